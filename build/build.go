@@ -1,6 +1,62 @@
 package build
 
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+)
+
 var (
 	Name    = "treefmt"    //nolint:gochecknoglobals
 	Version = "v0.0.1+dev" //nolint:gochecknoglobals
+	// Commit and Date are the git revision treefmt was built from and when it was committed. If they are not set with
+	// -ldflags, they are read from the version control information embedded by the go toolchain, if any.
+	Commit = "" //nolint:gochecknoglobals
+	Date   = "" //nolint:gochecknoglobals
 )
+
+// Info describes how treefmt was built.
+type Info struct {
+	Version string `json:"version"`
+	// Commit and Date are empty if they could not be determined.
+	Commit    string `json:"commit,omitempty"`
+	Date      string `json:"date,omitempty"`
+	GoVersion string `json:"goVersion"`
+	Platform  string `json:"platform"`
+}
+
+// ReadInfo returns the Info of the running binary.
+func ReadInfo() Info {
+	info := Info{
+		Version:   Version,
+		Commit:    Commit,
+		Date:      Date,
+		GoVersion: runtime.Version(),
+		Platform:  fmt.Sprintf("%s/%s", runtime.GOOS, runtime.GOARCH),
+	}
+
+	buildInfo, ok := debug.ReadBuildInfo()
+	if !ok {
+		return info
+	}
+
+	settings := make(map[string]string, len(buildInfo.Settings))
+	for _, setting := range buildInfo.Settings {
+		settings[setting.Key] = setting.Value
+	}
+
+	if info.Commit == "" && settings["vcs.revision"] != "" {
+		info.Commit = settings["vcs.revision"]
+
+		// the binary does not correspond to the commit it was built from
+		if settings["vcs.modified"] == "true" {
+			info.Commit += "-dirty"
+		}
+	}
+
+	if info.Date == "" {
+		info.Date = settings["vcs.time"]
+	}
+
+	return info
+}
@@ -0,0 +1,319 @@
+package bench
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/charmbracelet/log"
+	formatCmd "github.com/numtide/treefmt/v2/cmd/format"
+	"github.com/numtide/treefmt/v2/config"
+	"github.com/numtide/treefmt/v2/runner"
+	"github.com/numtide/treefmt/v2/stats"
+	"github.com/numtide/treefmt/v2/walk/cache"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// Result describes how long it took to format the tree over a number of runs, with a cold and a warm cache.
+type Result struct {
+	Runs int `json:"runs"`
+	// Files is the number of files traversed by each run.
+	Files int   `json:"files"`
+	Cold  Phase `json:"cold"`
+	Warm  Phase `json:"warm"`
+}
+
+// Phase summarises the runs made with either a cold or a warm cache.
+type Phase struct {
+	Elapsed Latency `json:"elapsed"`
+	// Throughput is the median number of files traversed per second spent walking the tree.
+	Throughput float64 `json:"throughput"`
+	// CacheHitRate is the fraction of matched files which the cache showed were already formatted.
+	CacheHitRate float64 `json:"cacheHitRate"`
+	// Formatters contains the time spent applying each formatter to the tree.
+	Formatters map[string]Latency `json:"formatters"`
+}
+
+// Latency is the distribution of a duration over the runs of a phase.
+type Latency struct {
+	P50 time.Duration `json:"p50"`
+	P95 time.Duration `json:"p95"`
+}
+
+func NewCommand(v *viper.Viper) *cobra.Command {
+	var runs int
+
+	cmd := &cobra.Command{
+		Use:   "bench",
+		Short: "Measure how long it takes to format the tree",
+		Long: "Format the tree a number of times, first with a cold cache and then with a warm one, reporting the " +
+			"median and 95th percentile of the time taken by each run and each formatter, along with the " +
+			"throughput of walking the tree and the rate of cache hits. Useful for quantifying the impact of " +
+			"changes to the config, or regressions between releases.\n\nThe runs use a temporary cache, leaving " +
+			"the evaluation cache of the tree untouched. Use --output json for output which is suitable for tooling.",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			cmd.SilenceUsage = true
+
+			return Run(v, runs)
+		},
+	}
+
+	cmd.Flags().IntVarP(&runs, "runs", "n", 5, "The number of runs to make with a cold and with a warm cache.")
+
+	return cmd
+}
+
+func Run(v *viper.Viper, runs int) error {
+	if runs < 1 {
+		return formatCmd.FailureUsage.Errorf("invalid runs: %d, must be at least 1", runs)
+	}
+
+	cfg, err := config.FromViper(v)
+	if err != nil {
+		return formatCmd.FailureConfig.Errorf("failed to load config: %w", err)
+	} else if cfg.Stdin || cfg.Watch {
+		return formatCmd.FailureUsage.Errorf("bench cannot be used with the --stdin or --watch flags")
+	}
+
+	output, err := formatCmd.OutputString(cfg.Output)
+	if err != nil {
+		return fmt.Errorf("invalid output format: %w", err)
+	}
+
+	// other processes must not format the tree whilst it is being measured
+	unlock, err := runner.Lock(cfg)
+	if err != nil {
+		return err
+	}
+
+	defer unlock()
+
+	// the runs use a cache of their own, so that the cold runs can be made without clearing the evaluation cache
+	cacheDir, err := os.MkdirTemp("", "treefmt-bench-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary cache directory: %w", err)
+	}
+
+	defer os.RemoveAll(cacheDir)
+
+	db, err := cache.Open(cacheDir, cfg.TreeRoot)
+	if err != nil {
+		return fmt.Errorf("failed to open cache: %w", err)
+	}
+
+	defer func() {
+		if closeErr := db.Close(); closeErr != nil {
+			log.Errorf("failed to close cache: %v", closeErr)
+		}
+	}()
+
+	// nor should the cold runs be warmed by a cache shared between machines
+	cfg.NoCache = false
+	cfg.CacheRemote = ""
+
+	r, err := runner.New(cfg, db)
+	if err != nil {
+		return formatCmd.FailureConfig.Wrap(err)
+	}
+
+	ctx := context.Background()
+
+	var cold, warm []*run
+
+	for i := range runs {
+		log.Infof("run %d of %d", i+1, runs)
+
+		if err = cache.Clear(db); err != nil {
+			return fmt.Errorf("failed to clear cache: %w", err)
+		}
+
+		measured, err := measure(ctx, r)
+		if err != nil {
+			return err
+		}
+
+		cold = append(cold, measured)
+
+		if measured, err = measure(ctx, r); err != nil {
+			return err
+		}
+
+		warm = append(warm, measured)
+	}
+
+	result := Result{
+		Runs:  runs,
+		Files: cold[0].summary.Counters[stats.Traversed],
+		Cold:  summarise(cold),
+		Warm:  summarise(warm),
+	}
+
+	if output == formatCmd.JSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+
+		if err = encoder.Encode(result); err != nil {
+			return fmt.Errorf("failed to encode result: %w", err)
+		}
+
+		return nil
+	}
+
+	printResult(&result)
+
+	return nil
+}
+
+// run records the outcome of formatting the tree once.
+type run struct {
+	summary stats.Summary
+	// walk is the time spent waiting for the tree to be traversed
+	walk time.Duration
+}
+
+// measure formats the tree once. Changes and unmatched files are not considered a failure, as they are an outcome of
+// the run rather than a problem with it.
+func measure(ctx context.Context, r *runner.Runner) (*run, error) {
+	statz := stats.New()
+
+	err := r.Format(ctx, &statz, nil)
+	if err != nil && !errors.Is(err, runner.ErrFailOnChange) && !errors.Is(err, runner.ErrUnmatched) {
+		return nil, err
+	}
+
+	return &run{summary: statz.Summary(), walk: statz.WalkDuration()}, nil
+}
+
+// summarise computes the distribution of the time taken by runs, and by each formatter applied during them.
+func summarise(runs []*run) Phase {
+	var (
+		elapsed    []time.Duration
+		throughput []float64
+		matched    int
+		cached     int
+	)
+
+	formatters := make(map[string][]time.Duration)
+
+	for _, r := range runs {
+		elapsed = append(elapsed, r.summary.Elapsed)
+
+		if seconds := r.walk.Seconds(); seconds > 0 {
+			throughput = append(throughput, float64(r.summary.Counters[stats.Traversed])/seconds)
+		}
+
+		matched += r.summary.Counters[stats.Matched]
+		cached += r.summary.Counters[stats.Cached]
+
+		for name, f := range r.summary.Formatters {
+			formatters[name] = append(formatters[name], f.Duration)
+		}
+	}
+
+	phase := Phase{
+		Elapsed:    latency(elapsed),
+		Formatters: make(map[string]Latency, len(formatters)),
+	}
+
+	if len(throughput) > 0 {
+		slices.Sort(throughput)
+		phase.Throughput = throughput[rank(len(throughput), 0.5)]
+	}
+
+	if matched > 0 {
+		phase.CacheHitRate = float64(cached) / float64(matched)
+	}
+
+	for name, durations := range formatters {
+		// a formatter which was not applied during a run, e.g. as every file was cached, took no time at all
+		for len(durations) < len(runs) {
+			durations = append(durations, 0)
+		}
+
+		phase.Formatters[name] = latency(durations)
+	}
+
+	return phase
+}
+
+// latency returns the median and 95th percentile of durations.
+func latency(durations []time.Duration) Latency {
+	slices.Sort(durations)
+
+	return Latency{
+		P50: durations[rank(len(durations), 0.5)],
+		P95: durations[rank(len(durations), 0.95)],
+	}
+}
+
+// rank returns the index of the given percentile within n sorted values, using the nearest-rank method.
+func rank(n int, percentile float64) int {
+	return max(int(math.Ceil(percentile*float64(n)))-1, 0)
+}
+
+func printResult(result *Result) {
+	fmt.Printf("made %d runs with a cold and a warm cache, traversing %d files each\n\n", result.Runs, result.Files)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintln(w, "cache\tp50\tp95\twalk throughput\tcache hit rate")
+
+	for _, phase := range []struct {
+		name string
+		*Phase
+	}{{"cold", &result.Cold}, {"warm", &result.Warm}} {
+		fmt.Fprintf(w, "%s\t%v\t%v\t%.0f files/s\t%.1f%%\n",
+			phase.name, round(phase.Elapsed.P50), round(phase.Elapsed.P95), phase.Throughput, 100*phase.CacheHitRate,
+		)
+	}
+
+	_ = w.Flush()
+
+	names := make([]string, 0, len(result.Cold.Formatters))
+	for name := range result.Cold.Formatters {
+		names = append(names, name)
+	}
+
+	if len(names) == 0 {
+		return
+	}
+
+	// slowest first, as they are the ones worth looking into
+	slices.SortFunc(names, func(a, b string) int {
+		if result := cmp.Compare(result.Cold.Formatters[b].P50, result.Cold.Formatters[a].P50); result != 0 {
+			return result
+		}
+
+		return strings.Compare(a, b)
+	})
+
+	fmt.Println()
+
+	fmt.Fprintln(w, "formatter\tcold p50\tcold p95\twarm p50\twarm p95")
+
+	for _, name := range names {
+		cold, warm := result.Cold.Formatters[name], result.Warm.Formatters[name]
+		fmt.Fprintf(w, "%s\t%v\t%v\t%v\t%v\n", name, round(cold.P50), round(cold.P95), round(warm.P50), round(warm.P95))
+	}
+
+	_ = w.Flush()
+}
+
+// round rounds a duration for display, keeping sub-millisecond durations legible.
+func round(d time.Duration) time.Duration {
+	if d < time.Millisecond {
+		return d.Round(time.Microsecond)
+	}
+
+	return d.Round(time.Millisecond)
+}
@@ -0,0 +1,147 @@
+package cache
+
+import (
+	"fmt"
+
+	"github.com/charmbracelet/log"
+	"github.com/numtide/treefmt/v2/config"
+	"github.com/numtide/treefmt/v2/runner"
+	"github.com/numtide/treefmt/v2/walk/cache"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	bolt "go.etcd.io/bbolt"
+)
+
+func NewCommand(v *viper.Viper) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "cache",
+		Short: "Inspect and manage the evaluation cache",
+		Long: "Inspect and manage the evaluation cache, which records the files that have already been formatted " +
+			"so they can be skipped on subsequent runs. Each tree root has a cache of its own.",
+		Args: cobra.NoArgs,
+	}
+
+	cmd.AddCommand(
+		&cobra.Command{
+			Use:   "info",
+			Short: "Print the location, number of entries and size of the cache",
+			Args:  cobra.NoArgs,
+			RunE: func(cmd *cobra.Command, _ []string) error {
+				return withCache(v, cmd, printInfo)
+			},
+		},
+		&cobra.Command{
+			Use:   "clear",
+			Short: "Remove every entry from the cache",
+			Args:  cobra.NoArgs,
+			RunE: func(cmd *cobra.Command, _ []string) error {
+				return withCache(v, cmd, clearCache)
+			},
+		},
+		&cobra.Command{
+			Use:   "prune",
+			Short: "Remove entries for files which no longer exist, or formatters which are no longer configured",
+			Args:  cobra.NoArgs,
+			RunE: func(cmd *cobra.Command, _ []string) error {
+				return withCache(v, cmd, pruneCache)
+			},
+		},
+		&cobra.Command{
+			Use:   "export <file>",
+			Short: "Write a portable snapshot of the cache to a file",
+			Long: "Write a portable snapshot of the cache to a file, which can be imported on another machine. " +
+				"Entries are keyed by the hash of each file's content rather than its mod time, allowing the cache " +
+				"to be restored in a fresh checkout, e.g. between CI runs.",
+			Args: cobra.ExactArgs(1),
+			RunE: func(cmd *cobra.Command, args []string) error {
+				return withCache(v, cmd, func(cfg *config.Config, db *bolt.DB) error {
+					return exportCache(cfg, db, args[0])
+				})
+			},
+		},
+		&cobra.Command{
+			Use:   "import <file>",
+			Short: "Restore cache entries from a snapshot written by export",
+			Long: "Restore cache entries from a snapshot written by export. Entries are only restored for files whose " +
+				"content is unchanged since the snapshot was taken.",
+			Args: cobra.ExactArgs(1),
+			RunE: func(cmd *cobra.Command, args []string) error {
+				return withCache(v, cmd, func(cfg *config.Config, db *bolt.DB) error {
+					return importCache(cfg, db, args[0])
+				})
+			},
+		},
+	)
+
+	return cmd
+}
+
+// withCache opens the cache for the configured tree root, passing it to fn before closing it again.
+func withCache(v *viper.Viper, cmd *cobra.Command, fn func(*config.Config, *bolt.DB) error) error {
+	cmd.SilenceUsage = true
+
+	cfg, err := config.FromViper(v)
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+
+	// wait for any formatting to complete before modifying the cache
+	unlock, err := runner.Lock(cfg)
+	if err != nil {
+		return err
+	}
+
+	defer unlock()
+
+	db, err := cache.Open(cfg.CacheDir, cfg.TreeRoot)
+	if err != nil {
+		return fmt.Errorf("failed to open cache: %w", err)
+	}
+
+	defer func() {
+		if closeErr := db.Close(); closeErr != nil {
+			log.Errorf("failed to close cache: %v", closeErr)
+		}
+	}()
+
+	return fn(cfg, db)
+}
+
+func printInfo(_ *config.Config, db *bolt.DB) error {
+	info, err := cache.Stat(db)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("location: %s\n", info.Path)
+	fmt.Printf("entries:  %d\n", info.Entries)
+	fmt.Printf("size:     %d bytes\n", info.Size)
+
+	return nil
+}
+
+func clearCache(_ *config.Config, db *bolt.DB) error {
+	if err := cache.Clear(db); err != nil {
+		return fmt.Errorf("failed to clear cache: %w", err)
+	}
+
+	fmt.Println("cleared the cache")
+
+	return nil
+}
+
+func pruneCache(cfg *config.Config, db *bolt.DB) error {
+	formatters := make([]string, 0, len(cfg.FormatterConfigs))
+	for name := range cfg.FormatterConfigs {
+		formatters = append(formatters, name)
+	}
+
+	pruned, err := cache.Prune(db, cfg.TreeRoot, formatters)
+	if err != nil {
+		return fmt.Errorf("failed to prune cache: %w", err)
+	}
+
+	fmt.Printf("pruned %d entries from the cache\n", pruned)
+
+	return nil
+}
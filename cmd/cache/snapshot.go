@@ -0,0 +1,239 @@
+package cache
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/numtide/treefmt/v2/config"
+	"github.com/numtide/treefmt/v2/format"
+	"github.com/numtide/treefmt/v2/stats"
+	"github.com/numtide/treefmt/v2/walk"
+	"github.com/numtide/treefmt/v2/walk/cache"
+	bolt "go.etcd.io/bbolt"
+)
+
+const snapshotVersion = 2
+
+// snapshot is a portable copy of the cache.
+// The cache records the size and mod time of each file when it was formatted, neither of which survive being checked
+// out on a different machine, so entries in a snapshot are keyed by the hash of each file's content instead.
+// Likewise, the signature of each entry's formatters is the portable one used with a remote cache, which doesn't
+// depend on the size and mod time of their executables.
+type snapshot struct {
+	Version int             `json:"version"`
+	Entries []snapshotEntry `json:"entries"`
+}
+
+type snapshotEntry struct {
+	Path       string   `json:"path"`
+	Hash       string   `json:"hash"`
+	Formatters []string `json:"formatters"`
+	Signature  []byte   `json:"signature"`
+}
+
+// hashFile returns the hash of the content of the file at path, along with its info.
+// A nil info is returned if the file does not exist or is not a regular file.
+func hashFile(path string) (string, fs.FileInfo, error) {
+	info, err := os.Stat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", nil, nil
+	} else if err != nil {
+		return "", nil, fmt.Errorf("failed to stat %s: %w", path, err)
+	} else if !info.Mode().IsRegular() {
+		return "", nil, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err = io.Copy(h, f); err != nil {
+		return "", nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), info, nil
+}
+
+// newSigner creates a composite formatter with which the signatures of the formatters recorded in cache entries are
+// calculated.
+func newSigner(cfg *config.Config) (*format.CompositeFormatter, error) {
+	// formatters whose command is missing cannot be signed, but shouldn't prevent the rest from being
+	signerCfg := *cfg
+	signerCfg.AllowMissingFormatter = true
+	signerCfg.Check = false
+	signerCfg.CacheRemote = ""
+	// the formatters' versions are needed for their signatures
+	signerCfg.NoCache = false
+
+	statz := stats.New()
+
+	composite, err := format.NewCompositeFormatter(&signerCfg, &statz, walk.BatchSize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create composite formatter: %w", err)
+	}
+
+	return composite, nil
+}
+
+// exportCache writes a snapshot of the cache to the file at path.
+// Only entries for files which have not changed since they were formatted, with formatters which have not changed
+// since either, are included.
+func exportCache(cfg *config.Config, db *bolt.DB, path string) error {
+	signer, err := newSigner(cfg)
+	if err != nil {
+		return err
+	}
+
+	snap := snapshot{
+		Version: snapshotVersion,
+		Entries: []snapshotEntry{},
+	}
+
+	err = db.View(func(tx *bolt.Tx) error {
+		entries := cache.EntriesBucket(tx)
+
+		return cache.PathsBucket(tx).ForEach(func(k, v []byte) error {
+			relPath := string(k)
+
+			entry, err := cache.GetEntry(entries, relPath)
+			if err != nil || entry == nil {
+				// entries written by older versions cannot be exported
+				return err
+			}
+
+			hash, info, err := hashFile(filepath.Join(cfg.TreeRoot, relPath))
+			if err != nil || info == nil {
+				return err
+			}
+
+			// skip files which have changed since they were formatted
+			file := &walk.File{Info: info}
+			if signature, err := file.FormatSignature(entry.Signature); err != nil {
+				return fmt.Errorf("failed to calculate signature for path %s: %w", relPath, err)
+			} else if !bytes.Equal(signature, v) {
+				return nil
+			}
+
+			// skip files whose formatters have changed since they were formatted
+			local, portable, err := signer.Signatures(relPath, entry.Formatters)
+			if err != nil {
+				return fmt.Errorf("failed to calculate formatters signature for path %s: %w", relPath, err)
+			} else if !bytes.Equal(local, entry.Signature) {
+				return nil
+			}
+
+			snap.Entries = append(snap.Entries, snapshotEntry{
+				Path:       relPath,
+				Hash:       hash,
+				Formatters: entry.Formatters,
+				Signature:  portable,
+			})
+
+			return nil
+		})
+	})
+	if err != nil {
+		return fmt.Errorf("failed to read cache: %w", err)
+	}
+
+	data, err := json.Marshal(snap)
+	if err != nil {
+		return fmt.Errorf("failed to marshal snapshot: %w", err)
+	}
+
+	if err = os.WriteFile(path, data, 0o600); err != nil {
+		return fmt.Errorf("failed to write snapshot: %w", err)
+	}
+
+	fmt.Printf("exported %d entries to %s\n", len(snap.Entries), path)
+
+	return nil
+}
+
+// importCache reads a snapshot from the file at path, adding an entry to the cache for each file whose content, and
+// the config and versions of whose formatters, are unchanged since the snapshot was exported.
+func importCache(cfg *config.Config, db *bolt.DB, path string) error {
+	signer, err := newSigner(cfg)
+	if err != nil {
+		return err
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read snapshot: %w", err)
+	}
+
+	var snap snapshot
+	if err = json.Unmarshal(data, &snap); err != nil {
+		return fmt.Errorf("failed to unmarshal snapshot: %w", err)
+	} else if snap.Version != snapshotVersion {
+		return fmt.Errorf("unsupported snapshot version %d, expected %d", snap.Version, snapshotVersion)
+	}
+
+	var imported int
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		paths := cache.PathsBucket(tx)
+		entries := cache.EntriesBucket(tx)
+
+		for _, entry := range snap.Entries {
+			hash, info, err := hashFile(filepath.Join(cfg.TreeRoot, entry.Path))
+			if err != nil {
+				return err
+			} else if info == nil || hash != entry.Hash {
+				// the file is missing or its content differs from when it was exported
+				continue
+			}
+
+			local, portable, err := signer.Signatures(entry.Path, entry.Formatters)
+			if err != nil {
+				return fmt.Errorf("failed to calculate formatters signature for path %s: %w", entry.Path, err)
+			} else if !bytes.Equal(portable, entry.Signature) {
+				// the formatters differ from those the file was formatted with
+				continue
+			}
+
+			// generate a signature from the local file info
+			file := &walk.File{Info: info}
+
+			signature, err := file.FormatSignature(local)
+			if err != nil {
+				return fmt.Errorf("failed to calculate signature for path %s: %w", entry.Path, err)
+			}
+
+			if err = paths.Put([]byte(entry.Path), signature); err != nil {
+				return fmt.Errorf("failed to put format signature for path %s: %w", entry.Path, err)
+			}
+
+			err = cache.PutEntry(entries, entry.Path, &cache.Entry{
+				Formatters: entry.Formatters,
+				Signature:  local,
+			})
+			if err != nil {
+				return err
+			}
+
+			imported++
+		}
+
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to update cache: %w", err)
+	}
+
+	fmt.Printf("imported %d of %d entries from %s\n", imported, len(snap.Entries), path)
+
+	return nil
+}
@@ -0,0 +1,184 @@
+package cmd
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+
+	"github.com/numtide/treefmt/v2/config"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// completionFunc completes the value of a flag or argument, see cobra.Command.ValidArgsFunction.
+type completionFunc func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective)
+
+// registerCompletions completes the values of flags which refer to the contents of the config file, such as
+// --formatters, from the config file itself, and the paths given as arguments from the git index.
+func registerCompletions(v *viper.Viper, cmd *cobra.Command) {
+	formatterNames := func(formatters map[string]*config.Formatter) []string {
+		names := make([]string, 0, len(formatters))
+		for name := range formatters {
+			names = append(names, name)
+		}
+
+		return names
+	}
+
+	formatterTags := func(formatters map[string]*config.Formatter) []string {
+		var tags []string
+		for _, formatter := range formatters {
+			tags = append(tags, formatter.Tags...)
+		}
+
+		return tags
+	}
+
+	for name, fn := range map[string]completionFunc{
+		"formatters":      completeList(v, formatterNames),
+		"skip-formatters": completeList(v, formatterNames),
+		"tags":            completeList(v, formatterTags),
+		"skip-tags":       completeList(v, formatterTags),
+		"profile": func(cmd *cobra.Command, _ []string, _ string) ([]string, cobra.ShellCompDirective) {
+			if err := readCompletionConfig(v, cmd); err != nil {
+				return nil, cobra.ShellCompDirectiveError
+			}
+
+			profiles := make([]string, 0)
+			for name := range v.GetStringMap("profiles") {
+				profiles = append(profiles, name)
+			}
+
+			slices.Sort(profiles)
+
+			return profiles, cobra.ShellCompDirectiveNoFileComp
+		},
+	} {
+		cobra.CheckErr(cmd.RegisterFlagCompletionFunc(name, fn))
+	}
+
+	// paths are given when formatting, as well as when explaining how they would be formatted
+	cmd.ValidArgsFunction = completePaths(v)
+
+	if explain, _, err := cmd.Find([]string{"explain"}); err == nil && explain != cmd {
+		explain.ValidArgsFunction = completePaths(v)
+	}
+}
+
+// completeList completes a comma separated list of the values returned by values for the formatters in the config
+// file, omitting any which have already been given.
+func completeList(
+	v *viper.Viper,
+	values func(formatters map[string]*config.Formatter) []string,
+) completionFunc {
+	return func(cmd *cobra.Command, _ []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		if err := readCompletionConfig(v, cmd); err != nil {
+			return nil, cobra.ShellCompDirectiveError
+		}
+
+		var formatters map[string]*config.Formatter
+		if err := v.UnmarshalKey("formatter", &formatters); err != nil {
+			return nil, cobra.ShellCompDirectiveError
+		}
+
+		// the values before the last comma are complete, so we only complete the last one
+		prefix := ""
+		if idx := strings.LastIndexByte(toComplete, ','); idx >= 0 {
+			prefix = toComplete[:idx+1]
+		}
+
+		given := strings.Split(prefix, ",")
+
+		var completions []string
+
+		for _, value := range values(formatters) {
+			if !slices.Contains(given, value) && !slices.Contains(completions, prefix+value) {
+				completions = append(completions, prefix+value)
+			}
+		}
+
+		slices.Sort(completions)
+
+		return completions, cobra.ShellCompDirectiveNoFileComp
+	}
+}
+
+// completePaths completes the paths of files which are tracked, or untracked but not ignored, by git, offering the
+// directories beneath the working directory one level at a time. Outside a git repository, completion is left to the
+// shell.
+func completePaths(v *viper.Viper) completionFunc {
+	return func(_ *cobra.Command, _ []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		if err := changeWorkingDir(v); err != nil {
+			return nil, cobra.ShellCompDirectiveError
+		}
+
+		// paths are listed relative to the working directory
+		out, err := exec.Command("git", "ls-files", "-z", "--cached", "--others", "--exclude-standard").Output()
+		if err != nil {
+			return nil, cobra.ShellCompDirectiveDefault
+		}
+
+		var completions []string
+
+		seen := make(map[string]bool)
+		directive := cobra.ShellCompDirectiveNoFileComp
+
+		for _, entry := range bytes.Split(out, []byte{0}) {
+			path := string(entry)
+			if path == "" || !strings.HasPrefix(path, toComplete) {
+				continue
+			}
+
+			// offer the next directory rather than every file beneath it, allowing it to be completed further
+			if idx := strings.IndexByte(path[len(toComplete):], '/'); idx >= 0 {
+				path = path[:len(toComplete)+idx+1]
+				directive |= cobra.ShellCompDirectiveNoSpace
+			}
+
+			if !seen[path] {
+				seen[path] = true
+				completions = append(completions, path)
+			}
+		}
+
+		// e.g. paths outside the working directory
+		if len(completions) == 0 {
+			return nil, cobra.ShellCompDirectiveDefault
+		}
+
+		return completions, directive
+	}
+}
+
+// readCompletionConfig reads the config file into v, without configuring logging or reporting anything, so the values
+// of flags can be completed from it.
+func readCompletionConfig(v *viper.Viper, cmd *cobra.Command) error {
+	if err := changeWorkingDir(v); err != nil {
+		return err
+	}
+
+	workingDir, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+
+	configFile, err := cmd.Flags().GetString("config-file")
+	if err != nil {
+		return err
+	}
+
+	if configFile, err = config.Locate(configFile, workingDir); err != nil {
+		return err
+	}
+
+	v.SetConfigFile(configFile)
+	v.SetConfigType(config.FileType(configFile))
+
+	if err = v.ReadInConfig(); err != nil {
+		return err
+	}
+
+	return config.ResolveImports(v)
+}
@@ -0,0 +1,132 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/numtide/treefmt/v2/config"
+	"github.com/spf13/cobra"
+)
+
+// ErrInvalidConfig is returned when validation finds problems with the config file.
+var ErrInvalidConfig = errors.New("config file is invalid")
+
+func NewCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "config",
+		Short: "Inspect and validate the config file",
+		Args:  cobra.NoArgs,
+	}
+
+	cmd.AddCommand(
+		&cobra.Command{
+			Use:   "schema",
+			Short: "Print a JSON Schema describing the config file",
+			Long: "Print a JSON Schema describing the config file, which can be used by editors and other tools to " +
+				"validate and complete treefmt.toml.",
+			Args: cobra.NoArgs,
+			RunE: func(_ *cobra.Command, _ []string) error {
+				encoder := json.NewEncoder(os.Stdout)
+				encoder.SetIndent("", "  ")
+
+				if err := encoder.Encode(config.Schema()); err != nil {
+					return fmt.Errorf("failed to encode schema: %w", err)
+				}
+
+				return nil
+			},
+		},
+		&cobra.Command{
+			Use:   "presets",
+			Short: "List the built-in formatter presets",
+			Long: "List the built-in formatter presets, which can be selected with the preset key of a formatter " +
+				"instead of specifying its command, options and includes.",
+			Args: cobra.NoArgs,
+			Run: func(_ *cobra.Command, _ []string) {
+				printPresets()
+			},
+		},
+		&cobra.Command{
+			Use:   "validate [file]",
+			Short: "Strictly validate the config file, reporting unknown keys and values of the wrong type",
+			Long: "Strictly validate the config file, reporting syntax errors, values of the wrong type and unknown " +
+				"keys, which are otherwise ignored. Validates the config file which would be used for formatting, " +
+				"unless a file is given.",
+			Args: cobra.MaximumNArgs(1),
+			RunE: func(cmd *cobra.Command, args []string) error {
+				cmd.SilenceUsage = true
+
+				return validate(cmd, args)
+			},
+		},
+	)
+
+	return cmd
+}
+
+func validate(cmd *cobra.Command, args []string) error {
+	var path string
+
+	if len(args) > 0 {
+		path = args[0]
+	} else {
+		configFile, err := cmd.Flags().GetString("config-file")
+		if err != nil {
+			return fmt.Errorf("failed to read config-file flag: %w", err)
+		}
+
+		workingDir, err := os.Getwd()
+		if err != nil {
+			return fmt.Errorf("failed to get working directory: %w", err)
+		}
+
+		if path, err = config.Locate(configFile, workingDir); err != nil {
+			return fmt.Errorf("failed to find treefmt config file: %w", err)
+		}
+	}
+
+	problems, err := config.Validate(path)
+	if err != nil {
+		return err
+	}
+
+	if len(problems) == 0 {
+		fmt.Printf("%s is valid\n", path)
+
+		return nil
+	}
+
+	for _, problem := range problems {
+		switch {
+		case problem.Line > 0 && problem.Column > 0:
+			fmt.Printf("%s:%d:%d: %s\n", path, problem.Line, problem.Column, problem.Message)
+		case problem.Line > 0:
+			fmt.Printf("%s:%d: %s\n", path, problem.Line, problem.Message)
+		default:
+			fmt.Printf("%s: %s\n", path, problem.Message)
+		}
+	}
+
+	return fmt.Errorf("%w: %d problem(s) found", ErrInvalidConfig, len(problems))
+}
+
+func printPresets() {
+	for i, name := range config.PresetNames() {
+		if i > 0 {
+			fmt.Println()
+		}
+
+		preset := config.Presets[name]
+
+		fmt.Println(name)
+		fmt.Printf("  command:      %s\n", strings.Join(append([]string{preset.Command}, preset.Options...), " "))
+		fmt.Printf("  includes:     %s\n", strings.Join(preset.Includes, " "))
+
+		if len(preset.Interpreters) > 0 {
+			fmt.Printf("  interpreters: %s\n", strings.Join(preset.Interpreters, " "))
+		}
+	}
+}
@@ -0,0 +1,282 @@
+package daemon
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"syscall"
+
+	"github.com/adrg/xdg"
+	"github.com/charmbracelet/log"
+	"github.com/numtide/treefmt/v2/config"
+	"github.com/numtide/treefmt/v2/runner"
+	"github.com/numtide/treefmt/v2/stats"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// Request is sent by a client to the daemon, one JSON object per line.
+type Request struct {
+	// Paths is a list of files or directories to format, either absolute or relative to the tree root.
+	// If empty, the entire tree is formatted.
+	Paths []string `json:"paths"`
+}
+
+// Response is sent by the daemon for each Request it receives, one JSON object per line.
+type Response struct {
+	// Summary contains the stats for formatting the requested paths.
+	Summary *stats.Summary `json:"summary,omitempty"`
+	// Error is set if formatting failed, or unexpected changes were detected with --fail-on-change.
+	Error string `json:"error,omitempty"`
+}
+
+func NewCommand(v *viper.Viper) *cobra.Command {
+	var socket string
+
+	cmd := &cobra.Command{
+		Use:   "daemon",
+		Short: "Serve format requests over a unix socket",
+		Long: "Load the config once and serve format requests over a unix socket, avoiding the startup cost of " +
+			"running treefmt for each request.\n\nClients send newline-delimited JSON requests of the form " +
+			`{"paths": ["a.go", "b/"]}` + " and receive a JSON response for each, containing a summary of the " +
+			"formatting and any error which occurred. An empty list of paths formats the entire tree.",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			return Run(v, cmd, socket)
+		},
+	}
+
+	cmd.Flags().StringVar(
+		&socket, "socket", "",
+		"The path of the unix socket to listen on (defaults to a socket in $XDG_RUNTIME_DIR/treefmt/daemon, or "+
+			"the user's cache directory if that cannot be used, which is unique to the tree root).",
+	)
+
+	return cmd
+}
+
+func Run(v *viper.Viper, cmd *cobra.Command, socket string) error {
+	cmd.SilenceUsage = true
+
+	cfg, err := config.FromViper(v)
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	} else if cfg.Stdin {
+		return fmt.Errorf("the daemon cannot be used with the --stdin flag")
+	}
+
+	// the lock is only held, and the cache only opened, whilst handling a request, so the tree can still be formatted
+	// by other treefmt processes in between
+	r, err := runner.NewShared(cfg)
+	if err != nil {
+		return err
+	}
+
+	// create the formatters up front, rather than on the first request, validating their config
+	if err = r.LoadFormatters(); err != nil {
+		return err
+	}
+
+	if socket == "" {
+		if socket, err = defaultSocket(cfg.TreeRoot); err != nil {
+			return err
+		}
+	}
+
+	listener, err := listen(socket)
+	if err != nil {
+		return err
+	}
+
+	// create an overall app context, which is cancelled when we receive a shutdown signal or the command is cancelled
+	ctx, cancel := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	// stop accepting connections once we have been cancelled
+	go func() {
+		<-ctx.Done()
+
+		if err := listener.Close(); err != nil {
+			log.Errorf("failed to close listener: %v", err)
+		}
+	}()
+
+	if err = r.ServeMetrics(ctx); err != nil {
+		return err
+	}
+
+	log.Infof("listening on %s", socket)
+
+	s := server{
+		cfg:    cfg,
+		runner: r,
+		lock:   &sync.Mutex{},
+	}
+
+	var wg sync.WaitGroup
+
+	for {
+		conn, err := listener.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			break
+		} else if err != nil {
+			return fmt.Errorf("failed to accept connection: %w", err)
+		}
+
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+			s.handle(ctx, conn)
+		}()
+	}
+
+	// wait for any in-flight requests to complete
+	wg.Wait()
+
+	return nil
+}
+
+// defaultSocket returns a socket path which is unique to the tree root, within XDG_RUNTIME_DIR or, if that cannot
+// be used, e.g. because it is not set and the shared fallback belongs to another user, the user's cache directory.
+func defaultSocket(treeRoot string) (string, error) {
+	digest := sha256.Sum256([]byte(treeRoot))
+	// socket paths are limited to around 100 bytes, so we only use half of the digest
+	name := hex.EncodeToString(digest[:16])
+
+	path, err := xdg.RuntimeFile(fmt.Sprintf("treefmt/daemon/%s.sock", name))
+	if err == nil {
+		return path, nil
+	}
+
+	log.Debugf("falling back to the cache directory for the socket: %v", err)
+
+	if path, err = xdg.CacheFile(fmt.Sprintf("treefmt/daemon/%s.sock", name)); err != nil {
+		return "", fmt.Errorf("could not resolve local path for the socket: %w", err)
+	}
+
+	return path, nil
+}
+
+// listen creates a unix socket listener at path, removing any stale socket left behind by a previous daemon.
+func listen(path string) (net.Listener, error) {
+	if _, err := os.Stat(path); err == nil {
+		// check if there is another daemon listening
+		if conn, err := net.Dial("unix", path); err == nil {
+			_ = conn.Close()
+
+			return nil, fmt.Errorf("another daemon is already listening on %s", path)
+		}
+
+		if err = os.Remove(path); err != nil {
+			return nil, fmt.Errorf("failed to remove stale socket %s: %w", path, err)
+		}
+	}
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", path, err)
+	}
+
+	return listener, nil
+}
+
+type server struct {
+	cfg    *config.Config
+	runner *runner.Runner
+
+	// lock ensures only one request is processed at a time, preventing formatters from racing on the same files
+	lock *sync.Mutex
+}
+
+// handle processes requests from a connection until it is closed.
+func (s *server) handle(ctx context.Context, conn net.Conn) {
+	defer func() {
+		if err := conn.Close(); err != nil {
+			log.Errorf("failed to close connection: %v", err)
+		}
+	}()
+
+	// ensure we don't block waiting for requests once we have been cancelled
+	stop := context.AfterFunc(ctx, func() {
+		_ = conn.Close()
+	})
+	defer stop()
+
+	scanner := bufio.NewScanner(conn)
+	encoder := json.NewEncoder(conn)
+
+	for scanner.Scan() {
+		var (
+			req Request
+			res Response
+		)
+
+		if err := json.Unmarshal(scanner.Bytes(), &req); err != nil {
+			res.Error = fmt.Sprintf("failed to decode request: %v", err)
+		} else {
+			res = s.format(ctx, req)
+		}
+
+		if err := encoder.Encode(res); err != nil {
+			log.Errorf("failed to write response: %v", err)
+
+			return
+		}
+	}
+}
+
+// format applies the formatters to the requested paths.
+func (s *server) format(ctx context.Context, req Request) Response {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	paths := make([]string, len(req.Paths))
+
+	for i, path := range req.Paths {
+		relPath, err := s.relativePath(path)
+		if err != nil {
+			return Response{Error: err.Error()}
+		}
+
+		paths[i] = relPath
+	}
+
+	log.Debugf("formatting paths: %v", paths)
+
+	statz := stats.New()
+	err := s.runner.Format(ctx, &statz, paths)
+
+	summary := statz.Summary()
+	res := Response{Summary: &summary}
+
+	if err != nil {
+		res.Error = err.Error()
+	}
+
+	return res
+}
+
+// relativePath converts path into a path relative to the tree root, ensuring it exists.
+func (s *server) relativePath(path string) (string, error) {
+	relPath, err := runner.RelativePath(s.cfg.TreeRoot, path)
+	if err != nil {
+		return "", err
+	}
+
+	path = filepath.Join(s.cfg.TreeRoot, relPath)
+	if _, err = os.Stat(path); err != nil {
+		return "", fmt.Errorf("path %s not found", path)
+	}
+
+	return relPath, nil
+}
@@ -0,0 +1,272 @@
+package docs
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/numtide/treefmt/v2/build"
+	"github.com/numtide/treefmt/v2/config"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+func NewCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "docs",
+		Short: "Generate man pages and reference documentation",
+		Long: "Generate man pages and reference documentation for the command line and the config file, from the " +
+			"commands and flags of treefmt and the entries of the config file, so they never fall out of date.",
+		Args: cobra.NoArgs,
+	}
+
+	cmd.AddCommand(
+		&cobra.Command{
+			Use:   "man <dir>",
+			Short: "Write a man page for each command, and one for the config file, to the given directory",
+			Long: "Write a man page for each command to the given directory, e.g. treefmt.1 and treefmt-cache.1, " +
+				"as well as treefmt.toml.5 describing the config file.",
+			Args: cobra.ExactArgs(1),
+			RunE: func(cmd *cobra.Command, args []string) error {
+				cmd.SilenceUsage = true
+
+				return generate(cmd.Root(), args[0], writeManPages, writeConfigManPage)
+			},
+		},
+		&cobra.Command{
+			Use:   "markdown <dir>",
+			Short: "Write a reference for the command line and the config file to the given directory",
+			Long: "Write a reference for the command line to cli.md, and a reference for the config file to " +
+				"config.md, within the given directory.",
+			Args: cobra.ExactArgs(1),
+			RunE: func(cmd *cobra.Command, args []string) error {
+				cmd.SilenceUsage = true
+
+				return generate(cmd.Root(), args[0], writeMarkdown, writeConfigMarkdown)
+			},
+		},
+	)
+
+	return cmd
+}
+
+// generate writes the documentation for the command tree beneath root to dir, using writeCommands, followed by the
+// reference for the config file, using writeConfig.
+func generate(
+	root *cobra.Command,
+	dir string,
+	writeCommands func(dir string, cmds []*cobra.Command) error,
+	writeConfig func(dir string, sections []section) error,
+) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("failed to create %s: %w", dir, err)
+	}
+
+	if err := writeCommands(dir, commands(root)); err != nil {
+		return err
+	}
+
+	return writeConfig(dir, configSections())
+}
+
+// commands returns cmd followed by each of the commands beneath it which are shown in help, depth first.
+func commands(cmd *cobra.Command) []*cobra.Command {
+	// the help flags are otherwise only added when a command is executed
+	cmd.InitDefaultHelpFlag()
+
+	result := []*cobra.Command{cmd}
+
+	for _, sub := range cmd.Commands() {
+		if sub.IsAvailableCommand() {
+			result = append(result, commands(sub)...)
+		}
+	}
+
+	return result
+}
+
+// writeFile creates the file at path, writing its content with fn.
+func writeFile(path string, fn func(w io.Writer)) error {
+	var sb strings.Builder
+
+	fn(&sb)
+
+	if err := os.WriteFile(path, []byte(sb.String()), 0o644); err != nil { //nolint:gosec
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+
+	return nil
+}
+
+// pageName returns the name of the page documenting cmd, e.g. treefmt-cache-info.
+func pageName(cmd *cobra.Command) string {
+	return strings.ReplaceAll(cmd.CommandPath(), " ", "-")
+}
+
+// description returns the long description of cmd, falling back to its short description.
+func description(cmd *cobra.Command) string {
+	if cmd.Long != "" {
+		return cmd.Long
+	}
+
+	return cmd.Short
+}
+
+// visibleFlags returns the flags within fs which are not hidden, in the order they are listed in help.
+func visibleFlags(fs *pflag.FlagSet) []*pflag.Flag {
+	var flags []*pflag.Flag
+
+	fs.VisitAll(func(flag *pflag.Flag) {
+		if !flag.Hidden {
+			flags = append(flags, flag)
+		}
+	})
+
+	return flags
+}
+
+// flagDefault returns the default value of flag, or an empty string if it defaults to the zero value of its type.
+func flagDefault(flag *pflag.Flag) string {
+	switch flag.DefValue {
+	case "", "false", "0", "[]", "0s":
+		return ""
+	}
+
+	if flag.Value.Type() == "string" {
+		return fmt.Sprintf("%q", flag.DefValue)
+	}
+
+	return flag.DefValue
+}
+
+// configTitle returns the name of the config file, which titles its reference.
+func configTitle() string {
+	return build.Name + ".toml"
+}
+
+// configDescription introduces the config file reference.
+func configDescription() string {
+	return "The config file is searched for upwards from the working directory, and may also be named " +
+		build.Name + ".yaml, " + build.Name + ".yml or " + build.Name + ".json, optionally prefixed with a '.'. " +
+		"Formatters are configured in tables named after them, e.g. [formatter.gofmt]."
+}
+
+// section is a table of the config file, such as the global options or those of each formatter.
+type section struct {
+	// name is the name of the table, which is empty for the global options, e.g. formatter.<name>
+	name        string
+	description string
+	entries     []entry
+}
+
+// entry is a key within a section of the config file.
+type entry struct {
+	key         string
+	kind        string
+	description string
+	// values are the values which are allowed, if they are restricted
+	values []string
+}
+
+// configSections describes the config file from its schema, which is derived from the config struct tags, returning
+// the global options followed by each table in order of its name.
+func configSections() []section {
+	schema := config.Schema()
+
+	global := section{
+		description: "Options at the top level of the config file, most of which can also be set with flags or " +
+			"environment variables.",
+	}
+
+	var sections []section
+
+	properties, _ := schema["properties"].(map[string]any)
+
+	for _, key := range sortedKeys(properties) {
+		property, _ := properties[key].(map[string]any)
+		description, _ := property["description"].(string)
+
+		// tables are either a fixed set of keys, or a map of names to a fixed set of keys, such as the formatters
+		if nested, ok := property["properties"].(map[string]any); ok {
+			sections = append(sections, section{
+				name:        key,
+				description: description,
+				entries:     configEntries(nested),
+			})
+		} else if values, ok := property["additionalProperties"].(map[string]any); ok && values["properties"] != nil {
+			nested, _ := values["properties"].(map[string]any)
+			sections = append(sections, section{
+				name:        key + ".<name>",
+				description: description,
+				entries:     configEntries(nested),
+			})
+		} else {
+			global.entries = append(global.entries, configEntry(key, property))
+		}
+	}
+
+	return append([]section{global}, sections...)
+}
+
+func configEntries(properties map[string]any) []entry {
+	entries := make([]entry, 0, len(properties))
+
+	for _, key := range sortedKeys(properties) {
+		property, _ := properties[key].(map[string]any)
+		entries = append(entries, configEntry(key, property))
+	}
+
+	return entries
+}
+
+func configEntry(key string, property map[string]any) entry {
+	description, _ := property["description"].(string)
+
+	values, _ := property["enum"].([]string)
+	if items, ok := property["items"].(map[string]any); ok && values == nil {
+		values, _ = items["enum"].([]string)
+	}
+
+	return entry{
+		key:         key,
+		kind:        kindOf(property),
+		description: description,
+		values:      values,
+	}
+}
+
+// kindOf describes the type of the entry with the given schema, using the terms of TOML.
+func kindOf(property map[string]any) string {
+	switch kind, _ := property["type"].(string); kind {
+	case "array":
+		items, _ := property["items"].(map[string]any)
+
+		return "array of " + kindOf(items) + "s"
+	case "object":
+		return "table"
+	default:
+		return kind
+	}
+}
+
+func sortedKeys(m map[string]any) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+
+	slices.Sort(keys)
+
+	return keys
+}
+
+// sectionTitle returns the heading of s, as the table would be written in the config file.
+func sectionTitle(s section) string {
+	if s.name == "" {
+		return "Global options"
+	}
+
+	return "[" + s.name + "]"
+}
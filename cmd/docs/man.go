@@ -0,0 +1,146 @@
+package docs
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/numtide/treefmt/v2/build"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+// writeManPages writes a page in section 1 for each of cmds, named after its command path, e.g. treefmt-cache.1.
+func writeManPages(dir string, cmds []*cobra.Command) error {
+	for _, cmd := range cmds {
+		path := filepath.Join(dir, pageName(cmd)+".1")
+		if err := writeFile(path, func(w io.Writer) { writeManPage(w, cmd) }); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func writeManPage(w io.Writer, cmd *cobra.Command) {
+	writeManHeader(w, pageName(cmd), 1)
+
+	_, _ = fmt.Fprintf(w, ".SH NAME\n%s \\- %s\n", roff(pageName(cmd)), roff(cmd.Short))
+	_, _ = fmt.Fprintf(w, ".SH SYNOPSIS\n\\fB%s\\fR\n", roff(cmd.UseLine()))
+	_, _ = fmt.Fprintf(w, ".SH DESCRIPTION\n%s\n", roff(description(cmd)))
+
+	var subs []*cobra.Command
+
+	for _, sub := range cmd.Commands() {
+		if sub.IsAvailableCommand() {
+			subs = append(subs, sub)
+		}
+	}
+
+	if len(subs) > 0 {
+		_, _ = fmt.Fprintln(w, ".SH COMMANDS")
+
+		for _, sub := range subs {
+			_, _ = fmt.Fprintf(w, ".TP\n\\fB%s\\fR\n%s\n", roff(sub.Name()), roff(sub.Short))
+		}
+	}
+
+	if flags := visibleFlags(cmd.NonInheritedFlags()); len(flags) > 0 {
+		_, _ = fmt.Fprintln(w, ".SH OPTIONS")
+		writeManFlags(w, flags)
+	}
+
+	if cmd.HasAvailableInheritedFlags() {
+		_, _ = fmt.Fprintf(w, ".SH GLOBAL OPTIONS\nAccepts the options of \\fB%s\\fR(1).\n", roff(cmd.Root().Name()))
+	}
+
+	seeAlso := make([]string, 0, len(subs)+2)
+	if cmd.HasParent() {
+		seeAlso = append(seeAlso, pageName(cmd.Parent()))
+	}
+
+	for _, sub := range subs {
+		seeAlso = append(seeAlso, pageName(sub))
+	}
+
+	for i, name := range seeAlso {
+		seeAlso[i] = fmt.Sprintf("\\fB%s\\fR(1)", roff(name))
+	}
+
+	if !cmd.HasParent() {
+		seeAlso = append(seeAlso, fmt.Sprintf("\\fB%s\\fR(5)", roff(configTitle())))
+	}
+
+	_, _ = fmt.Fprintf(w, ".SH SEE ALSO\n%s\n", strings.Join(seeAlso, ", "))
+}
+
+func writeManFlags(w io.Writer, flags []*pflag.Flag) {
+	for _, flag := range flags {
+		name := "\\fB\\-\\-" + roff(flag.Name) + "\\fR"
+		if flag.Shorthand != "" {
+			name = "\\fB\\-" + roff(flag.Shorthand) + "\\fR, " + name
+		}
+
+		varname, usage := pflag.UnquoteUsage(flag)
+		if varname != "" {
+			name += "=\\fI" + roff(varname) + "\\fR"
+		}
+
+		if def := flagDefault(flag); def != "" {
+			usage += fmt.Sprintf(" (default %s)", def)
+		}
+
+		_, _ = fmt.Fprintf(w, ".TP\n%s\n%s\n", name, roff(usage))
+	}
+}
+
+// writeConfigManPage writes a page in section 5 describing each entry of the config file.
+func writeConfigManPage(dir string, sections []section) error {
+	return writeFile(filepath.Join(dir, configTitle()+".5"), func(w io.Writer) {
+		writeManHeader(w, configTitle(), 5)
+
+		_, _ = fmt.Fprintf(w, ".SH NAME\n%s \\- configuration file for \\fB%s\\fR(1)\n", roff(configTitle()),
+			roff(build.Name))
+		_, _ = fmt.Fprintf(w, ".SH DESCRIPTION\n%s\n", roff(configDescription()))
+
+		for _, s := range sections {
+			_, _ = fmt.Fprintf(w, ".SH %s\n", roff(strings.ToUpper(sectionTitle(s))))
+
+			if s.description != "" {
+				_, _ = fmt.Fprintf(w, "%s\n", roff(s.description))
+			}
+
+			for _, e := range s.entries {
+				_, _ = fmt.Fprintf(w, ".TP\n\\fB%s\\fR (%s)\n%s\n", roff(e.key), roff(e.kind), roff(e.description))
+
+				if len(e.values) > 0 {
+					_, _ = fmt.Fprintf(w, ".br\nOne of: %s.\n", roff(strings.Join(e.values, ", ")))
+				}
+			}
+		}
+
+		_, _ = fmt.Fprintf(w, ".SH SEE ALSO\n\\fB%s\\fR(1)\n", roff(build.Name))
+	})
+}
+
+// writeManHeader writes the title of a page, omitting the date so the output is reproducible.
+func writeManHeader(w io.Writer, name string, section int) {
+	_, _ = fmt.Fprintf(w, ".TH \"%s\" \"%d\" \"\" \"%s %s\" \"%s Manual\"\n",
+		roff(strings.ToUpper(name)), section, build.Name, roff(build.Version), build.Name)
+	_, _ = fmt.Fprintln(w, ".nh\n.ad l")
+}
+
+// roff escapes text for use within a man page, preventing lines from being interpreted as requests.
+func roff(text string) string {
+	text = strings.NewReplacer(`\`, `\e`, "-", `\-`).Replace(text)
+
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		if strings.HasPrefix(line, ".") || strings.HasPrefix(line, "'") {
+			lines[i] = `\&` + line
+		}
+	}
+
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,78 @@
+package docs
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// writeMarkdown writes a reference for each of cmds to cli.md, with a heading for each command.
+func writeMarkdown(dir string, cmds []*cobra.Command) error {
+	return writeFile(filepath.Join(dir, "cli.md"), func(w io.Writer) {
+		_, _ = fmt.Fprintln(w, "# Command line reference")
+
+		for _, cmd := range cmds {
+			writeMarkdownCommand(w, cmd)
+		}
+	})
+}
+
+func writeMarkdownCommand(w io.Writer, cmd *cobra.Command) {
+	_, _ = fmt.Fprintf(w, "\n## `%s`\n\n%s\n\n```\n%s\n```\n", cmd.CommandPath(), description(cmd), cmd.UseLine())
+
+	var subs []string
+
+	for _, sub := range cmd.Commands() {
+		if sub.IsAvailableCommand() {
+			subs = append(subs, fmt.Sprintf("- [`%s`](#%s): %s", sub.Name(), pageName(sub), sub.Short))
+		}
+	}
+
+	if len(subs) > 0 {
+		_, _ = fmt.Fprintf(w, "\n### Commands\n\n%s\n", strings.Join(subs, "\n"))
+	}
+
+	if cmd.HasAvailableLocalFlags() {
+		_, _ = fmt.Fprintf(w, "\n### Options\n\n```\n%s```\n", cmd.NonInheritedFlags().FlagUsages())
+	}
+
+	if cmd.HasAvailableInheritedFlags() {
+		root := cmd.Root()
+		_, _ = fmt.Fprintf(w, "\nAlso accepts the options of [`%s`](#%s).\n", root.Name(), pageName(root))
+	}
+}
+
+// writeConfigMarkdown writes a reference for the config file to config.md, with a heading for each entry.
+func writeConfigMarkdown(dir string, sections []section) error {
+	return writeFile(filepath.Join(dir, "config.md"), func(w io.Writer) {
+		_, _ = fmt.Fprintf(w, "# Config file reference\n\n%s\n", configDescription())
+
+		for _, s := range sections {
+			_, _ = fmt.Fprintf(w, "\n## %s\n", markdownTitle(s))
+
+			if s.description != "" {
+				_, _ = fmt.Fprintf(w, "\n%s\n", s.description)
+			}
+
+			for _, e := range s.entries {
+				_, _ = fmt.Fprintf(w, "\n### `%s`\n\n%s\n\nType: %s\n", e.key, e.description, e.kind)
+
+				if len(e.values) > 0 {
+					_, _ = fmt.Fprintf(w, "\nOne of: `%s`\n", strings.Join(e.values, "`, `"))
+				}
+			}
+		}
+	})
+}
+
+// markdownTitle returns the heading of s, quoting the name of a table as code.
+func markdownTitle(s section) string {
+	if s.name == "" {
+		return sectionTitle(s)
+	}
+
+	return "`" + sectionTitle(s) + "`"
+}
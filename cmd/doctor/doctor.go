@@ -0,0 +1,370 @@
+package doctor
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/numtide/treefmt/v2/config"
+	"github.com/numtide/treefmt/v2/format"
+	"github.com/numtide/treefmt/v2/stats"
+	"github.com/numtide/treefmt/v2/walk"
+	"github.com/numtide/treefmt/v2/walk/cache"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// ErrProblemsFound is returned when doctor finds at least one problem which would prevent treefmt from working.
+var ErrProblemsFound = errors.New("problems were found")
+
+func NewCommand(v *viper.Viper) *cobra.Command {
+	return &cobra.Command{
+		Use:   "doctor",
+		Short: "Diagnose problems with the config, formatters, tree and cache",
+		Long: "Diagnose problems with the config, formatters, tree and cache. " +
+			"Checks the config is valid, each formatter's command can be found, every file in the tree can be read, " +
+			"whether any formatters overlap and that the cache is healthy. " +
+			"Exits with an error if any problems are found, whilst warnings are only reported.",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			cmd.SilenceUsage = true
+
+			return Run(v)
+		},
+	}
+}
+
+// report prints the outcome of each check, keeping count of the problems and warnings.
+type report struct {
+	problems int
+	warnings int
+}
+
+func (r *report) ok(format string, args ...any) {
+	fmt.Printf("[ok]    "+format+"\n", args...)
+}
+
+func (r *report) warn(format string, args ...any) {
+	r.warnings++
+
+	fmt.Printf("[warn]  "+format+"\n", args...)
+}
+
+func (r *report) problem(format string, args ...any) {
+	r.problems++
+
+	fmt.Printf("[error] "+format+"\n", args...)
+}
+
+func Run(v *viper.Viper) error {
+	r := &report{}
+
+	if err := diagnose(v, r); err != nil {
+		return err
+	}
+
+	fmt.Printf("\n%d problem(s), %d warning(s)\n", r.problems, r.warnings)
+
+	if r.problems > 0 {
+		return ErrProblemsFound
+	}
+
+	return nil
+}
+
+func diagnose(v *viper.Viper, r *report) error {
+	cfg, err := config.FromViper(v)
+	if err != nil {
+		r.problem("config: %v", err)
+
+		return nil
+	}
+
+	r.ok("config: loaded %s", v.ConfigFileUsed())
+
+	if len(cfg.FormatterConfigs) == 0 {
+		r.warn("config: no formatters are configured")
+	}
+
+	// a missing formatter is reported below, rather than preventing every other check from running
+	checkCfg := *cfg
+	checkCfg.AllowMissingFormatter = true
+	// these only affect how formatters are applied, and would otherwise create a sandbox or remote cache client
+	checkCfg.Check = false
+	checkCfg.CacheRemote = ""
+
+	statz := stats.New()
+
+	composite, err := format.NewCompositeFormatter(&checkCfg, &statz, walk.BatchSize)
+	if err != nil {
+		r.problem("config: %v", err)
+
+		return nil
+	}
+
+	formatters := checkFormatters(cfg, composite, r)
+
+	if err = checkTree(cfg, composite, formatters, r); err != nil {
+		return err
+	}
+
+	checkCache(cfg, r)
+
+	return nil
+}
+
+// checkFormatters reports whether the command for each formatter could be found, returning those which are available.
+func checkFormatters(cfg *config.Config, composite *format.CompositeFormatter, r *report) []*format.Formatter {
+	available := composite.Formatters()
+
+	names := make([]string, 0, len(cfg.FormatterConfigs))
+	for name := range cfg.FormatterConfigs {
+		names = append(names, name)
+	}
+
+	slices.Sort(names)
+
+	for _, name := range names {
+		idx := slices.IndexFunc(available, func(f *format.Formatter) bool {
+			return f.Name() == name
+		})
+
+		switch {
+		case idx >= 0:
+			r.ok("formatter %s: %s", name, available[idx].Executable())
+		case cfg.AllowMissingFormatter:
+			r.warn("formatter %s: command '%s' not found, it will be skipped", name, cfg.FormatterConfigs[name].Command)
+		default:
+			r.problem("formatter %s: command '%s' not found", name, cfg.FormatterConfigs[name].Command)
+		}
+	}
+
+	return available
+}
+
+// checkTree traverses the tree, reporting any files which cannot be read, formatters which match no files, and files
+// matched by several formatters whose order is only determined by their names.
+func checkTree(
+	cfg *config.Config,
+	composite *format.CompositeFormatter,
+	formatters []*format.Formatter,
+	r *report,
+) error {
+	walkType, err := walk.ParseType(cfg.Walk)
+	if err != nil {
+		r.problem("walk: invalid walk type: %v", err)
+
+		return nil
+	} else if walkType == walk.Stdin {
+		return nil
+	}
+
+	symlinks, err := walk.SymlinksString(cfg.Symlinks)
+	if err != nil {
+		r.problem("symlinks: %v", err)
+
+		return nil
+	}
+
+	statz := stats.New()
+
+	// the cache is not used, so that every file in the tree is inspected
+	reader, err := walk.NewReader(walkType, cfg.TreeRoot, "", "", symlinks, nil, &statz)
+	if err != nil {
+		r.problem("walk: %v", err)
+
+		return nil
+	}
+
+	defer func() {
+		if err := reader.Close(); err != nil {
+			log.Errorf("failed to close reader: %v", err)
+		}
+	}()
+
+	var (
+		traversed  int
+		unreadable []string
+		// the first nested config file which could not be applied, as the reader must be drained regardless
+		nestedErr error
+		matched   = make(map[string]int)
+		// files matched by several formatters with the same priority, keyed by the sequence they are applied in
+		ambiguous = make(map[string]int)
+	)
+
+	files := make([]*walk.File, walk.BatchSize)
+
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		n, readErr := reader.Read(ctx, files)
+
+		cancel()
+
+		for _, file := range files[:n] {
+			traversed++
+
+			if f, err := os.Open(file.Path); err != nil {
+				unreadable = append(unreadable, file.RelPath)
+
+				continue
+			} else if err = f.Close(); err != nil {
+				return fmt.Errorf("failed to close %s: %w", file.Path, err)
+			}
+
+			matches, err := composite.Match(file)
+			if err != nil {
+				nestedErr = cmp.Or(nestedErr, err)
+
+				continue
+			}
+
+			for i, f := range matches {
+				matched[f.Name()]++
+
+				if i > 0 && matches[i-1].Priority() == f.Priority() {
+					ambiguous[sequence(matches)]++
+
+					break
+				}
+			}
+		}
+
+		if errors.Is(readErr, io.EOF) {
+			break
+		} else if readErr != nil {
+			r.problem("walk: failed to traverse %s: %v", cfg.TreeRoot, readErr)
+
+			return nil
+		}
+	}
+
+	if nestedErr != nil {
+		r.problem("config: %v", nestedErr)
+	}
+
+	if len(unreadable) == 0 {
+		r.ok("tree: traversed %d files in %s", traversed, cfg.TreeRoot)
+	} else {
+		slices.Sort(unreadable)
+		r.problem("tree: %d file(s) cannot be read: %s", len(unreadable), strings.Join(unreadable, ", "))
+	}
+
+	for _, f := range formatters {
+		if matched[f.Name()] == 0 {
+			r.warn("formatter %s: does not match any files, check its includes and excludes", f.Name())
+		}
+	}
+
+	sequences := make([]string, 0, len(ambiguous))
+	for seq := range ambiguous {
+		sequences = append(sequences, seq)
+	}
+
+	slices.Sort(sequences)
+
+	for _, seq := range sequences {
+		r.warn(
+			"formatters %s overlap on %d file(s) and share a priority, so are applied in name order, "+
+				"set their priority to make the order explicit",
+			seq, ambiguous[seq],
+		)
+	}
+
+	return nil
+}
+
+// sequence describes the order in which formatters are applied.
+func sequence(formatters []*format.Formatter) string {
+	names := make([]string, len(formatters))
+	for i, f := range formatters {
+		names[i] = f.Name()
+	}
+
+	return strings.Join(names, ", ")
+}
+
+// checkCache reports on the health of the cache, and whether the remote cache, if configured, can be reached.
+func checkCache(cfg *config.Config, r *report) {
+	checkLocalCache(cfg, r)
+
+	if cfg.CacheRemote == "" {
+		return
+	}
+
+	backend, err := cache.NewBackend(cfg.CacheRemote)
+	if err != nil {
+		r.problem("remote cache: %v", err)
+
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// any key will do, we are only interested in whether the request succeeds
+	if _, err = backend.Has(ctx, cache.Key(nil, nil)); err != nil {
+		r.warn("remote cache: %v", err)
+	} else {
+		r.ok("remote cache: %s", cfg.CacheRemote)
+	}
+}
+
+func checkLocalCache(cfg *config.Config, r *report) {
+	if cfg.NoCache {
+		r.ok("cache: disabled")
+
+		return
+	}
+
+	// opening the cache would block whilst another treefmt process is using it
+	unlock, err := cache.Lock(cfg.TreeRoot, 0)
+	if errors.Is(err, cache.ErrLocked) {
+		r.warn("cache: in use by another treefmt process, skipping")
+
+		return
+	} else if err != nil {
+		// formatting carries on without the lock in this case, so we do too
+		r.warn("lock: %v", err)
+	} else {
+		defer func() {
+			if err := unlock(); err != nil {
+				log.Errorf("failed to release lock: %v", err)
+			}
+		}()
+	}
+
+	db, err := cache.Open(cfg.CacheDir, cfg.TreeRoot)
+	if err != nil {
+		r.problem("cache: failed to open: %v, try removing it", err)
+
+		return
+	}
+
+	defer func() {
+		if err := db.Close(); err != nil {
+			log.Errorf("failed to close cache: %v", err)
+		}
+	}()
+
+	if err = cache.Check(db); err != nil {
+		r.problem("cache: %s is corrupt, remove it to start afresh: %v", db.Path(), err)
+
+		return
+	}
+
+	info, err := cache.Stat(db)
+	if err != nil {
+		r.problem("cache: %v", err)
+
+		return
+	}
+
+	r.ok("cache: %d entries in %s", info.Entries, info.Path)
+}
@@ -0,0 +1,75 @@
+package cmd
+
+import (
+	"encoding/json"
+	"os"
+
+	formatCmd "github.com/numtide/treefmt/v2/cmd/format"
+	"github.com/spf13/cobra"
+)
+
+// errorReport is printed to stderr when treefmt fails with --error-format json.
+type errorReport struct {
+	Error    string            `json:"error"`
+	Class    formatCmd.Failure `json:"class"`
+	ExitCode int               `json:"exitCode"`
+}
+
+// Execute runs root, printing any error to stderr according to --error-format, and returns the exit code for its
+// class of failure.
+func Execute(root *cobra.Command) int {
+	// errors are printed below instead, in the requested format
+	root.SilenceErrors = true
+
+	err := root.Execute()
+	if err == nil {
+		return 0
+	}
+
+	failure := formatCmd.Classify(err)
+
+	if errorFormat(root) == formatCmd.ErrorFormatJSON {
+		encoder := json.NewEncoder(root.ErrOrStderr())
+		_ = encoder.Encode(errorReport{Error: err.Error(), Class: failure, ExitCode: failure.ExitCode()})
+	} else {
+		root.PrintErrln(root.ErrPrefix(), err.Error())
+	}
+
+	return failure.ExitCode()
+}
+
+// errorFormat returns the value of --error-format, falling back to $TREEFMT_ERROR_FORMAT in the same way as viper, as
+// the config may not have been loaded if root failed early on.
+func errorFormat(root *cobra.Command) string {
+	flag := root.PersistentFlags().Lookup("error-format")
+	if flag.Changed {
+		return flag.Value.String()
+	}
+
+	if value, ok := os.LookupEnv("TREEFMT_ERROR_FORMAT"); ok {
+		return value
+	}
+
+	return flag.DefValue
+}
+
+// markUsageErrors marks the errors returned when parsing the flags or validating the args of cmd, and of each of its
+// subcommands, as usage errors.
+func markUsageErrors(cmd *cobra.Command) {
+	// subcommands inherit the flag error func of their parent
+	if !cmd.HasParent() {
+		cmd.SetFlagErrorFunc(func(_ *cobra.Command, err error) error {
+			return formatCmd.FailureUsage.Wrap(err)
+		})
+	}
+
+	if args := cmd.Args; args != nil {
+		cmd.Args = func(cmd *cobra.Command, values []string) error {
+			return formatCmd.FailureUsage.Wrap(args(cmd, values))
+		}
+	}
+
+	for _, sub := range cmd.Commands() {
+		markUsageErrors(sub)
+	}
+}
@@ -0,0 +1,352 @@
+package explain
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/numtide/treefmt/v2/config"
+	"github.com/numtide/treefmt/v2/format"
+	"github.com/numtide/treefmt/v2/runner"
+	"github.com/numtide/treefmt/v2/stats"
+	"github.com/numtide/treefmt/v2/walk"
+	"github.com/numtide/treefmt/v2/walk/cache"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	bolt "go.etcd.io/bbolt"
+)
+
+func NewCommand(v *viper.Viper) *cobra.Command {
+	return &cobra.Command{
+		Use:   "explain <paths...>",
+		Short: "Explain how treefmt decides whether, and how, to format the given paths",
+		Long: "Explain how treefmt decides whether, and how, to format the given paths. " +
+			"For each path, shows whether it is included by the walker, excluded globally, which formatters want it " +
+			"and why, the order in which they will be applied, and whether it will be skipped due to the cache.",
+		Args: cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cmd.SilenceUsage = true
+
+			return Run(v, args)
+		},
+	}
+}
+
+func Run(v *viper.Viper, paths []string) error {
+	cfg, err := config.FromViper(v)
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+
+	walkType, err := walk.ParseType(cfg.Walk)
+	if err != nil {
+		return fmt.Errorf("invalid walk type: %w", err)
+	} else if walkType == walk.Stdin {
+		return fmt.Errorf("the stdin walk type cannot be explained, as there are no paths to walk")
+	}
+
+	// formatters whose command is missing are reported, rather than preventing an explanation
+	explainCfg := *cfg
+	explainCfg.AllowMissingFormatter = true
+	explainCfg.Check = false
+	explainCfg.CacheRemote = ""
+
+	statz := stats.New()
+
+	composite, err := format.NewCompositeFormatter(&explainCfg, &statz, walk.BatchSize)
+	if err != nil {
+		return fmt.Errorf("failed to create composite formatter: %w", err)
+	}
+
+	var db *bolt.DB
+
+	if !cfg.NoCache {
+		// opening the cache would block whilst another treefmt process is using it
+		unlock, err := cache.Lock(cfg.TreeRoot, 0)
+		if errors.Is(err, cache.ErrLocked) {
+			log.Warn("another treefmt process holds the lock for this tree root, the cache status is unknown")
+		} else {
+			if err != nil {
+				log.Warnf("continuing without locking the tree root: %v", err)
+			} else {
+				defer func() {
+					if err := unlock(); err != nil {
+						log.Errorf("failed to release lock: %v", err)
+					}
+				}()
+			}
+
+			if db, err = cache.Open(cfg.CacheDir, cfg.TreeRoot); err != nil {
+				return fmt.Errorf("failed to open cache: %w", err)
+			}
+
+			defer func() {
+				if err := db.Close(); err != nil {
+					log.Errorf("failed to close cache: %v", err)
+				}
+			}()
+		}
+	}
+
+	for i, path := range paths {
+		if i > 0 {
+			fmt.Println()
+		}
+
+		if err = explain(cfg, walkType, composite, db, path); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func explain(
+	cfg *config.Config,
+	walkType walk.Type,
+	composite *format.CompositeFormatter,
+	db *bolt.DB,
+	path string,
+) error {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return fmt.Errorf("error computing absolute path of %s: %w", path, err)
+	}
+
+	relPath, err := runner.RelativePath(cfg.TreeRoot, absPath)
+	if err != nil {
+		return fmt.Errorf("path %s not inside the tree root %s", path, cfg.TreeRoot)
+	}
+
+	info, err := os.Lstat(absPath)
+	if err != nil {
+		return fmt.Errorf("path %s not found", path)
+	} else if info.IsDir() {
+		return fmt.Errorf("path %s is a directory, only files can be explained", path)
+	}
+
+	fmt.Println(relPath)
+
+	// symlinks are only included when following them, rather than failing the explanation when they are an error
+	symlinks := walk.SymlinksSkip
+	if cfg.Symlinks == walk.SymlinksFollow.String() {
+		symlinks = walk.SymlinksFollow
+	}
+
+	// walker
+	included, err := walked(walkType, symlinks, cfg.TreeRoot, relPath)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case info.Mode()&os.ModeSymlink != 0 && !included:
+		fmt.Println("  walker:     not included, symlinks are only formatted with --symlinks follow")
+	case included:
+		fmt.Printf("  walker:     included by the %s walker\n", walkType.Name())
+	default:
+		fmt.Printf(
+			"  walker:     not included by the %s walker, it may be ignored or untracked, "+
+				"unless it is passed as a path\n", walkType.Name(),
+		)
+	}
+
+	file := &walk.File{
+		Path:    absPath,
+		RelPath: relPath,
+		Info:    info,
+	}
+
+	explanation, err := composite.Explain(file)
+	if err != nil {
+		return fmt.Errorf("failed to explain %s: %w", relPath, err)
+	}
+
+	// nested config file
+	if explanation.Scope.Dir != "." {
+		configFile, err := filepath.Rel(cfg.TreeRoot, explanation.Scope.ConfigFile)
+		if err != nil {
+			return fmt.Errorf("error computing relative path of %s: %w", explanation.Scope.ConfigFile, err)
+		}
+
+		fmt.Printf("  config:     %s\n", configFile)
+	}
+
+	// global excludes and size limit
+	if explanation.ExcludedBy != "" {
+		fmt.Printf("  excludes:   excluded by '%s'\n", explanation.ExcludedBy)
+	} else {
+		fmt.Println("  excludes:   not excluded")
+	}
+
+	if explanation.TooLarge {
+		fmt.Printf("  size:       exceeds the max-file-size of %s\n", cfg.MaxFileSize)
+	}
+
+	// formatters, including those whose command is missing
+	formatterConfigs := explanation.Scope.FormatterConfigs
+
+	names := make([]string, 0, len(formatterConfigs))
+	for name := range formatterConfigs {
+		names = append(names, name)
+	}
+
+	slices.Sort(names)
+
+	width := 0
+	for _, name := range names {
+		width = max(width, len(name))
+	}
+
+	for _, d := range explanation.Decisions {
+		width = max(width, len(d.Formatter))
+	}
+
+	fmt.Println("  formatters:")
+
+	for _, name := range names {
+		// formatters configured by a nested config file are named after its directory, e.g. go@services/api
+		idx := slices.IndexFunc(explanation.Decisions, func(d *format.Decision) bool {
+			return d.Formatter == name || strings.HasPrefix(d.Formatter, name+"@")
+		})
+
+		reason := fmt.Sprintf("command '%s' not found", formatterConfigs[name].Command)
+		if idx >= 0 {
+			name, reason = explanation.Decisions[idx].Formatter, explanation.Decisions[idx].Reason
+		}
+
+		fmt.Printf("    %-*s %s\n", width+1, name+":", reason)
+	}
+
+	// pipeline order
+	sequence := make([]string, len(explanation.Sequence))
+	for i, f := range explanation.Sequence {
+		sequence[i] = fmt.Sprintf("%s[%d]", f.Name(), f.Priority())
+	}
+
+	switch {
+	case explanation.ExcludedBy != "":
+		fmt.Println("  sequence:   none, the file is excluded")
+	case explanation.TooLarge:
+		fmt.Println("  sequence:   none, the file is too large")
+	case len(sequence) == 0:
+		fmt.Printf("  sequence:   none, no formatter wants the file (on-unmatched is %s)\n", cfg.OnUnmatched)
+	default:
+		fmt.Printf("  sequence:   %s\n", strings.Join(sequence, " -> "))
+	}
+
+	// cache
+	if len(sequence) > 0 {
+		status, err := cacheStatus(cfg, db, file, explanation.Signature)
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("  cache:      %s\n", status)
+	}
+
+	return nil
+}
+
+// walked determines whether the walker includes relPath when traversing the tree.
+// Paths which are passed explicitly are always included, so we traverse the top-level directory containing relPath
+// instead, ensuring any of its parent directories which are ignored are taken into account. A file in the tree root
+// has no such directory, so the whole tree is traversed.
+func walked(walkType walk.Type, symlinks walk.Symlinks, root string, relPath string) (bool, error) {
+	statz := stats.New()
+
+	topLevel, _, nested := strings.Cut(relPath, string(filepath.Separator))
+	if !nested {
+		topLevel = ""
+	}
+
+	reader, err := walk.NewReader(walkType, root, topLevel, "", symlinks, nil, &statz)
+	if err != nil {
+		return false, fmt.Errorf("failed to create walker: %w", err)
+	}
+
+	defer func() {
+		if err := reader.Close(); err != nil {
+			log.Errorf("failed to close reader: %v", err)
+		}
+	}()
+
+	var found bool
+
+	files := make([]*walk.File, walk.BatchSize)
+
+	// the reader must be exhausted before it can be closed
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		n, err := reader.Read(ctx, files)
+
+		cancel()
+
+		for _, file := range files[:n] {
+			found = found || file.RelPath == relPath
+		}
+
+		if errors.Is(err, io.EOF) {
+			return found, nil
+		} else if err != nil {
+			return false, fmt.Errorf("failed to read files: %w", err)
+		}
+	}
+}
+
+// cacheStatus describes whether file will be skipped, based on the format signature recorded in the cache.
+func cacheStatus(cfg *config.Config, db *bolt.DB, file *walk.File, formattersSig []byte) (string, error) {
+	switch {
+	case cfg.NoCache:
+		return "disabled, the file will be formatted", nil
+	case db == nil:
+		return "unknown, another treefmt process is using the cache", nil
+	case cfg.ClearCache:
+		return "will be cleared, the file will be formatted", nil
+	}
+
+	var (
+		cached []byte
+		entry  *cache.Entry
+	)
+
+	err := db.View(func(tx *bolt.Tx) error {
+		cached = bytes.Clone(cache.PathsBucket(tx).Get([]byte(cache.PathKey(file.RelPath))))
+
+		var err error
+
+		entry, err = cache.GetEntry(cache.EntriesBucket(tx), cache.PathKey(file.RelPath))
+
+		return err
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to read cache: %w", err)
+	}
+
+	signature, err := file.FormatSignature(formattersSig)
+	if err != nil {
+		return "", fmt.Errorf("failed to calculate file signature: %w", err)
+	}
+
+	switch {
+	case cached == nil:
+		return "not cached, the file will be formatted", nil
+	case bytes.Equal(cached, signature):
+		return "up to date, the file will be skipped", nil
+	case entry != nil:
+		return fmt.Sprintf(
+			"stale, the file or its formatters have changed since it was formatted by %s",
+			strings.Join(entry.Formatters, ", "),
+		), nil
+	default:
+		return "stale, the file or its formatters have changed since it was last formatted", nil
+	}
+}
@@ -0,0 +1,75 @@
+package format
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/muesli/termenv"
+)
+
+const (
+	// ColorAuto uses color when writing to a terminal, honouring NO_COLOR and CLICOLOR_FORCE.
+	ColorAuto = "auto"
+	// ColorAlways uses color regardless of where output is written to, e.g. when piping to a pager.
+	ColorAlways = "always"
+	// ColorNever disables color.
+	ColorNever = "never"
+)
+
+// ColorProfile resolves the configured color mode into the color profile to use when writing to file.
+func ColorProfile(mode string, file *os.File) (termenv.Profile, error) {
+	switch mode {
+	case ColorAuto:
+		return termenv.NewOutput(file).EnvColorProfile(), nil
+	case ColorAlways:
+		// use the capabilities of the terminal if we can determine them, falling back to basic colors otherwise
+		if profile := termenv.NewOutput(file, termenv.WithTTY(true)).ColorProfile(); profile != termenv.Ascii {
+			return profile, nil
+		}
+
+		return termenv.ANSI, nil
+	case ColorNever:
+		return termenv.Ascii, nil
+	default:
+		return termenv.Ascii, fmt.Errorf(
+			"invalid color: %s, must be one of <%s|%s|%s>", mode, ColorAuto, ColorAlways, ColorNever,
+		)
+	}
+}
+
+// colorDiff styles the lines of a unified diff according to profile: headers in bold, hunk ranges in cyan, removals
+// in red and additions in green. With the Ascii profile, the diff is returned unchanged.
+func colorDiff(profile termenv.Profile, diff string) string {
+	if profile == termenv.Ascii {
+		return diff
+	}
+
+	lines := strings.SplitAfter(diff, "\n")
+
+	for i, line := range lines {
+		text := strings.TrimSuffix(line, "\n")
+		if text == "" {
+			continue
+		}
+
+		style := profile.String(text)
+
+		switch {
+		case strings.HasPrefix(text, "---"), strings.HasPrefix(text, "+++"), strings.HasPrefix(text, "diff "):
+			style = style.Bold()
+		case strings.HasPrefix(text, "@@"):
+			style = style.Foreground(profile.Color("6"))
+		case strings.HasPrefix(text, "-"):
+			style = style.Foreground(profile.Color("1"))
+		case strings.HasPrefix(text, "+"):
+			style = style.Foreground(profile.Color("2"))
+		default:
+			continue
+		}
+
+		lines[i] = style.String() + line[len(text):]
+	}
+
+	return strings.Join(lines, "")
+}
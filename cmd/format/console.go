@@ -0,0 +1,45 @@
+package format
+
+import (
+	"io"
+	"os"
+	"sync"
+)
+
+// Console is where log entries are written for display, which is stderr unless it has been redirected, e.g. to the log
+// pane of the dashboard whilst it is displayed. Entries which are also written to a log file are unaffected.
+var Console = &console{}
+
+type console struct {
+	lock sync.Mutex
+	// w is the writer entries are redirected to, or nil to write them to stderr
+	w io.Writer
+}
+
+func (c *console) Write(p []byte) (int, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.w != nil {
+		return c.w.Write(p)
+	}
+
+	// stderr is resolved on each write, as it is swapped out when running tests
+	return os.Stderr.Write(p)
+}
+
+// redirect writes entries to w instead, until the returned function is called.
+func (c *console) redirect(w io.Writer) func() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	previous := c.w
+	c.w = w
+
+	return func() {
+		c.lock.Lock()
+		defer c.lock.Unlock()
+
+		c.w = previous
+	}
+}
@@ -0,0 +1,599 @@
+package format
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/charmbracelet/log"
+	"github.com/muesli/reflow/truncate"
+	"github.com/muesli/termenv"
+	"github.com/numtide/treefmt/v2/stats"
+)
+
+const (
+	// dashboardInterval is how often the dashboard is refreshed whilst formatting
+	dashboardInterval = 100 * time.Millisecond
+	// dashboardLogLines is the number of lines of log output retained for the log pane
+	dashboardLogLines = 1000
+	// dashboardBarWidth is the width of the progress bar
+	dashboardBarWidth = 30
+)
+
+// dashboardStyles are used to render the dashboard, according to the color mode.
+type dashboardStyles struct {
+	title    lipgloss.Style
+	heading  lipgloss.Style
+	running  lipgloss.Style
+	failed   lipgloss.Style
+	finished lipgloss.Style
+	selected lipgloss.Style
+	hint     lipgloss.Style
+}
+
+func newDashboardStyles(renderer *lipgloss.Renderer) dashboardStyles {
+	return dashboardStyles{
+		title:    renderer.NewStyle().Bold(true),
+		heading:  renderer.NewStyle().Bold(true),
+		running:  renderer.NewStyle().Foreground(lipgloss.Color("3")),
+		failed:   renderer.NewStyle().Foreground(lipgloss.Color("1")),
+		finished: renderer.NewStyle().Foreground(lipgloss.Color("2")),
+		selected: renderer.NewStyle().Reverse(true),
+		hint:     renderer.NewStyle().Faint(true),
+	}
+}
+
+type (
+	// tickMsg refreshes the dashboard whilst formatting
+	tickMsg struct{}
+	// logMsg carries log output written whilst the dashboard is displayed
+	logMsg string
+	// finishedMsg is sent once formatting has finished, along with the error it finished with, if any
+	finishedMsg struct{ err error }
+)
+
+// pane identifies which part of the dashboard responds to scrolling once formatting has finished.
+type pane int
+
+const (
+	resultsPane pane = iota
+	logPane
+)
+
+// fileResult describes what happened to a file, for browsing once formatting has finished.
+type fileResult struct {
+	path   string
+	status string
+	// detail is displayed when the result is selected, e.g. the diff of a change or the output of a failed formatter
+	detail string
+	failed bool
+}
+
+// dashboard is a bubbletea model displaying the progress of each formatter and the log output whilst formatting, and
+// the result for each file once formatting has finished.
+type dashboard struct {
+	statz  *stats.Stats
+	cancel context.CancelFunc
+	styles dashboardStyles
+
+	width  int
+	height int
+
+	logs []string
+	// logScroll is how many lines the log pane has been scrolled back from the most recent entry
+	logScroll int
+
+	cancelling bool
+	finished   bool
+	err        error
+	elapsed    time.Duration
+
+	results []fileResult
+	cursor  int
+	// detail indicates the selected result is being displayed in full, scrolled down by detailScroll lines
+	detail       bool
+	detailScroll int
+	focus        pane
+}
+
+// logWriter sends log output to the dashboard for display in the log pane.
+type logWriter struct {
+	program *tea.Program
+}
+
+func (w *logWriter) Write(p []byte) (int, error) {
+	// p may be reused once we return, so it is copied by the conversion
+	w.program.Send(logMsg(p))
+
+	return len(p), nil
+}
+
+// startDashboard takes over the terminal, displaying the progress of each formatter along with the log output whilst
+// formatting, styled according to color. Pressing ctrl+c calls cancel to stop formatting.
+// The returned function, which must be called once formatting has finished with formatErr, displays the result for
+// each file and returns once the user has quit the dashboard, at which point the terminal is restored.
+func startDashboard(statz *stats.Stats, cancel context.CancelFunc, color termenv.Profile) func(formatErr error) {
+	output := termenv.NewOutput(os.Stdout, termenv.WithProfile(color))
+
+	model := &dashboard{
+		statz:  statz,
+		cancel: cancel,
+		styles: newDashboardStyles(lipgloss.NewRenderer(os.Stdout, termenv.WithProfile(color))),
+	}
+
+	// the output is passed as is, otherwise the terminal is queried for its colors, which we have no use for
+	program := tea.NewProgram(model, tea.WithAltScreen(), tea.WithInput(os.Stdin), tea.WithOutput(output))
+
+	// log output would otherwise be written over the dashboard
+	restoreConsole := Console.redirect(&logWriter{program: program})
+
+	done := make(chan error, 1)
+
+	go func() {
+		_, err := program.Run()
+		done <- err
+	}()
+
+	return func(formatErr error) {
+		program.Send(finishedMsg{err: formatErr})
+
+		err := <-done
+
+		restoreConsole()
+
+		if err != nil {
+			log.Errorf("failed to display dashboard: %v", err)
+		}
+
+		// the log output is written to the terminal again, so it is not lost once the dashboard is closed
+		for _, line := range model.logs {
+			fmt.Fprintln(Console, line)
+		}
+	}
+}
+
+func (d *dashboard) Init() tea.Cmd {
+	return tick()
+}
+
+func tick() tea.Cmd {
+	return tea.Tick(dashboardInterval, func(time.Time) tea.Msg {
+		return tickMsg{}
+	})
+}
+
+func (d *dashboard) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.WindowSizeMsg:
+		d.width, d.height = msg.Width, msg.Height
+
+	case tickMsg:
+		if !d.finished {
+			return d, tick()
+		}
+
+	case logMsg:
+		for _, line := range strings.Split(strings.TrimRight(string(msg), "\n"), "\n") {
+			d.logs = append(d.logs, line)
+		}
+
+		if overflow := len(d.logs) - dashboardLogLines; overflow > 0 {
+			d.logs = slices.Delete(d.logs, 0, overflow)
+		}
+
+	case finishedMsg:
+		d.finished = true
+		d.err = msg.err
+		d.elapsed = d.statz.Elapsed()
+		d.results = collectResults(d.statz)
+
+	case tea.KeyMsg:
+		return d, d.handleKey(msg.String())
+	}
+
+	return d, nil
+}
+
+// handleKey updates the dashboard in response to a key being pressed, returning tea.Quit once the user has finished.
+func (d *dashboard) handleKey(key string) tea.Cmd {
+	if !d.finished {
+		switch key {
+		case "ctrl+c":
+			// formatting is stopped, but the dashboard remains until it has finished
+			if !d.cancelling {
+				d.cancelling = true
+				d.cancel()
+			}
+		default:
+			d.scrollLogs(key)
+		}
+
+		return nil
+	}
+
+	switch {
+	case key == "q" || key == "ctrl+c":
+		return tea.Quit
+	case d.detail:
+		switch key {
+		case "esc", "enter", "backspace", "left", "h":
+			d.detail = false
+		case "up", "k":
+			d.detailScroll = max(d.detailScroll-1, 0)
+		case "down", "j":
+			d.detailScroll++
+		case "pgup":
+			d.detailScroll = max(d.detailScroll-d.paneHeight(), 0)
+		case "pgdown":
+			d.detailScroll += d.paneHeight()
+		}
+	case key == "esc":
+		return tea.Quit
+	case key == "tab":
+		if d.focus == resultsPane {
+			d.focus = logPane
+		} else {
+			d.focus = resultsPane
+		}
+	case d.focus == logPane:
+		d.scrollLogs(key)
+	default:
+		switch key {
+		case "up", "k":
+			d.cursor = max(d.cursor-1, 0)
+		case "down", "j":
+			d.cursor = min(d.cursor+1, max(len(d.results)-1, 0))
+		case "pgup":
+			d.cursor = max(d.cursor-d.paneHeight(), 0)
+		case "pgdown":
+			d.cursor = min(d.cursor+d.paneHeight(), max(len(d.results)-1, 0))
+		case "home", "g":
+			d.cursor = 0
+		case "end", "G":
+			d.cursor = max(len(d.results)-1, 0)
+		case "enter", "right", "l":
+			if len(d.results) > 0 {
+				d.detail = true
+				d.detailScroll = 0
+			}
+		}
+	}
+
+	return nil
+}
+
+func (d *dashboard) scrollLogs(key string) {
+	maxScroll := max(len(d.logs)-1, 0)
+
+	switch key {
+	case "up", "k":
+		d.logScroll = min(d.logScroll+1, maxScroll)
+	case "down", "j":
+		d.logScroll = max(d.logScroll-1, 0)
+	case "pgup":
+		d.logScroll = min(d.logScroll+d.paneHeight(), maxScroll)
+	case "pgdown":
+		d.logScroll = max(d.logScroll-d.paneHeight(), 0)
+	case "home", "g":
+		d.logScroll = maxScroll
+	case "end", "G":
+		d.logScroll = 0
+	}
+}
+
+// paneHeight returns the number of lines available to each of the panes below the header.
+func (d *dashboard) paneHeight() int {
+	// the header occupies four lines and the hints at the bottom another, whilst each pane has a heading
+	return max((d.height-5)/2-1, 1)
+}
+
+func (d *dashboard) View() string {
+	if d.width == 0 {
+		// wait until the size of the terminal is known
+		return ""
+	}
+
+	lines := d.header()
+
+	switch {
+	case d.finished && d.detail:
+		lines = append(lines, d.detailView(d.height-len(lines)-1)...)
+	case d.finished:
+		lines = append(lines, d.resultsView(d.paneHeight())...)
+		lines = append(lines, d.logView(d.height-len(lines)-1)...)
+	default:
+		lines = append(lines, d.formattersView(d.paneHeight())...)
+		lines = append(lines, d.logView(d.height-len(lines)-1)...)
+	}
+
+	// pad the view, so the hints are always at the bottom
+	for len(lines) < d.height-1 {
+		lines = append(lines, "")
+	}
+
+	lines = append(lines, d.styles.hint.Render(d.hints()))
+
+	for i, line := range lines {
+		lines[i] = truncate.String(line, uint(d.width))
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+func (d *dashboard) header() []string {
+	var status string
+
+	elapsed := d.statz.Elapsed()
+
+	switch {
+	case d.finished && d.err != nil:
+		status = d.styles.failed.Render("failed: " + d.err.Error())
+		elapsed = d.elapsed
+	case d.finished:
+		status = d.styles.finished.Render("finished")
+		elapsed = d.elapsed
+	case d.cancelling:
+		status = d.styles.running.Render("cancelling")
+	default:
+		status = d.styles.running.Render("formatting")
+	}
+
+	matched := d.statz.Value(stats.Matched)
+	formatted := d.statz.Value(stats.Formatted)
+
+	counters := fmt.Sprintf(
+		"traversed %d | matched %d | cached %d | formatted %d | changed %d",
+		d.statz.Value(stats.Traversed),
+		matched,
+		d.statz.Value(stats.Cached),
+		formatted,
+		d.statz.Value(stats.Changed),
+	)
+
+	if errored := d.statz.Value(stats.Errored); errored > 0 {
+		counters += d.styles.failed.Render(fmt.Sprintf(" | failed %d", errored))
+	}
+
+	// the files which were cached need not be formatted
+	total := matched - d.statz.Value(stats.Cached)
+
+	var progress float64
+
+	switch {
+	case total > 0:
+		progress = min(float64(formatted)/float64(total), 1)
+	case d.finished:
+		progress = 1
+	}
+
+	filled := int(progress * dashboardBarWidth)
+	bar := fmt.Sprintf(
+		"[%s%s] %3.0f%%",
+		strings.Repeat("█", filled), strings.Repeat("░", dashboardBarWidth-filled), 100*progress,
+	)
+
+	// estimate the time remaining for the files matched so far, based on the rate at which they have been formatted
+	if remaining := total - formatted; !d.finished && formatted > 0 && remaining > 0 {
+		eta := time.Duration(float64(elapsed) / float64(formatted) * float64(remaining))
+		bar += fmt.Sprintf("  eta %v", eta.Round(time.Second))
+	}
+
+	return []string{
+		fmt.Sprintf("%s  %s  %v", d.styles.title.Render("treefmt"), status, elapsed.Round(time.Millisecond)),
+		counters,
+		bar,
+		"",
+	}
+}
+
+// formattersView lists the work performed by each formatter so far, along with those which are running.
+func (d *dashboard) formattersView(height int) []string {
+	formatters := d.statz.Formatters()
+	running := d.statz.Running()
+
+	// include formatters which are running their first batch
+	names := slices.Clone(running)
+	for name := range formatters {
+		if !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+	}
+
+	slices.Sort(names)
+
+	width := len("formatter")
+	for _, name := range names {
+		width = max(width, len(name))
+	}
+
+	lines := []string{d.styles.heading.Render("formatters")}
+
+	for i, name := range names {
+		if i == height-1 && len(names) > height {
+			lines = append(lines, d.styles.hint.Render(fmt.Sprintf("... and %d more", len(names)-i)))
+
+			break
+		}
+
+		f := formatters[name]
+		line := fmt.Sprintf(
+			"%-*s  %6d files  %4d batches  %8v", width, name, f.Files, f.Batches, f.Duration.Round(time.Millisecond),
+		)
+
+		if slices.Contains(running, name) {
+			line += "  " + d.styles.running.Render("running")
+		}
+
+		lines = append(lines, line)
+	}
+
+	if len(names) == 0 {
+		lines = append(lines, d.styles.hint.Render("waiting for files to format"))
+	}
+
+	for len(lines) < height+1 {
+		lines = append(lines, "")
+	}
+
+	return lines
+}
+
+// resultsView lists the result for each file which did not simply get formatted, with the selected one highlighted.
+func (d *dashboard) resultsView(height int) []string {
+	heading := fmt.Sprintf("results (%d)", len(d.results))
+	if d.focus == resultsPane {
+		heading = d.styles.heading.Render(heading)
+	}
+
+	lines := []string{heading}
+
+	if len(d.results) == 0 {
+		lines = append(lines, d.styles.hint.Render("no files were changed"))
+	}
+
+	// keep the cursor within view
+	offset := max(d.cursor-height+1, 0)
+
+	for i := offset; i < len(d.results) && i < offset+height; i++ {
+		result := d.results[i]
+
+		status := result.status
+		if result.failed {
+			status = d.styles.failed.Render(status)
+		}
+
+		line := fmt.Sprintf("%s  %s", result.path, status)
+		if i == d.cursor && d.focus == resultsPane {
+			line = d.styles.selected.Render(result.path) + "  " + status
+		}
+
+		lines = append(lines, line)
+	}
+
+	for len(lines) < height+1 {
+		lines = append(lines, "")
+	}
+
+	return lines
+}
+
+// detailView displays the selected result in full.
+func (d *dashboard) detailView(height int) []string {
+	result := d.results[d.cursor]
+
+	lines := []string{d.styles.heading.Render(result.path) + "  " + result.status}
+
+	detail := strings.Split(strings.TrimRight(result.detail, "\n"), "\n")
+
+	d.detailScroll = min(d.detailScroll, max(len(detail)-height+1, 0))
+
+	for i := d.detailScroll; i < len(detail) && len(lines) < height; i++ {
+		lines = append(lines, detail[i])
+	}
+
+	return lines
+}
+
+// logView displays the most recent log output which fits within height, less any the user has scrolled back through.
+func (d *dashboard) logView(height int) []string {
+	heading := "log"
+	if !d.finished || d.focus == logPane {
+		heading = d.styles.heading.Render(heading)
+	}
+
+	if d.logScroll > 0 {
+		heading += d.styles.hint.Render(fmt.Sprintf(" (scrolled back %d lines)", d.logScroll))
+	}
+
+	lines := []string{heading}
+
+	end := len(d.logs) - d.logScroll
+	start := max(end-height+1, 0)
+
+	return append(lines, d.logs[start:end]...)
+}
+
+func (d *dashboard) hints() string {
+	switch {
+	case !d.finished && d.cancelling:
+		return "waiting for formatters to stop..."
+	case !d.finished:
+		return "↑/↓ scroll log • ctrl+c cancel"
+	case d.detail:
+		return "↑/↓ scroll • esc back • q quit"
+	case d.focus == logPane:
+		return "↑/↓ scroll log • tab results • q quit"
+	default:
+		return "↑/↓ select • enter details • tab log • q quit"
+	}
+}
+
+// collectResults describes each file which was changed, failed to be formatted, was modified by conflicting
+// formatters or did not match any formatter, sorted by path.
+func collectResults(statz *stats.Stats) []fileResult {
+	var results []fileResult
+
+	for _, change := range statz.Changes() {
+		// diffs are only recorded when requested, as the original content of each file must be retained
+		detail := change.Diff
+		if detail == "" {
+			detail = "run with --fail-on-change and --diff to see the changes which were made"
+		}
+
+		results = append(results, fileResult{
+			path:   change.Path,
+			status: "changed by " + strings.Join(change.Formatters, ", "),
+			detail: detail,
+		})
+	}
+
+	for _, failure := range statz.Failures() {
+		detail := failure.Error
+		if failure.Output != "" {
+			detail += "\n\n" + failure.Output
+		}
+
+		for _, path := range failure.Paths {
+			results = append(results, fileResult{
+				path:   path,
+				status: "failed in " + failure.Formatter,
+				detail: detail,
+				failed: true,
+			})
+		}
+	}
+
+	for _, conflict := range statz.Conflicts() {
+		detail := "modified by more than one of " + strings.Join(conflict.Formatters, ", ")
+		if conflict.Oscillating {
+			detail += ", which undo each other's changes"
+		}
+
+		results = append(results, fileResult{
+			path:   conflict.Path,
+			status: "conflict between " + strings.Join(conflict.Formatters, ", "),
+			detail: detail,
+			failed: conflict.Oscillating,
+		})
+	}
+
+	for _, path := range statz.Unmatched() {
+		results = append(results, fileResult{
+			path:   path,
+			status: "no formatter",
+			detail: "no formatter matched the file",
+			failed: true,
+		})
+	}
+
+	slices.SortStableFunc(results, func(a, b fileResult) int {
+		return strings.Compare(a.path, b.path)
+	})
+
+	return results
+}
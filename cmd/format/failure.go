@@ -0,0 +1,98 @@
+package format
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/numtide/treefmt/v2/format"
+	"github.com/numtide/treefmt/v2/runner"
+)
+
+// Failure is the class of an error which caused treefmt to fail, which determines its exit code so that scripts can
+// distinguish, for example, a tree which needs formatting from a formatter which crashed.
+//
+//go:generate enumer -type=Failure -text -trimprefix=Failure -transform=snake -output=./failure_enum.go
+type Failure int
+
+const (
+	// FailureError is any failure which does not belong to one of the other classes.
+	FailureError Failure = iota
+	// FailureUsage is caused by invalid flags or arguments.
+	FailureUsage
+	// FailureChanges is reported when files were changed with --fail-on-change, or would be changed with --check.
+	FailureChanges
+	// FailureFormatter is reported when a formatter could not be found or failed when applied.
+	FailureFormatter
+	// FailureConfig is caused by a config file which could not be found or read, or contains invalid values.
+	FailureConfig
+)
+
+const (
+	// ErrorFormatText prints the message of an error.
+	ErrorFormatText = "text"
+	// ErrorFormatJSON prints an object containing the message of an error, its class of failure and the exit code.
+	ErrorFormatJSON = "json"
+)
+
+// ExitCode returns the exit code for the class of failure.
+func (f Failure) ExitCode() int {
+	return int(f) + 1
+}
+
+// Wrap marks err as belonging to the class of failure, without changing its message.
+func (f Failure) Wrap(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	return &classifiedError{failure: f, err: err}
+}
+
+// Errorf formats an error which belongs to the class of failure.
+func (f Failure) Errorf(format string, args ...any) error {
+	return f.Wrap(fmt.Errorf(format, args...))
+}
+
+// Classify determines the class of failure err belongs to. If it combines failures of several classes, a failing
+// formatter takes precedence over a problem with the config, which takes precedence over changes being detected.
+func Classify(err error) Failure {
+	switch {
+	case errors.Is(err, format.ErrFormattingFailures),
+		errors.Is(err, format.ErrCommandNotFound),
+		errors.Is(err, format.ErrNotInstalled),
+		errors.Is(err, FailureFormatter):
+		return FailureFormatter
+	case errors.Is(err, FailureConfig), errors.Is(err, runner.ErrInvalidConfig):
+		return FailureConfig
+	case errors.Is(err, runner.ErrFailOnChange), errors.Is(err, FailureChanges):
+		return FailureChanges
+	case errors.Is(err, FailureUsage):
+		return FailureUsage
+	default:
+		return FailureError
+	}
+}
+
+// Error allows a class of failure to be the target of errors.Is.
+func (f Failure) Error() string {
+	return f.String()
+}
+
+type classifiedError struct {
+	failure Failure
+	err     error
+}
+
+func (e *classifiedError) Error() string {
+	return e.err.Error()
+}
+
+func (e *classifiedError) Unwrap() error {
+	return e.err
+}
+
+func (e *classifiedError) Is(target error) bool {
+	failure, ok := target.(Failure)
+
+	return ok && failure == e.failure
+}
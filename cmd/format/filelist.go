@@ -0,0 +1,47 @@
+package format
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+
+	"github.com/charmbracelet/log"
+)
+
+// readFilelist reads a list of paths from r, separated by NUL characters or, if none are present, by newlines.
+// Paths which do not exist, such as those deleted in a diff, are skipped.
+func readFilelist(r io.Reader) ([]string, error) {
+	content, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file list: %w", err)
+	}
+
+	sep := []byte{'\n'}
+	if bytes.IndexByte(content, 0) >= 0 {
+		sep = []byte{0}
+	}
+
+	var paths []string
+
+	for _, entry := range bytes.Split(content, sep) {
+		path := string(bytes.TrimSuffix(entry, []byte{'\r'}))
+		if path == "" {
+			continue
+		}
+
+		if _, err = os.Lstat(path); errors.Is(err, fs.ErrNotExist) {
+			log.Warnf("skipping %s from the file list as it does not exist", path)
+
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to stat %s: %w", path, err)
+		}
+
+		paths = append(paths, path)
+	}
+
+	return paths, nil
+}
@@ -2,42 +2,37 @@ package format
 
 import (
 	"context"
-	"errors"
 	"fmt"
-	"io"
 	"os"
 	"os/signal"
 	"path/filepath"
-	"runtime/pprof"
-	"strings"
 	"syscall"
 	"time"
 
 	"github.com/charmbracelet/log"
 	"github.com/numtide/treefmt/v2/config"
-	"github.com/numtide/treefmt/v2/format"
+	"github.com/numtide/treefmt/v2/runner"
 	"github.com/numtide/treefmt/v2/stats"
 	"github.com/numtide/treefmt/v2/walk"
 	"github.com/numtide/treefmt/v2/walk/cache"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 	bolt "go.etcd.io/bbolt"
+	"golang.org/x/term"
 )
 
-const (
-	BatchSize = 1024
-)
-
-var ErrFailOnChange = errors.New("unexpected changes detected, --fail-on-change is enabled")
-
 func Run(v *viper.Viper, statz *stats.Stats, cmd *cobra.Command, paths []string) error {
 	cmd.SilenceUsage = true
 
 	cfg, err := config.FromViper(v)
 	if err != nil {
-		return fmt.Errorf("failed to load config: %w", err)
+		return FailureConfig.Errorf("failed to load config: %w", err)
 	}
 
+	// formatters named exactly with --formatters are applied to stdin regardless of its path, which editors often
+	// have to synthesise, whereas those selected in the config file or with globs still have to match it
+	cfg.StdinByName = cfg.Stdin && cmd.Flags().Changed("formatters") && cfg.Selection.ByExactName()
+
 	if cfg.CI {
 		log.Info("ci mode enabled")
 
@@ -59,29 +54,38 @@ func Run(v *viper.Viper, statz *stats.Stats, cmd *cobra.Command, paths []string)
 		time.Sleep(time.Until(startAfter))
 	}
 
-	// cpu profiling
-	if cfg.CPUProfile != "" {
-		cpuProfile, err := os.Create(cfg.CPUProfile)
+	// cpu, heap, block and mutex profiling, the profiles being written once formatting has finished
+	stopProfiling, err := startProfiling(cfg)
+	if err != nil {
+		return err
+	}
+
+	defer stopProfiling()
+
+	// prevent other treefmt processes from formatting the tree at the same time
+	// formatting stdin doesn't modify the tree, so there is no need to wait for anyone else, whilst when watching, the
+	// lock is only held whilst formatting each set of changes
+	if !cfg.Stdin && !cfg.Watch {
+		unlock, err := runner.Lock(cfg)
 		if err != nil {
-			return fmt.Errorf("failed to open file for writing cpu profile: %w", err)
-		} else if err = pprof.StartCPUProfile(cpuProfile); err != nil {
-			return fmt.Errorf("failed to start cpu profile: %w", err)
+			return err
 		}
 
-		defer func() {
-			pprof.StopCPUProfile()
+		defer unlock()
+	}
 
-			if err := cpuProfile.Close(); err != nil {
-				log.Errorf("failed to close cpu profile: %v", err)
-			}
-		}()
+	// formatting a single file, e.g. when an editor formats on save, is dominated by fixed costs such as opening the
+	// cache, which we skip as there is little to gain from it
+	single := singleFile(cfg, paths)
+	if single {
+		log.Debug("formatting a single file, skipping the cache")
 	}
 
 	var db *bolt.DB
 
-	// open the db unless --no-cache was specified
-	if !cfg.NoCache {
-		db, err = cache.Open(cfg.TreeRoot)
+	// open the db unless --no-cache was specified, or we are watching, in which case it is opened for each pass
+	if !cfg.NoCache && !single && !cfg.Watch {
+		db, err = cache.Open(cfg.CacheDir, cfg.TreeRoot)
 		if err != nil {
 			return fmt.Errorf("failed to open cache: %w", err)
 		}
@@ -104,7 +108,7 @@ func Run(v *viper.Viper, statz *stats.Stats, cmd *cobra.Command, paths []string)
 	}
 
 	// create an overall app context
-	ctx, cancel := context.WithCancel(context.Background())
+	ctx, cancel := context.WithCancel(cmd.Context())
 	defer cancel()
 
 	// listen for shutdown signal and cancel the context
@@ -115,15 +119,107 @@ func Run(v *viper.Viper, statz *stats.Stats, cmd *cobra.Command, paths []string)
 		cancel()
 	}()
 
-	// parse the walk type
-	walkType, err := walk.TypeString(cfg.Walk)
+	// create a runner for applying the formatters
+	var r *Runner
+	if cfg.Watch {
+		r, err = NewSharedRunner(cfg)
+	} else {
+		r, err = NewRunner(cfg, db)
+	}
+
 	if err != nil {
-		return fmt.Errorf("invalid walk type: %w", err)
+		return FailureConfig.Wrap(err)
+	}
+
+	walkType := r.WalkType()
+
+	if walkType == walk.Stdin && cfg.Watch {
+		return FailureUsage.Errorf("the --watch flag cannot be used with the --stdin flag")
 	}
 
 	if walkType == walk.Stdin && len(paths) != 1 {
 		// check we have only received one path arg which we use for the file extension / matching to formatters
-		return fmt.Errorf("exactly one path should be specified when using the --stdin flag")
+		return FailureUsage.Errorf("exactly one path should be specified when using the --stdin flag")
+	}
+
+	if cfg.To != "" && cfg.From == "" {
+		return FailureUsage.Errorf("the --to flag requires the --from flag")
+	} else if cfg.From != "" {
+		switch {
+		case walkType != walk.Auto && walkType != walk.Git:
+			return FailureUsage.Errorf("the %s walk type does not support the --from flag, use git instead", walkType.Name())
+		case cfg.Since != "":
+			return FailureUsage.Errorf("the --from flag cannot be used with the --since flag")
+		case cfg.Staged:
+			return FailureUsage.Errorf("the --from flag cannot be used with the --staged flag")
+		case cfg.StdinFilelist:
+			return FailureUsage.Errorf("the --from flag cannot be used with the --stdin-filelist flag")
+		case cfg.Watch:
+			return FailureUsage.Errorf("the --from flag cannot be used with the --watch flag")
+		}
+	}
+
+	// the answers to the prompt are read from stdin, and each change to the tree would be prompted for when watching
+	if cfg.Interactive {
+		switch {
+		case walkType == walk.Stdin:
+			return FailureUsage.Errorf("the --interactive flag cannot be used with the --stdin flag")
+		case cfg.StdinFilelist:
+			return FailureUsage.Errorf("the --interactive flag cannot be used with the --stdin-filelist flag")
+		case cfg.Watch:
+			return FailureUsage.Errorf("the --interactive flag cannot be used with the --watch flag")
+		}
+	}
+
+	// the dashboard takes over the terminal, so nothing else can read from or write to it whilst it is displayed
+	if cfg.TUI {
+		switch {
+		case walkType == walk.Stdin:
+			return FailureUsage.Errorf("the --tui flag cannot be used with the --stdin flag")
+		case cfg.StdinFilelist:
+			return FailureUsage.Errorf("the --tui flag cannot be used with the --stdin-filelist flag")
+		case cfg.Interactive:
+			return FailureUsage.Errorf("the --tui flag cannot be used with the --interactive flag")
+		case cfg.DryRun:
+			return FailureUsage.Errorf("the --tui flag cannot be used with the --dry-run flag")
+		case cfg.Watch:
+			return FailureUsage.Errorf("the --tui flag cannot be used with the --watch flag")
+		case !term.IsTerminal(int(os.Stdin.Fd())) || !term.IsTerminal(int(os.Stdout.Fd())):
+			return FailureUsage.Errorf("the --tui flag requires a terminal")
+		}
+	}
+
+	if cfg.Staged {
+		switch {
+		case walkType == walk.Stdin:
+			return FailureUsage.Errorf("the --staged flag cannot be used with the --stdin flag")
+		case cfg.StdinFilelist:
+			return FailureUsage.Errorf("the --staged flag cannot be used with the --stdin-filelist flag")
+		case cfg.Since != "":
+			return FailureUsage.Errorf("the --staged flag cannot be used with the --since flag")
+		case cfg.Watch:
+			return FailureUsage.Errorf("the --staged flag cannot be used with the --watch flag")
+		}
+	}
+
+	if cfg.StdinFilelist {
+		if walkType == walk.Stdin {
+			return FailureUsage.Errorf("the --stdin-filelist flag cannot be used with the --stdin flag")
+		}
+
+		filelist, err := readFilelist(os.Stdin)
+		if err != nil {
+			return err
+		}
+
+		// an empty list means there is nothing to format, rather than the entire tree
+		if len(paths) == 0 && len(filelist) == 0 {
+			log.Info("no paths were provided on stdin, nothing to format")
+
+			return r.summarise(statz, nil)
+		}
+
+		paths = append(paths, filelist...)
 	}
 
 	// checks all paths are contained within the tree root and exist
@@ -134,81 +230,68 @@ func Run(v *viper.Viper, statz *stats.Stats, cmd *cobra.Command, paths []string)
 			return fmt.Errorf("error computing absolute path of %s: %w", path, err)
 		}
 
-		relativePath, err := filepath.Rel(cfg.TreeRoot, absolutePath)
+		relativePath, err := runner.RelativePath(cfg.TreeRoot, absolutePath)
 		if err != nil {
-			return fmt.Errorf("error computing relative path from %s to %s: %s", cfg.TreeRoot, absolutePath, err)
-		}
-
-		if strings.HasPrefix(relativePath, "..") {
-			return fmt.Errorf("path %s not inside the tree root %s", path, cfg.TreeRoot)
+			return FailureUsage.Errorf("path %s not inside the tree root %s", path, cfg.TreeRoot)
 		}
 
 		paths[i] = relativePath
 
 		if walkType != walk.Stdin {
 			if _, err = os.Stat(absolutePath); err != nil {
-				return fmt.Errorf("path %s not found", path)
+				return FailureUsage.Errorf("path %s not found", path)
 			}
 		}
 	}
 
-	// create a composite formatter which will handle applying the correct formatters to each file we traverse
-	formatter, err := format.NewCompositeFormatter(cfg, statz, BatchSize)
-	if err != nil {
-		return fmt.Errorf("failed to create composite formatter: %w", err)
-	}
-
-	// create a new walker for traversing the paths
-	walker, err := walk.NewCompositeReader(walkType, cfg.TreeRoot, paths, db, statz)
-	if err != nil {
-		return fmt.Errorf("failed to create walker: %w", err)
+	// metrics are only worth exposing if we keep running after the initial format
+	if cfg.Watch {
+		if err = r.ServeMetrics(ctx); err != nil {
+			return err
+		}
+	} else if cfg.MetricsListen != "" {
+		log.Debug("ignoring --metrics-listen as metrics are only exposed with --watch or by the daemon")
 	}
 
-	// start traversing
-	files := make([]*walk.File, BatchSize)
-
-	for {
-		// read the next batch
-		readCtx, cancel := context.WithTimeout(ctx, 1*time.Second)
-		n, err := walker.Read(readCtx, files)
-
-		// ensure context is cancelled to release resources
-		cancel()
+	// display the dashboard if requested, otherwise progress whilst formatting the requested paths, if interactive
+	var stopProgress func(formatErr error)
 
-		// format
-		if err := formatter.Apply(ctx, files[:n]); err != nil {
-			return fmt.Errorf("formatting failure: %w", err)
-		}
+	if cfg.TUI {
+		stopProgress = startDashboard(statz, cancel, r.color)
+	} else {
+		stop := startProgress(cfg, statz)
+		stopProgress = func(error) { stop() }
+	}
 
-		if errors.Is(err, io.EOF) {
-			// we have finished traversing
-			break
-		} else if err != nil {
-			// something went wrong
-			return fmt.Errorf("failed to read files: %w", err)
-		}
+	// format the requested paths
+	if single && walkType != walk.Stdin && !cfg.Staged && cfg.From == "" {
+		err = r.FormatFile(ctx, statz, paths[0])
+	} else {
+		err = r.Format(ctx, statz, paths)
 	}
 
-	// finalize formatting
-	formatErr := formatter.Close(ctx)
+	stopProgress(err)
 
-	// close the walker, ensuring any pending file release hooks finish
-	if err = walker.Close(); err != nil {
-		return fmt.Errorf("failed to close walker: %w", err)
+	if err = r.summarise(statz, err); !cfg.Watch {
+		return err
+	} else if err != nil {
+		log.Error(err)
 	}
 
-	// print stats to stdout, unless we are processing from stdin and therefore outputting the results to stdout
-	if !cfg.Stdin {
-		statz.Print()
-	}
+	// keep formatting paths as they change, until we are interrupted
+	return r.watch(ctx)
+}
 
-	if formatErr != nil {
-		// return an error if any formatting failures were detected
-		return formatErr
-	} else if cfg.FailOnChange && statz.Value(stats.Changed) != 0 {
-		// if fail on change has been enabled, check that no files were actually changed, throwing an error if so
-		return ErrFailOnChange
+// singleFile reports whether paths describes a single file, either a regular file or the content of stdin, which can be
+// formatted without the overhead of the cache or traversing the tree root.
+func singleFile(cfg *config.Config, paths []string) bool {
+	if len(paths) != 1 || cfg.Watch || cfg.StdinFilelist || cfg.ClearCache {
+		return false
+	} else if cfg.Stdin {
+		return true
 	}
 
-	return nil
+	info, err := os.Stat(paths[0])
+
+	return err == nil && info.Mode().IsRegular()
 }
@@ -0,0 +1,109 @@
+package format
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/numtide/treefmt/v2/format"
+)
+
+var ErrAborted = errors.New("formatting aborted")
+
+// confirmFormatters returns a format.ConfirmFunc which lists the number of files each formatter would be applied to on
+// out, before prompting for confirmation on in. Formatters can be deselected by entering their numbers, after which the
+// remaining formatters are listed again.
+func confirmFormatters(in io.Reader, out io.Writer) format.ConfirmFunc {
+	reader := bufio.NewReader(in)
+
+	return func(counts map[string]int) ([]string, error) {
+		names := make([]string, 0, len(counts))
+		for name := range counts {
+			names = append(names, name)
+		}
+
+		slices.Sort(names)
+
+		var deselected []string
+
+		for {
+			remaining := slices.DeleteFunc(slices.Clone(names), func(name string) bool {
+				return slices.Contains(deselected, name)
+			})
+
+			if len(remaining) == 0 {
+				return deselected, nil
+			}
+
+			printCounts(out, remaining, counts)
+
+			_, _ = fmt.Fprint(out, "Format these files? [Y]es, [n]o, or the numbers of any formatters to skip: ")
+
+			line, err := reader.ReadString('\n')
+			if errors.Is(err, io.EOF) && line == "" {
+				_, _ = fmt.Fprintln(out)
+
+				return nil, fmt.Errorf("%w: no answer was given", ErrAborted)
+			} else if err != nil && !errors.Is(err, io.EOF) {
+				return nil, fmt.Errorf("failed to read answer: %w", err)
+			}
+
+			switch answer := strings.ToLower(strings.TrimSpace(line)); answer {
+			case "", "y", "yes":
+				return deselected, nil
+			case "n", "no":
+				return nil, ErrAborted
+			default:
+				skip, err := parseSelection(answer, len(remaining))
+				if err != nil {
+					_, _ = fmt.Fprintf(out, "%v\n", err)
+
+					continue
+				}
+
+				for _, i := range skip {
+					deselected = append(deselected, remaining[i])
+				}
+			}
+		}
+	}
+}
+
+// printCounts lists the number of files each of the named formatters would be applied to, numbering each formatter so
+// it can be deselected.
+func printCounts(out io.Writer, names []string, counts map[string]int) {
+	width := 0
+	for _, name := range names {
+		width = max(width, len(name))
+	}
+
+	_, _ = fmt.Fprintln(out, "The following formatters would be applied:")
+
+	for i, name := range names {
+		_, _ = fmt.Fprintf(out, "%4d) %-*s  %d file(s)\n", i+1, width, name, counts[name])
+	}
+}
+
+// parseSelection parses the numbers of formatters, separated by spaces or commas, returning their indices.
+func parseSelection(answer string, n int) ([]int, error) {
+	fields := strings.FieldsFunc(answer, func(r rune) bool {
+		return r == ' ' || r == ','
+	})
+
+	indices := make([]int, 0, len(fields))
+
+	for _, field := range fields {
+		number, err := strconv.Atoi(field)
+		if err != nil || number < 1 || number > n {
+			return nil, fmt.Errorf("invalid selection '%s', expected a number between 1 and %d", field, n)
+		}
+
+		indices = append(indices, number-1)
+	}
+
+	return indices, nil
+}
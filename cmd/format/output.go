@@ -0,0 +1,251 @@
+package format
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/muesli/termenv"
+	"github.com/numtide/treefmt/v2/config"
+	"github.com/numtide/treefmt/v2/stats"
+)
+
+//go:generate enumer -type=Output -text -transform=snake -output=./output_enum.go
+type Output int
+
+const (
+	Auto Output = iota
+	Text
+	JSON
+	Github
+)
+
+const (
+	// SummaryBasic prints the counters of the run.
+	SummaryBasic = "basic"
+	// SummaryDetailed additionally prints the work performed by each formatter.
+	SummaryDetailed = "detailed"
+)
+
+// resolveOutput parses the configured output format, resolving Auto based on the environment we are running in.
+// Annotations are only worth adding to a pull request when changes are a failure, so Auto only resolves to Github
+// when failOnChange is set, whereas it can be chosen explicitly to report changes as notices.
+func resolveOutput(value string, failOnChange bool) (Output, error) {
+	output, err := OutputString(value)
+	if err != nil {
+		return output, err
+	}
+
+	if output != Auto {
+		return output, nil
+	}
+
+	// detect if we are running within GitHub Actions
+	// see https://docs.github.com/en/actions/writing-workflows/choosing-what-your-workflow-does/store-information-in-variables#default-environment-variables
+	if failOnChange && os.Getenv("GITHUB_ACTIONS") == "true" {
+		return Github, nil
+	}
+
+	return Text, nil
+}
+
+// printSummary writes a summary of the run to stdout in the requested output format, with any diffs styled according to
+// color.
+func printSummary(output Output, color termenv.Profile, cfg *config.Config, statz *stats.Stats) error {
+	switch output {
+	case Text:
+		if cfg.Diff {
+			printDiffs(color, statz)
+		}
+
+		printStats(cfg, statz)
+	case JSON:
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+
+		if err := encoder.Encode(statz.Summary()); err != nil {
+			return fmt.Errorf("failed to encode summary: %w", err)
+		}
+	case Github:
+		if cfg.Diff {
+			printDiffs(color, statz)
+		}
+
+		printGithubAnnotations(cfg, statz)
+		printStats(cfg, statz)
+	case Auto:
+		return fmt.Errorf("output format has not been resolved")
+	default:
+		return fmt.Errorf("unknown output format: %v", output)
+	}
+
+	return nil
+}
+
+// printStats writes the counters of the run to stdout, followed by a breakdown of each formatter if a detailed summary
+// was requested.
+func printStats(cfg *config.Config, statz *stats.Stats) {
+	statz.Print()
+
+	if cfg.Summary == SummaryDetailed {
+		fmt.Println()
+		statz.PrintFormatters()
+	}
+}
+
+// printDiffs writes the unified diff recorded for each change to stdout, if any.
+// When outputting JSON, the diffs are instead included with the changes in the summary.
+func printDiffs(color termenv.Profile, statz *stats.Stats) {
+	for _, change := range statz.Changes() {
+		if change.Diff != "" {
+			fmt.Print(colorDiff(color, change.Diff))
+		}
+	}
+}
+
+// printPlanned writes each file which would have been formatted during a dry run to stdout, along with the formatters
+// which would have been applied to it.
+// When outputting JSON, the files are instead included in the summary.
+func printPlanned(statz *stats.Stats) {
+	for _, plan := range statz.Planned() {
+		fmt.Printf("%s: %s\n", plan.Path, strings.Join(plan.Formatters, ", "))
+	}
+}
+
+// writePatch writes the unified diff recorded for each change to path, producing a single patch which can be applied
+// to the tree with `git apply`.
+// The file is always written, and is empty if there were no changes.
+func writePatch(path string, statz *stats.Stats) error {
+	var patch strings.Builder
+	for _, change := range statz.Changes() {
+		patch.WriteString(change.Diff)
+	}
+
+	if err := os.WriteFile(path, []byte(patch.String()), 0o600); err != nil {
+		return fmt.Errorf("failed to write patch file: %w", err)
+	}
+
+	return nil
+}
+
+// writeStatsFile writes a summary of the run to the given path as JSON, in the same form as the json output format.
+func writeStatsFile(path string, statz *stats.Stats) error {
+	data, err := json.MarshalIndent(statz.Summary(), "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode stats: %w", err)
+	}
+
+	if err = os.WriteFile(path, append(data, '\n'), 0o600); err != nil {
+		return fmt.Errorf("failed to write stats file: %w", err)
+	}
+
+	return nil
+}
+
+// printGithubAnnotations writes a GitHub Actions workflow command for each file which was changed, causing them to
+// be displayed as annotations on the files in question.
+// Changes are reported as errors when --fail-on-change is enabled, and as notices otherwise.
+// See https://docs.github.com/en/actions/writing-workflows/choosing-what-your-workflow-does/workflow-commands-for-github-actions
+func printGithubAnnotations(cfg *config.Config, statz *stats.Stats) {
+	command := "notice"
+	if cfg.FailOnChange {
+		command = "error"
+	}
+
+	for _, change := range statz.Changes() {
+		fmt.Printf(
+			"::%s file=%s,title=%s::%s\n",
+			command,
+			escapeGithubProperty(filepath.ToSlash(change.Path)),
+			escapeGithubProperty("treefmt"),
+			escapeGithubData(fmt.Sprintf("File was changed by %s", strings.Join(change.Formatters, ", "))),
+		)
+	}
+}
+
+func escapeGithubData(value string) string {
+	return strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A").Replace(value)
+}
+
+func escapeGithubProperty(value string) string {
+	return strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A", ":", "%3A", ",", "%2C").Replace(value)
+}
+
+// printChanges writes a list of every file which was changed to stderr, sorted by path, along with the formatters
+// which were applied to it.
+func printChanges(statz *stats.Stats) {
+	changes := statz.Changes()
+	if len(changes) == 0 {
+		return
+	}
+
+	_, _ = fmt.Fprintf(os.Stderr, "\n%d file(s) changed:\n", len(changes))
+
+	for _, change := range changes {
+		_, _ = fmt.Fprintf(os.Stderr, "  %s (%s)\n", change.Path, strings.Join(change.Formatters, ", "))
+	}
+
+	_, _ = fmt.Fprintln(os.Stderr)
+}
+
+// printUnmatched writes a list of every path which did not match any formatter to stderr, sorted by path.
+func printUnmatched(statz *stats.Stats) {
+	unmatched := statz.Unmatched()
+	if len(unmatched) == 0 {
+		return
+	}
+
+	_, _ = fmt.Fprintf(os.Stderr, "\n%d path(s) without a formatter:\n", len(unmatched))
+
+	for _, path := range unmatched {
+		_, _ = fmt.Fprintf(os.Stderr, "  %s\n", path)
+	}
+
+	_, _ = fmt.Fprintln(os.Stderr)
+}
+
+// writeUnmatchedFile writes every path which did not match any formatter to path, one per line and sorted, so the
+// list can be acted upon by other tools.
+// The file is always written, and is empty if every path was matched.
+func writeUnmatchedFile(path string, statz *stats.Stats) error {
+	var content strings.Builder
+	for _, unmatched := range statz.Unmatched() {
+		content.WriteString(unmatched + "\n")
+	}
+
+	if err := os.WriteFile(path, []byte(content.String()), 0o600); err != nil {
+		return fmt.Errorf("failed to write unmatched file: %w", err)
+	}
+
+	return nil
+}
+
+// printFailures writes a report of every formatting failure to stderr, including any output captured from the
+// formatters in question.
+func printFailures(statz *stats.Stats) {
+	failures := statz.Failures()
+	if len(failures) == 0 {
+		return
+	}
+
+	_, _ = fmt.Fprintf(os.Stderr, "\n%d formatting failure(s):\n", len(failures))
+
+	for _, failure := range failures {
+		_, _ = fmt.Fprintf(
+			os.Stderr, "\nformatter '%s' failed to format %d file(s): %s\n",
+			failure.Formatter, len(failure.Paths), failure.Error,
+		)
+
+		for _, path := range failure.Paths {
+			_, _ = fmt.Fprintf(os.Stderr, "  %s\n", path)
+		}
+
+		if output := strings.TrimSpace(failure.Output); output != "" {
+			_, _ = fmt.Fprintf(os.Stderr, "\n%s\n", output)
+		}
+	}
+
+	_, _ = fmt.Fprintln(os.Stderr)
+}
@@ -0,0 +1,100 @@
+package format
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"runtime/pprof"
+
+	"github.com/charmbracelet/log"
+	"github.com/numtide/treefmt/v2/config"
+)
+
+// startProfiling starts each of the profiles requested in cfg, returning a function which stops them and writes them
+// to their files. The files are created up front, so a bad path is reported before any formatting is done.
+func startProfiling(cfg *config.Config) (func(), error) {
+	var stops []func()
+
+	stop := func() {
+		// stop in the reverse order, so the cpu profile, which is started first, covers writing the others
+		for i := len(stops) - 1; i >= 0; i-- {
+			stops[i]()
+		}
+	}
+
+	if cfg.CPUProfile != "" {
+		cpuProfile, err := os.Create(cfg.CPUProfile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open file for writing cpu profile: %w", err)
+		} else if err = pprof.StartCPUProfile(cpuProfile); err != nil {
+			closeProfile("cpu", cpuProfile)
+
+			return nil, fmt.Errorf("failed to start cpu profile: %w", err)
+		}
+
+		stops = append(stops, func() {
+			pprof.StopCPUProfile()
+			closeProfile("cpu", cpuProfile)
+		})
+	}
+
+	for _, profile := range []struct {
+		name  string
+		path  string
+		start func()
+		stop  func()
+	}{
+		{
+			// heap profiles are always sampled, recording the allocations made as well as the memory in use
+			name: "heap",
+			path: cfg.MemProfile,
+			// collect garbage first, so the memory in use is that which is still reachable
+			stop: runtime.GC,
+		},
+		{
+			name:  "block",
+			path:  cfg.BlockProfile,
+			start: func() { runtime.SetBlockProfileRate(1) },
+			stop:  func() { runtime.SetBlockProfileRate(0) },
+		},
+		{
+			name:  "mutex",
+			path:  cfg.MutexProfile,
+			start: func() { runtime.SetMutexProfileFraction(1) },
+			stop:  func() { runtime.SetMutexProfileFraction(0) },
+		},
+	} {
+		if profile.path == "" {
+			continue
+		}
+
+		file, err := os.Create(profile.path)
+		if err != nil {
+			stop()
+
+			return nil, fmt.Errorf("failed to open file for writing %s profile: %w", profile.name, err)
+		}
+
+		if profile.start != nil {
+			profile.start()
+		}
+
+		stops = append(stops, func() {
+			profile.stop()
+
+			if err := pprof.Lookup(profile.name).WriteTo(file, 0); err != nil {
+				log.Errorf("failed to write %s profile: %v", profile.name, err)
+			}
+
+			closeProfile(profile.name, file)
+		})
+	}
+
+	return stop, nil
+}
+
+func closeProfile(name string, file *os.File) {
+	if err := file.Close(); err != nil {
+		log.Errorf("failed to close %s profile: %v", name, err)
+	}
+}
@@ -0,0 +1,106 @@
+package format
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/numtide/treefmt/v2/config"
+	"github.com/numtide/treefmt/v2/stats"
+	"golang.org/x/term"
+)
+
+const (
+	// progressDelay is how long to wait before displaying progress, so that quick runs do not flicker
+	progressDelay = 500 * time.Millisecond
+	// progressInterval is how often the progress display is refreshed
+	progressInterval = 100 * time.Millisecond
+)
+
+// startProgress displays a live summary of the counters in statz on stdout until the returned function is called,
+// which clears the display.
+// Progress is only displayed when stdout is a terminal and nothing else is expected to write to it, otherwise we fall
+// back to the plain logs.
+func startProgress(cfg *config.Config, statz *stats.Stats) func() {
+	fd := int(os.Stdout.Fd())
+
+	// formatted stdin is written to stdout, and logging when verbose or prompting when interactive would be
+	// interleaved with the display, as would the files listed by a dry run
+	if cfg.Stdin || cfg.Quiet || cfg.Verbose > 0 || cfg.Interactive || cfg.DryRun || !term.IsTerminal(fd) {
+		return func() {}
+	}
+
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+
+	go func() {
+		defer close(stopped)
+
+		delay := time.NewTimer(progressDelay)
+		defer delay.Stop()
+
+		select {
+		case <-done:
+			return
+		case <-delay.C:
+		}
+
+		ticker := time.NewTicker(progressInterval)
+		defer ticker.Stop()
+
+		for {
+			width, _, err := term.GetSize(fd)
+			if err != nil {
+				width = 0
+			}
+
+			renderProgress(os.Stdout, statz, width)
+
+			select {
+			case <-done:
+				// clear the display so that it does not get mixed up with the summary
+				fmt.Fprint(os.Stdout, "\r\033[K")
+
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
+
+// renderProgress writes a single line describing the progress made so far, truncated to width if it is positive.
+func renderProgress(w io.Writer, statz *stats.Stats, width int) {
+	matched := statz.Value(stats.Matched)
+	formatted := statz.Value(stats.Formatted)
+	elapsed := statz.Elapsed()
+
+	components := []string{
+		fmt.Sprintf("traversed %d", statz.Value(stats.Traversed)),
+		fmt.Sprintf("matched %d", matched),
+		fmt.Sprintf("formatted %d", formatted),
+	}
+
+	// estimate the time remaining for the files matched so far, based on the rate at which they have been formatted
+	if remaining := matched - formatted; formatted > 0 && remaining > 0 {
+		eta := time.Duration(float64(elapsed) / float64(formatted) * float64(remaining))
+		components = append(components, fmt.Sprintf("eta %v", eta.Round(time.Second)))
+	}
+
+	if running := statz.Running(); len(running) > 0 {
+		components = append(components, "running "+strings.Join(running, ", "))
+	}
+
+	line := strings.Join(components, " | ")
+	if width > 0 && len(line) >= width {
+		line = line[:width-1]
+	}
+
+	fmt.Fprintf(w, "\r\033[K%s", line)
+}
@@ -0,0 +1,103 @@
+//nolint:testpackage
+package format
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/numtide/treefmt/v2/stats"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRenderProgress(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		traversed int
+		matched   int
+		formatted int
+		running   []string
+		width     int
+		line      string
+	}{
+		{
+			name: "empty",
+			line: `^traversed 0 \| matched 0 \| formatted 0$`,
+		},
+		{
+			name:      "nothing formatted yet",
+			traversed: 10,
+			matched:   6,
+			line:      `^traversed 10 \| matched 6 \| formatted 0$`,
+		},
+		{
+			name:      "partially formatted",
+			traversed: 10,
+			matched:   6,
+			formatted: 3,
+			line:      `^traversed 10 \| matched 6 \| formatted 3 \| eta \d+s$`,
+		},
+		{
+			name:      "fully formatted",
+			traversed: 10,
+			matched:   6,
+			formatted: 6,
+			line:      `^traversed 10 \| matched 6 \| formatted 6$`,
+		},
+		{
+			name:      "running",
+			traversed: 10,
+			matched:   6,
+			running:   []string{"shfmt", "alejandra", "shfmt"},
+			line:      `^traversed 10 \| matched 6 \| formatted 0 \| running alejandra, shfmt$`,
+		},
+		{
+			name:      "wide enough",
+			traversed: 10,
+			matched:   6,
+			width:     80,
+			line:      `^traversed 10 \| matched 6 \| formatted 0$`,
+		},
+		{
+			name:      "truncated",
+			traversed: 10,
+			matched:   6,
+			width:     20,
+			line:      `^traversed 10 \| matc$`,
+		},
+		{
+			name:      "exactly the width",
+			traversed: 10,
+			matched:   6,
+			width:     len("traversed 10 | matched 6 | formatted 0"),
+			line:      `^traversed 10 \| matched 6 \| formatted $`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			as := require.New(t)
+
+			statz := stats.New()
+			statz.Add(stats.Traversed, tc.traversed)
+			statz.Add(stats.Matched, tc.matched)
+			statz.Add(stats.Formatted, tc.formatted)
+
+			for _, name := range tc.running {
+				statz.StartFormatter(name)
+			}
+
+			var buf bytes.Buffer
+			renderProgress(&buf, &statz, tc.width)
+
+			// each line replaces the previous one
+			out := buf.String()
+			as.True(strings.HasPrefix(out, "\r\033[K"), "missing line reset: %q", out)
+
+			line := strings.TrimPrefix(out, "\r\033[K")
+			as.Regexp(tc.line, line)
+
+			if tc.width > 0 {
+				as.Less(len(line), tc.width)
+			}
+		})
+	}
+}
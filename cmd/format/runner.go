@@ -0,0 +1,164 @@
+package format
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/muesli/termenv"
+	"github.com/numtide/treefmt/v2/config"
+	"github.com/numtide/treefmt/v2/format"
+	"github.com/numtide/treefmt/v2/report"
+	"github.com/numtide/treefmt/v2/runner"
+	"github.com/numtide/treefmt/v2/stats"
+	bolt "go.etcd.io/bbolt"
+)
+
+// Runner applies the configured formatters with a runner.Runner, reporting the outcome of each run on the console.
+type Runner struct {
+	*runner.Runner
+
+	cfg     *config.Config
+	output  Output
+	color   termenv.Profile
+	reports []*report.Report
+}
+
+// NewRunner creates a Runner for the given config.
+// db is used for caching and may be nil, in which case the cache is disabled.
+func NewRunner(cfg *config.Config, db *bolt.DB) (*Runner, error) {
+	r, err := runner.New(cfg, db)
+	if err != nil {
+		return nil, err
+	}
+
+	return newRunner(cfg, r)
+}
+
+// NewSharedRunner creates a Runner for --watch, which takes the lock for the tree root and opens the cache for each
+// run, rather than holding them for as long as it is running.
+func NewSharedRunner(cfg *config.Config) (*Runner, error) {
+	r, err := runner.NewShared(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return newRunner(cfg, r)
+}
+
+// newRunner checks the settings which determine how the outcome of each run of r is reported.
+func newRunner(cfg *config.Config, r *runner.Runner) (*Runner, error) {
+	// parse the output format
+	output, err := resolveOutput(cfg.Output, cfg.FailOnChange)
+	if err != nil {
+		return nil, fmt.Errorf("invalid output format: %w", err)
+	}
+
+	// diffs are printed to stdout, so that is where we check for color support
+	color, err := ColorProfile(cfg.Color, os.Stdout)
+	if err != nil {
+		return nil, err
+	}
+
+	// check the level of detail in the summary
+	if cfg.Summary != SummaryBasic && cfg.Summary != SummaryDetailed {
+		return nil, fmt.Errorf(
+			"invalid summary: %s, must be one of <%s|%s>", cfg.Summary, SummaryBasic, SummaryDetailed,
+		)
+	}
+
+	// errors are only printed once the run has failed, so the format is checked beforehand
+	if cfg.ErrorFormat != ErrorFormatText && cfg.ErrorFormat != ErrorFormatJSON {
+		return nil, fmt.Errorf(
+			"invalid error-format: %s, must be one of <%s|%s>", cfg.ErrorFormat, ErrorFormatText, ErrorFormatJSON,
+		)
+	}
+
+	// parse any reports which should be written after formatting
+	reports := make([]*report.Report, len(cfg.Reports))
+	for i, value := range cfg.Reports {
+		if reports[i], err = report.Parse(value); err != nil {
+			return nil, fmt.Errorf("invalid report: %w", err)
+		}
+	}
+
+	// the prompt is written to stderr, as stdout is where the summary is printed
+	if cfg.Interactive && !cfg.Yes {
+		r.Confirm(confirmFormatters(os.Stdin, os.Stderr))
+	}
+
+	return &Runner{
+		Runner:  r,
+		cfg:     cfg,
+		output:  output,
+		color:   color,
+		reports: reports,
+	}, nil
+}
+
+// summarise writes any configured reports and prints a summary of the run, provided formatting ran to completion.
+// It returns formatErr, the result of calling Format, or any error encountered whilst summarising.
+func (r *Runner) summarise(statz *stats.Stats, formatErr error) error {
+	if formatErr != nil && !errors.Is(formatErr, format.ErrFormattingFailures) &&
+		!errors.Is(formatErr, runner.ErrFailOnChange) && !errors.Is(formatErr, runner.ErrUnmatched) {
+		// formatting did not complete
+		return formatErr
+	}
+
+	// write any reports which were requested
+	for _, rep := range r.reports {
+		if err := rep.Write(statz); err != nil {
+			return err
+		}
+	}
+
+	// write a patch of the changes, but only if they were diffed
+	if r.cfg.PatchFile != "" && r.cfg.FailOnChange {
+		if err := writePatch(r.cfg.PatchFile, statz); err != nil {
+			return err
+		}
+	}
+
+	// write the stats for dashboards and the like, independently of the output format
+	if r.cfg.StatsFile != "" {
+		if err := writeStatsFile(r.cfg.StatsFile, statz); err != nil {
+			return err
+		}
+	}
+
+	// list the files which were changed, so it's clear what needs to be addressed
+	if errors.Is(formatErr, runner.ErrFailOnChange) {
+		printChanges(statz)
+	}
+
+	// likewise for any paths without a formatter, which are written to a file instead if requested
+	if r.cfg.OnUnmatched == format.OnUnmatchedFailWithList {
+		if r.cfg.UnmatchedFile != "" {
+			if err := writeUnmatchedFile(r.cfg.UnmatchedFile, statz); err != nil {
+				return err
+			}
+		} else {
+			printUnmatched(statz)
+		}
+	}
+
+	// list what a dry run would have done, unless it forms part of the json summary
+	if r.cfg.DryRun && r.output != JSON && !r.cfg.Stdin {
+		printPlanned(statz)
+	}
+
+	// formatter output is withheld when keeping going, so we report every failure together at the end
+	if r.cfg.KeepGoing {
+		printFailures(statz)
+	}
+
+	// print stats to stdout, unless we are processing from stdin and therefore outputting the results to stdout, or
+	// have been asked to be quiet
+	if !r.cfg.Stdin && !r.cfg.Quiet {
+		if err := printSummary(r.output, r.color, r.cfg, statz); err != nil {
+			return err
+		}
+	}
+
+	return formatErr
+}
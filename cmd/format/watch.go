@@ -0,0 +1,172 @@
+package format
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/fsnotify/fsnotify"
+	"github.com/numtide/treefmt/v2/stats"
+)
+
+// watchDebounce is how long we wait for filesystem events to settle before formatting the paths which have changed.
+const watchDebounce = 100 * time.Millisecond
+
+// watchSkipDirs are directories which we never watch, as they are managed by version control systems and change
+// frequently.
+var watchSkipDirs = []string{".git", ".jj", ".hg", ".svn"} //nolint:gochecknoglobals
+
+// watch monitors the tree root for changes, formatting any files which are created or modified until ctx is cancelled.
+// Each set of changes is processed with a fresh set of stats, so the summary printed after each pass only reflects the
+// paths which changed. Events caused by the formatters writing to those paths are ignored, as otherwise each pass would
+// trigger another.
+func (r *Runner) watch(ctx context.Context) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("failed to create filesystem watcher: %w", err)
+	}
+
+	defer func() {
+		if err := watcher.Close(); err != nil {
+			log.Errorf("failed to close filesystem watcher: %v", err)
+		}
+	}()
+
+	if err = r.watchDir(watcher, r.cfg.TreeRoot); err != nil {
+		return err
+	}
+
+	log.Infof("watching %s for changes", r.cfg.TreeRoot)
+
+	var (
+		// pending is the set of paths, relative to the tree root, which have changed since the last pass
+		pending = make(map[string]bool)
+		// formatted records the state of the paths in the last pass once it completed, so we can tell our own writes
+		// apart from later changes
+		formatted = make(map[string]fs.FileInfo)
+		// timer fires once events have settled
+		timer <-chan time.Time
+	)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+
+			log.Errorf("filesystem watcher error: %v", err)
+
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+
+			// we only care about files being created or written to
+			if !event.Has(fsnotify.Create) && !event.Has(fsnotify.Write) {
+				continue
+			}
+
+			info, err := os.Lstat(event.Name)
+			if errors.Is(err, fs.ErrNotExist) {
+				// the file may have been removed before we got to it
+				continue
+			} else if err != nil {
+				log.Errorf("failed to stat %s: %v", event.Name, err)
+
+				continue
+			}
+
+			if info.IsDir() {
+				// start watching any new directories, formatting their contents
+				if err = r.watchDir(watcher, event.Name); err != nil {
+					log.Error(err)
+
+					continue
+				}
+			} else if !info.Mode().IsRegular() {
+				continue
+			}
+
+			relPath, err := filepath.Rel(r.cfg.TreeRoot, event.Name)
+			if err != nil {
+				log.Errorf("failed to determine a relative path for %s: %v", event.Name, err)
+
+				continue
+			}
+
+			if last, ok := formatted[relPath]; ok && !info.IsDir() &&
+				last.Size() == info.Size() && last.ModTime().Equal(info.ModTime()) {
+				// the file hasn't changed since we formatted it
+				continue
+			}
+
+			log.Debugf("change detected: %s", relPath)
+
+			pending[relPath] = true
+			timer = time.After(watchDebounce)
+
+		case <-timer:
+			timer = nil
+
+			paths := make([]string, 0, len(pending))
+			for path := range pending {
+				// ignore any paths which have since been removed
+				if _, err := os.Lstat(filepath.Join(r.cfg.TreeRoot, path)); err == nil {
+					paths = append(paths, path)
+				}
+			}
+
+			clear(pending)
+
+			if len(paths) == 0 {
+				continue
+			}
+
+			slices.Sort(paths)
+
+			// format the changed paths, using a fresh set of stats for each pass
+			statz := stats.New()
+			if err := r.summarise(&statz, r.Format(ctx, &statz, paths)); err != nil {
+				log.Error(err)
+			}
+
+			// remember the state of the paths we just formatted, so we can ignore the events caused by formatting them
+			clear(formatted)
+
+			for _, path := range paths {
+				if info, err := os.Lstat(filepath.Join(r.cfg.TreeRoot, path)); err == nil {
+					formatted[path] = info
+				}
+			}
+		}
+	}
+}
+
+// watchDir adds dir and any directories beneath it to the watcher.
+func (r *Runner) watchDir(watcher *fsnotify.Watcher, dir string) error {
+	return filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		} else if !entry.IsDir() {
+			return nil
+		} else if slices.Contains(watchSkipDirs, entry.Name()) {
+			return filepath.SkipDir
+		}
+
+		if err = watcher.Add(path); err != nil {
+			return fmt.Errorf("failed to watch %s: %w", path, err)
+		}
+
+		return nil
+	})
+}
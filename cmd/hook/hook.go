@@ -0,0 +1,169 @@
+package hook
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// marker identifies hooks which were installed by treefmt, so they can be replaced or uninstalled safely.
+const marker = "# installed by treefmt install-hook"
+
+// scripts are the git hooks which can be installed, keyed by name.
+var scripts = map[string]string{
+	// the staged files are formatted and staged again, failing if any of them were changed so they can be reviewed
+	// before committing
+	"pre-commit": `#!/bin/sh
+` + marker + `, remove with: treefmt install-hook --uninstall pre-commit
+exec treefmt --staged --fail-on-change
+`,
+	// the whole tree is checked before pushing, without modifying it
+	"pre-push": `#!/bin/sh
+` + marker + `, remove with: treefmt install-hook --uninstall pre-push
+exec treefmt --check
+`,
+}
+
+func NewCommand() *cobra.Command {
+	var force, uninstall bool
+
+	cmd := &cobra.Command{
+		Use:   "install-hook [pre-commit|pre-push]",
+		Short: "Install a git hook which runs treefmt, defaulting to pre-commit",
+		Long: "Install a git hook which runs treefmt, defaulting to pre-commit. The pre-commit hook formats the staged " +
+			"files and stages them again, blocking the commit if any of them were changed so they can be reviewed. " +
+			"The pre-push hook blocks the push if any files in the tree are not formatted, without modifying them.",
+		Args:      cobra.MatchAll(cobra.MaximumNArgs(1), cobra.OnlyValidArgs),
+		ValidArgs: []string{"pre-commit", "pre-push"},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cmd.SilenceUsage = true
+
+			name := "pre-commit"
+			if len(args) > 0 {
+				name = args[0]
+			}
+
+			if uninstall {
+				return Uninstall(name)
+			}
+
+			return Install(name, force)
+		},
+	}
+
+	cmd.Flags().BoolVar(&force, "force", false, "Replace an existing hook which was not installed by treefmt.")
+	cmd.Flags().BoolVar(&uninstall, "uninstall", false, "Remove a hook previously installed by treefmt.")
+	cmd.MarkFlagsMutuallyExclusive("force", "uninstall")
+
+	return cmd
+}
+
+// Install writes the named hook into the hooks directory of the git repository containing the current directory.
+// An existing hook is only replaced if it was installed by treefmt, or force is true.
+func Install(name string, force bool) error {
+	script, ok := scripts[name]
+	if !ok {
+		return fmt.Errorf("unknown hook %s, must be one of <pre-commit|pre-push>", name)
+	}
+
+	path, err := hookPath(name)
+	if err != nil {
+		return err
+	}
+
+	if replaceable, err := canReplace(path); err != nil {
+		return err
+	} else if !replaceable && !force {
+		return fmt.Errorf("%s already exists, use --force to replace it", path)
+	}
+
+	if err = os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("failed to create hooks directory: %w", err)
+	}
+
+	if err = os.WriteFile(path, []byte(script), 0o755); err != nil { //nolint:gosec
+		return fmt.Errorf("failed to write %s hook: %w", name, err)
+	}
+
+	// the permissions of an existing file are not changed by WriteFile
+	if err = os.Chmod(path, 0o755); err != nil { //nolint:gosec
+		return fmt.Errorf("failed to make %s hook executable: %w", name, err)
+	}
+
+	fmt.Printf("Installed %s hook at %s\n", name, path)
+
+	return nil
+}
+
+// Uninstall removes the named hook, provided it was installed by treefmt.
+func Uninstall(name string) error {
+	if _, ok := scripts[name]; !ok {
+		return fmt.Errorf("unknown hook %s, must be one of <pre-commit|pre-push>", name)
+	}
+
+	path, err := hookPath(name)
+	if err != nil {
+		return err
+	}
+
+	if _, err = os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		fmt.Printf("No %s hook is installed\n", name)
+
+		return nil
+	}
+
+	if replaceable, err := canReplace(path); err != nil {
+		return err
+	} else if !replaceable {
+		return fmt.Errorf("%s was not installed by treefmt, so it has not been removed", path)
+	}
+
+	if err = os.Remove(path); err != nil {
+		return fmt.Errorf("failed to remove %s hook: %w", name, err)
+	}
+
+	fmt.Printf("Uninstalled %s hook from %s\n", name, path)
+
+	return nil
+}
+
+// hookPath returns the path of the named hook, respecting core.hooksPath and linked worktrees.
+func hookPath(name string) (string, error) {
+	var stdout, stderr bytes.Buffer
+
+	cmd := exec.Command("git", "rev-parse", "--git-path", "hooks/"+name)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf(
+			"failed to find git hooks directory, is this a git repository? %w: %s", err, strings.TrimSpace(stderr.String()),
+		)
+	}
+
+	// the path is relative to the current directory, unless core.hooksPath is absolute
+	path, err := filepath.Abs(strings.TrimSpace(stdout.String()))
+	if err != nil {
+		return "", fmt.Errorf("failed to get absolute path for %s hook: %w", name, err)
+	}
+
+	return path, nil
+}
+
+// canReplace reports whether the hook at path either does not exist or was installed by treefmt.
+func canReplace(path string) (bool, error) {
+	content, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return true, nil
+	} else if err != nil {
+		return false, fmt.Errorf("failed to read existing hook: %w", err)
+	}
+
+	return bytes.Contains(content, []byte(marker)), nil
+}
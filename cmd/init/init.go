@@ -2,8 +2,11 @@ package init
 
 import (
 	_ "embed"
+	"errors"
 	"fmt"
 	"os"
+
+	"github.com/spf13/cobra"
 )
 
 // We embed the sample toml file for use with the init flag.
@@ -20,3 +23,48 @@ func Run() error {
 
 	return nil
 }
+
+func NewCommand() *cobra.Command {
+	var force bool
+
+	cmd := &cobra.Command{
+		Use:   "init",
+		Short: "Generate a starter treefmt.toml based on the contents of the current directory",
+		Long: "Generate a starter treefmt.toml based on the contents of the current directory. " +
+			"A formatter is added for each language found in the tree. Formatters which can be found on the PATH " +
+			"are enabled, while the rest are commented out, ready to be enabled once they have been installed.",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			cmd.SilenceUsage = true
+
+			return Scaffold(force)
+		},
+	}
+
+	cmd.Flags().BoolVar(&force, "force", false, "Overwrite an existing treefmt.toml.")
+
+	return cmd
+}
+
+// Scaffold inspects the current directory and writes a starter treefmt.toml for it.
+// An existing treefmt.toml is only replaced if force is true.
+func Scaffold(force bool) error {
+	if _, err := os.Stat("treefmt.toml"); err == nil && !force {
+		return errors.New("treefmt.toml already exists, use --force to overwrite it")
+	} else if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to check for an existing treefmt.toml: %w", err)
+	}
+
+	contents, err := generate(".")
+	if err != nil {
+		return err
+	}
+
+	if err = os.WriteFile("treefmt.toml", contents, 0o600); err != nil {
+		return fmt.Errorf("failed to write treefmt.toml: %w", err)
+	}
+
+	fmt.Printf("Generated treefmt.toml from the contents of this directory. Review it before running treefmt.\n")
+
+	return nil
+}
@@ -0,0 +1,190 @@
+package init
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// tool describes how to invoke a formatter.
+type tool struct {
+	name    string
+	command string
+	options []string
+}
+
+// language describes the files belonging to a language, along with the formatters which can be used for it in order
+// of preference.
+type language struct {
+	name       string
+	extensions []string
+	tools      []tool
+}
+
+// languages is the catalogue of formatters which init knows how to configure.
+var languages = []language{
+	{"c", []string{".c", ".h", ".cc", ".cpp", ".hpp"}, []tool{
+		{"clang-format", "clang-format", []string{"-i"}},
+	}},
+	{"elm", []string{".elm"}, []tool{
+		{"elm-format", "elm-format", []string{"--yes"}},
+	}},
+	{"go", []string{".go"}, []tool{
+		{"gofumpt", "gofumpt", []string{"-w"}},
+		{"gofmt", "gofmt", []string{"-w"}},
+	}},
+	{"haskell", []string{".hs"}, []tool{
+		{"ormolu", "ormolu", []string{"--mode", "inplace"}},
+		{"fourmolu", "fourmolu", []string{"--mode", "inplace"}},
+	}},
+	{"java", []string{".java"}, []tool{
+		{"google-java-format", "google-java-format", []string{"--replace"}},
+	}},
+	{"lua", []string{".lua"}, []tool{
+		{"stylua", "stylua", nil},
+	}},
+	{"nix", []string{".nix"}, []tool{
+		{"nixfmt", "nixfmt", nil},
+		{"alejandra", "alejandra", nil},
+	}},
+	{"python", []string{".py", ".pyi"}, []tool{
+		{"ruff", "ruff", []string{"format"}},
+		{"black", "black", nil},
+	}},
+	{"ruby", []string{".rb"}, []tool{
+		{"rufo", "rufo", nil},
+	}},
+	{"rust", []string{".rs"}, []tool{
+		{"rustfmt", "rustfmt", []string{"--edition", "2021"}},
+	}},
+	{"shell", []string{".sh", ".bash"}, []tool{
+		{"shfmt", "shfmt", []string{"-w"}},
+	}},
+	{"terraform", []string{".tf"}, []tool{
+		{"terraform", "terraform", []string{"fmt"}},
+	}},
+	{"toml", []string{".toml"}, []tool{
+		{"taplo", "taplo", []string{"format"}},
+	}},
+	{"web", []string{".js", ".jsx", ".ts", ".tsx", ".css", ".scss", ".html", ".json", ".md", ".yaml", ".yml"}, []tool{
+		{"prettier", "prettier", []string{"--write"}},
+	}},
+	{"zig", []string{".zig"}, []tool{
+		{"zig", "zig", []string{"fmt"}},
+	}},
+}
+
+// skipDirs are directories which are not inspected when detecting the languages used within a tree.
+var skipDirs = []string{"node_modules", "vendor", "target"}
+
+// detectExtensions walks the tree at root, returning the set of file extensions found within it.
+// Hidden directories, such as .git, and common dependency directories are skipped.
+func detectExtensions(root string) (map[string]bool, error) {
+	extensions := make(map[string]bool)
+
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if d.IsDir() {
+			name := d.Name()
+			if path != root && (strings.HasPrefix(name, ".") || slices.Contains(skipDirs, name)) {
+				return filepath.SkipDir
+			}
+
+			return nil
+		}
+
+		if ext := filepath.Ext(d.Name()); ext != "" {
+			extensions[strings.ToLower(ext)] = true
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to inspect %s: %w", root, err)
+	}
+
+	return extensions, nil
+}
+
+// generate inspects the tree at root and generates a starter config, with a formatter for each language found.
+// Formatters whose command can be found on the PATH are enabled, while the rest are commented out.
+func generate(root string) ([]byte, error) {
+	extensions, err := detectExtensions(root)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+
+	buf.WriteString("# One CLI to format the code tree - https://github.com/numtide/treefmt\n")
+	buf.WriteString("# Generated by treefmt init, based on the files found in this tree\n")
+
+	for _, lang := range languages {
+		var includes []string
+
+		for _, ext := range lang.extensions {
+			if extensions[ext] {
+				includes = append(includes, "*"+ext)
+			}
+		}
+
+		if len(includes) == 0 {
+			continue
+		}
+
+		// prefer the first tool which is available
+		selected, found := lang.tools[0], false
+
+		for _, t := range lang.tools {
+			if _, err := exec.LookPath(t.command); err == nil {
+				selected, found = t, true
+
+				break
+			}
+		}
+
+		writeFormatter(&buf, lang, selected, includes, found)
+	}
+
+	return buf.Bytes(), nil
+}
+
+func writeFormatter(buf *bytes.Buffer, lang language, t tool, includes []string, enabled bool) {
+	prefix := ""
+
+	buf.WriteString("\n")
+
+	if enabled {
+		fmt.Fprintf(buf, "# %s\n", lang.name)
+	} else {
+		fmt.Fprintf(buf, "# %s: %s was not found on the PATH, uncomment once it has been installed\n", lang.name, t.command)
+
+		prefix = "# "
+	}
+
+	fmt.Fprintf(buf, "%s[formatter.%s]\n", prefix, t.name)
+	fmt.Fprintf(buf, "%scommand = %s\n", prefix, strconv.Quote(t.command))
+
+	if len(t.options) > 0 {
+		fmt.Fprintf(buf, "%soptions = %s\n", prefix, quoteList(t.options))
+	}
+
+	fmt.Fprintf(buf, "%sincludes = %s\n", prefix, quoteList(includes))
+}
+
+func quoteList(values []string) string {
+	quoted := make([]string, len(values))
+	for i, value := range values {
+		quoted[i] = strconv.Quote(value)
+	}
+
+	return "[" + strings.Join(quoted, ", ") + "]"
+}
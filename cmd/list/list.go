@@ -0,0 +1,221 @@
+package list
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	formatCmd "github.com/numtide/treefmt/v2/cmd/format"
+	"github.com/numtide/treefmt/v2/config"
+	"github.com/numtide/treefmt/v2/format"
+	"github.com/numtide/treefmt/v2/stats"
+	"github.com/numtide/treefmt/v2/walk"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"golang.org/x/sync/errgroup"
+)
+
+// Entry describes a configured formatter.
+type Entry struct {
+	Name string `json:"name"`
+	// Preset is the name of the built-in preset the formatter is based on, if any.
+	Preset string `json:"preset,omitempty"`
+	// Runner is the package runner used to invoke Command, if any.
+	Runner string `json:"runner,omitempty"`
+	// Container is the image within which Command is run, if any.
+	Container string `json:"container,omitempty"`
+	Command   string `json:"command"`
+	// Path is the resolved path to Command, or to Runner or the container engine if set, empty if it could not be
+	// found.
+	Path string `json:"path,omitempty"`
+	// Version is the first line output by Command when invoked with its version-probe, empty if it has none or the
+	// version could not be determined.
+	Version  string   `json:"version,omitempty"`
+	Includes []string `json:"includes,omitempty"`
+	Excludes []string `json:"excludes,omitempty"`
+	Tags     []string `json:"tags,omitempty"`
+	Priority int      `json:"priority"`
+	// Selected indicates whether the formatter will be applied, given the values of --formatters and --tags.
+	Selected bool `json:"selected"`
+	// Enabled indicates whether the formatter's enabled-if condition, if any, holds in this environment.
+	Enabled bool `json:"enabled"`
+}
+
+func NewCommand(v *viper.Viper) *cobra.Command {
+	return &cobra.Command{
+		Use:   "list",
+		Short: "List the configured formatters",
+		Long: "List the configured formatters, along with the path and version of their command, their includes, " +
+			"excludes, tags and priority, whether they are selected by --formatters and --tags, and whether they are enabled by their " +
+			"enabled-if condition. " +
+			"Use --output json for output which is suitable for tooling.",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			cmd.SilenceUsage = true
+
+			return Run(v)
+		},
+	}
+}
+
+func Run(v *viper.Viper) error {
+	cfg, err := config.FromViper(v)
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+
+	output, err := formatCmd.OutputString(cfg.Output)
+	if err != nil {
+		return fmt.Errorf("invalid output format: %w", err)
+	}
+
+	entries, err := Entries(v, cfg)
+	if err != nil {
+		return err
+	}
+
+	if output == formatCmd.JSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+
+		if err = encoder.Encode(entries); err != nil {
+			return fmt.Errorf("failed to encode formatters: %w", err)
+		}
+
+		return nil
+	}
+
+	printEntries(entries)
+
+	return nil
+}
+
+// Entries describes every formatter in the config, including those which are not selected by --formatters.
+func Entries(v *viper.Viper, cfg *config.Config) ([]*Entry, error) {
+	// cfg only contains the selected formatters, so we read them all from the config file
+	var all map[string]*config.Formatter
+	if err := v.UnmarshalKey("formatter", &all); err != nil {
+		return nil, fmt.Errorf("failed to read formatters: %w", err)
+	}
+
+	// resolve each formatter's command in the same way as when formatting, without failing on a missing command
+	listCfg := *cfg
+	listCfg.FormatterConfigs = all
+	listCfg.AllowMissingFormatter = true
+	listCfg.Check = false
+	listCfg.CacheRemote = ""
+	// versions are probed below, rather than for the cache signature
+	listCfg.NoCache = true
+
+	statz := stats.New()
+
+	composite, err := format.NewCompositeFormatter(&listCfg, &statz, walk.BatchSize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create composite formatter: %w", err)
+	}
+
+	formatters := make(map[string]*format.Formatter)
+	executables := make(map[string]string)
+
+	for _, f := range composite.Formatters() {
+		formatters[f.Name()] = f
+		executables[f.Name()] = f.Executable()
+	}
+
+	entries := make([]*Entry, 0, len(all))
+
+	for name, formatterCfg := range all {
+		entries = append(entries, &Entry{
+			Name:      name,
+			Preset:    formatterCfg.Preset,
+			Runner:    formatterCfg.Runner,
+			Container: formatterCfg.Container,
+			Command:   formatterCfg.Command,
+			Path:      executables[name],
+			Includes:  formatterCfg.Includes,
+			Excludes:  formatterCfg.Excludes,
+			Tags:      formatterCfg.Tags,
+			Priority:  formatterCfg.Priority,
+			Selected:  cfg.Selection.Contains(name, formatterCfg),
+			Enabled:   !slices.Contains(cfg.DisabledFormatters, name),
+		})
+	}
+
+	slices.SortFunc(entries, func(a, b *Entry) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+
+	// probing for versions can be slow, so we do it concurrently
+	eg := &errgroup.Group{}
+
+	for _, entry := range entries {
+		formatter, ok := formatters[entry.Name]
+		if !ok || entry.Path == "" {
+			continue
+		}
+
+		eg.Go(func() error {
+			entry.Version = formatter.Version()
+
+			return nil
+		})
+	}
+
+	// failing to determine a version is not an error, so there is nothing to check
+	_ = eg.Wait()
+
+	return entries, nil
+}
+
+func printEntries(entries []*Entry) {
+	for i, entry := range entries {
+		if i > 0 {
+			fmt.Println()
+		}
+
+		fmt.Println(entry.Name)
+
+		if entry.Preset != "" {
+			fmt.Printf("  preset:   %s\n", entry.Preset)
+		}
+
+		if entry.Runner != "" {
+			fmt.Printf("  runner:   %s\n", entry.Runner)
+		}
+
+		if entry.Container != "" {
+			fmt.Printf("  image:    %s\n", entry.Container)
+		}
+
+		if entry.Path == "" {
+			fmt.Printf("  command:  %s (not found)\n", entry.Command)
+		} else {
+			fmt.Printf("  command:  %s (%s)\n", entry.Command, entry.Path)
+		}
+
+		if entry.Version != "" {
+			fmt.Printf("  version:  %s\n", entry.Version)
+		} else {
+			fmt.Println("  version:  unknown")
+		}
+
+		fmt.Printf("  includes: %s\n", strings.Join(entry.Includes, " "))
+
+		if len(entry.Excludes) > 0 {
+			fmt.Printf("  excludes: %s\n", strings.Join(entry.Excludes, " "))
+		}
+
+		if len(entry.Tags) > 0 {
+			fmt.Printf("  tags:     %s\n", strings.Join(entry.Tags, " "))
+		}
+
+		fmt.Printf("  priority: %d\n", entry.Priority)
+		fmt.Printf("  selected: %t\n", entry.Selected)
+
+		if !entry.Enabled {
+			fmt.Println("  enabled:  false (enabled-if)")
+		}
+	}
+}
@@ -0,0 +1,403 @@
+package lsp
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"unicode/utf16"
+
+	"github.com/charmbracelet/log"
+	"github.com/numtide/treefmt/v2/build"
+	"github.com/numtide/treefmt/v2/config"
+	"github.com/numtide/treefmt/v2/runner"
+	"github.com/numtide/treefmt/v2/stats"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// Error codes defined by JSON-RPC and the Language Server Protocol.
+const (
+	codeParseError     = -32700
+	codeInvalidParams  = -32602
+	codeMethodNotFound = -32601
+	codeRequestFailed  = -32803
+)
+
+// textDocumentSyncFull indicates documents are synced by always sending their full content.
+const textDocumentSyncFull = 1
+
+var ErrExitWithoutShutdown = errors.New("received exit notification before shutdown request")
+
+func NewCommand(v *viper.Viper) *cobra.Command {
+	return &cobra.Command{
+		Use:   "lsp",
+		Short: "Run a language server providing document formatting",
+		Long: "Run a language server over stdin and stdout, allowing any editor with LSP support to format " +
+			"buffers using the formatters configured in treefmt.toml.\n\nThe server implements the " +
+			"textDocument/formatting and textDocument/rangeFormatting requests. Formatters operate on entire " +
+			"files, so a range formatting request will format the whole document.",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			return Run(v, cmd)
+		},
+	}
+}
+
+func Run(v *viper.Viper, cmd *cobra.Command) error {
+	cmd.SilenceUsage = true
+
+	cfg, err := config.FromViper(v)
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	} else if cfg.Stdin {
+		return fmt.Errorf("the language server cannot be used with the --stdin flag")
+	}
+
+	// buffers are not backed by files in the tree, so there is no need for the cache
+	r, err := runner.New(cfg, nil)
+	if err != nil {
+		return err
+	}
+
+	// create the formatters up front, rather than on the first request, validating their config
+	if err = r.LoadFormatters(); err != nil {
+		return err
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	s := server{
+		cfg:       cfg,
+		runner:    r,
+		documents: make(map[string]string),
+		writer:    os.Stdout,
+	}
+
+	return s.serve(ctx, os.Stdin)
+}
+
+type message struct {
+	JSONRPC string           `json:"jsonrpc"`
+	ID      *json.RawMessage `json:"id,omitempty"`
+	Method  string           `json:"method"`
+	Params  json.RawMessage  `json:"params,omitempty"`
+}
+
+type responseError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type position struct {
+	Line      int `json:"line"`
+	Character int `json:"character"`
+}
+
+type textRange struct {
+	Start position `json:"start"`
+	End   position `json:"end"`
+}
+
+type textEdit struct {
+	Range   textRange `json:"range"`
+	NewText string    `json:"newText"`
+}
+
+type textDocumentIdentifier struct {
+	URI string `json:"uri"`
+}
+
+type didOpenParams struct {
+	TextDocument struct {
+		URI  string `json:"uri"`
+		Text string `json:"text"`
+	} `json:"textDocument"`
+}
+
+type didChangeParams struct {
+	TextDocument   textDocumentIdentifier `json:"textDocument"`
+	ContentChanges []struct {
+		Text string `json:"text"`
+	} `json:"contentChanges"`
+}
+
+type documentParams struct {
+	TextDocument textDocumentIdentifier `json:"textDocument"`
+}
+
+type server struct {
+	cfg    *config.Config
+	runner *runner.Runner
+	writer io.Writer
+
+	// documents tracks the content of open documents, keyed by uri
+	documents map[string]string
+
+	shutdown bool
+}
+
+// serve reads messages from reader until the client sends an exit notification or closes the stream.
+func (s *server) serve(ctx context.Context, reader io.Reader) error {
+	buf := bufio.NewReader(reader)
+
+	for {
+		content, err := readMessage(buf)
+		if errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		var msg message
+		if err = json.Unmarshal(content, &msg); err != nil {
+			if err = s.reply(nil, nil, &responseError{codeParseError, err.Error()}); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		if msg.Method == "exit" {
+			if !s.shutdown {
+				return ErrExitWithoutShutdown
+			}
+
+			return nil
+		}
+
+		result, resErr := s.handle(ctx, &msg)
+
+		// notifications do not receive a response
+		if msg.ID == nil {
+			if resErr != nil {
+				log.Errorf("failed to handle %s: %s", msg.Method, resErr.Message)
+			}
+
+			continue
+		}
+
+		if err = s.reply(msg.ID, result, resErr); err != nil {
+			return err
+		}
+	}
+}
+
+// handle processes a single request or notification, returning the result to be sent to the client.
+func (s *server) handle(ctx context.Context, msg *message) (any, *responseError) {
+	log.Debugf("received %s", msg.Method)
+
+	switch msg.Method {
+	case "initialize":
+		return map[string]any{
+			"capabilities": map[string]any{
+				"textDocumentSync":                textDocumentSyncFull,
+				"documentFormattingProvider":      true,
+				"documentRangeFormattingProvider": true,
+			},
+			"serverInfo": map[string]any{
+				"name":    build.Name,
+				"version": build.Version,
+			},
+		}, nil
+
+	case "shutdown":
+		s.shutdown = true
+
+		return nil, nil
+
+	case "textDocument/didOpen":
+		var params didOpenParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, &responseError{codeInvalidParams, err.Error()}
+		}
+
+		s.documents[params.TextDocument.URI] = params.TextDocument.Text
+
+		return nil, nil
+
+	case "textDocument/didChange":
+		var params didChangeParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, &responseError{codeInvalidParams, err.Error()}
+		}
+
+		// we only support full document sync, so the last change contains the entire document
+		if n := len(params.ContentChanges); n > 0 {
+			s.documents[params.TextDocument.URI] = params.ContentChanges[n-1].Text
+		}
+
+		return nil, nil
+
+	case "textDocument/didClose":
+		var params documentParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, &responseError{codeInvalidParams, err.Error()}
+		}
+
+		delete(s.documents, params.TextDocument.URI)
+
+		return nil, nil
+
+	case "textDocument/formatting", "textDocument/rangeFormatting":
+		var params documentParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, &responseError{codeInvalidParams, err.Error()}
+		}
+
+		edits, err := s.format(ctx, params.TextDocument.URI)
+		if err != nil {
+			return nil, &responseError{codeRequestFailed, err.Error()}
+		}
+
+		return edits, nil
+
+	case "initialized", "$/cancelRequest", "$/setTrace":
+		return nil, nil
+
+	default:
+		return nil, &responseError{codeMethodNotFound, fmt.Sprintf("method not found: %s", msg.Method)}
+	}
+}
+
+// format runs the document identified by uri through the formatters, returning the edits required to update it.
+func (s *server) format(ctx context.Context, uri string) ([]textEdit, error) {
+	path, err := uriToPath(uri)
+	if err != nil {
+		return nil, err
+	}
+
+	text, ok := s.documents[uri]
+	if !ok {
+		// fallback to the file on disk if the client has not opened the document
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", path, err)
+		}
+
+		text = string(content)
+	}
+
+	var output bytes.Buffer
+
+	statz := stats.New()
+
+	err = s.runner.FormatBuffer(ctx, &statz, path, strings.NewReader(text), &output)
+	if err != nil && !errors.Is(err, runner.ErrFailOnChange) {
+		return nil, fmt.Errorf("failed to format %s: %w", path, err)
+	}
+
+	formatted := output.String()
+	if formatted == text {
+		return []textEdit{}, nil
+	}
+
+	// formatters operate on entire files, so we replace the whole document
+	return []textEdit{{
+		Range: textRange{
+			Start: position{Line: 0, Character: 0},
+			End:   endPosition(text),
+		},
+		NewText: formatted,
+	}}, nil
+}
+
+// reply writes a response to the client.
+func (s *server) reply(id *json.RawMessage, result any, resErr *responseError) error {
+	res := map[string]any{
+		"jsonrpc": "2.0",
+		"id":      id,
+	}
+
+	if resErr != nil {
+		res["error"] = resErr
+	} else {
+		res["result"] = result
+	}
+
+	content, err := json.Marshal(res)
+	if err != nil {
+		return fmt.Errorf("failed to encode response: %w", err)
+	}
+
+	if _, err = fmt.Fprintf(s.writer, "Content-Length: %d\r\n\r\n%s", len(content), content); err != nil {
+		return fmt.Errorf("failed to write response: %w", err)
+	}
+
+	return nil
+}
+
+// readMessage reads the headers and content of the next message from reader.
+func readMessage(reader *bufio.Reader) ([]byte, error) {
+	length := -1
+
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			if errors.Is(err, io.EOF) && line == "" {
+				return nil, io.EOF
+			}
+
+			return nil, fmt.Errorf("failed to read message header: %w", err)
+		}
+
+		line = strings.TrimSpace(line)
+		if line == "" {
+			// a blank line marks the end of the headers
+			break
+		}
+
+		name, value, ok := strings.Cut(line, ":")
+		if ok && strings.EqualFold(name, "Content-Length") {
+			if length, err = strconv.Atoi(strings.TrimSpace(value)); err != nil {
+				return nil, fmt.Errorf("invalid Content-Length header %q: %w", value, err)
+			}
+		}
+	}
+
+	if length < 0 {
+		return nil, fmt.Errorf("message is missing a Content-Length header")
+	}
+
+	content := make([]byte, length)
+	if _, err := io.ReadFull(reader, content); err != nil {
+		return nil, fmt.Errorf("failed to read message content: %w", err)
+	}
+
+	return content, nil
+}
+
+// uriToPath converts a file uri into a local path.
+func uriToPath(uri string) (string, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse uri %s: %w", uri, err)
+	} else if u.Scheme != "file" {
+		return "", fmt.Errorf("unsupported uri scheme %s, only file uris are supported", u.Scheme)
+	}
+
+	return filepath.FromSlash(u.Path), nil
+}
+
+// endPosition returns the position at the end of text, with characters measured in UTF-16 code units as required
+// by the protocol.
+func endPosition(text string) position {
+	line := strings.Count(text, "\n")
+	last := text[strings.LastIndex(text, "\n")+1:]
+
+	return position{
+		Line:      line,
+		Character: len(utf16.Encode([]rune(last))),
+	}
+}
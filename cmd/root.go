@@ -6,10 +6,24 @@ import (
 	"path/filepath"
 
 	"github.com/charmbracelet/log"
+	"github.com/muesli/termenv"
 	"github.com/numtide/treefmt/v2/build"
-	"github.com/numtide/treefmt/v2/cmd/format"
+	"github.com/numtide/treefmt/v2/cmd/bench"
+	"github.com/numtide/treefmt/v2/cmd/cache"
+	configCmd "github.com/numtide/treefmt/v2/cmd/config"
+	"github.com/numtide/treefmt/v2/cmd/daemon"
+	"github.com/numtide/treefmt/v2/cmd/docs"
+	"github.com/numtide/treefmt/v2/cmd/doctor"
+	"github.com/numtide/treefmt/v2/cmd/explain"
+	formatCmd "github.com/numtide/treefmt/v2/cmd/format"
+	"github.com/numtide/treefmt/v2/cmd/hook"
 	_init "github.com/numtide/treefmt/v2/cmd/init"
+	"github.com/numtide/treefmt/v2/cmd/list"
+	"github.com/numtide/treefmt/v2/cmd/lsp"
+	versionCmd "github.com/numtide/treefmt/v2/cmd/version"
 	"github.com/numtide/treefmt/v2/config"
+	"github.com/numtide/treefmt/v2/format"
+	"github.com/numtide/treefmt/v2/logfile"
 	"github.com/numtide/treefmt/v2/stats"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
@@ -32,18 +46,17 @@ func NewRoot() (*cobra.Command, *stats.Stats) {
 
 	// create out root command
 	cmd := &cobra.Command{
-		Use:     fmt.Sprintf("%s <paths...>", build.Name),
-		Short:   "One CLI to format your repo",
-		Version: build.Version,
+		Use:   fmt.Sprintf("%s <paths...>", build.Name),
+		Short: "One CLI to format your repo",
+		// we accept arbitrary paths as well as subcommands
+		Args: cobra.ArbitraryArgs,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			return runE(v, &statz, cmd, args)
 		},
 	}
 
-	// update version template
-	cmd.SetVersionTemplate("treefmt {{.Version}}")
-
-	fs := cmd.Flags()
+	// config flags are persistent, so they can be used with any subcommands which also load the config
+	fs := cmd.PersistentFlags()
 
 	// add our config flags to the command's flag set
 	config.SetFlags(fs)
@@ -56,16 +69,22 @@ func NewRoot() (*cobra.Command, *stats.Stats) {
 	// add a couple of special flags which don't have a corresponding entry in treefmt.toml
 	fs.StringVar(
 		&configFile, "config-file", "",
-		"Load the config file from the given path (defaults to searching upwards for treefmt.toml or "+
-			".treefmt.toml).",
+		"Load the config file from the given path (defaults to searching upwards for treefmt.toml, "+
+			"treefmt.yaml, treefmt.yml or treefmt.json, optionally prefixed with a '.').",
 	)
-	fs.BoolVarP(
+	cmd.Flags().BoolVarP(
 		&treefmtInit, "init", "i", false,
 		"Create a treefmt.toml file in the current directory.",
 	)
+	// handled by runE rather than cobra, so that formatters can be probed with --verbose
+	cmd.Flags().Bool(
+		"version", false,
+		"Print the version of treefmt and how it was built. With --verbose, also probe the version of each "+
+			"formatter with a version-probe, e.g. to record the environment of a CI run with --output json.",
+	)
 
 	// bind our command's flags to viper
-	if err := v.BindPFlags(fs); err != nil {
+	if err := config.BindFlags(v, fs); err != nil {
 		cobra.CheckErr(fmt.Errorf("failed to bind global config to viper: %w", err))
 	}
 
@@ -73,81 +92,218 @@ func NewRoot() (*cobra.Command, *stats.Stats) {
 	// conforms with https://github.com/numtide/prj-spec/blob/main/PRJ_SPEC.md
 	cobra.CheckErr(v.BindPFlag("prj_root", fs.Lookup("tree-root")))
 
+	// add subcommands which operate on the config, ensuring the config is loaded in the same way as the root command
+	// the hook is persistent, so it also applies to any subcommands of their own
+	for _, sub := range []*cobra.Command{
+		bench.NewCommand(v),
+		cache.NewCommand(v),
+		daemon.NewCommand(v),
+		doctor.NewCommand(v),
+		explain.NewCommand(v),
+		list.NewCommand(v),
+		lsp.NewCommand(v),
+	} {
+		sub.PersistentPreRunE = func(cmd *cobra.Command, _ []string) error {
+			return loadConfig(v, cmd)
+		}
+
+		cmd.AddCommand(sub)
+	}
+
+	// init generates the config, config validates it and install-hook writes a git hook, so they only need to respect
+	// --working-dir
+	for _, sub := range []*cobra.Command{
+		_init.NewCommand(),
+		configCmd.NewCommand(),
+		docs.NewCommand(),
+		hook.NewCommand(),
+	} {
+		sub.PersistentPreRunE = func(_ *cobra.Command, _ []string) error {
+			return changeWorkingDir(v)
+		}
+
+		cmd.AddCommand(sub)
+	}
+
+	registerCompletions(v, cmd)
+	markUsageErrors(cmd)
+
 	return cmd, &statz
 }
 
 func runE(v *viper.Viper, statz *stats.Stats, cmd *cobra.Command, args []string) error {
 	flags := cmd.Flags()
 
-	// change working directory if required
-	workingDir, err := filepath.Abs(v.GetString("working-dir"))
-	if err != nil {
-		return fmt.Errorf("failed to get absolute path for working directory: %w", err)
-	} else if err = os.Chdir(workingDir); err != nil {
-		return fmt.Errorf("failed to change working directory: %w", err)
-	}
-
 	// check if we are running the init command
 	if init, err := flags.GetBool("init"); err != nil {
 		return fmt.Errorf("failed to read init flag: %w", err)
 	} else if init {
+		if err = changeWorkingDir(v); err != nil {
+			return err
+		}
+
 		return _init.Run()
 	}
 
+	// the config file is only needed to probe the formatters for their versions
+	if version, err := flags.GetBool("version"); err != nil {
+		return fmt.Errorf("failed to read version flag: %w", err)
+	} else if version {
+		if v.GetInt("verbose") > 0 {
+			if err = loadConfig(v, cmd); err != nil {
+				return err
+			}
+		}
+
+		return versionCmd.Run(v)
+	}
+
 	// otherwise attempt to load the config file
+	if err := loadConfig(v, cmd); err != nil {
+		return err
+	}
 
-	// use the path specified by the flag
-	configFile, err := flags.GetString("config-file")
+	// format
+	return formatCmd.Run(v, statz, cmd, args)
+}
+
+// changeWorkingDir changes the working directory if requested with --working-dir.
+func changeWorkingDir(v *viper.Viper) error {
+	workingDir, err := filepath.Abs(v.GetString("working-dir"))
 	if err != nil {
-		return fmt.Errorf("failed to read config-file flag: %w", err)
+		return fmt.Errorf("failed to get absolute path for working directory: %w", err)
+	} else if err = os.Chdir(workingDir); err != nil {
+		return fmt.Errorf("failed to change working directory: %w", err)
 	}
 
-	// fallback to env
-	if configFile == "" {
-		configFile = os.Getenv("TREEFMT_CONFIG")
+	return nil
+}
+
+// loadConfig changes the working directory if required, searches for the config file and reads it into v before
+// configuring logging.
+func loadConfig(v *viper.Viper, cmd *cobra.Command) error {
+	flags := cmd.Flags()
+
+	// change working directory if required
+	if err := changeWorkingDir(v); err != nil {
+		return err
 	}
 
-	filenames := []string{"treefmt.toml", ".treefmt.toml"}
+	workingDir, err := os.Getwd()
+	if err != nil {
+		return fmt.Errorf("failed to get working directory: %w", err)
+	}
 
-	// look in PRJ_ROOT if set
-	if prjRoot := os.Getenv("PRJ_ROOT"); configFile == "" && prjRoot != "" {
-		configFile, _ = config.Find(prjRoot, filenames...)
+	// when quiet, logging is restricted before reading the config file so that locating it isn't logged either
+	if v.GetBool("quiet") {
+		log.SetLevel(log.ErrorLevel)
 	}
 
-	// search up from the working directory
-	if configFile == "" {
-		configFile, _, err = config.FindUp(workingDir, filenames...)
+	// likewise, color is configured so that locating the config file respects --color and $TREEFMT_COLOR
+	if _, err = configureColor(v); err != nil {
+		cmd.SilenceUsage = true
+
+		return formatCmd.FailureConfig.Wrap(err)
 	}
 
-	// error out if we couldn't find the config file
+	// use the path specified by the flag
+	configFile, err := flags.GetString("config-file")
 	if err != nil {
+		return fmt.Errorf("failed to read config-file flag: %w", err)
+	}
+
+	// error out if we couldn't find the config file
+	if configFile, err = config.Locate(configFile, workingDir); err != nil {
 		cmd.SilenceUsage = true
 
-		return fmt.Errorf("failed to find treefmt config file: %w", err)
+		return formatCmd.FailureConfig.Errorf("failed to find treefmt config file: %w", err)
 	}
 
 	log.Infof("using config file: %s", configFile)
 
 	// read in the config
 	v.SetConfigFile(configFile)
+	v.SetConfigType(config.FileType(configFile))
 
 	if err := v.ReadInConfig(); err != nil {
-		cobra.CheckErr(fmt.Errorf("failed to read config file '%s': %w", configFile, err))
+		cmd.SilenceUsage = true
+
+		return formatCmd.FailureConfig.Errorf("failed to read config file '%s': %w", configFile, err)
+	}
+
+	// merge any config files it imports
+	if err := config.ResolveImports(v); err != nil {
+		cmd.SilenceUsage = true
+
+		return formatCmd.FailureConfig.Errorf("failed to resolve config imports: %w", err)
+	}
+
+	// configure logging, resolving color again in case it was set in the config file
+	color, err := configureColor(v)
+	if err != nil {
+		cmd.SilenceUsage = true
+
+		return formatCmd.FailureConfig.Wrap(err)
 	}
 
-	// configure logging
-	log.SetOutput(os.Stderr)
 	log.SetReportTimestamp(false)
 
-	switch v.GetInt("verbose") {
-	case 0:
-		log.SetLevel(log.WarnLevel)
-	case 1:
-		log.SetLevel(log.InfoLevel)
+	var level log.Level
+
+	switch verbosity := v.GetInt("verbose"); {
+	case v.GetBool("quiet"):
+		level = log.ErrorLevel
+	case verbosity == 0:
+		level = log.WarnLevel
+	case verbosity == 1:
+		level = log.InfoLevel
 	default:
-		log.SetLevel(log.DebugLevel)
+		level = log.DebugLevel
 	}
 
-	// format
-	return format.Run(v, statz, cmd, args)
+	log.SetLevel(level)
+
+	if logFile := v.GetString("log-file"); logFile != "" {
+		if err := openLogFile(logFile, v.GetString("log-file-max-size"), level, color); err != nil {
+			cmd.SilenceUsage = true
+
+			return formatCmd.FailureConfig.Wrap(err)
+		}
+	}
+
+	return nil
+}
+
+// configureColor directs logging to stderr, styled according to the color mode, returning the resolved color profile.
+func configureColor(v *viper.Viper) (termenv.Profile, error) {
+	color, err := formatCmd.ColorProfile(v.GetString("color"), os.Stderr)
+	if err != nil {
+		return color, err
+	}
+
+	log.SetOutput(formatCmd.Console)
+	log.SetColorProfile(color)
+
+	return color, nil
+}
+
+// openLogFile duplicates log output to path, recording everything at debug level whilst the console continues to only
+// receive entries at or above level, styled according to color.
+func openLogFile(path string, maxSize string, level log.Level, color termenv.Profile) error {
+	size, err := format.ParseSize(maxSize)
+	if err != nil {
+		return fmt.Errorf("invalid log-file-max-size: %w", err)
+	}
+
+	writer, err := logfile.Open(path, size, formatCmd.Console, level)
+	if err != nil {
+		return err
+	}
+
+	// the console is written to via the log file, so we must retain the styling it would otherwise have received
+	log.SetOutput(writer)
+	log.SetColorProfile(color)
+	log.SetLevel(log.DebugLevel)
+
+	return nil
 }
@@ -3,29 +3,57 @@ package cmd_test
 import (
 	"bufio"
 	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
 	"fmt"
 	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"os/exec"
 	"path"
 	"path/filepath"
 	"regexp"
+	"runtime"
+	"slices"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/BurntSushi/toml"
 	"github.com/charmbracelet/log"
+	"github.com/numtide/treefmt/v2/build"
 	"github.com/numtide/treefmt/v2/cmd"
+	"github.com/numtide/treefmt/v2/cmd/bench"
+	configCmd "github.com/numtide/treefmt/v2/cmd/config"
+	"github.com/numtide/treefmt/v2/cmd/daemon"
+	"github.com/numtide/treefmt/v2/cmd/doctor"
 	formatCmd "github.com/numtide/treefmt/v2/cmd/format"
 	"github.com/numtide/treefmt/v2/config"
 	"github.com/numtide/treefmt/v2/format"
+	"github.com/numtide/treefmt/v2/runner"
+	"github.com/numtide/treefmt/v2/sandbox"
 	"github.com/numtide/treefmt/v2/stats"
 	"github.com/numtide/treefmt/v2/test"
 	"github.com/numtide/treefmt/v2/walk"
+	"github.com/numtide/treefmt/v2/walk/cache"
 	cp "github.com/otiai10/copy"
+	"github.com/spf13/cobra"
 	"github.com/stretchr/testify/require"
 )
 
+func TestMain(m *testing.M) {
+	// formatters sandboxed with landlock are started by the test binary, in place of treefmt
+	sandbox.Exec()
+
+	os.Exit(m.Run())
+}
+
 func TestOnUnmatched(t *testing.T) {
 	as := require.New(t)
 
@@ -131,6 +159,73 @@ func TestOnUnmatched(t *testing.T) {
 	})
 }
 
+func TestOnUnmatchedFailWithList(t *testing.T) {
+	as := require.New(t)
+
+	tempDir := test.TempExamples(t)
+	configPath := filepath.Join(tempDir, "treefmt.toml")
+	unmatchedPath := filepath.Join(t.TempDir(), "unmatched.txt")
+
+	test.ChangeWorkDir(t, tempDir)
+
+	cfg := &config.Config{
+		OnUnmatched: "fail-with-list",
+		Excludes:    []string{"*.toml", "elm/*", "haskell*/*", "html/*", "nix/*", "rust/*", "terraform/*", "yaml/*"},
+		FormatterConfigs: map[string]*config.Formatter{
+			"echo": {
+				Command:  "echo",
+				Includes: []string{"*.go", "*.py", "*.js", "*.rb", "*.sh"},
+			},
+		},
+	}
+
+	// rather than logging each path, they are listed together once formatting has completed
+	treefmt(t,
+		withConfig(configPath, cfg),
+		withError(func(err error) {
+			as.ErrorIs(err, runner.ErrUnmatched)
+			as.ErrorContains(err, "2 path(s) unmatched")
+		}),
+		withStats(t, map[stats.Type]int{
+			stats.Matched:   6,
+			stats.Formatted: 6,
+		}),
+		withOutput(func(out []byte) {
+			as.NotContains(string(out), "no formatter for path")
+			as.Contains(string(out), "\n2 path(s) without a formatter:\n  go/go.mod\n  python/requirements.txt\n")
+		}),
+	)
+
+	// or written to a file instead
+	treefmt(t,
+		withArgs("--unmatched-file", unmatchedPath),
+		withConfig(configPath, cfg),
+		withError(func(err error) {
+			as.ErrorIs(err, runner.ErrUnmatched)
+		}),
+		withOutput(func(out []byte) {
+			as.NotContains(string(out), "path(s) without a formatter:")
+		}),
+	)
+
+	content, err := os.ReadFile(unmatchedPath)
+	as.NoError(err)
+	as.Equal("go/go.mod\npython/requirements.txt\n", string(content))
+
+	// once every path has a formatter, the file is emptied and there is no error
+	cfg.FormatterConfigs["echo"].Includes = append(cfg.FormatterConfigs["echo"].Includes, "*.mod", "*.txt")
+
+	treefmt(t,
+		withArgs("--unmatched-file", unmatchedPath),
+		withConfig(configPath, cfg),
+		withNoError(t),
+	)
+
+	content, err = os.ReadFile(unmatchedPath)
+	as.NoError(err)
+	as.Empty(content)
+}
+
 func TestCpuProfile(t *testing.T) {
 	as := require.New(t)
 	tempDir := test.TempExamples(t)
@@ -155,6 +250,48 @@ func TestCpuProfile(t *testing.T) {
 	as.FileExists(filepath.Join(tempDir, "env.pprof"))
 }
 
+func TestProfiles(t *testing.T) {
+	as := require.New(t)
+	tempDir := test.TempExamples(t)
+
+	test.ChangeWorkDir(t, tempDir)
+
+	// allow missing formatter
+	t.Setenv("TREEFMT_ALLOW_MISSING_FORMATTER", "true")
+
+	treefmt(t,
+		withArgs(
+			"--cpu-profile", "cpu.pprof",
+			"--mem-profile", "mem.pprof",
+			"--block-profile", "block.pprof",
+			"--mutex-profile", "mutex.pprof",
+		),
+		withNoError(t),
+	)
+
+	// each profile is written in the gzipped protobuf format understood by go tool pprof
+	for _, name := range []string{"cpu.pprof", "mem.pprof", "block.pprof", "mutex.pprof"} {
+		content, err := os.ReadFile(filepath.Join(tempDir, name))
+		as.NoError(err)
+		as.True(bytes.HasPrefix(content, []byte{0x1f, 0x8b}), "%s should be gzipped", name)
+	}
+
+	// test with env
+	t.Setenv("TREEFMT_MEM_PROFILE", "env.pprof")
+
+	treefmt(t, withNoError(t))
+
+	as.FileExists(filepath.Join(tempDir, "env.pprof"))
+
+	// the files are created before formatting, so a bad path is reported up front
+	treefmt(t,
+		withArgs("--block-profile", filepath.Join(tempDir, "missing", "block.pprof")),
+		withError(func(err error) {
+			as.ErrorContains(err, "failed to open file for writing block profile")
+		}),
+	)
+}
+
 func TestAllowMissingFormatter(t *testing.T) {
 	as := require.New(t)
 
@@ -202,16 +339,19 @@ func TestSpecifyingFormatters(t *testing.T) {
 				Command:  "test-fmt-append",
 				Options:  []string{"   "},
 				Includes: []string{"*.elm"},
+				Tags:     []string{"fast", "web"},
 			},
 			"nix": {
 				Command:  "test-fmt-append",
 				Options:  []string{"   "},
 				Includes: []string{"*.nix"},
+				Tags:     []string{"fast"},
 			},
 			"ruby": {
 				Command:  "test-fmt-append",
 				Options:  []string{"   "},
 				Includes: []string{"*.rb"},
+				Tags:     []string{"slow"},
 			},
 		},
 	}
@@ -281,6 +421,150 @@ func TestSpecifyingFormatters(t *testing.T) {
 		)
 	})
 
+	t.Run("globs", func(t *testing.T) {
+		treefmt(t,
+			withArgs("--formatters", "[en]*"),
+			withModtimeBump(tempDir, time.Second),
+			withNoError(t),
+			withStats(t, map[stats.Type]int{
+				stats.Traversed: 32,
+				stats.Matched:   2,
+				stats.Formatted: 2,
+				stats.Changed:   2,
+			}),
+		)
+
+		// a glob which matches no formatters
+		treefmt(t,
+			withArgs("--formatters", "go*"),
+			withError(func(err error) {
+				as.ErrorContains(err, "formatter go* not found in config")
+			}),
+		)
+	})
+
+	t.Run("negation", func(t *testing.T) {
+		treefmt(t,
+			withArgs("--formatters", "!elm"),
+			withModtimeBump(tempDir, time.Second),
+			withNoError(t),
+			withStats(t, map[stats.Type]int{
+				stats.Traversed: 32,
+				stats.Matched:   2,
+				stats.Formatted: 2,
+				stats.Changed:   2,
+			}),
+		)
+
+		// combined with a glob
+		treefmt(t,
+			withArgs("--formatters", "*", "--formatters", "!elm", "--formatters", "!ruby"),
+			withModtimeBump(tempDir, time.Second),
+			withNoError(t),
+			withStats(t, map[stats.Type]int{
+				stats.Traversed: 32,
+				stats.Matched:   1,
+				stats.Formatted: 1,
+				stats.Changed:   1,
+			}),
+		)
+
+		// a negation which matches no formatters
+		treefmt(t,
+			withArgs("--formatters", "!foo"),
+			withError(func(err error) {
+				as.ErrorContains(err, "formatter foo not found in config")
+			}),
+		)
+	})
+
+	t.Run("skip", func(t *testing.T) {
+		treefmt(t,
+			withArgs("--skip-formatters", "elm,nix"),
+			withModtimeBump(tempDir, time.Second),
+			withNoError(t),
+			withStats(t, map[stats.Type]int{
+				stats.Traversed: 32,
+				stats.Matched:   1,
+				stats.Formatted: 1,
+				stats.Changed:   1,
+			}),
+		)
+
+		// skipped formatters take precedence over those which are specified
+		treefmt(t,
+			withArgs("--formatters", "ruby,nix", "--skip-formatters", "nix"),
+			withModtimeBump(tempDir, time.Second),
+			withNoError(t),
+			withStats(t, map[stats.Type]int{
+				stats.Traversed: 32,
+				stats.Matched:   1,
+				stats.Formatted: 1,
+				stats.Changed:   1,
+			}),
+		)
+
+		t.Setenv("TREEFMT_SKIP_FORMATTERS", "ruby")
+
+		treefmt(t,
+			withModtimeBump(tempDir, time.Second),
+			withNoError(t),
+			withStats(t, map[stats.Type]int{
+				stats.Traversed: 32,
+				stats.Matched:   2,
+				stats.Formatted: 2,
+				stats.Changed:   2,
+			}),
+		)
+	})
+
+	t.Run("tags", func(t *testing.T) {
+		treefmt(t,
+			withArgs("--tags", "fast"),
+			withModtimeBump(tempDir, time.Second),
+			withNoError(t),
+			withStats(t, map[stats.Type]int{
+				stats.Traversed: 32,
+				stats.Matched:   2,
+				stats.Formatted: 2,
+				stats.Changed:   2,
+			}),
+		)
+
+		treefmt(t,
+			withArgs("--tags", "fast", "--skip-tags", "web"),
+			withModtimeBump(tempDir, time.Second),
+			withNoError(t),
+			withStats(t, map[stats.Type]int{
+				stats.Traversed: 32,
+				stats.Matched:   1,
+				stats.Formatted: 1,
+				stats.Changed:   1,
+			}),
+		)
+
+		t.Setenv("TREEFMT_SKIP_TAGS", "slow")
+
+		treefmt(t,
+			withModtimeBump(tempDir, time.Second),
+			withNoError(t),
+			withStats(t, map[stats.Type]int{
+				stats.Traversed: 32,
+				stats.Matched:   2,
+				stats.Formatted: 2,
+				stats.Changed:   2,
+			}),
+		)
+
+		// unknown tag
+		treefmt(t,
+			withArgs("--tags", "foo"),
+			withError(func(err error) {
+				as.ErrorContains(err, "no formatters are tagged foo in config")
+			}),
+		)
+	})
+
 	t.Run("env", func(t *testing.T) {
 		t.Setenv("TREEFMT_FORMATTERS", "ruby,nix")
 
@@ -302,6 +586,19 @@ func TestSpecifyingFormatters(t *testing.T) {
 				as.Errorf(err, "formatter not found in config: bar")
 			}),
 		)
+
+		t.Setenv("TREEFMT_FORMATTERS", "!nix,!ruby")
+
+		treefmt(t,
+			withNoError(t),
+			withModtimeBump(tempDir, time.Second),
+			withStats(t, map[stats.Type]int{
+				stats.Traversed: 32,
+				stats.Matched:   1,
+				stats.Formatted: 1,
+				stats.Changed:   1,
+			}),
+		)
 	})
 }
 
@@ -362,6 +659,36 @@ func TestIncludesAndExcludes(t *testing.T) {
 		}),
 	)
 
+	// exclude go files for just this run, in addition to those of the config
+	treefmt(t,
+		withArgs("-c", "--excludes", "*.go"),
+		withConfig(configPath, cfg),
+		withNoError(t),
+		withStats(t, map[stats.Type]int{
+			stats.Traversed: 32,
+			stats.Matched:   24,
+			stats.Formatted: 24,
+			stats.Changed:   0,
+		}),
+	)
+
+	// and python files via env
+	t.Setenv("TREEFMT_EXCLUDES", "*.go,*.py")
+
+	treefmt(t,
+		withArgs("-c"),
+		withConfig(configPath, cfg),
+		withNoError(t),
+		withStats(t, map[stats.Type]int{
+			stats.Traversed: 32,
+			stats.Matched:   22,
+			stats.Formatted: 22,
+			stats.Changed:   0,
+		}),
+	)
+
+	t.Setenv("TREEFMT_EXCLUDES", "") // reset
+
 	echo := cfg.FormatterConfigs["echo"]
 
 	// remove python files from the echo formatter
@@ -425,98 +752,69 @@ func TestIncludesAndExcludes(t *testing.T) {
 			stats.Changed:   0,
 		}),
 	)
-}
 
-func TestConfigFile(t *testing.T) {
-	as := require.New(t)
+	t.Setenv("TREEFMT_FORMATTER_ECHO_INCLUDES", "") // reset
 
-	for _, name := range []string{"treefmt.toml", ".treefmt.toml"} {
-		t.Run(name, func(t *testing.T) {
-			tempDir := test.TempExamples(t)
+	echo.Includes = []string{"*"}
 
-			// use a config file in a different temp directory
-			configPath := filepath.Join(t.TempDir(), name)
+	// restrict the run to the haskell directory, in which the global excludes still apply
+	treefmt(t,
+		withArgs("-c", "--include", "haskell/**"),
+		withConfig(configPath, cfg),
+		withNoError(t),
+		withStats(t, map[stats.Type]int{
+			stats.Traversed: 32,
+			stats.Matched:   3,
+			stats.Formatted: 3,
+			stats.Changed:   0,
+		}),
+	)
 
-			// if we don't specify a tree root, we default to the directory containing the config file
-			treefmt(t,
-				withConfig(configPath, &config.Config{
-					FormatterConfigs: map[string]*config.Formatter{
-						"echo": {
-							Command:  "echo",
-							Includes: []string{"*"},
-						},
-					},
-				}),
-				withArgs("--config-file", configPath),
-				withNoError(t),
-				withStats(t, map[stats.Type]int{
-					stats.Traversed: 1,
-					stats.Matched:   1,
-					stats.Formatted: 1,
-					stats.Changed:   0,
-				}),
-			)
-
-			treefmt(t,
-				withArgs("--config-file", configPath, "--tree-root", tempDir),
-				withNoError(t),
-				withStats(t, map[stats.Type]int{
-					stats.Traversed: 32,
-					stats.Matched:   32,
-					stats.Formatted: 32,
-					stats.Changed:   0,
-				}),
-			)
+	// add the python directory, in which the excludes of echo still apply
+	treefmt(t,
+		withArgs("-c", "--include", "haskell/**", "--include", "python/*"),
+		withConfig(configPath, cfg),
+		withNoError(t),
+		withStats(t, map[stats.Type]int{
+			stats.Traversed: 32,
+			stats.Matched:   4,
+			stats.Formatted: 4,
+			stats.Changed:   0,
+		}),
+	)
 
-			// use env variable
-			treefmt(t,
-				withEnv(map[string]string{
-					// TREEFMT_CONFIG takes precedence
-					"TREEFMT_CONFIG": configPath,
-					"PRJ_ROOT":       tempDir,
-				}),
-				withNoError(t),
-				withStats(t, map[stats.Type]int{
-					stats.Traversed: 1,
-					stats.Matched:   1,
-					stats.Formatted: 0,
-					stats.Changed:   0,
-				}),
-			)
+	// via env
+	t.Setenv("TREEFMT_INCLUDE", "*.md")
 
-			// should fallback to PRJ_ROOT
-			treefmt(t,
-				withArgs("--tree-root", tempDir),
-				withEnv(map[string]string{
-					"PRJ_ROOT": filepath.Dir(configPath),
-				}),
-				withNoError(t),
-				withStats(t, map[stats.Type]int{
-					stats.Traversed: 32,
-					stats.Matched:   32,
-					stats.Formatted: 0,
-					stats.Changed:   0,
-				}),
-			)
+	treefmt(t,
+		withArgs("-c"),
+		withConfig(configPath, cfg),
+		withNoError(t),
+		withStats(t, map[stats.Type]int{
+			stats.Traversed: 32,
+			stats.Matched:   2,
+			stats.Formatted: 2,
+			stats.Changed:   0,
+		}),
+	)
 
-			// should not search upwards if using PRJ_ROOT
-			configSubDir := filepath.Join(filepath.Dir(configPath), "sub")
-			as.NoError(os.MkdirAll(configSubDir, 0o600))
+	t.Setenv("TREEFMT_INCLUDE", "") // reset
 
-			treefmt(t,
-				withArgs("--tree-root", tempDir),
-				withEnv(map[string]string{
-					"PRJ_ROOT": configSubDir,
-				}),
-				withError(func(err error) {
-					as.ErrorContains(err, "failed to find treefmt config file")
-				}),
-			)
-		})
-	}
+	// combined with paths, only those files beneath the paths which match are formatted
+	treefmt(t,
+		withArgs("-c", "--include", "*.mod", "go", "python"),
+		withConfig(configPath, cfg),
+		withNoError(t),
+		withStats(t, map[stats.Type]int{
+			stats.Traversed: 5,
+			stats.Matched:   1,
+			stats.Formatted: 1,
+			stats.Changed:   0,
+		}),
+	)
 }
 
-func TestCache(t *testing.T) {
+func TestInterpreters(t *testing.T) {
 	as := require.New(t)
 
 	tempDir := test.TempExamples(t)
@@ -524,362 +822,6177 @@ func TestCache(t *testing.T) {
 
 	test.ChangeWorkDir(t, tempDir)
 
-	// test without any excludes
+	// create some scripts
+	as.NoError(os.MkdirAll(filepath.Join(tempDir, "bin"), 0o755))
+
+	for name, content := range map[string]string{
+		"bin/build":     "#!/usr/bin/env bash\necho build\n",
+		"bin/deploy":    "#!/bin/bash -e\necho deploy\n",
+		"bin/run":       "#!/usr/bin/python3\nprint('run')\n",
+		"bin/data":      "bash\n",
+		"bin/script.py": "#!/usr/bin/env bash\necho script\n",
+	} {
+		as.NoError(os.WriteFile(filepath.Join(tempDir, name), []byte(content), 0o755))
+	}
+
 	cfg := &config.Config{
 		FormatterConfigs: map[string]*config.Formatter{
-			"append": {
-				Command:  "test-fmt-append",
-				Options:  []string{"   "},
-				Includes: []string{"*"},
+			"shell": {
+				Command:      "echo",
+				Includes:     []string{"*.sh"},
+				Interpreters: []string{"bash"},
+			},
+			// no includes are required if interpreters are provided
+			"python": {
+				Command:      "echo",
+				Interpreters: []string{"python3"},
 			},
 		},
 	}
 
-	test.WriteConfig(t, configPath, cfg)
-
-	// first run
+	// shell/foo.sh, bin/build, bin/deploy and bin/run
 	treefmt(t,
+		withConfig(configPath, cfg),
 		withNoError(t),
 		withStats(t, map[stats.Type]int{
-			stats.Traversed: 32,
-			stats.Matched:   32,
-			stats.Formatted: 32,
-			stats.Changed:   32,
+			stats.Traversed: 37,
+			stats.Matched:   4,
+			stats.Formatted: 4,
+			stats.Changed:   0,
 		}),
 	)
 
-	// cached run with no changes to underlying files
+	// excludes still apply
+	cfg.FormatterConfigs["shell"].Excludes = []string{"bin/deploy"}
+
 	treefmt(t,
+		withArgs("-c"),
+		withConfig(configPath, cfg),
 		withNoError(t),
 		withStats(t, map[stats.Type]int{
-			stats.Traversed: 32,
-			stats.Matched:   32,
-			stats.Formatted: 0,
+			stats.Traversed: 37,
+			stats.Matched:   3,
+			stats.Formatted: 3,
 			stats.Changed:   0,
 		}),
 	)
 
-	// clear cache
+	// a formatter must have includes or interpreters
+	cfg.FormatterConfigs["python"].Interpreters = nil
+
 	treefmt(t,
 		withArgs("-c"),
-		withNoError(t),
-		withStats(t, map[stats.Type]int{
-			stats.Traversed: 32,
-			stats.Matched:   32,
-			stats.Formatted: 32,
-			stats.Changed:   32,
+		withConfig(configPath, cfg),
+		withError(func(err error) {
+			as.ErrorContains(err, "formatter 'python' has no includes")
 		}),
 	)
+}
 
-	// cached run with no changes to underlying files
-	treefmt(t,
-		withNoError(t),
-		withStats(t, map[stats.Type]int{
-			stats.Traversed: 32,
-			stats.Matched:   32,
-			stats.Formatted: 0,
-			stats.Changed:   0,
-		}),
-	)
+func TestMatchFirstLine(t *testing.T) {
+	as := require.New(t)
 
-	// bump underlying files
-	treefmt(t,
-		withNoError(t),
-		withModtimeBump(tempDir, time.Second),
-		withStats(t, map[stats.Type]int{
-			stats.Traversed: 32,
-			stats.Matched:   32,
-			stats.Formatted: 32,
-			stats.Changed:   32,
-		}),
-	)
+	tempDir := test.TempExamples(t)
+	configPath := filepath.Join(tempDir, "treefmt.toml")
 
-	// no cache
-	treefmt(t,
-		withArgs("--no-cache"),
-		withNoError(t),
-		withStats(t, map[stats.Type]int{
-			stats.Traversed: 32,
-			stats.Matched:   32,
-			stats.Formatted: 32,
-			stats.Changed:   32,
-		}),
-	)
+	test.ChangeWorkDir(t, tempDir)
 
-	// update the config with a failing formatter
-	cfg = &config.Config{
+	for name, content := range map[string]string{
+		"shell/tool":     "#!/usr/bin/env nix-shell\n#!nix-shell -i bash\necho tool\n",
+		"shell/tool.txt": "#!/usr/bin/env nix-shell\n",
+		"shell/other":    "# not a nix-shell\n",
+	} {
+		as.NoError(os.WriteFile(filepath.Join(tempDir, name), []byte(content), 0o600))
+	}
+
+	cfg := &config.Config{
 		FormatterConfigs: map[string]*config.Formatter{
-			// fails to execute
-			"fail": {
-				Command:  "touch",
-				Options:  []string{"--bad-arg"},
-				Includes: []string{"*.hs"},
+			"nix-shell": {
+				Command:        "echo",
+				Includes:       []string{"*.nix"},
+				MatchFirstLine: "^#!/usr/bin/env nix-shell",
 			},
 		},
 	}
-	test.WriteConfig(t, configPath, cfg)
-
-	// test that formatting errors are not cached
-
-	// running should match but not format anything
 
+	// nix/sources.nix, shell/tool and shell/tool.txt
 	treefmt(t,
-		withError(func(err error) {
-			as.ErrorIs(err, format.ErrFormattingFailures)
-		}),
+		withConfig(configPath, cfg),
+		withNoError(t),
 		withStats(t, map[stats.Type]int{
-			stats.Traversed: 32,
-			stats.Matched:   6,
-			stats.Formatted: 0,
+			stats.Traversed: 35,
+			stats.Matched:   3,
+			stats.Formatted: 3,
 			stats.Changed:   0,
 		}),
 	)
 
-	// running again should provide the same result
+	// includes are not required
+	cfg.FormatterConfigs["nix-shell"].Includes = nil
+	cfg.FormatterConfigs["nix-shell"].Excludes = []string{"*.txt"}
+
 	treefmt(t,
-		withError(func(err error) {
-			as.ErrorIs(err, format.ErrFormattingFailures)
-		}),
+		withArgs("-c"),
+		withConfig(configPath, cfg),
+		withNoError(t),
 		withStats(t, map[stats.Type]int{
-			stats.Traversed: 32,
-			stats.Matched:   6,
-			stats.Formatted: 0,
+			stats.Traversed: 35,
+			stats.Matched:   1,
+			stats.Formatted: 1,
 			stats.Changed:   0,
 		}),
 	)
 
-	// let's fix the haskell config so it no longer fails
-	cfg.FormatterConfigs["fail"] = &config.Formatter{
-		Command:  "test-fmt-append",
-		Options:  []string{"   "},
-		Includes: []string{"*.hs"},
-	}
-
-	test.WriteConfig(t, configPath, cfg)
+	// invalid expression
+	cfg.FormatterConfigs["nix-shell"].MatchFirstLine = "^(nix"
 
-	// we should now format the haskell files
 	treefmt(t,
-		withNoError(t),
-		withStats(t, map[stats.Type]int{
-			stats.Traversed: 32,
-			stats.Matched:   6,
-			stats.Formatted: 6,
-			stats.Changed:   6,
+		withArgs("-c"),
+		withConfig(configPath, cfg),
+		withError(func(err error) {
+			as.ErrorContains(err, "failed to compile formatter 'nix-shell' match-first-line")
 		}),
 	)
 }
 
-func TestChangeWorkingDirectory(t *testing.T) {
+func TestContentDetection(t *testing.T) {
 	as := require.New(t)
 
+	tempDir := test.TempExamples(t)
+	configPath := filepath.Join(tempDir, "treefmt.toml")
+
+	test.ChangeWorkDir(t, tempDir)
+
+	// create some files whose extensions are missing or lie about their content
+	as.NoError(os.MkdirAll(filepath.Join(tempDir, "data"), 0o755))
+
+	for name, content := range map[string]string{
+		"data/config":    `{"hello": "world"}`,
+		"data/lies.json": `<?xml version="1.0"?><hello>world</hello>`,
+	} {
+		as.NoError(os.WriteFile(filepath.Join(tempDir, name), []byte(content), 0o600))
+	}
+
 	cfg := &config.Config{
 		FormatterConfigs: map[string]*config.Formatter{
-			"append": {
-				Command:  "test-fmt-append",
-				Options:  []string{"   "},
-				Includes: []string{"*"},
+			// elm/elm.json and data/config
+			"json": {
+				Command: "echo",
+				Detect:  "content",
+				Types:   []string{"json"},
+			},
+			// data/lies.json, includes restrict which paths are inspected
+			"xml": {
+				Command:  "echo",
+				Includes: []string{"data/*"},
+				Detect:   "content",
+				Types:    []string{"xml", "html"},
 			},
 		},
 	}
 
-	t.Run("default", func(t *testing.T) {
-		// capture current cwd, so we can replace it after the test is finished
-		cwd, err := os.Getwd()
-		as.NoError(err)
+	treefmt(t,
+		withConfig(configPath, cfg),
+		withNoError(t),
+		withStats(t, map[stats.Type]int{
+			stats.Traversed: 34,
+			stats.Matched:   3,
+			stats.Formatted: 3,
+			stats.Changed:   0,
+		}),
+	)
 
-		t.Cleanup(func() {
-			// return to the previous working directory
-			as.NoError(os.Chdir(cwd))
-		})
+	// invalid config
+	for _, tc := range []struct {
+		formatter *config.Formatter
+		err       string
+	}{
+		{
+			formatter: &config.Formatter{Command: "echo", Detect: "content"},
+			err:       "formatter 'json' detects by content but has no types",
+		},
+		{
+			formatter: &config.Formatter{Command: "echo", Detect: "content", Types: []string{"foo"}},
+			err:       "formatter 'json' has an invalid type",
+		},
+		{
+			formatter: &config.Formatter{Command: "echo", Includes: []string{"*"}, Types: []string{"json"}},
+			err:       "formatter 'json' has types but detect is not set to content",
+		},
+		{
+			formatter: &config.Formatter{Command: "echo", Includes: []string{"*"}, Detect: "magic"},
+			err:       "formatter 'json' has an invalid detect value 'magic'",
+		},
+	} {
+		cfg.FormatterConfigs = map[string]*config.Formatter{"json": tc.formatter}
 
+		treefmt(t,
+			withArgs("-c"),
+			withConfig(configPath, cfg),
+			withError(func(err error) {
+				as.ErrorContains(err, tc.err)
+			}),
+		)
+	}
+}
+
+func TestMaxFileSize(t *testing.T) {
+	as := require.New(t)
+
+	tempDir := test.TempExamples(t)
+	configPath := filepath.Join(tempDir, "treefmt.toml")
+
+	test.ChangeWorkDir(t, tempDir)
+
+	// create a large generated file
+	as.NoError(os.WriteFile(filepath.Join(tempDir, "go/generated.go"), make([]byte, 1024*1024), 0o600))
+
+	cfg := &config.Config{
+		FormatterConfigs: map[string]*config.Formatter{
+			"go": {
+				Command:  "echo",
+				Includes: []string{"*.go"},
+			},
+			"all": {
+				Command:  "echo",
+				Includes: []string{"*"},
+			},
+		},
+	}
+
+	// no limit
+	treefmt(t,
+		withConfig(configPath, cfg),
+		withNoError(t),
+		withStats(t, map[stats.Type]int{
+			stats.Traversed: 33,
+			stats.Matched:   33,
+			stats.Formatted: 33,
+			stats.Skipped:   0,
+		}),
+	)
+
+	// global limit
+	treefmt(t,
+		withArgs("-c", "--max-file-size", "512KiB"),
+		withConfig(configPath, cfg),
+		withNoError(t),
+		withStats(t, map[stats.Type]int{
+			stats.Traversed: 33,
+			stats.Matched:   32,
+			stats.Formatted: 32,
+			stats.Skipped:   1,
+		}),
+		withOutput(func(out []byte) {
+			as.Contains(string(out), "skipped 1 files")
+		}),
+	)
+
+	// a per-formatter limit only skips the file if every matching formatter is limited
+	cfg.FormatterConfigs["go"].MaxFileSize = "512KiB"
+
+	treefmt(t,
+		withArgs("-c"),
+		withConfig(configPath, cfg),
+		withNoError(t),
+		withStats(t, map[stats.Type]int{
+			stats.Traversed: 33,
+			stats.Matched:   33,
+			stats.Formatted: 33,
+			stats.Skipped:   0,
+		}),
+	)
+
+	cfg.FormatterConfigs["all"].MaxFileSize = "1MB"
+
+	treefmt(t,
+		withArgs("-c"),
+		withConfig(configPath, cfg),
+		withNoError(t),
+		withStats(t, map[stats.Type]int{
+			stats.Traversed: 33,
+			stats.Matched:   32,
+			stats.Formatted: 32,
+			stats.Skipped:   1,
+		}),
+	)
+
+	// invalid sizes
+	treefmt(t,
+		withArgs("-c", "--max-file-size", "lots"),
+		withConfig(configPath, cfg),
+		withError(func(err error) {
+			as.ErrorContains(err, "invalid max-file-size value")
+		}),
+	)
+
+	cfg.FormatterConfigs["all"].MaxFileSize = "2XB"
+
+	treefmt(t,
+		withArgs("-c"),
+		withConfig(configPath, cfg),
+		withError(func(err error) {
+			as.ErrorContains(err, "formatter 'all' has an invalid max-file-size")
+		}),
+	)
+}
+
+func TestStdoutFormatter(t *testing.T) {
+	as := require.New(t)
+
+	tempDir := test.TempExamples(t)
+	configPath := filepath.Join(tempDir, "treefmt.toml")
+
+	test.ChangeWorkDir(t, tempDir)
+
+	as.NoError(os.MkdirAll(filepath.Join(tempDir, "stdout"), 0o755))
+
+	for name, content := range map[string]string{
+		"stdout/hello.txt": "hello\n",
+		"stdout/world.txt": "world\n",
+	} {
+		as.NoError(os.WriteFile(filepath.Join(tempDir, name), []byte(content), 0o600))
+	}
+
+	cfg := &config.Config{
+		FormatterConfigs: map[string]*config.Formatter{
+			"sed": {
+				Command:  "sed",
+				Options:  []string{"-e", "s/hello$/hello there/"},
+				Includes: []string{"stdout/*.txt"},
+				Stdout:   true,
+			},
+		},
+	}
+
+	// only the file whose output differs should be changed
+	treefmt(t,
+		withConfig(configPath, cfg),
+		withNoError(t),
+		withStats(t, map[stats.Type]int{
+			stats.Traversed: 34,
+			stats.Matched:   2,
+			stats.Formatted: 2,
+			stats.Changed:   1,
+		}),
+	)
+
+	content, err := os.ReadFile(filepath.Join(tempDir, "stdout/hello.txt"))
+	as.NoError(err)
+	as.Equal("hello there\n", string(content))
+
+	content, err = os.ReadFile(filepath.Join(tempDir, "stdout/world.txt"))
+	as.NoError(err)
+	as.Equal("world\n", string(content))
+
+	// formatting again should result in no changes
+	treefmt(t,
+		withArgs("-c"),
+		withConfig(configPath, cfg),
+		withNoError(t),
+		withStats(t, map[stats.Type]int{
+			stats.Traversed: 34,
+			stats.Matched:   2,
+			stats.Formatted: 2,
+			stats.Changed:   0,
+		}),
+	)
+
+	// a formatter which writes nothing to stdout should not wipe the file
+	cfg.FormatterConfigs["sed"].Options = []string{"-n", "-e", "s/hello$/hello there/"}
+
+	treefmt(t,
+		withArgs("-c"),
+		withConfig(configPath, cfg),
+		withError(func(err error) {
+			as.ErrorIs(err, format.ErrFormattingFailures)
+		}),
+	)
+
+	content, err = os.ReadFile(filepath.Join(tempDir, "stdout/world.txt"))
+	as.NoError(err)
+	as.Equal("world\n", string(content))
+}
+
+// pluginScript is a minimal plugin which, depending on its first arg, modifies each file it is sent in place, replies
+// with their formatted content, or reports an error for each of them. It records each time it is started in the file
+// given as its second arg.
+const pluginScript = `#!/bin/sh
+mode=$1
+echo started >> "$2"
+
+while IFS= read -r line; do
+	id=$(printf '%s\n' "$line" | sed -e 's/^{"id":\([0-9]*\),.*$/\1/')
+
+	case "$line" in
+	*'"method":"initialize"'*)
+		contents=false
+		[ "$mode" = contents ] && contents=true
+		printf '%s\n' "{\"id\":$id,\"result\":{\"version\":1,\"contents\":$contents}}"
+		;;
+	*'"method":"format"'*)
+		files=""
+		for path in $(printf '%s\n' "$line" | grep -o '"path":"[^"]*"' | cut -d'"' -f4); do
+			case "$mode" in
+			paths)
+				echo plugin >> "$path"
+				file="{\"path\":\"$path\"}"
+				;;
+			contents)
+				file="{\"path\":\"$path\",\"content\":\"formatted\\n\"}"
+				;;
+			errors)
+				file="{\"path\":\"$path\",\"diagnostics\":[{\"line\":1,\"severity\":\"error\",\"message\":\"bad\"}]}"
+				;;
+			esac
+			files="${files:+$files,}$file"
+		done
+		printf '%s\n' "{\"id\":$id,\"result\":{\"files\":[$files]}}"
+		;;
+	*'"method":"shutdown"'*)
+		printf '%s\n' "{\"id\":$id}"
+		;;
+	esac
+done
+`
+
+func TestPlugin(t *testing.T) {
+	as := require.New(t)
+
+	tempDir := test.TempExamples(t)
+	configPath := filepath.Join(tempDir, "treefmt.toml")
+
+	test.ChangeWorkDir(t, tempDir)
+
+	as.NoError(os.MkdirAll(filepath.Join(tempDir, "plugin"), 0o755))
+
+	for _, name := range []string{"plugin/a.txt", "plugin/b.txt", "plugin/c.txt"} {
+		as.NoError(os.WriteFile(filepath.Join(tempDir, name), []byte("hello\n"), 0o600))
+	}
+
+	binDir := t.TempDir()
+	pluginPath := filepath.Join(binDir, "test-plugin")
+	startsPath := filepath.Join(binDir, "starts")
+
+	as.NoError(os.WriteFile(pluginPath, []byte(pluginScript), 0o755))
+
+	cfg := &config.Config{
+		FormatterConfigs: map[string]*config.Formatter{
+			"plugin": {
+				Command:   pluginPath,
+				Options:   []string{"paths", startsPath},
+				Includes:  []string{"plugin/*.txt"},
+				BatchSize: 1,
+				Plugin:    true,
+			},
+		},
+	}
+
+	// the plugin is started once, and receives a request for each batch
+	treefmt(t,
+		withConfig(configPath, cfg),
+		withNoError(t),
+		withStats(t, map[stats.Type]int{
+			stats.Traversed: 35,
+			stats.Matched:   3,
+			stats.Formatted: 3,
+			stats.Changed:   3,
+		}),
+	)
+
+	starts, err := os.ReadFile(startsPath)
+	as.NoError(err)
+	as.Equal("started\n", string(starts))
+
+	content, err := os.ReadFile(filepath.Join(tempDir, "plugin/a.txt"))
+	as.NoError(err)
+	as.Equal("hello\nplugin\n", string(content))
+
+	// a plugin which asks for contents replies with the formatted content of each file
+	cfg.FormatterConfigs["plugin"].Options = []string{"contents", startsPath}
+
+	treefmt(t,
+		withArgs("-c"),
+		withConfig(configPath, cfg),
+		withNoError(t),
+		withStats(t, map[stats.Type]int{
+			stats.Traversed: 35,
+			stats.Matched:   3,
+			stats.Formatted: 3,
+			stats.Changed:   3,
+		}),
+	)
+
+	content, err = os.ReadFile(filepath.Join(tempDir, "plugin/b.txt"))
+	as.NoError(err)
+	as.Equal("formatted\n", string(content))
+
+	// files are only written if their content has changed
+	treefmt(t,
+		withArgs("-c"),
+		withConfig(configPath, cfg),
+		withNoError(t),
+		withStats(t, map[stats.Type]int{
+			stats.Traversed: 35,
+			stats.Matched:   3,
+			stats.Formatted: 3,
+			stats.Changed:   0,
+		}),
+	)
+
+	// diagnostics with an error severity cause the formatter to fail
+	cfg.FormatterConfigs["plugin"].Options = []string{"errors", startsPath}
+
+	treefmt(t,
+		withArgs("-c"),
+		withConfig(configPath, cfg),
+		withError(func(err error) {
+			as.ErrorIs(err, format.ErrFormattingFailures)
+		}),
+	)
+
+	// a plugin cannot also write its output to stdout
+	cfg.FormatterConfigs["plugin"].Stdout = true
+
+	treefmt(t,
+		withArgs("-c"),
+		withConfig(configPath, cfg),
+		withError(func(err error) {
+			as.ErrorContains(err, "formatter 'plugin' cannot be a plugin and also write its output to stdout")
+		}),
+	)
+}
+
+// upperSource is a minimal WASI formatter which upper-cases its stdin, failing for content containing "fail".
+const upperSource = `package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+)
+
+func main() {
+	content, err := io.ReadAll(os.Stdin)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	if bytes.Contains(content, []byte("fail")) {
+		fmt.Fprintf(os.Stderr, "cannot format %s\n", os.Args[len(os.Args)-1])
+		os.Exit(1)
+	}
+
+	_, _ = os.Stdout.Write(bytes.ToUpper(content))
+}
+`
+
+func TestWasm(t *testing.T) {
+	as := require.New(t)
+
+	goBin, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go is required to build the WASM module")
+	}
+
+	tempDir := test.TempExamples(t)
+	configPath := filepath.Join(tempDir, "treefmt.toml")
+
+	// build the module
+	srcDir := t.TempDir()
+	as.NoError(os.WriteFile(filepath.Join(srcDir, "go.mod"), []byte("module upper\n\ngo 1.21\n"), 0o600))
+	as.NoError(os.WriteFile(filepath.Join(srcDir, "main.go"), []byte(upperSource), 0o600))
+	as.NoError(os.MkdirAll(filepath.Join(tempDir, "wasm"), 0o755))
+
+	build := exec.Command(goBin, "build", "-o", filepath.Join(tempDir, "wasm", "upper.wasm"), ".")
+	build.Dir = srcDir
+	build.Env = append(os.Environ(), "GOOS=wasip1", "GOARCH=wasm")
+
+	output, err := build.CombinedOutput()
+	as.NoError(err, string(output))
+
+	test.ChangeWorkDir(t, tempDir)
+
+	for name, content := range map[string]string{
+		"wasm/hello.txt": "hello\n",
+		"wasm/world.txt": "WORLD\n",
+	} {
+		as.NoError(os.WriteFile(filepath.Join(tempDir, name), []byte(content), 0o600))
+	}
+
+	cfg := &config.Config{
+		FormatterConfigs: map[string]*config.Formatter{
+			"upper": {
+				// relative to the tree root
+				Command:  "wasm/upper.wasm",
+				Includes: []string{"wasm/*.txt"},
+			},
+		},
+	}
+
+	// only the file whose output differs should be changed
+	treefmt(t,
+		withConfig(configPath, cfg),
+		withNoError(t),
+		withStats(t, map[stats.Type]int{
+			stats.Traversed: 35,
+			stats.Matched:   2,
+			stats.Formatted: 2,
+			stats.Changed:   1,
+		}),
+	)
+
+	content, err := os.ReadFile(filepath.Join(tempDir, "wasm/hello.txt"))
+	as.NoError(err)
+	as.Equal("HELLO\n", string(content))
+
+	// formatting again should result in no changes
+	treefmt(t,
+		withArgs("-c"),
+		withConfig(configPath, cfg),
+		withNoError(t),
+		withStats(t, map[stats.Type]int{
+			stats.Traversed: 35,
+			stats.Matched:   2,
+			stats.Formatted: 2,
+			stats.Changed:   0,
+		}),
+	)
+
+	// a module which exits with an error leaves the file untouched
+	as.NoError(os.WriteFile(filepath.Join(tempDir, "wasm/world.txt"), []byte("fail\n"), 0o600))
+
+	treefmt(t,
+		withArgs("-c"),
+		withConfig(configPath, cfg),
+		withError(func(err error) {
+			as.ErrorIs(err, format.ErrFormattingFailures)
+		}),
+	)
+
+	content, err = os.ReadFile(filepath.Join(tempDir, "wasm/world.txt"))
+	as.NoError(err)
+	as.Equal("fail\n", string(content))
+
+	// a module which does not exist is treated like a missing command
+	cfg.FormatterConfigs["upper"].Command = "wasm/missing.wasm"
+
+	treefmt(t,
+		withArgs("-c"),
+		withConfig(configPath, cfg),
+		withError(func(err error) {
+			as.ErrorIs(err, format.ErrCommandNotFound)
+		}),
+	)
+}
+
+func TestBuiltin(t *testing.T) {
+	as := require.New(t)
+
+	tempDir := test.TempExamples(t)
+	configPath := filepath.Join(tempDir, "treefmt.toml")
+
+	test.ChangeWorkDir(t, tempDir)
+
+	as.NoError(os.MkdirAll(filepath.Join(tempDir, "builtin"), 0o755))
+
+	for name, content := range map[string]string{
+		"builtin/a.json": `{"a": 1}`,
+		"builtin/b.json": "{\n  \"b\": 2\n}\n",
+	} {
+		as.NoError(os.WriteFile(filepath.Join(tempDir, name), []byte(content), 0o600))
+	}
+
+	cfg := &config.Config{
+		FormatterConfigs: map[string]*config.Formatter{
+			"json": {
+				Command:  "builtin:json",
+				Includes: []string{"builtin/*.json"},
+			},
+		},
+	}
+
+	// only the file which was not already formatted should be changed
+	treefmt(t,
+		withConfig(configPath, cfg),
+		withNoError(t),
+		withStats(t, map[stats.Type]int{
+			stats.Traversed: 34,
+			stats.Matched:   2,
+			stats.Formatted: 2,
+			stats.Changed:   1,
+		}),
+	)
+
+	content, err := os.ReadFile(filepath.Join(tempDir, "builtin/a.json"))
+	as.NoError(err)
+	as.Equal("{\n  \"a\": 1\n}\n", string(content))
+
+	// invalid content causes the formatter to fail
+	as.NoError(os.WriteFile(filepath.Join(tempDir, "builtin/b.json"), []byte("{"), 0o600))
+
+	treefmt(t,
+		withArgs("-c"),
+		withConfig(configPath, cfg),
+		withError(func(err error) {
+			as.ErrorIs(err, format.ErrFormattingFailures)
+		}),
+	)
+
+	// builtin formatters do not accept options
+	cfg.FormatterConfigs["json"].Options = []string{"--indent", "4"}
+
+	treefmt(t,
+		withArgs("-c"),
+		withConfig(configPath, cfg),
+		withError(func(err error) {
+			as.ErrorContains(err, "formatter 'json' is a builtin formatter, so cannot have options")
+		}),
+	)
+
+	// only known builtins can be used
+	cfg.FormatterConfigs["json"].Options = nil
+	cfg.FormatterConfigs["json"].Command = "builtin:yaml"
+
+	treefmt(t,
+		withArgs("-c"),
+		withConfig(configPath, cfg),
+		withError(func(err error) {
+			as.ErrorContains(err, "formatter 'json' has an invalid builtin 'yaml', must be one of "+
+				"<final-newline|gofmt|json|toml|trim-whitespace>")
+		}),
+	)
+}
+
+func TestHooks(t *testing.T) {
+	as := require.New(t)
+
+	tempDir := test.TempExamples(t)
+	configPath := filepath.Join(tempDir, "treefmt.toml")
+	logPath := filepath.Join(t.TempDir(), "hooks.log")
+
+	test.ChangeWorkDir(t, tempDir)
+
+	as.NoError(os.MkdirAll(filepath.Join(tempDir, "hooks"), 0o755))
+
+	record := func(line string) string {
+		return fmt.Sprintf("printf '%%s\\n' \"%s\" >> %s", line, logPath)
+	}
+
+	readLog := func() string {
+		content, err := os.ReadFile(logPath)
+		as.NoError(err)
+
+		return string(content)
+	}
+
+	cfg := &config.Config{
+		FormatterConfigs: map[string]*config.Formatter{
+			"json": {
+				Command:  "builtin:json",
+				Includes: []string{"hooks/*.json"},
+				Pre:      record("$TREEFMT_FORMATTER $TREEFMT_HOOK"),
+				Post:     record("$TREEFMT_FORMATTER $TREEFMT_HOOK"),
+			},
+		},
+	}
+
+	// the pre hook runs in the tree root, before it is traversed, so the file it generates is formatted
+	cfg.Hooks.Pre = record("$TREEFMT_HOOK") + "; printf '{\"a\": 1}' > hooks/generated.json"
+	cfg.Hooks.Post = record("$TREEFMT_HOOK $TREEFMT_FORMATTED $TREEFMT_CHANGED")
+
+	treefmt(t,
+		withConfig(configPath, cfg),
+		withNoError(t),
+		withStats(t, map[stats.Type]int{
+			stats.Traversed: 33,
+			stats.Matched:   1,
+			stats.Formatted: 1,
+			stats.Changed:   1,
+		}),
+	)
+
+	as.Equal("pre\njson pre\njson post\npost 1 1\n", readLog())
+
+	content, err := os.ReadFile(filepath.Join(tempDir, "hooks/generated.json"))
+	as.NoError(err)
+	as.Equal("{\n  \"a\": 1\n}\n", string(content))
+
+	// hooks are not run in check mode, as they might modify the tree
+	as.NoError(os.Remove(logPath))
+
+	treefmt(t,
+		withArgs("--check", "-c"),
+		withConfig(configPath, cfg),
+		withNoError(t),
+	)
+
+	as.NoFileExists(logPath)
+
+	// if the pre hook fails, nothing is formatted and the post hook is not run
+	cfg.Hooks.Pre = "exit 1"
+	cfg.Hooks.Post = record("$TREEFMT_HOOK $TREEFMT_ERROR")
+
+	treefmt(t,
+		withArgs("-c"),
+		withConfig(configPath, cfg),
+		withError(func(err error) {
+			as.ErrorContains(err, "pre hook failed")
+		}),
+	)
+
+	as.NoFileExists(logPath)
+
+	// a failing formatter hook is a formatting failure, which the post hook is told about
+	cfg.Hooks.Pre = ""
+	cfg.FormatterConfigs["json"].Post = "exit 2"
+
+	treefmt(t,
+		withArgs("-c"),
+		withConfig(configPath, cfg),
+		withError(func(err error) {
+			as.ErrorIs(err, format.ErrFormattingFailures)
+		}),
+	)
+
+	as.Equal("json pre\npost formatting failures detected\n", readLog())
+}
+
+func TestInstallHook(t *testing.T) {
+	as := require.New(t)
+
+	tempDir := t.TempDir()
+	hookPath := filepath.Join(tempDir, ".git", "hooks", "pre-commit")
+
+	test.ChangeWorkDir(t, tempDir)
+
+	// the hooks directory cannot be found outside a git repository
+	treefmt(t,
+		withArgs("install-hook"),
+		withError(func(err error) {
+			as.ErrorContains(err, "failed to find git hooks directory")
+		}),
+	)
+
+	as.NoError(exec.Command("git", "init").Run(), "failed to init git repository")
+
+	// installing is idempotent
+	for range 2 {
+		treefmt(t,
+			withArgs("install-hook"),
+			withNoError(t),
+		)
+
+		info, err := os.Stat(hookPath)
+		as.NoError(err)
+		as.NotZero(info.Mode()&0o100, "hook should be executable")
+
+		content, err := os.ReadFile(hookPath)
+		as.NoError(err)
+		as.Contains(string(content), "exec treefmt --staged --fail-on-change")
+	}
+
+	// the pre-push hook checks the whole tree
+	treefmt(t,
+		withArgs("install-hook", "pre-push"),
+		withNoError(t),
+	)
+
+	content, err := os.ReadFile(filepath.Join(tempDir, ".git", "hooks", "pre-push"))
+	as.NoError(err)
+	as.Contains(string(content), "exec treefmt --check")
+
+	treefmt(t,
+		withArgs("install-hook", "post-merge"),
+		withError(func(err error) {
+			as.ErrorContains(err, "invalid argument \"post-merge\"")
+		}),
+	)
+
+	// uninstalling removes the hook
+	treefmt(t,
+		withArgs("install-hook", "--uninstall"),
+		withNoError(t),
+	)
+
+	as.NoFileExists(hookPath)
+
+	// hooks which were not installed by treefmt are left alone, unless forced
+	as.NoError(os.WriteFile(hookPath, []byte("#!/bin/sh\nexit 0\n"), 0o600))
+
+	treefmt(t,
+		withArgs("install-hook"),
+		withError(func(err error) {
+			as.ErrorContains(err, "already exists, use --force to replace it")
+		}),
+	)
+
+	treefmt(t,
+		withArgs("install-hook", "--uninstall"),
+		withError(func(err error) {
+			as.ErrorContains(err, "was not installed by treefmt")
+		}),
+	)
+
+	treefmt(t,
+		withArgs("install-hook", "--force"),
+		withNoError(t),
+	)
+
+	content, err = os.ReadFile(hookPath)
+	as.NoError(err)
+	as.Contains(string(content), "installed by treefmt install-hook")
+}
+
+func TestInstallHookWithDaemon(t *testing.T) {
+	as := require.New(t)
+
+	goBin, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go is required to build the treefmt executable run by the hook")
+	}
+
+	// the hook runs treefmt from the PATH
+	binPath := t.TempDir()
+
+	build := exec.Command(goBin, "build", "-o", filepath.Join(binPath, "treefmt"), "..")
+	output, err := build.CombinedOutput()
+	as.NoError(err, string(output))
+
+	tempDir := t.TempDir()
+	configPath := filepath.Join(tempDir, "treefmt.toml")
+	socketPath := filepath.Join(t.TempDir(), "treefmt.sock")
+
+	test.ChangeWorkDir(t, tempDir)
+
+	git := func(args ...string) error {
+		cmd := exec.Command("git", args...)
+		cmd.Env = append(os.Environ(),
+			"PATH="+binPath+":"+os.Getenv("PATH"),
+			"GIT_AUTHOR_NAME=treefmt", "GIT_AUTHOR_EMAIL=treefmt@example.com",
+			"GIT_COMMITTER_NAME=treefmt", "GIT_COMMITTER_EMAIL=treefmt@example.com",
+		)
+
+		return cmd.Run()
+	}
+
+	cfg := &config.Config{
+		FormatterConfigs: map[string]*config.Formatter{
+			"json": {
+				Command:  "builtin:json",
+				Includes: []string{"*.json"},
+			},
+		},
+	}
+
+	as.NoError(git("init"))
+	as.NoError(os.WriteFile(filepath.Join(tempDir, "a.json"), []byte(`{"a": 1}`), 0o600))
+	as.NoError(os.WriteFile(filepath.Join(tempDir, "b.json"), []byte(`{"b": 2}`), 0o600))
+
+	treefmt(t,
+		withArgs("install-hook"),
+		withNoError(t),
+	)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// the results are asserted on once the daemon has stopped
+	var (
+		res         daemon.Response
+		unformatted error
+		content     []byte
+	)
+
+	done := make(chan error, 1)
+
+	go func() {
+		// stop the daemon once we are finished, even if something went wrong
+		defer cancel()
+
+		done <- func() error {
+			conn, err := dialDaemon(socketPath)
+			if err != nil {
+				return err
+			}
+
+			defer conn.Close()
+
+			// the daemon formats a file, opening the cache and releasing it again once it has done so
+			if res, err = requestDaemon(json.NewEncoder(conn), bufio.NewScanner(conn), "b.json"); err != nil {
+				return err
+			}
+
+			// the hook formats the staged file, blocking the commit as it was changed
+			if err = git("add", "a.json", "treefmt.toml"); err != nil {
+				return fmt.Errorf("failed to stage files: %w", err)
+			}
+
+			unformatted = git("commit", "-m", "unformatted")
+
+			// once formatted, the commit goes ahead whilst the daemon is still running
+			if err = git("commit", "-m", "formatted"); err != nil {
+				return fmt.Errorf("failed to commit formatted files: %w", err)
+			}
+
+			content, err = exec.Command("git", "show", "HEAD:a.json").Output()
+
+			return err
+		}()
+	}()
+
+	treefmt(t,
+		withContext(ctx),
+		withArgs("daemon", "--socket", socketPath),
+		withConfig(configPath, cfg),
+		withNoError(t),
+	)
+
+	as.NoError(<-done)
+	as.Empty(res.Error)
+	as.Error(unformatted)
+	as.Equal("{\n  \"a\": 1\n}\n", string(content))
+}
+
+func TestStaged(t *testing.T) {
+	as := require.New(t)
+
+	tempDir := t.TempDir()
+	configPath := filepath.Join(tempDir, "treefmt.toml")
+
+	test.ChangeWorkDir(t, tempDir)
+
+	git := func(args ...string) string {
+		out, err := exec.Command("git", args...).Output()
+		as.NoError(err, "failed to run git %v", args)
+
+		return string(out)
+	}
+
+	cfg := &config.Config{
+		FormatterConfigs: map[string]*config.Formatter{
+			"json": {
+				Command:  "builtin:json",
+				Includes: []string{"*.json"},
+			},
+		},
+	}
+
+	test.WriteConfig(t, configPath, cfg)
+
+	git("init")
+
+	// a is fully staged, b is partially staged and c is not staged
+	for name, content := range map[string]string{"a.json": `{"a": 1}`, "b.json": `{"b": 1}`} {
+		as.NoError(os.WriteFile(name, []byte(content), 0o600))
+	}
+
+	git("add", "a.json", "b.json")
+
+	as.NoError(os.WriteFile("b.json", []byte(`{"b": 2}`), 0o600))
+	as.NoError(os.WriteFile("c.json", []byte(`{"c": 1}`), 0o600))
+
+	treefmt(t,
+		withArgs("--staged"),
+		withNoError(t),
+		withStats(t, map[stats.Type]int{
+			stats.Traversed: 2,
+			stats.Matched:   2,
+			stats.Formatted: 2,
+			stats.Changed:   2,
+		}),
+	)
+
+	// the staged content was formatted and staged again, leaving unstaged changes untouched
+	as.Equal("{\n  \"a\": 1\n}\n", git("show", ":a.json"))
+	as.Equal("{\n  \"b\": 1\n}\n", git("show", ":b.json"))
+
+	for name, expected := range map[string]string{
+		"a.json": "{\n  \"a\": 1\n}\n",
+		"b.json": `{"b": 2}`,
+		"c.json": `{"c": 1}`,
+	} {
+		content, err := os.ReadFile(name)
+		as.NoError(err)
+		as.Equal(expected, string(content), name)
+	}
+
+	treefmt(t,
+		withArgs("--staged", "--since", "HEAD"),
+		withError(func(err error) {
+			as.ErrorContains(err, "the --staged flag cannot be used with the --since flag")
+		}),
+	)
+}
+
+func TestConflictMarkers(t *testing.T) {
+	as := require.New(t)
+
+	tempDir := test.TempExamples(t)
+	configPath := filepath.Join(tempDir, "treefmt.toml")
+
+	test.ChangeWorkDir(t, tempDir)
+
+	as.NoError(os.MkdirAll(filepath.Join(tempDir, "conflict"), 0o755))
+
+	conflicted := "<<<<<<< HEAD\n{\"a\": 1}\n=======\n{\"a\": 2}\n>>>>>>> feature\n"
+
+	for name, content := range map[string]string{
+		"conflict/a.json": conflicted,
+		"conflict/b.json": `{"b": 1}`,
+	} {
+		as.NoError(os.WriteFile(filepath.Join(tempDir, name), []byte(content), 0o600))
+	}
+
+	cfg := &config.Config{
+		FormatterConfigs: map[string]*config.Formatter{
+			"json": {
+				Command:  "builtin:json",
+				Includes: []string{"conflict/*.json"},
+			},
+		},
+	}
+
+	// the file with conflict markers is skipped, rather than failing to format
+	treefmt(t,
+		withConfig(configPath, cfg),
+		withNoError(t),
+		withStats(t, map[stats.Type]int{
+			stats.Traversed: 34,
+			stats.Matched:   1,
+			stats.Formatted: 1,
+			stats.Changed:   1,
+			stats.Skipped:   1,
+		}),
+	)
+
+	content, err := os.ReadFile(filepath.Join(tempDir, "conflict/a.json"))
+	as.NoError(err)
+	as.Equal(conflicted, string(content))
+}
+
+func TestLineEndings(t *testing.T) {
+	as := require.New(t)
+
+	tempDir := test.TempExamples(t)
+	configPath := filepath.Join(tempDir, "treefmt.toml")
+
+	test.ChangeWorkDir(t, tempDir)
+
+	as.NoError(os.MkdirAll(filepath.Join(tempDir, "eol"), 0o755))
+
+	// a.json is formatted apart from its line endings, which the formatter changes to \n
+	formatted := "{\r\n  \"a\": 1\r\n}\r\n"
+
+	write := func() {
+		for name, content := range map[string]string{
+			"eol/a.json": formatted,
+			"eol/b.json": `{"b": 1}`,
+		} {
+			as.NoError(os.WriteFile(filepath.Join(tempDir, name), []byte(content), 0o600))
+		}
+	}
+
+	read := func(name string) string {
+		content, err := os.ReadFile(filepath.Join(tempDir, name))
+		as.NoError(err)
+
+		return string(content)
+	}
+
+	cfg := &config.Config{
+		FormatterConfigs: map[string]*config.Formatter{
+			"json": {
+				Command:  "builtin:json",
+				Includes: []string{"eol/*.json"},
+			},
+		},
+	}
+
+	// by default, line endings are left to the formatter
+	write()
+	treefmt(t,
+		withArgs("--no-cache"),
+		withConfig(configPath, cfg),
+		withNoError(t),
+		withStats(t, map[stats.Type]int{
+			stats.Traversed: 34,
+			stats.Matched:   2,
+			stats.Formatted: 2,
+			stats.Changed:   2,
+		}),
+	)
+
+	as.Equal("{\n  \"a\": 1\n}\n", read("eol/a.json"))
+
+	// the original line endings are restored, so a.json is unchanged, whilst b.json had none to restore
+	write()
+	treefmt(t,
+		withArgs("--no-cache", "--line-endings", "preserve"),
+		withConfig(configPath, cfg),
+		withNoError(t),
+		withStats(t, map[stats.Type]int{
+			stats.Traversed: 34,
+			stats.Matched:   2,
+			stats.Formatted: 2,
+			stats.Changed:   1,
+		}),
+	)
+
+	as.Equal(formatted, read("eol/a.json"))
+	as.Equal("{\n  \"b\": 1\n}\n", read("eol/b.json"))
+
+	// line endings are enforced, regardless of those the file had beforehand
+	write()
+	treefmt(t,
+		withArgs("--no-cache", "--line-endings", "crlf"),
+		withConfig(configPath, cfg),
+		withNoError(t),
+		withStats(t, map[stats.Type]int{
+			stats.Traversed: 34,
+			stats.Matched:   2,
+			stats.Formatted: 2,
+			stats.Changed:   1,
+		}),
+	)
+
+	as.Equal(formatted, read("eol/a.json"))
+	as.Equal("{\r\n  \"b\": 1\r\n}\r\n", read("eol/b.json"))
+
+	// invalid values are rejected
+	treefmt(t,
+		withArgs("--line-endings", "cr"),
+		withConfig(configPath, cfg),
+		withError(func(err error) {
+			as.ErrorContains(err, "invalid line-endings value: cr")
+		}),
+	)
+}
+
+func TestEncoding(t *testing.T) {
+	as := require.New(t)
+
+	tempDir := test.TempExamples(t)
+	configPath := filepath.Join(tempDir, "treefmt.toml")
+
+	test.ChangeWorkDir(t, tempDir)
+
+	as.NoError(os.MkdirAll(filepath.Join(tempDir, "enc"), 0o755))
+
+	// a.json is UTF-16 with a byte order mark, b.json is latin-1 and c.json is UTF-16 which is already formatted
+	write := func() {
+		for name, content := range map[string]string{
+			"enc/a.json": "\xff\xfe{\x00\"\x00a\x00\"\x00:\x00\"\x00\xe9\x00\"\x00}\x00",
+			"enc/b.json": "{\"b\":\"\xe9\"}",
+			"enc/c.json": "{\x00}\x00\n\x00",
+		} {
+			as.NoError(os.WriteFile(filepath.Join(tempDir, name), []byte(content), 0o600))
+		}
+	}
+
+	read := func(name string) string {
+		content, err := os.ReadFile(filepath.Join(tempDir, name))
+		as.NoError(err)
+
+		return string(content)
+	}
+
+	cfg := &config.Config{
+		FormatterConfigs: map[string]*config.Formatter{
+			"json": {
+				Command:  "builtin:json",
+				Includes: []string{"enc/*.json"},
+				Encoding: format.EncodingSkip,
+			},
+		},
+	}
+
+	// files which are not encoded as UTF-8 are skipped
+	write()
+	treefmt(t,
+		withArgs("--no-cache"),
+		withConfig(configPath, cfg),
+		withNoError(t),
+		withStats(t, map[stats.Type]int{
+			stats.Traversed: 35,
+			stats.Matched:   0,
+			stats.Formatted: 0,
+			stats.Changed:   0,
+		}),
+	)
+
+	// or converted to UTF-8 whilst they are formatted, and back again afterwards
+	cfg.FormatterConfigs["json"].Encoding = format.EncodingTranscode
+
+	write()
+	treefmt(t,
+		withArgs("--no-cache"),
+		withConfig(configPath, cfg),
+		withNoError(t),
+		withStats(t, map[stats.Type]int{
+			stats.Traversed: 35,
+			stats.Matched:   3,
+			stats.Formatted: 3,
+			stats.Changed:   2,
+		}),
+	)
+
+	as.Equal(
+		"\xff\xfe{\x00\n\x00 \x00 \x00\"\x00a\x00\"\x00:\x00 \x00\"\x00\xe9\x00\"\x00\n\x00}\x00\n\x00",
+		read("enc/a.json"),
+	)
+	as.Equal("{\n  \"b\": \"\xe9\"\n}\n", read("enc/b.json"))
+	as.Equal("{\x00}\x00\n\x00", read("enc/c.json"))
+
+	// an invalid value is rejected
+	cfg.FormatterConfigs["json"].Encoding = "utf16"
+
+	treefmt(t,
+		withConfig(configPath, cfg),
+		withError(func(err error) {
+			as.ErrorContains(err, "invalid encoding value 'utf16'")
+		}),
+	)
+}
+
+func TestMaxChanges(t *testing.T) {
+	as := require.New(t)
+
+	tempDir := test.TempExamples(t)
+	configPath := filepath.Join(tempDir, "treefmt.toml")
+
+	test.ChangeWorkDir(t, tempDir)
+
+	as.NoError(os.MkdirAll(filepath.Join(tempDir, "changes"), 0o755))
+
+	names := []string{"changes/a.json", "changes/b.json", "changes/c.json"}
+
+	for _, name := range names {
+		as.NoError(os.WriteFile(filepath.Join(tempDir, name), []byte(`{"a":1}`), 0o600))
+	}
+
+	cfg := &config.Config{
+		FormatterConfigs: map[string]*config.Formatter{
+			"json": {
+				Command:  "builtin:json",
+				Includes: []string{"changes/*.json"},
+			},
+		},
+	}
+
+	// nothing is formatted if too many files would be, even those in batches which were full before the limit was
+	// exceeded
+	cfg.MaxChanges = 2
+
+	for _, args := range [][]string{{}, {"--batch-size", "1"}} {
+		treefmt(t,
+			withArgs(args...),
+			withConfig(configPath, cfg),
+			withError(func(err error) {
+				as.ErrorIs(err, format.ErrTooManyChanges)
+				as.ErrorContains(err, "3 files would be formatted, the limit is 2")
+			}),
+		)
+
+		for _, name := range names {
+			content, err := os.ReadFile(filepath.Join(tempDir, name))
+			as.NoError(err)
+			as.Equal(`{"a":1}`, string(content))
+		}
+	}
+
+	// the limit does not apply when checking, as nothing is written
+	treefmt(t,
+		withArgs("--check"),
+		withConfig(configPath, cfg),
+		withError(func(err error) {
+			as.ErrorIs(err, runner.ErrFailOnChange)
+		}),
+	)
+
+	// unless the files are formatted anyway
+	treefmt(t,
+		withArgs("--yes"),
+		withConfig(configPath, cfg),
+		withNoError(t),
+		withStats(t, map[stats.Type]int{
+			stats.Traversed: 35,
+			stats.Matched:   3,
+			stats.Formatted: 3,
+			stats.Changed:   3,
+		}),
+	)
+
+	// once formatted, the cached files would not be formatted again
+	treefmt(t,
+		withConfig(configPath, cfg),
+		withNoError(t),
+		withStats(t, map[stats.Type]int{
+			stats.Traversed: 35,
+			stats.Matched:   3,
+			stats.Formatted: 0,
+			stats.Changed:   0,
+		}),
+	)
+}
+
+func TestInteractive(t *testing.T) {
+	as := require.New(t)
+
+	tempDir := test.TempExamples(t)
+	configPath := filepath.Join(tempDir, "treefmt.toml")
+
+	test.ChangeWorkDir(t, tempDir)
+
+	as.NoError(os.MkdirAll(filepath.Join(tempDir, "interactive"), 0o755))
+
+	write := func() {
+		for name, content := range map[string]string{
+			"interactive/a.json": `{"a":1}`,
+			"interactive/b.txt":  "b  \n",
+		} {
+			as.NoError(os.WriteFile(filepath.Join(tempDir, name), []byte(content), 0o600))
+		}
+	}
+
+	read := func(name string) string {
+		content, err := os.ReadFile(filepath.Join(tempDir, name))
+		as.NoError(err)
+
+		return string(content)
+	}
+
+	// capture current stdin and replace it on test cleanup
+	prevStdIn := os.Stdin
+
+	t.Cleanup(func() {
+		os.Stdin = prevStdIn
+	})
+
+	answer := func(answers string) {
+		os.Stdin = test.TempFile(t, "", "stdin", &answers)
+	}
+
+	cfg := &config.Config{
+		FormatterConfigs: map[string]*config.Formatter{
+			"json": {
+				Command:  "builtin:json",
+				Includes: []string{"interactive/*.json"},
+			},
+			"whitespace": {
+				Command:  "builtin:trim-whitespace",
+				Includes: []string{"interactive/*.txt"},
+			},
+		},
+	}
+
+	// nothing is formatted unless confirmed
+	for _, answers := range []string{"n\n", ""} {
+		write()
+		answer(answers)
+
+		treefmt(t,
+			withArgs("--no-cache", "--interactive"),
+			withConfig(configPath, cfg),
+			withError(func(err error) {
+				as.ErrorIs(err, formatCmd.ErrAborted)
+			}),
+		)
+
+		as.Equal(`{"a":1}`, read("interactive/a.json"))
+		as.Equal("b  \n", read("interactive/b.txt"))
+	}
+
+	// formatters can be deselected, with an invalid selection being asked for again
+	write()
+	answer("3\n1\ny\n")
+
+	treefmt(t,
+		withArgs("--no-cache", "--interactive"),
+		withConfig(configPath, cfg),
+		withNoError(t),
+		withStats(t, map[stats.Type]int{
+			stats.Traversed: 34,
+			stats.Matched:   2,
+			stats.Formatted: 1,
+			stats.Changed:   1,
+		}),
+	)
+
+	as.Equal(`{"a":1}`, read("interactive/a.json"))
+	as.Equal("b\n", read("interactive/b.txt"))
+
+	// there is no prompt with --yes
+	write()
+	answer("")
+
+	treefmt(t,
+		withArgs("--no-cache", "--interactive", "--yes"),
+		withConfig(configPath, cfg),
+		withNoError(t),
+		withStats(t, map[stats.Type]int{
+			stats.Traversed: 34,
+			stats.Matched:   2,
+			stats.Formatted: 2,
+			stats.Changed:   2,
+		}),
+	)
+
+	// answers cannot be read from stdin if it is being formatted
+	treefmt(t,
+		withArgs("--interactive", "--stdin", "a.json"),
+		withConfig(configPath, cfg),
+		withError(func(err error) {
+			as.ErrorContains(err, "the --interactive flag cannot be used with the --stdin flag")
+		}),
+	)
+}
+
+func TestTUI(t *testing.T) {
+	as := require.New(t)
+
+	tempDir := test.TempExamples(t)
+	configPath := filepath.Join(tempDir, "treefmt.toml")
+
+	test.ChangeWorkDir(t, tempDir)
+
+	cfg := &config.Config{
+		FormatterConfigs: map[string]*config.Formatter{
+			"echo": {
+				Command:  "echo",
+				Includes: []string{"*"},
+			},
+		},
+	}
+
+	// the dashboard cannot share the terminal with anything else which reads from or writes to it
+	for _, args := range [][]string{
+		{"--stdin", "a.json"},
+		{"--stdin-filelist"},
+		{"--interactive"},
+		{"--dry-run"},
+		{"--watch"},
+	} {
+		treefmt(t,
+			withArgs(append([]string{"--tui"}, args...)...),
+			withConfig(configPath, cfg),
+			withError(func(err error) {
+				as.ErrorIs(err, formatCmd.FailureUsage)
+				as.ErrorContains(err, fmt.Sprintf("the --tui flag cannot be used with the %s flag", args[0]))
+			}),
+		)
+	}
+
+	// tests are not run in a terminal
+	treefmt(t,
+		withArgs("--tui"),
+		withConfig(configPath, cfg),
+		withError(func(err error) {
+			as.ErrorIs(err, formatCmd.FailureUsage)
+			as.ErrorContains(err, "the --tui flag requires a terminal")
+		}),
+	)
+}
+
+func TestCompletion(t *testing.T) {
+	as := require.New(t)
+
+	tempDir := test.TempExamples(t)
+	configPath := filepath.Join(tempDir, "treefmt.toml")
+
+	test.ChangeWorkDir(t, tempDir)
+
+	as.NoError(exec.Command("git", "init").Run(), "failed to init git repository")
+
+	cfg := &config.Config{
+		FormatterConfigs: map[string]*config.Formatter{
+			"json": {
+				Command:  "builtin:json",
+				Includes: []string{"*.json"},
+				Tags:     []string{"data"},
+			},
+			"toml": {
+				Command:  "builtin:toml",
+				Includes: []string{"*.toml"},
+				Tags:     []string{"data", "config"},
+			},
+			"gofmt": {
+				Command:  "builtin:gofmt",
+				Includes: []string{"*.go"},
+			},
+		},
+		Profiles: map[string]map[string]any{
+			"ci":    {"no-cache": true},
+			"local": {"quiet": true},
+		},
+	}
+
+	complete := func(expected []string, directive cobra.ShellCompDirective, args ...string) {
+		treefmt(t,
+			withArgs(append([]string{cobra.ShellCompRequestCmd}, args...)...),
+			withConfig(configPath, cfg),
+			withNoError(t),
+			withOutput(func(out []byte) {
+				lines := strings.Split(strings.TrimSpace(string(out)), "\n")
+				as.Equal(append(expected, fmt.Sprintf(":%d", directive)), lines[:len(lines)-1])
+			}),
+		)
+	}
+
+	noFiles := cobra.ShellCompDirectiveNoFileComp
+
+	// values are completed from the config file
+	complete([]string{"gofmt", "json", "toml"}, noFiles, "--formatters", "")
+	complete([]string{"json,gofmt", "json,toml"}, noFiles, "--formatters", "json,")
+	complete([]string{"gofmt", "json", "toml"}, noFiles, "--skip-formatters", "")
+	complete([]string{"config", "data"}, noFiles, "--tags", "")
+	complete([]string{"ci", "local"}, noFiles, "--profile", "")
+
+	// paths are completed from the git index, one directory at a time
+	complete([]string{"haskell-frontend/", "haskell/"}, noFiles|cobra.ShellCompDirectiveNoSpace, "has")
+	complete([]string{"rust/Cargo.toml", "rust/src/"}, noFiles|cobra.ShellCompDirectiveNoSpace, "explain", "rust/")
+	complete([]string{"go/main.go"}, noFiles, "go/m")
+}
+
+func TestDocs(t *testing.T) {
+	as := require.New(t)
+
+	tempDir := t.TempDir()
+	test.ChangeWorkDir(t, tempDir)
+
+	manDir := filepath.Join(tempDir, "man")
+	treefmt(t, withArgs("docs", "man", manDir), withNoError(t))
+
+	// a page for each command, and one for the config file
+	for _, name := range []string{"treefmt.1", "treefmt-cache.1", "treefmt-cache-info.1", "treefmt.toml.5"} {
+		as.FileExists(filepath.Join(manDir, name))
+	}
+
+	page, err := os.ReadFile(filepath.Join(manDir, "treefmt.1"))
+	as.NoError(err)
+	as.Contains(string(page), ".TH \"TREEFMT\" \"1\"")
+	as.Contains(string(page), "\\fB\\-\\-fail\\-on\\-change\\fR")
+
+	page, err = os.ReadFile(filepath.Join(manDir, "treefmt.toml.5"))
+	as.NoError(err)
+	as.Contains(string(page), ".SH [FORMATTER.<NAME>]")
+	as.Contains(string(page), "One of: formatter, preserve, lf, crlf.")
+
+	markdownDir := filepath.Join(tempDir, "markdown")
+	treefmt(t, withArgs("docs", "markdown", markdownDir), withNoError(t))
+
+	cli, err := os.ReadFile(filepath.Join(markdownDir, "cli.md"))
+	as.NoError(err)
+	as.Contains(string(cli), "## `treefmt cache info`")
+	as.Contains(string(cli), "--fail-on-change")
+
+	// entries are described by the usage of the corresponding flags, or their schema descriptions
+	reference, err := os.ReadFile(filepath.Join(markdownDir, "config.md"))
+	as.NoError(err)
+	as.Contains(string(reference), "### `fail-on-change`\n\nExit with error if any changes were made. Useful for CI.")
+	as.Contains(string(reference), "## `[formatter.<name>]`")
+	as.Contains(string(reference), "Type: array of strings")
+
+	// the directory must be given
+	treefmt(t, withArgs("docs", "man"), withError(func(err error) {
+		as.ErrorContains(err, "accepts 1 arg(s)")
+	}))
+}
+
+func TestFormatterOutput(t *testing.T) {
+	as := require.New(t)
+
+	tempDir := test.TempExamples(t)
+	configPath := filepath.Join(tempDir, "treefmt.toml")
+
+	test.ChangeWorkDir(t, tempDir)
+
+	cfg := &config.Config{
+		FormatterConfigs: map[string]*config.Formatter{
+			"echo": {
+				Command:  "echo",
+				Options:  []string{"formatting"},
+				Includes: []string{"*.go"},
+			},
+		},
+	}
+
+	// by default, the output is only logged at debug level
+	treefmt(t,
+		withArgs("-v", "--no-cache"),
+		withConfig(configPath, cfg),
+		withNoError(t),
+		withOutput(func(out []byte) {
+			as.NotContains(string(out), "echo: formatting go/main.go")
+		}),
+	)
+
+	treefmt(t,
+		withArgs("-vv", "--no-cache"),
+		withConfig(configPath, cfg),
+		withNoError(t),
+		withOutput(func(out []byte) {
+			as.Contains(string(out), "DEBU formatter | echo: formatting go/main.go\n")
+		}),
+	)
+
+	// it can be promoted to info level
+	cfg.FormatterConfigs["echo"].Output = "info"
+
+	treefmt(t,
+		withArgs("-v", "--no-cache"),
+		withConfig(configPath, cfg),
+		withNoError(t),
+		withOutput(func(out []byte) {
+			as.Contains(string(out), "INFO formatter | echo: formatting go/main.go\n")
+		}),
+	)
+
+	// or not logged at all
+	cfg.FormatterConfigs["echo"].Output = "never"
+
+	treefmt(t,
+		withArgs("-vv", "--no-cache"),
+		withConfig(configPath, cfg),
+		withNoError(t),
+		withOutput(func(out []byte) {
+			as.NotContains(string(out), "echo: formatting go/main.go")
+		}),
+	)
+
+	// anything else is rejected
+	cfg.FormatterConfigs["echo"].Output = "warn"
+
+	treefmt(t,
+		withConfig(configPath, cfg),
+		withError(func(err error) {
+			as.ErrorContains(err, "formatter 'echo' has an invalid output value 'warn'")
+		}),
+	)
+}
+
+func TestTimeout(t *testing.T) {
+	as := require.New(t)
+
+	tempDir := test.TempExamples(t)
+	configPath := filepath.Join(tempDir, "treefmt.toml")
+
+	test.ChangeWorkDir(t, tempDir)
+
+	cfg := &config.Config{
+		FormatterConfigs: map[string]*config.Formatter{
+			"hang": {
+				Command:  "sh",
+				Options:  []string{"-c", "sleep 30; true", "sh"},
+				Includes: []string{"*.go"},
+				Timeout:  "100ms",
+			},
+		},
+	}
+
+	// a hung formatter is killed, and its files are recorded as errored
+	start := time.Now()
+
+	treefmt(t,
+		withConfig(configPath, cfg),
+		withError(func(err error) {
+			as.ErrorIs(err, format.ErrFormattingFailures)
+		}),
+		withStats(t, map[stats.Type]int{
+			stats.Traversed: 32,
+			stats.Matched:   1,
+			stats.Formatted: 0,
+			stats.Errored:   1,
+		}),
+		withOutput(func(out []byte) {
+			as.Contains(string(out), "timed out after 100ms")
+		}),
+	)
+
+	as.Less(time.Since(start), 10*time.Second)
+
+	// the global timeout applies to formatters which don't specify their own
+	cfg.FormatterConfigs["hang"].Timeout = ""
+
+	treefmt(t,
+		withArgs("--timeout", "100ms"),
+		withConfig(configPath, cfg),
+		withError(func(err error) {
+			as.ErrorIs(err, format.ErrFormattingFailures)
+		}),
+		withStats(t, map[stats.Type]int{
+			stats.Traversed: 32,
+			stats.Matched:   1,
+			stats.Formatted: 0,
+			stats.Errored:   1,
+		}),
+	)
+
+	// a formatter's own timeout takes precedence over the global timeout
+	cfg.FormatterConfigs["hang"].Options = []string{"-c", "sleep 0.5", "sh"}
+	cfg.FormatterConfigs["hang"].Timeout = "10s"
+
+	treefmt(t,
+		withArgs("--timeout", "100ms"),
+		withConfig(configPath, cfg),
+		withNoError(t),
+		withStats(t, map[stats.Type]int{
+			stats.Traversed: 32,
+			stats.Matched:   1,
+			stats.Formatted: 1,
+			stats.Errored:   0,
+		}),
+	)
+
+	// invalid durations
+	treefmt(t,
+		withArgs("--timeout", "forever"),
+		withConfig(configPath, cfg),
+		withError(func(err error) {
+			as.ErrorContains(err, "invalid timeout value")
+		}),
+	)
+
+	cfg.FormatterConfigs["hang"].Timeout = "10"
+
+	treefmt(t,
+		withConfig(configPath, cfg),
+		withError(func(err error) {
+			as.ErrorContains(err, "formatter 'hang' has an invalid timeout")
+		}),
+	)
+}
+
+func TestJobs(t *testing.T) {
+	as := require.New(t)
+
+	tempDir := test.TempExamples(t)
+	configPath := filepath.Join(tempDir, "treefmt.toml")
+
+	test.ChangeWorkDir(t, tempDir)
+
+	// each formatter fails if another formatter process is running at the same time
+	lockDir := filepath.Join(t.TempDir(), "lock")
+	script := fmt.Sprintf(`mkdir %[1]s || exit 1; sleep 0.1; rmdir %[1]s`, lockDir)
+
+	cfg := &config.Config{
+		FormatterConfigs: map[string]*config.Formatter{},
+	}
+
+	for name, include := range map[string]string{
+		"go":     "*.go",
+		"nix":    "*.nix",
+		"python": "*.py",
+		"ruby":   "*.rb",
+	} {
+		cfg.FormatterConfigs[name] = &config.Formatter{
+			Command:  "sh",
+			Options:  []string{"-c", script, "sh"},
+			Includes: []string{include},
+		}
+	}
+
+	treefmt(t,
+		withArgs("--jobs", "1"),
+		withConfig(configPath, cfg),
+		withNoError(t),
+		withStats(t, map[stats.Type]int{
+			stats.Traversed: 32,
+			stats.Matched:   5,
+			stats.Formatted: 5,
+		}),
+	)
+
+	// negative values are rejected
+	treefmt(t,
+		withArgs("-c", "--jobs", "-1"),
+		withConfig(configPath, cfg),
+		withError(func(err error) {
+			as.ErrorContains(err, "invalid jobs value")
+		}),
+	)
+}
+
+func TestExclusive(t *testing.T) {
+	tempDir := test.TempExamples(t)
+	configPath := filepath.Join(tempDir, "treefmt.toml")
+
+	test.ChangeWorkDir(t, tempDir)
+
+	// the exclusive formatters fail if any other exclusive formatter process is running at the same time
+	lockDir := filepath.Join(t.TempDir(), "lock")
+	exclusive := []string{"-c", fmt.Sprintf(`mkdir %[1]s || exit 1; sleep 0.1; rmdir %[1]s`, lockDir), "sh"}
+
+	// whilst the others fail if they are not run alongside an exclusive formatter process
+	waitForLock := []string{"-c", fmt.Sprintf(
+		`for i in $(seq 50); do test -d %[1]s && exit 0; sleep 0.1; done; exit 1`, lockDir,
+	), "sh"}
+
+	cfg := &config.Config{
+		FormatterConfigs: map[string]*config.Formatter{
+			"haskell": {
+				Command:   "sh",
+				Options:   exclusive,
+				Includes:  []string{"*.hs"},
+				BatchSize: 1,
+				Parallel:  2,
+				Exclusive: true,
+			},
+			"nix": {
+				Command:   "sh",
+				Options:   exclusive,
+				Includes:  []string{"*.nix"},
+				Exclusive: true,
+			},
+			"python": {
+				Command:  "sh",
+				Options:  waitForLock,
+				Includes: []string{"*.py"},
+			},
+			"ruby": {
+				Command:  "sh",
+				Options:  waitForLock,
+				Includes: []string{"*.rb"},
+			},
+		},
+	}
+
+	treefmt(t,
+		withArgs("--jobs", "4"),
+		withConfig(configPath, cfg),
+		withNoError(t),
+		withStats(t, map[stats.Type]int{
+			stats.Traversed: 32,
+			stats.Matched:   10,
+			stats.Formatted: 10,
+		}),
+	)
+}
+
+func TestParallel(t *testing.T) {
+	as := require.New(t)
+
+	tempDir := test.TempExamples(t)
+	configPath := filepath.Join(tempDir, "treefmt.toml")
+
+	test.ChangeWorkDir(t, tempDir)
+
+	// record how many files each formatter process was given
+	logPath := filepath.Join(t.TempDir(), "invocations.log")
+
+	cfg := &config.Config{
+		FormatterConfigs: map[string]*config.Formatter{
+			"count": {
+				Command:  "sh",
+				Options:  []string{"-c", fmt.Sprintf(`echo $# >> %s`, logPath), "sh"},
+				Includes: []string{"*"},
+				Parallel: 4,
+			},
+		},
+	}
+
+	treefmt(t,
+		withConfig(configPath, cfg),
+		withNoError(t),
+		withStats(t, map[stats.Type]int{
+			stats.Traversed: 32,
+			stats.Matched:   32,
+			stats.Formatted: 32,
+		}),
+	)
+
+	// the batch should have been split evenly across four processes
+	invocations, err := os.ReadFile(logPath)
+	as.NoError(err)
+	as.Equal("8\n8\n8\n8\n", string(invocations))
+
+	// negative values are rejected
+	cfg.FormatterConfigs["count"].Parallel = -1
+
+	treefmt(t,
+		withArgs("-c"),
+		withConfig(configPath, cfg),
+		withError(func(err error) {
+			as.ErrorContains(err, "formatter 'count' has an invalid parallel value")
+		}),
+	)
+}
+
+func TestProjectMode(t *testing.T) {
+	as := require.New(t)
+
+	tempDir := t.TempDir()
+	configPath := filepath.Join(tempDir, "treefmt.toml")
+
+	test.ChangeWorkDir(t, tempDir)
+
+	// two projects, and a file which is not within either of them
+	for path, content := range map[string]string{
+		"a/Cargo.toml":     "",
+		"a/src/main.rs":    "fn main() {}",
+		"a/src/lib.rs":     "",
+		"b/Cargo.toml":     "",
+		"b/src/main.rs":    "fn main() {}",
+		"scripts/loose.rs": "",
+	} {
+		as.NoError(os.MkdirAll(filepath.Dir(path), 0o755))
+		as.NoError(os.WriteFile(path, []byte(content), 0o600))
+	}
+
+	// record the directory the formatter is run within, and how many files it is given
+	logPath := filepath.Join(t.TempDir(), "invocations.log")
+
+	cfg := &config.Config{
+		FormatterConfigs: map[string]*config.Formatter{
+			"cargo": {
+				Command:     "sh",
+				Options:     []string{"-c", fmt.Sprintf(`echo "$(basename "$(pwd)") $#" >> %s`, logPath), "sh"},
+				Includes:    []string{"*.rs"},
+				Mode:        "project",
+				RootMarkers: []string{"Cargo.toml"},
+			},
+		},
+	}
+
+	invocations := func() []string {
+		content, err := os.ReadFile(logPath)
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+
+		as.NoError(err)
+		as.NoError(os.Remove(logPath))
+
+		lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+		slices.Sort(lines)
+
+		return lines
+	}
+
+	// the formatter is run once within each project, and files outside of them are not matched
+	treefmt(t,
+		withArgs("--walk", "filesystem"),
+		withConfig(configPath, cfg),
+		withNoError(t),
+		withStats(t, map[stats.Type]int{
+			stats.Traversed: 7,
+			stats.Matched:   3,
+			stats.Formatted: 3,
+		}),
+	)
+
+	as.Equal([]string{"a 0", "b 0"}, invocations())
+
+	// only projects containing files which have changed are formatted again
+	as.NoError(os.WriteFile("b/src/main.rs", []byte("fn main() { }"), 0o600))
+
+	treefmt(t,
+		withArgs("--walk", "filesystem"),
+		withConfig(configPath, cfg),
+		withNoError(t),
+		withStats(t, map[stats.Type]int{
+			stats.Traversed: 7,
+			stats.Matched:   3,
+			stats.Formatted: 1,
+		}),
+	)
+
+	as.Equal([]string{"b 0"}, invocations())
+
+	// the formatter could modify files outside of the check sandbox, so it is skipped
+	treefmt(t,
+		withArgs("--walk", "filesystem", "--check", "--no-cache"),
+		withConfig(configPath, cfg),
+		withNoError(t),
+		withStats(t, map[stats.Type]int{
+			stats.Traversed: 7,
+			stats.Matched:   0,
+		}),
+	)
+
+	as.Empty(invocations())
+
+	// the files of a project are found by the formatter itself
+	cfg.FormatterConfigs["cargo"].BatchSize = 10
+
+	treefmt(t,
+		withConfig(configPath, cfg),
+		withError(func(err error) {
+			as.ErrorContains(err, "formatter 'cargo' is run once per project, so cannot have a batch-size")
+		}),
+	)
+
+	cfg.FormatterConfigs["cargo"].BatchSize = 0
+	cfg.FormatterConfigs["cargo"].RootMarkers = nil
+
+	treefmt(t,
+		withConfig(configPath, cfg),
+		withError(func(err error) {
+			as.ErrorContains(err, "formatter 'cargo' is run once per project, so must have root-markers")
+		}),
+	)
+
+	cfg.FormatterConfigs["cargo"].Mode = ""
+	cfg.FormatterConfigs["cargo"].RootMarkers = []string{"Cargo.toml"}
+
+	treefmt(t,
+		withConfig(configPath, cfg),
+		withError(func(err error) {
+			as.ErrorContains(err, "formatter 'cargo' has root-markers but mode is not set to project")
+		}),
+	)
+}
+
+func TestFileList(t *testing.T) {
+	as := require.New(t)
+
+	tempDir := test.TempExamples(t)
+	configPath := filepath.Join(tempDir, "treefmt.toml")
+
+	test.ChangeWorkDir(t, tempDir)
+
+	// record the path of each file list and how many args the formatter is given, then append to each file listed
+	logPath := filepath.Join(t.TempDir(), "invocations.log")
+	script := fmt.Sprintf(
+		`list="${1#@}"; echo "$list $#" >> %s; while read -r f; do echo >> "$f"; done < "$list"`, logPath,
+	)
+
+	cfg := &config.Config{
+		FormatterConfigs: map[string]*config.Formatter{
+			"haskell": {
+				Command:   "sh",
+				Options:   []string{"-c", script, "sh", "@{filelist}"},
+				Includes:  []string{"*.hs"},
+				BatchSize: 2,
+			},
+		},
+	}
+
+	treefmt(t,
+		withConfig(configPath, cfg),
+		withNoError(t),
+		withStats(t, map[stats.Type]int{
+			stats.Traversed: 32,
+			stats.Matched:   6,
+			stats.Formatted: 6,
+			stats.Changed:   6,
+		}),
+	)
+
+	content, err := os.ReadFile(logPath)
+	as.NoError(err)
+
+	invocations := strings.Split(strings.TrimSpace(string(content)), "\n")
+	as.Len(invocations, 3)
+
+	for _, invocation := range invocations {
+		list, count, ok := strings.Cut(invocation, " ")
+		as.True(ok)
+
+		// the files are passed in the list rather than as args, and the list is removed afterwards
+		as.Equal("1", count)
+		as.NoFileExists(list)
+	}
+
+	// formatters which write to stdout are passed a single file at a time
+	cfg.FormatterConfigs["haskell"].Stdout = true
+
+	treefmt(t,
+		withConfig(configPath, cfg),
+		withError(func(err error) {
+			as.ErrorContains(err, "formatter 'haskell' cannot be passed a {filelist} and also write its output to stdout")
+		}),
+	)
+}
+
+func TestBatchSize(t *testing.T) {
+	as := require.New(t)
+
+	tempDir := test.TempExamples(t)
+	configPath := filepath.Join(tempDir, "treefmt.toml")
+
+	test.ChangeWorkDir(t, tempDir)
+
+	// record how many files each formatter process was given
+	logPath := filepath.Join(t.TempDir(), "invocations.log")
+
+	cfg := &config.Config{
+		FormatterConfigs: map[string]*config.Formatter{
+			"count": {
+				Command:  "sh",
+				Options:  []string{"-c", fmt.Sprintf(`echo $# >> %s`, logPath), "sh"},
+				Includes: []string{"*"},
+			},
+		},
+	}
+
+	checkInvocations := func(expected ...string) {
+		t.Helper()
+
+		content, err := os.ReadFile(logPath)
+		as.NoError(err)
+
+		invocations := strings.Fields(string(content))
+		slices.Sort(invocations)
+		slices.Sort(expected)
+
+		as.Equal(expected, invocations)
+		as.NoError(os.Remove(logPath))
+	}
+
+	// global batch size
+	treefmt(t,
+		withArgs("--batch-size", "10"),
+		withConfig(configPath, cfg),
+		withNoError(t),
+		withStats(t, map[stats.Type]int{
+			stats.Traversed: 32,
+			stats.Matched:   32,
+			stats.Formatted: 32,
+		}),
+	)
+
+	checkInvocations("10", "10", "10", "2")
+
+	// per-formatter batch size
+	cfg.FormatterConfigs["count"].BatchSize = 5
+
+	treefmt(t,
+		withArgs("-c"),
+		withConfig(configPath, cfg),
+		withNoError(t),
+		withStats(t, map[stats.Type]int{
+			stats.Traversed: 32,
+			stats.Matched:   32,
+			stats.Formatted: 32,
+		}),
+	)
+
+	checkInvocations("5", "5", "5", "5", "5", "5", "2")
+
+	// a batch size smaller than the share of each parallel process takes precedence
+	cfg.FormatterConfigs["count"].Parallel = 2
+
+	treefmt(t,
+		withArgs("-c"),
+		withConfig(configPath, cfg),
+		withNoError(t),
+		withStats(t, map[stats.Type]int{
+			stats.Traversed: 32,
+			stats.Matched:   32,
+			stats.Formatted: 32,
+		}),
+	)
+
+	checkInvocations("5", "5", "5", "5", "5", "5", "2")
+
+	// invalid values
+	treefmt(t,
+		withArgs("-c", "--batch-size", "-1"),
+		withConfig(configPath, cfg),
+		withError(func(err error) {
+			as.ErrorContains(err, "invalid batch-size value")
+		}),
+	)
+
+	cfg.FormatterConfigs["count"].BatchSize = -1
+
+	treefmt(t,
+		withArgs("-c"),
+		withConfig(configPath, cfg),
+		withError(func(err error) {
+			as.ErrorContains(err, "formatter 'count' has an invalid batch-size")
+		}),
+	)
+}
+
+func TestConfigFile(t *testing.T) {
+	as := require.New(t)
+
+	for _, name := range []string{"treefmt.toml", ".treefmt.toml"} {
+		t.Run(name, func(t *testing.T) {
+			tempDir := test.TempExamples(t)
+
+			// use a config file in a different temp directory
+			configPath := filepath.Join(t.TempDir(), name)
+
+			// if we don't specify a tree root, we default to the directory containing the config file
+			treefmt(t,
+				withConfig(configPath, &config.Config{
+					FormatterConfigs: map[string]*config.Formatter{
+						"echo": {
+							Command:  "echo",
+							Includes: []string{"*"},
+						},
+					},
+				}),
+				withArgs("--config-file", configPath),
+				withNoError(t),
+				withStats(t, map[stats.Type]int{
+					stats.Traversed: 1,
+					stats.Matched:   1,
+					stats.Formatted: 1,
+					stats.Changed:   0,
+				}),
+			)
+
+			treefmt(t,
+				withArgs("--config-file", configPath, "--tree-root", tempDir),
+				withNoError(t),
+				withStats(t, map[stats.Type]int{
+					stats.Traversed: 32,
+					stats.Matched:   32,
+					stats.Formatted: 32,
+					stats.Changed:   0,
+				}),
+			)
+
+			// use env variable
+			treefmt(t,
+				withEnv(map[string]string{
+					// TREEFMT_CONFIG takes precedence
+					"TREEFMT_CONFIG": configPath,
+					"PRJ_ROOT":       tempDir,
+				}),
+				withNoError(t),
+				withStats(t, map[stats.Type]int{
+					stats.Traversed: 1,
+					stats.Matched:   1,
+					stats.Formatted: 0,
+					stats.Changed:   0,
+				}),
+			)
+
+			// should fallback to PRJ_ROOT
+			treefmt(t,
+				withArgs("--tree-root", tempDir),
+				withEnv(map[string]string{
+					"PRJ_ROOT": filepath.Dir(configPath),
+				}),
+				withNoError(t),
+				withStats(t, map[stats.Type]int{
+					stats.Traversed: 32,
+					stats.Matched:   32,
+					stats.Formatted: 0,
+					stats.Changed:   0,
+				}),
+			)
+
+			// should not search upwards if using PRJ_ROOT
+			configSubDir := filepath.Join(filepath.Dir(configPath), "sub")
+			as.NoError(os.MkdirAll(configSubDir, 0o600))
+
+			treefmt(t,
+				withArgs("--tree-root", tempDir),
+				withEnv(map[string]string{
+					"PRJ_ROOT": configSubDir,
+				}),
+				withError(func(err error) {
+					as.ErrorContains(err, "failed to find treefmt config file")
+				}),
+			)
+		})
+	}
+}
+
+func TestCache(t *testing.T) {
+	as := require.New(t)
+
+	tempDir := test.TempExamples(t)
+	configPath := filepath.Join(tempDir, "treefmt.toml")
+
+	test.ChangeWorkDir(t, tempDir)
+
+	// test without any excludes
+	cfg := &config.Config{
+		FormatterConfigs: map[string]*config.Formatter{
+			"append": {
+				Command:  "test-fmt-append",
+				Options:  []string{"   "},
+				Includes: []string{"*"},
+			},
+		},
+	}
+
+	test.WriteConfig(t, configPath, cfg)
+
+	// first run
+	treefmt(t,
+		withNoError(t),
+		withStats(t, map[stats.Type]int{
+			stats.Traversed: 32,
+			stats.Matched:   32,
+			stats.Formatted: 32,
+			stats.Changed:   32,
+		}),
+	)
+
+	// cached run with no changes to underlying files
+	treefmt(t,
+		withNoError(t),
+		withStats(t, map[stats.Type]int{
+			stats.Traversed: 32,
+			stats.Matched:   32,
+			stats.Formatted: 0,
+			stats.Changed:   0,
+		}),
+	)
+
+	// clear cache
+	treefmt(t,
+		withArgs("-c"),
+		withNoError(t),
+		withStats(t, map[stats.Type]int{
+			stats.Traversed: 32,
+			stats.Matched:   32,
+			stats.Formatted: 32,
+			stats.Changed:   32,
+		}),
+	)
+
+	// cached run with no changes to underlying files
+	treefmt(t,
+		withNoError(t),
+		withStats(t, map[stats.Type]int{
+			stats.Traversed: 32,
+			stats.Matched:   32,
+			stats.Formatted: 0,
+			stats.Changed:   0,
+		}),
+	)
+
+	// bump underlying files
+	treefmt(t,
+		withNoError(t),
+		withModtimeBump(tempDir, time.Second),
+		withStats(t, map[stats.Type]int{
+			stats.Traversed: 32,
+			stats.Matched:   32,
+			stats.Formatted: 32,
+			stats.Changed:   32,
+		}),
+	)
+
+	// no cache
+	treefmt(t,
+		withArgs("--no-cache"),
+		withNoError(t),
+		withStats(t, map[stats.Type]int{
+			stats.Traversed: 32,
+			stats.Matched:   32,
+			stats.Formatted: 32,
+			stats.Changed:   32,
+		}),
+	)
+
+	// update the config with a failing formatter
+	cfg = &config.Config{
+		FormatterConfigs: map[string]*config.Formatter{
+			// fails to execute
+			"fail": {
+				Command:  "touch",
+				Options:  []string{"--bad-arg"},
+				Includes: []string{"*.hs"},
+			},
+		},
+	}
+	test.WriteConfig(t, configPath, cfg)
+
+	// test that formatting errors are not cached
+
+	// running should match but not format anything
+
+	treefmt(t,
+		withError(func(err error) {
+			as.ErrorIs(err, format.ErrFormattingFailures)
+		}),
+		withStats(t, map[stats.Type]int{
+			stats.Traversed: 32,
+			stats.Matched:   6,
+			stats.Formatted: 0,
+			stats.Changed:   0,
+		}),
+	)
+
+	// running again should provide the same result
+	treefmt(t,
+		withError(func(err error) {
+			as.ErrorIs(err, format.ErrFormattingFailures)
+		}),
+		withStats(t, map[stats.Type]int{
+			stats.Traversed: 32,
+			stats.Matched:   6,
+			stats.Formatted: 0,
+			stats.Changed:   0,
+		}),
+	)
+
+	// let's fix the haskell config so it no longer fails
+	cfg.FormatterConfigs["fail"] = &config.Formatter{
+		Command:  "test-fmt-append",
+		Options:  []string{"   "},
+		Includes: []string{"*.hs"},
+	}
+
+	test.WriteConfig(t, configPath, cfg)
+
+	// we should now format the haskell files
+	treefmt(t,
+		withNoError(t),
+		withStats(t, map[stats.Type]int{
+			stats.Traversed: 32,
+			stats.Matched:   6,
+			stats.Formatted: 6,
+			stats.Changed:   6,
+		}),
+	)
+}
+
+func TestKeepGoing(t *testing.T) {
+	as := require.New(t)
+
+	tempDir := test.TempExamples(t)
+	configPath := filepath.Join(tempDir, "treefmt.toml")
+
+	test.ChangeWorkDir(t, tempDir)
+
+	cfg := &config.Config{
+		FormatterConfigs: map[string]*config.Formatter{
+			// fails to execute
+			"fail": {
+				Command:  "touch",
+				Options:  []string{"--bad-arg"},
+				Includes: []string{"*.hs"},
+				Priority: 1,
+			},
+			"append": {
+				Command:  "test-fmt-append",
+				Options:  []string{"   "},
+				Includes: []string{"*.hs"},
+				Priority: 2,
+			},
+		},
+	}
+
+	// by default, we stop applying formatters after the first failure
+	treefmt(t,
+		withConfig(configPath, cfg),
+		withError(func(err error) {
+			as.ErrorIs(err, format.ErrFormattingFailures)
+		}),
+		withStats(t, map[stats.Type]int{
+			stats.Traversed: 32,
+			stats.Matched:   6,
+			stats.Formatted: 0,
+			stats.Changed:   0,
+			stats.Errored:   6,
+		}),
+		withOutput(func(out []byte) {
+			as.Contains(string(out), "--bad-arg")
+			as.NotContains(string(out), "formatting failure(s)")
+		}),
+	)
+
+	// batches which had not started when the failure occurred are skipped
+	treefmt(t,
+		withArgs("--jobs", "1", "--batch-size", "2"),
+		withConfig(configPath, cfg),
+		withError(func(err error) {
+			as.ErrorIs(err, format.ErrFormattingFailures)
+		}),
+		withStats(t, map[stats.Type]int{
+			stats.Traversed: 32,
+			stats.Matched:   6,
+			stats.Formatted: 0,
+			stats.Changed:   0,
+			stats.Errored:   2,
+			stats.Skipped:   4,
+		}),
+		withOutput(func(out []byte) {
+			as.Contains(string(out), "skipped 4 files")
+		}),
+	)
+
+	// when keeping going, the remaining formatters are applied and the failures are reported at the end
+	treefmt(t,
+		withArgs("--keep-going"),
+		withConfig(configPath, cfg),
+		withError(func(err error) {
+			as.ErrorIs(err, format.ErrFormattingFailures)
+		}),
+		withStats(t, map[stats.Type]int{
+			stats.Traversed: 32,
+			stats.Matched:   6,
+			stats.Formatted: 0,
+			stats.Changed:   6,
+			stats.Errored:   6,
+		}),
+		withOutput(func(out []byte) {
+			as.Contains(string(out), "1 formatting failure(s)")
+			as.Contains(string(out), "formatter 'fail' failed to format 6 file(s)")
+			as.Contains(string(out), "haskell/Foo.hs")
+			as.Contains(string(out), "--bad-arg")
+		}),
+	)
+}
+
+func TestDryRun(t *testing.T) {
+	as := require.New(t)
+
+	tempDir := test.TempExamples(t)
+	configPath := filepath.Join(tempDir, "treefmt.toml")
+
+	test.ChangeWorkDir(t, tempDir)
+
+	cfg := &config.Config{
+		FormatterConfigs: map[string]*config.Formatter{
+			"append": {
+				Command:  "test-fmt-append",
+				Options:  []string{"   "},
+				Includes: []string{"*.hs"},
+			},
+			"echo": {
+				Command:  "echo",
+				Includes: []string{"*.hs"},
+				Priority: 1,
+			},
+		},
+	}
+
+	// nothing should be formatted
+	treefmt(t,
+		withArgs("--dry-run"),
+		withConfig(configPath, cfg),
+		withNoError(t),
+		withStats(t, map[stats.Type]int{
+			stats.Traversed: 32,
+			stats.Matched:   6,
+			stats.Formatted: 0,
+			stats.Changed:   0,
+		}),
+		withOutput(func(out []byte) {
+			as.Contains(string(out), "haskell/Foo.hs: append, echo\n")
+			as.Contains(string(out), "haskell/Setup.hs: append, echo\n")
+		}),
+	)
+
+	// when outputting json, the files are listed in the summary rather than ahead of it
+	treefmt(t,
+		withArgs("--dry-run", "--output", "json"),
+		withConfig(configPath, cfg),
+		withNoError(t),
+		withOutput(func(out []byte) {
+			// skip any log output which precedes the summary
+			start := bytes.IndexByte(out, '{')
+			as.GreaterOrEqual(start, 0, "no summary in output: %s", out)
+			as.NotContains(string(out[:start]), "haskell/Foo.hs")
+
+			var summary stats.Summary
+			as.NoError(json.Unmarshal(out[start:], &summary))
+
+			as.Len(summary.Planned, 6)
+			as.Contains(summary.Planned, stats.Plan{
+				Path: "haskell/Foo.hs", Formatters: []string{"append", "echo"},
+			})
+		}),
+	)
+
+	// the cache should not have been updated, so a normal run formats everything
+	treefmt(t,
+		withConfig(configPath, cfg),
+		withNoError(t),
+		withStats(t, map[stats.Type]int{
+			stats.Traversed: 32,
+			stats.Matched:   6,
+			stats.Formatted: 6,
+			stats.Changed:   6,
+		}),
+	)
+
+	// files which are already formatted according to the cache are not listed
+	treefmt(t,
+		withArgs("--dry-run"),
+		withConfig(configPath, cfg),
+		withNoError(t),
+		withStats(t, map[stats.Type]int{
+			stats.Traversed: 32,
+			stats.Matched:   6,
+			stats.Formatted: 0,
+			stats.Changed:   0,
+		}),
+		withOutput(func(out []byte) {
+			as.NotContains(string(out), "haskell/Foo.hs: append, echo")
+		}),
+	)
+}
+
+func TestCheck(t *testing.T) {
+	as := require.New(t)
+
+	tempDir := test.TempExamples(t)
+	configPath := filepath.Join(tempDir, "treefmt.toml")
+
+	test.ChangeWorkDir(t, tempDir)
+
+	cfg := &config.Config{
+		FormatterConfigs: map[string]*config.Formatter{
+			"append": {
+				Command:  "test-fmt-append",
+				Options:  []string{"   "},
+				Includes: []string{"*.hs"},
+			},
+			// fails unless the rest of the tree is visible from the directory it runs in, which is copied rather than
+			// linked, so writing to its files does not modify the tree
+			"config": {
+				Command: "sh",
+				Options: []string{
+					"-c", "test -f treefmt.toml && test -f haskell/haskell.cabal && echo >> haskell/haskell.cabal", "sh",
+				},
+				Includes: []string{"*.hs"},
+				Priority: 1,
+			},
+		},
+	}
+
+	original, err := os.ReadFile(filepath.Join(tempDir, "haskell/Foo.hs"))
+	as.NoError(err)
+
+	cabal, err := os.ReadFile(filepath.Join(tempDir, "haskell/haskell.cabal"))
+	as.NoError(err)
+
+	// files which are not formatted are reported, without being modified
+	treefmt(t,
+		withArgs("--check"),
+		withConfig(configPath, cfg),
+		withError(func(err error) {
+			as.ErrorIs(err, runner.ErrFailOnChange)
+		}),
+		withStats(t, map[stats.Type]int{
+			stats.Traversed: 32,
+			stats.Matched:   6,
+			stats.Formatted: 6,
+			stats.Changed:   6,
+		}),
+		withOutput(func(out []byte) {
+			as.Contains(string(out), "file is not formatted")
+		}),
+	)
+
+	content, err := os.ReadFile(filepath.Join(tempDir, "haskell/Foo.hs"))
+	as.NoError(err)
+	as.Equal(original, content)
+
+	content, err = os.ReadFile(filepath.Join(tempDir, "haskell/haskell.cabal"))
+	as.NoError(err)
+	as.Equal(cabal, content)
+
+	// the cache should not have been updated, so checking again gives the same result
+	treefmt(t,
+		withArgs("--check"),
+		withConfig(configPath, cfg),
+		withError(func(err error) {
+			as.ErrorIs(err, runner.ErrFailOnChange)
+		}),
+		withStats(t, map[stats.Type]int{
+			stats.Traversed: 32,
+			stats.Matched:   6,
+			stats.Formatted: 6,
+			stats.Changed:   6,
+		}),
+	)
+
+	// once formatted, the check passes
+	cfg.FormatterConfigs["append"].Command = "echo"
+
+	treefmt(t,
+		withArgs("--check", "--no-cache"),
+		withConfig(configPath, cfg),
+		withNoError(t),
+		withStats(t, map[stats.Type]int{
+			stats.Traversed: 32,
+			stats.Matched:   6,
+			stats.Formatted: 6,
+			stats.Changed:   0,
+		}),
+	)
+}
+
+func TestDiff(t *testing.T) {
+	as := require.New(t)
+
+	tempDir := test.TempExamples(t)
+	configPath := filepath.Join(tempDir, "treefmt.toml")
+
+	test.ChangeWorkDir(t, tempDir)
+
+	cfg := &config.Config{
+		FormatterConfigs: map[string]*config.Formatter{
+			"append": {
+				Command:  "test-fmt-append",
+				Options:  []string{"   "},
+				Includes: []string{"*.hs"},
+			},
+		},
+	}
+
+	// diffs are not printed unless changes are considered a failure
+	treefmt(t,
+		withArgs("--diff", "--no-cache"),
+		withConfig(configPath, cfg),
+		withNoError(t),
+		withOutput(func(out []byte) {
+			as.NotContains(string(out), "--- a/haskell/Foo.hs")
+		}),
+	)
+
+	// in check mode, the diff describes the change which would be made
+	treefmt(t,
+		withArgs("--check", "--diff"),
+		withConfig(configPath, cfg),
+		withError(func(err error) {
+			as.ErrorIs(err, runner.ErrFailOnChange)
+		}),
+		withStats(t, map[stats.Type]int{
+			stats.Traversed: 32,
+			stats.Matched:   6,
+			stats.Formatted: 6,
+			stats.Changed:   6,
+		}),
+		withOutput(func(out []byte) {
+			as.Contains(string(out), "--- a/haskell/Foo.hs\n+++ b/haskell/Foo.hs\n")
+			as.Contains(string(out), "\n+   \n")
+		}),
+	)
+
+	// with fail-on-change, the diff describes the change which was made
+	treefmt(t,
+		withArgs("--fail-on-change", "--diff", "--no-cache"),
+		withConfig(configPath, cfg),
+		withError(func(err error) {
+			as.ErrorIs(err, runner.ErrFailOnChange)
+		}),
+		withStats(t, map[stats.Type]int{
+			stats.Traversed: 32,
+			stats.Matched:   6,
+			stats.Formatted: 6,
+			stats.Changed:   6,
+		}),
+		withOutput(func(out []byte) {
+			as.Contains(string(out), "--- a/haskell/Foo.hs\n+++ b/haskell/Foo.hs\n")
+			as.Contains(string(out), "\n+   \n")
+		}),
+	)
+}
+
+func TestColor(t *testing.T) {
+	as := require.New(t)
+
+	tempDir := test.TempExamples(t)
+	configPath := filepath.Join(tempDir, "treefmt.toml")
+
+	test.ChangeWorkDir(t, tempDir)
+
+	cfg := &config.Config{
+		FormatterConfigs: map[string]*config.Formatter{
+			"append": {
+				Command:  "test-fmt-append",
+				Options:  []string{"   "},
+				Includes: []string{"*.hs"},
+			},
+		},
+	}
+
+	// output is not a terminal, so by default there is no color
+	treefmt(t,
+		withArgs("--check", "--diff"),
+		withConfig(configPath, cfg),
+		withError(func(err error) {
+			as.ErrorIs(err, runner.ErrFailOnChange)
+		}),
+		withOutput(func(out []byte) {
+			as.NotContains(string(out), "\x1b[")
+			as.Contains(string(out), "\n+   \n")
+		}),
+	)
+
+	// color can be forced with CLICOLOR_FORCE
+	t.Setenv("CLICOLOR_FORCE", "1")
+
+	treefmt(t,
+		withArgs("--check", "--diff"),
+		withConfig(configPath, cfg),
+		withError(func(err error) {
+			as.ErrorIs(err, runner.ErrFailOnChange)
+		}),
+		withOutput(func(out []byte) {
+			as.Contains(string(out), "\x1b[32m+   \x1b[0m\n")
+		}),
+	)
+
+	// NO_COLOR takes precedence
+	t.Setenv("NO_COLOR", "1")
+
+	treefmt(t,
+		withArgs("--check", "--diff"),
+		withConfig(configPath, cfg),
+		withError(func(err error) {
+			as.ErrorIs(err, runner.ErrFailOnChange)
+		}),
+		withOutput(func(out []byte) {
+			as.NotContains(string(out), "\x1b[")
+		}),
+	)
+
+	// unless color is requested explicitly
+	treefmt(t,
+		withArgs("--check", "--diff", "--color", "always"),
+		withConfig(configPath, cfg),
+		withError(func(err error) {
+			as.ErrorIs(err, runner.ErrFailOnChange)
+		}),
+		withOutput(func(out []byte) {
+			as.Contains(string(out), "\x1b[32m+   \x1b[0m\n")
+			as.Contains(string(out), "\x1b[1m--- a/haskell/Foo.hs\x1b[0m\n")
+		}),
+	)
+
+	// or disabled
+	t.Setenv("NO_COLOR", "")
+
+	treefmt(t,
+		withArgs("--check", "--diff", "--color", "never"),
+		withConfig(configPath, cfg),
+		withError(func(err error) {
+			as.ErrorIs(err, runner.ErrFailOnChange)
+		}),
+		withOutput(func(out []byte) {
+			as.NotContains(string(out), "\x1b[")
+		}),
+	)
+
+	// anything else is rejected
+	treefmt(t,
+		withArgs("--color", "sometimes"),
+		withConfig(configPath, cfg),
+		withError(func(err error) {
+			as.ErrorContains(err, "invalid color: sometimes, must be one of <auto|always|never>")
+		}),
+	)
+}
+
+func TestPatchFile(t *testing.T) {
+	as := require.New(t)
+
+	tempDir := test.TempExamples(t)
+	configPath := filepath.Join(tempDir, "treefmt.toml")
+	patchPath := filepath.Join(t.TempDir(), "treefmt.patch")
+
+	test.ChangeWorkDir(t, tempDir)
+
+	cfg := &config.Config{
+		FormatterConfigs: map[string]*config.Formatter{
+			"append": {
+				Command:  "test-fmt-append",
+				Options:  []string{"   "},
+				Includes: []string{"*.hs"},
+			},
+		},
+	}
+
+	original, err := os.ReadFile(filepath.Join(tempDir, "haskell/Foo.hs"))
+	as.NoError(err)
+
+	// check mode accumulates the changes which would be made into the patch
+	treefmt(t,
+		withArgs("--check", "--patch-file", patchPath),
+		withConfig(configPath, cfg),
+		withError(func(err error) {
+			as.ErrorIs(err, runner.ErrFailOnChange)
+		}),
+		withStats(t, map[stats.Type]int{
+			stats.Traversed: 32,
+			stats.Matched:   6,
+			stats.Formatted: 6,
+			stats.Changed:   6,
+		}),
+		withOutput(func(out []byte) {
+			// the diffs are written to the patch rather than printed
+			as.NotContains(string(out), "--- a/haskell/Foo.hs")
+		}),
+	)
+
+	patch, err := os.ReadFile(patchPath)
+	as.NoError(err)
+	as.Equal(6, strings.Count(string(patch), "\n+++ b/"))
+
+	// applying the patch formats the tree
+	gitCmd := exec.Command("git", "apply", patchPath)
+	gitCmd.Dir = tempDir
+	out, err := gitCmd.CombinedOutput()
+	as.NoError(err, string(out))
+
+	content, err := os.ReadFile(filepath.Join(tempDir, "haskell/Foo.hs"))
+	as.NoError(err)
+	as.Equal(string(original)+"   \n", string(content))
+
+	// with nothing to change, the patch is empty
+	cfg.FormatterConfigs["append"].Command = "echo"
+
+	treefmt(t,
+		withArgs("--check", "--no-cache", "--patch-file", patchPath),
+		withConfig(configPath, cfg),
+		withNoError(t),
+		withStats(t, map[stats.Type]int{
+			stats.Traversed: 32,
+			stats.Matched:   6,
+			stats.Formatted: 6,
+			stats.Changed:   0,
+		}),
+	)
+
+	patch, err = os.ReadFile(patchPath)
+	as.NoError(err)
+	as.Empty(patch)
+}
+
+func TestStatsFile(t *testing.T) {
+	as := require.New(t)
+
+	tempDir := test.TempExamples(t)
+	configPath := filepath.Join(tempDir, "treefmt.toml")
+	statsPath := filepath.Join(t.TempDir(), "stats.json")
+
+	test.ChangeWorkDir(t, tempDir)
+
+	cfg := &config.Config{
+		FormatterConfigs: map[string]*config.Formatter{
+			"append": {
+				Command:  "test-fmt-append",
+				Options:  []string{"   "},
+				Includes: []string{"*.hs"},
+			},
+		},
+	}
+
+	// the stats are written regardless of what is printed
+	treefmt(t,
+		withArgs("--quiet", "--stats-file", statsPath),
+		withConfig(configPath, cfg),
+		withNoError(t),
+		withOutput(func(out []byte) {
+			as.Empty(string(out))
+		}),
+	)
+
+	data, err := os.ReadFile(statsPath)
+	as.NoError(err)
+
+	var summary stats.Summary
+	as.NoError(json.Unmarshal(data, &summary))
+
+	as.Equal(32, summary.Counters[stats.Traversed])
+	as.Equal(6, summary.Counters[stats.Matched])
+	as.Equal(6, summary.Counters[stats.Formatted])
+	as.Equal(6, summary.Counters[stats.Changed])
+
+	as.Equal(6, summary.Formatters["append"].Files)
+	as.Equal(1, summary.Formatters["append"].Batches)
+	as.Positive(summary.Elapsed)
+
+	as.Len(summary.Changes, 6)
+	as.Equal("haskell-frontend/Main.hs", summary.Changes[0].Path)
+	as.Equal([]string{"append"}, summary.Changes[0].Formatters)
+}
+
+func TestLogFile(t *testing.T) {
+	as := require.New(t)
+
+	tempDir := test.TempExamples(t)
+	configPath := filepath.Join(tempDir, "treefmt.toml")
+	logPath := filepath.Join(t.TempDir(), "treefmt.log")
+
+	test.ChangeWorkDir(t, tempDir)
+
+	cfg := &config.Config{
+		FormatterConfigs: map[string]*config.Formatter{
+			"append": {
+				Command:  "test-fmt-append",
+				Options:  []string{"   "},
+				Includes: []string{"*.hs"},
+			},
+		},
+	}
+
+	// the console only receives warnings, whilst the log file records everything
+	treefmt(t,
+		withArgs("--on-unmatched", "info", "--log-file", logPath),
+		withConfig(configPath, cfg),
+		withNoError(t),
+		withOutput(func(out []byte) {
+			as.NotContains(string(out), "DEBU")
+			as.NotContains(string(out), "INFO no formatter")
+		}),
+	)
+
+	content, err := os.ReadFile(logPath)
+	as.NoError(err)
+	as.Contains(string(content), " DEBU walk | filesystem: file queued haskell/Foo.hs\n")
+	as.Contains(string(content), " INFO no formatter for path: go/main.go\n")
+	as.Contains(string(content), " INFO formatter | append: 6 file(s) processed in ")
+
+	// a limit on the size of the log file rotates it
+	treefmt(t,
+		withArgs("--log-file", logPath, "--log-file-max-size", "1KB"),
+		withConfig(configPath, cfg),
+		withNoError(t),
+	)
+
+	info, err := os.Stat(logPath)
+	as.NoError(err)
+	as.LessOrEqual(info.Size(), int64(1000))
+	as.FileExists(logPath + ".1")
+
+	// the limit must be a valid size
+	treefmt(t,
+		withArgs("--log-file", logPath, "--log-file-max-size", "lots"),
+		withConfig(configPath, cfg),
+		withError(func(err error) {
+			as.ErrorContains(err, "invalid log-file-max-size")
+		}),
+	)
+}
+
+func TestConflicts(t *testing.T) {
+	as := require.New(t)
+
+	tempDir := test.TempExamples(t)
+	configPath := filepath.Join(tempDir, "treefmt.toml")
+
+	test.ChangeWorkDir(t, tempDir)
+
+	cfg := &config.Config{
+		FormatterConfigs: map[string]*config.Formatter{
+			"append": {
+				Command:  "test-fmt-append",
+				Options:  []string{"foo"},
+				Includes: []string{"*.hs"},
+			},
+			"append-again": {
+				Command:  "test-fmt-append",
+				Options:  []string{"bar"},
+				Includes: []string{"*.hs"},
+				Priority: 1,
+			},
+		},
+	}
+
+	// formatters which both modify the same files are fine, provided they don't undo each other's changes
+	treefmt(t,
+		withConfig(configPath, cfg),
+		withNoError(t),
+		withStats(t, map[stats.Type]int{
+			stats.Traversed: 32,
+			stats.Matched:   6,
+			stats.Formatted: 6,
+			stats.Changed:   6,
+		}),
+		withOutput(func(out []byte) {
+			as.NotContains(string(out), "undoing each other's changes")
+		}),
+	)
+
+	// remove the line which was just appended
+	cfg.FormatterConfigs["append-again"] = &config.Formatter{
+		Command:  "sh",
+		Options:  []string{"-c", `for f in "$@"; do sed -i '$d' "$f"; done`, "sh"},
+		Includes: []string{"*.hs"},
+		Priority: 1,
+	}
+
+	// files are only hashed between formatters when requested
+	treefmt(t,
+		withArgs("--no-cache"),
+		withConfig(configPath, cfg),
+		withNoError(t),
+		withOutput(func(out []byte) {
+			as.NotContains(string(out), "undoing each other's changes")
+		}),
+	)
+
+	treefmt(t,
+		withArgs("--no-cache", "--detect-conflicts"),
+		withConfig(configPath, cfg),
+		withNoError(t),
+		withOutput(func(out []byte) {
+			as.Contains(string(out), "formatters append, append-again are undoing each other's changes to haskell/Foo.hs")
+		}),
+	)
+}
+
+func TestStages(t *testing.T) {
+	as := require.New(t)
+
+	tempDir := test.TempExamples(t)
+	configPath := filepath.Join(tempDir, "treefmt.toml")
+
+	test.ChangeWorkDir(t, tempDir)
+
+	// each formatter waits for the other to start, which only succeeds if they are applied concurrently, whilst
+	// failing if the other is applied to the same file at the same time
+	lockDir := t.TempDir()
+
+	waitFor := func(self string, other string) *config.Formatter {
+		return &config.Formatter{
+			Command: "sh",
+			Options: []string{
+				"-c",
+				`lock="$LOCK_DIR/$(echo "$2" | tr / _)"; mkdir "$lock" || exit 1; touch "$0.started"; ` +
+					`for i in $(seq 50); do [ -e "$1.started" ] && rmdir "$lock" && exit 0; sleep 0.1; done; exit 1`,
+				self, other,
+			},
+			Includes:  []string{"*.hs"},
+			Stage:     "lint",
+			BatchSize: 1,
+		}
+	}
+
+	cfg := &config.Config{
+		FormatterConfigs: map[string]*config.Formatter{
+			"lint-a": waitFor("a", "b"),
+			"lint-b": waitFor("b", "a"),
+			"append": {
+				Command:  "test-fmt-append",
+				Options:  []string{"   "},
+				Includes: []string{"*.hs"},
+				Priority: 1,
+			},
+		},
+	}
+
+	// formatter processes are still limited by --jobs, and the formatters of a stage are applied to different files
+	// at the same time, rather than the same one
+	treefmt(t,
+		withArgs("--jobs", "2"),
+		withEnv(map[string]string{"LOCK_DIR": lockDir}),
+		withConfig(configPath, cfg),
+		withNoError(t),
+		withStats(t, map[stats.Type]int{
+			stats.Traversed: 32,
+			stats.Matched:   6,
+			stats.Formatted: 6,
+			stats.Changed:   6,
+		}),
+	)
+
+	// formatters in the same stage must share a priority
+	cfg.FormatterConfigs["lint-b"].Priority = 2
+
+	treefmt(t,
+		withArgs("--no-cache"),
+		withConfig(configPath, cfg),
+		withError(func(err error) {
+			as.ErrorContains(err, "formatters lint-a and lint-b are in stage lint but have different priorities, 0 and 2")
+		}),
+	)
+
+	// or be applied after one another
+	cfg.FormatterConfigs["lint-b"].Priority = 0
+	cfg.FormatterConfigs["lint-b"].After = []string{"lint-a"}
+
+	treefmt(t,
+		withArgs("--no-cache"),
+		withConfig(configPath, cfg),
+		withError(func(err error) {
+			as.ErrorContains(err, "formatters lint-a and lint-b are in stage lint but one is after the other")
+		}),
+	)
+}
+
+func TestAfter(t *testing.T) {
+	as := require.New(t)
+
+	tempDir := test.TempExamples(t)
+	configPath := filepath.Join(tempDir, "treefmt.toml")
+
+	test.ChangeWorkDir(t, tempDir)
+
+	cfg := &config.Config{
+		FormatterConfigs: map[string]*config.Formatter{
+			"codegen": {
+				Command:  "test-fmt-append",
+				Options:  []string{"codegen"},
+				Includes: []string{"*.elm"},
+				Priority: 2,
+			},
+			"imports": {
+				Command:  "test-fmt-append",
+				Options:  []string{"imports"},
+				Includes: []string{"*.elm"},
+				After:    []string{"codegen"},
+			},
+			"format": {
+				Command:  "test-fmt-append",
+				Options:  []string{"format"},
+				Includes: []string{"*.elm"},
+				After:    []string{"imports"},
+			},
+		},
+	}
+
+	// without after, the formatters would be applied in the order format, imports, codegen
+	treefmt(t,
+		withConfig(configPath, cfg),
+		withNoError(t),
+		withStats(t, map[stats.Type]int{
+			stats.Traversed: 32,
+			stats.Matched:   1,
+			stats.Formatted: 1,
+			stats.Changed:   1,
+		}),
+	)
+
+	content, err := os.ReadFile(filepath.Join(tempDir, "elm/src/Main.elm"))
+	as.NoError(err)
+	as.True(strings.HasSuffix(string(content), "codegen\nimports\nformat\n"))
+
+	// cycles are rejected
+	cfg.FormatterConfigs["codegen"].After = []string{"format"}
+
+	treefmt(t,
+		withConfig(configPath, cfg),
+		withError(func(err error) {
+			as.ErrorContains(err, "formatters have a cycle in their after dependencies: codegen -> format -> imports -> codegen")
+		}),
+	)
+}
+
+func TestCacheCommand(t *testing.T) {
+	as := require.New(t)
+
+	tempDir := test.TempExamples(t)
+	configPath := filepath.Join(tempDir, "treefmt.toml")
+
+	test.ChangeWorkDir(t, tempDir)
+
+	cfg := &config.Config{
+		FormatterConfigs: map[string]*config.Formatter{
+			"echo": {
+				Command:  "echo",
+				Includes: []string{"*.hs"},
+			},
+			"elm": {
+				Command:  "echo",
+				Includes: []string{"*.elm"},
+			},
+		},
+	}
+
+	treefmt(t,
+		withConfig(configPath, cfg),
+		withNoError(t),
+		withStats(t, map[stats.Type]int{
+			stats.Traversed: 32,
+			stats.Matched:   7,
+			stats.Formatted: 7,
+			stats.Changed:   0,
+		}),
+	)
+
+	treefmt(t,
+		withArgs("cache", "info"),
+		withNoError(t),
+		withOutput(func(out []byte) {
+			as.Contains(string(out), "location: ")
+			as.Contains(string(out), "entries:  7\n")
+		}),
+	)
+
+	// remove a file and a formatter, leaving their entries stale
+	as.NoError(os.Remove(filepath.Join(tempDir, "haskell/Foo.hs")))
+	delete(cfg.FormatterConfigs, "elm")
+
+	treefmt(t,
+		withArgs("cache", "prune"),
+		withConfig(configPath, cfg),
+		withNoError(t),
+		withOutput(func(out []byte) {
+			as.Contains(string(out), "pruned 2 entries from the cache")
+		}),
+	)
+
+	treefmt(t,
+		withArgs("cache", "info"),
+		withNoError(t),
+		withOutput(func(out []byte) {
+			as.Contains(string(out), "entries:  5\n")
+		}),
+	)
+
+	// the remaining entries are still used
+	treefmt(t,
+		withConfig(configPath, cfg),
+		withNoError(t),
+		withStats(t, map[stats.Type]int{
+			stats.Traversed: 31,
+			stats.Matched:   5,
+			stats.Formatted: 0,
+			stats.Changed:   0,
+		}),
+	)
+
+	treefmt(t,
+		withArgs("cache", "clear"),
+		withNoError(t),
+		withOutput(func(out []byte) {
+			as.Contains(string(out), "cleared the cache")
+		}),
+	)
+
+	treefmt(t,
+		withArgs("cache", "info"),
+		withNoError(t),
+		withOutput(func(out []byte) {
+			as.Contains(string(out), "entries:  0\n")
+		}),
+	)
+}
+
+func TestCacheDir(t *testing.T) {
+	as := require.New(t)
+
+	tempDir := test.TempExamples(t)
+	otherDir := test.TempExamples(t)
+	cacheDir := filepath.Join(t.TempDir(), "cache")
+
+	cfg := &config.Config{
+		FormatterConfigs: map[string]*config.Formatter{
+			"echo": {
+				Command:  "echo",
+				Includes: []string{"*.hs"},
+			},
+		},
+	}
+	cfg.Cache.Dir = cacheDir
+
+	for _, dir := range []string{tempDir, otherDir} {
+		test.ChangeWorkDir(t, dir)
+
+		treefmt(t,
+			withConfig(filepath.Join(dir, "treefmt.toml"), cfg),
+			withNoError(t),
+			withStats(t, map[stats.Type]int{
+				stats.Traversed: 32,
+				stats.Matched:   6,
+				stats.Formatted: 6,
+				stats.Changed:   0,
+			}),
+		)
+
+		treefmt(t,
+			withArgs("cache", "info"),
+			withNoError(t),
+			withOutput(func(out []byte) {
+				as.Contains(string(out), "location: "+cacheDir+string(filepath.Separator))
+				as.Contains(string(out), "entries:  6\n")
+			}),
+		)
+	}
+
+	// each tree root has a cache of its own within the directory
+	entries, err := filepath.Glob(filepath.Join(cacheDir, "*.db"))
+	as.NoError(err)
+	as.Len(entries, 2)
+
+	// the flag takes precedence over the config file
+	flagDir := filepath.Join(t.TempDir(), "flag")
+
+	treefmt(t,
+		withArgs("--cache-dir", flagDir),
+		withNoError(t),
+		withStats(t, map[stats.Type]int{
+			stats.Traversed: 32,
+			stats.Matched:   6,
+			stats.Formatted: 6,
+			stats.Changed:   0,
+		}),
+	)
+
+	entries, err = filepath.Glob(filepath.Join(flagDir, "*.db"))
+	as.NoError(err)
+	as.Len(entries, 1)
+}
+
+// installFormatter puts an executable named name on the PATH which does nothing, with the given mod time, as each
+// machine has its own copy of a formatter whose size and mod time differ.
+func installFormatter(t *testing.T, name string, modTime time.Time) {
+	t.Helper()
+
+	binPath := t.TempDir()
+	fmtPath := filepath.Join(binPath, name)
+
+	require.NoError(t, os.WriteFile(fmtPath, []byte("#!/bin/sh\n# installed "+modTime.String()+"\n"), 0o755))
+	require.NoError(t, os.Chtimes(fmtPath, modTime, modTime))
+
+	t.Setenv("PATH", binPath+":"+os.Getenv("PATH"))
+}
+
+func TestCacheExportImport(t *testing.T) {
+	as := require.New(t)
+
+	cfg := &config.Config{
+		FormatterConfigs: map[string]*config.Formatter{
+			"snapshot": {
+				Command:  "snapshot-fmt",
+				Includes: []string{"*.hs", "*.elm"},
+				Version:  "1.0",
+			},
+		},
+	}
+
+	snapshotPath := filepath.Join(t.TempDir(), "cache.json")
+
+	tempDir := test.TempExamples(t)
+	otherDir := test.TempExamples(t)
+	upgradedDir := test.TempExamples(t)
+
+	// format a tree and export its cache
+	test.ChangeWorkDir(t, tempDir)
+	installFormatter(t, "snapshot-fmt", time.Now().Add(-time.Hour))
+
+	treefmt(t,
+		withConfig(filepath.Join(tempDir, "treefmt.toml"), cfg),
+		withNoError(t),
+		withStats(t, map[stats.Type]int{
+			stats.Traversed: 32,
+			stats.Matched:   7,
+			stats.Formatted: 7,
+			stats.Changed:   0,
+		}),
+	)
+
+	treefmt(t,
+		withArgs("cache", "export", snapshotPath),
+		withNoError(t),
+		withOutput(func(out []byte) {
+			as.Contains(string(out), "exported 7 entries")
+		}),
+	)
+
+	// import the cache into a fresh copy of the tree on another machine, in which one of the files has changed
+	test.ChangeWorkDir(t, otherDir)
+	installFormatter(t, "snapshot-fmt", time.Now())
+
+	test.WriteConfig(t, filepath.Join(otherDir, "treefmt.toml"), cfg)
+	as.NoError(os.WriteFile(filepath.Join(otherDir, "haskell/Foo.hs"), []byte("changed"), 0o600))
+
+	treefmt(t,
+		withArgs("cache", "import", snapshotPath),
+		withNoError(t),
+		withOutput(func(out []byte) {
+			as.Contains(string(out), "imported 6 of 7 entries")
+		}),
+	)
+
+	// only the changed file needs formatting
+	treefmt(t,
+		withNoError(t),
+		withStats(t, map[stats.Type]int{
+			stats.Traversed: 32,
+			stats.Matched:   7,
+			stats.Formatted: 1,
+			stats.Changed:   0,
+		}),
+	)
+
+	// nothing is imported once the formatter has been upgraded
+	cfg.FormatterConfigs["snapshot"].Version = "2.0"
+
+	test.ChangeWorkDir(t, upgradedDir)
+	test.WriteConfig(t, filepath.Join(upgradedDir, "treefmt.toml"), cfg)
+
+	treefmt(t,
+		withArgs("cache", "import", snapshotPath),
+		withNoError(t),
+		withOutput(func(out []byte) {
+			as.Contains(string(out), "imported 0 of 7 entries")
+		}),
+	)
+}
+
+func TestCacheRemote(t *testing.T) {
+	as := require.New(t)
+
+	// a minimal remote cache, storing entries in memory
+	var (
+		lock    sync.Mutex
+		entries = make(map[string]bool)
+	)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+
+		switch r.Method {
+		case http.MethodHead:
+			if !entries[r.URL.Path] {
+				w.WriteHeader(http.StatusNotFound)
+			}
+		case http.MethodPut:
+			entries[r.URL.Path] = true
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	}))
+	defer server.Close()
+
+	cfg := &config.Config{
+		FormatterConfigs: map[string]*config.Formatter{
+			"remote": {
+				Command:  "remote-fmt",
+				Includes: []string{"*.hs", "*.elm"},
+				Version:  "1.0",
+			},
+		},
+	}
+	cfg.Cache.Remote = server.URL + "/treefmt"
+
+	tempDir := test.TempExamples(t)
+	otherDir := test.TempExamples(t)
+	upgradedDir := test.TempExamples(t)
+
+	// formatting a tree populates the remote cache
+	test.ChangeWorkDir(t, tempDir)
+	installFormatter(t, "remote-fmt", time.Now().Add(-time.Hour))
+
+	treefmt(t,
+		withConfig(filepath.Join(tempDir, "treefmt.toml"), cfg),
+		withNoError(t),
+		withStats(t, map[stats.Type]int{
+			stats.Traversed: 32,
+			stats.Matched:   7,
+			stats.Formatted: 7,
+			stats.Changed:   0,
+		}),
+	)
+
+	// entries are keyed by content, and two of the files are identical
+	as.Len(entries, 6)
+
+	// a different checkout of the same tree has nothing to format, besides a file which has changed
+	test.ChangeWorkDir(t, otherDir)
+	as.NoError(os.WriteFile(filepath.Join(otherDir, "haskell/Foo.hs"), []byte("changed"), 0o600))
+	installFormatter(t, "remote-fmt", time.Now())
+
+	treefmt(t,
+		withConfig(filepath.Join(otherDir, "treefmt.toml"), cfg),
+		withNoError(t),
+		withStats(t, map[stats.Type]int{
+			stats.Traversed: 32,
+			stats.Matched:   7,
+			stats.Formatted: 1,
+			stats.Changed:   0,
+		}),
+	)
+
+	as.Len(entries, 7)
+
+	// upgrading the formatter means files must be formatted again
+	cfg.FormatterConfigs["remote"].Version = "2.0"
+	test.ChangeWorkDir(t, upgradedDir)
+
+	treefmt(t,
+		withConfig(filepath.Join(upgradedDir, "treefmt.toml"), cfg),
+		withNoError(t),
+		withStats(t, map[stats.Type]int{
+			stats.Traversed: 32,
+			stats.Matched:   7,
+			stats.Formatted: 7,
+			stats.Changed:   0,
+		}),
+	)
+
+	as.Len(entries, 13)
+
+	// files found in the remote cache were added to the local cache
+	test.ChangeWorkDir(t, otherDir)
+	server.Close()
+
+	treefmt(t,
+		withNoError(t),
+		withStats(t, map[stats.Type]int{
+			stats.Traversed: 32,
+			stats.Matched:   7,
+			stats.Formatted: 0,
+			stats.Changed:   0,
+		}),
+	)
+
+	// formatting continues without the remote cache if it is unavailable
+	treefmt(t,
+		withArgs("--no-cache"),
+		withNoError(t),
+		withStats(t, map[stats.Type]int{
+			stats.Traversed: 32,
+			stats.Matched:   7,
+			stats.Formatted: 7,
+			stats.Changed:   0,
+		}),
+		withOutput(func(out []byte) {
+			as.Contains(string(out), "remote cache is unavailable, continuing without it")
+		}),
+	)
+}
+
+func TestLockWait(t *testing.T) {
+	as := require.New(t)
+
+	tempDir := test.TempExamples(t)
+	configPath := filepath.Join(tempDir, "treefmt.toml")
+
+	test.ChangeWorkDir(t, tempDir)
+
+	cfg := &config.Config{
+		FormatterConfigs: map[string]*config.Formatter{
+			"echo": {
+				Command:  "echo",
+				Includes: []string{"*.hs"},
+			},
+		},
+	}
+
+	// simulate another treefmt process running against the tree
+	unlock, err := cache.Lock(tempDir, 0)
+	as.NoError(err)
+
+	// by default, we fail straight away
+	treefmt(t,
+		withConfig(configPath, cfg),
+		withError(func(err error) {
+			as.ErrorIs(err, cache.ErrLocked)
+			as.ErrorContains(err, "use --lock-wait")
+		}),
+	)
+
+	// we can wait for a while, giving up if the lock isn't released
+	treefmt(t,
+		withArgs("--lock-wait", "200ms"),
+		withError(func(err error) {
+			as.ErrorIs(err, cache.ErrLocked)
+			as.ErrorContains(err, "gave up waiting after 200ms")
+		}),
+	)
+
+	// the lock is tied to the tree root, regardless of where the cache is kept or whether it is used at all
+	treefmt(t,
+		withArgs("--cache-dir", t.TempDir()),
+		withError(func(err error) {
+			as.ErrorIs(err, cache.ErrLocked)
+		}),
+	)
+
+	treefmt(t,
+		withArgs("--no-cache"),
+		withError(func(err error) {
+			as.ErrorIs(err, cache.ErrLocked)
+		}),
+	)
+
+	// or until the lock is released
+	released := make(chan error, 1)
+
+	go func() {
+		time.Sleep(200 * time.Millisecond)
+		released <- unlock()
+	}()
+
+	treefmt(t,
+		withArgs("--lock-wait", "10s"),
+		withNoError(t),
+		withStats(t, map[stats.Type]int{
+			stats.Traversed: 32,
+			stats.Matched:   6,
+			stats.Formatted: 6,
+			stats.Changed:   0,
+		}),
+	)
+
+	as.NoError(<-released)
+
+	// invalid values are rejected
+	treefmt(t,
+		withArgs("--lock-wait", "soon"),
+		withError(func(err error) {
+			as.ErrorContains(err, "invalid lock-wait value")
+		}),
+	)
+}
+
+func TestInitCommand(t *testing.T) {
+	as := require.New(t)
+
+	tempDir := test.TempExamples(t)
+	configPath := filepath.Join(tempDir, "treefmt.toml")
+
+	test.ChangeWorkDir(t, tempDir)
+
+	// only gofmt and rustfmt are available
+	binDir := t.TempDir()
+	for _, name := range []string{"gofmt", "rustfmt"} {
+		as.NoError(os.WriteFile(filepath.Join(binDir, name), []byte("#!/bin/sh\n"), 0o755))
+	}
+
+	t.Setenv("PATH", binDir)
+
+	// an existing config is not overwritten
+	treefmt(t,
+		withArgs("init"),
+		withError(func(err error) {
+			as.ErrorContains(err, "treefmt.toml already exists, use --force to overwrite it")
+		}),
+	)
+
+	treefmt(t,
+		withArgs("init", "--force"),
+		withNoError(t),
+	)
+
+	contents, err := os.ReadFile(configPath)
+	as.NoError(err)
+
+	// formatters found on the PATH are enabled, with the preferred formatter falling back to the next available one
+	as.Contains(string(contents), "[formatter.gofmt]\ncommand = \"gofmt\"\noptions = [\"-w\"]\nincludes = [\"*.go\"]\n")
+	as.Contains(string(contents), "[formatter.rustfmt]\n")
+	as.NotContains(string(contents), "gofumpt")
+
+	// the rest are commented out
+	as.Contains(string(contents), "# nix: nixfmt was not found on the PATH")
+	as.Contains(string(contents), "# [formatter.nixfmt]\n# command = \"nixfmt\"\n# includes = [\"*.nix\"]\n")
+	as.Contains(string(contents), "# [formatter.elm-format]\n")
+
+	// languages which are not present in the tree are omitted
+	as.NotContains(string(contents), "zig")
+
+	// the generated config can be loaded
+	var cfg config.Config
+
+	_, err = toml.Decode(string(contents), &cfg)
+	as.NoError(err)
+	as.Len(cfg.FormatterConfigs, 2)
+	as.Equal([]string{"*.rs"}, cfg.FormatterConfigs["rustfmt"].Includes)
+}
+
+func TestDoctor(t *testing.T) {
+	as := require.New(t)
+
+	tempDir := test.TempExamples(t)
+	configPath := filepath.Join(tempDir, "treefmt.toml")
+
+	test.ChangeWorkDir(t, tempDir)
+
+	cfg := &config.Config{
+		FormatterConfigs: map[string]*config.Formatter{
+			"echo": {
+				Command:  "echo",
+				Includes: []string{"*.hs"},
+			},
+			"haskell": {
+				Command:  "echo",
+				Includes: []string{"haskell/*.hs"},
+			},
+			"elm": {
+				Command:  "echo",
+				Includes: []string{"*.elm"},
+				Priority: 1,
+			},
+			"missing": {
+				Command:  "does-not-exist",
+				Includes: []string{"*.go"},
+			},
+			"unused": {
+				Command:  "echo",
+				Includes: []string{"*.unused"},
+			},
+		},
+	}
+
+	treefmt(t,
+		withArgs("doctor"),
+		withConfig(configPath, cfg),
+		withError(func(err error) {
+			as.ErrorIs(err, doctor.ErrProblemsFound)
+		}),
+		withOutput(func(out []byte) {
+			as.Contains(string(out), "[ok]    config: loaded "+configPath+"\n")
+			as.Contains(string(out), "[ok]    formatter echo: ")
+			as.Contains(string(out), "[error] formatter missing: command 'does-not-exist' not found\n")
+			as.Contains(string(out), "[ok]    tree: traversed 32 files in "+tempDir+"\n")
+			as.Contains(string(out), "[warn]  formatter unused: does not match any files")
+			as.Contains(string(out), "[warn]  formatters echo, haskell overlap on 4 file(s) and share a priority")
+			as.Contains(string(out), "[ok]    cache: 0 entries in ")
+			as.Contains(string(out), "\n1 problem(s), 2 warning(s)\n")
+		}),
+	)
+
+	// a missing formatter is only a warning when they are allowed
+	cfg.AllowMissingFormatter = true
+
+	treefmt(t,
+		withArgs("doctor"),
+		withConfig(configPath, cfg),
+		withNoError(t),
+		withOutput(func(out []byte) {
+			as.Contains(string(out), "[warn]  formatter missing: command 'does-not-exist' not found, it will be skipped\n")
+			as.Contains(string(out), "\n0 problem(s), 3 warning(s)\n")
+		}),
+	)
+
+	// invalid config is reported
+	cfg.FormatterConfigs["unused"].Timeout = "soon"
+
+	treefmt(t,
+		withArgs("doctor"),
+		withConfig(configPath, cfg),
+		withError(func(err error) {
+			as.ErrorIs(err, doctor.ErrProblemsFound)
+		}),
+		withOutput(func(out []byte) {
+			as.Contains(string(out), "[error] config: failed to initialise formatter unused: formatter 'unused' has an "+
+				"invalid timeout")
+		}),
+	)
+}
+
+func TestList(t *testing.T) {
+	as := require.New(t)
+
+	tempDir := test.TempExamples(t)
+	configPath := filepath.Join(tempDir, "treefmt.toml")
+
+	test.ChangeWorkDir(t, tempDir)
+
+	// a formatter which reports its version
+	binDir := t.TempDir()
+	versioned := filepath.Join(binDir, "versioned-fmt")
+	as.NoError(os.WriteFile(versioned, []byte("#!/bin/sh\n\necho\necho 'versioned-fmt 1.2.3'\n"), 0o755))
+
+	t.Setenv("PATH", binDir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	cfg := &config.Config{
+		FormatterConfigs: map[string]*config.Formatter{
+			"versioned": {
+				Command:      "versioned-fmt",
+				Includes:     []string{"*.hs"},
+				Excludes:     []string{"haskell/*"},
+				Priority:     2,
+				VersionProbe: "--version",
+			},
+			"elm": {
+				Command:  "test-fmt-append",
+				Includes: []string{"*.elm"},
+			},
+			"missing": {
+				Command:  "does-not-exist",
+				Includes: []string{"*.go"},
+			},
+		},
+	}
+
+	treefmt(t,
+		withArgs("list", "--formatters", "versioned"),
+		withConfig(configPath, cfg),
+		withNoError(t),
+		withOutput(func(out []byte) {
+			as.Contains(string(out), "elm\n  command:  test-fmt-append (")
+			as.Contains(string(out), "missing\n  command:  does-not-exist (not found)\n  version:  unknown\n"+
+				"  includes: *.go\n  priority: 0\n  selected: false\n")
+			as.Contains(string(out), "versioned\n  command:  versioned-fmt ("+versioned+")\n"+
+				"  version:  versioned-fmt 1.2.3\n  includes: *.hs\n  excludes: haskell/*\n  priority: 2\n"+
+				"  selected: true\n")
+		}),
+	)
+
+	treefmt(t,
+		withArgs("list", "--output", "json"),
+		withConfig(configPath, cfg),
+		withNoError(t),
+		withOutput(func(out []byte) {
+			// skip the log line which precedes the json
+			start := bytes.IndexByte(out, '[')
+			as.GreaterOrEqual(start, 0, "no json in output: %s", out)
+
+			out = out[start:]
+
+			var entries []map[string]any
+			as.NoError(json.Unmarshal(out, &entries))
+			as.Len(entries, 3)
+
+			as.Equal("elm", entries[0]["name"])
+			as.Equal(true, entries[0]["selected"])
+
+			as.Equal("missing", entries[1]["name"])
+			as.NotContains(entries[1], "path")
+			as.NotContains(entries[1], "version")
+
+			as.Equal(map[string]any{
+				"name":     "versioned",
+				"command":  "versioned-fmt",
+				"path":     versioned,
+				"version":  "versioned-fmt 1.2.3",
+				"includes": []any{"*.hs"},
+				"excludes": []any{"haskell/*"},
+				"priority": float64(2),
+				"selected": true,
+				"enabled":  true,
+			}, entries[2])
+		}),
+	)
+
+	// formatters without a version-probe are not probed, as they may treat the args as paths
+	as.NoFileExists(filepath.Join(tempDir, "--version"))
+}
+
+func TestVersion(t *testing.T) {
+	as := require.New(t)
+
+	tempDir := test.TempExamples(t)
+	configPath := filepath.Join(tempDir, "treefmt.toml")
+
+	test.ChangeWorkDir(t, tempDir)
+
+	binDir := t.TempDir()
+	versioned := filepath.Join(binDir, "versioned-fmt")
+	as.NoError(os.WriteFile(versioned, []byte("#!/bin/sh\necho 'versioned-fmt 1.2.3'\n"), 0o755))
+
+	t.Setenv("PATH", binDir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	cfg := &config.Config{
+		FormatterConfigs: map[string]*config.Formatter{
+			"versioned": {
+				Command:      "versioned-fmt",
+				Includes:     []string{"*.hs"},
+				VersionProbe: "--version",
+			},
+			"missing": {
+				Command:  "does-not-exist",
+				Includes: []string{"*.go"},
+			},
+		},
+	}
+
+	// the formatters are not probed by default, so the config file is not required
+	treefmt(t,
+		withArgs("--version", "--config-file", filepath.Join(tempDir, "does-not-exist.toml")),
+		withNoError(t),
+		withOutput(func(out []byte) {
+			as.Contains(string(out), "treefmt "+build.Version+"\n")
+			as.Contains(string(out), "  go:       "+runtime.Version()+"\n")
+			as.NotContains(string(out), "formatters:")
+		}),
+	)
+
+	treefmt(t,
+		withArgs("--version", "--verbose"),
+		withConfig(configPath, cfg),
+		withNoError(t),
+		withOutput(func(out []byte) {
+			as.Contains(string(out), "formatters:\n"+
+				"  missing    does-not-exist (not found)\n"+
+				"  versioned  versioned-fmt 1.2.3 ("+versioned+")\n")
+		}),
+	)
+
+	treefmt(t,
+		withArgs("--version", "--verbose", "--output", "json"),
+		withConfig(configPath, cfg),
+		withNoError(t),
+		withOutput(func(out []byte) {
+			// skip the log line which precedes the json
+			start := bytes.IndexByte(out, '{')
+			as.GreaterOrEqual(start, 0, "no json in output: %s", out)
+
+			out = out[start:]
+
+			var manifest struct {
+				Version    string `json:"version"`
+				GoVersion  string `json:"goVersion"`
+				Formatters []struct {
+					Name    string `json:"name"`
+					Version string `json:"version"`
+				} `json:"formatters"`
+			}
+
+			as.NoError(json.Unmarshal(out, &manifest))
+			as.Equal(build.Version, manifest.Version)
+			as.Equal(runtime.Version(), manifest.GoVersion)
+			as.Len(manifest.Formatters, 2)
+			as.Equal("versioned", manifest.Formatters[1].Name)
+			as.Equal("versioned-fmt 1.2.3", manifest.Formatters[1].Version)
+		}),
+	)
+}
+
+func TestBench(t *testing.T) {
+	as := require.New(t)
+
+	tempDir := test.TempExamples(t)
+	configPath := filepath.Join(tempDir, "treefmt.toml")
+
+	test.ChangeWorkDir(t, tempDir)
+
+	cfg := &config.Config{
+		OnUnmatched: "debug",
+		FormatterConfigs: map[string]*config.Formatter{
+			"echo": {
+				Command:  "echo",
+				Includes: []string{"*.hs"},
+			},
+		},
+	}
+
+	treefmt(t,
+		withArgs("bench", "--runs", "3"),
+		withConfig(configPath, cfg),
+		withNoError(t),
+		withOutput(func(out []byte) {
+			as.Contains(string(out), "made 3 runs with a cold and a warm cache, traversing 32 files each\n")
+			as.Regexp(`(?m)^cold\s+\S+\s+\S+\s+\d+ files/s\s+0\.0%$`, string(out))
+			as.Regexp(`(?m)^warm\s+\S+\s+\S+\s+\d+ files/s\s+100\.0%$`, string(out))
+			as.Regexp(`(?m)^echo\s+`, string(out))
+		}),
+	)
+
+	treefmt(t,
+		withArgs("bench", "--runs", "2", "--output", "json"),
+		withNoError(t),
+		withOutput(func(out []byte) {
+			// skip the log line which precedes the json
+			start := bytes.IndexByte(out, '{')
+			as.GreaterOrEqual(start, 0, "no json in output: %s", out)
+
+			out = out[start:]
+
+			var result bench.Result
+
+			as.NoError(json.Unmarshal(out, &result))
+			as.Equal(2, result.Runs)
+			as.Equal(32, result.Files)
+			as.InDelta(0.0, result.Cold.CacheHitRate, 0)
+			as.InDelta(1.0, result.Warm.CacheHitRate, 0)
+			as.Positive(result.Cold.Throughput)
+			as.Contains(result.Cold.Formatters, "echo")
+			as.LessOrEqual(result.Cold.Elapsed.P50, result.Cold.Elapsed.P95)
+		}),
+	)
+
+	// the evaluation cache is left untouched, so the tree is formatted from scratch afterwards
+	treefmt(t,
+		withNoError(t),
+		withStats(t, map[stats.Type]int{
+			stats.Traversed: 32,
+			stats.Matched:   6,
+			stats.Formatted: 6,
+			stats.Changed:   0,
+		}),
+	)
+
+	treefmt(t,
+		withArgs("bench", "--runs", "0"),
+		withError(func(err error) {
+			as.ErrorIs(err, formatCmd.FailureUsage)
+			as.ErrorContains(err, "invalid runs: 0")
+		}),
+	)
+}
+
+func TestExplain(t *testing.T) {
+	as := require.New(t)
+
+	tempDir := test.TempExamples(t)
+	configPath := filepath.Join(tempDir, "treefmt.toml")
+
+	test.ChangeWorkDir(t, tempDir)
+
+	as.NoError(os.WriteFile(filepath.Join(tempDir, ".gitignore"), []byte("rust/\nignored.hs\n"), 0o600))
+	as.NoError(os.WriteFile(filepath.Join(tempDir, "ignored.hs"), []byte("ignored\n"), 0o600))
+	as.NoError(os.WriteFile(filepath.Join(tempDir, "..hidden.hs"), []byte("hidden\n"), 0o600))
+
+	cfg := &config.Config{
+		Walk:     "gitignore",
+		Excludes: []string{"*.toml"},
+		FormatterConfigs: map[string]*config.Formatter{
+			"echo": {
+				Command:  "echo",
+				Includes: []string{"*.hs", "*.rs"},
+			},
+			"haskell": {
+				Command:  "echo",
+				Includes: []string{"haskell/*"},
+				Excludes: []string{"haskell/Main.hs"},
+				Priority: 1,
+			},
+			"shell": {
+				Command:      "echo",
+				Interpreters: []string{"bash"},
+			},
+			"missing": {
+				Command:  "does-not-exist",
+				Includes: []string{"*"},
+			},
+		},
+	}
+
+	treefmt(t,
+		withArgs(
+			"explain", "haskell/Foo.hs", "haskell/Main.hs", "treefmt.toml", "rust/src/main.rs", "ignored.hs",
+			"..hidden.hs",
+		),
+		withConfig(configPath, cfg),
+		withNoError(t),
+		withOutput(func(out []byte) {
+			as.Contains(string(out), `haskell/Foo.hs
+  walker:     included by the gitignore walker
+  excludes:   not excluded
+  formatters:
+    echo:    included by '*.hs'
+    haskell: included by 'haskell/*'
+    missing: command 'does-not-exist' not found
+    shell:   no includes match
+  sequence:   echo[0] -> haskell[1]
+  cache:      not cached, the file will be formatted
+`)
+			as.Contains(string(out), `haskell/Main.hs
+  walker:     included by the gitignore walker
+  excludes:   not excluded
+  formatters:
+    echo:    included by '*.hs'
+    haskell: excluded by 'haskell/Main.hs'
+`)
+			as.Contains(string(out), `treefmt.toml
+  walker:     included by the gitignore walker
+  excludes:   excluded by '*.toml'
+`)
+			as.Contains(string(out), "  sequence:   none, the file is excluded\n")
+			as.Contains(string(out), "rust/src/main.rs\n  walker:     not included by the gitignore walker")
+			// files in the tree root are subject to the ignore rules too
+			as.Contains(string(out), "ignored.hs\n  walker:     not included by the gitignore walker")
+			// whilst a file whose name merely starts with .. is within the tree root
+			as.Contains(string(out), "..hidden.hs\n  walker:     included by the gitignore walker")
+		}),
+	)
+
+	// once formatted, the file will be skipped
+	cfg.AllowMissingFormatter = true
+
+	treefmt(t,
+		withConfig(configPath, cfg),
+		withNoError(t),
+	)
+
+	treefmt(t,
+		withArgs("explain", "haskell/Foo.hs"),
+		withConfig(configPath, cfg),
+		withNoError(t),
+		withOutput(func(out []byte) {
+			as.Contains(string(out), "  cache:      up to date, the file will be skipped\n")
+		}),
+	)
+
+	// the formatters have changed since the file was formatted
+	cfg.FormatterConfigs["haskell"].Options = []string{"-n"}
+
+	treefmt(t,
+		withArgs("explain", "haskell/Foo.hs"),
+		withConfig(configPath, cfg),
+		withNoError(t),
+		withOutput(func(out []byte) {
+			as.Contains(string(out), "  cache:      stale, the file or its formatters have changed since it was "+
+				"formatted by echo, haskell\n")
+		}),
+	)
+
+	// only files within the tree can be explained
+	treefmt(t,
+		withArgs("explain", "haskell"),
+		withConfig(configPath, cfg),
+		withError(func(err error) {
+			as.ErrorContains(err, "path haskell is a directory, only files can be explained")
+		}),
+	)
+}
+
+func TestConfigCommand(t *testing.T) {
+	as := require.New(t)
+
+	tempDir := t.TempDir()
+	configPath := filepath.Join(tempDir, "treefmt.toml")
+
+	test.ChangeWorkDir(t, tempDir)
+
+	as.NoError(os.WriteFile(configPath, []byte("[formatter.nix]\ncommand = \"nixfmt\"\ninculdes = [\"*.nix\"]\n"), 0o600))
+
+	// the config file is found in the same way as when formatting
+	treefmt(t,
+		withArgs("config", "validate"),
+		withError(func(err error) {
+			as.ErrorIs(err, configCmd.ErrInvalidConfig)
+		}),
+		withOutput(func(out []byte) {
+			as.Contains(string(out), configPath+":3:1: unknown key formatter.nix.inculdes, "+
+				"did you mean formatter.nix.includes?\n")
+		}),
+	)
+
+	// or can be given explicitly
+	validPath := filepath.Join(tempDir, "valid.toml")
+	as.NoError(os.WriteFile(validPath, []byte("[formatter.nix]\ncommand = \"nixfmt\"\nincludes = [\"*.nix\"]\n"), 0o600))
+
+	treefmt(t,
+		withArgs("config", "validate", validPath),
+		withNoError(t),
+		withOutput(func(out []byte) {
+			as.Equal(validPath+" is valid\n", string(out))
+		}),
+	)
+
+	treefmt(t,
+		withArgs("config", "schema"),
+		withNoError(t),
+		withOutput(func(out []byte) {
+			var schema map[string]any
+			as.NoError(json.Unmarshal(out, &schema))
+			as.Equal("treefmt.toml", schema["title"])
+		}),
+	)
+
+	treefmt(t,
+		withArgs("config", "presets"),
+		withNoError(t),
+		withOutput(func(out []byte) {
+			as.Contains(string(out), "gofmt\n  command:      gofmt -w\n  includes:     *.go\n")
+		}),
+	)
+}
+
+func TestConfigFormats(t *testing.T) {
+	as := require.New(t)
+
+	tempDir := test.TempExamples(t)
+	test.ChangeWorkDir(t, tempDir)
+
+	// the examples contain a treefmt.toml, which would otherwise take precedence
+	as.NoError(os.Remove(filepath.Join(tempDir, "treefmt.toml")))
+
+	configs := map[string]string{
+		"treefmt.yaml": `formatter:
+  append:
+    command: test-fmt-append
+    options: ["   "]
+    includes: ["*.rb", "*.nix"]
+`,
+		".treefmt.json": `{
+  "formatter": {
+    "append": {"command": "test-fmt-append", "options": ["   "], "includes": ["*.rb", "*.nix"]}
+  }
+}`,
+	}
+
+	for name, contents := range configs {
+		t.Run(name, func(t *testing.T) {
+			configPath := filepath.Join(tempDir, name)
+			as.NoError(os.WriteFile(configPath, []byte(contents), 0o600))
+
+			t.Cleanup(func() {
+				as.NoError(os.Remove(configPath))
+			})
+
+			// the config file is discovered and parsed according to its extension
+			treefmt(t,
+				withNoError(t),
+				withModtimeBump(tempDir, time.Second),
+				withStats(t, map[stats.Type]int{
+					stats.Traversed: 32,
+					stats.Matched:   2,
+					stats.Formatted: 2,
+					stats.Changed:   2,
+				}),
+			)
+
+			treefmt(t,
+				withArgs("config", "validate"),
+				withNoError(t),
+				withOutput(func(out []byte) {
+					as.Equal(configPath+" is valid\n", string(out))
+				}),
+			)
+		})
+	}
+}
+
+func TestNestedConfig(t *testing.T) {
+	as := require.New(t)
+
+	tempDir := test.TempExamples(t)
+	configPath := filepath.Join(tempDir, "treefmt.toml")
+
+	test.WriteConfig(t, configPath, &config.Config{
+		NestedConfigs: true,
+		FormatterConfigs: map[string]*config.Formatter{
+			"haskell": {
+				Command:  "test-fmt-append",
+				Options:  []string{"   "},
+				Includes: []string{"*.hs"},
+			},
+		},
+	})
+
+	test.ChangeWorkDir(t, tempDir)
+
+	// the haskell directory of the examples contains a config file, which we replace with one which excludes one of
+	// its files and adds a formatter of its own
+	as.NoError(os.WriteFile(filepath.Join(tempDir, "haskell", "treefmt.toml"), []byte(`
+excludes = ["Setup.hs"]
+
+[formatter.cabal]
+command = "test-fmt-append"
+options = ["   "]
+includes = ["*.cabal"]
+`), 0o600))
+
+	treefmt(t,
+		withNoError(t),
+		withStats(t, map[stats.Type]int{
+			stats.Traversed: 32,
+			stats.Matched:   6,
+			stats.Formatted: 6,
+			stats.Changed:   6,
+		}),
+	)
+
+	// formatters changed by a nested config file are distinguished from those of the root config file
+	as.NoError(os.WriteFile(filepath.Join(tempDir, "haskell", "treefmt.toml"), []byte(`
+[formatter.haskell]
+options = ["--", "   "]
+`), 0o600))
+
+	treefmt(t,
+		withArgs("explain", "haskell/Main.hs", "haskell-frontend/Main.hs"),
+		withNoError(t),
+		withOutput(func(out []byte) {
+			as.Contains(string(out), "  config:     haskell/treefmt.toml\n")
+			as.Contains(string(out), "  sequence:   haskell@haskell[0]\n")
+			as.Contains(string(out), "  sequence:   haskell[0]\n")
+		}),
+	)
+
+	// the nested config file changes the signature of the files beneath it, which are formatted again
+	treefmt(t,
+		withNoError(t),
+		withStats(t, map[stats.Type]int{
+			stats.Traversed: 32,
+			stats.Matched:   6,
+			stats.Formatted: 4,
+			stats.Changed:   4,
+		}),
+	)
+}
+
+func TestEnabledIf(t *testing.T) {
+	as := require.New(t)
+
+	tempDir := test.TempExamples(t)
+	configPath := filepath.Join(tempDir, "treefmt.toml")
+
+	test.ChangeWorkDir(t, tempDir)
+
+	t.Setenv("TREEFMT_TEST_ELM", "")
+
+	cfg := &config.Config{
+		FormatterConfigs: map[string]*config.Formatter{
+			"elm": {
+				Command:   "test-fmt-append",
+				Options:   []string{"   "},
+				Includes:  []string{"*.elm"},
+				EnabledIf: `{{isset "TREEFMT_TEST_ELM"}}`,
+			},
+			"haskell": {
+				Command:   "does-not-exist",
+				Includes:  []string{"*.hs"},
+				EnabledIf: `{{executable "does-not-exist"}}`,
+			},
+		},
+	}
+
+	// the haskell formatter is disabled, so its missing command is not an error
+	treefmt(t,
+		withConfig(configPath, cfg),
+		withNoError(t),
+		withStats(t, map[stats.Type]int{
+			stats.Traversed: 32,
+			stats.Matched:   1,
+			stats.Formatted: 1,
+			stats.Changed:   1,
+		}),
+	)
+
+	treefmt(t,
+		withArgs("list"),
+		withConfig(configPath, cfg),
+		withNoError(t),
+		withOutput(func(out []byte) {
+			as.Contains(string(out), "  selected: true\n  enabled:  false (enabled-if)\n")
+			as.Equal(1, strings.Count(string(out), "enabled:"))
+		}),
+	)
+
+	// invalid conditions are reported
+	cfg.FormatterConfigs["elm"].EnabledIf = "{{.OS}}"
+
+	treefmt(t,
+		withConfig(configPath, cfg),
+		withError(func(err error) {
+			as.ErrorContains(err, "formatter.elm.enabled-if must evaluate to true or false")
+		}),
+	)
+}
+
+func TestBinPaths(t *testing.T) {
+	as := require.New(t)
+
+	tempDir := test.TempExamples(t)
+	configPath := filepath.Join(tempDir, "treefmt.toml")
+
+	test.ChangeWorkDir(t, tempDir)
+
+	// a formatter which is only installed within the project
+	binDir := filepath.Join(tempDir, "node_modules", ".bin")
+	as.NoError(os.MkdirAll(binDir, 0o755))
+	as.NoError(os.WriteFile(filepath.Join(binDir, "local-fmt"), []byte("#!/bin/sh\n\ntouch \"$@\"\n"), 0o755))
+
+	cfg := &config.Config{
+		FormatterConfigs: map[string]*config.Formatter{
+			"local": {
+				Command:  "local-fmt",
+				Includes: []string{"*.elm"},
+			},
+		},
+	}
+
+	// it cannot be found on the PATH
+	treefmt(t,
+		withConfig(configPath, cfg),
+		withError(func(err error) {
+			as.ErrorIs(err, format.ErrCommandNotFound)
+		}),
+	)
+
+	// so a formatter which is enabled if it is executable is skipped
+	cfg.FormatterConfigs["local"].EnabledIf = `{{executable "local-fmt"}}`
+
+	treefmt(t,
+		withConfig(configPath, cfg),
+		withNoError(t),
+		withStats(t, map[stats.Type]int{
+			stats.Traversed: 33,
+			stats.Matched:   0,
+		}),
+	)
+
+	// but is found within the bin paths, relative to the tree root
+	cfg.BinPaths = []string{"node_modules/.bin"}
+
+	treefmt(t,
+		withConfig(configPath, cfg),
+		withNoError(t),
+		withStats(t, map[stats.Type]int{
+			stats.Traversed: 33, // including the formatter itself
+			stats.Matched:   1,
+			stats.Formatted: 1,
+			stats.Changed:   1,
+		}),
+	)
+}
+
+func TestRunner(t *testing.T) {
+	as := require.New(t)
+
+	tempDir := test.TempExamples(t)
+	configPath := filepath.Join(tempDir, "treefmt.toml")
+
+	test.ChangeWorkDir(t, tempDir)
+
+	// a runner which, like npx --no-install, only invokes tools installed within the project
+	binDir := t.TempDir()
+	as.NoError(os.WriteFile(filepath.Join(binDir, "npx"), []byte(`#!/bin/sh
+[ "$1" = "--no-install" ] || exit 2
+shift
+tool="$1"
+shift
+exec "node_modules/.bin/$tool" "$@"
+`), 0o755))
+
+	t.Setenv("PATH", binDir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	cfg := &config.Config{
+		FormatterConfigs: map[string]*config.Formatter{
+			"elm": {
+				Runner:   "npx",
+				Command:  "elm-fmt",
+				Options:  []string{"--yes"},
+				Includes: []string{"*.elm"},
+			},
+		},
+	}
+
+	// the tool has not been installed
+	treefmt(t,
+		withConfig(configPath, cfg),
+		withError(func(err error) {
+			as.ErrorIs(err, format.ErrNotInstalled)
+			as.ErrorContains(err, "elm-fmt is missing from node_modules/.bin")
+		}),
+	)
+
+	// which can be allowed, like a missing command
+	cfg.AllowMissingFormatter = true
+
+	treefmt(t,
+		withConfig(configPath, cfg),
+		withNoError(t),
+		withStats(t, map[stats.Type]int{
+			stats.Matched: 0,
+		}),
+	)
+
+	// once installed, the runner is invoked with the tool and its options
+	toolDir := filepath.Join(tempDir, "node_modules", ".bin")
+	logPath := filepath.Join(t.TempDir(), "args.log")
+
+	as.NoError(os.MkdirAll(toolDir, 0o755))
+	as.NoError(os.WriteFile(
+		filepath.Join(toolDir, "elm-fmt"), []byte("#!/bin/sh\n\necho \"$@\" > "+logPath+"\n"), 0o755,
+	))
+
+	treefmt(t,
+		withConfig(configPath, cfg),
+		withNoError(t),
+		withStats(t, map[stats.Type]int{
+			stats.Matched:   1,
+			stats.Formatted: 1,
+		}),
+	)
+
+	args, err := os.ReadFile(logPath)
+	as.NoError(err)
+	as.Equal("--yes elm/src/Main.elm\n", string(args))
+
+	// unknown runners are reported
+	cfg.FormatterConfigs["elm"].Runner = "npm"
+
+	treefmt(t,
+		withConfig(configPath, cfg),
+		withError(func(err error) {
+			as.ErrorContains(err, "formatter 'elm' has an invalid runner 'npm', must be one of "+
+				"<bunx|npx|pnpm dlx|pnpm exec|uvx>")
+		}),
+	)
+}
+
+func TestContainer(t *testing.T) {
+	as := require.New(t)
+
+	tempDir := test.TempExamples(t)
+	configPath := filepath.Join(tempDir, "treefmt.toml")
+
+	test.ChangeWorkDir(t, tempDir)
+
+	cfg := &config.Config{
+		FormatterConfigs: map[string]*config.Formatter{
+			"append": {
+				Container: "example/append:1",
+				Command:   "test-fmt-append",
+				Options:   []string{"   "},
+				Includes:  []string{"*.hs"},
+			},
+		},
+	}
+
+	// there is no engine with which to run the container
+	prevPath := os.Getenv("PATH")
+	t.Setenv("PATH", t.TempDir())
+
+	treefmt(t,
+		withConfig(configPath, cfg),
+		withError(func(err error) {
+			as.ErrorIs(err, format.ErrCommandNotFound)
+			as.ErrorContains(err, "container engine docker or podman")
+		}),
+	)
+
+	// an engine which records its args, then runs the command following the image on the host
+	binDir := t.TempDir()
+	logPath := filepath.Join(t.TempDir(), "args.log")
+
+	as.NoError(os.WriteFile(filepath.Join(binDir, "docker"), []byte(`#!/bin/sh
+echo "$@" > `+logPath+`
+while [ "$1" != "example/append:1" ]; do shift; done
+shift
+exec "$@"
+`), 0o755))
+
+	t.Setenv("PATH", binDir+string(os.PathListSeparator)+prevPath)
+
+	readArgs := func() string {
+		args, err := os.ReadFile(logPath)
+		as.NoError(err)
+
+		return string(args)
+	}
+
+	treefmt(t,
+		withArgs("--no-cache"),
+		withConfig(configPath, cfg),
+		withNoError(t),
+		withStats(t, map[stats.Type]int{
+			stats.Traversed: 32,
+			stats.Matched:   6,
+			stats.Formatted: 6,
+			stats.Changed:   6,
+		}),
+	)
+
+	// the tree root is mounted at the same path, so the relative paths of the files need no translation
+	args := readArgs()
+	as.True(strings.HasPrefix(args, fmt.Sprintf(
+		"run --rm --interactive --volume %s:%s --workdir %s ", tempDir, tempDir, tempDir,
+	)), args)
+	as.Contains(args, " example/append:1 test-fmt-append     haskell/")
+
+	if uid := os.Getuid(); uid > 0 {
+		as.Contains(args, fmt.Sprintf(" --user %d:%d ", uid, os.Getgid()))
+	}
+
+	// the version of the engine is not that of the formatter
+	treefmt(t,
+		withArgs("list"),
+		withConfig(configPath, cfg),
+		withNoError(t),
+		withOutput(func(out []byte) {
+			as.Contains(string(out), "  image:    example/append:1\n")
+			as.Contains(string(out), "  version:  unknown\n")
+		}),
+	)
+
+	// in check mode, the formatter runs in the sandbox, which links back to the tree root
+	treefmt(t,
+		withArgs("--no-cache", "--check"),
+		withConfig(configPath, cfg),
+		withError(func(err error) {
+			as.ErrorIs(err, runner.ErrFailOnChange)
+		}),
+	)
+
+	args = readArgs()
+	as.NotContains(args, "--workdir "+tempDir+" ")
+	as.Contains(args, fmt.Sprintf(" --volume %s:%s:ro ", tempDir, tempDir))
+
+	// the engine can be chosen, rather than using the first which is found
+	cfg.ContainerEngine = "podman"
+
+	t.Setenv("PATH", binDir)
+
+	treefmt(t,
+		withConfig(configPath, cfg),
+		withError(func(err error) {
+			as.ErrorIs(err, format.ErrCommandNotFound)
+			as.ErrorContains(err, "container engine podman")
+		}),
+	)
+
+	cfg.ContainerEngine = "nerdctl"
+
+	treefmt(t,
+		withConfig(configPath, cfg),
+		withError(func(err error) {
+			as.ErrorContains(err, "invalid container-engine 'nerdctl', must be one of <docker|podman>")
+		}),
+	)
+
+	// the command is found within the image, so cannot be invoked with a runner
+	cfg.ContainerEngine = ""
+	cfg.FormatterConfigs["append"].Runner = "npx"
+
+	treefmt(t,
+		withConfig(configPath, cfg),
+		withError(func(err error) {
+			as.ErrorContains(err, "formatter 'append' is run within a container, so cannot have a runner")
+		}),
+	)
+}
+
+func TestSandbox(t *testing.T) {
+	as := require.New(t)
+
+	tempDir := test.TempExamples(t)
+	configPath := filepath.Join(tempDir, "treefmt.toml")
+
+	test.ChangeWorkDir(t, tempDir)
+
+	cfg := &config.Config{
+		FormatterConfigs: map[string]*config.Formatter{
+			// appends to each file, writes to a temporary file, then tries to write elsewhere in the tree
+			"escape": {
+				Command: "sh",
+				Options: []string{
+					"-c", `for f; do echo >> "$f"; done; echo > "$TMPDIR/tmp" && echo > escaped.txt`, "sh",
+				},
+				Includes: []string{"*.hs"},
+				Sandbox:  true,
+			},
+		},
+	}
+
+	if err := sandbox.Available(); err != nil {
+		t.Skip(err)
+	}
+
+	treefmt(t,
+		withArgs("--no-cache"),
+		withConfig(configPath, cfg),
+		withError(func(err error) {
+			as.ErrorIs(err, format.ErrFormattingFailures)
+		}),
+		withStats(t, map[stats.Type]int{
+			stats.Traversed: 32,
+			stats.Matched:   6,
+			stats.Changed:   6,
+		}),
+	)
+
+	as.NoFileExists(filepath.Join(tempDir, "escaped.txt"))
+
+	// without the sandbox, the formatter can write anywhere, whilst $TMPDIR is no longer set for it
+	cfg.FormatterConfigs["escape"].Sandbox = false
+
+	t.Setenv("TMPDIR", t.TempDir())
+
+	treefmt(t,
+		withArgs("--no-cache"),
+		withConfig(configPath, cfg),
+		withNoError(t),
+	)
+
+	as.FileExists(filepath.Join(tempDir, "escaped.txt"))
+
+	// formatters which are not run as a process for each batch of files cannot be sandboxed
+	cfg.FormatterConfigs["escape"] = &config.Formatter{
+		Command:  "builtin:trim-whitespace",
+		Includes: []string{"*.hs"},
+		Sandbox:  true,
+	}
+
+	treefmt(t,
+		withConfig(configPath, cfg),
+		withError(func(err error) {
+			as.ErrorContains(err, "formatter 'escape' is run in-process, so cannot be sandboxed")
+		}),
+	)
+}
+
+func TestLimits(t *testing.T) {
+	as := require.New(t)
+
+	tempDir := test.TempExamples(t)
+	configPath := filepath.Join(tempDir, "treefmt.toml")
+
+	test.ChangeWorkDir(t, tempDir)
+
+	if runtime.GOOS != "linux" {
+		t.Skip("resource limits are only supported on linux")
+	}
+
+	cfg := &config.Config{
+		FormatterConfigs: map[string]*config.Formatter{
+			// records the niceness and data limit, in KiB, which it is run with
+			"limited": {
+				Command:     "sh",
+				Options:     []string{"-c", "nice > limits.txt && ulimit -d >> limits.txt"},
+				Includes:    []string{"*.hs"},
+				Nice:        10,
+				MemoryLimit: "64MiB",
+			},
+		},
+	}
+
+	treefmt(t,
+		withConfig(configPath, cfg),
+		withNoError(t),
+		withStats(t, map[stats.Type]int{
+			stats.Traversed: 32,
+			stats.Matched:   6,
+			stats.Changed:   0,
+		}),
+	)
+
+	limits, err := os.ReadFile(filepath.Join(tempDir, "limits.txt"))
+	as.NoError(err)
+	as.Equal("10\n65536\n", string(limits))
+	as.NoError(os.Remove(filepath.Join(tempDir, "limits.txt")))
+
+	// a formatter which spins is killed once it has used its cpu time
+	cfg.FormatterConfigs["limited"] = &config.Formatter{
+		Command:  "sh",
+		Options:  []string{"-c", "while :; do :; done"},
+		Includes: []string{"*.hs"},
+		CPULimit: "1s",
+	}
+
+	treefmt(t,
+		withArgs("--no-cache"),
+		withConfig(configPath, cfg),
+		withError(func(err error) {
+			as.ErrorIs(err, format.ErrFormattingFailures)
+		}),
+		withStats(t, map[stats.Type]int{
+			stats.Traversed: 32,
+			stats.Matched:   6,
+			stats.Formatted: 0,
+			stats.Errored:   6,
+		}),
+		withOutput(func(out []byte) {
+			as.Contains(string(out), "exceeded its cpu-limit of 1s")
+		}),
+	)
+
+	// the niceness of a process cannot exceed its bounds
+	cfg.FormatterConfigs["limited"].Nice = 20
+
+	treefmt(t,
+		withConfig(configPath, cfg),
+		withError(func(err error) {
+			as.ErrorContains(err, "formatter 'limited' has an invalid nice value 20, must be between -20 and 19")
+		}),
+	)
+
+	// formatters which are run in-process cannot be limited
+	cfg.FormatterConfigs["limited"] = &config.Formatter{
+		Command:  "builtin:trim-whitespace",
+		Includes: []string{"*.hs"},
+		Nice:     10,
+	}
+
+	treefmt(t,
+		withConfig(configPath, cfg),
+		withError(func(err error) {
+			as.ErrorContains(err, "formatter 'limited' is run in-process, so cannot have resource limits")
+		}),
+	)
+}
+
+func TestSingleFile(t *testing.T) {
+	tempDir := test.TempExamples(t)
+	configPath := filepath.Join(tempDir, "treefmt.toml")
+
+	test.ChangeWorkDir(t, tempDir)
+
+	cfg := &config.Config{
+		FormatterConfigs: map[string]*config.Formatter{
+			"elm": {
+				Command:  "true",
+				Includes: []string{"*.elm"},
+			},
+		},
+	}
+
+	// a single file is formatted without the cache, so it is formatted every time
+	for range 2 {
+		treefmt(t,
+			withArgs("elm/src/Main.elm"),
+			withConfig(configPath, cfg),
+			withNoError(t),
+			withStats(t, map[stats.Type]int{
+				stats.Traversed: 1,
+				stats.Matched:   1,
+				stats.Formatted: 1,
+				stats.Changed:   0,
+			}),
+		)
+	}
+
+	// whereas the cache is used for directories
+	treefmt(t,
+		withArgs("elm"),
+		withConfig(configPath, cfg),
+		withNoError(t),
+		withStats(t, map[stats.Type]int{
+			stats.Traversed: 2,
+			stats.Matched:   1,
+			stats.Formatted: 1,
+		}),
+	)
+
+	treefmt(t,
+		withArgs("elm"),
+		withConfig(configPath, cfg),
+		withNoError(t),
+		withStats(t, map[stats.Type]int{
+			stats.Traversed: 2,
+			stats.Matched:   1,
+			stats.Formatted: 0,
+		}),
+	)
+}
+
+func TestQuiet(t *testing.T) {
+	as := require.New(t)
+
+	tempDir := test.TempExamples(t)
+	configPath := filepath.Join(tempDir, "treefmt.toml")
+
+	test.ChangeWorkDir(t, tempDir)
+
+	// most files in the examples are unmatched, which produces a warning for each
+	cfg := &config.Config{
+		FormatterConfigs: map[string]*config.Formatter{
+			"append": {
+				Command:  "test-fmt-append",
+				Options:  []string{"   "},
+				Includes: []string{"*.go"},
+			},
+		},
+	}
+
+	treefmt(t,
+		withConfig(configPath, cfg),
+		withNoError(t),
+		withOutput(func(out []byte) {
+			as.Contains(string(out), "no formatter for path")
+			as.Contains(string(out), "traversed 32 files")
+		}),
+	)
+
+	// when quiet, there is no output at all, even when verbose
+	treefmt(t,
+		withArgs("--quiet", "-vv", "--no-cache"),
+		withConfig(configPath, cfg),
+		withNoError(t),
+		withStats(t, map[stats.Type]int{
+			stats.Traversed: 32,
+			stats.Matched:   1,
+			stats.Formatted: 1,
+			stats.Changed:   1,
+		}),
+		withOutput(func(out []byte) {
+			as.Empty(string(out))
+		}),
+	)
+
+	// errors are still reported
+	treefmt(t,
+		withArgs("-q", "--no-cache", "--fail-on-change"),
+		withConfig(configPath, cfg),
+		withError(func(err error) {
+			as.ErrorIs(err, runner.ErrFailOnChange)
+		}),
+		withOutput(func(out []byte) {
+			as.NotContains(string(out), "no formatter for path")
+			as.NotContains(string(out), "traversed")
+			as.Contains(string(out), "Error: unexpected changes detected")
+		}),
+	)
+}
+
+func TestOtelEndpoint(t *testing.T) {
+	as := require.New(t)
+
+	tempDir := test.TempExamples(t)
+	configPath := filepath.Join(tempDir, "treefmt.toml")
+
+	test.ChangeWorkDir(t, tempDir)
+
+	// collect the names of the spans which are exported
+	var (
+		lock  sync.Mutex
+		spans []string
+	)
+
+	server := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		var req struct {
+			ResourceSpans []struct {
+				ScopeSpans []struct {
+					Spans []struct {
+						Name string `json:"name"`
+					} `json:"spans"`
+				} `json:"scopeSpans"`
+			} `json:"resourceSpans"`
+		}
+
+		as.NoError(json.NewDecoder(r.Body).Decode(&req))
+
+		lock.Lock()
+		defer lock.Unlock()
+
+		for _, span := range req.ResourceSpans[0].ScopeSpans[0].Spans {
+			spans = append(spans, span.Name)
+		}
+	}))
+	defer server.Close()
+
+	cfg := &config.Config{
+		OtelEndpoint: server.URL,
+		FormatterConfigs: map[string]*config.Formatter{
+			"append": {
+				Command:  "test-fmt-append",
+				Options:  []string{"   "},
+				Includes: []string{"*.hs"},
+			},
+		},
+	}
+
+	treefmt(t,
+		withConfig(configPath, cfg),
+		withNoError(t),
+		withStats(t, map[stats.Type]int{
+			stats.Traversed: 32,
+			stats.Matched:   6,
+			stats.Formatted: 6,
+			stats.Changed:   6,
+		}),
+	)
+
+	as.Contains(spans, "format")
+	as.Contains(spans, "walk")
+	as.Contains(spans, "cache.lookup")
+	as.Contains(spans, "formatter")
+	as.Contains(spans, "cache.update")
+
+	// failing to export does not fail formatting
+	server.Close()
+
+	treefmt(t,
+		withConfig(configPath, cfg),
+		withNoError(t),
+		withOutput(func(out []byte) {
+			as.Contains(string(out), "failed to export traces")
+		}),
+	)
+
+	// the endpoint must be a url
+	cfg.OtelEndpoint = "localhost:4318"
+
+	treefmt(t,
+		withConfig(configPath, cfg),
+		withError(func(err error) {
+			as.ErrorContains(err, "invalid otel endpoint")
+		}),
+	)
+}
+
+func TestChangeWorkingDirectory(t *testing.T) {
+	as := require.New(t)
+
+	cfg := &config.Config{
+		FormatterConfigs: map[string]*config.Formatter{
+			"append": {
+				Command:  "test-fmt-append",
+				Options:  []string{"   "},
+				Includes: []string{"*"},
+			},
+		},
+	}
+
+	t.Run("default", func(t *testing.T) {
+		// capture current cwd, so we can replace it after the test is finished
+		cwd, err := os.Getwd()
+		as.NoError(err)
+
+		t.Cleanup(func() {
+			// return to the previous working directory
+			as.NoError(os.Chdir(cwd))
+		})
+
+		tempDir := test.TempExamples(t)
+		configPath := filepath.Join(tempDir, "treefmt.toml")
+
+		// change to an empty temp dir and try running without specifying a working directory
+		as.NoError(os.Chdir(t.TempDir()))
+
+		treefmt(t,
+			withConfig(configPath, cfg),
+			withError(func(err error) {
+				as.ErrorContains(err, "failed to find treefmt config file")
+			}),
+		)
+
+		// now change to the examples temp directory
+		as.NoError(os.Chdir(tempDir), "failed to change to temp directory")
+
+		treefmt(t,
+			withConfig(configPath, cfg),
+			withNoError(t),
+			withStats(t, map[stats.Type]int{
+				stats.Traversed: 32,
+			}),
+		)
+	})
+
+	execute := func(t *testing.T, configFile string, env bool) {
+		t.Run(configFile, func(t *testing.T) {
+			// capture current cwd, so we can replace it after the test is finished
+			cwd, err := os.Getwd()
+			as.NoError(err)
+
+			t.Cleanup(func() {
+				// return to the previous working directory
+				as.NoError(os.Chdir(cwd))
+			})
+
+			tempDir := test.TempExamples(t)
+			configPath := filepath.Join(tempDir, configFile)
+
+			// delete treefmt.toml that comes with the example folder
+			as.NoError(os.Remove(filepath.Join(tempDir, "treefmt.toml")))
+
+			var args []string
+
+			if env {
+				t.Setenv("TREEFMT_WORKING_DIR", tempDir)
+			} else {
+				args = []string{"-C", tempDir}
+			}
+
+			treefmt(t,
+				withArgs(args...),
+				withConfig(configPath, cfg),
+				withNoError(t),
+				withStats(t, map[stats.Type]int{
+					stats.Traversed: 32,
+				}),
+			)
+		})
+	}
+
+	// by default, we look for a config file at ./treefmt.toml or ./.treefmt.toml in the current working directory
+	configFiles := []string{"treefmt.toml", ".treefmt.toml"}
+
+	t.Run("arg", func(t *testing.T) {
+		for _, configFile := range configFiles {
+			execute(t, configFile, false)
+		}
+	})
+
+	t.Run("env", func(t *testing.T) {
+		for _, configFile := range configFiles {
+			execute(t, configFile, true)
+		}
+	})
+}
+
+func TestFailOnChange(t *testing.T) {
+	as := require.New(t)
+
+	t.Run("change size", func(t *testing.T) {
+		tempDir := test.TempExamples(t)
+		configPath := filepath.Join(tempDir, "treefmt.toml")
+
+		test.ChangeWorkDir(t, tempDir)
+
+		cfg := &config.Config{
+			FormatterConfigs: map[string]*config.Formatter{
+				"append": {
+					// test-fmt-append is a helper defined in nix/packages/treefmt/formatters.nix which lets us append
+					// an arbitrary value to a list of files
+					Command:  "test-fmt-append",
+					Options:  []string{"hello"},
+					Includes: []string{"elm/*"},
+				},
+			},
+		}
+
+		// running with a cold cache, we should see the elm files being formatted, resulting in changes, which should
+		// trigger an error
+		treefmt(t,
+			withArgs("--fail-on-change"),
+			withConfig(configPath, cfg),
+			withError(func(err error) {
+				as.ErrorIs(err, runner.ErrFailOnChange)
+				as.ErrorContains(err, "2 file(s) changed")
+			}),
+			withStats(t, map[stats.Type]int{
+				stats.Traversed: 32,
+				stats.Matched:   2,
+				stats.Formatted: 2,
+				stats.Changed:   2,
+			}),
+			withOutput(func(out []byte) {
+				// the changed files are listed in order, along with the formatters responsible
+				as.Contains(string(out), "2 file(s) changed:\n  elm/elm.json (append)\n  elm/src/Main.elm (append)\n")
+			}),
+		)
+
+		// running with a hot cache, we should see matches for the elm files, but no attempt to format them as the
+		// underlying files have not changed since we last ran
+		treefmt(t,
+			withArgs("--fail-on-change"),
+			withNoError(t),
+			withStats(t, map[stats.Type]int{
+				stats.Traversed: 32,
+				stats.Matched:   2,
+				stats.Formatted: 0,
+				stats.Changed:   0,
+			}),
+		)
+	})
+
+	t.Run("change modtime", func(t *testing.T) {
 		tempDir := test.TempExamples(t)
 		configPath := filepath.Join(tempDir, "treefmt.toml")
 
-		// change to an empty temp dir and try running without specifying a working directory
-		as.NoError(os.Chdir(t.TempDir()))
+		test.ChangeWorkDir(t, tempDir)
+
+		dateFormat := "2006 01 02 15:04.05"
+		replacer := strings.NewReplacer(" ", "", ":", "")
+
+		formatTime := func(t time.Time) string {
+			// go date formats are stupid
+			return replacer.Replace(t.Format(dateFormat))
+		}
+
+		// running with a cold cache, we should see the haskell files being formatted, resulting in changes, which should
+		// trigger an error
+		treefmt(t,
+			withArgs("--fail-on-change"),
+			withConfigFunc(configPath, func() *config.Config {
+				// new mod time is in the next second
+				modTime := time.Now().Truncate(time.Second).Add(time.Second)
+
+				return &config.Config{
+					FormatterConfigs: map[string]*config.Formatter{
+						"append": {
+							// test-fmt-modtime is a helper defined in nix/packages/treefmt/formatters.nix which lets us set
+							// a file's modtime to an arbitrary date.
+							// in this case, we move it forward more than a second so that our second level modtime comparison
+							// will detect it as a change.
+							Command:  "test-fmt-modtime",
+							Options:  []string{formatTime(modTime)},
+							Includes: []string{"haskell/*"},
+						},
+					},
+				}
+			}),
+			withError(func(err error) {
+				as.ErrorIs(err, runner.ErrFailOnChange)
+			}),
+			withStats(t, map[stats.Type]int{
+				stats.Traversed: 32,
+				stats.Matched:   7,
+				stats.Formatted: 7,
+				stats.Changed:   7,
+			}),
+		)
+
+		// running with a hot cache, we should see matches for the haskell files, but no attempt to format them as the
+		// underlying files have not changed since we last ran
+		treefmt(t,
+			withArgs("--fail-on-change"),
+			withNoError(t),
+			withStats(t, map[stats.Type]int{
+				stats.Traversed: 32,
+				stats.Matched:   7,
+				stats.Formatted: 0,
+				stats.Changed:   0,
+			}),
+		)
+	})
+}
+
+func TestExitCodes(t *testing.T) {
+	as := require.New(t)
+
+	tempDir := test.TempExamples(t)
+	configPath := filepath.Join(tempDir, "treefmt.toml")
+
+	test.ChangeWorkDir(t, tempDir)
+
+	execute := func(cfg *config.Config, args ...string) (int, string) {
+		t.Helper()
+
+		if cfg != nil {
+			test.WriteConfig(t, configPath, cfg)
+		}
+
+		var stderr bytes.Buffer
+
+		root, _ := cmd.NewRoot()
+		root.SetArgs(args)
+		root.SetOut(io.Discard)
+		root.SetErr(&stderr)
+
+		code := cmd.Execute(root)
+		t.Logf("treefmt %s: %d\n%s", strings.Join(args, " "), code, stderr.String())
+
+		return code, stderr.String()
+	}
+
+	formatterConfig := func(command string) *config.Config {
+		return &config.Config{
+			FormatterConfigs: map[string]*config.Formatter{
+				"append": {
+					Command:  command,
+					Options:  []string{"hello"},
+					Includes: []string{"elm/*"},
+				},
+			},
+		}
+	}
+
+	code, _ := execute(formatterConfig("test-fmt-append"), "--quiet", "--no-cache")
+	as.Equal(0, code)
+
+	code, stderr := execute(nil, "--quiet", "--no-cache", "--fail-on-change")
+	as.Equal(3, code)
+	as.Contains(stderr, "Error: unexpected changes detected, --fail-on-change is enabled: 2 file(s) changed")
+
+	code, _ = execute(formatterConfig("false"), "--quiet", "--no-cache")
+	as.Equal(4, code)
+
+	code, _ = execute(formatterConfig("does-not-exist"), "--quiet", "--no-cache")
+	as.Equal(4, code)
+
+	code, _ = execute(nil, "--config-file", filepath.Join(tempDir, "does-not-exist.toml"))
+	as.Equal(5, code)
+
+	code, stderr = execute(nil, "--error-format", "yaml")
+	as.Equal(5, code)
+	as.Contains(stderr, "Error: invalid error-format: yaml")
+
+	code, stderr = execute(nil, "--unknown-flag")
+	as.Equal(2, code)
+	as.Contains(stderr, "Error: unknown flag: --unknown-flag")
+
+	code, _ = execute(nil, "--stdin", "a.go", "b.go")
+	as.Equal(2, code)
+
+	code, _ = execute(nil, "docs", "man")
+	as.Equal(2, code)
+
+	// the class of failure is printed as json, which can be selected with an env variable as the config may not be
+	// loaded by the time the error occurs
+	code, stderr = execute(
+		formatterConfig("test-fmt-append"), "--error-format", "json", "--quiet", "--no-cache", "--fail-on-change",
+	)
+	as.Equal(3, code)
+
+	var report struct {
+		Error    string `json:"error"`
+		Class    string `json:"class"`
+		ExitCode int    `json:"exitCode"`
+	}
+
+	start := strings.IndexByte(stderr, '{')
+	as.GreaterOrEqual(start, 0, "no report in stderr: %s", stderr)
+	as.NoError(json.Unmarshal([]byte(stderr[start:]), &report))
+	as.Equal("changes", report.Class)
+	as.Equal(3, report.ExitCode)
+	as.Contains(report.Error, "2 file(s) changed")
+
+	t.Setenv("TREEFMT_ERROR_FORMAT", "json")
+
+	code, stderr = execute(nil, "--unknown-flag")
+	as.Equal(2, code)
+	as.Contains(stderr, `{"error":"unknown flag: --unknown-flag","class":"usage","exitCode":2}`)
+}
+
+func TestOutput(t *testing.T) {
+	as := require.New(t)
+
+	tempDir := test.TempExamples(t)
+	configPath := filepath.Join(tempDir, "treefmt.toml")
+
+	test.ChangeWorkDir(t, tempDir)
+
+	cfg := &config.Config{
+		OnUnmatched: "debug",
+		FormatterConfigs: map[string]*config.Formatter{
+			"append": {
+				Command:  "test-fmt-append",
+				Options:  []string{"hello"},
+				Includes: []string{"elm/*"},
+			},
+		},
+	}
+
+	t.Run("json", func(t *testing.T) {
+		treefmt(t,
+			withArgs("--output", "json"),
+			withConfig(configPath, cfg),
+			withNoError(t),
+			withOutput(func(out []byte) {
+				// skip any log output which precedes the summary
+				start := bytes.IndexByte(out, '{')
+				as.GreaterOrEqual(start, 0, "no summary in output: %s", out)
+
+				var summary stats.Summary
+				as.NoError(json.Unmarshal(out[start:], &summary))
+
+				as.Equal(32, summary.Counters[stats.Traversed])
+				as.Equal(2, summary.Counters[stats.Matched])
+				as.Equal(2, summary.Counters[stats.Formatted])
+				as.Equal(2, summary.Counters[stats.Changed])
+
+				as.Contains(summary.Formatters, "append")
+				as.Equal(2, summary.Formatters["append"].Files)
+
+				as.Equal([]stats.Change{
+					{Path: "elm/elm.json", Formatters: []string{"append"}},
+					{Path: "elm/src/Main.elm", Formatters: []string{"append"}},
+				}, summary.Changes)
+			}),
+		)
+	})
+
+	t.Run("detailed", func(t *testing.T) {
+		treefmt(t,
+			withArgs("--summary", "detailed", "--no-cache"),
+			withConfig(configPath, cfg),
+			withNoError(t),
+			withOutput(func(out []byte) {
+				as.Contains(string(out), "traversed 32 files\n")
+				as.Regexp(`\nformatter\s+files\s+batches\s+duration\nappend\s+2\s+1\s+\S+\n$`, string(out))
+			}),
+		)
+
+		treefmt(t,
+			withArgs("--summary", "verbose"),
+			withConfig(configPath, cfg),
+			withError(func(err error) {
+				as.ErrorContains(err, "invalid summary: verbose, must be one of <basic|detailed>")
+			}),
+		)
+	})
+
+	t.Run("github", func(t *testing.T) {
+		checkAnnotations := func(command string) func([]byte) {
+			return func(out []byte) {
+				for _, path := range []string{"elm/elm.json", "elm/src/Main.elm"} {
+					as.Contains(
+						string(out),
+						fmt.Sprintf("::%s file=%s,title=treefmt::File was changed by append\n", command, path),
+					)
+				}
+			}
+		}
+
+		// auto-detected when running in GitHub Actions
+		treefmt(t,
+			withArgs("--fail-on-change"),
+			withEnv(map[string]string{"GITHUB_ACTIONS": "true"}),
+			withModtimeBump(filepath.Join(tempDir, "elm"), time.Second),
+			withError(func(err error) {
+				as.ErrorIs(err, runner.ErrFailOnChange)
+			}),
+			withOutput(checkAnnotations("error")),
+		)
+
+		// but not when changes are not a failure, as every change would otherwise be annotated
+		treefmt(t,
+			withEnv(map[string]string{"GITHUB_ACTIONS": "true"}),
+			withModtimeBump(filepath.Join(tempDir, "elm"), 2*time.Second),
+			withNoError(t),
+			withOutput(func(out []byte) {
+				as.NotContains(string(out), "::notice")
+				as.Contains(string(out), "formatted 2 files (2 changed)")
+			}),
+		)
+
+		// forced with the flag, reporting notices as fail-on-change is disabled
+		treefmt(t,
+			withArgs("--output", "github"),
+			withModtimeBump(filepath.Join(tempDir, "elm"), 3*time.Second),
+			withNoError(t),
+			withOutput(checkAnnotations("notice")),
+		)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		treefmt(t,
+			withArgs("--output", "yaml"),
+			withError(func(err error) {
+				as.ErrorContains(err, "invalid output format")
+			}),
+		)
+	})
+}
+
+func TestReport(t *testing.T) {
+	as := require.New(t)
+
+	tempDir := test.TempExamples(t)
+	configPath := filepath.Join(tempDir, "treefmt.toml")
+
+	test.ChangeWorkDir(t, tempDir)
+
+	cfg := &config.Config{
+		FormatterConfigs: map[string]*config.Formatter{
+			"append": {
+				Command:  "test-fmt-append",
+				Options:  []string{"hello"},
+				Includes: []string{"elm/*"},
+			},
+		},
+	}
+
+	t.Run("sarif", func(t *testing.T) {
+		reportPath := filepath.Join(tempDir, "treefmt.sarif")
+
+		treefmt(t,
+			withArgs("--fail-on-change", "--report", "sarif="+reportPath),
+			withConfig(configPath, cfg),
+			withError(func(err error) {
+				as.ErrorIs(err, runner.ErrFailOnChange)
+			}),
+		)
+
+		data, err := os.ReadFile(reportPath)
+		as.NoError(err)
+
+		var sarif struct {
+			Version string `json:"version"`
+			Runs    []struct {
+				Results []struct {
+					RuleID    string `json:"ruleId"`
+					Locations []struct {
+						PhysicalLocation struct {
+							ArtifactLocation struct {
+								URI string `json:"uri"`
+							} `json:"artifactLocation"`
+						} `json:"physicalLocation"`
+					} `json:"locations"`
+				} `json:"results"`
+			} `json:"runs"`
+		}
+
+		as.NoError(json.Unmarshal(data, &sarif))
+		as.Equal("2.1.0", sarif.Version)
+		as.Len(sarif.Runs, 1)
+
+		results := sarif.Runs[0].Results
+		as.Len(results, 2)
+
+		for idx, path := range []string{"elm/elm.json", "elm/src/Main.elm"} {
+			as.Equal("append", results[idx].RuleID)
+			as.Equal(path, results[idx].Locations[0].PhysicalLocation.ArtifactLocation.URI)
+		}
+	})
+
+	t.Run("junit", func(t *testing.T) {
+		reportPath := filepath.Join(tempDir, "junit.xml")
 
+		// bump the mod times so the elm files are formatted again
 		treefmt(t,
-			withConfig(configPath, cfg),
+			withArgs("--fail-on-change", "--report", "junit="+reportPath),
+			withModtimeBump(filepath.Join(tempDir, "elm"), time.Second),
 			withError(func(err error) {
-				as.ErrorContains(err, "failed to find treefmt config file")
+				as.ErrorIs(err, runner.ErrFailOnChange)
 			}),
 		)
 
-		// now change to the examples temp directory
-		as.NoError(os.Chdir(tempDir), "failed to change to temp directory")
+		data, err := os.ReadFile(reportPath)
+		as.NoError(err)
+
+		var junit struct {
+			Tests     int `xml:"tests,attr"`
+			Failures  int `xml:"failures,attr"`
+			TestSuite struct {
+				TestCases []struct {
+					Name    string `xml:"name,attr"`
+					Failure *struct {
+						Contents string `xml:",chardata"`
+					} `xml:"failure"`
+				} `xml:"testcase"`
+			} `xml:"testsuite"`
+		}
+
+		as.NoError(xml.Unmarshal(data, &junit))
+		as.Equal(1, junit.Tests)
+		as.Equal(1, junit.Failures)
+
+		testCases := junit.TestSuite.TestCases
+		as.Len(testCases, 1)
+		as.Equal("append", testCases[0].Name)
+		as.NotNil(testCases[0].Failure)
+		as.Equal("elm/elm.json\nelm/src/Main.elm", testCases[0].Failure.Contents)
+	})
+
+	t.Run("codeclimate", func(t *testing.T) {
+		reportPath := filepath.Join(tempDir, "codeclimate.json")
 
 		treefmt(t,
-			withConfig(configPath, cfg),
-			withNoError(t),
-			withStats(t, map[stats.Type]int{
-				stats.Traversed: 32,
+			withArgs("--fail-on-change", "--report", "codeclimate="+reportPath),
+			withModtimeBump(filepath.Join(tempDir, "elm"), 2*time.Second),
+			withError(func(err error) {
+				as.ErrorIs(err, runner.ErrFailOnChange)
 			}),
 		)
+
+		data, err := os.ReadFile(reportPath)
+		as.NoError(err)
+
+		var issues []struct {
+			CheckName   string `json:"check_name"` //nolint:tagliatelle
+			Fingerprint string `json:"fingerprint"`
+			Location    struct {
+				Path string `json:"path"`
+			} `json:"location"`
+		}
+
+		as.NoError(json.Unmarshal(data, &issues))
+		as.Len(issues, 2)
+
+		for idx, path := range []string{"elm/elm.json", "elm/src/Main.elm"} {
+			as.Equal("treefmt/append", issues[idx].CheckName)
+			as.Equal(path, issues[idx].Location.Path)
+			as.NotEmpty(issues[idx].Fingerprint)
+		}
+
+		as.NotEqual(issues[0].Fingerprint, issues[1].Fingerprint)
 	})
 
-	execute := func(t *testing.T, configFile string, env bool) {
-		t.Run(configFile, func(t *testing.T) {
-			// capture current cwd, so we can replace it after the test is finished
-			cwd, err := os.Getwd()
-			as.NoError(err)
+	t.Run("invalid", func(t *testing.T) {
+		treefmt(t,
+			withArgs("--report", "sarif"),
+			withError(func(err error) {
+				as.ErrorContains(err, "must be of the form <format>=<path>")
+			}),
+		)
 
-			t.Cleanup(func() {
-				// return to the previous working directory
-				as.NoError(os.Chdir(cwd))
-			})
+		treefmt(t,
+			withArgs("--report", "html=report.html"),
+			withError(func(err error) {
+				as.ErrorContains(err, "invalid report format")
+			}),
+		)
+	})
+}
 
-			tempDir := test.TempExamples(t)
-			configPath := filepath.Join(tempDir, configFile)
+func TestWatch(t *testing.T) {
+	as := require.New(t)
 
-			// delete treefmt.toml that comes with the example folder
-			as.NoError(os.Remove(filepath.Join(tempDir, "treefmt.toml")))
+	tempDir := test.TempExamples(t)
+	configPath := filepath.Join(tempDir, "treefmt.toml")
+	elmPath := filepath.Join(tempDir, "elm", "elm.json")
 
-			var args []string
+	test.ChangeWorkDir(t, tempDir)
 
-			if env {
-				t.Setenv("TREEFMT_WORKING_DIR", tempDir)
-			} else {
-				args = []string{"-C", tempDir}
+	cfg := &config.Config{
+		FormatterConfigs: map[string]*config.Formatter{
+			"append": {
+				Command:  "test-fmt-append",
+				Options:  []string{"hello"},
+				Includes: []string{"elm/*"},
+			},
+		},
+	}
+
+	// waitFor polls the elm file until its contents end with suffix
+	waitFor := func(suffix string) error {
+		return eventually(func() bool {
+			data, err := os.ReadFile(elmPath)
+
+			return err == nil && strings.HasSuffix(string(data), suffix)
+		}, 10*time.Second)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	done := make(chan error, 1)
+
+	go func() {
+		// stop watching once we are finished, even if something went wrong
+		defer cancel()
+
+		done <- func() error {
+			// wait for the initial pass to complete and give the watcher a chance to start
+			if err := waitFor("hello\n"); err != nil {
+				return err
 			}
 
-			treefmt(t,
-				withArgs(args...),
-				withConfig(configPath, cfg),
-				withNoError(t),
-				withStats(t, map[stats.Type]int{
-					stats.Traversed: 32,
-				}),
-			)
-		})
+			time.Sleep(500 * time.Millisecond)
+
+			// in between passes, other treefmt processes can lock the tree and open the cache
+			if err := checkUnlocked(tempDir); err != nil {
+				return err
+			}
+
+			// modify a file, which should be formatted again
+			f, err := os.OpenFile(elmPath, os.O_APPEND|os.O_WRONLY, 0o644)
+			if err != nil {
+				return err
+			}
+
+			if _, err = f.WriteString("foo\n"); err != nil {
+				return errors.Join(err, f.Close())
+			} else if err = f.Close(); err != nil {
+				return err
+			}
+
+			if err = waitFor("foo\nhello\n"); err != nil {
+				return err
+			}
+
+			// formatting the file must not trigger another pass, which would append to it again
+			time.Sleep(500 * time.Millisecond)
+
+			return nil
+		}()
+	}()
+
+	treefmt(t,
+		withContext(ctx),
+		// without the cache, nothing else prevents a file from being formatted again after each write
+		withArgs("--watch", "--no-cache"),
+		withConfig(configPath, cfg),
+		withNoError(t),
+	)
+
+	as.NoError(<-done)
+
+	data, err := os.ReadFile(elmPath)
+	as.NoError(err)
+	as.True(strings.HasSuffix(string(data), "hello\nfoo\nhello\n"))
+
+	// watch cannot be combined with stdin
+	treefmt(t,
+		withArgs("--watch", "--stdin", "test.nix"),
+		withError(func(err error) {
+			as.ErrorContains(err, "cannot be used with the --stdin flag")
+		}),
+	)
+}
+
+func TestDaemon(t *testing.T) {
+	as := require.New(t)
+
+	tempDir := test.TempExamples(t)
+	configPath := filepath.Join(tempDir, "treefmt.toml")
+	socketPath := filepath.Join(t.TempDir(), "treefmt.sock")
+
+	test.ChangeWorkDir(t, tempDir)
+
+	cfg := &config.Config{
+		FormatterConfigs: map[string]*config.Formatter{
+			"append": {
+				Command:  "test-fmt-append",
+				Options:  []string{"hello"},
+				Includes: []string{"elm/*"},
+			},
+		},
 	}
 
-	// by default, we look for a config file at ./treefmt.toml or ./.treefmt.toml in the current working directory
-	configFiles := []string{"treefmt.toml", ".treefmt.toml"}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 
-	t.Run("arg", func(t *testing.T) {
-		for _, configFile := range configFiles {
-			execute(t, configFile, false)
-		}
-	})
+	// the responses are asserted on once the daemon has stopped
+	var responses []daemon.Response
 
-	t.Run("env", func(t *testing.T) {
-		for _, configFile := range configFiles {
-			execute(t, configFile, true)
+	done := make(chan error, 1)
+
+	go func() {
+		// stop the daemon once we are finished, even if something went wrong
+		defer cancel()
+
+		done <- func() error {
+			conn, err := dialDaemon(socketPath)
+			if err != nil {
+				return err
+			}
+
+			defer conn.Close()
+
+			encoder := json.NewEncoder(conn)
+			scanner := bufio.NewScanner(conn)
+
+			request := func(paths ...string) error {
+				res, err := requestDaemon(encoder, scanner, paths...)
+				responses = append(responses, res)
+
+				return err
+			}
+
+			// format a single file, the whole tree, and then a directory once the cache is warm
+			for _, paths := range [][]string{{"elm/elm.json"}, nil, {filepath.Join(tempDir, "elm")}} {
+				if err = request(paths...); err != nil {
+					return err
+				}
+			}
+
+			// in between requests, other treefmt processes can lock the tree and open the cache
+			if err = checkUnlocked(tempDir); err != nil {
+				return err
+			}
+
+			// whilst they hold the lock, requests fail
+			unlock, err := cache.Lock(tempDir, 0)
+			if err != nil {
+				return err
+			}
+
+			if err = request(); err != nil {
+				return errors.Join(err, unlock())
+			} else if err = unlock(); err != nil {
+				return err
+			}
+
+			// invalid paths, and a file whose name merely starts with .., which is inside the tree root
+			if err = os.WriteFile(filepath.Join(tempDir, "..hidden"), []byte("hidden\n"), 0o600); err != nil {
+				return err
+			}
+
+			for _, path := range []string{"foo/bar", "../foo", "..hidden"} {
+				if err = request(path); err != nil {
+					return err
+				}
+			}
+
+			return nil
+		}()
+	}()
+
+	treefmt(t,
+		withContext(ctx),
+		withArgs("daemon", "--socket", socketPath),
+		withConfig(configPath, cfg),
+		withNoError(t),
+	)
+
+	as.NoError(<-done)
+	as.Len(responses, 7)
+
+	// a single file
+	as.Empty(responses[0].Error)
+	as.Equal(1, responses[0].Summary.Counters[stats.Traversed])
+	as.Equal(1, responses[0].Summary.Counters[stats.Changed])
+
+	// the whole tree
+	as.Empty(responses[1].Error)
+	as.Equal(32, responses[1].Summary.Counters[stats.Traversed])
+	as.Equal(1, responses[1].Summary.Counters[stats.Changed])
+
+	// the cache is warm, so nothing should be formatted
+	as.Empty(responses[2].Error)
+	as.Equal(2, responses[2].Summary.Counters[stats.Traversed])
+	as.Equal(0, responses[2].Summary.Counters[stats.Formatted])
+
+	// another treefmt process held the lock
+	as.Contains(responses[3].Error, cache.ErrLocked.Error())
+
+	// invalid paths
+	as.Contains(responses[4].Error, "not found")
+	as.Contains(responses[5].Error, "not inside the tree root")
+
+	// but a file whose name merely starts with .. is inside it
+	as.Empty(responses[6].Error)
+	as.Equal(1, responses[6].Summary.Counters[stats.Traversed])
+}
+
+// dialDaemon connects to the daemon listening on socketPath, waiting for it to start.
+func dialDaemon(socketPath string) (net.Conn, error) {
+	var conn net.Conn
+
+	err := eventually(func() bool {
+		var err error
+		conn, err = net.Dial("unix", socketPath)
+
+		return err == nil
+	}, 5*time.Second)
+	if err != nil {
+		return nil, fmt.Errorf("daemon is not listening on %s: %w", socketPath, err)
+	}
+
+	return conn, nil
+}
+
+// requestDaemon asks the daemon to format paths, returning its response.
+func requestDaemon(encoder *json.Encoder, scanner *bufio.Scanner, paths ...string) (daemon.Response, error) {
+	var res daemon.Response
+
+	if err := encoder.Encode(daemon.Request{Paths: paths}); err != nil {
+		return res, fmt.Errorf("failed to send request: %w", err)
+	}
+
+	if !scanner.Scan() {
+		return res, fmt.Errorf("failed to read response: %w", cmp.Or(scanner.Err(), io.ErrUnexpectedEOF))
+	}
+
+	if err := json.Unmarshal(scanner.Bytes(), &res); err != nil {
+		return res, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	return res, nil
+}
+
+// eventually polls condition until it is satisfied, returning an error if it is not within timeout.
+// Unlike require.Eventually, it can be called from goroutines other than the test's.
+func eventually(condition func() bool, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+
+	for !condition() {
+		if time.Now().After(deadline) {
+			return fmt.Errorf("condition not satisfied within %v", timeout)
 		}
-	})
+
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	return nil
 }
 
-func TestFailOnChange(t *testing.T) {
+// checkUnlocked checks that no other treefmt process holds the lock for root, or has its cache open.
+func checkUnlocked(root string) error {
+	unlock, err := cache.Lock(root, 0)
+	if err != nil {
+		return fmt.Errorf("failed to lock the tree root: %w", err)
+	}
+
+	db, err := cache.Open("", root)
+	if err != nil {
+		return errors.Join(fmt.Errorf("failed to open the cache: %w", err), unlock())
+	}
+
+	return errors.Join(db.Close(), unlock())
+}
+
+func TestLSP(t *testing.T) {
 	as := require.New(t)
 
-	t.Run("change size", func(t *testing.T) {
-		tempDir := test.TempExamples(t)
-		configPath := filepath.Join(tempDir, "treefmt.toml")
+	tempDir := test.TempExamples(t)
+	configPath := filepath.Join(tempDir, "treefmt.toml")
 
-		test.ChangeWorkDir(t, tempDir)
+	test.ChangeWorkDir(t, tempDir)
 
-		cfg := &config.Config{
-			FormatterConfigs: map[string]*config.Formatter{
-				"append": {
-					// test-fmt-append is a helper defined in nix/packages/treefmt/formatters.nix which lets us append
-					// an arbitrary value to a list of files
-					Command:  "test-fmt-append",
-					Options:  []string{"hello"},
-					Includes: []string{"elm/*"},
-				},
+	cfg := &config.Config{
+		FormatterConfigs: map[string]*config.Formatter{
+			"append": {
+				Command:  "test-fmt-append",
+				Options:  []string{"hello"},
+				Includes: []string{"*.elm"},
 			},
-		}
+		},
+	}
 
-		// running with a cold cache, we should see the elm files being formatted, resulting in changes, which should
-		// trigger an error
-		treefmt(t,
-			withArgs("--fail-on-change"),
-			withConfig(configPath, cfg),
-			withError(func(err error) {
-				as.ErrorIs(err, formatCmd.ErrFailOnChange)
-			}),
-			withStats(t, map[stats.Type]int{
-				stats.Traversed: 32,
-				stats.Matched:   2,
-				stats.Formatted: 2,
-				stats.Changed:   2,
-			}),
-		)
+	// capture current stdin and replace it on test cleanup
+	prevStdIn := os.Stdin
 
-		// running with a hot cache, we should see matches for the elm files, but no attempt to format them as the
-		// underlying files have not changed since we last ran
-		treefmt(t,
-			withArgs("--fail-on-change"),
-			withNoError(t),
-			withStats(t, map[stats.Type]int{
-				stats.Traversed: 32,
-				stats.Matched:   2,
-				stats.Formatted: 0,
-				stats.Changed:   0,
-			}),
-		)
+	t.Cleanup(func() {
+		os.Stdin = prevStdIn
 	})
 
-	t.Run("change modtime", func(t *testing.T) {
-		tempDir := test.TempExamples(t)
-		configPath := filepath.Join(tempDir, "treefmt.toml")
+	elmURI := "file://" + filepath.Join(tempDir, "elm/src/Main.elm")
+	jsonURI := "file://" + filepath.Join(tempDir, "elm/elm.json")
+
+	requests := []string{
+		`{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"capabilities":{}}}`,
+		`{"jsonrpc":"2.0","method":"initialized","params":{}}`,
+		`{"jsonrpc":"2.0","method":"textDocument/didOpen","params":{"textDocument":` +
+			`{"uri":"` + elmURI + `","languageId":"elm","version":1,"text":"module Main exposing (..)\n"}}}`,
+		`{"jsonrpc":"2.0","id":2,"method":"textDocument/formatting","params":{"textDocument":` +
+			`{"uri":"` + elmURI + `"},"options":{"tabSize":4,"insertSpaces":true}}}`,
+		`{"jsonrpc":"2.0","id":3,"method":"textDocument/rangeFormatting","params":{"textDocument":` +
+			`{"uri":"` + elmURI + `"},"range":{"start":{"line":0,"character":0},"end":{"line":0,"character":6}},` +
+			`"options":{"tabSize":4,"insertSpaces":true}}}`,
+		`{"jsonrpc":"2.0","id":4,"method":"textDocument/formatting","params":{"textDocument":` +
+			`{"uri":"` + jsonURI + `"},"options":{"tabSize":4,"insertSpaces":true}}}`,
+		`{"jsonrpc":"2.0","id":5,"method":"textDocument/hover","params":{}}`,
+		`{"jsonrpc":"2.0","id":6,"method":"shutdown"}`,
+		`{"jsonrpc":"2.0","method":"exit"}`,
+	}
 
-		test.ChangeWorkDir(t, tempDir)
+	var input strings.Builder
+	for _, req := range requests {
+		input.WriteString(fmt.Sprintf("Content-Length: %d\r\n\r\n%s", len(req), req))
+	}
 
-		dateFormat := "2006 01 02 15:04.05"
-		replacer := strings.NewReplacer(" ", "", ":", "")
+	contents := input.String()
+	os.Stdin = test.TempFile(t, "", "stdin", &contents)
 
-		formatTime := func(t time.Time) string {
-			// go date formats are stupid
-			return replacer.Replace(t.Format(dateFormat))
-		}
+	type response struct {
+		ID     int             `json:"id"`
+		Result json.RawMessage `json:"result"`
+		Error  *struct {
+			Code int `json:"code"`
+		} `json:"error"`
+	}
 
-		// running with a cold cache, we should see the haskell files being formatted, resulting in changes, which should
-		// trigger an error
-		treefmt(t,
-			withArgs("--fail-on-change"),
-			withConfigFunc(configPath, func() *config.Config {
-				// new mod time is in the next second
-				modTime := time.Now().Truncate(time.Second).Add(time.Second)
+	treefmt(t,
+		withArgs("lsp"),
+		withConfig(configPath, cfg),
+		withNoError(t),
+		withOutput(func(out []byte) {
+			var responses []response
 
-				return &config.Config{
-					FormatterConfigs: map[string]*config.Formatter{
-						"append": {
-							// test-fmt-modtime is a helper defined in nix/packages/treefmt/formatters.nix which lets us set
-							// a file's modtime to an arbitrary date.
-							// in this case, we move it forward more than a second so that our second level modtime comparison
-							// will detect it as a change.
-							Command:  "test-fmt-modtime",
-							Options:  []string{formatTime(modTime)},
-							Includes: []string{"haskell/*"},
-						},
-					},
+			reader := bufio.NewReader(bytes.NewReader(out))
+
+			for {
+				line, err := reader.ReadString('\n')
+				if err != nil {
+					break
 				}
-			}),
-			withError(func(err error) {
-				as.ErrorIs(err, formatCmd.ErrFailOnChange)
-			}),
-			withStats(t, map[stats.Type]int{
-				stats.Traversed: 32,
-				stats.Matched:   7,
-				stats.Formatted: 7,
-				stats.Changed:   7,
-			}),
-		)
 
-		// running with a hot cache, we should see matches for the haskell files, but no attempt to format them as the
-		// underlying files have not changed since we last ran
-		treefmt(t,
-			withArgs("--fail-on-change"),
-			withNoError(t),
-			withStats(t, map[stats.Type]int{
-				stats.Traversed: 32,
-				stats.Matched:   7,
-				stats.Formatted: 0,
-				stats.Changed:   0,
-			}),
-		)
-	})
+				var length int
+				if _, err = fmt.Sscanf(line, "Content-Length: %d", &length); err != nil {
+					continue
+				}
+
+				_, err = reader.ReadString('\n')
+				as.NoError(err)
+
+				content := make([]byte, length)
+				_, err = io.ReadFull(reader, content)
+				as.NoError(err)
+
+				var res response
+				as.NoError(json.Unmarshal(content, &res))
+
+				responses = append(responses, res)
+			}
+
+			as.Len(responses, 6)
+
+			// initialize
+			as.Equal(1, responses[0].ID)
+			as.Contains(string(responses[0].Result), `"documentFormattingProvider":true`)
+			as.Contains(string(responses[0].Result), `"documentRangeFormattingProvider":true`)
+
+			// formatting and range formatting replace the whole buffer, not the file on disk
+			edits := `[{"range":{"start":{"line":0,"character":0},"end":{"line":1,"character":0}},` +
+				`"newText":"module Main exposing (..)\nhello\n"}]`
+
+			as.Equal(2, responses[1].ID)
+			as.JSONEq(edits, string(responses[1].Result))
+			as.Equal(3, responses[2].ID)
+			as.JSONEq(edits, string(responses[2].Result))
+
+			// no formatter matches, so there are no edits
+			as.Equal(4, responses[3].ID)
+			as.JSONEq(`[]`, string(responses[3].Result))
+
+			// unsupported method
+			as.Equal(5, responses[4].ID)
+			as.NotNil(responses[4].Error)
+			as.Equal(-32601, responses[4].Error.Code)
+
+			// shutdown
+			as.Equal(6, responses[5].ID)
+			as.JSONEq(`null`, string(responses[5].Result))
+		}),
+	)
+
+	// the file on disk should be untouched
+	content, err := os.ReadFile(filepath.Join(tempDir, "elm/src/Main.elm"))
+	as.NoError(err)
+	as.NotContains(string(content), "hello")
 }
 
 func TestCacheBusting(t *testing.T) {
@@ -1504,6 +7617,7 @@ func TestPathsArg(t *testing.T) {
 	)
 
 	// specify an absolute path
+	// a single file is formatted without consulting the cache
 	absoluteInternalPath, err := filepath.Abs("elm/elm.json")
 	as.NoError(err)
 
@@ -1513,7 +7627,7 @@ func TestPathsArg(t *testing.T) {
 		withStats(t, map[stats.Type]int{
 			stats.Traversed: 1,
 			stats.Matched:   1,
-			stats.Formatted: 0,
+			stats.Formatted: 1,
 			stats.Changed:   0,
 		}),
 	)
@@ -1548,6 +7662,21 @@ func TestPathsArg(t *testing.T) {
 			as.Errorf(err, "path %s not found within the tree root", relativeExternalPath)
 		}),
 	)
+
+	// a directory named after a subcommand must follow --, otherwise the subcommand is run
+	as.NoError(os.Mkdir(filepath.Join(treeRoot, "list"), 0o755))
+	as.NoError(os.WriteFile(filepath.Join(treeRoot, "list", "names.txt"), []byte("names\n"), 0o600))
+
+	treefmt(t,
+		withArgs("--", "list"),
+		withNoError(t),
+		withStats(t, map[stats.Type]int{
+			stats.Traversed: 1,
+			stats.Matched:   1,
+			stats.Formatted: 1,
+			stats.Changed:   0,
+		}),
+	)
 }
 
 func TestStdin(t *testing.T) {
@@ -1642,6 +7771,209 @@ func TestStdin(t *testing.T) {
 	)
 }
 
+func TestStdinFormatters(t *testing.T) {
+	as := require.New(t)
+
+	tempDir := test.TempExamples(t)
+	configPath := filepath.Join(tempDir, "treefmt.toml")
+
+	test.ChangeWorkDir(t, tempDir)
+
+	// capture current stdin and replace it on test cleanup
+	prevStdIn := os.Stdin
+
+	t.Cleanup(func() {
+		os.Stdin = prevStdIn
+	})
+
+	cfg := &config.Config{
+		FormatterConfigs: map[string]*config.Formatter{
+			"append": {
+				Command:  "test-fmt-append",
+				Options:  []string{"   "},
+				Includes: []string{"*.md"},
+			},
+			"other": {
+				Command:  "test-fmt-append",
+				Options:  []string{"!!!"},
+				Includes: []string{"*.go"},
+			},
+		},
+	}
+
+	contents := "hello\n"
+
+	// without selecting formatters, the path is matched against the includes and excludes as usual
+	os.Stdin = test.TempFile(t, "", "stdin", &contents)
+
+	treefmt(t,
+		withArgs("--stdin", "notes.txt"),
+		withConfig(configPath, cfg),
+		withNoError(t),
+		withStats(t, map[stats.Type]int{
+			stats.Traversed: 1,
+			stats.Matched:   0,
+			stats.Formatted: 0,
+			stats.Changed:   0,
+		}),
+		withOutput(func(out []byte) {
+			as.True(strings.HasSuffix(string(out), "hello\n"))
+		}),
+	)
+
+	// only the selected formatters are applied, regardless of the path
+	os.Stdin = test.TempFile(t, "", "stdin", &contents)
+
+	treefmt(t,
+		withArgs("--stdin", "--formatters", "append", "notes.txt"),
+		withConfig(configPath, cfg),
+		withNoError(t),
+		withStats(t, map[stats.Type]int{
+			stats.Traversed: 1,
+			stats.Matched:   1,
+			stats.Formatted: 1,
+			stats.Changed:   1,
+		}),
+		withOutput(func(out []byte) {
+			as.True(strings.HasSuffix(string(out), "hello\n   \n"))
+		}),
+	)
+
+	// formatters selected with a glob must still match the path
+	os.Stdin = test.TempFile(t, "", "stdin", &contents)
+
+	treefmt(t,
+		withArgs("--stdin", "--formatters", "app*", "notes.go"),
+		withConfig(configPath, cfg),
+		withNoError(t),
+		withStats(t, map[stats.Type]int{
+			stats.Traversed: 1,
+			stats.Matched:   0,
+			stats.Formatted: 0,
+			stats.Changed:   0,
+		}),
+		withOutput(func(out []byte) {
+			as.True(strings.HasSuffix(string(out), "hello\n"))
+		}),
+	)
+
+	// as must those selected in the config file
+	os.Stdin = test.TempFile(t, "", "stdin", &contents)
+
+	cfg.Formatters = []string{"append", "other"}
+
+	treefmt(t,
+		withArgs("--stdin", "notes.go"),
+		withConfig(configPath, cfg),
+		withNoError(t),
+		withStats(t, map[stats.Type]int{
+			stats.Traversed: 1,
+			stats.Matched:   1,
+			stats.Formatted: 1,
+			stats.Changed:   1,
+		}),
+		withOutput(func(out []byte) {
+			as.True(strings.HasSuffix(string(out), "hello\n!!!\n"))
+		}),
+	)
+}
+
+func TestStdinFilelist(t *testing.T) {
+	as := require.New(t)
+
+	tempDir := test.TempExamples(t)
+	configPath := filepath.Join(tempDir, "treefmt.toml")
+
+	test.ChangeWorkDir(t, tempDir)
+
+	// capture current stdin and replace it on test cleanup
+	prevStdIn := os.Stdin
+
+	t.Cleanup(func() {
+		os.Stdin = prevStdIn
+	})
+
+	cfg := &config.Config{
+		FormatterConfigs: map[string]*config.Formatter{
+			"append": {
+				Command:  "test-fmt-append",
+				Options:  []string{"   "},
+				Includes: []string{"*"},
+			},
+		},
+	}
+
+	// NUL separated, including a path which no longer exists
+	contents := "elm/elm.json\x00go/main.go\x00deleted.go\x00"
+	os.Stdin = test.TempFile(t, "", "stdin", &contents)
+
+	treefmt(t,
+		withArgs("-0"),
+		withConfig(configPath, cfg),
+		withNoError(t),
+		withStats(t, map[stats.Type]int{
+			stats.Traversed: 2,
+			stats.Matched:   2,
+			stats.Formatted: 2,
+			stats.Changed:   2,
+		}),
+	)
+
+	// newline separated, combined with a path argument
+	contents = "rust/src/main.rs\nhaskell\n"
+	os.Stdin = test.TempFile(t, "", "stdin", &contents)
+
+	treefmt(t,
+		withArgs("--stdin-filelist", "python/main.py"),
+		withConfig(configPath, cfg),
+		withNoError(t),
+		withStats(t, map[stats.Type]int{
+			stats.Traversed: 9,
+			stats.Matched:   9,
+			stats.Formatted: 9,
+			stats.Changed:   9,
+		}),
+	)
+
+	// an empty list formats nothing, rather than the whole tree
+	contents = ""
+	os.Stdin = test.TempFile(t, "", "stdin", &contents)
+
+	treefmt(t,
+		withArgs("-0"),
+		withConfig(configPath, cfg),
+		withNoError(t),
+		withStats(t, map[stats.Type]int{
+			stats.Traversed: 0,
+			stats.Matched:   0,
+			stats.Formatted: 0,
+			stats.Changed:   0,
+		}),
+	)
+
+	// paths outside the tree root are rejected
+	contents = "../outside.go"
+	os.Stdin = test.TempFile(t, "", "stdin", &contents)
+	as.NoError(os.WriteFile(filepath.Join(tempDir, "../outside.go"), []byte("package main\n"), 0o600))
+
+	treefmt(t,
+		withArgs("-0"),
+		withConfig(configPath, cfg),
+		withError(func(err error) {
+			as.ErrorContains(err, "not inside the tree root")
+		}),
+	)
+
+	// cannot be combined with --stdin
+	treefmt(t,
+		withArgs("-0", "--stdin", "test.go"),
+		withConfig(configPath, cfg),
+		withError(func(err error) {
+			as.EqualError(err, "the --stdin-filelist flag cannot be used with the --stdin flag")
+		}),
+	)
+}
+
 func TestDeterministicOrderingInPipeline(t *testing.T) {
 	as := require.New(t)
 
@@ -1791,6 +8123,7 @@ func TestRunInSubdir(t *testing.T) {
 }
 
 type options struct {
+	ctx  context.Context
 	args []string
 	env  map[string]string
 
@@ -1818,6 +8151,14 @@ func withArgs(args ...string) option {
 	}
 }
 
+// withContext executes the command with ctx, allowing long-running commands such as the daemon to be stopped by
+// cancelling it.
+func withContext(ctx context.Context) option {
+	return func(o *options) {
+		o.ctx = ctx
+	}
+}
+
 func withEnv(env map[string]string) option {
 	return func(o *options) {
 		o.env = env
@@ -1883,7 +8224,7 @@ func treefmt(
 	t.Helper()
 
 	// build options
-	opts := &options{}
+	opts := &options{ctx: context.Background()}
 	for _, option := range opt {
 		option(opts)
 	}
@@ -1947,7 +8288,7 @@ func treefmt(
 	root.SetErr(tempOut)
 
 	// execute the command
-	cmdErr := root.Execute()
+	cmdErr := root.ExecuteContext(opts.ctx)
 
 	// reset and read the temporary output
 	if _, resetErr := tempOut.Seek(0, 0); resetErr != nil {
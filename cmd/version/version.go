@@ -0,0 +1,101 @@
+package version
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/numtide/treefmt/v2/build"
+	formatCmd "github.com/numtide/treefmt/v2/cmd/format"
+	"github.com/numtide/treefmt/v2/cmd/list"
+	"github.com/numtide/treefmt/v2/config"
+	"github.com/spf13/viper"
+)
+
+// Manifest describes the build of treefmt and, with --verbose, each of the configured formatters, so that the
+// environment a tree was formatted in can be reproduced.
+type Manifest struct {
+	build.Info
+	Formatters []*list.Entry `json:"formatters,omitempty"`
+}
+
+// Run prints the version of treefmt along with how it was built. With --verbose, each configured formatter is probed
+// for its version, which requires the config file to have been read into v.
+func Run(v *viper.Viper) error {
+	output, err := formatCmd.OutputString(v.GetString("output"))
+	if err != nil {
+		return fmt.Errorf("invalid output format: %w", err)
+	}
+
+	manifest := Manifest{Info: build.ReadInfo()}
+
+	if v.GetInt("verbose") > 0 {
+		cfg, err := config.FromViper(v)
+		if err != nil {
+			return fmt.Errorf("failed to load config: %w", err)
+		}
+
+		if manifest.Formatters, err = list.Entries(v, cfg); err != nil {
+			return err
+		}
+	}
+
+	if output == formatCmd.JSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+
+		if err = encoder.Encode(manifest); err != nil {
+			return fmt.Errorf("failed to encode version: %w", err)
+		}
+
+		return nil
+	}
+
+	printManifest(&manifest)
+
+	return nil
+}
+
+func printManifest(manifest *Manifest) {
+	fmt.Printf("%s %s\n", build.Name, manifest.Version)
+
+	if manifest.Commit != "" {
+		fmt.Printf("  commit:   %s\n", manifest.Commit)
+	}
+
+	if manifest.Date != "" {
+		fmt.Printf("  date:     %s\n", manifest.Date)
+	}
+
+	fmt.Printf("  go:       %s\n", manifest.GoVersion)
+	fmt.Printf("  platform: %s\n", manifest.Platform)
+
+	if len(manifest.Formatters) == 0 {
+		return
+	}
+
+	width := 0
+	for _, entry := range manifest.Formatters {
+		width = max(width, len(entry.Name))
+	}
+
+	fmt.Println("\nformatters:")
+
+	for _, entry := range manifest.Formatters {
+		version := entry.Version
+		if version == "" {
+			version = "unknown"
+		}
+
+		switch {
+		case entry.Path == "":
+			fmt.Printf("  %-*s  %s (not found)\n", width, entry.Name, entry.Command)
+		case entry.Runner != "":
+			fmt.Printf("  %-*s  %s via %s (%s)\n", width, entry.Name, entry.Command, entry.Runner, entry.Path)
+		case entry.Container != "":
+			fmt.Printf("  %-*s  %s in %s (%s)\n", width, entry.Name, entry.Command, entry.Container, entry.Path)
+		default:
+			fmt.Printf("  %-*s  %s (%s)\n", width, entry.Name, version, entry.Path)
+		}
+	}
+}
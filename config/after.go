@@ -0,0 +1,82 @@
+package config
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// checkAfter ensures formatters are only applied after others which exist, and that they do not depend on each other
+// in a cycle.
+func checkAfter(formatters map[string]*Formatter) error {
+	for _, name := range sortedNames(formatters) {
+		for _, other := range formatters[name].After {
+			if _, ok := formatters[other]; !ok {
+				return fmt.Errorf("formatter %v is after %v, which is not in config", name, other)
+			}
+		}
+	}
+
+	return checkCycles(formatters)
+}
+
+// checkCycles ensures the formatters do not depend on each other in a cycle, ignoring any which they are applied after
+// but which are not present, e.g. because they were not selected.
+func checkCycles(formatters map[string]*Formatter) error {
+	const (
+		visiting = iota + 1
+		visited
+	)
+
+	state := make(map[string]int, len(formatters))
+
+	var visit func(name string, path []string) error
+
+	visit = func(name string, path []string) error {
+		switch state[name] {
+		case visiting:
+			cycle := append(path[slices.Index(path, name):], name)
+
+			return fmt.Errorf("formatters have a cycle in their after dependencies: %s", strings.Join(cycle, " -> "))
+		case visited:
+			return nil
+		}
+
+		state[name] = visiting
+		path = append(path, name)
+
+		for _, other := range formatters[name].After {
+			if _, ok := formatters[other]; !ok {
+				continue
+			}
+
+			if err := visit(other, path); err != nil {
+				return err
+			}
+		}
+
+		state[name] = visited
+
+		return nil
+	}
+
+	for _, name := range sortedNames(formatters) {
+		if err := visit(name, nil); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// sortedNames returns the names of formatters in lexicographic order.
+func sortedNames(formatters map[string]*Formatter) []string {
+	names := make([]string, 0, len(formatters))
+	for name := range formatters {
+		names = append(names, name)
+	}
+
+	slices.Sort(names)
+
+	return names
+}
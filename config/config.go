@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 
 	"github.com/numtide/treefmt/v2/walk"
@@ -13,41 +14,207 @@ import (
 
 // Config is used to represent the list of configured Formatters.
 type Config struct {
-	AllowMissingFormatter bool     `mapstructure:"allow-missing-formatter" toml:"allow-missing-formatter,omitempty"`
-	CI                    bool     `mapstructure:"ci" toml:"-"`          // not allowed in config
-	ClearCache            bool     `mapstructure:"clear-cache" toml:"-"` // not allowed in config
-	CPUProfile            string   `mapstructure:"cpu-profile" toml:"cpu-profile,omitempty"`
-	Excludes              []string `mapstructure:"excludes" toml:"excludes,omitempty"`
-	FailOnChange          bool     `mapstructure:"fail-on-change" toml:"fail-on-change,omitempty"`
-	Formatters            []string `mapstructure:"formatters" toml:"formatters,omitempty"`
-	NoCache               bool     `mapstructure:"no-cache" toml:"-"` // not allowed in config
-	OnUnmatched           string   `mapstructure:"on-unmatched" toml:"on-unmatched,omitempty"`
-	TreeRoot              string   `mapstructure:"tree-root" toml:"tree-root,omitempty"`
-	TreeRootFile          string   `mapstructure:"tree-root-file" toml:"tree-root-file,omitempty"`
-	Verbose               uint8    `mapstructure:"verbose" toml:"verbose,omitempty"`
-	Walk                  string   `mapstructure:"walk" toml:"walk,omitempty"`
-	WorkingDirectory      string   `mapstructure:"working-dir" toml:"-"`
-	Stdin                 bool     `mapstructure:"stdin" toml:"-"` // not allowed in config
+	AllowMissingFormatter bool       `mapstructure:"allow-missing-formatter" toml:"allow-missing-formatter,omitempty"`
+	BatchSize             int        `mapstructure:"batch-size" toml:"batch-size,omitempty"`
+	BinPaths              []string   `mapstructure:"bin-paths" toml:"bin-paths,omitempty"`
+	BlockProfile          string     `mapstructure:"block-profile" toml:"block-profile,omitempty"`
+	CacheDir              string     `mapstructure:"cache-dir" toml:"-"`
+	CacheRemote           string     `mapstructure:"cache-remote" toml:"-"`
+	Check                 bool       `mapstructure:"check" toml:"-"`       // not allowed in config
+	CI                    bool       `mapstructure:"ci" toml:"-"`          // not allowed in config
+	ClearCache            bool       `mapstructure:"clear-cache" toml:"-"` // not allowed in config
+	Color                 string     `mapstructure:"color" toml:"color,omitempty"`
+	ConfigFile            string     `mapstructure:"-" toml:"-"` // the config file which was loaded
+	ContainerEngine       string     `mapstructure:"container-engine" toml:"container-engine,omitempty"`
+	CPUProfile            string     `mapstructure:"cpu-profile" toml:"cpu-profile,omitempty"`
+	DetectConflicts       bool       `mapstructure:"detect-conflicts" toml:"detect-conflicts,omitempty"`
+	Diff                  bool       `mapstructure:"diff" toml:"diff,omitempty"`
+	DisabledFormatters    []string   `mapstructure:"-" toml:"-"`            // formatters whose enabled-if does not hold
+	DryRun                bool       `mapstructure:"dry-run" toml:"-"`      // not allowed in config
+	ErrorFormat           string     `mapstructure:"error-format" toml:"-"` // not allowed in config
+	Excludes              []string   `mapstructure:"excludes" toml:"excludes,omitempty"`
+	FailOnChange          bool       `mapstructure:"fail-on-change" toml:"fail-on-change,omitempty"`
+	Formatters            []string   `mapstructure:"formatters" toml:"formatters,omitempty"`
+	From                  string     `mapstructure:"from" toml:"-"` // not allowed in config
+	Imports               []string   `mapstructure:"imports" toml:"imports,omitempty"`
+	Include               []string   `mapstructure:"include" toml:"-"`     // not allowed in config
+	Interactive           bool       `mapstructure:"interactive" toml:"-"` // not allowed in config
+	Jobs                  int        `mapstructure:"jobs" toml:"jobs,omitempty"`
+	KeepGoing             bool       `mapstructure:"keep-going" toml:"keep-going,omitempty"`
+	LineEndings           string     `mapstructure:"line-endings" toml:"line-endings,omitempty"`
+	LockWait              string     `mapstructure:"lock-wait" toml:"lock-wait,omitempty"`
+	LogFile               string     `mapstructure:"log-file" toml:"log-file,omitempty"`
+	LogFileMaxSize        string     `mapstructure:"log-file-max-size" toml:"log-file-max-size,omitempty"`
+	MaxChanges            int        `mapstructure:"max-changes" toml:"max-changes,omitempty"`
+	MaxFileSize           string     `mapstructure:"max-file-size" toml:"max-file-size,omitempty"`
+	MemProfile            string     `mapstructure:"mem-profile" toml:"mem-profile,omitempty"`
+	MetricsListen         string     `mapstructure:"metrics-listen" toml:"metrics-listen,omitempty"`
+	MutexProfile          string     `mapstructure:"mutex-profile" toml:"mutex-profile,omitempty"`
+	NestedConfigs         bool       `mapstructure:"nested-configs" toml:"nested-configs,omitempty"`
+	NoCache               bool       `mapstructure:"no-cache" toml:"-"` // not allowed in config
+	OnUnmatched           string     `mapstructure:"on-unmatched" toml:"on-unmatched,omitempty"`
+	OtelEndpoint          string     `mapstructure:"otel-endpoint" toml:"otel-endpoint,omitempty"`
+	Output                string     `mapstructure:"output" toml:"output,omitempty"`
+	PatchFile             string     `mapstructure:"patch-file" toml:"patch-file,omitempty"`
+	Profile               string     `mapstructure:"profile" toml:"profile,omitempty"`
+	Quiet                 bool       `mapstructure:"quiet" toml:"quiet,omitempty"`
+	Reports               []string   `mapstructure:"report" toml:"report,omitempty"`
+	Selection             *Selection `mapstructure:"-" toml:"-"`               // the formatters selected by Formatters
+	Since                 string     `mapstructure:"since" toml:"-"`           // not allowed in config
+	SkipFormatters        []string   `mapstructure:"skip-formatters" toml:"-"` // not allowed in config
+	SkipTags              []string   `mapstructure:"skip-tags" toml:"skip-tags,omitempty"`
+	Staged                bool       `mapstructure:"staged" toml:"-"` // not allowed in config
+	StatsFile             string     `mapstructure:"stats-file" toml:"stats-file,omitempty"`
+	TreeRoot              string     `mapstructure:"tree-root" toml:"tree-root,omitempty"`
+	TreeRootFile          string     `mapstructure:"tree-root-file" toml:"tree-root-file,omitempty"`
+	TUI                   bool       `mapstructure:"tui" toml:"-"` // not allowed in config
+	UnmatchedFile         string     `mapstructure:"unmatched-file" toml:"unmatched-file,omitempty"`
+	Verbose               uint8      `mapstructure:"verbose" toml:"verbose,omitempty"`
+	Walk                  string     `mapstructure:"walk" toml:"walk,omitempty"`
+	Watch                 bool       `mapstructure:"watch" toml:"-"` // not allowed in config
+	WorkingDirectory      string     `mapstructure:"working-dir" toml:"-"`
+	Stdin                 bool       `mapstructure:"stdin" toml:"-"`          // not allowed in config
+	StdinFilelist         bool       `mapstructure:"stdin-filelist" toml:"-"` // not allowed in config
+	StdinByName           bool       `mapstructure:"-" toml:"-"`              // stdin is formatted by --formatters
+	Summary               string     `mapstructure:"summary" toml:"summary,omitempty"`
+	Symlinks              string     `mapstructure:"symlinks" toml:"symlinks,omitempty"`
+	Tags                  []string   `mapstructure:"tags" toml:"tags,omitempty"`
+	Timeout               string     `mapstructure:"timeout" toml:"timeout,omitempty"`
+	To                    string     `mapstructure:"to" toml:"-"`  // not allowed in config
+	Yes                   bool       `mapstructure:"yes" toml:"-"` // not allowed in config
 
 	FormatterConfigs map[string]*Formatter `mapstructure:"formatter" toml:"formatter,omitempty"`
 
+	// Profiles are sets of options, keyed by name, which override those of the config file when selected with
+	// Profile.
+	Profiles map[string]map[string]any `mapstructure:"profiles" toml:"profiles,omitempty"`
+
+	// Templates are partial formatter definitions, keyed by name, which formatters can extend.
+	Templates map[string]*Formatter `mapstructure:"templates" toml:"templates,omitempty"`
+
 	Global struct {
 		// Deprecated: Use Excludes
 		Excludes []string `mapstructure:"excludes" toml:"excludes,omitempty"`
 	} `mapstructure:"global" toml:"global,omitempty"`
+
+	Cache struct {
+		// Dir is the directory in which the cache is stored, see CacheDir.
+		Dir string `mapstructure:"dir" toml:"dir,omitempty"`
+		// Remote is the URL of a cache which is shared between machines, see CacheRemote.
+		Remote string `mapstructure:"remote" toml:"remote,omitempty"`
+	} `mapstructure:"cache" toml:"cache,omitempty"`
+
+	Hooks struct {
+		// Pre is a shell script run in the tree root before any files are formatted.
+		Pre string `mapstructure:"pre" toml:"pre,omitempty"`
+		// Post is a shell script run in the tree root once formatting has finished, whether or not it succeeded.
+		Post string `mapstructure:"post" toml:"post,omitempty"`
+	} `mapstructure:"hooks" toml:"hooks,omitempty"`
 }
 
 type Formatter struct {
-	// Command is the command to invoke when applying this Formatter.
+	// Preset is the name of an entry in Presets, whose keys are used for any which are not set for this Formatter.
+	Preset string `mapstructure:"preset,omitempty" toml:"preset,omitempty"`
+	// Extends is the name of an entry in Templates, whose keys are used for any which are not set for this Formatter.
+	// Templates may themselves extend other templates.
+	Extends string `mapstructure:"extends,omitempty" toml:"extends,omitempty"`
+	// Command is the command to invoke when applying this Formatter. Alternatively, it may refer to a formatter which
+	// is built into treefmt, e.g. builtin:json, or be the path of a WASI module ending in .wasm, relative to the tree
+	// root, both of which are run in-process.
 	Command string `mapstructure:"command" toml:"command"`
-	// Options are an optional list of args to be passed to Command.
+	// Runner is the name of an entry in Runners, e.g. npx, used to invoke Command instead of finding it on the PATH.
+	Runner string `mapstructure:"runner,omitempty" toml:"runner,omitempty"`
+	// Container is an optional image, e.g. ghcr.io/org/prettier:3, within which Command is run using docker or podman
+	// instead of finding it on the PATH. The working directory is mounted at the same path within the container.
+	Container string `mapstructure:"container,omitempty" toml:"container,omitempty"`
+	// Options are an optional list of args to be passed to Command. Any reference to {filelist} is replaced with the
+	// path of a temporary file listing the files to format, which are then not passed as args.
 	Options []string `mapstructure:"options,omitempty" toml:"options,omitempty"`
 	// Includes is a list of glob patterns used to determine whether this Formatter should be applied against a path.
 	Includes []string `mapstructure:"includes,omitempty" toml:"includes,omitempty"`
 	// Excludes is an optional list of glob patterns used to exclude certain files from this Formatter.
 	Excludes []string `mapstructure:"excludes,omitempty" toml:"excludes,omitempty"`
+	// Interpreters is an optional list of interpreters, e.g. bash or python3, used to match extensionless files based
+	// on their shebang line.
+	Interpreters []string `mapstructure:"interpreters,omitempty" toml:"interpreters,omitempty"`
+	// MatchFirstLine is an optional regular expression used to match files based on their first line, in addition to
+	// Includes.
+	MatchFirstLine string `mapstructure:"match-first-line,omitempty" toml:"match-first-line,omitempty"`
+	// Detect determines how files are matched. If set to content, files are matched based on the type detected from
+	// their content, with Includes becoming optional and restricting which paths are inspected.
+	Detect string `mapstructure:"detect,omitempty" toml:"detect,omitempty"`
+	// Types is a list of content types, e.g. json or shell, to match when Detect is set to content.
+	Types []string `mapstructure:"types,omitempty" toml:"types,omitempty"`
+	// MaxFileSize is an optional size, e.g. 2MB, above which files will not be passed to this Formatter.
+	MaxFileSize string `mapstructure:"max-file-size,omitempty" toml:"max-file-size,omitempty"`
+	// Encoding determines how files encoded as UTF-16 or latin-1 are handled: ignore passes them to the Formatter as
+	// they are, skip does not apply the Formatter to them, and transcode converts them to UTF-8 whilst the Formatter is
+	// applied. Defaults to ignore.
+	Encoding string `mapstructure:"encoding,omitempty" toml:"encoding,omitempty"`
+	// Stdout indicates the Formatter writes its output to stdout instead of modifying files in place.
+	// When set, the Formatter is invoked once per file, with the file's content replaced by the captured output.
+	Stdout bool `mapstructure:"stdout,omitempty" toml:"stdout,omitempty"`
+	// Plugin indicates the Formatter is a long-lived process which speaks the plugin protocol over stdin and stdout,
+	// receiving batches of files in requests rather than being invoked once per batch.
+	Plugin bool `mapstructure:"plugin,omitempty" toml:"plugin,omitempty"`
+	// Mode determines how the Formatter is invoked: files passes it the paths of the files to format, whilst project
+	// invokes it once within the root of each project containing them, for tools such as cargo fmt which do not accept
+	// a list of files. Defaults to files.
+	Mode string `mapstructure:"mode,omitempty" toml:"mode,omitempty"`
+	// RootMarkers are the names of files, e.g. Cargo.toml, which mark the root of a project when Mode is project. The
+	// root of a file's project is the nearest directory containing one of them.
+	RootMarkers []string `mapstructure:"root-markers,omitempty" toml:"root-markers,omitempty"`
+	// Sandbox restricts the Formatter's processes, using bubblewrap or landlock where available, to writing the files
+	// they are applied to, with read-only access to the rest of the filesystem.
+	Sandbox bool `mapstructure:"sandbox,omitempty" toml:"sandbox,omitempty"`
+	// Output determines the level at which the Formatter's output is logged whilst it runs: debug, info or never.
+	// Defaults to debug. Regardless of this setting, the output of a failed Formatter is always reported.
+	Output string `mapstructure:"output,omitempty" toml:"output,omitempty"`
+	// BatchSize is an optional maximum number of files to pass to each invocation of the Formatter.
+	BatchSize int `mapstructure:"batch-size,omitempty" toml:"batch-size,omitempty"`
+	// Parallel is an optional number of processes across which each batch of files is split and formatted
+	// concurrently. Defaults to 1.
+	Parallel int `mapstructure:"parallel,omitempty" toml:"parallel,omitempty"`
+	// Exclusive indicates the Formatter's processes are run one at a time, and never alongside those of another
+	// exclusive Formatter, for tools which write to a shared lock or cache file. Other formatters still run alongside.
+	Exclusive bool `mapstructure:"exclusive,omitempty" toml:"exclusive,omitempty"`
+	// Timeout is an optional duration, e.g. 30s, after which the Formatter is killed. Overrides the global Timeout.
+	Timeout string `mapstructure:"timeout,omitempty" toml:"timeout,omitempty"`
+	// Nice is an optional niceness, from -20 to 19, with which the Formatter's processes are run. Higher values give
+	// them a lower priority, allowing heavyweight formatters to be deprioritized.
+	Nice int `mapstructure:"nice,omitempty" toml:"nice,omitempty"`
+	// CPULimit is an optional duration, e.g. 5m, of cpu time after which each of the Formatter's processes is killed.
+	CPULimit string `mapstructure:"cpu-limit,omitempty" toml:"cpu-limit,omitempty"`
+	// MemoryLimit is an optional size, e.g. 2GB, to which the memory of each of the Formatter's processes may grow,
+	// beyond which its allocations fail.
+	MemoryLimit string `mapstructure:"memory-limit,omitempty" toml:"memory-limit,omitempty"`
+	// VersionProbe is an optional list of args, separated by spaces, with which Command is invoked to print its
+	// version. The output is included in the cache signature, so files are formatted again when the Formatter is
+	// upgraded. Formatters are only probed when it is set, as one which treats its args as paths would write to them.
+	VersionProbe string `mapstructure:"version-probe,omitempty" toml:"version-probe,omitempty"`
+	// Version is an optional version of the Formatter, e.g. as pinned by a lock file, which is used in place of
+	// probing it with VersionProbe.
+	Version string `mapstructure:"version,omitempty" toml:"version,omitempty"`
+	// EnabledIf is an optional template, e.g. {{executable "prettier"}}, which must evaluate to true for this
+	// Formatter to be applied. It allows a single config file to serve environments in which not every Formatter is
+	// available or wanted.
+	EnabledIf string `mapstructure:"enabled-if,omitempty" toml:"enabled-if,omitempty"`
+	// Tags are optional labels, e.g. fast or js, used to select groups of formatters with --tags and --skip-tags.
+	Tags []string `mapstructure:"tags,omitempty" toml:"tags,omitempty"`
 	// Indicates the order of precedence when executing this Formatter in a sequence of Formatters.
 	Priority int `mapstructure:"priority,omitempty" toml:"priority,omitempty"`
+	// After is an optional list of Formatters, by name, which must be applied before this Formatter to any file they
+	// both match, regardless of Priority.
+	After []string `mapstructure:"after,omitempty" toml:"after,omitempty"`
+	// Stage is an optional name shared by Formatters which are applied concurrently to the same files, rather than one
+	// after another. Formatters in the same stage must have the same Priority.
+	Stage string `mapstructure:"stage,omitempty" toml:"stage,omitempty"`
+	// Pre is an optional shell script run in the tree root before the Formatter is first applied, and only if it is
+	// applied to at least one file.
+	Pre string `mapstructure:"pre,omitempty" toml:"pre,omitempty"`
+	// Post is an optional shell script run in the tree root once formatting has finished, if the Formatter was applied
+	// to at least one file.
+	Post string `mapstructure:"post,omitempty" toml:"post,omitempty"`
 }
 
 // SetFlags appends our flags to the provided flag set.
@@ -59,6 +226,36 @@ func SetFlags(fs *pflag.FlagSet) {
 		"allow-missing-formatter", false,
 		"Do not exit with error if a configured formatter is missing. (env $TREEFMT_ALLOW_MISSING_FORMATTER)",
 	)
+	fs.Int(
+		"batch-size", 0,
+		"The maximum number of files to process in each batch. Formatters are invoked once per batch, unless "+
+			"they specify a smaller batch-size of their own. Defaults to 1024. (env $TREEFMT_BATCH_SIZE)",
+	)
+	fs.StringSlice(
+		"bin-paths", nil,
+		"Directories, relative to the tree root, which are searched for formatter commands before the PATH, "+
+			"e.g. node_modules/.bin or .venv/bin. (env $TREEFMT_BIN_PATHS)",
+	)
+	fs.String(
+		"block-profile", "",
+		"The file into which a profile of where goroutines block waiting on synchronization primitives will be "+
+			"written. (env $TREEFMT_BLOCK_PROFILE)",
+	)
+	fs.String(
+		"cache-dir", "",
+		"The directory in which to store the cache, which is shared by every tree root. Defaults to "+
+			"$XDG_CACHE_HOME/treefmt/eval-cache. Overrides [cache] dir in the config file. (env $TREEFMT_CACHE_DIR)",
+	)
+	fs.String(
+		"cache-remote", "",
+		"The URL of a cache shared between machines, either http(s)://<host>/<path> or s3://<bucket>/<prefix>. "+
+			"Overrides [cache] remote in the config file. (env $TREEFMT_CACHE_REMOTE)",
+	)
+	fs.Bool(
+		"check", false,
+		"Check whether files are formatted without modifying them, by applying formatters to copies of the "+
+			"files within a temporary directory. Implies --fail-on-change. (env $TREEFMT_CHECK)",
+	)
 	fs.Bool(
 		"ci", false,
 		"Runs treefmt in a CI mode, enabling --no-cache, --fail-on-change and adjusting some other settings "+
@@ -68,13 +265,44 @@ func SetFlags(fs *pflag.FlagSet) {
 		"clear-cache", "c", false,
 		"Reset the evaluation cache. Use in case the cache is not precise enough. (env $TREEFMT_CLEAR_CACHE)",
 	)
+	fs.String(
+		"color", "auto",
+		"When to use color in log output and diffs. Possible values are <auto|always|never>, where auto uses color "+
+			"when writing to a terminal, honouring NO_COLOR and CLICOLOR_FORCE. (env $TREEFMT_COLOR)",
+	)
+	fs.String(
+		"container-engine", "",
+		"The engine with which to run formatters which specify a container. Possible values are <docker|podman>, "+
+			"defaulting to whichever is found first in the PATH, in that order. (env $TREEFMT_CONTAINER_ENGINE)",
+	)
 	fs.String(
 		"cpu-profile", "",
 		"The file into which a cpu profile will be written. (env $TREEFMT_CPU_PROFILE)",
 	)
+	fs.Bool(
+		"detect-conflicts", false,
+		"Hash the content of files between each of the formatters applied to them, warning about formatters which "+
+			"undo each other's changes. (env $TREEFMT_DETECT_CONFLICTS)",
+	)
+	fs.Bool(
+		"diff", false,
+		"Print a unified diff of each file which was changed. Only takes effect with --fail-on-change or "+
+			"--check. (env $TREEFMT_DIFF)",
+	)
+	fs.Bool(
+		"dry-run", false,
+		"List the files which would be formatted, along with the formatters which would be applied to them, "+
+			"without running any formatters. (env $TREEFMT_DRY_RUN)",
+	)
+	fs.String(
+		"error-format", "text",
+		"The format in which an error is printed to stderr when treefmt fails. Possible values are <text|json>, "+
+			"where json prints an object with the class of failure and its exit code. (env $TREEFMT_ERROR_FORMAT)",
+	)
 	fs.StringSlice(
 		"excludes", nil,
-		"Exclude files or directories matching the specified globs. (env $TREEFMT_EXCLUDES)",
+		"Exclude files or directories matching the specified globs, in addition to the excludes of the config "+
+			"file. (env $TREEFMT_EXCLUDES)",
 	)
 	fs.Bool(
 		"fail-on-change", false,
@@ -82,7 +310,83 @@ func SetFlags(fs *pflag.FlagSet) {
 	)
 	fs.StringSliceP(
 		"formatters", "f", nil,
-		"Specify formatters to apply. Defaults to all configured formatters. (env $TREEFMT_FORMATTERS)",
+		"Specify formatters to apply, by name or glob e.g. 'prettier-*'. Prefix with '!' to exclude formatters "+
+			"instead e.g. '!slow-linter'. Defaults to all configured formatters. (env $TREEFMT_FORMATTERS)",
+	)
+	fs.String(
+		"from", "",
+		"Only format files touched by the commits between the given git ref and --to, e.g. those a series of "+
+			"commits in a merge queue introduces. Requires the git walker. (env $TREEFMT_FROM)",
+	)
+	fs.StringSlice(
+		"include", nil,
+		"Only format files matching the specified globs e.g. 'src/**'. Unlike the paths given as arguments, they "+
+			"need not match any existing files. (env $TREEFMT_INCLUDE)",
+	)
+	fs.Bool(
+		"interactive", false,
+		"List the number of files each formatter would be applied to and prompt for confirmation before formatting "+
+			"any of them, allowing formatters to be deselected. (env $TREEFMT_INTERACTIVE)",
+	)
+	fs.IntP(
+		"jobs", "j", 0,
+		"The maximum number of formatter processes to run concurrently. Defaults to the number of CPUs. "+
+			"(env $TREEFMT_JOBS)",
+	)
+	fs.BoolP(
+		"keep-going", "k", false,
+		"Keep formatting after a formatter fails, printing a report of every failure once all formatters have "+
+			"completed. (env $TREEFMT_KEEP_GOING)",
+	)
+	fs.String(
+		"line-endings", "formatter",
+		"The line endings of each file once it has been formatted. Possible values are "+
+			"<formatter|preserve|lf|crlf>, where formatter leaves them to the formatters, and preserve restores "+
+			"those the file had beforehand, unless it had a mix of them. (env $TREEFMT_LINE_ENDINGS)",
+	)
+	fs.String(
+		"lock-wait", "",
+		"How long to wait for another treefmt process running against the same tree root to finish e.g. 30s or "+
+			"2m. Defaults to failing immediately. (env $TREEFMT_LOCK_WAIT)",
+	)
+	fs.String(
+		"log-file", "",
+		"Append log output to the given file, recording everything at debug level regardless of the verbosity "+
+			"of the console. (env $TREEFMT_LOG_FILE)",
+	)
+	fs.String(
+		"log-file-max-size", "",
+		"Rotate the log file once it would exceed the specified size e.g. 10MB, keeping the previous entries in a "+
+			"single backup with a .1 suffix. Defaults to no limit. (env $TREEFMT_LOG_FILE_MAX_SIZE)",
+	)
+	fs.Int(
+		"max-changes", 0,
+		"Abort before formatting anything if more than the specified number of files would be formatted, unless "+
+			"--yes is passed. Defaults to no limit. (env $TREEFMT_MAX_CHANGES)",
+	)
+	fs.String(
+		"max-file-size", "",
+		"Skip files larger than the specified size e.g. 2MB or 512KiB. Defaults to no limit. "+
+			"(env $TREEFMT_MAX_FILE_SIZE)",
+	)
+	fs.String(
+		"mem-profile", "",
+		"The file into which a heap profile will be written, recording both the memory in use and the allocations "+
+			"made. (env $TREEFMT_MEM_PROFILE)",
+	)
+	fs.String(
+		"metrics-listen", "",
+		"Expose Prometheus metrics over HTTP at /metrics on the given address e.g. :9090, when running with "+
+			"--watch or as a daemon. (env $TREEFMT_METRICS_LISTEN)",
+	)
+	fs.String(
+		"mutex-profile", "",
+		"The file into which a profile of contended mutexes will be written. (env $TREEFMT_MUTEX_PROFILE)",
+	)
+	fs.Bool(
+		"nested-configs", false,
+		"Apply config files found in subdirectories of the tree root to the files beneath them, overriding or "+
+			"extending the formatters and excludes of their parent directories. (env $TREEFMT_NESTED_CONFIGS)",
 	)
 	fs.Bool(
 		"no-cache", false,
@@ -91,12 +395,98 @@ func SetFlags(fs *pflag.FlagSet) {
 	fs.StringP(
 		"on-unmatched", "u", "warn",
 		"Log paths that did not match any formatters at the specified log level. Possible values are "+
-			"<debug|info|warn|error|fatal>. (env $TREEFMT_ON_UNMATCHED)",
+			"<debug|info|warn|error|fatal|fail-with-list>, where fail-with-list lists every unmatched path once "+
+			"formatting has completed, before exiting with an error. (env $TREEFMT_ON_UNMATCHED)",
+	)
+	fs.String(
+		"otel-endpoint", "",
+		"Export a trace of each run to an OpenTelemetry collector, using OTLP over HTTP e.g. "+
+			"http://localhost:4318. (env $TREEFMT_OTEL_ENDPOINT)",
+	)
+	fs.StringP(
+		"output", "o", "auto",
+		"The format used when printing a summary of the run to stdout. Possible values are "+
+			"<auto|text|json|github>. (env $TREEFMT_OUTPUT)",
+	)
+	fs.String(
+		"patch-file", "",
+		"Write a patch containing every change to the given file, which can be applied with git apply. Only "+
+			"takes effect with --fail-on-change or --check. (env $TREEFMT_PATCH_FILE)",
+	)
+	fs.String(
+		"profile", "",
+		"Apply the options of the given profile, defined in the profiles section of the config file, before those of "+
+			"any flags or env variables. (env $TREEFMT_PROFILE)",
+	)
+	fs.BoolP(
+		"quiet", "q", false,
+		"Only log errors, and do not print a summary once formatting has completed. Takes precedence over "+
+			"--verbose. (env $TREEFMT_QUIET)",
+	)
+	fs.StringSlice(
+		"report", nil,
+		"Write a report of the run to a file once formatting has completed, specified as <format>=<path>. "+
+			"Supported formats are <sarif|junit|codeclimate>. (env $TREEFMT_REPORT)",
+	)
+	fs.String(
+		"since", "",
+		"Only format files which have changed since the given git ref, including any uncommitted or untracked "+
+			"files. Requires the git walker. (env $TREEFMT_SINCE)",
+	)
+	fs.StringSlice(
+		"skip-formatters", nil,
+		"Specify formatters to skip, by name or glob, regardless of the config file and --formatters. "+
+			"(env $TREEFMT_SKIP_FORMATTERS)",
+	)
+	fs.StringSlice(
+		"skip-tags", nil,
+		"Skip formatters with any of the specified tags e.g. slow. (env $TREEFMT_SKIP_TAGS)",
+	)
+	fs.Bool(
+		"staged", false,
+		"Only format the files staged in the git index, adding them to the index again once formatted. Partially "+
+			"staged files are formatted without their unstaged changes, which are left in the worktree. Intended for "+
+			"pre-commit hooks. (env $TREEFMT_STAGED)",
+	)
+	fs.String(
+		"stats-file", "",
+		"Write the statistics of the run to the given file as JSON once formatting has completed, regardless of "+
+			"the output format. (env $TREEFMT_STATS_FILE)",
 	)
 	fs.Bool(
 		"stdin", false,
 		"Format the context passed in via stdin.",
 	)
+	fs.BoolP(
+		"stdin-filelist", "0", false,
+		"Read a list of paths to format from stdin, separated by NUL characters or newlines e.g. "+
+			"git diff -z --name-only | treefmt -0.",
+	)
+	fs.String(
+		"summary", "basic",
+		"The level of detail in the summary printed once formatting has completed. Possible values are "+
+			"<basic|detailed>, where detailed includes the files, batches and time taken by each formatter. "+
+			"(env $TREEFMT_SUMMARY)",
+	)
+	fs.String(
+		"symlinks", "skip",
+		"How symlinks encountered whilst traversing the tree root are handled. Possible values are "+
+			"<skip|follow|error>, where follow traverses their targets as if they were located at the symlink, "+
+			"skipping any which would form a cycle. (env $TREEFMT_SYMLINKS)",
+	)
+	fs.StringSlice(
+		"tags", nil,
+		"Only apply formatters with at least one of the specified tags e.g. fast. (env $TREEFMT_TAGS)",
+	)
+	fs.String(
+		"timeout", "",
+		"Kill any formatter which runs for longer than the specified duration e.g. 30s or 2m. Defaults to no "+
+			"timeout. (env $TREEFMT_TIMEOUT)",
+	)
+	fs.String(
+		"to", "",
+		"The git ref at which the range of commits selected with --from ends. Defaults to HEAD. (env $TREEFMT_TO)",
+	)
 	fs.String(
 		"tree-root", "",
 		"The root directory from which treefmt will start walking the filesystem (defaults to the directory "+
@@ -106,6 +496,17 @@ func SetFlags(fs *pflag.FlagSet) {
 		"tree-root-file", "",
 		"File to search for to find the tree root (if --tree-root is not passed). (env $TREEFMT_TREE_ROOT_FILE)",
 	)
+	fs.Bool(
+		"tui", false,
+		"Display a dashboard with the progress of each formatter and a pane of log output whilst formatting, "+
+			"followed by a summary in which the result for each file can be browsed. Requires a terminal. "+
+			"(env $TREEFMT_TUI)",
+	)
+	fs.String(
+		"unmatched-file", "",
+		"Write the paths that did not match any formatters to the given file, one per line, instead of listing "+
+			"them. Only takes effect with --on-unmatched fail-with-list. (env $TREEFMT_UNMATCHED_FILE)",
+	)
 	fs.CountP(
 		"verbose", "v",
 		"Set the verbosity of logs e.g. -vv. (env $TREEFMT_VERBOSE)",
@@ -113,47 +514,111 @@ func SetFlags(fs *pflag.FlagSet) {
 	fs.String(
 		"walk", "auto",
 		"The method used to traverse the files within the tree root. Currently supports "+
-			"<auto|git|filesystem>. (env $TREEFMT_WALK)",
+			"<auto|git|jujutsu|gitignore|filesystem>. (env $TREEFMT_WALK)",
+	)
+	fs.Bool(
+		"watch", false,
+		"Keep running after the initial format, watching the tree root for changes and formatting any files "+
+			"which are created or modified. (env $TREEFMT_WATCH)",
 	)
 	fs.StringP(
 		"working-dir", "C", ".",
 		"Run as if treefmt was started in the specified working directory instead of the current working "+
 			"directory. (env $TREEFMT_WORKING_DIR)",
 	)
+	fs.BoolP(
+		"yes", "y", false,
+		"Format the files even if there are more of them than --max-changes allows, without prompting for "+
+			"confirmation with --interactive. (env $TREEFMT_YES)",
+	)
+}
+
+// cliExcludesKey is the key to which --excludes and $TREEFMT_EXCLUDES are bound. Keeping them apart from the excludes
+// of the config file allows FromViper to merge the two for a single run, rather than one replacing the other.
+const cliExcludesKey = "cli-excludes"
+
+// envKeyReplacer maps the name of a key's env variable, replacing `-` and `.` with `_` e.g. `global.excludes` =>
+// `TREEFMT_GLOBAL_EXCLUDES`. The exception is $TREEFMT_EXCLUDES, which is mapped to cliExcludesKey instead of the
+// excludes of the config file.
+type envKeyReplacer struct{}
+
+func (envKeyReplacer) Replace(name string) string {
+	switch name = strings.NewReplacer("-", "_", ".", "_").Replace(name); name {
+	case "TREEFMT_EXCLUDES":
+		// an empty name is never set, so the excludes of the config file cannot be overridden by the environment
+		return ""
+	case "TREEFMT_CLI_EXCLUDES":
+		return "TREEFMT_EXCLUDES"
+	default:
+		return name
+	}
 }
 
 // NewViper creates a Viper instance pre-configured with the following options:
-// * TOML config type
+// * TOML config type, which is overridden by FileType when reading a config file
 // * automatic env enabled
 // * `TREEFMT_` env prefix for environment variables
 // * replacement of `-` and `.` with `_` when mapping flags to env e.g. `global.excludes` => `TREEFMT_GLOBAL_EXCLUDES`.
 func NewViper() (*viper.Viper, error) {
-	v := viper.New()
+	v := viper.NewWithOptions(viper.EnvKeyReplacer(envKeyReplacer{}))
 
-	// Enforce toml (may open this up to other formats in the future)
+	// default to toml when reading config without a path, e.g. from a reader
 	v.SetConfigType("toml")
 
 	// Allow env overrides for config and flags.
 	v.SetEnvPrefix("treefmt")
 	v.AutomaticEnv()
-	v.SetEnvKeyReplacer(strings.NewReplacer("-", "_", ".", "_"))
 
 	// unset some env variables that we don't want automatically applied
-	if err := os.Unsetenv("TREEFMT_STDIN"); err != nil {
-		return nil, fmt.Errorf("failed to unset TREEFMT_STDIN: %w", err)
+	for _, name := range []string{"TREEFMT_STDIN", "TREEFMT_STDIN_FILELIST"} {
+		if err := os.Unsetenv(name); err != nil {
+			return nil, fmt.Errorf("failed to unset %s: %w", name, err)
+		}
 	}
 
 	return v, nil
 }
 
+// BindFlags binds the flags created by SetFlags to v, allowing them to override the corresponding entries in the
+// config file. The exception is --excludes, which is merged with the excludes of the config file by FromViper.
+func BindFlags(v *viper.Viper, fs *pflag.FlagSet) error {
+	var err error
+
+	fs.VisitAll(func(flag *pflag.Flag) {
+		key := flag.Name
+		if key == "excludes" {
+			key = cliExcludesKey
+		}
+
+		if err == nil {
+			err = v.BindPFlag(key, flag)
+		}
+	})
+
+	return err
+}
+
 // FromViper takes a viper instance and produces a Config instance.
 func FromViper(v *viper.Viper) (*Config, error) {
 	configReset := map[string]any{
-		"ci":          false,
-		"clear-cache": false,
-		"no-cache":    false,
-		"stdin":       false,
-		"working-dir": ".",
+		"cache-dir":       "",
+		"cache-remote":    "",
+		"check":           false,
+		"ci":              false,
+		"clear-cache":     false,
+		"dry-run":         false,
+		"error-format":    "text",
+		"include":         []string{},
+		"interactive":     false,
+		"no-cache":        false,
+		"since":           "",
+		"skip-formatters": []string{},
+		"stdin":           false,
+		"stdin-filelist":  false,
+		"tui":             false,
+		"watch":           false,
+		"working-dir":     ".",
+		"yes":             false,
 	}
 
 	// reset certain values which are not allowed to be specified in the config file
@@ -166,6 +631,20 @@ func FromViper(v *viper.Viper) (*Config, error) {
 
 	cfg := &Config{}
 
+	// apply the selected profile after the reset, as it can set options such as no-cache which are otherwise not
+	// allowed in the config file
+	if err = applyProfile(v); err != nil {
+		return nil, err
+	}
+
+	// expand formatter templates and presets before decoding, so the keys of each formatter take precedence over
+	// its template, and those of the template over its preset
+	if err = applyTemplates(v); err != nil {
+		return nil, err
+	} else if err = applyPresets(v); err != nil {
+		return nil, err
+	}
+
 	if err = v.Unmarshal(cfg); err != nil {
 		return nil, fmt.Errorf("failed to unmarshal config: %w", err)
 	}
@@ -201,27 +680,86 @@ func FromViper(v *viper.Viper) (*Config, error) {
 		return nil, fmt.Errorf("failed to get absolute path for tree root: %w", err)
 	}
 
+	// record the config file which was loaded, if any
+	if configFile := v.ConfigFileUsed(); configFile != "" {
+		if cfg.ConfigFile, err = filepath.Abs(configFile); err != nil {
+			return nil, fmt.Errorf("failed to get absolute path for config file: %w", err)
+		}
+	}
+
+	// values set by an imported config file are interpolated relative to it
+	configDirs := v.GetStringMapString(configDirsKey)
+
 	// prefer top level excludes, falling back to global.excludes for backwards compatibility
 	if len(cfg.Excludes) == 0 {
 		cfg.Excludes = cfg.Global.Excludes
+
+		if dir, ok := configDirs["global.excludes"]; ok {
+			configDirs["excludes"] = dir
+		}
 	}
 
-	// filter formatters based on provided names
-	if len(cfg.Formatters) > 0 {
-		filtered := make(map[string]*Formatter)
+	// add any excludes given with --excludes or $TREEFMT_EXCLUDES for this run
+	var cliExcludes []string
+	if err = v.UnmarshalKey(cliExcludesKey, &cliExcludes); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal excludes: %w", err)
+	}
 
-		// check if the provided names exist in the config
-		for _, name := range cfg.Formatters {
-			formatterCfg, ok := cfg.FormatterConfigs[name]
-			if !ok {
-				return nil, fmt.Errorf("formatter %v not found in config", name)
-			}
+	cfg.Excludes = append(cfg.Excludes, cliExcludes...)
 
-			filtered[name] = formatterCfg
-		}
+	// expand any templates, e.g. {{.TreeRoot}}, in the global excludes and formatters
+	data := newTemplateData(cfg.TreeRoot, cfg.TreeRoot, cfg.Profile)
+	data.binPaths = cfg.BinPaths
+	data.configDirs = configDirs
+
+	if cfg.ConfigFile != "" {
+		data.ConfigDir = filepath.Dir(cfg.ConfigFile)
+	}
+
+	if err = interpolate(cfg.Excludes, cfg.FormatterConfigs, data); err != nil {
+		return nil, err
+	}
+
+	// evaluate the enabled-if condition of every formatter, including those not selected with --formatters
+	if cfg.DisabledFormatters, err = disabledFormatters(cfg.FormatterConfigs, data); err != nil {
+		return nil, err
+	}
+
+	// check the order in which formatters are applied can be determined, including those which are not selected
+	if err = checkAfter(cfg.FormatterConfigs); err != nil {
+		return nil, err
+	}
+
+	// filter formatters based on the provided names, globs, negations and tags, with any which are skipped being
+	// negated
+	patterns := slices.Clone(cfg.Formatters)
+	for _, name := range cfg.SkipFormatters {
+		patterns = append(patterns, "!"+name)
+	}
+
+	if cfg.Selection, err = NewSelection(patterns, cfg.Tags, cfg.SkipTags); err != nil {
+		return nil, err
+	} else if cfg.FormatterConfigs, err = cfg.Selection.filter(cfg.FormatterConfigs); err != nil {
+		return nil, err
+	}
+
+	// drop formatters which are disabled in this environment
+	for _, name := range cfg.DisabledFormatters {
+		delete(cfg.FormatterConfigs, name)
+	}
 
-		// updated formatters
-		cfg.FormatterConfigs = filtered
+	// prefer the cache flags, falling back to the [cache] section of the config file
+	if cfg.CacheDir == "" {
+		cfg.CacheDir = cfg.Cache.Dir
+	}
+
+	if cfg.CacheRemote == "" {
+		cfg.CacheRemote = cfg.Cache.Remote
+	}
+
+	// check mode reports files which are not formatted as failures
+	if cfg.Check {
+		cfg.FailOnChange = true
 	}
 
 	// ci mode
@@ -238,6 +776,52 @@ func FromViper(v *viper.Viper) (*Config, error) {
 	return cfg, nil
 }
 
+// filenames are the names of the config files we search for, in order of preference.
+// YAML and JSON are parsed by viper according to the extension, and share the same schema as TOML.
+var filenames = []string{
+	"treefmt.toml", ".treefmt.toml",
+	"treefmt.yaml", ".treefmt.yaml",
+	"treefmt.yml", ".treefmt.yml",
+	"treefmt.json", ".treefmt.json",
+}
+
+// Locate returns the path to the config file.
+// If configFile is empty, $TREEFMT_CONFIG is used, before searching $PRJ_ROOT and then upwards from workingDir for
+// treefmt.toml, treefmt.yaml, treefmt.yml or treefmt.json, any of which may be prefixed with a '.'.
+func Locate(configFile string, workingDir string) (string, error) {
+	// fallback to env
+	if configFile == "" {
+		configFile = os.Getenv("TREEFMT_CONFIG")
+	}
+
+	// look in PRJ_ROOT if set
+	if prjRoot := os.Getenv("PRJ_ROOT"); configFile == "" && prjRoot != "" {
+		configFile, _ = Find(prjRoot, filenames...)
+	}
+
+	// search up from the working directory
+	if configFile == "" {
+		var err error
+		if configFile, _, err = FindUp(workingDir, filenames...); err != nil {
+			return "", err
+		}
+	}
+
+	return configFile, nil
+}
+
+// FileType returns the format of the config file at path, based on its extension, defaulting to toml.
+func FileType(path string) string {
+	switch filepath.Ext(path) {
+	case ".yaml", ".yml":
+		return "yaml"
+	case ".json":
+		return "json"
+	default:
+		return "toml"
+	}
+}
+
 func Find(searchDir string, fileNames ...string) (path string, err error) {
 	for _, f := range fileNames {
 		path := filepath.Join(searchDir, f)
@@ -6,6 +6,8 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"runtime"
+	"strings"
 	"testing"
 
 	"github.com/BurntSushi/toml"
@@ -29,7 +31,7 @@ func newViper(t *testing.T) (*viper.Viper, *pflag.FlagSet) {
 	flags := pflag.NewFlagSet("test", pflag.ContinueOnError)
 	config.SetFlags(flags)
 
-	if err := v.BindPFlags(flags); err != nil {
+	if err := config.BindFlags(v, flags); err != nil {
 		t.Fatal(err)
 	}
 
@@ -86,6 +88,179 @@ func TestAllowMissingFormatter(t *testing.T) {
 	checkValue(true)
 }
 
+func TestBatchSize(t *testing.T) {
+	as := require.New(t)
+
+	cfg := &config.Config{}
+	v, flags := newViper(t)
+
+	checkValue := func(expected int) {
+		readValue(t, v, cfg, func(cfg *config.Config) {
+			as.Equal(expected, cfg.BatchSize)
+		})
+	}
+
+	// default with no flag, env or config
+	checkValue(0)
+
+	// set config value
+	cfg.BatchSize = 256
+
+	checkValue(256)
+
+	// env override
+	t.Setenv("TREEFMT_BATCH_SIZE", "128")
+	checkValue(128)
+
+	// flag override
+	as.NoError(flags.Set("batch-size", "64"))
+	checkValue(64)
+}
+
+func TestBinPaths(t *testing.T) {
+	as := require.New(t)
+
+	cfg := &config.Config{}
+	v, flags := newViper(t)
+
+	checkValue := func(expected []string) {
+		readValue(t, v, cfg, func(cfg *config.Config) {
+			as.Equal(expected, cfg.BinPaths)
+		})
+	}
+
+	// default with no flag, env or config
+	checkValue([]string{})
+
+	// set config value
+	cfg.BinPaths = []string{"node_modules/.bin", ".venv/bin"}
+	checkValue([]string{"node_modules/.bin", ".venv/bin"})
+
+	// env override
+	t.Setenv("TREEFMT_BIN_PATHS", "tools/bin")
+	checkValue([]string{"tools/bin"})
+
+	// flag override
+	as.NoError(flags.Set("bin-paths", "bin,scripts"))
+	checkValue([]string{"bin", "scripts"})
+}
+
+func TestBlockProfile(t *testing.T) {
+	as := require.New(t)
+
+	cfg := &config.Config{}
+	v, flags := newViper(t)
+
+	checkValue := func(expected string) {
+		readValue(t, v, cfg, func(cfg *config.Config) {
+			as.Equal(expected, cfg.BlockProfile)
+		})
+	}
+
+	// default with no flag, env or config
+	checkValue("")
+
+	// set config value
+	cfg.BlockProfile = "/foo/bar"
+
+	checkValue("/foo/bar")
+
+	// env override
+	t.Setenv("TREEFMT_BLOCK_PROFILE", "/fizz/buzz")
+	checkValue("/fizz/buzz")
+
+	// flag override
+	as.NoError(flags.Set("block-profile", "/bla/bla"))
+	checkValue("/bla/bla")
+}
+
+func TestCacheDir(t *testing.T) {
+	as := require.New(t)
+
+	cfg := &config.Config{}
+	v, flags := newViper(t)
+
+	checkValue := func(expected string) {
+		readValue(t, v, cfg, func(cfg *config.Config) {
+			as.Equal(expected, cfg.CacheDir)
+		})
+	}
+
+	// default with no flag, env or config
+	checkValue("")
+
+	// set config value
+	cfg.Cache.Dir = "/tmp/foo"
+	checkValue("/tmp/foo")
+
+	// env override
+	t.Setenv("TREEFMT_CACHE_DIR", "/tmp/bar")
+	checkValue("/tmp/bar")
+
+	// flag override
+	as.NoError(flags.Set("cache-dir", "/tmp/baz"))
+	checkValue("/tmp/baz")
+}
+
+func TestCacheRemote(t *testing.T) {
+	as := require.New(t)
+
+	cfg := &config.Config{}
+	v, flags := newViper(t)
+
+	checkValue := func(expected string) {
+		readValue(t, v, cfg, func(cfg *config.Config) {
+			as.Equal(expected, cfg.CacheRemote)
+		})
+	}
+
+	// default with no flag, env or config
+	checkValue("")
+
+	// set config value
+	cfg.Cache.Remote = "https://cache.example.com/treefmt"
+	checkValue("https://cache.example.com/treefmt")
+
+	// env override
+	t.Setenv("TREEFMT_CACHE_REMOTE", "s3://bucket/treefmt")
+	checkValue("s3://bucket/treefmt")
+
+	// flag override
+	as.NoError(flags.Set("cache-remote", "http://localhost:8080"))
+	checkValue("http://localhost:8080")
+}
+
+func TestCheck(t *testing.T) {
+	as := require.New(t)
+
+	cfg := &config.Config{}
+	v, flags := newViper(t)
+
+	checkValues := func(check bool, failOnChange bool) {
+		readValue(t, v, cfg, func(cfg *config.Config) {
+			as.Equal(check, cfg.Check)
+			as.Equal(failOnChange, cfg.FailOnChange)
+		})
+	}
+
+	// default with no flag, env or config
+	checkValues(false, false)
+
+	// set config value and check that it has no effect
+	// you are not allowed to set check in config
+	cfg.Check = true
+
+	checkValues(false, false)
+
+	// env override
+	t.Setenv("TREEFMT_CHECK", "false")
+	checkValues(false, false)
+
+	// flag override
+	as.NoError(flags.Set("check", "true"))
+	checkValues(true, true)
+}
+
 func TestCI(t *testing.T) {
 	as := require.New(t)
 
@@ -154,6 +329,63 @@ func TestClearCache(t *testing.T) {
 	checkValue(true)
 }
 
+func TestColor(t *testing.T) {
+	as := require.New(t)
+
+	cfg := &config.Config{}
+	v, flags := newViper(t)
+
+	checkValue := func(expected string) {
+		readValue(t, v, cfg, func(cfg *config.Config) {
+			as.Equal(expected, cfg.Color)
+		})
+	}
+
+	// default with no flag, env or config
+	checkValue("auto")
+
+	// set config value
+	cfg.Color = "never"
+	checkValue("never")
+
+	// env override
+	t.Setenv("TREEFMT_COLOR", "always")
+	checkValue("always")
+
+	// flag override
+	as.NoError(flags.Set("color", "auto"))
+	checkValue("auto")
+}
+
+func TestContainerEngine(t *testing.T) {
+	as := require.New(t)
+
+	cfg := &config.Config{}
+	v, flags := newViper(t)
+
+	checkValue := func(expected string) {
+		readValue(t, v, cfg, func(cfg *config.Config) {
+			as.Equal(expected, cfg.ContainerEngine)
+		})
+	}
+
+	// default with no flag, env or config
+	checkValue("")
+
+	// set config value
+	cfg.ContainerEngine = "docker"
+
+	checkValue("docker")
+
+	// env override
+	t.Setenv("TREEFMT_CONTAINER_ENGINE", "podman")
+	checkValue("podman")
+
+	// flag override
+	as.NoError(flags.Set("container-engine", "docker"))
+	checkValue("docker")
+}
+
 func TestCpuProfile(t *testing.T) {
 	as := require.New(t)
 
@@ -183,6 +415,94 @@ func TestCpuProfile(t *testing.T) {
 	checkValue("/bla/bla")
 }
 
+func TestDiff(t *testing.T) {
+	as := require.New(t)
+
+	cfg := &config.Config{}
+	v, flags := newViper(t)
+
+	checkValue := func(expected bool) {
+		readValue(t, v, cfg, func(cfg *config.Config) {
+			as.Equal(expected, cfg.Diff)
+		})
+	}
+
+	// default with no flag, env or config
+	checkValue(false)
+
+	// set config value
+	cfg.Diff = true
+	checkValue(true)
+
+	// env override
+	t.Setenv("TREEFMT_DIFF", "false")
+	checkValue(false)
+
+	// flag override
+	as.NoError(flags.Set("diff", "true"))
+	checkValue(true)
+}
+
+func TestDryRun(t *testing.T) {
+	as := require.New(t)
+
+	cfg := &config.Config{}
+	v, flags := newViper(t)
+
+	checkValue := func(expected bool) {
+		readValue(t, v, cfg, func(cfg *config.Config) {
+			as.Equal(expected, cfg.DryRun)
+		})
+	}
+
+	// default with no flag, env or config
+	checkValue(false)
+
+	// set config value and check that it has no effect
+	// you are not allowed to set dry-run in config
+	cfg.DryRun = true
+
+	checkValue(false)
+
+	// env override
+	t.Setenv("TREEFMT_DRY_RUN", "false")
+	checkValue(false)
+
+	// flag override
+	as.NoError(flags.Set("dry-run", "true"))
+	checkValue(true)
+}
+
+func TestErrorFormat(t *testing.T) {
+	as := require.New(t)
+
+	cfg := &config.Config{}
+	v, flags := newViper(t)
+
+	checkValue := func(expected string) {
+		readValue(t, v, cfg, func(cfg *config.Config) {
+			as.Equal(expected, cfg.ErrorFormat)
+		})
+	}
+
+	// default with no flag, env or config
+	checkValue("text")
+
+	// set config value and check that it has no effect
+	// you are not allowed to set error-format in config
+	cfg.ErrorFormat = "json"
+
+	checkValue("text")
+
+	// env override
+	t.Setenv("TREEFMT_ERROR_FORMAT", "json")
+	checkValue("json")
+
+	// flag override
+	as.NoError(flags.Set("error-format", "text"))
+	checkValue("text")
+}
+
 func TestExcludes(t *testing.T) {
 	as := require.New(t)
 
@@ -209,12 +529,15 @@ func TestExcludes(t *testing.T) {
 
 	checkValue([]string{"fizz", "buzz"})
 
-	// env override
+	// env is merged with config
 	t.Setenv("TREEFMT_EXCLUDES", "foo,bar")
-	checkValue([]string{"foo", "bar"})
+	checkValue([]string{"fizz", "buzz", "foo", "bar"})
 
-	// flag override
+	// flag takes precedence over env, and is also merged with config
 	as.NoError(flags.Set("excludes", "bleep,bloop"))
+	checkValue([]string{"fizz", "buzz", "bleep", "bloop"})
+
+	cfg.Global.Excludes = nil
 	checkValue([]string{"bleep", "bloop"})
 }
 
@@ -291,17 +614,83 @@ func TestFormatters(t *testing.T) {
 
 	_, err := config.FromViper(v)
 	as.ErrorContains(err, "formatter foo not found in config")
+
+	// Set appends to the flag's values after the first call
+	setFormatters := func(values ...string) {
+		as.NoError(flags.Lookup("formatters").Value.(pflag.SliceValue).Replace(values))
+	}
+
+	checkSelected := func(expected ...string) {
+		readValue(t, v, cfg, func(cfg *config.Config) {
+			names := make([]string, 0, len(cfg.FormatterConfigs))
+			for name := range cfg.FormatterConfigs {
+				names = append(names, name)
+			}
+
+			as.ElementsMatch(expected, names)
+		})
+	}
+
+	// globs
+	setFormatters("[et]*")
+	checkSelected("echo", "touch")
+
+	// negations
+	setFormatters("!echo")
+	checkSelected("date", "touch")
+
+	// globs combined with negations
+	setFormatters("*", "!touch")
+	checkSelected("date", "echo")
+
+	// patterns which match no formatters
+	for _, value := range []string{"foo*", "!foo"} {
+		setFormatters(value)
+
+		_, err = config.FromViper(v)
+		as.ErrorContains(err, "not found in config")
+	}
+
+	// a pattern which cannot be compiled
+	setFormatters("[echo")
+
+	_, err = config.FromViper(v)
+	as.ErrorContains(err, "failed to compile formatters pattern '[echo'")
 }
 
-func TestNoCache(t *testing.T) {
+func TestInclude(t *testing.T) {
 	as := require.New(t)
 
 	cfg := &config.Config{}
 	v, flags := newViper(t)
 
-	checkValue := func(expected bool) {
+	checkValue := func(expected []string) {
 		readValue(t, v, cfg, func(cfg *config.Config) {
-			as.Equal(expected, cfg.NoCache)
+			as.Equal(expected, cfg.Include)
+		})
+	}
+
+	// default with no flag or env
+	checkValue([]string{})
+
+	// env override
+	t.Setenv("TREEFMT_INCLUDE", "src/**,*.nix")
+	checkValue([]string{"src/**", "*.nix"})
+
+	// flag override
+	as.NoError(flags.Set("include", "*.go"))
+	checkValue([]string{"*.go"})
+}
+
+func TestInteractive(t *testing.T) {
+	as := require.New(t)
+
+	cfg := &config.Config{}
+	v, flags := newViper(t)
+
+	checkValue := func(expected bool) {
+		readValue(t, v, cfg, func(cfg *config.Config) {
+			as.Equal(expected, cfg.Interactive)
 		})
 	}
 
@@ -309,47 +698,882 @@ func TestNoCache(t *testing.T) {
 	checkValue(false)
 
 	// set config value and check that it has no effect
-	// you are not allowed to set no-cache in config
-	cfg.NoCache = true
+	// you are not allowed to set interactive in config
+	cfg.Interactive = true
+
+	checkValue(false)
+
+	// env override
+	t.Setenv("TREEFMT_INTERACTIVE", "true")
+	checkValue(true)
+
+	// flag override
+	as.NoError(flags.Set("interactive", "false"))
+	checkValue(false)
+}
+
+func TestJobs(t *testing.T) {
+	as := require.New(t)
+
+	cfg := &config.Config{}
+	v, flags := newViper(t)
+
+	checkValue := func(expected int) {
+		readValue(t, v, cfg, func(cfg *config.Config) {
+			as.Equal(expected, cfg.Jobs)
+		})
+	}
+
+	// default with no flag, env or config
+	checkValue(0)
+
+	// set config value
+	cfg.Jobs = 4
+
+	checkValue(4)
+
+	// env override
+	t.Setenv("TREEFMT_JOBS", "2")
+	checkValue(2)
+
+	// flag override
+	as.NoError(flags.Set("jobs", "1"))
+	checkValue(1)
+}
+
+func TestKeepGoing(t *testing.T) {
+	as := require.New(t)
+
+	cfg := &config.Config{}
+	v, flags := newViper(t)
+
+	checkValue := func(expected bool) {
+		readValue(t, v, cfg, func(cfg *config.Config) {
+			as.Equal(expected, cfg.KeepGoing)
+		})
+	}
+
+	// default with no flag, env or config
+	checkValue(false)
+
+	// set config value
+	cfg.KeepGoing = true
+	checkValue(true)
+
+	// env override
+	t.Setenv("TREEFMT_KEEP_GOING", "false")
+	checkValue(false)
+
+	// flag override
+	as.NoError(flags.Set("keep-going", "true"))
+	checkValue(true)
+}
+
+func TestLineEndings(t *testing.T) {
+	as := require.New(t)
+
+	cfg := &config.Config{}
+	v, flags := newViper(t)
+
+	checkValue := func(expected string) {
+		readValue(t, v, cfg, func(cfg *config.Config) {
+			as.Equal(expected, cfg.LineEndings)
+		})
+	}
+
+	// default with no flag, env or config
+	checkValue("formatter")
+
+	// set config value
+	cfg.LineEndings = "preserve"
+
+	checkValue("preserve")
+
+	// env override
+	t.Setenv("TREEFMT_LINE_ENDINGS", "lf")
+	checkValue("lf")
+
+	// flag override
+	as.NoError(flags.Set("line-endings", "crlf"))
+	checkValue("crlf")
+}
+
+func TestLockWait(t *testing.T) {
+	as := require.New(t)
+
+	cfg := &config.Config{}
+	v, flags := newViper(t)
+
+	checkValue := func(expected string) {
+		readValue(t, v, cfg, func(cfg *config.Config) {
+			as.Equal(expected, cfg.LockWait)
+		})
+	}
+
+	// default with no flag, env or config
+	checkValue("")
+
+	// set config value
+	cfg.LockWait = "30s"
+	checkValue("30s")
+
+	// env override
+	t.Setenv("TREEFMT_LOCK_WAIT", "1m")
+	checkValue("1m")
+
+	// flag override
+	as.NoError(flags.Set("lock-wait", "5s"))
+	checkValue("5s")
+}
+
+func TestLogFile(t *testing.T) {
+	as := require.New(t)
+
+	cfg := &config.Config{}
+	v, flags := newViper(t)
+
+	checkValue := func(expected string, expectedMaxSize string) {
+		readValue(t, v, cfg, func(cfg *config.Config) {
+			as.Equal(expected, cfg.LogFile)
+			as.Equal(expectedMaxSize, cfg.LogFileMaxSize)
+		})
+	}
+
+	// default with no flag, env or config
+	checkValue("", "")
+
+	// set config value
+	cfg.LogFile = "foo.log"
+	cfg.LogFileMaxSize = "1MB"
+	checkValue("foo.log", "1MB")
+
+	// env override
+	t.Setenv("TREEFMT_LOG_FILE", "bar.log")
+	t.Setenv("TREEFMT_LOG_FILE_MAX_SIZE", "2MB")
+	checkValue("bar.log", "2MB")
 
+	// flag override
+	as.NoError(flags.Set("log-file", "baz.log"))
+	as.NoError(flags.Set("log-file-max-size", "3MiB"))
+	checkValue("baz.log", "3MiB")
+}
+
+func TestMaxChanges(t *testing.T) {
+	as := require.New(t)
+
+	cfg := &config.Config{}
+	v, flags := newViper(t)
+
+	checkValue := func(expected int) {
+		readValue(t, v, cfg, func(cfg *config.Config) {
+			as.Equal(expected, cfg.MaxChanges)
+		})
+	}
+
+	// default with no flag, env or config
+	checkValue(0)
+
+	// set config value
+	cfg.MaxChanges = 100
+
+	checkValue(100)
+
+	// env override
+	t.Setenv("TREEFMT_MAX_CHANGES", "50")
+	checkValue(50)
+
+	// flag override
+	as.NoError(flags.Set("max-changes", "10"))
+	checkValue(10)
+}
+
+func TestMaxFileSize(t *testing.T) {
+	as := require.New(t)
+
+	cfg := &config.Config{}
+	v, flags := newViper(t)
+
+	checkValue := func(expected string) {
+		readValue(t, v, cfg, func(cfg *config.Config) {
+			as.Equal(expected, cfg.MaxFileSize)
+		})
+	}
+
+	// default with no flag, env or config
+	checkValue("")
+
+	// set config value
+	cfg.MaxFileSize = "2MB"
+
+	checkValue("2MB")
+
+	// env override
+	t.Setenv("TREEFMT_MAX_FILE_SIZE", "512KiB")
+	checkValue("512KiB")
+
+	// flag override
+	as.NoError(flags.Set("max-file-size", "1GB"))
+	checkValue("1GB")
+}
+
+func TestMemProfile(t *testing.T) {
+	as := require.New(t)
+
+	cfg := &config.Config{}
+	v, flags := newViper(t)
+
+	checkValue := func(expected string) {
+		readValue(t, v, cfg, func(cfg *config.Config) {
+			as.Equal(expected, cfg.MemProfile)
+		})
+	}
+
+	// default with no flag, env or config
+	checkValue("")
+
+	// set config value
+	cfg.MemProfile = "/foo/bar"
+
+	checkValue("/foo/bar")
+
+	// env override
+	t.Setenv("TREEFMT_MEM_PROFILE", "/fizz/buzz")
+	checkValue("/fizz/buzz")
+
+	// flag override
+	as.NoError(flags.Set("mem-profile", "/bla/bla"))
+	checkValue("/bla/bla")
+}
+
+func TestMetricsListen(t *testing.T) {
+	as := require.New(t)
+
+	cfg := &config.Config{}
+	v, flags := newViper(t)
+
+	checkValue := func(expected string) {
+		readValue(t, v, cfg, func(cfg *config.Config) {
+			as.Equal(expected, cfg.MetricsListen)
+		})
+	}
+
+	// default with no flag, env or config
+	checkValue("")
+
+	// set config value
+	cfg.MetricsListen = ":9090"
+	checkValue(":9090")
+
+	// env override
+	t.Setenv("TREEFMT_METRICS_LISTEN", "localhost:9091")
+	checkValue("localhost:9091")
+
+	// flag override
+	as.NoError(flags.Set("metrics-listen", ":9092"))
+	checkValue(":9092")
+}
+
+func TestMutexProfile(t *testing.T) {
+	as := require.New(t)
+
+	cfg := &config.Config{}
+	v, flags := newViper(t)
+
+	checkValue := func(expected string) {
+		readValue(t, v, cfg, func(cfg *config.Config) {
+			as.Equal(expected, cfg.MutexProfile)
+		})
+	}
+
+	// default with no flag, env or config
+	checkValue("")
+
+	// set config value
+	cfg.MutexProfile = "/foo/bar"
+
+	checkValue("/foo/bar")
+
+	// env override
+	t.Setenv("TREEFMT_MUTEX_PROFILE", "/fizz/buzz")
+	checkValue("/fizz/buzz")
+
+	// flag override
+	as.NoError(flags.Set("mutex-profile", "/bla/bla"))
+	checkValue("/bla/bla")
+}
+
+func TestNestedConfigs(t *testing.T) {
+	as := require.New(t)
+
+	cfg := &config.Config{}
+	v, flags := newViper(t)
+
+	checkValue := func(expected bool) {
+		readValue(t, v, cfg, func(cfg *config.Config) {
+			as.Equal(expected, cfg.NestedConfigs)
+		})
+	}
+
+	// default with no flag, env or config
 	checkValue(false)
 
+	// set config value
+	cfg.NestedConfigs = true
+	checkValue(true)
+
+	// env override
+	t.Setenv("TREEFMT_NESTED_CONFIGS", "false")
+	checkValue(false)
+
+	// flag override
+	as.NoError(flags.Set("nested-configs", "true"))
+	checkValue(true)
+}
+
+func TestNoCache(t *testing.T) {
+	as := require.New(t)
+
+	cfg := &config.Config{}
+	v, flags := newViper(t)
+
+	checkValue := func(expected bool) {
+		readValue(t, v, cfg, func(cfg *config.Config) {
+			as.Equal(expected, cfg.NoCache)
+		})
+	}
+
+	// default with no flag, env or config
+	checkValue(false)
+
+	// set config value and check that it has no effect
+	// you are not allowed to set no-cache in config
+	cfg.NoCache = true
+
+	checkValue(false)
+
+	// env override
+	t.Setenv("TREEFMT_NO_CACHE", "false")
+	checkValue(false)
+
+	// flag override
+	as.NoError(flags.Set("no-cache", "true"))
+	checkValue(true)
+}
+
+func TestOnUnmatched(t *testing.T) {
+	as := require.New(t)
+
+	cfg := &config.Config{}
+	v, flags := newViper(t)
+
+	checkValue := func(expected string) {
+		readValue(t, v, cfg, func(cfg *config.Config) {
+			as.Equal(expected, cfg.OnUnmatched)
+		})
+	}
+
+	// default with no flag, env or config
+	checkValue("warn")
+
+	// set config value
+	cfg.OnUnmatched = "error"
+
+	checkValue("error")
+
+	// env override
+	t.Setenv("TREEFMT_ON_UNMATCHED", "debug")
+	checkValue("debug")
+
+	// flag override
+	as.NoError(flags.Set("on-unmatched", "fatal"))
+	checkValue("fatal")
+}
+
+func TestOtelEndpoint(t *testing.T) {
+	as := require.New(t)
+
+	cfg := &config.Config{}
+	v, flags := newViper(t)
+
+	checkValue := func(expected string) {
+		readValue(t, v, cfg, func(cfg *config.Config) {
+			as.Equal(expected, cfg.OtelEndpoint)
+		})
+	}
+
+	// default with no flag, env or config
+	checkValue("")
+
+	// set config value
+	cfg.OtelEndpoint = "http://localhost:4318"
+	checkValue("http://localhost:4318")
+
+	// env override
+	t.Setenv("TREEFMT_OTEL_ENDPOINT", "http://collector:4318")
+	checkValue("http://collector:4318")
+
+	// flag override
+	as.NoError(flags.Set("otel-endpoint", "https://collector/v1/traces"))
+	checkValue("https://collector/v1/traces")
+}
+
+func TestOutput(t *testing.T) {
+	as := require.New(t)
+
+	cfg := &config.Config{}
+	v, flags := newViper(t)
+
+	checkValue := func(expected string) {
+		readValue(t, v, cfg, func(cfg *config.Config) {
+			as.Equal(expected, cfg.Output)
+		})
+	}
+
+	// default with no flag, env or config
+	checkValue("auto")
+
+	// set config value
+	cfg.Output = "json"
+
+	checkValue("json")
+
+	// env override
+	t.Setenv("TREEFMT_OUTPUT", "text")
+	checkValue("text")
+
+	// flag override
+	as.NoError(flags.Set("output", "json"))
+	checkValue("json")
+}
+
+func TestPatchFile(t *testing.T) {
+	as := require.New(t)
+
+	cfg := &config.Config{}
+	v, flags := newViper(t)
+
+	checkValue := func(expected string) {
+		readValue(t, v, cfg, func(cfg *config.Config) {
+			as.Equal(expected, cfg.PatchFile)
+		})
+	}
+
+	// default with no flag, env or config
+	checkValue("")
+
+	// set config value
+	cfg.PatchFile = "foo.patch"
+	checkValue("foo.patch")
+
+	// env override
+	t.Setenv("TREEFMT_PATCH_FILE", "bar.patch")
+	checkValue("bar.patch")
+
+	// flag override
+	as.NoError(flags.Set("patch-file", "baz.patch"))
+	checkValue("baz.patch")
+}
+
+func TestProfile(t *testing.T) {
+	as := require.New(t)
+
+	cfg := &config.Config{
+		FormatterConfigs: map[string]*config.Formatter{
+			"go": {
+				Command:  "gofmt",
+				Options:  []string{"-w"},
+				Includes: []string{"*.go"},
+			},
+		},
+		Profiles: map[string]map[string]any{
+			"ci": {
+				"fail-on-change": true,
+				"no-cache":       true,
+				"formatter": map[string]any{
+					"go": map[string]any{"options": []string{"-s", "-w"}},
+					"lint": map[string]any{
+						"command":    "golangci-lint",
+						"includes":   []string{"*.go"},
+						"enabled-if": `{{eq .Profile "ci"}}`,
+					},
+				},
+			},
+			"dev": {"on-unmatched": "debug"},
+		},
+	}
+	v, flags := newViper(t)
+
+	checkValue := func(expected string, check func(cfg *config.Config)) {
+		readValue(t, v, cfg, func(cfg *config.Config) {
+			as.Equal(expected, cfg.Profile)
+			check(cfg)
+		})
+	}
+
+	// default with no flag, env or config
+	checkValue("", func(cfg *config.Config) {
+		as.False(cfg.FailOnChange)
+		as.Equal("warn", cfg.OnUnmatched)
+		as.Len(cfg.FormatterConfigs, 1)
+	})
+
+	// set config value
+	cfg.Profile = "dev"
+	checkValue("dev", func(cfg *config.Config) {
+		as.Equal("debug", cfg.OnUnmatched)
+	})
+
+	// env override, with the profile merged into the formatters and setting options not allowed in the config file
+	t.Setenv("TREEFMT_PROFILE", "ci")
+	checkValue("ci", func(cfg *config.Config) {
+		as.True(cfg.FailOnChange)
+		as.True(cfg.NoCache)
+		as.Equal("warn", cfg.OnUnmatched)
+		as.Equal(&config.Formatter{
+			Command:  "gofmt",
+			Options:  []string{"-s", "-w"},
+			Includes: []string{"*.go"},
+		}, cfg.FormatterConfigs["go"])
+		as.Equal("golangci-lint", cfg.FormatterConfigs["lint"].Command)
+	})
+
+	// flags take precedence over the profile
+	as.NoError(flags.Set("no-cache", "false"))
+	checkValue("ci", func(cfg *config.Config) {
+		as.False(cfg.NoCache)
+	})
+
+	// flag override, with unknown profiles being reported
+	as.NoError(flags.Set("profile", "dve"))
+
+	_, err := config.FromViper(v)
+	as.ErrorContains(err, "unknown profile 'dve', did you mean 'dev'?")
+}
+
+func TestQuiet(t *testing.T) {
+	as := require.New(t)
+
+	cfg := &config.Config{}
+	v, flags := newViper(t)
+
+	checkValue := func(expected bool) {
+		readValue(t, v, cfg, func(cfg *config.Config) {
+			as.Equal(expected, cfg.Quiet)
+		})
+	}
+
+	// default with no flag, env or config
+	checkValue(false)
+
+	// set config value
+	cfg.Quiet = true
+	checkValue(true)
+
+	// env override
+	t.Setenv("TREEFMT_QUIET", "false")
+	checkValue(false)
+
+	// flag override
+	as.NoError(flags.Set("quiet", "true"))
+	checkValue(true)
+}
+
+func TestReport(t *testing.T) {
+	as := require.New(t)
+
+	cfg := &config.Config{}
+	v, flags := newViper(t)
+
+	checkValue := func(expected []string) {
+		readValue(t, v, cfg, func(cfg *config.Config) {
+			as.Equal(expected, cfg.Reports)
+		})
+	}
+
+	// default with no flag, env or config
+	checkValue([]string{})
+
+	// set config value
+	cfg.Reports = []string{"sarif=treefmt.sarif"}
+	checkValue([]string{"sarif=treefmt.sarif"})
+
+	// env override
+	t.Setenv("TREEFMT_REPORT", "sarif=env.sarif")
+	checkValue([]string{"sarif=env.sarif"})
+
+	// flag override
+	as.NoError(flags.Set("report", "sarif=flag.sarif"))
+	checkValue([]string{"sarif=flag.sarif"})
+}
+
+func TestSince(t *testing.T) {
+	as := require.New(t)
+
+	cfg := &config.Config{}
+	v, flags := newViper(t)
+
+	checkValue := func(expected string) {
+		readValue(t, v, cfg, func(cfg *config.Config) {
+			as.Equal(expected, cfg.Since)
+		})
+	}
+
+	// default with no flag, env or config
+	checkValue("")
+
+	// set config value and check that it has no effect
+	// you are not allowed to set since in config
+	cfg.Since = "main"
+
+	checkValue("")
+
+	// env override
+	t.Setenv("TREEFMT_SINCE", "origin/main")
+	checkValue("origin/main")
+
+	// flag override
+	as.NoError(flags.Set("since", "HEAD~1"))
+	checkValue("HEAD~1")
+}
+
+func TestSkipFormatters(t *testing.T) {
+	as := require.New(t)
+
+	cfg := &config.Config{
+		FormatterConfigs: map[string]*config.Formatter{
+			"echo":  {Command: "echo"},
+			"touch": {Command: "touch"},
+			"date":  {Command: "date"},
+		},
+	}
+	v, flags := newViper(t)
+
+	checkValue := func(expected []string, selected ...string) {
+		readValue(t, v, cfg, func(cfg *config.Config) {
+			as.Equal(expected, cfg.SkipFormatters)
+
+			names := make([]string, 0, len(cfg.FormatterConfigs))
+			for name := range cfg.FormatterConfigs {
+				names = append(names, name)
+			}
+
+			as.ElementsMatch(selected, names)
+		})
+	}
+
+	// default with no flag or env
+	checkValue([]string{}, "echo", "touch", "date")
+
+	// env override
+	t.Setenv("TREEFMT_SKIP_FORMATTERS", "echo")
+	checkValue([]string{"echo"}, "touch", "date")
+
+	// flag override
+	as.NoError(flags.Set("skip-formatters", "date,touch"))
+	checkValue([]string{"date", "touch"}, "echo")
+
+	// formatters are skipped regardless of the config
+	cfg.Formatters = []string{"echo", "touch"}
+
+	checkValue([]string{"date", "touch"}, "echo")
+
+	// unknown formatter
+	as.NoError(flags.Set("skip-formatters", "foo"))
+
+	_, err := config.FromViper(v)
+	as.ErrorContains(err, "formatter foo not found in config")
+}
+
+func TestSkipTags(t *testing.T) {
+	as := require.New(t)
+
+	cfg := &config.Config{
+		FormatterConfigs: map[string]*config.Formatter{
+			"echo":  {Command: "echo", Tags: []string{"fast"}},
+			"touch": {Command: "touch", Tags: []string{"fast", "slow"}},
+			"date":  {Command: "date", Tags: []string{"slow"}},
+		},
+	}
+	v, flags := newViper(t)
+
+	checkValue := func(expected []string, selected ...string) {
+		readValue(t, v, cfg, func(cfg *config.Config) {
+			as.Equal(expected, cfg.SkipTags)
+
+			names := make([]string, 0, len(cfg.FormatterConfigs))
+			for name := range cfg.FormatterConfigs {
+				names = append(names, name)
+			}
+
+			as.ElementsMatch(selected, names)
+		})
+	}
+
+	// default with no flag, env or config
+	checkValue([]string{}, "echo", "touch", "date")
+
+	// set config value
+	cfg.SkipTags = []string{"fast"}
+
+	checkValue([]string{"fast"}, "date")
+
+	// env override
+	t.Setenv("TREEFMT_SKIP_TAGS", "slow")
+	checkValue([]string{"slow"}, "echo")
+
+	// flag override
+	as.NoError(flags.Set("skip-tags", "foo"))
+
+	_, err := config.FromViper(v)
+	as.ErrorContains(err, "no formatters are tagged foo in config")
+}
+
+func TestStatsFile(t *testing.T) {
+	as := require.New(t)
+
+	cfg := &config.Config{}
+	v, flags := newViper(t)
+
+	checkValue := func(expected string) {
+		readValue(t, v, cfg, func(cfg *config.Config) {
+			as.Equal(expected, cfg.StatsFile)
+		})
+	}
+
+	// default with no flag, env or config
+	checkValue("")
+
+	// set config value
+	cfg.StatsFile = "foo.json"
+	checkValue("foo.json")
+
+	// env override
+	t.Setenv("TREEFMT_STATS_FILE", "bar.json")
+	checkValue("bar.json")
+
+	// flag override
+	as.NoError(flags.Set("stats-file", "baz.json"))
+	checkValue("baz.json")
+}
+
+func TestSummary(t *testing.T) {
+	as := require.New(t)
+
+	cfg := &config.Config{}
+	v, flags := newViper(t)
+
+	checkValue := func(expected string) {
+		readValue(t, v, cfg, func(cfg *config.Config) {
+			as.Equal(expected, cfg.Summary)
+		})
+	}
+
+	// default with no flag, env or config
+	checkValue("basic")
+
+	// set config value
+	cfg.Summary = "detailed"
+
+	checkValue("detailed")
+
+	// env override
+	t.Setenv("TREEFMT_SUMMARY", "basic")
+	checkValue("basic")
+
+	// flag override
+	as.NoError(flags.Set("summary", "detailed"))
+	checkValue("detailed")
+}
+
+func TestSymlinks(t *testing.T) {
+	as := require.New(t)
+
+	cfg := &config.Config{}
+	v, flags := newViper(t)
+
+	checkValue := func(expected string) {
+		readValue(t, v, cfg, func(cfg *config.Config) {
+			as.Equal(expected, cfg.Symlinks)
+		})
+	}
+
+	// default with no flag, env or config
+	checkValue("skip")
+
+	// set config value
+	cfg.Symlinks = "follow"
+
+	checkValue("follow")
+
 	// env override
-	t.Setenv("TREEFMT_NO_CACHE", "false")
-	checkValue(false)
+	t.Setenv("TREEFMT_SYMLINKS", "error")
+	checkValue("error")
 
 	// flag override
-	as.NoError(flags.Set("no-cache", "true"))
-	checkValue(true)
+	as.NoError(flags.Set("symlinks", "skip"))
+	checkValue("skip")
 }
 
-func TestOnUnmatched(t *testing.T) {
+func TestTags(t *testing.T) {
 	as := require.New(t)
 
-	cfg := &config.Config{}
+	cfg := &config.Config{
+		FormatterConfigs: map[string]*config.Formatter{
+			"echo":  {Command: "echo", Tags: []string{"fast"}},
+			"touch": {Command: "touch", Tags: []string{"fast", "slow"}},
+			"date":  {Command: "date", Tags: []string{"slow"}},
+		},
+	}
 	v, flags := newViper(t)
 
-	checkValue := func(expected string) {
+	checkValue := func(expected []string, selected ...string) {
 		readValue(t, v, cfg, func(cfg *config.Config) {
-			as.Equal(expected, cfg.OnUnmatched)
+			as.Equal(expected, cfg.Tags)
+
+			names := make([]string, 0, len(cfg.FormatterConfigs))
+			for name := range cfg.FormatterConfigs {
+				names = append(names, name)
+			}
+
+			as.ElementsMatch(selected, names)
 		})
 	}
 
 	// default with no flag, env or config
-	checkValue("warn")
+	checkValue([]string{}, "echo", "touch", "date")
 
 	// set config value
-	cfg.OnUnmatched = "error"
+	cfg.Tags = []string{"fast"}
 
-	checkValue("error")
+	checkValue([]string{"fast"}, "echo", "touch")
+
+	// combined with skipped tags
+	cfg.SkipTags = []string{"slow"}
+
+	checkValue([]string{"fast"}, "echo")
+
+	cfg.SkipTags = nil
+
+	// combined with formatters
+	cfg.Formatters = []string{"touch", "date"}
+
+	checkValue([]string{"fast"}, "touch")
+
+	cfg.Formatters = nil
 
 	// env override
-	t.Setenv("TREEFMT_ON_UNMATCHED", "debug")
-	checkValue("debug")
+	t.Setenv("TREEFMT_TAGS", "slow")
+	checkValue([]string{"slow"}, "touch", "date")
 
 	// flag override
-	as.NoError(flags.Set("on-unmatched", "fatal"))
-	checkValue("fatal")
+	as.NoError(flags.Set("tags", "fast,slow"))
+	checkValue([]string{"fast", "slow"}, "echo", "touch", "date")
+
+	// unknown tag
+	as.NoError(flags.Set("tags", "foo"))
+
+	_, err := config.FromViper(v)
+	as.ErrorContains(err, "no formatters are tagged foo in config")
 }
 
 func TestTreeRoot(t *testing.T) {
@@ -427,6 +1651,64 @@ func TestTreeRootFile(t *testing.T) {
 	checkValue(tempDir, ".git/config")
 }
 
+func TestTUI(t *testing.T) {
+	as := require.New(t)
+
+	cfg := &config.Config{}
+	v, flags := newViper(t)
+
+	checkValue := func(expected bool) {
+		readValue(t, v, cfg, func(cfg *config.Config) {
+			as.Equal(expected, cfg.TUI)
+		})
+	}
+
+	// default with no flag, env or config
+	checkValue(false)
+
+	// set config value and check that it has no effect
+	// you are not allowed to set tui in config
+	cfg.TUI = true
+
+	checkValue(false)
+
+	// env override
+	t.Setenv("TREEFMT_TUI", "true")
+	checkValue(true)
+
+	// flag override
+	as.NoError(flags.Set("tui", "false"))
+	checkValue(false)
+}
+
+func TestUnmatchedFile(t *testing.T) {
+	as := require.New(t)
+
+	cfg := &config.Config{}
+	v, flags := newViper(t)
+
+	checkValue := func(expected string) {
+		readValue(t, v, cfg, func(cfg *config.Config) {
+			as.Equal(expected, cfg.UnmatchedFile)
+		})
+	}
+
+	// default with no flag, env or config
+	checkValue("")
+
+	// set config value
+	cfg.UnmatchedFile = "foo.txt"
+	checkValue("foo.txt")
+
+	// env override
+	t.Setenv("TREEFMT_UNMATCHED_FILE", "bar.txt")
+	checkValue("bar.txt")
+
+	// flag override
+	as.NoError(flags.Set("unmatched-file", "baz.txt"))
+	checkValue("baz.txt")
+}
+
 func TestVerbosity(t *testing.T) {
 	as := require.New(t)
 
@@ -486,6 +1768,36 @@ func TestWalk(t *testing.T) {
 	checkValue("auto")
 }
 
+func TestWatch(t *testing.T) {
+	as := require.New(t)
+
+	cfg := &config.Config{}
+	v, flags := newViper(t)
+
+	checkValue := func(expected bool) {
+		readValue(t, v, cfg, func(cfg *config.Config) {
+			as.Equal(expected, cfg.Watch)
+		})
+	}
+
+	// default with no flag, env or config
+	checkValue(false)
+
+	// set config value and check that it has no effect
+	// you are not allowed to set watch in config
+	cfg.Watch = true
+
+	checkValue(false)
+
+	// env override
+	t.Setenv("TREEFMT_WATCH", "false")
+	checkValue(false)
+
+	// flag override
+	as.NoError(flags.Set("watch", "true"))
+	checkValue(true)
+}
+
 func TestWorkingDirectory(t *testing.T) {
 	as := require.New(t)
 
@@ -522,6 +1834,36 @@ func TestWorkingDirectory(t *testing.T) {
 	checkValue("/flip/flop")
 }
 
+func TestYes(t *testing.T) {
+	as := require.New(t)
+
+	cfg := &config.Config{}
+	v, flags := newViper(t)
+
+	checkValue := func(expected bool) {
+		readValue(t, v, cfg, func(cfg *config.Config) {
+			as.Equal(expected, cfg.Yes)
+		})
+	}
+
+	// default with no flag, env or config
+	checkValue(false)
+
+	// set config value and check that it has no effect
+	// you are not allowed to set yes in config
+	cfg.Yes = true
+
+	checkValue(false)
+
+	// env override
+	t.Setenv("TREEFMT_YES", "true")
+	checkValue(true)
+
+	// flag override
+	as.NoError(flags.Set("yes", "false"))
+	checkValue(false)
+}
+
 func TestStdin(t *testing.T) {
 	as := require.New(t)
 
@@ -552,6 +1894,61 @@ func TestStdin(t *testing.T) {
 	checkValues(true)
 }
 
+func TestStdinFilelist(t *testing.T) {
+	as := require.New(t)
+
+	cfg := &config.Config{}
+	v, flags := newViper(t)
+
+	checkValues := func(expected bool) {
+		readValue(t, v, cfg, func(cfg *config.Config) {
+			as.Equal(expected, cfg.StdinFilelist)
+		})
+	}
+
+	// default with no flag, env or config
+	checkValues(false)
+
+	// set config value and check that it has no effect
+	// you are not allowed to set stdin-filelist in config
+	cfg.StdinFilelist = true
+
+	checkValues(false)
+
+	// flag override
+	as.NoError(flags.Set("stdin-filelist", "true"))
+	checkValues(true)
+}
+
+func TestTimeout(t *testing.T) {
+	as := require.New(t)
+
+	cfg := &config.Config{}
+	v, flags := newViper(t)
+
+	checkValue := func(expected string) {
+		readValue(t, v, cfg, func(cfg *config.Config) {
+			as.Equal(expected, cfg.Timeout)
+		})
+	}
+
+	// default with no flag, env or config
+	checkValue("")
+
+	// set config value
+	cfg.Timeout = "30s"
+
+	checkValue("30s")
+
+	// env override
+	t.Setenv("TREEFMT_TIMEOUT", "1m")
+	checkValue("1m")
+
+	// flag override
+	as.NoError(flags.Set("timeout", "5m"))
+	checkValue("5m")
+}
+
 func TestSampleConfigFile(t *testing.T) {
 	as := require.New(t)
 
@@ -687,3 +2084,648 @@ func TestSampleConfigFile(t *testing.T) {
 	as.True(ok, "foo formatter not found")
 	as.Equal("foo-fmt", foo.Command)
 }
+
+func TestImports(t *testing.T) {
+	as := require.New(t)
+
+	tempDir := t.TempDir()
+
+	write := func(name string, contents string) string {
+		path := filepath.Join(tempDir, name)
+		as.NoError(os.MkdirAll(filepath.Dir(path), 0o755))
+		as.NoError(os.WriteFile(path, []byte(contents), 0o600))
+
+		return path
+	}
+
+	load := func(path string) (*config.Config, error) {
+		v, flags := newViper(t)
+		v.SetConfigFile(path)
+		as.NoError(flags.Set("tree-root", tempDir))
+		as.NoError(v.ReadInConfig())
+
+		if err := config.ResolveImports(v); err != nil {
+			return nil, err
+		}
+
+		return config.FromViper(v)
+	}
+
+	// imports are relative to the importing file, and may be nested or in another format
+	write("fmt/go.toml", `
+imports = ["shared.yaml"]
+
+[formatter.go]
+command = "gofmt"
+includes = ["*.go"]
+priority = 1
+`)
+	write("fmt/shared.yaml", "excludes: [\"vendor/*\"]\nformatter:\n  go:\n    options: [\"-s\"]\n")
+	write("fmt/js.toml", `
+excludes = ["node_modules/*"]
+
+[formatter.go]
+priority = 2
+
+[formatter.prettier]
+command = "prettier"
+includes = ["*.js"]
+`)
+
+	cfg, err := load(write("treefmt.toml", `
+imports = ["./fmt/go.toml", "fmt/js.toml"]
+on-unmatched = "debug"
+
+[formatter.prettier]
+options = ["--write"]
+`))
+	as.NoError(err)
+
+	as.Equal("debug", cfg.OnUnmatched)
+	// later imports take precedence over earlier ones
+	as.Equal([]string{"node_modules/*"}, cfg.Excludes)
+	// tables are merged
+	as.Equal(&config.Formatter{
+		Command:  "gofmt",
+		Options:  []string{"-s"},
+		Includes: []string{"*.go"},
+		Priority: 2,
+	}, cfg.FormatterConfigs["go"])
+	// the importing file takes precedence over its imports
+	as.Equal(&config.Formatter{
+		Command:  "prettier",
+		Options:  []string{"--write"},
+		Includes: []string{"*.js"},
+	}, cfg.FormatterConfigs["prettier"])
+
+	// templates are expanded relative to the file in which they were set
+	write("fmt/templates.toml", `
+excludes = ["{{.ConfigDir}}/generated/*"]
+
+[formatter.go]
+command = "{{.ConfigDir}}/bin/gofmt"
+options = ["--config", "{{.ConfigDir}}/gofmt.toml"]
+includes = ["*.go"]
+`)
+
+	cfg, err = load(write("templates.toml", `
+imports = ["fmt/templates.toml"]
+
+[formatter.go]
+options = ["--config", "{{.ConfigDir}}/gofmt.toml"]
+`))
+	as.NoError(err)
+
+	as.Equal([]string{filepath.Join(tempDir, "fmt/generated/*")}, cfg.Excludes)
+	as.Equal(filepath.Join(tempDir, "fmt/bin/gofmt"), cfg.FormatterConfigs["go"].Command)
+	as.Equal([]string{"--config", filepath.Join(tempDir, "gofmt.toml")}, cfg.FormatterConfigs["go"].Options)
+
+	// missing imports
+	_, err = load(write("missing.toml", `imports = ["fmt/missing.toml"]`))
+	as.ErrorContains(err, "failed to import fmt/missing.toml from "+filepath.Join(tempDir, "missing.toml"))
+
+	// cycles
+	write("fmt/cycle.toml", `imports = ["../cycle.toml"]`)
+	_, err = load(write("cycle.toml", `imports = ["fmt/cycle.toml"]`))
+	as.ErrorContains(err, "import cycle: "+strings.Join([]string{
+		filepath.Join(tempDir, "cycle.toml"),
+		filepath.Join(tempDir, "fmt/cycle.toml"),
+		filepath.Join(tempDir, "cycle.toml"),
+	}, " -> "))
+}
+
+func TestScope(t *testing.T) {
+	as := require.New(t)
+
+	treeRoot := t.TempDir()
+
+	write := func(name string, contents string) {
+		path := filepath.Join(treeRoot, name)
+		as.NoError(os.MkdirAll(filepath.Dir(path), 0o755))
+		as.NoError(os.WriteFile(path, []byte(contents), 0o600))
+	}
+
+	root := config.RootScope(&config.Config{
+		ConfigFile: filepath.Join(treeRoot, "treefmt.toml"),
+		Excludes:   []string{"*.md"},
+		FormatterConfigs: map[string]*config.Formatter{
+			"go": {
+				Command:  "gofmt",
+				Options:  []string{"-w"},
+				Includes: []string{"*.go"},
+			},
+			"nix": {
+				Command:  "nixfmt",
+				Includes: []string{"*.nix"},
+			},
+		},
+	})
+
+	// directories without a config file, or containing the root config file, are not nested scopes
+	write("treefmt.toml", "")
+
+	for _, dir := range []string{".", "services"} {
+		scope, err := root.Nest(treeRoot, dir)
+		as.NoError(err)
+		as.Nil(scope)
+	}
+
+	write("services/api/treefmt.yaml", `
+excludes: ["generated/*"]
+walk: git
+formatter:
+  go:
+    options: ["-s", "-w"]
+    excludes: ["vendor/*"]
+  prettier:
+    command: prettier
+    includes: ["*.js"]
+`)
+
+	scope, err := root.Nest(treeRoot, "services/api")
+	as.NoError(err)
+	as.Equal(filepath.Join(treeRoot, "services/api/treefmt.yaml"), scope.ConfigFile)
+	as.Equal([]string{"walk"}, scope.Ignored)
+
+	// excludes are added to those of the parent, relative to the nested config file
+	as.Equal([]string{"*.md", "services/api/generated/*"}, scope.Excludes)
+
+	// only the keys which are set replace those of the parent
+	as.Equal(&config.Formatter{
+		Command:  "gofmt",
+		Options:  []string{"-s", "-w"},
+		Includes: []string{"*.go"},
+		Excludes: []string{"services/api/vendor/*"},
+	}, scope.FormatterConfigs["go"])
+
+	// formatters may be added, and those which are unchanged are shared with the parent
+	as.Equal([]string{"services/api/*.js"}, scope.FormatterConfigs["prettier"].Includes)
+	as.Same(root.FormatterConfigs["nix"], scope.FormatterConfigs["nix"])
+
+	// scopes can be nested further
+	// with templates being relative to the nested config file
+	write("services/api/v2/treefmt.toml", `
+[formatter.prettier]
+options = ["--config", "{{.ConfigDir}}/.prettierrc"]
+`)
+
+	nested, err := scope.Nest(treeRoot, "services/api/v2")
+	as.NoError(err)
+	as.Equal(scope.Excludes, nested.Excludes)
+	as.Same(scope.FormatterConfigs["go"], nested.FormatterConfigs["go"])
+	as.Equal(&config.Formatter{
+		Command:  "prettier",
+		Options:  []string{"--config", filepath.Join(treeRoot, "services/api/v2/.prettierrc")},
+		Includes: []string{"services/api/*.js"},
+	}, nested.FormatterConfigs["prettier"])
+
+	// formatters can be disabled for the files beneath a nested config file
+	write("services/web/treefmt.toml", `
+[formatter.go]
+enabled-if = "false"
+`)
+
+	web, err := root.Nest(treeRoot, "services/web")
+	as.NoError(err)
+	as.Equal([]string{"go"}, web.Disabled)
+	as.NotContains(web.FormatterConfigs, "go")
+	as.Contains(web.FormatterConfigs, "nix")
+}
+
+func TestPresets(t *testing.T) {
+	as := require.New(t)
+
+	load := func(contents string) (*config.Config, error) {
+		v, _ := newViper(t)
+		as.NoError(v.ReadConfig(strings.NewReader(contents)))
+
+		return config.FromViper(v)
+	}
+
+	// a preset provides the command, options and includes
+	cfg, err := load("[formatter.go]\npreset = \"gofumpt\"\n")
+	as.NoError(err)
+	as.Equal(&config.Formatter{
+		Preset:   "gofumpt",
+		Command:  "gofumpt",
+		Options:  []string{"-w"},
+		Includes: []string{"*.go"},
+	}, cfg.FormatterConfigs["go"])
+
+	// which can be overridden field by field
+	cfg, err = load(`
+[formatter.shell]
+preset = "shfmt"
+options = ["-w", "-i", "2"]
+excludes = ["vendor/*"]
+priority = 1
+`)
+	as.NoError(err)
+	as.Equal(&config.Formatter{
+		Preset:       "shfmt",
+		Command:      "shfmt",
+		Options:      []string{"-w", "-i", "2"},
+		Includes:     []string{"*.sh", "*.bash"},
+		Excludes:     []string{"vendor/*"},
+		Interpreters: []string{"sh", "bash"},
+		Priority:     1,
+	}, cfg.FormatterConfigs["shell"])
+
+	// the catalogue is not modified
+	as.Equal([]string{"-w"}, config.Presets["shfmt"].Options)
+
+	// unknown presets are reported, along with the closest match
+	_, err = load("[formatter.web]\npreset = \"prettir\"\n")
+	as.ErrorContains(err, "formatter web has an unknown preset 'prettir', did you mean 'prettier'?")
+
+	// every preset is complete
+	for _, name := range config.PresetNames() {
+		preset := config.Presets[name]
+		as.NotEmpty(preset.Command, name)
+		as.NotEmpty(preset.Includes, name)
+	}
+}
+
+func TestTemplates(t *testing.T) {
+	as := require.New(t)
+
+	load := func(contents string) (*config.Config, error) {
+		v, _ := newViper(t)
+		as.NoError(v.ReadConfig(strings.NewReader(contents)))
+
+		return config.FromViper(v)
+	}
+
+	cfg, err := load(`
+[templates.prettier-base]
+command = "prettier"
+options = ["--write"]
+excludes = ["node_modules/*"]
+priority = 1
+
+[templates.prettier-markdown]
+extends = "prettier-base"
+options = ["--write", "--prose-wrap", "always"]
+
+[templates.nix]
+preset = "nixfmt"
+options = ["--width", "120"]
+
+[formatter.typescript]
+extends = "prettier-base"
+options = ["--write", "--parser", "typescript"]
+includes = ["*.ts"]
+
+[formatter.markdown]
+extends = "prettier-markdown"
+includes = ["*.md"]
+priority = 2
+
+[formatter.nix]
+extends = "nix"
+`)
+	as.NoError(err)
+
+	// formatters take the keys of their template, overriding only those they set
+	as.Equal(&config.Formatter{
+		Extends:  "prettier-base",
+		Command:  "prettier",
+		Options:  []string{"--write", "--parser", "typescript"},
+		Includes: []string{"*.ts"},
+		Excludes: []string{"node_modules/*"},
+		Priority: 1,
+	}, cfg.FormatterConfigs["typescript"])
+
+	// templates can extend other templates
+	as.Equal(&config.Formatter{
+		Extends:  "prettier-markdown",
+		Command:  "prettier",
+		Options:  []string{"--write", "--prose-wrap", "always"},
+		Includes: []string{"*.md"},
+		Excludes: []string{"node_modules/*"},
+		Priority: 2,
+	}, cfg.FormatterConfigs["markdown"])
+
+	// and use a preset
+	as.Equal(&config.Formatter{
+		Extends:  "nix",
+		Preset:   "nixfmt",
+		Command:  "nixfmt",
+		Options:  []string{"--width", "120"},
+		Includes: []string{"*.nix"},
+	}, cfg.FormatterConfigs["nix"])
+
+	// templates are not formatters
+	as.Len(cfg.FormatterConfigs, 3)
+
+	// unknown templates are reported, along with the closest match
+	_, err = load("[templates.prettier]\ncommand = \"prettier\"\n\n[formatter.web]\nextends = \"prettir\"\n")
+	as.ErrorContains(err, "failed to expand the template of formatter web: unknown template 'prettir', "+
+		"did you mean 'prettier'?")
+
+	// as are cycles
+	_, err = load(`
+[templates.a]
+extends = "b"
+
+[templates.b]
+extends = "a"
+
+[formatter.web]
+extends = "a"
+includes = ["*.js"]
+`)
+	as.ErrorContains(err, "template cycle: a -> b -> a")
+}
+
+func TestInterpolation(t *testing.T) {
+	as := require.New(t)
+
+	t.Setenv("GENERATED_DIR", "gen")
+	t.Setenv("FMT_STYLE", "google")
+
+	load := func(contents string) (*config.Config, string, error) {
+		v, _ := newViper(t)
+		as.NoError(v.ReadConfig(strings.NewReader(contents)))
+
+		cfg, err := config.FromViper(v)
+
+		return cfg, filepath.Dir(v.ConfigFileUsed()), err
+	}
+
+	cfg, configDir, err := load(`
+excludes = ['{{env "GENERATED_DIR"}}/*']
+
+[formatter.java]
+command = "{{.TreeRoot}}/tools/google-java-format"
+options = ['--style={{env "FMT_STYLE"}}', "--config", "{{.ConfigDir}}/fmt.xml", '{{"{{"}}literal}}']
+includes = ["{{.TreeRoot}}/*.java"]
+excludes = ['{{env "GENERATED_DIR"}}/*.java']
+`)
+	as.NoError(err)
+
+	// the config file is in the tree root
+	as.Equal([]string{"gen/*"}, cfg.Excludes)
+	as.Equal(&config.Formatter{
+		Command:  filepath.Join(configDir, "tools/google-java-format"),
+		Options:  []string{"--style=google", "--config", filepath.Join(configDir, "fmt.xml"), "{{literal}}"},
+		Includes: []string{"{{.TreeRoot}}/*.java"}, // includes are not interpolated
+		Excludes: []string{"gen/*.java"},
+	}, cfg.FormatterConfigs["java"])
+
+	// unknown variables and invalid templates are reported
+	_, _, err = load("[formatter.go]\ncommand = \"gofmt\"\noptions = [\"{{.Unknown}}\"]\nincludes = [\"*.go\"]\n")
+	as.ErrorContains(err, "failed to interpolate formatter.go.options")
+
+	_, _, err = load("[formatter.go]\ncommand = \"{{.TreeRoot\"\nincludes = [\"*.go\"]\n")
+	as.ErrorContains(err, "failed to parse template in formatter.go.command")
+}
+
+func TestEnabledIf(t *testing.T) {
+	as := require.New(t)
+
+	t.Setenv("TREEFMT_TEST_SET", "")
+
+	load := func(contents string, formatters ...string) (*config.Config, error) {
+		v, flags := newViper(t)
+		as.NoError(v.ReadConfig(strings.NewReader(contents)))
+
+		if len(formatters) > 0 {
+			as.NoError(flags.Set("formatters", strings.Join(formatters, ",")))
+		}
+
+		return config.FromViper(v)
+	}
+
+	contents := fmt.Sprintf(`
+[formatter.always]
+command = "gofmt"
+includes = ["*.go"]
+
+[formatter.os]
+command = "gofmt"
+includes = ["*.go"]
+enabled-if = '{{ne .OS "%s"}}'
+
+[formatter.set]
+command = "gofmt"
+includes = ["*.go"]
+enabled-if = '{{isset "TREEFMT_TEST_SET"}}'
+
+[formatter.unset]
+command = "gofmt"
+includes = ["*.go"]
+enabled-if = '{{env "TREEFMT_TEST_UNSET"}}'
+
+[formatter.executable]
+command = "gofmt"
+includes = ["*.go"]
+enabled-if = '{{and (executable "treefmt-test-missing") (exists "go.mod")}}'
+`, runtime.GOOS)
+
+	cfg, err := load(contents)
+	as.NoError(err)
+	as.Equal([]string{"executable", "os", "unset"}, cfg.DisabledFormatters)
+	as.Len(cfg.FormatterConfigs, 2)
+	as.Contains(cfg.FormatterConfigs, "always")
+	as.Contains(cfg.FormatterConfigs, "set")
+
+	// selecting a disabled formatter is not an error
+	cfg, err = load(contents, "os", "set")
+	as.NoError(err)
+	as.Equal([]string{"executable", "os", "unset"}, cfg.DisabledFormatters)
+	as.Len(cfg.FormatterConfigs, 1)
+	as.Contains(cfg.FormatterConfigs, "set")
+
+	// conditions must evaluate to a boolean
+	_, err = load("[formatter.go]\ncommand = \"gofmt\"\nincludes = [\"*.go\"]\nenabled-if = \"{{.OS}}\"\n")
+	as.ErrorContains(err, "formatter.go.enabled-if must evaluate to true or false, got '"+runtime.GOOS+"'")
+}
+
+func TestAfter(t *testing.T) {
+	as := require.New(t)
+
+	load := func(contents string, formatters ...string) (*config.Config, error) {
+		v, flags := newViper(t)
+		as.NoError(v.ReadConfig(strings.NewReader(contents)))
+
+		if len(formatters) > 0 {
+			as.NoError(flags.Set("formatters", strings.Join(formatters, ",")))
+		}
+
+		return config.FromViper(v)
+	}
+
+	contents := `
+[formatter.gofmt]
+command = "gofmt"
+includes = ["*.go"]
+
+[formatter.goimports]
+command = "goimports"
+includes = ["*.go"]
+after = ["gofmt"]
+
+[formatter.golines]
+command = "golines"
+includes = ["*.go"]
+after = ["gofmt", "goimports"]
+`
+
+	cfg, err := load(contents)
+	as.NoError(err)
+	as.Equal([]string{"gofmt", "goimports"}, cfg.FormatterConfigs["golines"].After)
+
+	// formatters which are not selected can still be depended upon
+	cfg, err = load(contents, "golines")
+	as.NoError(err)
+	as.Len(cfg.FormatterConfigs, 1)
+
+	// unknown formatter
+	_, err = load("[formatter.goimports]\ncommand = \"goimports\"\nincludes = [\"*.go\"]\nafter = [\"gofumpt\"]\n")
+	as.ErrorContains(err, "formatter goimports is after gofumpt, which is not in config")
+
+	// cycle
+	_, err = load(strings.Replace(contents, `command = "gofmt"`, `command = "gofmt"`+"\nafter = [\"golines\"]", 1))
+	as.ErrorContains(err, "formatters have a cycle in their after dependencies: gofmt -> golines -> gofmt")
+}
+
+func TestValidate(t *testing.T) {
+	as := require.New(t)
+
+	validate := func(contents string) []config.Problem {
+		path := filepath.Join(t.TempDir(), "treefmt.toml")
+		as.NoError(os.WriteFile(path, []byte(contents), 0o600))
+
+		problems, err := config.Validate(path)
+		as.NoError(err)
+
+		return problems
+	}
+
+	// the sample config and init template are valid
+	for _, path := range []string{"../test/examples/treefmt.toml", "../cmd/init/init.toml"} {
+		problems, err := config.Validate(path)
+		as.NoError(err)
+		as.Empty(problems, path)
+	}
+
+	// unknown keys are reported with their location and the closest known key
+	problems := validate(`excludes = ["*.md"]
+ci = true
+on-unmatchd = "info"
+
+[formatter.nix]
+command = "nixfmt"
+  inculdes = ["*.nix"]
+
+[formater.go]
+command = "gofmt"
+`)
+	as.Equal([]config.Problem{
+		{Line: 2, Column: 1, Message: "ci cannot be set in the config file, only with a flag or env variable"},
+		{Line: 3, Column: 1, Message: "unknown key on-unmatchd, did you mean on-unmatched?"},
+		{Line: 7, Column: 3, Message: "unknown key formatter.nix.inculdes, did you mean formatter.nix.includes?"},
+		{Line: 9, Column: 1, Message: "unknown key formater.go, did you mean formatter.go?"},
+	}, problems)
+
+	// values of the wrong type
+	problems = validate("[formatter.nix]\ncommand = \"nixfmt\"\nincludes = \"*.nix\"\n")
+	as.Len(problems, 1)
+	as.Equal(3, problems[0].Line)
+	as.Contains(problems[0].Message, "invalid value for formatter.nix.includes: incompatible types")
+
+	// syntax errors
+	problems = validate("[formatter.nix\ncommand = \"nixfmt\"\n")
+	as.Len(problems, 1)
+	as.Equal("line 1, column 15: expected '.' or ']' to end table name, but got '\\n' instead", problems[0].String())
+}
+
+func TestValidateYAMLAndJSON(t *testing.T) {
+	as := require.New(t)
+
+	validate := func(name string, contents string) []config.Problem {
+		path := filepath.Join(t.TempDir(), name)
+		as.NoError(os.WriteFile(path, []byte(contents), 0o600))
+
+		problems, err := config.Validate(path)
+		as.NoError(err)
+
+		return problems
+	}
+
+	// valid files
+	as.Empty(validate("treefmt.yaml", `excludes: ["*.md"]
+formatter:
+  nix:
+    command: nixfmt
+    includes:
+      - "*.nix"
+    priority: 1
+`))
+	as.Empty(validate("treefmt.json", `{
+	"excludes": ["*.md"],
+	"formatter": {"nix": {"command": "nixfmt", "includes": ["*.nix"], "priority": 1}}
+}`))
+
+	// unknown keys and values of the wrong type are reported with their location
+	as.Equal([]config.Problem{
+		{Line: 1, Column: 1, Message: "ci cannot be set in the config file, only with a flag or env variable"},
+		{Line: 2, Column: 1, Message: "unknown key on-unmatchd, did you mean on-unmatched?"},
+		{Line: 6, Column: 5, Message: "unknown key formatter.nix.inculdes, did you mean formatter.nix.includes?"},
+		{Line: 7, Column: 15, Message: "invalid value for formatter.nix.priority: expected an integer, got a string"},
+	}, validate("treefmt.yml", `ci: true
+on-unmatchd: info
+formatter:
+  nix:
+    command: nixfmt
+    inculdes: ["*.nix"]
+    priority: first
+`))
+
+	as.Equal([]config.Problem{
+		{Line: 1, Column: 36, Message: "invalid value for formatter.nix.includes: expected a list, got a string"},
+	}, validate("treefmt.json", `{"formatter": {"nix": {"includes": "*.nix"}}}`))
+
+	// syntax errors
+	as.Equal(
+		"line 2: mapping values are not allowed in this context",
+		validate("treefmt.yaml", "a: b\n c: d\n")[0].String(),
+	)
+	as.Equal(
+		"line 1, column 7: invalid character '}' looking for beginning of value",
+		validate("treefmt.json", `{"a": }`)[0].String(),
+	)
+}
+
+func TestSchema(t *testing.T) {
+	as := require.New(t)
+
+	schema := config.Schema()
+	as.Equal("http://json-schema.org/draft-07/schema#", schema["$schema"])
+	as.Equal(false, schema["additionalProperties"])
+
+	properties, ok := schema["properties"].(map[string]any)
+	as.True(ok)
+
+	// options which cannot be set in the config file are omitted
+	as.NotContains(properties, "ci")
+	as.NotContains(properties, "cache-dir")
+
+	// descriptions are taken from the flags, without the env variable
+	as.Equal(map[string]any{
+		"type": "integer",
+		"description": "The maximum number of files to process in each batch. Formatters are invoked once per batch, " +
+			"unless they specify a smaller batch-size of their own. Defaults to 1024.",
+	}, properties["batch-size"])
+
+	as.Contains(properties["walk"].(map[string]any)["enum"], "git")
+
+	// formatters are keyed by name
+	formatter := properties["formatter"].(map[string]any)["additionalProperties"].(map[string]any)
+	as.Equal(false, formatter["additionalProperties"])
+
+	includes := formatter["properties"].(map[string]any)["includes"].(map[string]any)
+	as.Equal("array", includes["type"])
+	as.Equal(map[string]any{"type": "string"}, includes["items"])
+}
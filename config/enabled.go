@@ -0,0 +1,54 @@
+package config
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// enabled evaluates the EnabledIf condition of the formatter called name, reporting whether it should be applied.
+// Formatters without a condition are always enabled.
+func (f *Formatter) enabled(name string, data templateData) (bool, error) {
+	if f.EnabledIf == "" {
+		return true, nil
+	}
+
+	key := "formatter." + name + ".enabled-if"
+
+	result, err := expand(key, f.EnabledIf, data)
+	if err != nil {
+		return false, err
+	}
+
+	// an empty result, e.g. from {{env "FOO"}} when FOO is not set, disables the formatter
+	result = strings.TrimSpace(result)
+	if result == "" {
+		return false, nil
+	}
+
+	enabled, err := strconv.ParseBool(result)
+	if err != nil {
+		return false, fmt.Errorf("%s must evaluate to true or false, got '%s'", key, result)
+	}
+
+	return enabled, nil
+}
+
+// disabledFormatters returns the names of the formatters whose EnabledIf condition does not hold, sorted.
+func disabledFormatters(formatters map[string]*Formatter, data templateData) ([]string, error) {
+	var disabled []string
+
+	for name, formatterCfg := range formatters {
+		enabled, err := formatterCfg.enabled(name, data)
+		if err != nil {
+			return nil, err
+		} else if !enabled {
+			disabled = append(disabled, name)
+		}
+	}
+
+	slices.Sort(disabled)
+
+	return disabled, nil
+}
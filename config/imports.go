@@ -0,0 +1,126 @@
+package config
+
+import (
+	"fmt"
+	"maps"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// configDirsKey is the key under which ResolveImports records the directory of the imported config file which set each
+// value, keyed by the value's own key, so that {{.ConfigDir}} refers to the file in which a value was set.
+const configDirsKey = "config-dirs"
+
+// ResolveImports merges the config files listed in the imports of the config file which has been read into v.
+//
+// Imports are resolved relative to the file which imports them, and may themselves contain imports. Tables are merged,
+// whilst other values are replaced: later imports take precedence over earlier ones, and a file takes precedence over
+// all of its imports. Templates in the values of an imported file are expanded relative to that file.
+func ResolveImports(v *viper.Viper) error {
+	configFile, err := filepath.Abs(v.ConfigFileUsed())
+	if err != nil {
+		return fmt.Errorf("failed to compute absolute path of %s: %w", v.ConfigFileUsed(), err)
+	}
+
+	// imports are only read from the config file, rather than from v, which may include env overrides
+	settings, dirs, imported, err := resolve(configFile, nil)
+	if err != nil || !imported {
+		return err
+	}
+
+	// v already contains the config file, whose values have been given precedence within settings
+	if err = v.MergeConfigMap(settings); err != nil {
+		return fmt.Errorf("failed to merge imports: %w", err)
+	}
+
+	// only the values set by an import need recording, as the rest are relative to the config file
+	configDirs := make(map[string]any)
+
+	for key, dir := range dirs {
+		if dir != filepath.Dir(configFile) {
+			configDirs[key] = dir
+		}
+	}
+
+	v.Set(configDirsKey, configDirs)
+
+	return nil
+}
+
+// resolve reads configFile and merges it over its imports, returning the result, the directory of the config file
+// which set each value, and whether there were any imports.
+// chain contains the files which led to configFile being imported, for detecting cycles.
+func resolve(configFile string, chain []string) (map[string]any, map[string]string, bool, error) {
+	if slices.Contains(chain, configFile) {
+		return nil, nil, false, fmt.Errorf("import cycle: %s", strings.Join(append(chain, configFile), " -> "))
+	}
+
+	chain = append(slices.Clone(chain), configFile)
+
+	file, err := read(configFile)
+	if err != nil {
+		return nil, nil, false, err
+	}
+
+	// values are replaced, rather than merged, so a value is always set by a single file
+	dirs := make(map[string]string)
+
+	imports := file.GetStringSlice("imports")
+	if len(imports) == 0 {
+		setDirs(dirs, file, configFile)
+
+		return file.AllSettings(), dirs, false, nil
+	}
+
+	merged := viper.New()
+
+	for _, name := range imports {
+		path := name
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(filepath.Dir(configFile), path)
+		}
+
+		settings, importDirs, _, err := resolve(path, chain)
+		if err != nil {
+			return nil, nil, false, fmt.Errorf("failed to import %s from %s: %w", name, configFile, err)
+		}
+
+		if err = merged.MergeConfigMap(settings); err != nil {
+			return nil, nil, false, fmt.Errorf("failed to merge %s: %w", path, err)
+		}
+
+		maps.Copy(dirs, importDirs)
+	}
+
+	// the importing file takes precedence over its imports
+	if err = merged.MergeConfigMap(file.AllSettings()); err != nil {
+		return nil, nil, false, fmt.Errorf("failed to merge %s: %w", configFile, err)
+	}
+
+	setDirs(dirs, file, configFile)
+
+	return merged.AllSettings(), dirs, true, nil
+}
+
+// setDirs records configFile's directory as the one in which each of its values was set.
+func setDirs(dirs map[string]string, file *viper.Viper, configFile string) {
+	for _, key := range file.AllKeys() {
+		dirs[key] = filepath.Dir(configFile)
+	}
+}
+
+// read reads the config file at path, in the format given by its extension.
+func read(path string) (*viper.Viper, error) {
+	v := viper.New()
+	v.SetConfigFile(path)
+	v.SetConfigType(FileType(path))
+
+	if err := v.ReadInConfig(); err != nil {
+		return nil, fmt.Errorf("failed to read config file '%s': %w", path, err)
+	}
+
+	return v, nil
+}
@@ -0,0 +1,142 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"text/template"
+)
+
+// templateData contains the variables which can be referenced when interpolating config values,
+// e.g. {{.TreeRoot}}/bin/fmt.
+type templateData struct {
+	// TreeRoot is the absolute path of the tree root.
+	TreeRoot string
+	// ConfigDir is the absolute path of the directory containing the config file in which the value was set.
+	ConfigDir string
+	// OS and Arch describe the platform treefmt is running on, e.g. linux and amd64.
+	OS   string
+	Arch string
+	// Profile is the name of the profile selected with --profile, if any.
+	Profile string
+
+	// binPaths are searched before the PATH when looking for executables, see Config.BinPaths
+	binPaths []string
+	// configDirs contains the ConfigDir of values which were set in an imported config file, keyed by their key
+	configDirs map[string]string
+}
+
+func newTemplateData(treeRoot string, configDir string, profile string) templateData {
+	return templateData{
+		TreeRoot:  treeRoot,
+		ConfigDir: configDir,
+		Profile:   profile,
+		OS:        runtime.GOOS,
+		Arch:      runtime.GOARCH,
+	}
+}
+
+// funcs returns the functions which can be called when interpolating config values, e.g. {{env "HOME"}}.
+func (d templateData) funcs() template.FuncMap {
+	return template.FuncMap{
+		// env returns the value of an environment variable, or an empty string if it is not set
+		"env": os.Getenv,
+		// isset reports whether an environment variable is set
+		"isset": func(name string) bool {
+			_, ok := os.LookupEnv(name)
+
+			return ok
+		},
+		// executable reports whether a command can be found in the bin paths or on the PATH
+		"executable": func(name string) bool {
+			for _, dir := range d.binPaths {
+				if !filepath.IsAbs(dir) {
+					dir = filepath.Join(d.TreeRoot, dir)
+				}
+
+				if _, err := exec.LookPath(filepath.Join(dir, name)); err == nil {
+					return true
+				}
+			}
+
+			_, err := exec.LookPath(name)
+
+			return err == nil
+		},
+		// exists reports whether a path, relative to the tree root, exists
+		"exists": func(path string) bool {
+			if !filepath.IsAbs(path) {
+				path = filepath.Join(d.TreeRoot, path)
+			}
+
+			_, err := os.Stat(path)
+
+			return err == nil
+		},
+	}
+}
+
+// interpolate expands the templates in the global excludes, and the command, options and excludes of each formatter.
+func interpolate(excludes []string, formatters map[string]*Formatter, data templateData) error {
+	if err := expandAll("excludes", excludes, data); err != nil {
+		return err
+	}
+
+	for name, formatter := range formatters {
+		command, err := expand("formatter."+name+".command", formatter.Command, data)
+		if err != nil {
+			return err
+		}
+
+		formatter.Command = command
+
+		if err = expandAll("formatter."+name+".options", formatter.Options, data); err != nil {
+			return err
+		} else if err = expandAll("formatter."+name+".excludes", formatter.Excludes, data); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// expandAll expands the templates in values, in place.
+func expandAll(key string, values []string, data templateData) error {
+	for i, value := range values {
+		expanded, err := expand(key, value, data)
+		if err != nil {
+			return err
+		}
+
+		values[i] = expanded
+	}
+
+	return nil
+}
+
+// expand executes value as a template, if it contains one.
+func expand(key string, value string, data templateData) (string, error) {
+	if !strings.Contains(value, "{{") {
+		return value, nil
+	}
+
+	// keys are case-insensitive, and recorded in lower case
+	if dir, ok := data.configDirs[strings.ToLower(key)]; ok {
+		data.ConfigDir = dir
+	}
+
+	tmpl, err := template.New(key).Funcs(data.funcs()).Option("missingkey=error").Parse(value)
+	if err != nil {
+		return "", fmt.Errorf(`failed to parse template in %s, a literal {{ can be written as {{"{{"}}: %w`, key, err)
+	}
+
+	var sb strings.Builder
+	if err = tmpl.Execute(&sb, data); err != nil {
+		return "", fmt.Errorf("failed to interpolate %s: %w", key, err)
+	}
+
+	return sb.String(), nil
+}
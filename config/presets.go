@@ -0,0 +1,146 @@
+package config
+
+import (
+	"fmt"
+	"maps"
+	"reflect"
+	"slices"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+var (
+	goIncludes      = []string{"*.go"}
+	haskellIncludes = []string{"*.hs"}
+	nixIncludes     = []string{"*.nix"}
+	pythonIncludes  = []string{"*.py", "*.pyi"}
+)
+
+// Presets is a catalogue of formatter definitions, keyed by name, which can be selected with the preset key of a
+// formatter. Any other keys set for the formatter override those of the preset.
+var Presets = map[string]*Formatter{
+	"alejandra": {Command: "alejandra", Includes: nixIncludes},
+	"black":     {Command: "black", Includes: pythonIncludes},
+	"clang-format": {
+		Command:  "clang-format",
+		Options:  []string{"-i"},
+		Includes: []string{"*.c", "*.h", "*.cc", "*.cpp", "*.hpp"},
+	},
+	"deadnix":            {Command: "deadnix", Options: []string{"--edit"}, Includes: nixIncludes},
+	"elm-format":         {Command: "elm-format", Options: []string{"--yes"}, Includes: []string{"*.elm"}},
+	"fourmolu":           {Command: "fourmolu", Options: []string{"--mode", "inplace"}, Includes: haskellIncludes},
+	"gofmt":              {Command: "gofmt", Options: []string{"-w"}, Includes: goIncludes},
+	"gofumpt":            {Command: "gofumpt", Options: []string{"-w"}, Includes: goIncludes},
+	"goimports":          {Command: "goimports", Options: []string{"-w"}, Includes: goIncludes},
+	"google-java-format": {Command: "google-java-format", Options: []string{"--replace"}, Includes: []string{"*.java"}},
+	"isort":              {Command: "isort", Includes: pythonIncludes},
+	"nixfmt":             {Command: "nixfmt", Includes: nixIncludes},
+	"ormolu":             {Command: "ormolu", Options: []string{"--mode", "inplace"}, Includes: haskellIncludes},
+	"prettier": {
+		Command: "prettier",
+		Options: []string{"--write"},
+		Includes: []string{
+			"*.js", "*.jsx", "*.mjs", "*.cjs", "*.ts", "*.tsx", "*.css", "*.scss", "*.html", "*.json", "*.md",
+			"*.yaml", "*.yml",
+		},
+	},
+	"ruff-check":  {Command: "ruff", Options: []string{"check", "--fix"}, Includes: pythonIncludes},
+	"ruff-format": {Command: "ruff", Options: []string{"format"}, Includes: pythonIncludes},
+	"rufo":        {Command: "rufo", Includes: []string{"*.rb"}},
+	"rustfmt":     {Command: "rustfmt", Options: []string{"--edition", "2021"}, Includes: []string{"*.rs"}},
+	"shfmt": {
+		Command:      "shfmt",
+		Options:      []string{"-w"},
+		Includes:     []string{"*.sh", "*.bash"},
+		Interpreters: []string{"sh", "bash"},
+	},
+	"stylua":    {Command: "stylua", Includes: []string{"*.lua"}},
+	"taplo":     {Command: "taplo", Options: []string{"format"}, Includes: []string{"*.toml"}},
+	"terraform": {Command: "terraform", Options: []string{"fmt"}, Includes: []string{"*.tf", "*.tfvars"}},
+	"yamlfmt":   {Command: "yamlfmt", Includes: []string{"*.yaml", "*.yml"}},
+	"zig":       {Command: "zig", Options: []string{"fmt"}, Includes: []string{"*.zig"}},
+}
+
+// PresetNames returns the names of the presets in the catalogue, sorted.
+func PresetNames() []string {
+	names := make([]string, 0, len(Presets))
+	for name := range Presets {
+		names = append(names, name)
+	}
+
+	slices.Sort(names)
+
+	return names
+}
+
+// applyPresets expands the preset of each formatter in v, with the keys which have been set for the formatter taking
+// precedence over those of the preset.
+func applyPresets(v *viper.Viper) error {
+	for name := range v.GetStringMap("formatter") {
+		key := "formatter." + name
+
+		presetName := v.GetString(key + ".preset")
+		if presetName == "" {
+			continue
+		}
+
+		preset, ok := Presets[presetName]
+		if !ok {
+			return fmt.Errorf("formatter %s has an unknown preset '%s'%s", name, presetName, suggestName(presetName, PresetNames()))
+		}
+
+		settings := presetSettings(preset)
+		maps.Copy(settings, v.GetStringMap(key))
+
+		if err := v.MergeConfigMap(map[string]any{"formatter": map[string]any{name: settings}}); err != nil {
+			return fmt.Errorf("failed to apply preset '%s' to formatter %s: %w", presetName, name, err)
+		}
+	}
+
+	return nil
+}
+
+// presetSettings converts preset into settings keyed by their name in the config file, omitting empty values.
+func presetSettings(preset *Formatter) map[string]any {
+	settings := make(map[string]any)
+
+	value := reflect.ValueOf(preset).Elem()
+
+	for i := range value.NumField() {
+		if value.Field(i).IsZero() {
+			continue
+		}
+
+		name, _, _ := strings.Cut(value.Type().Field(i).Tag.Get("mapstructure"), ",")
+
+		// lists are copied, so the catalogue cannot be modified through the config
+		if list, ok := value.Field(i).Interface().([]string); ok {
+			settings[name] = slices.Clone(list)
+		} else {
+			settings[name] = value.Field(i).Interface()
+		}
+	}
+
+	return settings
+}
+
+// suggestName returns a hint naming the candidate closest to name, allowing for typos.
+func suggestName(name string, candidates []string) string {
+	var (
+		best     string
+		bestDist = 3
+	)
+
+	for _, candidate := range candidates {
+		if dist := distance(name, candidate); dist < bestDist {
+			best, bestDist = candidate, dist
+		}
+	}
+
+	if best == "" {
+		return ""
+	}
+
+	return fmt.Sprintf(", did you mean '%s'?", best)
+}
@@ -0,0 +1,47 @@
+package config
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/spf13/viper"
+)
+
+// profileReserved are the keys which cannot be set by a profile.
+var profileReserved = []string{"imports", "profile", "profiles"}
+
+// applyProfile merges the settings of the profile selected with --profile, if any, into v.
+// A profile overrides the keys of the config file, including those of individual formatters, but not flags or env
+// variables.
+func applyProfile(v *viper.Viper) error {
+	name := v.GetString("profile")
+	if name == "" {
+		return nil
+	}
+
+	profiles := v.GetStringMap("profiles")
+	if _, ok := profiles[name]; !ok {
+		names := make([]string, 0, len(profiles))
+		for profileName := range profiles {
+			names = append(names, profileName)
+		}
+
+		slices.Sort(names)
+
+		return fmt.Errorf("unknown profile '%s'%s", name, suggestName(name, names))
+	}
+
+	settings := v.GetStringMap("profiles." + name)
+
+	for _, key := range profileReserved {
+		if _, ok := settings[key]; ok {
+			return fmt.Errorf("%s cannot be set in profile %s", key, name)
+		}
+	}
+
+	if err := v.MergeConfigMap(settings); err != nil {
+		return fmt.Errorf("failed to apply profile %s: %w", name, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,33 @@
+package config
+
+import "slices"
+
+// Runner describes how a package runner, e.g. npx, is used to invoke a formatter's command.
+type Runner struct {
+	// Args are the runner's command and the args which precede the formatter's command.
+	Args []string
+	// Installed indicates the runner will only invoke tools which are already installed within the project, in
+	// node_modules/.bin beneath the tree root, rather than downloading them.
+	Installed bool
+}
+
+// Runners is a catalogue of package runners, keyed by name, which can be selected with the runner key of a formatter.
+var Runners = map[string]*Runner{
+	"bunx":      {Args: []string{"bunx", "--no-install"}, Installed: true},
+	"npx":       {Args: []string{"npx", "--no-install"}, Installed: true},
+	"pnpm dlx":  {Args: []string{"pnpm", "dlx"}},
+	"pnpm exec": {Args: []string{"pnpm", "exec"}, Installed: true},
+	"uvx":       {Args: []string{"uvx"}},
+}
+
+// RunnerNames returns the names of the runners in the catalogue, sorted.
+func RunnerNames() []string {
+	names := make([]string, 0, len(Runners))
+	for name := range Runners {
+		names = append(names, name)
+	}
+
+	slices.Sort(names)
+
+	return names
+}
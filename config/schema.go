@@ -0,0 +1,186 @@
+package config
+
+import (
+	"reflect"
+	"regexp"
+	"strings"
+
+	"github.com/numtide/treefmt/v2/walk"
+	"github.com/spf13/pflag"
+)
+
+// envSuffix matches the reference to a flag's environment variable at the end of its usage.
+var envSuffix = regexp.MustCompile(`\s*\(env \$[A-Z_]+\)$`)
+
+// descriptions for the entries in the config file which do not have a corresponding flag, keyed by their path.
+var descriptions = map[string]string{
+	"cache":                "Configures the cache.",
+	"cache.dir":            "The directory in which to store the cache. Defaults to $XDG_CACHE_HOME/treefmt.",
+	"cache.remote":         "The URL of a cache shared between machines.",
+	"formatter":            "The formatters to apply, keyed by name.",
+	"formatter.after":      "Formatters which must be applied before this formatter to any file they both match.",
+	"formatter.batch-size": "The maximum number of files to pass to each invocation of the formatter.",
+	"formatter.command":    "The command to invoke, a builtin formatter, e.g. builtin:json, or the path of a .wasm module.",
+	"formatter.container":  "An image, e.g. ghcr.io/org/prettier:3, within which the command is run by docker or podman.",
+	"formatter.cpu-limit":  "A duration, e.g. 5m, of cpu time after which each process of the formatter is killed.",
+	"formatter.detect":     "How files are matched. If set to content, files are matched by their detected type.",
+	"formatter.encoding": "How files encoded as UTF-16 or latin-1 are handled: passed as they are, skipped, or " +
+		"converted to UTF-8 whilst the formatter is applied. Defaults to ignore.",
+	"formatter.exclusive": "Run one process at a time across all exclusive formatters, e.g. as they share a cache.",
+	"formatter.extends": "A template from the templates section, whose keys are used unless they are set for this " +
+		"formatter.",
+	"formatter.enabled-if": "A template, e.g. {{executable \"prettier\"}}, which must evaluate to true for the " +
+		"formatter to be applied.",
+	"formatter.excludes":         "Glob patterns for files which should not be passed to the formatter.",
+	"formatter.includes":         "Glob patterns for files which should be passed to the formatter.",
+	"formatter.interpreters":     "Interpreters, e.g. bash, used to match extensionless files by their shebang.",
+	"formatter.match-first-line": "A regular expression used to match files by their first line, in addition to includes.",
+	"formatter.max-file-size":    "A size, e.g. 2MB, above which files will not be passed to the formatter.",
+	"formatter.memory-limit":     "A size, e.g. 2GB, to which the memory of each process of the formatter may grow.",
+	"formatter.mode":             "Whether the formatter is passed files (the default), or run once within each project.",
+	"formatter.nice":             "A niceness, from -20 to 19, with which the formatter's processes are run.",
+	"formatter.options":          "Arguments passed to the command, before the paths of the files to format.",
+	"formatter.output":           "The level at which the formatter's output is logged whilst running. Defaults to debug.",
+	"formatter.post":             "A shell script run once formatting has finished, if the formatter was applied.",
+	"formatter.pre":              "A shell script run before the formatter is first applied.",
+	"formatter.root-markers":     "Names of files, e.g. Cargo.toml, which mark the root of a project in project mode.",
+	"formatter.preset": "A formatter from the built-in catalogue, whose command, options and includes are used " +
+		"unless they are set for this formatter.",
+	"formatter.parallel": "The number of processes across which each batch of files is split. Defaults to 1.",
+	"formatter.plugin":   "The formatter is a long-lived process which speaks the plugin protocol over stdin and stdout.",
+	"formatter.priority": "The order in which formatters which match the same file are applied, lowest first.",
+	"formatter.runner":   "A package runner, e.g. npx, used to invoke the command instead of finding it on the PATH.",
+	"formatter.sandbox":  "Restrict the formatter to writing the files it is applied to, using bubblewrap or landlock.",
+	"formatter.stage":    "A name shared by formatters which are applied concurrently, rather than one after another.",
+	"formatter.stdout":   "The formatter writes its output to stdout, instead of modifying files in place.",
+	"formatter.tags":     "Labels, e.g. fast or js, used to select groups of formatters with --tags and --skip-tags.",
+	"formatter.timeout":  "A duration, e.g. 30s, after which the formatter is killed. Overrides timeout.",
+	"formatter.types":    "Content types, e.g. json or shell, to match when detect is set to content.",
+	"formatter.version": "The version of the formatter, e.g. as pinned by a lock file, which is used in place of " +
+		"probing it with version-probe.",
+	"formatter.version-probe": "Arguments with which the command is invoked to print its version, which is included " +
+		"in the cache signature and shown by treefmt list, e.g. --version. Formatters are not probed by default.",
+	"global":          "Deprecated: use the top-level excludes instead.",
+	"global.excludes": "Deprecated: use the top-level excludes instead.",
+	"hooks":           "Shell scripts run in the tree root before and after formatting.",
+	"hooks.post":      "A shell script run once formatting has finished, whether or not it succeeded.",
+	"hooks.pre":       "A shell script run before any files are formatted. If it fails, nothing is formatted.",
+	"imports": "Other config files to merge into this one, relative to this file. " +
+		"Later imports take precedence over earlier ones, and this file takes precedence over all of them.",
+	"profiles": "Sets of options, keyed by name, which override those of the config file when selected with " +
+		"--profile.",
+	"templates": "Partial formatter definitions, keyed by name, which formatters can extend.",
+}
+
+// enums lists the allowed values for entries in the config file, keyed by their path.
+var enums = map[string][]string{
+	"color":              {"auto", "always", "never"},
+	"formatter.detect":   {"glob", "content"},
+	"formatter.encoding": {"ignore", "skip", "transcode"},
+	"formatter.mode":     {"files", "project"},
+	"formatter.output":   {"debug", "info", "never"},
+	"formatter.preset":   PresetNames(),
+	"formatter.runner":   RunnerNames(),
+	"formatter.types":    walk.ContentTypeStrings(),
+	"line-endings":       {"formatter", "preserve", "lf", "crlf"},
+	"on-unmatched":       {"debug", "info", "warn", "error", "fatal", "fail-with-list"},
+	"summary":            {"basic", "detailed"},
+	"symlinks":           walk.SymlinksStrings(),
+	"walk":               walk.TypeStrings(),
+}
+
+// Schema generates a JSON Schema describing the config file, for use with editors and validation tools.
+func Schema() map[string]any {
+	// the usage of each flag describes the corresponding entry in the config file
+	fs := pflag.NewFlagSet("schema", pflag.ContinueOnError)
+	SetFlags(fs)
+
+	schema := schemaFor(reflect.TypeOf(Config{}), "", fs)
+	schema["$schema"] = "http://json-schema.org/draft-07/schema#"
+	schema["title"] = "treefmt.toml"
+
+	return schema
+}
+
+// schemaFor generates the schema for a value of type t, found at path within the config file.
+func schemaFor(t reflect.Type, path string, fs *pflag.FlagSet) map[string]any {
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	schema := map[string]any{}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		schema["type"] = "boolean"
+	case reflect.Int:
+		schema["type"] = "integer"
+	case reflect.Uint8:
+		schema["type"] = "integer"
+		schema["minimum"] = 0
+	case reflect.String:
+		schema["type"] = "string"
+	case reflect.Slice:
+		schema["type"] = "array"
+		schema["items"] = schemaFor(t.Elem(), path, fs)
+	case reflect.Map:
+		// entries in a map share the path of the map itself, e.g. formatter.command
+		schema["type"] = "object"
+		schema["propertyNames"] = map[string]any{"pattern": "^[a-zA-Z0-9_-]+$"}
+		schema["additionalProperties"] = schemaFor(t.Elem(), path, fs)
+	case reflect.Struct:
+		properties := map[string]any{}
+
+		for i := range t.NumField() {
+			name, _, _ := strings.Cut(t.Field(i).Tag.Get("toml"), ",")
+			if name == "-" || name == "" {
+				// not allowed in the config file
+				continue
+			}
+
+			key := name
+			if path != "" {
+				key = path + "." + name
+			}
+
+			properties[name] = annotate(schemaFor(t.Field(i).Type, key, fs), key, fs)
+		}
+
+		schema["type"] = "object"
+		schema["properties"] = properties
+		schema["additionalProperties"] = false
+	}
+
+	return schema
+}
+
+// annotate adds the description and any allowed values for the entry at path to its schema.
+func annotate(schema map[string]any, path string, fs *pflag.FlagSet) map[string]any {
+	// the entries of a template are those of a formatter
+	if key, ok := strings.CutPrefix(path, "templates."); ok {
+		path = "formatter." + key
+	}
+
+	if values, ok := enums[path]; ok {
+		if items, ok := schema["items"].(map[string]any); ok {
+			items["enum"] = values
+		} else {
+			schema["enum"] = values
+		}
+	}
+
+	description, ok := descriptions[path]
+	if flag := fs.Lookup(path); !ok && flag != nil {
+		description = envSuffix.ReplaceAllString(flag.Usage, "")
+	}
+
+	if description != "" {
+		schema["description"] = description
+	}
+
+	if strings.HasPrefix(description, "Deprecated:") {
+		schema["deprecated"] = true
+	}
+
+	return schema
+}
@@ -0,0 +1,216 @@
+package config
+
+import (
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// scopeKeys are the top-level keys which are honoured in a nested config file.
+var scopeKeys = []string{"excludes", "formatter", "global", "imports", "templates"}
+
+// Scope is the config which applies to the files beneath a directory within the tree root.
+// The tree root is the root scope, described by the config file which was loaded. Subdirectories may contain their own
+// config file, which overrides or extends the config of their parent scope for the files beneath them.
+type Scope struct {
+	// Dir is the directory to which the scope applies, relative to the tree root.
+	Dir string
+	// ConfigFile is the path of the config file which describes the scope.
+	ConfigFile string
+	// Excludes are the global excludes of this scope and its parents, relative to the tree root.
+	Excludes []string
+	// FormatterConfigs are the formatters which apply within this scope, with their includes and excludes relative
+	// to the tree root. Formatters which are not changed by this scope share the config of the parent scope.
+	FormatterConfigs map[string]*Formatter
+	// Ignored lists the keys in ConfigFile which cannot be set in a nested config file.
+	Ignored []string
+	// Disabled lists the formatters set in ConfigFile whose enabled-if condition does not hold.
+	Disabled []string
+
+	// selected are the formatters selected with --formatters, which also restricts those of nested scopes
+	selected *Selection
+	// rootConfigFile is the config file of the root scope, which is never treated as a nested config file
+	rootConfigFile string
+	// profile is the profile selected with --profile, which can be referenced by templates in nested scopes
+	profile string
+	// binPaths are searched for executables by templates in nested scopes
+	binPaths []string
+}
+
+// RootScope returns the scope for the tree root, described by cfg.
+func RootScope(cfg *Config) *Scope {
+	return &Scope{
+		Dir:              ".",
+		ConfigFile:       cfg.ConfigFile,
+		Excludes:         cfg.Excludes,
+		FormatterConfigs: cfg.FormatterConfigs,
+		selected:         cfg.Selection,
+		rootConfigFile:   cfg.ConfigFile,
+		profile:          cfg.Profile,
+		binPaths:         cfg.BinPaths,
+	}
+}
+
+// Nest returns the scope for dir, a directory beneath s relative to treeRoot, if dir contains a config file.
+// It returns nil if there is no config file in dir, or if it is the config file of the root scope.
+//
+// The global excludes of the nested config file are added to those of s, and its formatters are merged with those of
+// s: keys which are set in the nested config file replace those of the formatter with the same name in s.
+// Globs in the nested config file are relative to dir.
+func (s *Scope) Nest(treeRoot string, dir string) (*Scope, error) {
+	var configFile string
+
+	for _, name := range filenames {
+		if path := filepath.Join(treeRoot, dir, name); fileExists(path) {
+			configFile = path
+
+			break
+		}
+	}
+
+	if configFile == "" || configFile == s.rootConfigFile {
+		return nil, nil
+	}
+
+	settings, configDirs, _, err := resolve(configFile, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read nested config file: %w", err)
+	}
+
+	nested := viper.New()
+	if err = nested.MergeConfigMap(settings); err != nil {
+		return nil, fmt.Errorf("failed to read nested config file %s: %w", configFile, err)
+	} else if err = applyTemplates(nested); err != nil {
+		return nil, fmt.Errorf("failed to read nested config file %s: %w", configFile, err)
+	} else if err = applyPresets(nested); err != nil {
+		return nil, fmt.Errorf("failed to read nested config file %s: %w", configFile, err)
+	}
+
+	cfg := &Config{}
+	if err = nested.Unmarshal(cfg); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal nested config file %s: %w", configFile, err)
+	}
+
+	// prefer top level excludes, falling back to global.excludes for backwards compatibility
+	if len(cfg.Excludes) == 0 {
+		cfg.Excludes = cfg.Global.Excludes
+
+		if dir, ok := configDirs["global.excludes"]; ok {
+			configDirs["excludes"] = dir
+		}
+	}
+
+	// templates are expanded relative to the nested config file, or the file it imports which set them
+	data := newTemplateData(treeRoot, filepath.Dir(configFile), s.profile)
+	data.binPaths = s.binPaths
+	data.configDirs = configDirs
+
+	if err = interpolate(cfg.Excludes, cfg.FormatterConfigs, data); err != nil {
+		return nil, fmt.Errorf("failed to read nested config file %s: %w", configFile, err)
+	}
+
+	prefix := filepath.ToSlash(dir) + "/"
+
+	result := &Scope{
+		Dir:              dir,
+		ConfigFile:       configFile,
+		Excludes:         append(slices.Clone(s.Excludes), prefixed(prefix, cfg.Excludes)...),
+		FormatterConfigs: make(map[string]*Formatter, len(s.FormatterConfigs)),
+		selected:         s.selected,
+		rootConfigFile:   s.rootConfigFile,
+		profile:          s.profile,
+		binPaths:         s.binPaths,
+	}
+
+	for key := range settings {
+		if !slices.Contains(scopeKeys, key) {
+			result.Ignored = append(result.Ignored, key)
+		}
+	}
+
+	slices.Sort(result.Ignored)
+
+	for name, formatterCfg := range s.FormatterConfigs {
+		result.FormatterConfigs[name] = formatterCfg
+	}
+
+	for name, formatterCfg := range cfg.FormatterConfigs {
+		// only the keys which have been set replace those of the parent
+		keys := nested.GetStringMap("formatter." + name)
+
+		merged := &Formatter{}
+		if parent, ok := s.FormatterConfigs[name]; ok {
+			*merged = *parent
+		}
+
+		mergeFormatter(merged, formatterCfg, keys)
+
+		if _, ok := keys["includes"]; ok {
+			merged.Includes = prefixed(prefix, formatterCfg.Includes)
+		}
+
+		if _, ok := keys["excludes"]; ok {
+			merged.Excludes = prefixed(prefix, formatterCfg.Excludes)
+		}
+
+		// respect the selection made with --formatters and --tags, which may depend on the keys set in the nested
+		// config file
+		if !s.selected.Contains(name, merged) {
+			delete(result.FormatterConfigs, name)
+
+			continue
+		}
+
+		result.FormatterConfigs[name] = merged
+	}
+
+	if err = checkCycles(result.FormatterConfigs); err != nil {
+		return nil, fmt.Errorf("failed to read nested config file %s: %w", configFile, err)
+	}
+
+	// only the formatters set in the nested config file need their condition evaluating, the others were evaluated
+	// by the parent scope
+	nestedFormatters := make(map[string]*Formatter)
+	for name := range cfg.FormatterConfigs {
+		if formatterCfg, ok := result.FormatterConfigs[name]; ok {
+			nestedFormatters[name] = formatterCfg
+		}
+	}
+
+	if result.Disabled, err = disabledFormatters(nestedFormatters, data); err != nil {
+		return nil, fmt.Errorf("failed to read nested config file %s: %w", configFile, err)
+	}
+
+	for _, name := range result.Disabled {
+		delete(result.FormatterConfigs, name)
+	}
+
+	return result, nil
+}
+
+// mergeFormatter copies the fields of src whose keys are in keys into dst.
+func mergeFormatter(dst *Formatter, src *Formatter, keys map[string]any) {
+	dstValue := reflect.ValueOf(dst).Elem()
+	srcValue := reflect.ValueOf(src).Elem()
+
+	for i := range dstValue.NumField() {
+		name, _, _ := strings.Cut(dstValue.Type().Field(i).Tag.Get("mapstructure"), ",")
+		if _, ok := keys[name]; ok {
+			dstValue.Field(i).Set(srcValue.Field(i))
+		}
+	}
+}
+
+// prefixed returns patterns with prefix prepended to each of them.
+func prefixed(prefix string, patterns []string) []string {
+	result := make([]string, len(patterns))
+	for i, pattern := range patterns {
+		result[i] = prefix + pattern
+	}
+
+	return result
+}
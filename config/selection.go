@@ -0,0 +1,161 @@
+package config
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/gobwas/glob"
+)
+
+// Selection determines which formatters are applied, based on the patterns given with --formatters.
+// Each pattern is a glob matched against the names of formatters, e.g. prettier-*, or a glob prefixed with `!`, which
+// deselects any formatters it matches, e.g. !slow-linter. If there are only negated patterns, every formatter which
+// they do not match is selected.
+// Formatters can also be selected by their tags: if any tags are given with --tags, only formatters with at least one
+// of them are selected, and formatters with any of the tags given with --skip-tags are deselected.
+// A nil Selection selects every formatter.
+type Selection struct {
+	patterns []selectionPattern
+	byName   bool
+	tags     []string
+	skipTags []string
+}
+
+type selectionPattern struct {
+	value   string
+	glob    glob.Glob
+	negated bool
+}
+
+// NewSelection compiles patterns into a Selection, along with the tags to select and skip, returning nil if there
+// are none.
+func NewSelection(patterns []string, tags []string, skipTags []string) (*Selection, error) {
+	if len(patterns) == 0 && len(tags) == 0 && len(skipTags) == 0 {
+		return nil, nil
+	}
+
+	s := &Selection{
+		tags:     tags,
+		skipTags: skipTags,
+	}
+
+	for _, value := range patterns {
+		negated := strings.HasPrefix(value, "!")
+
+		g, err := glob.Compile(strings.TrimPrefix(value, "!"))
+		if err != nil {
+			return nil, fmt.Errorf("failed to compile formatters pattern '%v': %w", value, err)
+		}
+
+		s.patterns = append(s.patterns, selectionPattern{value: value, glob: g, negated: negated})
+		s.byName = s.byName || !negated
+	}
+
+	return s, nil
+}
+
+// Contains reports whether the formatter called name, configured by formatterCfg, is selected.
+func (s *Selection) Contains(name string, formatterCfg *Formatter) bool {
+	if s == nil {
+		return true
+	}
+
+	if len(s.tags) > 0 && !hasAnyTag(formatterCfg, s.tags) {
+		return false
+	} else if hasAnyTag(formatterCfg, s.skipTags) {
+		return false
+	}
+
+	selected := !s.byName
+
+	for _, p := range s.patterns {
+		if !p.glob.Match(name) {
+			continue
+		} else if p.negated {
+			return false
+		}
+
+		selected = true
+	}
+
+	return selected
+}
+
+// ByExactName reports whether formatters were selected by their exact names, rather than with globs or only deselected
+// with negated patterns.
+func (s *Selection) ByExactName() bool {
+	if !s.ByName() {
+		return false
+	}
+
+	for _, p := range s.patterns {
+		if !p.negated && glob.QuoteMeta(p.value) != p.value {
+			return false
+		}
+	}
+
+	return true
+}
+
+// ByName reports whether formatters were selected by name or glob, rather than only deselected with negated patterns.
+func (s *Selection) ByName() bool {
+	return s != nil && s.byName
+}
+
+// filter returns those of formatters which are selected.
+// Each pattern and tag must match at least one of formatters, so a typo cannot silently select or deselect nothing.
+func (s *Selection) filter(formatters map[string]*Formatter) (map[string]*Formatter, error) {
+	if s == nil {
+		return formatters, nil
+	}
+
+	for _, p := range s.patterns {
+		found := false
+
+		for name := range formatters {
+			if found = p.glob.Match(name); found {
+				break
+			}
+		}
+
+		if !found {
+			return nil, fmt.Errorf("formatter %v not found in config", strings.TrimPrefix(p.value, "!"))
+		}
+	}
+
+	for _, tag := range slices.Concat(s.tags, s.skipTags) {
+		found := false
+
+		for _, formatterCfg := range formatters {
+			if found = slices.Contains(formatterCfg.Tags, tag); found {
+				break
+			}
+		}
+
+		if !found {
+			return nil, fmt.Errorf("no formatters are tagged %v in config", tag)
+		}
+	}
+
+	filtered := make(map[string]*Formatter)
+
+	for name, formatterCfg := range formatters {
+		if s.Contains(name, formatterCfg) {
+			filtered[name] = formatterCfg
+		}
+	}
+
+	return filtered, nil
+}
+
+// hasAnyTag reports whether formatterCfg has at least one of tags.
+func hasAnyTag(formatterCfg *Formatter, tags []string) bool {
+	for _, tag := range tags {
+		if slices.Contains(formatterCfg.Tags, tag) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,75 @@
+package config
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// applyTemplates expands the template which each formatter in v extends, with the keys which have been set for the
+// formatter taking precedence over those of the template.
+func applyTemplates(v *viper.Viper) error {
+	for name := range v.GetStringMap("formatter") {
+		key := "formatter." + name
+
+		templateName := v.GetString(key + ".extends")
+		if templateName == "" {
+			continue
+		}
+
+		settings, err := templateSettings(v, templateName, nil)
+		if err != nil {
+			return fmt.Errorf("failed to expand the template of formatter %s: %w", name, err)
+		}
+
+		maps.Copy(settings, v.GetStringMap(key))
+
+		if err := v.MergeConfigMap(map[string]any{"formatter": map[string]any{name: settings}}); err != nil {
+			return fmt.Errorf("failed to apply template '%s' to formatter %s: %w", templateName, name, err)
+		}
+	}
+
+	return nil
+}
+
+// templateSettings returns the settings of the template called name, including those of any template it extends.
+// chain contains the templates which are being expanded, and is used to detect cycles.
+func templateSettings(v *viper.Viper, name string, chain []string) (map[string]any, error) {
+	chain = append(chain, name)
+
+	if slices.Contains(chain[:len(chain)-1], name) {
+		return nil, fmt.Errorf("template cycle: %s", strings.Join(chain, " -> "))
+	}
+
+	templates := v.GetStringMap("templates")
+	if _, ok := templates[name]; !ok {
+		names := make([]string, 0, len(templates))
+		for templateName := range templates {
+			names = append(names, templateName)
+		}
+
+		slices.Sort(names)
+
+		return nil, fmt.Errorf("unknown template '%s'%s", name, suggestName(name, names))
+	}
+
+	template := v.GetStringMap("templates." + name)
+	settings := make(map[string]any)
+
+	if base := v.GetString("templates." + name + ".extends"); base != "" {
+		baseSettings, err := templateSettings(v, base, chain)
+		if err != nil {
+			return nil, err
+		}
+
+		maps.Copy(settings, baseSettings)
+	}
+
+	maps.Copy(settings, template)
+	delete(settings, "extends")
+
+	return settings, nil
+}
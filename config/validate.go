@@ -0,0 +1,295 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// Problem describes an issue found when validating a config file.
+type Problem struct {
+	// Line and Column locate the problem within the config file, starting at 1. They are 0 if the location is unknown.
+	Line    int
+	Column  int
+	Message string
+}
+
+func (p Problem) String() string {
+	switch {
+	case p.Line > 0 && p.Column > 0:
+		return fmt.Sprintf("line %d, column %d: %s", p.Line, p.Column, p.Message)
+	case p.Line > 0:
+		return fmt.Sprintf("line %d: %s", p.Line, p.Message)
+	default:
+		return p.Message
+	}
+}
+
+// decodeError matches the errors returned by toml when a value has the wrong type, which contain the line and key but
+// are not a toml.ParseError.
+var decodeError = regexp.MustCompile(`^toml: (?:line (\d+) )?\(last key "([^"]*)"\): (.*)$`)
+
+// parseMessage matches the location prefixed to the message of a toml.ParseError, which is reported separately.
+var parseMessage = regexp.MustCompile(`^toml: line \d+(?: \(last key "[^"]*"\))?: `)
+
+// Validate strictly parses the config file at path, returning any syntax errors, values of the wrong type and unknown
+// keys, which are otherwise ignored. The format of the file is determined by its extension, defaulting to TOML.
+func Validate(path string) ([]Problem, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	switch FileType(path) {
+	case "yaml":
+		return validateYAML(data, false)
+	case "json":
+		return validateYAML(data, true)
+	}
+
+	lines := strings.Split(string(data), "\n")
+
+	var cfg Config
+
+	md, err := toml.Decode(string(data), &cfg)
+
+	var parseErr toml.ParseError
+
+	switch {
+	case errors.As(err, &parseErr):
+		line, col := position(lines, parseErr.Position.Start)
+
+		return []Problem{{
+			Line:    line,
+			Column:  col,
+			Message: parseMessage.ReplaceAllString(parseErr.Error(), ""),
+		}}, nil
+	case err != nil:
+		problem := Problem{Message: strings.TrimPrefix(err.Error(), "toml: ")}
+
+		if match := decodeError.FindStringSubmatch(err.Error()); match != nil {
+			key := toml.Key(splitKey(match[2]))
+			problem.Message = fmt.Sprintf("invalid value for %s: %s", key, match[3])
+
+			if problem.Line, problem.Column = locate(lines, key); problem.Line == 0 {
+				problem.Line, _ = strconv.Atoi(match[1])
+			}
+		}
+
+		return []Problem{problem}, nil
+	}
+
+	var problems []Problem
+
+	undecoded := md.Undecoded()
+
+	for _, key := range undecoded {
+		// only report the outermost unknown key, e.g. an unknown table rather than each of its entries
+		if slices.ContainsFunc(undecoded, func(other toml.Key) bool {
+			return len(other) < len(key) && slices.Equal(other, key[:len(other)])
+		}) {
+			continue
+		}
+
+		message := fmt.Sprintf("unknown key %s", key)
+
+		if len(key) == 1 && isFlagOnly(key[0]) {
+			message = fmt.Sprintf("%s cannot be set in the config file, only with a flag or env variable", key)
+		} else if suggestion := suggest(key); suggestion != "" {
+			message += fmt.Sprintf(", did you mean %s?", suggestion)
+		}
+
+		line, col := locate(lines, key)
+
+		problems = append(problems, Problem{Line: line, Column: col, Message: message})
+	}
+
+	slices.SortStableFunc(problems, func(a, b Problem) int {
+		return a.Line - b.Line
+	})
+
+	return problems, nil
+}
+
+// position converts a byte offset into a line and column, both starting at 1.
+func position(lines []string, offset int) (int, int) {
+	for i, line := range lines {
+		if offset <= len(line) {
+			return i + 1, offset + 1
+		}
+
+		offset -= len(line) + 1
+	}
+
+	return 0, 0
+}
+
+// splitKey splits a dotted key, respecting quoted parts.
+func splitKey(key string) []string {
+	var (
+		parts   []string
+		current strings.Builder
+		quoted  bool
+	)
+
+	for _, r := range key {
+		switch {
+		case r == '"':
+			quoted = !quoted
+		case r == '.' && !quoted:
+			parts = append(parts, current.String())
+			current.Reset()
+		case quoted || (r != ' ' && r != '\t'):
+			current.WriteRune(r)
+		}
+	}
+
+	return append(parts, current.String())
+}
+
+// locate finds the line and column at which key is defined, either as a table header or as a key/value pair.
+// It returns 0, 0 if the key could not be found.
+func locate(lines []string, key toml.Key) (int, int) {
+	var table []string
+
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		indent := len(line) - len(strings.TrimLeft(line, " \t"))
+
+		switch {
+		case strings.HasPrefix(trimmed, "["):
+			header := strings.Trim(strings.SplitN(trimmed, "]", 2)[0], "[ ")
+			if header == "" {
+				continue
+			}
+
+			table = splitKey(header)
+
+			if slices.Equal(table, key) {
+				return i + 1, indent + 1
+			}
+		case strings.Contains(trimmed, "=") && !strings.HasPrefix(trimmed, "#"):
+			name, _, _ := strings.Cut(trimmed, "=")
+
+			if slices.Equal(append(slices.Clone(table), splitKey(name)...), key) {
+				return i + 1, indent + 1
+			}
+		}
+	}
+
+	return 0, 0
+}
+
+// suggest returns the known key which is closest to an unknown key, allowing for typos, or an empty string if there
+// is no such key.
+func suggest(key toml.Key) string {
+	t := reflect.TypeOf(Config{})
+
+	for i, part := range key {
+		for t.Kind() == reflect.Pointer {
+			t = t.Elem()
+		}
+
+		switch t.Kind() {
+		case reflect.Map:
+			// entries within a map are keyed by name, e.g. formatter.<name>
+			t = t.Elem()
+
+			continue
+		case reflect.Struct:
+		default:
+			return ""
+		}
+
+		if field, ok := fieldByTag(t, part); ok {
+			t = field.Type
+
+			continue
+		}
+
+		// suggest the closest match for the first part of the key which is unknown
+		var (
+			best     string
+			bestDist = 3
+		)
+
+		for j := range t.NumField() {
+			candidate, _, _ := strings.Cut(t.Field(j).Tag.Get("toml"), ",")
+			if candidate == "-" || candidate == "" {
+				continue
+			}
+
+			if dist := distance(part, candidate); dist < bestDist {
+				best, bestDist = candidate, dist
+			}
+		}
+
+		if best == "" {
+			return ""
+		}
+
+		suggestion := append(slices.Clone(key[:i]), best)
+
+		return toml.Key(append(suggestion, key[i+1:]...)).String()
+	}
+
+	return ""
+}
+
+// isFlagOnly determines whether name refers to an option which is not allowed in the config file.
+func isFlagOnly(name string) bool {
+	t := reflect.TypeOf(Config{})
+
+	for i := range t.NumField() {
+		field := t.Field(i)
+		if field.Tag.Get("mapstructure") == name && field.Tag.Get("toml") == "-" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// fieldByTag returns the field of t whose toml tag has the given name.
+func fieldByTag(t reflect.Type, name string) (reflect.StructField, bool) {
+	for i := range t.NumField() {
+		if tag, _, _ := strings.Cut(t.Field(i).Tag.Get("toml"), ","); tag == name {
+			return t.Field(i), true
+		}
+	}
+
+	return reflect.StructField{}, false
+}
+
+// distance calculates the Levenshtein distance between a and b.
+func distance(a string, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		curr := make([]int, len(b)+1)
+		curr[0] = i
+
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+
+		prev = curr
+	}
+
+	return prev[len(b)]
+}
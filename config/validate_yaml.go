@@ -0,0 +1,160 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// yamlError matches the errors returned by yaml when parsing fails, which contain the line but are not a typed error.
+var yamlError = regexp.MustCompile(`^yaml: (?:line (\d+): )?(.*)$`)
+
+// validateYAML strictly parses a YAML or JSON config file, checking it against the same schema as a TOML config file.
+// JSON is a subset of YAML, so both are parsed with yaml, which tracks the position of each key, but JSON is first
+// checked with encoding/json as yaml accepts some documents which are not valid JSON.
+func validateYAML(data []byte, isJSON bool) ([]Problem, error) {
+	if isJSON {
+		var syntaxErr *json.SyntaxError
+
+		var value any
+		if err := json.Unmarshal(data, &value); errors.As(err, &syntaxErr) {
+			line, col := position(strings.Split(string(data), "\n"), int(syntaxErr.Offset)-1)
+
+			return []Problem{{Line: line, Column: col, Message: syntaxErr.Error()}}, nil
+		} else if err != nil {
+			return []Problem{{Message: err.Error()}}, nil
+		}
+	}
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		problem := Problem{Message: strings.TrimPrefix(err.Error(), "yaml: ")}
+
+		if match := yamlError.FindStringSubmatch(err.Error()); match != nil {
+			problem.Line, _ = strconv.Atoi(match[1])
+			problem.Message = match[2]
+		}
+
+		return []Problem{problem}, nil
+	}
+
+	// an empty file is a valid, empty config
+	if len(doc.Content) == 0 {
+		return nil, nil
+	}
+
+	return validateNode(doc.Content[0], reflect.TypeOf(Config{}), nil), nil
+}
+
+// validateNode checks that node can be decoded into a value of type t, found at key within the config file.
+func validateNode(node *yaml.Node, t reflect.Type, key toml.Key) []Problem {
+	for node.Kind == yaml.AliasNode {
+		node = node.Alias
+	}
+
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	invalid := func(expected string) []Problem {
+		return []Problem{{
+			Line:    node.Line,
+			Column:  node.Column,
+			Message: fmt.Sprintf("invalid value for %s: expected %s, got %s", key, expected, describe(node)),
+		}}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		if node.Kind != yaml.ScalarNode || node.ShortTag() != "!!bool" {
+			return invalid("a boolean")
+		}
+	case reflect.Int, reflect.Uint8:
+		if node.Kind != yaml.ScalarNode || node.ShortTag() != "!!int" {
+			return invalid("an integer")
+		}
+	case reflect.String:
+		if node.Kind != yaml.ScalarNode || node.ShortTag() != "!!str" {
+			return invalid("a string")
+		}
+	case reflect.Slice:
+		if node.Kind != yaml.SequenceNode {
+			return invalid("a list")
+		}
+
+		var problems []Problem
+		for _, item := range node.Content {
+			problems = append(problems, validateNode(item, t.Elem(), key)...)
+		}
+
+		return problems
+	case reflect.Map, reflect.Struct:
+		if node.Kind != yaml.MappingNode {
+			return invalid("a table")
+		}
+
+		var problems []Problem
+
+		// content alternates between keys and their values
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			name, value := node.Content[i], node.Content[i+1]
+			path := append(key[:len(key):len(key)], name.Value)
+
+			if t.Kind() == reflect.Map {
+				problems = append(problems, validateNode(value, t.Elem(), path)...)
+
+				continue
+			}
+
+			if field, ok := fieldByTag(t, name.Value); ok {
+				problems = append(problems, validateNode(value, field.Type, path)...)
+
+				continue
+			}
+
+			message := fmt.Sprintf("unknown key %s", path)
+
+			if len(path) == 1 && isFlagOnly(path[0]) {
+				message = fmt.Sprintf("%s cannot be set in the config file, only with a flag or env variable", path)
+			} else if suggestion := suggest(path); suggestion != "" {
+				message += fmt.Sprintf(", did you mean %s?", suggestion)
+			}
+
+			problems = append(problems, Problem{Line: name.Line, Column: name.Column, Message: message})
+		}
+
+		return problems
+	}
+
+	return nil
+}
+
+// describe returns the type of node, for use in error messages.
+func describe(node *yaml.Node) string {
+	switch node.Kind {
+	case yaml.SequenceNode:
+		return "a list"
+	case yaml.MappingNode:
+		return "a table"
+	}
+
+	switch node.ShortTag() {
+	case "!!bool":
+		return "a boolean"
+	case "!!int":
+		return "an integer"
+	case "!!float":
+		return "a float"
+	case "!!null":
+		return "null"
+	default:
+		return "a string"
+	}
+}
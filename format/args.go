@@ -0,0 +1,51 @@
+package format
+
+import (
+	"github.com/numtide/treefmt/v2/walk"
+)
+
+// argSize estimates how much of the platform's command line limit is consumed by arg, including its terminating NUL
+// and the pointer to it in argv or envp.
+func argSize(arg string) int {
+	return len(arg) + 1 + 8
+}
+
+// argsSize estimates how much of the platform's command line limit is consumed by args.
+func argsSize(args []string) int {
+	size := 0
+	for _, arg := range args {
+		size += argSize(arg)
+	}
+
+	return size
+}
+
+// splitArgs splits files into chunks of at most size files, ensuring the paths in each chunk, when added to an
+// existing command line of baseSize, do not exceed limit.
+// A chunk always contains at least one file, even if its path alone exceeds the limit.
+func splitArgs(files []*walk.File, size int, baseSize int, limit int) [][]*walk.File {
+	var (
+		chunks [][]*walk.File
+		start  int
+		total  = baseSize
+	)
+
+	for idx, file := range files {
+		n := argSize(file.RelPath)
+
+		// start a new chunk if adding this file would make the current chunk too large
+		if idx > start && (idx-start == size || total+n > limit) {
+			chunks = append(chunks, files[start:idx])
+			start = idx
+			total = baseSize
+		}
+
+		total += n
+	}
+
+	if start < len(files) {
+		chunks = append(chunks, files[start:])
+	}
+
+	return chunks
+}
@@ -0,0 +1,46 @@
+//nolint:testpackage
+package format
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/numtide/treefmt/v2/walk"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSplitArgs(t *testing.T) {
+	r := require.New(t)
+
+	// each path consumes 100 bytes of the limit
+	files := make([]*walk.File, 10)
+	for i := range files {
+		files[i] = &walk.File{RelPath: strings.Repeat("a", 100-argSize(""))}
+	}
+
+	chunkSizes := func(chunks [][]*walk.File) []int {
+		sizes := make([]int, len(chunks))
+		for i, chunk := range chunks {
+			sizes[i] = len(chunk)
+		}
+
+		return sizes
+	}
+
+	// split by count only
+	r.Equal([]int{10}, chunkSizes(splitArgs(files, 10, 0, 1000)))
+	r.Equal([]int{4, 4, 2}, chunkSizes(splitArgs(files, 4, 0, 1000)))
+
+	// split by size
+	r.Equal([]int{3, 3, 3, 1}, chunkSizes(splitArgs(files, 10, 0, 399)))
+	r.Equal([]int{2, 2, 2, 2, 2}, chunkSizes(splitArgs(files, 10, 150, 399)))
+
+	// whichever is smallest wins
+	r.Equal([]int{2, 2, 2, 2, 2}, chunkSizes(splitArgs(files, 2, 0, 399)))
+
+	// paths which exceed the limit on their own are still passed, one at a time
+	r.Equal([]int{1, 1, 1, 1, 1, 1, 1, 1, 1, 1}, chunkSizes(splitArgs(files, 10, 1000, 500)))
+
+	// nothing to split
+	r.Empty(splitArgs(nil, 10, 0, 1000))
+}
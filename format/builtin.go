@@ -0,0 +1,143 @@
+package format
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	gofmt "go/format"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// builtinPrefix is the prefix of a Command which refers to one of the builtin formatters, e.g. builtin:json.
+const builtinPrefix = "builtin:"
+
+// builtinFunc formats the content of a file, returning the result.
+type builtinFunc func(content []byte) ([]byte, error)
+
+// builtins are formatters which are compiled into treefmt, so they can be used without installing anything, keyed by
+// name.
+var builtins = map[string]builtinFunc{
+	"final-newline":   formatFinalNewline,
+	"gofmt":           formatGo,
+	"json":            formatJSON,
+	"toml":            formatTOML,
+	"trim-whitespace": formatTrimWhitespace,
+}
+
+// BuiltinNames returns the names of the builtin formatters in lexicographic order.
+func BuiltinNames() []string {
+	names := make([]string, 0, len(builtins))
+	for name := range builtins {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+// isBuiltin reports whether command refers to a builtin formatter, rather than an executable.
+func isBuiltin(command string) bool {
+	return strings.HasPrefix(command, builtinPrefix)
+}
+
+// trailingWhitespace matches the spaces and tabs at the end of each line, before any carriage return.
+var trailingWhitespace = regexp.MustCompile(`(?m)[ \t]+(\r?)$`)
+
+// formatTrimWhitespace removes trailing whitespace from each line.
+func formatTrimWhitespace(content []byte) ([]byte, error) {
+	return trailingWhitespace.ReplaceAll(content, []byte("$1")), nil
+}
+
+// formatFinalNewline ensures non-empty content ends with a newline, using a carriage return as well if the content
+// contains any.
+func formatFinalNewline(content []byte) ([]byte, error) {
+	if len(content) == 0 || bytes.HasSuffix(content, []byte("\n")) {
+		return content, nil
+	}
+
+	if bytes.Contains(content, []byte("\r\n")) {
+		return append(content, '\r', '\n'), nil
+	}
+
+	return append(content, '\n'), nil
+}
+
+// formatJSON indents JSON with two spaces, preserving the order of keys.
+func formatJSON(content []byte) ([]byte, error) {
+	var out bytes.Buffer
+	if err := json.Indent(&out, bytes.TrimSpace(content), "", "  "); err != nil {
+		return nil, fmt.Errorf("invalid JSON: %w", err)
+	}
+
+	out.WriteByte('\n')
+
+	return out.Bytes(), nil
+}
+
+// formatTOML re-encodes TOML in a canonical form, with keys sorted within each table.
+// As comments cannot be preserved, content which contains any is rejected rather than losing them.
+func formatTOML(content []byte) ([]byte, error) {
+	var value map[string]any
+	if _, err := toml.Decode(string(content), &value); err != nil {
+		return nil, fmt.Errorf("invalid TOML: %w", err)
+	}
+
+	if hasTOMLComment(content) {
+		return nil, errors.New("TOML containing comments cannot be formatted without losing them")
+	}
+
+	var out bytes.Buffer
+
+	encoder := toml.NewEncoder(&out)
+	encoder.Indent = ""
+
+	if err := encoder.Encode(value); err != nil {
+		return nil, fmt.Errorf("failed to encode TOML: %w", err)
+	}
+
+	return out.Bytes(), nil
+}
+
+// hasTOMLComment reports whether valid TOML content contains a comment, ignoring any # within strings.
+func hasTOMLComment(content []byte) bool {
+	var quote string
+
+	for i := 0; i < len(content); i++ {
+		rest := content[i:]
+
+		switch {
+		case quote != "":
+			if content[i] == '\\' && quote[0] == '"' {
+				i++ // skip the escaped character
+			} else if bytes.HasPrefix(rest, []byte(quote)) {
+				i += len(quote) - 1
+				quote = ""
+			}
+		case bytes.HasPrefix(rest, []byte(`"""`)), bytes.HasPrefix(rest, []byte(`'''`)):
+			quote = string(rest[:3])
+			i += 2
+		case content[i] == '"' || content[i] == '\'':
+			quote = string(content[i])
+		case content[i] == '#':
+			return true
+		}
+	}
+
+	return false
+}
+
+// formatGo formats Go source in the same way as gofmt.
+func formatGo(content []byte) ([]byte, error) {
+	out, err := gofmt.Source(content)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Go source: %w", err)
+	}
+
+	return out, nil
+}
@@ -0,0 +1,48 @@
+//nolint:testpackage
+package format
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuiltins(t *testing.T) {
+	r := require.New(t)
+
+	for _, tc := range []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{"trim-whitespace", "foo  \nbar\t\r\nbaz \n", "foo\nbar\r\nbaz\n"},
+		{"trim-whitespace", "  indented\n", "  indented\n"},
+		{"final-newline", "foo", "foo\n"},
+		{"final-newline", "foo\r\nbar", "foo\r\nbar\r\n"},
+		{"final-newline", "foo\n", "foo\n"},
+		{"final-newline", "", ""},
+		{"json", `{"b": 1, "a": [1,2]}`, "{\n  \"b\": 1,\n  \"a\": [\n    1,\n    2\n  ]\n}\n"},
+		{"json", "{\n    \"a\": 1\n}\n", "{\n  \"a\": 1\n}\n"},
+		{"toml", "b = 1\na = \"#1\"\n[t]\nx=2\n", "a = \"#1\"\nb = 1\n\n[t]\nx = 2\n"},
+		{"gofmt", "package main\nfunc main() {\n}\n", "package main\n\nfunc main() {\n}\n"},
+	} {
+		output, err := builtins[tc.name]([]byte(tc.input))
+		r.NoError(err, tc.name)
+		r.Equal(tc.expected, string(output), tc.name)
+	}
+
+	for name, input := range map[string]string{
+		"json":  "{",
+		"toml":  "a =",
+		"gofmt": "package",
+	} {
+		_, err := builtins[name]([]byte(input))
+		r.ErrorContains(err, "invalid", name)
+	}
+
+	// comments would be lost, unless they are within strings
+	_, err := builtins["toml"]([]byte("a = 1 # comment\n"))
+	r.ErrorContains(err, "cannot be formatted without losing them")
+
+	r.False(hasTOMLComment([]byte("a = '#'\nb = \"\\\"#\"\nc = \"\"\"\n#\n\"\"\"\n")))
+}
@@ -1,18 +1,24 @@
 package format
 
 import (
+	"cmp"
 	"context"
 	"crypto/sha256"
 	"errors"
 	"fmt"
-	"os"
+	"maps"
+	"runtime"
 	"slices"
+	"strings"
+	"time"
 
 	"github.com/charmbracelet/log"
 	"github.com/gobwas/glob"
 	"github.com/numtide/treefmt/v2/config"
 	"github.com/numtide/treefmt/v2/stats"
 	"github.com/numtide/treefmt/v2/walk"
+	"github.com/numtide/treefmt/v2/walk/cache"
+	"golang.org/x/sync/semaphore"
 	"mvdan.cc/sh/v3/expand"
 )
 
@@ -20,7 +26,14 @@ const (
 	batchKeySeparator = ":"
 )
 
-var ErrFormattingFailures = errors.New("formatting failures detected")
+var (
+	ErrFormattingFailures = errors.New("formatting failures detected")
+	ErrTooManyChanges     = errors.New("more files would be formatted than --max-changes allows, use --yes to continue")
+)
+
+// OnUnmatchedFailWithList is the on-unmatched value which, rather than logging each path which did not match any
+// formatter, collects them so they can be listed together once formatting has completed.
+const OnUnmatchedFailWithList = "fail-with-list"
 
 // CompositeFormatter handles the application of multiple Formatter instances based on global excludes and individual
 // formatter configuration.
@@ -28,33 +41,157 @@ type CompositeFormatter struct {
 	cfg            *config.Config
 	stats          *stats.Stats
 	globalExcludes []glob.Glob
+	// include restricts the run to matching paths, and is only set if requested with --include
+	include     []glob.Glob
+	maxFileSize int64
 
 	unmatchedLevel log.Level
+	// collectUnmatched indicates unmatched paths are recorded in the stats instead of being logged.
+	collectUnmatched bool
 
 	scheduler  *scheduler
 	formatters map[string]*Formatter
+
+	// state used when creating formatters for nested config files
+	env       expand.Environ
+	jobSlots  *semaphore.Weighted
+	exclusive *semaphore.Weighted
+	locks     *pathLocks
+	timeout   time.Duration
+	sandbox   *sandbox
+
+	// scopes caches the scope which applies to each directory, keyed by its path relative to the tree root
+	scopes map[string]*scope
+
+	// closed indicates Close has been called since the CompositeFormatter was created or last reset
+	closed bool
 }
 
 // match filters the file against global excludes and returns a list of formatters that want to process the file.
-func (c *CompositeFormatter) match(file *walk.File) (bool, []*Formatter) {
-	// first check if this file has been globally excluded
-	if pathMatches(file.RelPath, c.globalExcludes) {
+// It also reports whether the file was skipped because it exceeded the maximum file size, either globally or for every
+// formatter which wanted it.
+// The global excludes and formatters are those of the scope containing the file, unless formatting stdin with
+// formatters named with --formatters, in which case every selected formatter is returned.
+func (c *CompositeFormatter) match(file *walk.File) (bool, bool, []*Formatter, error) {
+	s, err := c.scopeFor(file.RelPath)
+	if err != nil {
+		return false, false, nil, err
+	}
+
+	// when formatting stdin with formatters named on the command line, they are applied regardless of the path
+	if c.cfg.StdinByName {
+		return false, false, sortedByName(s.formatters), nil
+	}
+
+	// skip files which were not requested with --include, as if they had been globally excluded
+	if len(c.include) > 0 && !pathMatches(file.RelPath, c.include) {
+		log.Debugf("path did not match --include: %s", file.RelPath)
+
+		return true, false, nil, nil
+	}
+
+	// next check if this file has been globally excluded
+	if pathMatches(file.RelPath, s.excludes) {
 		log.Debugf("path matched global excludes: %s", file.RelPath)
 
-		return true, nil
+		return true, false, nil, nil
 	}
 
-	// a list of formatters that match this file
-	var matches []*Formatter
+	// next check if the file exceeds the global size limit
+	if exceedsSize(file, c.maxFileSize) {
+		log.Infof("skipping %s as it exceeds the max file size", file.RelPath)
+
+		return false, true, nil, nil
+	}
+
+	var (
+		// a list of formatters that match this file
+		matches []*Formatter
+		// a list of formatters that match this file, but for which it is too large
+		tooLarge []string
+	)
 
 	// iterate the formatters, recording which are interested in this file
-	for _, formatter := range c.formatters {
-		if formatter.Wants(file) {
+	for _, formatter := range s.formatters {
+		if wanted, _ := formatter.Wants(file); !wanted {
+			continue
+		} else if formatter.TooLarge(file) {
+			tooLarge = append(tooLarge, formatter.Name())
+		} else {
 			matches = append(matches, formatter)
 		}
 	}
 
-	return false, matches
+	if len(tooLarge) > 0 {
+		log.Infof("skipping %s for formatters %v as it exceeds their max file size", file.RelPath, tooLarge)
+	}
+
+	return false, len(matches) == 0 && len(tooLarge) > 0, matches, nil
+}
+
+// Formatters returns the formatters of the root config file which are available, sorted by name.
+// Formatters whose command could not be found are omitted if missing formatters are allowed.
+func (c *CompositeFormatter) Formatters() []*Formatter {
+	return sortedByName(c.formatters)
+}
+
+// Signatures returns the signatures of a sequence of formatters, named as they are recorded in the cache, which were
+// applied to the file at relPath: that used by the local cache, and a portable one which is the same on every machine
+// with the same config and versions of the formatters. Nil signatures are returned if any of the formatters is no
+// longer available.
+func (c *CompositeFormatter) Signatures(relPath string, names []string) ([]byte, []byte, error) {
+	s, err := c.scopeFor(relPath)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	formatters := make([]*Formatter, len(names))
+
+	for i, name := range names {
+		// formatters of nested config files are qualified by their scope
+		unqualified, _, _ := strings.Cut(name, "@")
+
+		f, ok := s.formatters[unqualified]
+		if !ok || f.Name() != name {
+			return nil, nil, nil
+		}
+
+		formatters[i] = f
+	}
+
+	local, err := sequenceSignature(formatters, c.scheduler.lineEndings)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return local, portableSignature(formatters, c.scheduler.lineEndings), nil
+}
+
+// sortedByName returns the values of formatters, sorted by name.
+func sortedByName(formatters map[string]*Formatter) []*Formatter {
+	result := make([]*Formatter, 0, len(formatters))
+	for _, f := range formatters {
+		result = append(result, f)
+	}
+
+	slices.SortFunc(result, func(a, b *Formatter) int {
+		return cmp.Compare(a.Name(), b.Name())
+	})
+
+	return result
+}
+
+// Match returns the formatters which would be applied to file, in the order they would be applied.
+// No formatters are returned if file is globally excluded or too large to be formatted.
+func (c *CompositeFormatter) Match(file *walk.File) ([]*Formatter, error) {
+	_, _, matches, err := c.match(file)
+	if err != nil {
+		return nil, err
+	}
+
+	sortFormatters(matches)
+
+	return matches, nil
 }
 
 // Apply applies the configured formatters to the given files.
@@ -63,22 +200,37 @@ func (c *CompositeFormatter) Apply(ctx context.Context, files []*walk.File) erro
 
 	for _, file := range files {
 		// match the file against the formatters
-		globalExclude, matches := c.match(file)
+		globalExclude, skipped, matches, err := c.match(file)
+		if err != nil {
+			return err
+		}
 
 		// if the file is globally excluded, we do not emit a warning
 		if globalExclude {
 			continue
 		}
 
+		// if the file was too large to be formatted, record it was skipped and release it
+		if skipped {
+			c.stats.Add(stats.Skipped, 1)
+
+			toRelease = append(toRelease, file)
+
+			continue
+		}
+
 		// check if there were no matches
 		if len(matches) == 0 {
-			// log that there was no match, exiting with an error if the unmatched level was set to fatal
-			if c.unmatchedLevel == log.FatalLevel {
+			// collect or log that there was no match, exiting with an error if the unmatched level was set to fatal
+			switch {
+			case c.collectUnmatched:
+				c.stats.RecordUnmatched(file.RelPath)
+			case c.unmatchedLevel == log.FatalLevel:
 				return fmt.Errorf("no formatter for path: %s", file.RelPath)
+			default:
+				log.Logf(c.unmatchedLevel, "no formatter for path: %s", file.RelPath)
 			}
 
-			log.Logf(c.unmatchedLevel, "no formatter for path: %s", file.RelPath)
-
 			// no further processing to be done, append to the release list
 			toRelease = append(toRelease, file)
 
@@ -86,6 +238,18 @@ func (c *CompositeFormatter) Apply(ctx context.Context, files []*walk.File) erro
 			continue
 		}
 
+		// formatters may mangle or choke on the content of files with unresolved merge conflicts, e.g. during a rebase
+		if conflicted, err := file.HasConflictMarkers(); err != nil {
+			return err
+		} else if conflicted {
+			log.Warnf("skipping %s as it contains unresolved merge conflicts", file.RelPath)
+			c.stats.Add(stats.Skipped, 1)
+
+			toRelease = append(toRelease, file)
+
+			continue
+		}
+
 		// record there was a match
 		c.stats.Add(stats.Matched, 1)
 
@@ -134,12 +298,75 @@ func (c *CompositeFormatter) signature() (signature, error) {
 	return h.Sum(nil), nil
 }
 
+// ConfirmFunc is called with the number of files each formatter would be applied to, keyed by the formatter's name,
+// once every file has been matched but before any are formatted. It returns the names of any formatters which should
+// not be applied, or an error if formatting should be aborted.
+type ConfirmFunc func(counts map[string]int) ([]string, error)
+
+// Confirm sets a function with which the formatters to be applied are confirmed before any files are formatted. As
+// every file must be matched first, no files are formatted until the CompositeFormatter is closed.
+func (c *CompositeFormatter) Confirm(fn ConfirmFunc) {
+	c.scheduler.confirm = fn
+}
+
 // Close finalizes the processing of the CompositeFormatter, ensuring that any remaining batches are applied and
 // all formatters have completed their tasks. It returns an error if any formatting failures were detected.
 func (c *CompositeFormatter) Close(ctx context.Context) error {
+	c.closed = true
+
 	return c.scheduler.close(ctx)
 }
 
+// Reset prepares the CompositeFormatter to be applied again, recording the outcome in statz. This allows a long-lived
+// process, such as the daemon, to create the formatters once, rather than for each run.
+func (c *CompositeFormatter) Reset(statz *stats.Stats) error {
+	// a run which failed part way through was never closed, so we wait for the batches it scheduled to finish, and shut
+	// down any plugins it started
+	if !c.closed {
+		_ = c.scheduler.eg.Wait()
+
+		for _, f := range c.scheduler.formatters {
+			if err := f.close(context.Background()); err != nil {
+				log.Warnf("%v", err)
+			}
+		}
+
+		if c.sandbox != nil {
+			if err := c.sandbox.remove(); err != nil {
+				log.Warnf("%v", err)
+			}
+		}
+	}
+
+	c.closed = false
+	c.stats = statz
+
+	// nested config files may have changed since the last run, so only the root scope is kept
+	c.scopes = map[string]*scope{".": c.scopes["."]}
+
+	for _, f := range c.formatters {
+		f.reset()
+	}
+
+	// the sandbox is removed once formatting is complete, so each run needs a new one
+	if c.cfg.Check {
+		sandbox, err := newSandbox(c.cfg.TreeRoot)
+		if err != nil {
+			return err
+		}
+
+		c.sandbox = sandbox
+
+		for _, f := range c.formatters {
+			f.workingDir = sandbox.dir
+		}
+	}
+
+	c.scheduler.reset(statz, c.sandbox, maps.Clone(c.formatters))
+
+	return nil
+}
+
 func NewCompositeFormatter(
 	cfg *config.Config,
 	statz *stats.Stats,
@@ -151,10 +378,64 @@ func NewCompositeFormatter(
 		return nil, fmt.Errorf("failed to compile global excludes: %w", err)
 	}
 
-	// parse unmatched log level
-	unmatchedLevel, err := log.ParseLevel(cfg.OnUnmatched)
+	// compile the globs of --include
+	include, err := compileGlobs(cfg.Include)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compile include: %w", err)
+	}
+
+	// parse the global max file size
+	maxFileSize, err := ParseSize(cfg.MaxFileSize)
 	if err != nil {
-		return nil, fmt.Errorf("invalid on-unmatched value: %w", err)
+		return nil, fmt.Errorf("invalid max-file-size value: %w", err)
+	}
+
+	// parse the global timeout
+	var timeout time.Duration
+	if cfg.Timeout != "" {
+		if timeout, err = time.ParseDuration(cfg.Timeout); err != nil {
+			return nil, fmt.Errorf("invalid timeout value: %w", err)
+		}
+	}
+
+	// the configured batch size takes precedence over the default
+	if cfg.BatchSize < 0 {
+		return nil, fmt.Errorf("invalid batch-size value: must not be negative, got %d", cfg.BatchSize)
+	} else if cfg.BatchSize > 0 {
+		batchSize = cfg.BatchSize
+	}
+
+	// default to running one formatter process per cpu
+	jobs := cfg.Jobs
+	if jobs < 0 {
+		return nil, fmt.Errorf("invalid jobs value: must not be negative, got %d", jobs)
+	} else if jobs == 0 {
+		jobs = runtime.NumCPU()
+	}
+
+	// parse unmatched log level, unless unmatched paths are to be collected
+	collectUnmatched := cfg.OnUnmatched == OnUnmatchedFailWithList
+
+	var unmatchedLevel log.Level
+
+	if !collectUnmatched {
+		if unmatchedLevel, err = log.ParseLevel(cfg.OnUnmatched); err != nil {
+			return nil, fmt.Errorf("invalid on-unmatched value: %w", err)
+		}
+	}
+
+	// line endings are left to the formatters by default
+	lineEndings := cfg.LineEndings
+
+	switch lineEndings {
+	case "":
+		lineEndings = LineEndingsFormatter
+	case LineEndingsFormatter, LineEndingsPreserve, LineEndingsLF, LineEndingsCRLF:
+	default:
+		return nil, fmt.Errorf(
+			"invalid line-endings value: %s, must be one of <%s|%s|%s|%s>", cfg.LineEndings,
+			LineEndingsFormatter, LineEndingsPreserve, LineEndingsLF, LineEndingsCRLF,
+		)
 	}
 
 	// create a composite formatter, adjusting the change logging based on --fail-on-change
@@ -163,36 +444,149 @@ func NewCompositeFormatter(
 		changeLevel = log.ErrorLevel
 	}
 
+	c := &CompositeFormatter{
+		cfg:              cfg,
+		stats:            statz,
+		globalExcludes:   globalExcludes,
+		include:          include,
+		maxFileSize:      maxFileSize,
+		unmatchedLevel:   unmatchedLevel,
+		collectUnmatched: collectUnmatched,
+
+		// formatters share a semaphore which limits how many formatter processes can run at once, and locks which
+		// prevent them from writing to the same file at once
+		env:       lookupEnv(cfg),
+		jobSlots:  semaphore.NewWeighted(int64(jobs)),
+		exclusive: semaphore.NewWeighted(1),
+		locks:     newPathLocks(),
+		timeout:   timeout,
+	}
+
+	for _, name := range cfg.DisabledFormatters {
+		log.Debugf("formatter %v is disabled by its enabled-if condition", name)
+	}
+
 	// create formatters
 	formatters := make(map[string]*Formatter)
 
-	env := expand.ListEnviron(os.Environ()...)
-
 	for name, formatterCfg := range cfg.FormatterConfigs {
-		formatter, err := newFormatter(name, cfg.TreeRoot, env, formatterCfg)
+		formatter, err := c.newFormatter(name, formatterCfg)
+		if err != nil {
+			return nil, err
+		} else if formatter != nil {
+			// store formatter by name
+			formatters[name] = formatter
+		}
+	}
 
-		if errors.Is(err, ErrCommandNotFound) && cfg.AllowMissingFormatter {
-			log.Debugf("formatter command not found: %v", name)
+	if err = checkStages(formatters); err != nil {
+		return nil, err
+	}
 
-			continue
-		} else if err != nil {
-			return nil, fmt.Errorf("failed to initialise formatter %v: %w", name, err)
+	// in check mode, formatters are run within a sandbox so the tree is never modified
+	if cfg.Check {
+		if c.sandbox, err = newSandbox(cfg.TreeRoot); err != nil {
+			return nil, err
 		}
 
-		// store formatter by name
-		formatters[name] = formatter
+		for _, formatter := range formatters {
+			formatter.workingDir = c.sandbox.dir
+		}
+	}
+
+	// the limit protects the tree from being rewritten, so it does not apply when the tree will not be modified, or
+	// when the user has confirmed they want it to be
+	if cfg.MaxChanges < 0 {
+		return nil, fmt.Errorf("invalid max-changes value: must not be negative, got %d", cfg.MaxChanges)
+	}
+
+	maxChanges := cfg.MaxChanges
+	if cfg.Yes || cfg.Check || cfg.DryRun {
+		maxChanges = 0
+	}
+
+	// diffs are only of interest when changes are considered a failure
+	diff := cfg.FailOnChange && (cfg.Diff || cfg.PatchFile != "")
+
+	// share formatting results with other machines if a remote cache has been configured
+	var remote cache.Backend
+
+	if cfg.CacheRemote != "" {
+		if remote, err = cache.NewBackend(cfg.CacheRemote); err != nil {
+			return nil, fmt.Errorf("invalid remote cache: %w", err)
+		}
 	}
 
 	// create a scheduler for carrying out the actual formatting
-	scheduler := newScheduler(statz, batchSize, changeLevel, formatters)
+	c.scheduler = newScheduler(
+		statz, batchSize, jobs, cfg.KeepGoing, cfg.DryRun, diff, cfg.DetectConflicts, c.sandbox, remote, changeLevel,
+		lineEndings, maxChanges, maps.Clone(formatters),
+	)
+	c.formatters = formatters
+
+	// the root scope applies to the whole tree, unless overridden by nested config files
+	c.scopes = map[string]*scope{
+		".": {
+			config:     config.RootScope(cfg),
+			excludes:   globalExcludes,
+			formatters: formatters,
+		},
+	}
+
+	return c, nil
+}
 
-	return &CompositeFormatter{
-		cfg:            cfg,
-		stats:          statz,
-		globalExcludes: globalExcludes,
-		unmatchedLevel: unmatchedLevel,
+// newFormatter creates a formatter, applying the global timeout and check mode.
+// It returns nil if the formatter's command could not be found and missing formatters are allowed.
+func (c *CompositeFormatter) newFormatter(name string, formatterCfg *config.Formatter) (*Formatter, error) {
+	formatter, err := newFormatter(name, c.cfg.TreeRoot, c.env, c.cfg.ContainerEngine, formatterCfg)
+
+	if errors.Is(err, ErrCommandNotFound) && c.cfg.AllowMissingFormatter {
+		log.Debugf("formatter command not found: %v", name)
+
+		return nil, nil
+	} else if errors.Is(err, ErrNotInstalled) && c.cfg.AllowMissingFormatter {
+		// unlike a missing command, this usually means the project's dependencies have not been installed
+		log.Warnf("skipping formatter %v: %v", name, err)
+
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to initialise formatter %v: %w", name, err)
+	}
+
+	// formatters run once per project can modify any file within it, rather than only the copies in the check sandbox,
+	// or the temporary file holding stdin
+	if formatterCfg.Mode == ModeProject && (c.cfg.Check || c.cfg.Stdin) {
+		log.Warnf("skipping formatter %v: it is run once per project, so cannot be used with --check or --stdin", name)
+
+		return nil, nil
+	}
+
+	formatter.jobs = c.jobSlots
+	formatter.locks = c.locks
+
+	// the processes of exclusive formatters are run one at a time, whilst other formatters run alongside them
+	if formatterCfg.Exclusive {
+		formatter.exclusive = c.exclusive
+	}
+
+	// hooks could otherwise modify the tree
+	formatter.hooks = !c.cfg.Check && !c.cfg.DryRun
+
+	// apply the global timeout to formatters which don't specify their own
+	if formatter.timeout == 0 {
+		formatter.timeout = c.timeout
+	}
+
+	// formatters created for nested config files after the sandbox must also use it
+	if c.sandbox != nil {
+		formatter.workingDir = c.sandbox.dir
+	}
+
+	// the version is only needed for the cache signatures, and no-cache only disables the local cache
+	if !c.cfg.NoCache || c.cfg.CacheRemote != "" {
+		formatter.probeVersion()
+	}
 
-		scheduler:  scheduler,
-		formatters: formatters,
-	}, nil
+	return formatter, nil
 }
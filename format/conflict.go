@@ -0,0 +1,102 @@
+package format
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"os"
+
+	"github.com/numtide/treefmt/v2/stats"
+	"github.com/numtide/treefmt/v2/walk"
+)
+
+// stageTracker hashes the content of a batch of files between each stage of formatters in a sequence, allowing us to
+// determine which formatters modified each file, and whether any of them undid the changes of another.
+type stageTracker struct {
+	files []*walk.File
+	// names contains the formatters of each stage which has been applied so far, in order
+	names [][]string
+	// hashes contains the hash of each file before the first stage was applied, and after each subsequent stage
+	hashes [][][sha256.Size]byte
+}
+
+// record hashes the content of each file after the named formatters, which form a stage, have been applied.
+// As the formatters of a stage are applied concurrently, a change is attributed to all of them.
+func (t *stageTracker) record(names ...string) error {
+	t.names = append(t.names, names)
+
+	return t.hash()
+}
+
+func (t *stageTracker) hash() error {
+	for i, file := range t.files {
+		content, err := os.ReadFile(file.Path)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", file.Path, err)
+		}
+
+		t.hashes[i] = append(t.hashes[i], sha256.Sum256(content))
+	}
+
+	return nil
+}
+
+// conflicts returns a Conflict for each file which was modified by more than one stage.
+func (t *stageTracker) conflicts() []stats.Conflict {
+	var result []stats.Conflict
+
+	for i, file := range t.files {
+		hashes := t.hashes[i]
+
+		var (
+			modifiedBy []string
+			stages     int
+		)
+
+		for stage, names := range t.names {
+			if hashes[stage] != hashes[stage+1] {
+				modifiedBy = append(modifiedBy, names...)
+				stages++
+			}
+		}
+
+		if stages < 2 {
+			continue
+		}
+
+		result = append(result, stats.Conflict{
+			Path:        file.RelPath,
+			Formatters:  modifiedBy,
+			Oscillating: oscillates(hashes),
+		})
+	}
+
+	return result
+}
+
+// oscillates reports whether a file's content returned to an earlier state after being modified.
+func oscillates(hashes [][sha256.Size]byte) bool {
+	for i := range hashes {
+		for j := i + 1; j < len(hashes); j++ {
+			// the content changed after stage i, but stage j restored it
+			if hashes[j] == hashes[i] && hashes[i+1] != hashes[i] {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func newStageTracker(files []*walk.File) (*stageTracker, error) {
+	t := &stageTracker{
+		files:  files,
+		hashes: make([][][sha256.Size]byte, len(files)),
+	}
+
+	// hash the content before any formatter has been applied
+	if err := t.hash(); err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
@@ -0,0 +1,109 @@
+package format
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"mvdan.cc/sh/v3/expand"
+)
+
+const (
+	ContainerEngineDocker = "docker"
+	ContainerEnginePodman = "podman"
+)
+
+// ContainerEngines are the engines with which a formatter can be run within a container, in the order in which they
+// are searched for on the PATH if none is configured.
+var ContainerEngines = []string{ContainerEngineDocker, ContainerEnginePodman}
+
+// container describes the image within which a formatter's command is run, and the engine used to run it.
+type container struct {
+	engine string
+	image  string
+	// root is the tree root, which is also mounted if the formatter is run within a sandbox, as the sandbox links back
+	// to files within the tree
+	root string
+}
+
+// useContainer resolves the engine used to run a formatter whose Command is run within a container image.
+// The Command is found within the image rather than on the PATH, so it cannot be invoked with a runner.
+func (f *Formatter) useContainer(treeRoot string, env expand.Environ, engine string) error {
+	switch {
+	case f.config.Runner != "":
+		return fmt.Errorf("formatter '%v' is run within a container, so cannot have a runner", f.name)
+	case engine != "" && !slices.Contains(ContainerEngines, engine):
+		return fmt.Errorf("invalid container-engine '%s', must be one of <%s>",
+			engine, strings.Join(ContainerEngines, "|"))
+	}
+
+	engines := ContainerEngines
+	if engine != "" {
+		engines = []string{engine}
+	}
+
+	for _, name := range engines {
+		executable, err := lookPath(treeRoot, env, name)
+		if err != nil {
+			continue
+		}
+
+		f.executable = executable
+		f.args = f.config.Options
+		f.container = &container{engine: name, image: f.config.Container, root: treeRoot}
+
+		return nil
+	}
+
+	return fmt.Errorf("%w: container engine %s", ErrCommandNotFound, strings.Join(engines, " or "))
+}
+
+// command returns the executable and args with which to invoke the formatter from dir, running it within its container
+// if it has one.
+func (f *Formatter) command(dir string, args []string) (string, []string) {
+	if f.container == nil {
+		return f.executable, args
+	}
+
+	return f.executable, f.container.wrap(dir, slices.Concat([]string{f.config.Command}, args))
+}
+
+// wrap returns the args with which the engine runs args within the container, with dir as the working directory.
+// Each directory is mounted at the same path within the container, so the paths of files do not need translating.
+func (c *container) wrap(dir string, args []string) []string {
+	// stdin is kept open for plugins, which receive their requests on it
+	result := []string{"run", "--rm", "--interactive", "--volume", mount(dir), "--workdir", containerPath(dir)}
+
+	if dir != c.root {
+		result = append(result, "--volume", mount(c.root)+":ro")
+	}
+
+	// files written by the formatter must be owned by the user rather than by root
+	if uid := os.Getuid(); uid > 0 {
+		switch c.engine {
+		case ContainerEngineDocker:
+			result = append(result, "--user", fmt.Sprintf("%d:%d", uid, os.Getgid()))
+		case ContainerEnginePodman:
+			result = append(result, "--userns", "keep-id")
+		}
+	}
+
+	return slices.Concat(result, []string{c.image}, args)
+}
+
+// mount returns the volume with which dir is mounted within a container.
+func mount(dir string) string {
+	return dir + ":" + containerPath(dir)
+}
+
+// containerPath translates a path on the host to the path at which it is mounted within a container, which is the
+// same other than on Windows, where C:\src becomes /c/src.
+func containerPath(path string) string {
+	if volume := filepath.VolumeName(path); volume != "" {
+		path = "/" + strings.ToLower(strings.TrimSuffix(volume, ":")) + path[len(volume):]
+	}
+
+	return filepath.ToSlash(path)
+}
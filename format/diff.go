@@ -0,0 +1,44 @@
+package format
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/numtide/treefmt/v2/walk"
+	"github.com/rogpeppe/go-internal/diff"
+)
+
+// readContents reads the content of each file in batch.
+func readContents(batch []*walk.File) ([][]byte, error) {
+	contents := make([][]byte, len(batch))
+
+	for i, file := range batch {
+		content, err := os.ReadFile(file.Path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", file.Path, err)
+		}
+
+		contents[i] = content
+	}
+
+	return contents, nil
+}
+
+// diffFile returns a unified diff between the original content of file and its current content on disk.
+func diffFile(file *walk.File, original []byte) (string, error) {
+	content, err := os.ReadFile(file.Path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s: %w", file.Path, err)
+	}
+
+	return unifiedDiff(file.RelPath, original, content), nil
+}
+
+// unifiedDiff returns a unified diff between the original and formatted content of the file at relPath.
+// Paths are prefixed with a/ and b/ in the same manner as git, allowing the diff to be applied with `git apply`.
+func unifiedDiff(relPath string, original []byte, formatted []byte) string {
+	path := filepath.ToSlash(relPath)
+
+	return string(diff.Diff("a/"+path, original, "b/"+path, formatted))
+}
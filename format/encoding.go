@@ -0,0 +1,239 @@
+package format
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"github.com/charmbracelet/log"
+	"github.com/numtide/treefmt/v2/walk"
+)
+
+// The values of a formatter's encoding, which determine how it is applied to files which are not encoded as UTF-8.
+const (
+	// EncodingIgnore passes files to the formatter regardless of their encoding.
+	EncodingIgnore = "ignore"
+	// EncodingSkip does not apply the formatter to files encoded as UTF-16 or latin-1, logging a warning instead.
+	EncodingSkip = "skip"
+	// EncodingTranscode converts files encoded as UTF-16 or latin-1 to UTF-8 before applying the formatter, and back
+	// to their original encoding afterwards.
+	EncodingTranscode = "transcode"
+)
+
+// transcoding converts a batch of files to UTF-8 whilst a formatter is applied to them, before restoring their original
+// encoding.
+type transcoding struct {
+	files     []*walk.File
+	encodings []walk.Encoding
+	// originals and modTimes record the content and modification time of each file before it was transcoded
+	originals [][]byte
+	modTimes  []time.Time
+}
+
+// restore converts each file back to its original encoding, returning a FormatError for each file which could not be
+// converted, e.g. because the formatter introduced characters which cannot be represented in latin-1. Such files are
+// left with their original content. If a file ends up with its original content, its modification time is restored so
+// it is not reported as changed.
+func (t *transcoding) restore(name string) []error {
+	var errs []error
+
+	for i, file := range t.files {
+		if err := t.restoreFile(i); err != nil {
+			errs = append(errs, newFormatError(name, []*walk.File{file}, nil, err))
+		}
+	}
+
+	return errs
+}
+
+func (t *transcoding) restoreFile(i int) error {
+	path := t.files[i].Path
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	encoded, encodeErr := encode(bytes.TrimPrefix(content, walk.EncodingUTF8.BOM()), t.encodings[i])
+	if encodeErr != nil {
+		encoded = t.originals[i]
+	} else if bytes.HasPrefix(t.originals[i], t.encodings[i].BOM()) {
+		encoded = append(bytes.Clone(t.encodings[i].BOM()), encoded...)
+	}
+
+	// the permissions of an existing file are not changed by WriteFile
+	if err = os.WriteFile(path, encoded, 0o600); err != nil {
+		return fmt.Errorf("failed to restore the %s encoding of %s: %w", t.encodings[i], path, err)
+	}
+
+	if bytes.Equal(encoded, t.originals[i]) {
+		if err = os.Chtimes(path, time.Time{}, t.modTimes[i]); err != nil {
+			return fmt.Errorf("failed to restore modification time of %s: %w", path, err)
+		}
+	}
+
+	if encodeErr != nil {
+		return fmt.Errorf("failed to convert the output of the formatter back to %s, %s is unchanged: %w",
+			t.encodings[i], path, encodeErr)
+	}
+
+	return nil
+}
+
+// newTranscoding converts those of files which are encoded as UTF-16 or latin-1 to UTF-8, returning the files which
+// may be passed to the formatter. Files which cannot be converted without loss, e.g. because they are not actually
+// encoded as detected, are left alone and are not returned.
+func newTranscoding(log *log.Logger, files []*walk.File) (*transcoding, []*walk.File, error) {
+	t := &transcoding{}
+	wanted := make([]*walk.File, 0, len(files))
+
+	for _, file := range files {
+		encoding, err := file.Encoding()
+		if err != nil {
+			return t, nil, err
+		}
+
+		if !transcodable(encoding) {
+			wanted = append(wanted, file)
+
+			continue
+		}
+
+		content, err := os.ReadFile(file.Path)
+		if err != nil {
+			return t, nil, fmt.Errorf("failed to read %s: %w", file.Path, err)
+		}
+
+		info, err := os.Stat(file.Path)
+		if err != nil {
+			return t, nil, fmt.Errorf("failed to stat %s: %w", file.Path, err)
+		}
+
+		decoded, ok := decode(bytes.TrimPrefix(content, encoding.BOM()), encoding)
+		if !ok {
+			log.Warnf("skipping %s as it cannot be converted from %s to utf8", file.RelPath, encoding)
+
+			continue
+		}
+
+		if err = os.WriteFile(file.Path, decoded, 0o600); err != nil {
+			return t, nil, fmt.Errorf("failed to convert %s to utf8: %w", file.Path, err)
+		}
+
+		log.Debugf("converted %s from %s to utf8", file.RelPath, encoding)
+
+		t.files = append(t.files, file)
+		t.encodings = append(t.encodings, encoding)
+		t.originals = append(t.originals, content)
+		t.modTimes = append(t.modTimes, info.ModTime())
+
+		wanted = append(wanted, file)
+	}
+
+	return t, wanted, nil
+}
+
+// transcodable reports whether files in encoding are skipped or transcoded, rather than passed to formatters as they
+// are.
+func transcodable(encoding walk.Encoding) bool {
+	switch encoding {
+	case walk.EncodingUTF16LE, walk.EncodingUTF16BE, walk.EncodingLatin1:
+		return true
+	default:
+		return false
+	}
+}
+
+// decode converts content from encoding to UTF-8, reporting false if it cannot be converted back without loss.
+func decode(content []byte, encoding walk.Encoding) ([]byte, bool) {
+	var decoded []byte
+
+	switch encoding {
+	case walk.EncodingLatin1:
+		decoded = make([]byte, 0, len(content)*2)
+		for _, b := range content {
+			decoded = utf8.AppendRune(decoded, rune(b))
+		}
+
+		return decoded, true
+
+	case walk.EncodingUTF16LE, walk.EncodingUTF16BE:
+		if len(content)%2 != 0 {
+			return nil, false
+		}
+
+		order := utf16ByteOrder(encoding)
+
+		units := make([]uint16, len(content)/2)
+		for i := range units {
+			units[i] = order.Uint16(content[i*2:])
+		}
+
+		decoded = make([]byte, 0, len(content))
+		for _, r := range utf16.Decode(units) {
+			decoded = utf8.AppendRune(decoded, r)
+		}
+
+		// unpaired surrogates are replaced when decoding
+		encoded, err := encode(decoded, encoding)
+
+		return decoded, err == nil && bytes.Equal(encoded, content)
+
+	default:
+		return content, true
+	}
+}
+
+// encode converts content from UTF-8 to encoding.
+func encode(content []byte, encoding walk.Encoding) ([]byte, error) {
+	if !utf8.Valid(content) {
+		return nil, errors.New("content is not valid utf8")
+	}
+
+	var encoded []byte
+
+	switch encoding {
+	case walk.EncodingLatin1:
+		encoded = make([]byte, 0, len(content))
+
+		for _, r := range string(content) {
+			if r > 0xff {
+				return nil, fmt.Errorf("%q cannot be represented in latin1", r)
+			}
+
+			encoded = append(encoded, byte(r))
+		}
+
+	case walk.EncodingUTF16LE, walk.EncodingUTF16BE:
+		order := utf16ByteOrder(encoding)
+		encoded = make([]byte, 0, len(content)*2)
+
+		for _, unit := range utf16.Encode([]rune(string(content))) {
+			encoded = order.AppendUint16(encoded, unit)
+		}
+
+	default:
+		encoded = content
+	}
+
+	return encoded, nil
+}
+
+// byteOrder reads and appends the code units of UTF-16 content.
+type byteOrder interface {
+	binary.ByteOrder
+	binary.AppendByteOrder
+}
+
+func utf16ByteOrder(encoding walk.Encoding) byteOrder {
+	if encoding == walk.EncodingUTF16BE {
+		return binary.BigEndian
+	}
+
+	return binary.LittleEndian
+}
@@ -0,0 +1,84 @@
+//nolint:testpackage
+package format
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/numtide/treefmt/v2/walk"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTranscoding(t *testing.T) {
+	r := require.New(t)
+
+	tempDir := t.TempDir()
+	modTime := time.Now().Add(-time.Hour).Truncate(time.Second)
+
+	newFile := func(name string, content string) *walk.File {
+		path := filepath.Join(tempDir, name)
+		r.NoError(os.WriteFile(path, []byte(content), 0o600))
+		r.NoError(os.Chtimes(path, modTime, modTime))
+
+		info, err := os.Stat(path)
+		r.NoError(err)
+
+		return &walk.File{Path: path, RelPath: name, Info: info}
+	}
+
+	read := func(file *walk.File) string {
+		content, err := os.ReadFile(file.Path)
+		r.NoError(err)
+
+		return string(content)
+	}
+
+	utf8File := newFile("utf8", "héllo\n")
+	utf16File := newFile("utf16", "\xff\xfeh\x00\xe9\x00l\x00l\x00o\x00\n\x00")
+	latin1File := newFile("latin1", "h\xe9llo\n")
+	unchangedFile := newFile("unchanged", "h\xe9llo\n")
+	invalidFile := newFile("invalid", "\xff\xfeh\x00\x00\xdc")
+
+	files := []*walk.File{utf8File, utf16File, latin1File, unchangedFile, invalidFile}
+
+	transcoded, wanted, err := newTranscoding(log.Default(), files)
+	r.NoError(err)
+
+	// content which cannot be converted without loss is not passed to the formatter
+	r.Equal([]*walk.File{utf8File, utf16File, latin1File, unchangedFile}, wanted)
+
+	r.Equal("héllo\n", read(utf16File), "the byte order mark is removed")
+	r.Equal("héllo\n", read(latin1File))
+
+	// the formatter changes some of the files
+	r.NoError(os.WriteFile(utf16File.Path, []byte("HÉLLO\n"), 0o600))
+	r.NoError(os.WriteFile(latin1File.Path, []byte("\xef\xbb\xbfHÉLLO\n"), 0o600))
+
+	r.Empty(transcoded.restore("test"))
+
+	r.Equal("héllo\n", read(utf8File))
+	r.Equal("\xff\xfeH\x00\xc9\x00L\x00L\x00O\x00\n\x00", read(utf16File))
+	r.Equal("H\xc9LLO\n", read(latin1File))
+	r.Equal("h\xe9llo\n", read(unchangedFile))
+	r.Equal("\xff\xfeh\x00\x00\xdc", read(invalidFile))
+
+	// files which end up with their original content are not reported as changed
+	info, err := os.Stat(unchangedFile.Path)
+	r.NoError(err)
+	r.Equal(modTime, info.ModTime())
+
+	// output which cannot be converted back is discarded
+	latin1File = newFile("latin1-emoji", "h\xe9llo\n")
+
+	transcoded, _, err = newTranscoding(log.Default(), []*walk.File{latin1File})
+	r.NoError(err)
+	r.NoError(os.WriteFile(latin1File.Path, []byte("héllo 👋\n"), 0o600))
+
+	errs := transcoded.restore("test")
+	r.Len(errs, 1)
+	r.ErrorContains(errs[0], "cannot be represented in latin1")
+	r.Equal("h\xe9llo\n", read(latin1File))
+}
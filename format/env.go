@@ -0,0 +1,124 @@
+package format
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"slices"
+	"strings"
+
+	"github.com/numtide/treefmt/v2/config"
+	"mvdan.cc/sh/v3/expand"
+	"mvdan.cc/sh/v3/interp"
+)
+
+// windowsExts are the extensions of executables on Windows, see lookPath.
+//
+//nolint:gochecknoglobals
+var windowsExts = []string{".exe", ".cmd", ".bat", ".com"}
+
+// envVarRegex matches references to environment variables of the form $NAME or ${NAME}, along with $$, which escapes
+// a literal $.
+//
+//nolint:gochecknoglobals
+var envVarRegex = regexp.MustCompile(`\$(?:(\$)|\{([a-zA-Z_][a-zA-Z0-9_]*)\}|([a-zA-Z_][a-zA-Z0-9_]*))`)
+
+// expandEnv returns a copy of cfg with the environment variables referenced in its command and options expanded
+// using env.
+//
+// A variable referenced as ${NAME} in the command must be set. Otherwise, variables which are not set are left
+// untouched, so scripts passed to a shell with -c, which commonly reference their own variables such as $f or ${f},
+// continue to work. A script can also reference its own variable when one of the same name is set in the environment
+// by escaping it as $$f. Other uses of $, e.g. $@ or ${f%.go}, are always left untouched.
+func expandEnv(name string, env expand.Environ, cfg *config.Formatter) (*config.Formatter, error) {
+	var unset []string
+
+	expandValue := func(value string, strict bool) string {
+		return envVarRegex.ReplaceAllStringFunc(value, func(match string) string {
+			groups := envVarRegex.FindStringSubmatch(match)
+			if groups[1] != "" {
+				return "$"
+			}
+
+			required := strict && groups[2] != ""
+
+			variable := env.Get(groups[2] + groups[3])
+
+			switch {
+			case variable.IsSet():
+				return variable.String()
+			case required && !slices.Contains(unset, groups[2]):
+				unset = append(unset, groups[2])
+			}
+
+			return match
+		})
+	}
+
+	expanded := *cfg
+	expanded.Command = expandValue(cfg.Command, true)
+	expanded.Options = make([]string, len(cfg.Options))
+
+	for i, option := range cfg.Options {
+		expanded.Options[i] = expandValue(option, false)
+	}
+
+	if len(unset) > 0 {
+		return nil, fmt.Errorf(
+			"formatter '%v' references environment variables which are not set: %s", name, strings.Join(unset, ", "),
+		)
+	}
+
+	return &expanded, nil
+}
+
+// lookupEnv returns the environment used to resolve formatter commands, in which the bin paths of cfg are searched
+// before the PATH. Relative bin paths are resolved against the tree root.
+func lookupEnv(cfg *config.Config) expand.Environ {
+	environ := os.Environ()
+	if len(cfg.BinPaths) == 0 {
+		return expand.ListEnviron(environ...)
+	}
+
+	paths := make([]string, 0, len(cfg.BinPaths)+1)
+
+	for _, dir := range cfg.BinPaths {
+		if !filepath.IsAbs(dir) {
+			dir = filepath.Join(cfg.TreeRoot, dir)
+		}
+
+		paths = append(paths, dir)
+	}
+
+	if path := os.Getenv("PATH"); path != "" {
+		paths = append(paths, path)
+	}
+
+	// later entries take precedence over earlier ones with the same name
+	return expand.ListEnviron(append(environ, "PATH="+strings.Join(paths, string(os.PathListSeparator)))...)
+}
+
+// lookPath finds the executable for command, relative to dir if it contains a path separator, or within the PATH of
+// env otherwise.
+// On Windows, the extensions listed in PATHEXT are tried in turn, so command need not include one, e.g. prettier finds
+// prettier.cmd. Elsewhere, a command with the extension of a Windows executable is also tried without it, so the same
+// config file can be shared between platforms.
+func lookPath(dir string, env expand.Environ, command string) (string, error) {
+	path, err := interp.LookPathDir(dir, env, command)
+	if err == nil || runtime.GOOS == "windows" {
+		return path, err
+	}
+
+	ext := filepath.Ext(command)
+	if !slices.Contains(windowsExts, strings.ToLower(ext)) {
+		return "", err
+	}
+
+	if path, extErr := interp.LookPathDir(dir, env, strings.TrimSuffix(command, ext)); extErr == nil {
+		return path, nil
+	}
+
+	return "", err
+}
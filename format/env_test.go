@@ -0,0 +1,90 @@
+//nolint:testpackage
+package format
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/numtide/treefmt/v2/config"
+	"github.com/stretchr/testify/require"
+	"mvdan.cc/sh/v3/expand"
+)
+
+func TestExpandEnv(t *testing.T) {
+	r := require.New(t)
+
+	env := expand.ListEnviron("RUSTFMT=/opt/bin/rustfmt", "PRETTIER_CONFIG=.prettierrc", "EMPTY=", "f=oops")
+
+	cfg := &config.Formatter{
+		Command: "$RUSTFMT",
+		Options: []string{
+			"--config", "${PRETTIER_CONFIG}", "--empty=${EMPTY}", "$UNSET", "${UNSET}",
+			"-c", `for f in "$@"; do sed -i '$d' "${f%.rs}"; done`,
+			"-c", `for g in "$@"; do fmt "${g}" "$$f" "$$$$"; done`,
+		},
+		Includes: []string{"$RUSTFMT"},
+	}
+
+	expanded, err := expandEnv("rust", env, cfg)
+	r.NoError(err)
+	r.Equal(&config.Formatter{
+		Command: "/opt/bin/rustfmt",
+		Options: []string{
+			"--config", ".prettierrc", "--empty=", "$UNSET", "${UNSET}",
+			"-c", `for f in "$@"; do sed -i '$d' "${f%.rs}"; done`,
+			"-c", `for g in "$@"; do fmt "${g}" "$f" "$$"; done`,
+		},
+		Includes: []string{"$RUSTFMT"}, // only the command and options are expanded
+	}, expanded)
+
+	// the original config is not modified
+	r.Equal("$RUSTFMT", cfg.Command)
+	r.Equal("${PRETTIER_CONFIG}", cfg.Options[1])
+
+	// variables which the command requires are reported
+	cfg.Command = "${UNSET}/${MISSING}/${UNSET}"
+
+	_, err = expandEnv("rust", env, cfg)
+	r.EqualError(err, "formatter 'rust' references environment variables which are not set: UNSET, MISSING")
+
+	// whereas those which are not set are left untouched in the options
+	cfg.Command = "rustfmt"
+	cfg.Options = []string{"${UNSET}", "${MISSING}"}
+
+	expanded, err = expandEnv("rust", env, cfg)
+	r.NoError(err)
+	r.Equal([]string{"${UNSET}", "${MISSING}"}, expanded.Options)
+}
+
+func TestLookPath(t *testing.T) {
+	r := require.New(t)
+
+	binDir := t.TempDir()
+	env := expand.ListEnviron("PATH="+binDir, "PATHEXT=.COM;.EXE;.BAT;.CMD")
+
+	// the executable is named as it would be on the current platform
+	name := "fmt"
+	if runtime.GOOS == "windows" {
+		name = "fmt.cmd"
+	}
+
+	r.NoError(os.WriteFile(filepath.Join(binDir, name), []byte("#!/bin/sh\n"), 0o755)) //nolint:gosec
+
+	// the command is found with or without the extension it has on windows, so config files can be shared
+	for _, command := range []string{"fmt", "fmt.cmd"} {
+		path, err := lookPath(binDir, env, command)
+		r.NoError(err, command)
+		r.Equal(filepath.Join(binDir, name), path)
+	}
+
+	// as are paths relative to the tree root, which are slash separated on every platform
+	path, err := lookPath(filepath.Dir(binDir), env, filepath.Base(binDir)+"/fmt.cmd")
+	r.NoError(err)
+	r.Equal(filepath.Join(binDir, name), filepath.Clean(path))
+
+	// other extensions are not dropped
+	_, err = lookPath(binDir, env, "fmt.sh")
+	r.Error(err)
+}
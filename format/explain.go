@@ -0,0 +1,113 @@
+package format
+
+import (
+	"crypto/md5" //nolint:gosec
+	"fmt"
+
+	"github.com/numtide/treefmt/v2/config"
+	"github.com/numtide/treefmt/v2/walk"
+)
+
+// Explanation describes how the formatters to apply to a file were determined.
+type Explanation struct {
+	// Scope is the config which applies to the file, which is that of the nearest nested config file, if any.
+	Scope *config.Scope
+	// ExcludedBy is the global exclude pattern which matched the file, if any.
+	ExcludedBy string
+	// TooLarge indicates the file exceeds the global max file size.
+	TooLarge bool
+	// Decisions describes whether each formatter of Scope wants the file, sorted by formatter name.
+	Decisions []*Decision
+	// Sequence contains the formatters which will be applied to the file, in order.
+	Sequence []*Formatter
+	// Signature is the signature of Sequence, which is combined with the file's size and mod time to produce the
+	// format signature recorded in the cache.
+	Signature []byte
+}
+
+// Decision describes whether a formatter wants to process a file, and why.
+type Decision struct {
+	Formatter string
+	Wanted    bool
+	Reason    string
+}
+
+// Explain describes how the formatters to apply to file are determined, for diagnosing why a file is or isn't being
+// formatted.
+func (c *CompositeFormatter) Explain(file *walk.File) (*Explanation, error) {
+	s, err := c.scopeFor(file.RelPath)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &Explanation{
+		Scope:      s.config,
+		ExcludedBy: matchingPattern(file.RelPath, s.excludes, s.config.Excludes),
+		TooLarge:   exceedsSize(file, c.maxFileSize),
+	}
+
+	// each formatter is explained, even when the file is excluded, as the reason may be of interest
+	for _, f := range sortedByName(s.formatters) {
+		wanted, reason := f.Wants(file)
+		if wanted && f.TooLarge(file) {
+			wanted, reason = false, fmt.Sprintf("exceeds the max-file-size of %s", f.config.MaxFileSize)
+		}
+
+		result.Decisions = append(result.Decisions, &Decision{
+			Formatter: f.Name(),
+			Wanted:    wanted,
+			Reason:    reason,
+		})
+
+		if wanted && result.ExcludedBy == "" && !result.TooLarge {
+			result.Sequence = append(result.Sequence, f)
+		}
+	}
+
+	if len(result.Sequence) == 0 {
+		return result, nil
+	}
+
+	sortFormatters(result.Sequence)
+
+	signature, err := sequenceSignature(result.Sequence, c.scheduler.lineEndings)
+	if err != nil {
+		return nil, err
+	}
+
+	result.Signature = signature
+
+	return result, nil
+}
+
+// sequenceSignature generates a signature for a sequence of formatters by hashing each of them in order, followed by
+// lineEndings unless they are left to the formatters, so files are formatted again when it changes.
+func sequenceSignature(formatters []*Formatter, lineEndings string) ([]byte, error) {
+	h := md5.New() //nolint:gosec
+	for _, f := range formatters {
+		if err := f.Hash(h); err != nil {
+			return nil, fmt.Errorf("failed to hash formatter %s: %w", f.Name(), err)
+		}
+	}
+
+	if lineEndings != LineEndingsFormatter {
+		h.Write([]byte("line-endings=" + lineEndings))
+	}
+
+	return h.Sum(nil), nil
+}
+
+// portableSignature is like sequenceSignature, but is the same on every machine with the same config and versions of
+// the formatters, for looking up files in a cache which is shared between them.
+func portableSignature(formatters []*Formatter, lineEndings string) []byte {
+	h := md5.New() //nolint:gosec
+	for _, f := range formatters {
+		f.hashPortable(h)
+	}
+
+	if lineEndings != LineEndingsFormatter {
+		h.Write([]byte("line-endings=" + lineEndings))
+	}
+
+	return h.Sum(nil)
+}
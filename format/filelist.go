@@ -0,0 +1,74 @@
+package format
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/numtide/treefmt/v2/walk"
+)
+
+// fileListPlaceholder is replaced in a formatter's options with the path of a temporary file listing the files it is
+// applied to, one per line, which are then no longer passed as arguments.
+const fileListPlaceholder = "{filelist}"
+
+// useFileList validates a formatter which is passed a file listing its files, rather than their paths as arguments.
+func (f *Formatter) useFileList() error {
+	switch {
+	case f.config.Stdout:
+		return fmt.Errorf("formatter '%v' cannot be passed a %s and also write its output to stdout",
+			f.name, fileListPlaceholder)
+	case f.config.Plugin:
+		return fmt.Errorf("formatter '%v' cannot be passed a %s and also be a plugin", f.name, fileListPlaceholder)
+	case f.config.Mode == ModeProject:
+		return fmt.Errorf("formatter '%v' is run once per project, so cannot be passed a %s",
+			f.name, fileListPlaceholder)
+	case f.container != nil:
+		// the file is written outside of the directories which are mounted within the container
+		return fmt.Errorf("formatter '%v' is run within a container, so cannot be passed a %s",
+			f.name, fileListPlaceholder)
+	}
+
+	f.fileList = true
+
+	return nil
+}
+
+// withFileList writes the paths of files, relative to the working directory, to a temporary file, returning args with
+// the placeholder replaced by its path, and a function which removes it.
+func withFileList(args []string, files []*walk.File) ([]string, func(), error) {
+	var b strings.Builder
+
+	for _, file := range files {
+		b.WriteString(file.RelPath)
+		b.WriteByte('\n')
+	}
+
+	file, err := os.CreateTemp("", "treefmt-filelist-*")
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create file list: %w", err)
+	}
+
+	remove := func() {
+		_ = os.Remove(file.Name())
+	}
+
+	_, err = file.WriteString(b.String())
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		remove()
+
+		return nil, nil, fmt.Errorf("failed to write file list: %w", err)
+	}
+
+	args = slices.Clone(args)
+	for i, arg := range args {
+		args[i] = strings.ReplaceAll(arg, fileListPlaceholder, file.Name())
+	}
+
+	return args, remove, nil
+}
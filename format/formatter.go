@@ -1,44 +1,158 @@
 package format
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
 	"hash"
+	"io"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"regexp"
+	"slices"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/charmbracelet/log"
 	"github.com/gobwas/glob"
 	"github.com/numtide/treefmt/v2/config"
+	processSandbox "github.com/numtide/treefmt/v2/sandbox"
 	"github.com/numtide/treefmt/v2/walk"
+	"golang.org/x/sync/errgroup"
+	"golang.org/x/sync/semaphore"
 	"mvdan.cc/sh/v3/expand"
-	"mvdan.cc/sh/v3/interp"
 )
 
 var (
 	ErrInvalidName = errors.New("formatter name must only contain alphanumeric characters, `_` or `-`")
 	// ErrCommandNotFound is returned when the Command for a Formatter is not available.
 	ErrCommandNotFound = errors.New("formatter command not found in PATH")
+	// ErrNotInstalled is returned when the Command for a Formatter with a Runner is not installed within the project.
+	ErrNotInstalled = errors.New("formatter command not installed for its runner")
 
 	nameRegex = regexp.MustCompile("^[a-zA-Z0-9_-]+$")
 )
 
+// FormatError describes a failure to apply a Formatter to a set of files, along with any output it produced.
+type FormatError struct {
+	Formatter string
+	Paths     []string
+	Output    []byte
+	Err       error
+}
+
+func (e *FormatError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *FormatError) Unwrap() error {
+	return e.Err
+}
+
+func newFormatError(name string, files []*walk.File, output []byte, err error) *FormatError {
+	paths := make([]string, len(files))
+	for i, file := range files {
+		paths[i] = file.RelPath
+	}
+
+	return &FormatError{
+		Formatter: name,
+		Paths:     paths,
+		Output:    output,
+		Err:       err,
+	}
+}
+
 // Formatter represents a command which should be applied to a filesystem.
 type Formatter struct {
 	name   string
 	config *config.Formatter
 
 	log        *log.Logger
-	executable string // path to the executable described by Command
+	executable string   // path to the executable described by Command, or of its Runner or container engine
+	args       []string // args which precede the paths of files: those of any Runner, then Command, then Options
 	workingDir string
 
 	// internal, compiled versions of Includes and Excludes.
 	includes []glob.Glob
 	excludes []glob.Glob
+
+	// internal, parsed version of MaxFileSize, 0 if there is no limit.
+	maxFileSize int64
+
+	// internal, parsed version of Timeout, falling back to the global timeout, 0 if there is no timeout.
+	timeout time.Duration
+
+	// internal, parsed version of Nice, CPULimit and MemoryLimit, nil if the formatter's processes are not limited.
+	limits *processSandbox.Limits
+
+	// fileList indicates the formatter's options refer to a file listing the files it is applied to, which are then not
+	// passed as arguments.
+	fileList bool
+
+	// internal, validated version of BatchSize, 0 if there is no limit.
+	batchSize int
+
+	// internal, validated version of Parallel, the number of processes to split each batch across.
+	parallel int
+
+	// projectRoots caches the root of the project containing each directory, relative to the tree root, if the
+	// formatter is run once per project.
+	projectRoots     map[string]string
+	projectRootsLock sync.Mutex
+
+	// jobs is shared by all formatters, limiting the number of formatter processes which can run at once.
+	jobs *semaphore.Weighted
+	// exclusive is shared by all exclusive formatters, so that only one of their processes runs at a time, or nil if
+	// the formatter is not exclusive.
+	exclusive *semaphore.Weighted
+	// locks is shared by all formatters, preventing them from writing to the same file at once.
+	locks *pathLocks
+
+	// internal, compiled version of MatchFirstLine.
+	firstLine *regexp.Regexp
+
+	// internal, parsed version of Types, only populated if Detect is set to content.
+	types []walk.ContentType
+
+	// internal, parsed version of Output: the level at which the formatter's output is logged, if at all.
+	outputLevel  log.Level
+	streamOutput bool
+
+	// plugin is the long-lived process of a formatter which speaks the plugin protocol, started when first applied.
+	plugin     *plugin
+	pluginErr  error
+	pluginOnce sync.Once
+
+	// wasm is the compiled module of a formatter whose Command is a WebAssembly module, compiled when first applied.
+	wasm     *wasmModule
+	wasmErr  error
+	wasmOnce sync.Once
+
+	// builtin formats each file in-process, if Command refers to one of the builtin formatters.
+	builtin builtinFunc
+
+	// container describes the image within which Command is run, if it has one.
+	container *container
+
+	// sandboxed indicates the formatter's processes are restricted to writing the files they are applied to.
+	sandboxed bool
+
+	// version reports the version of the formatter's command once probed, nil if it is not probed.
+	version *versionProbe
+
+	// env is the environment in which the formatter's Pre and Post hooks are run.
+	env expand.Environ
+	// hooks indicates whether Pre and Post are run, which they are not in check or dry-run mode.
+	hooks bool
+	// applied is set once the formatter has been applied to any files, after running its Pre hook.
+	applied atomic.Bool
+	preErr  error
+	preOnce sync.Once
 }
 
 func (f *Formatter) Name() string {
@@ -49,6 +163,23 @@ func (f *Formatter) Priority() int {
 	return f.config.Priority
 }
 
+// Stage returns the name of the stage in which the formatter is applied concurrently with others, if any.
+func (f *Formatter) Stage() string {
+	return f.config.Stage
+}
+
+// After returns the names of the formatters which must be applied before this one to any file they both match.
+func (f *Formatter) After() []string {
+	return f.config.After
+}
+
+// isAfter reports whether f must be applied after other, ignoring the scope by which other's name may be qualified.
+func (f *Formatter) isAfter(other *Formatter) bool {
+	name, _, _ := strings.Cut(other.name, "@")
+
+	return slices.Contains(f.config.After, name)
+}
+
 // Executable returns the path to the executable defined by Command.
 func (f *Formatter) Executable() string {
 	return f.executable
@@ -56,12 +187,7 @@ func (f *Formatter) Executable() string {
 
 // Hash adds this formatter's config and executable info to the config hash being created.
 func (f *Formatter) Hash(h hash.Hash) error {
-	// including the name helps us to easily detect when formatters have been added/removed
-	h.Write([]byte(f.name))
-	// if options change, the outcome of applying the formatter might be different
-	h.Write([]byte(strings.Join(f.config.Options, " ")))
-	// if priority changes, the outcome of applying a sequence of formatters might be different
-	h.Write([]byte(fmt.Sprintf("%d", f.config.Priority)))
+	f.hashConfig(h)
 
 	// stat the formatter's executable
 	info, err := os.Lstat(f.executable)
@@ -73,61 +199,794 @@ func (f *Formatter) Hash(h hash.Hash) error {
 	// if the formatter executable changes (e.g. new version) the outcome of applying the formatter might differ
 	h.Write([]byte(fmt.Sprintf("%d %d", info.Size(), info.ModTime().Unix())))
 
+	// the version it reports catches upgrades which don't change the executable, e.g. a wrapper script which runs the
+	// formatter within a container, or an executable on a network mount which preserves its mod time
+	f.hashVersion(h)
+
 	return nil
 }
 
+// hashPortable adds this formatter's config and the version it reports, if it has a version-probe, to the hash being
+// created. Unlike Hash, the executable is not considered, as its size and mod time differ between machines.
+func (f *Formatter) hashPortable(h hash.Hash) {
+	f.hashConfig(h)
+	f.hashVersion(h)
+}
+
+// hashConfig adds the config which determines the outcome of applying this formatter to the hash being created.
+func (f *Formatter) hashConfig(h hash.Hash) {
+	// including the name helps us to easily detect when formatters have been added/removed
+	h.Write([]byte(f.name))
+	// if options change, the outcome of applying the formatter might be different
+	h.Write([]byte(strings.Join(f.args, " ")))
+	// if priority changes, the outcome of applying a sequence of formatters might be different
+	h.Write([]byte(fmt.Sprintf("%d", f.config.Priority)))
+	// capturing stdout changes how the formatter is applied
+	if f.config.Stdout {
+		h.Write([]byte("stdout"))
+	}
+	// as is speaking the plugin protocol
+	if f.config.Plugin {
+		h.Write([]byte("plugin"))
+	}
+	// or being run once per project, where the markers determine which files each project contains
+	if f.config.Mode == ModeProject {
+		h.Write([]byte("project " + strings.Join(f.config.RootMarkers, " ")))
+	}
+	// builtin formatters share treefmt's executable, so are distinguished by their command
+	if f.builtin != nil {
+		h.Write([]byte(f.config.Command))
+	}
+	// as are those run within a container, which share the executable of its engine
+	if f.container != nil {
+		h.Write([]byte("container " + f.container.image + " " + f.config.Command))
+	}
+	// skipping or transcoding files in other encodings changes which files are formatted, and how
+	if f.config.Encoding != "" && f.config.Encoding != EncodingIgnore {
+		h.Write([]byte("encoding " + f.config.Encoding))
+	}
+	// applying formatters concurrently rather than in sequence might also change the outcome
+	if f.config.Stage != "" {
+		h.Write([]byte("stage " + f.config.Stage))
+	}
+}
+
+// hashVersion adds the version of this formatter to the hash being created, if it is configured or has been probed.
+func (f *Formatter) hashVersion(h hash.Hash) {
+	if f.config.Version != "" {
+		h.Write([]byte("version " + f.config.Version))
+	} else if version := f.version.wait(); version != nil {
+		h.Write([]byte("version "))
+		h.Write(version)
+	}
+}
+
+// Apply invokes the formatter against the given files, dividing them between as many processes as required.
+// If the formatter fails, the returned error will contain a FormatError for each process which failed.
 func (f *Formatter) Apply(ctx context.Context, files []*walk.File) error {
 	start := time.Now()
 
-	// construct args, starting with config
-	args := f.config.Options
-
 	// exit early if nothing to process
 	if len(files) == 0 {
 		return nil
 	}
 
-	// append paths to the args
-	for _, file := range files {
-		args = append(args, file.RelPath)
+	if err := f.runPre(ctx); err != nil {
+		f.log.Errorf("%v", err)
+
+		return newFormatError(f.name, files, nil, err)
+	}
+
+	// files encoded as UTF-16 or latin-1 are converted to UTF-8 whilst the formatter is applied to them
+	var transcoded *transcoding
+
+	if f.config.Encoding == EncodingTranscode {
+		t, wanted, err := newTranscoding(f.log, files)
+		if err != nil {
+			return errors.Join(append(t.restore(f.name), newFormatError(f.name, files, nil, err))...)
+		}
+
+		transcoded, files = t, wanted
+	}
+
+	var (
+		chunks [][]*walk.File
+		// roots contains the root of the project containing each chunk, if the formatter is run once per project
+		roots []string
+	)
+
+	if f.config.Mode == ModeProject {
+		roots, chunks = f.projects(files)
+	} else if f.config.Stdout || isWasm(f.config.Command) || f.builtin != nil {
+		// formatters which write to stdout must be applied one file at a time
+		chunks = make([][]*walk.File, len(files))
+		for i := range files {
+			chunks[i] = files[i : i+1]
+		}
+	} else {
+		// split the files into chunks, one for each formatter process we are allowed to run in parallel, ensuring no
+		// chunk exceeds the batch size or the platform's limit on the size of a command line
+		size := (len(files) + f.parallel - 1) / f.parallel
+		if f.batchSize > 0 {
+			size = min(size, f.batchSize)
+		}
+
+		if f.config.Plugin || f.fileList {
+			// plugins receive files in a request, and others may receive them in a file list, rather than on the
+			// command line, so there is no limit on its size
+			for start := 0; start < len(files); start += size {
+				chunks = append(chunks, files[start:min(start+size, len(files))])
+			}
+		} else {
+			executable, args := f.command(f.workingDir, f.args)
+			baseSize := baseArgsSize(append([]string{executable}, args...))
+			chunks = splitArgs(files, size, baseSize, argMax)
+		}
+	}
+
+	// apply each chunk, collecting any failures
+	errs := make([]error, len(chunks))
+
+	eg := &errgroup.Group{}
+	eg.SetLimit(f.parallel)
+
+	// the chunk applied by each process is claimed once none of its files are being written to by another formatter
+	claimed := make([]bool, len(chunks))
+
+	for i := range chunks {
+		eg.Go(func() error {
+			var (
+				err    error
+				output []byte
+			)
+
+			// the files are locked before waiting for a job slot, so a formatter waiting on them never holds a slot
+			if f.locks != nil {
+				var unlock func()
+				if i, unlock, err = f.locks.acquire(ctx, chunks, claimed); err != nil {
+					errs[i] = newFormatError(f.name, chunks[i], nil, err)
+
+					return nil
+				}
+
+				defer unlock()
+			}
+
+			chunk := chunks[i]
+
+			switch {
+			case f.config.Mode == ModeProject:
+				output, err = f.applyProject(ctx, roots[i])
+			case f.config.Stdout:
+				output, err = f.applyStdout(ctx, chunk[0])
+			case f.config.Plugin:
+				output, err = f.applyPlugin(ctx, chunk)
+			case isWasm(f.config.Command):
+				output, err = f.applyWasm(ctx, chunk[0])
+			case f.builtin != nil:
+				err = f.applyBuiltin(chunk[0])
+			default:
+				output, err = f.applyFiles(ctx, chunk)
+			}
+
+			if err != nil {
+				errs[i] = newFormatError(f.name, chunk, output, err)
+			}
+
+			return nil
+		})
+	}
+
+	// failures are collected in errs, so there is no error to check
+	_ = eg.Wait()
+
+	if transcoded != nil {
+		errs = append(errs, transcoded.restore(f.name)...)
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return err
+	}
+
+	f.log.Infof("%v file(s) processed in %v", len(files), time.Since(start))
+
+	return nil
+}
+
+// applyFiles invokes the formatter once, passing the paths of all the given files as arguments.
+// If the formatter fails, its combined output is returned alongside the error.
+func (f *Formatter) applyFiles(ctx context.Context, files []*walk.File) ([]byte, error) {
+	// construct args, starting with config
+	args := slices.Clone(f.args)
+
+	if f.fileList {
+		var (
+			remove func()
+			err    error
+		)
+
+		if args, remove, err = withFileList(args, files); err != nil {
+			return nil, err
+		}
+
+		defer remove()
+	} else {
+		// append paths to the args
+		for _, file := range files {
+			args = append(args, file.RelPath)
+		}
 	}
 
 	// execute the command
-	cmd := exec.CommandContext(ctx, f.executable, args...) //nolint:gosec
+	var out bytes.Buffer
+
+	// the output is still captured in full, so it can be reported if the formatter fails
+	output := io.Writer(&out)
+
+	if stream := f.outputStream(); stream != nil {
+		defer stream.Flush()
+
+		output = io.MultiWriter(&out, stream)
+	}
+
+	writable := make([]string, len(files))
+	for i, file := range files {
+		writable[i] = file.Path
+	}
+
+	if err := f.run(ctx, f.workingDir, args, writable, output, output); err != nil {
+		f.log.Errorf("failed to apply with options '%v': %s", f.config.Options, err)
+
+		return out.Bytes(), fmt.Errorf(
+			"formatter '%s' with options '%v' failed to apply: %w", f.config.Command, f.config.Options, err,
+		)
+	}
+
+	return nil, nil
+}
+
+// applyStdout invokes the formatter for a single file, replacing the file's content with whatever the formatter
+// writes to stdout.
+// If the formatter fails, its stderr is returned alongside the error.
+func (f *Formatter) applyStdout(ctx context.Context, file *walk.File) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+
+	// stdout is the formatted content, so only stderr is streamed
+	errOutput := io.Writer(&stderr)
+
+	if stream := f.outputStream(); stream != nil {
+		defer stream.Flush()
+
+		errOutput = io.MultiWriter(&stderr, stream)
+	}
+
+	// the formatter's output is written to the file by treefmt, so the formatter has no need to write to it
+	if err := f.run(ctx, f.workingDir, append(slices.Clone(f.args), file.RelPath), nil, &stdout, errOutput); err != nil {
+		f.log.Errorf("failed to apply with options '%v' to %s: %s", f.config.Options, file.RelPath, err)
+
+		return stderr.Bytes(), fmt.Errorf(
+			"formatter '%s' with options '%v' failed to apply to %s: %w",
+			f.config.Command, f.config.Options, file.RelPath, err,
+		)
+	}
+
+	return nil, f.writeOutput(file, stdout.Bytes())
+}
+
+// applyWasm runs the formatter's WebAssembly module for a single file, passing the file's content on stdin and
+// replacing it with whatever the module writes to stdout.
+// If the module fails, its stderr is returned alongside the error.
+func (f *Formatter) applyWasm(ctx context.Context, file *walk.File) ([]byte, error) {
+	f.wasmOnce.Do(func() {
+		f.wasm, f.wasmErr = compileWasm(ctx, f.executable)
+	})
+
+	if f.wasmErr != nil {
+		return nil, fmt.Errorf("formatter '%s' failed to load: %w", f.config.Command, f.wasmErr)
+	}
+
+	content, err := os.ReadFile(file.Path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", file.Path, err)
+	}
+
+	// wait for a free job slot before running the module
+	release, err := f.acquireJob(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	if f.timeout > 0 {
+		var cancel context.CancelFunc
+
+		ctx, cancel = context.WithTimeout(ctx, f.timeout)
+		defer cancel()
+	}
+
+	var stdout, stderr bytes.Buffer
+
+	// stdout is the formatted content, so only stderr is streamed
+	errOutput := io.Writer(&stderr)
+
+	if stream := f.outputStream(); stream != nil {
+		defer stream.Flush()
+
+		errOutput = io.MultiWriter(&stderr, stream)
+	}
+
+	f.log.Debugf("running %s for %s", f.config.Command, file.RelPath)
+
+	args := append(slices.Clone(f.args), file.RelPath)
+
+	err = f.wasm.run(ctx, args, bytes.NewReader(content), &stdout, errOutput)
+	if err != nil && f.timeout > 0 && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		err = fmt.Errorf("timed out after %v: %w", f.timeout, err)
+	}
+
+	if err != nil {
+		f.log.Errorf("failed to apply with options '%v' to %s: %s", f.config.Options, file.RelPath, err)
+
+		return stderr.Bytes(), fmt.Errorf(
+			"formatter '%s' with options '%v' failed to apply to %s: %w",
+			f.config.Command, f.config.Options, file.RelPath, err,
+		)
+	}
+
+	return nil, f.writeOutput(file, stdout.Bytes())
+}
+
+// applyBuiltin formats a single file in-process with the builtin formatter.
+func (f *Formatter) applyBuiltin(file *walk.File) error {
+	content, err := os.ReadFile(file.Path)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", file.Path, err)
+	}
+
+	formatted, err := f.builtin(content)
+	if err != nil {
+		f.log.Errorf("failed to apply to %s: %s", file.RelPath, err)
+
+		return fmt.Errorf("formatter '%s' failed to apply to %s: %w", f.config.Command, file.RelPath, err)
+	}
+
+	return writeIfChanged(file, formatted)
+}
+
+// writeOutput replaces the content of file with the output of a formatter which was applied to it alone, guarding
+// against a formatter which doesn't write any output wiping the file.
+func (f *Formatter) writeOutput(file *walk.File, output []byte) error {
+	if len(output) == 0 {
+		content, err := os.ReadFile(file.Path)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", file.Path, err)
+		} else if len(content) > 0 {
+			return fmt.Errorf(
+				"formatter '%s' with options '%v' produced no output for %s",
+				f.config.Command, f.config.Options, file.RelPath,
+			)
+		}
+	}
+
+	return writeIfChanged(file, output)
+}
+
+// writeIfChanged replaces the content of file with formatted, unless they are the same, to avoid needlessly updating
+// its mod time.
+func writeIfChanged(file *walk.File, formatted []byte) error {
+	content, err := os.ReadFile(file.Path)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", file.Path, err)
+	}
+
+	if bytes.Equal(content, formatted) {
+		return nil
+	}
+
+	if err = os.WriteFile(file.Path, formatted, file.Info.Mode().Perm()); err != nil {
+		return fmt.Errorf("failed to write formatted output to %s: %w", file.Path, err)
+	}
+
+	return nil
+}
+
+// applyPlugin sends the given files to the formatter's plugin process, starting it if necessary.
+// If the plugin reports any errors, its diagnostics are returned alongside the error.
+func (f *Formatter) applyPlugin(ctx context.Context, files []*walk.File) ([]byte, error) {
+	f.pluginOnce.Do(func() {
+		f.plugin, f.pluginErr = startPlugin(ctx, f)
+	})
+
+	if f.pluginErr != nil {
+		return nil, fmt.Errorf("plugin '%s' with options '%v' failed to start: %w",
+			f.config.Command, f.config.Options, f.pluginErr)
+	}
+
+	// wait for a free job slot before sending the request
+	release, err := f.acquireJob(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	if f.timeout > 0 {
+		var cancel context.CancelFunc
+
+		ctx, cancel = context.WithTimeout(ctx, f.timeout)
+		defer cancel()
+	}
+
+	result, err := f.plugin.format(ctx, files)
+	if err != nil && f.timeout > 0 && errors.Is(err, context.DeadlineExceeded) {
+		err = fmt.Errorf("timed out after %v: %w", f.timeout, err)
+	}
+
+	if err != nil {
+		f.log.Errorf("failed to apply with options '%v': %s", f.config.Options, err)
+
+		return nil, fmt.Errorf(
+			"plugin '%s' with options '%v' failed to apply: %w", f.config.Command, f.config.Options, err,
+		)
+	}
+
+	byPath := make(map[string]*walk.File, len(files))
+	for _, file := range files {
+		byPath[file.RelPath] = file
+	}
+
+	// diagnostics with an error severity cause the formatter to fail, and are reported as its output
+	var failures bytes.Buffer
+
+	for _, res := range result.Files {
+		file, ok := byPath[res.Path]
+		if !ok {
+			return failures.Bytes(), fmt.Errorf("plugin '%s' replied with a file it was not sent: %s",
+				f.config.Command, res.Path)
+		}
+
+		for _, diagnostic := range res.Diagnostics {
+			message := diagnostic.format(res.Path)
+
+			switch diagnostic.Severity {
+			case "error":
+				f.log.Error(message)
+				failures.WriteString(message + "\n")
+			case "warning":
+				f.log.Warn(message)
+			default:
+				f.log.Info(message)
+			}
+		}
+
+		if res.Content == nil || !f.plugin.contents {
+			continue
+		}
+
+		if err = writeIfChanged(file, []byte(*res.Content)); err != nil {
+			return failures.Bytes(), err
+		}
+	}
+
+	if failures.Len() > 0 {
+		return failures.Bytes(), fmt.Errorf("plugin '%s' reported errors", f.config.Command)
+	}
+
+	return nil, nil
+}
+
+// runPre runs the formatter's Pre hook, once, before it is first applied.
+func (f *Formatter) runPre(ctx context.Context) error {
+	f.applied.Store(true)
+
+	if !f.hooks || f.config.Pre == "" {
+		return nil
+	}
+
+	f.preOnce.Do(func() {
+		f.preErr = f.runHook(ctx, "pre", f.config.Pre)
+	})
+
+	return f.preErr
+}
+
+// runPost runs the formatter's Post hook, if the formatter was applied and its Pre hook succeeded.
+func (f *Formatter) runPost(ctx context.Context) error {
+	if !f.hooks || f.config.Post == "" || !f.applied.Load() || f.preErr != nil {
+		return nil
+	}
+
+	return f.runHook(ctx, "post", f.config.Post)
+}
+
+// runHook runs one of the formatter's hooks, with the name of the formatter in $TREEFMT_FORMATTER.
+func (f *Formatter) runHook(ctx context.Context, name string, command string) error {
+	return runHook(ctx, f.env, f.workingDir, name, command, map[string]string{"TREEFMT_FORMATTER": f.name})
+}
+
+// close shuts down the formatter's plugin process and releases its WebAssembly module, if either was started.
+func (f *Formatter) close(ctx context.Context) error {
+	if f.plugin != nil {
+		if err := f.plugin.close(); err != nil {
+			return fmt.Errorf("failed to close plugin for formatter %s: %w", f.name, err)
+		}
+	}
+
+	if f.wasm != nil {
+		if err := f.wasm.close(ctx); err != nil {
+			return fmt.Errorf("failed to close formatter %s: %w", f.name, err)
+		}
+	}
+
+	return nil
+}
+
+// reset clears the state of the last run once the formatter has been closed, so it can be applied again.
+func (f *Formatter) reset() {
+	f.plugin, f.pluginErr, f.pluginOnce = nil, nil, sync.Once{}
+	f.wasm, f.wasmErr, f.wasmOnce = nil, nil, sync.Once{}
+
+	f.applied.Store(false)
+	f.preErr, f.preOnce = nil, sync.Once{}
+
+	// projects may have been added or removed since the last run
+	f.projectRootsLock.Lock()
+	clear(f.projectRoots)
+	f.projectRootsLock.Unlock()
+}
+
+// outputStream returns a writer which logs the formatter's output line by line, or nil if it would not be shown at
+// the current log level, or has been disabled with the output setting.
+func (f *Formatter) outputStream() *lineLogger {
+	if !f.streamOutput || f.log.GetLevel() > f.outputLevel {
+		return nil
+	}
+
+	return &lineLogger{log: f.log, level: f.outputLevel}
+}
+
+// acquireJob waits for a free job slot, after waiting for any other exclusive formatter processes to finish if the
+// formatter is exclusive. The returned func releases them.
+func (f *Formatter) acquireJob(ctx context.Context) (func(), error) {
+	if f.exclusive != nil {
+		if err := f.exclusive.Acquire(ctx, 1); err != nil {
+			return nil, fmt.Errorf("failed to acquire exclusive lock: %w", err)
+		}
+	}
+
+	release := func() {
+		if f.exclusive != nil {
+			f.exclusive.Release(1)
+		}
+	}
+
+	if f.jobs != nil {
+		if err := f.jobs.Acquire(ctx, 1); err != nil {
+			release()
+
+			return nil, fmt.Errorf("failed to acquire job slot: %w", err)
+		}
+
+		releaseExclusive := release
+		release = func() {
+			f.jobs.Release(1)
+			releaseExclusive()
+		}
+	}
+
+	return release, nil
+}
+
+// run executes the formatter within dir with the given args, killing it if it exceeds the configured timeout. If the
+// formatter is sandboxed, it can only write to the paths in writable.
+func (f *Formatter) run(
+	ctx context.Context, dir string, args []string, writable []string, stdout io.Writer, stderr io.Writer,
+) error {
+	// wait for a free job slot before starting the formatter
+	release, err := f.acquireJob(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	if f.timeout > 0 {
+		var cancel context.CancelFunc
+
+		ctx, cancel = context.WithTimeout(ctx, f.timeout)
+		defer cancel()
+	}
+
+	executable, args := f.command(dir, args)
+
+	cmd := exec.CommandContext(ctx, executable, args...) //nolint:gosec
 	// replace the default Cancel handler installed by CommandContext because it sends SIGKILL (-9).
 	cmd.Cancel = func() error {
+		// if the timeout was exceeded, we assume the formatter is hung and kill it, along with anything it started
+		if f.timeout > 0 && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return killProcessGroup(cmd)
+		}
+
 		return cmd.Process.Signal(os.Interrupt)
 	}
-	cmd.Dir = f.workingDir
+	cmd.Dir = dir
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+
+	// only formatters with a timeout are placed in their own process group, otherwise they would no longer receive
+	// signals such as SIGINT from the terminal
+	if f.timeout > 0 {
+		setProcessGroup(cmd)
+		// don't wait forever on any orphaned processes which are holding onto stdout or stderr
+		cmd.WaitDelay = time.Second
+	}
+
+	if f.sandboxed {
+		cleanup, err := processSandbox.Apply(cmd, writable)
+		if err != nil {
+			return fmt.Errorf("failed to sandbox formatter: %w", err)
+		}
+
+		defer cleanup()
+	}
+
+	// the limits are applied outside of any sandbox, so that they also cover the processes which set it up
+	if f.limits != nil {
+		if err := processSandbox.Limit(cmd, *f.limits); err != nil {
+			return fmt.Errorf("failed to limit formatter: %w", err)
+		}
+	}
 
 	// log out the command being executed
 	f.log.Debugf("executing: %s", cmd.String())
 
-	if out, err := cmd.CombinedOutput(); err != nil {
-		f.log.Errorf("failed to apply with options '%v': %s", f.config.Options, err)
+	err = cmd.Run()
+	if err != nil && f.timeout > 0 && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("timed out after %v: %w", f.timeout, err)
+	} else if f.limits != nil && f.limits.CPU > 0 && processSandbox.ExceededCPU(err) {
+		return fmt.Errorf("exceeded its cpu-limit of %v: %w", f.limits.CPU, err)
+	}
 
-		if len(out) > 0 {
-			_, _ = fmt.Fprintf(os.Stderr, "\n%s\n", out)
-		}
+	return err
+}
 
-		return fmt.Errorf("formatter '%s' with options '%v' failed to apply: %w", f.config.Command, f.config.Options, err)
+// Wants is used to determine if a Formatter wants to process a path based on it's configured Includes and Excludes
+// patterns, as well as any Interpreters, MatchFirstLine expression or detected content Types. Formatters which are run
+// once per project only want files within a project.
+// Returns true if the Formatter should be applied to file, false otherwise, along with the reason why.
+func (f *Formatter) Wants(file *walk.File) (bool, string) {
+	if pattern := matchingPattern(file.RelPath, f.excludes, f.config.Excludes); pattern != "" {
+		return false, fmt.Sprintf("excluded by '%s'", pattern)
 	}
 
-	f.log.Infof("%v file(s) processed in %v", len(files), time.Since(start))
+	include := matchingPattern(file.RelPath, f.includes, f.config.Includes)
 
-	return nil
+	var (
+		match  bool
+		reason string
+	)
+
+	switch {
+	case f.types != nil && len(f.includes) > 0 && include == "":
+		// when detecting by content, includes are optional and restrict which paths we inspect
+		reason = "no includes match, which restrict the paths whose content is detected"
+	case f.types != nil && f.wantsContentType(file):
+		contentType, _ := file.ContentType()
+		match, reason = true, fmt.Sprintf("content detected as %s", contentType)
+	case f.types == nil && include != "":
+		match, reason = true, fmt.Sprintf("included by '%s'", include)
+	case f.wantsInterpreter(file):
+		interpreter, _ := file.Interpreter()
+		match, reason = true, fmt.Sprintf("interpreter '%s' matches", interpreter)
+	case f.wantsFirstLine(file):
+		match, reason = true, fmt.Sprintf("first line matches '%s'", f.config.MatchFirstLine)
+	case f.types != nil:
+		contentType, _ := file.ContentType()
+		reason = fmt.Sprintf("content detected as %s, expected one of %v", contentType, f.config.Types)
+	default:
+		reason = "no includes match"
+	}
+
+	if !match {
+		return false, reason
+	}
+
+	if !f.wantsEncoding(file) {
+		encoding, _ := file.Encoding()
+
+		return false, fmt.Sprintf("encoded as %s, which the formatter skips", encoding)
+	} else if !f.wantsProject(file) {
+		return false, fmt.Sprintf("not within a project, marked by one of %v", f.config.RootMarkers)
+	}
+
+	f.log.Debugf("match: %v", file)
+
+	return true, reason
 }
 
-// Wants is used to determine if a Formatter wants to process a path based on it's configured Includes and Excludes
-// patterns.
-// Returns true if the Formatter should be applied to file, false otherwise.
-func (f *Formatter) Wants(file *walk.File) bool {
-	match := !pathMatches(file.RelPath, f.excludes) && pathMatches(file.RelPath, f.includes)
-	if match {
-		f.log.Debugf("match: %v", file)
+// wantsInterpreter determines if the interpreter in file's shebang line is one of the configured Interpreters.
+func (f *Formatter) wantsInterpreter(file *walk.File) bool {
+	if len(f.config.Interpreters) == 0 {
+		return false
+	}
+
+	interpreter, err := file.Interpreter()
+	if err != nil {
+		f.log.Warnf("failed to determine interpreter for %s: %v", file.RelPath, err)
+
+		return false
+	}
+
+	return interpreter != "" && slices.Contains(f.config.Interpreters, interpreter)
+}
+
+// wantsEncoding determines if file is in an encoding this Formatter is applied to. Unless Encoding is set to skip, the
+// Formatter is applied regardless of encoding.
+func (f *Formatter) wantsEncoding(file *walk.File) bool {
+	if f.config.Encoding != EncodingSkip {
+		return true
+	}
+
+	encoding, err := file.Encoding()
+	if err != nil {
+		f.log.Warnf("failed to detect encoding of %s: %v", file.RelPath, err)
+
+		return false
+	}
+
+	if transcodable(encoding) {
+		f.log.Warnf("skipping %s as it is encoded as %s", file.RelPath, encoding)
+
+		return false
+	}
+
+	return true
+}
+
+// TooLarge determines if file exceeds the configured MaxFileSize for this Formatter.
+func (f *Formatter) TooLarge(file *walk.File) bool {
+	return exceedsSize(file, f.maxFileSize)
+}
+
+// wantsFirstLine determines if the first line of file matches the configured MatchFirstLine expression.
+func (f *Formatter) wantsFirstLine(file *walk.File) bool {
+	if f.firstLine == nil {
+		return false
+	}
+
+	line, err := file.FirstLine()
+	if err != nil {
+		f.log.Warnf("failed to read first line of %s: %v", file.RelPath, err)
+
+		return false
+	}
+
+	return f.firstLine.MatchString(line)
+}
+
+// wantsContentType determines if the type detected from file's content is one of the configured Types.
+func (f *Formatter) wantsContentType(file *walk.File) bool {
+	contentType, err := file.ContentType()
+	if err != nil {
+		f.log.Warnf("failed to detect content type for %s: %v", file.RelPath, err)
+
+		return false
 	}
 
-	return match
+	return slices.Contains(f.types, contentType)
+}
+
+// scope renames the formatter to distinguish it from the formatters of the same name in other scopes, e.g. a formatter
+// named go which is configured by services/api/treefmt.toml becomes go@services/api.
+func (f *Formatter) scope(dir string) {
+	f.name = fmt.Sprintf("%s@%s", f.name, filepath.ToSlash(dir))
+	f.log = newLogger(f.name, f.config.Priority)
+}
+
+func newLogger(name string, priority int) *log.Logger {
+	if priority > 0 {
+		return log.WithPrefix(fmt.Sprintf("formatter | %s[%d]", name, priority))
+	}
+
+	return log.WithPrefix(fmt.Sprintf("formatter | %s", name))
 }
 
 // newFormatter is used to create a new Formatter.
@@ -135,6 +994,7 @@ func newFormatter(
 	name string,
 	treeRoot string,
 	env expand.Environ,
+	containerEngine string,
 	cfg *config.Formatter,
 ) (*Formatter, error) {
 	var err error
@@ -144,30 +1004,151 @@ func newFormatter(
 		return nil, ErrInvalidName
 	}
 
+	// expand any environment variables referenced in the command and options
+	if cfg, err = expandEnv(name, env, cfg); err != nil {
+		return nil, err
+	}
+
 	f := Formatter{}
 
 	// capture config and the formatter's name
 	f.name = name
 	f.config = cfg
 	f.workingDir = treeRoot
+	f.env = env
 
 	// test if the formatter is available
-	executable, err := interp.LookPathDir(treeRoot, env, cfg.Command)
-	if err != nil {
-		return nil, ErrCommandNotFound
-	}
+	if isBuiltin(cfg.Command) {
+		if err = f.useBuiltin(); err != nil {
+			return nil, err
+		}
+	} else if isWasm(cfg.Command) {
+		if err = f.useWasm(treeRoot); err != nil {
+			return nil, err
+		}
+	} else if cfg.Container != "" {
+		if err = f.useContainer(treeRoot, env, containerEngine); err != nil {
+			return nil, err
+		}
+	} else if cfg.Runner == "" {
+		if f.executable, err = lookPath(treeRoot, env, cfg.Command); err != nil {
+			return nil, ErrCommandNotFound
+		}
 
-	f.executable = executable
+		f.args = cfg.Options
+	} else if err = f.useRunner(treeRoot, env); err != nil {
+		return nil, err
+	}
 
 	// initialise internal state
-	if cfg.Priority > 0 {
-		f.log = log.WithPrefix(fmt.Sprintf("formatter | %s[%d]", name, cfg.Priority))
-	} else {
-		f.log = log.WithPrefix(fmt.Sprintf("formatter | %s", name))
+	f.log = newLogger(name, cfg.Priority)
+
+	switch cfg.Detect {
+	case "", "glob":
+		if len(cfg.Types) > 0 {
+			return nil, fmt.Errorf("formatter '%v' has types but detect is not set to content", f.name)
+		}
+	case "content":
+		if len(cfg.Types) == 0 {
+			return nil, fmt.Errorf("formatter '%v' detects by content but has no types", f.name)
+		}
+
+		f.types = make([]walk.ContentType, len(cfg.Types))
+
+		for i, name := range cfg.Types {
+			if f.types[i], err = walk.ContentTypeString(name); err != nil {
+				return nil, fmt.Errorf("formatter '%v' has an invalid type: %w", f.name, err)
+			}
+		}
+	default:
+		return nil, fmt.Errorf("formatter '%v' has an invalid detect value '%s', must be one of <glob|content>",
+			f.name, cfg.Detect)
+	}
+
+	if cfg.Sandbox {
+		if err = f.useSandbox(); err != nil {
+			return nil, err
+		}
+	}
+
+	if cfg.Plugin && cfg.Stdout {
+		return nil, fmt.Errorf("formatter '%v' cannot be a plugin and also write its output to stdout", f.name)
+	} else if cfg.Plugin && isWasm(cfg.Command) {
+		return nil, fmt.Errorf("formatter '%v' cannot be a plugin and also a WASM module", f.name)
+	} else if cfg.Plugin && f.builtin != nil {
+		return nil, fmt.Errorf("formatter '%v' cannot be a plugin and also a builtin formatter", f.name)
+	}
+
+	if f.maxFileSize, err = ParseSize(cfg.MaxFileSize); err != nil {
+		return nil, fmt.Errorf("formatter '%v' has an invalid max-file-size: %w", f.name, err)
+	}
+
+	if cfg.BatchSize < 0 {
+		return nil, fmt.Errorf("formatter '%v' has an invalid batch-size: must not be negative", f.name)
+	}
+
+	f.batchSize = cfg.BatchSize
+
+	switch {
+	case cfg.Parallel < 0:
+		return nil, fmt.Errorf("formatter '%v' has an invalid parallel value: must not be negative", f.name)
+	case cfg.Parallel == 0:
+		f.parallel = 1
+	default:
+		f.parallel = cfg.Parallel
+	}
+
+	if cfg.Timeout != "" {
+		if f.timeout, err = time.ParseDuration(cfg.Timeout); err != nil {
+			return nil, fmt.Errorf("formatter '%v' has an invalid timeout: %w", f.name, err)
+		}
+	}
+
+	if err = f.useLimits(); err != nil {
+		return nil, err
+	}
+
+	switch cfg.Encoding {
+	case "", EncodingIgnore, EncodingSkip, EncodingTranscode:
+	default:
+		return nil, fmt.Errorf("formatter '%v' has an invalid encoding value '%s', must be one of <%s|%s|%s>",
+			f.name, cfg.Encoding, EncodingIgnore, EncodingSkip, EncodingTranscode)
+	}
+
+	if slices.ContainsFunc(cfg.Options, func(option string) bool {
+		return strings.Contains(option, fileListPlaceholder)
+	}) {
+		if err = f.useFileList(); err != nil {
+			return nil, err
+		}
 	}
 
-	// check there is at least one include
-	if len(cfg.Includes) == 0 {
+	switch cfg.Mode {
+	case "", ModeFiles:
+		if len(cfg.RootMarkers) > 0 {
+			return nil, fmt.Errorf("formatter '%v' has root-markers but mode is not set to project", f.name)
+		}
+	case ModeProject:
+		if err = f.useProject(); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("formatter '%v' has an invalid mode '%s', must be one of <%s|%s>",
+			f.name, cfg.Mode, ModeFiles, ModeProject)
+	}
+
+	if f.outputLevel, f.streamOutput, err = parseOutput(cfg.Output); err != nil {
+		return nil, fmt.Errorf("formatter '%v' has an %w", f.name, err)
+	}
+
+	if cfg.MatchFirstLine != "" {
+		if f.firstLine, err = regexp.Compile(cfg.MatchFirstLine); err != nil {
+			return nil, fmt.Errorf("failed to compile formatter '%v' match-first-line: %w", f.name, err)
+		}
+	}
+
+	// check there is at least one include, unless we are matching on interpreters, the first line or content
+	if len(cfg.Includes) == 0 && len(cfg.Interpreters) == 0 && f.firstLine == nil && f.types == nil {
 		return nil, fmt.Errorf("formatter '%v' has no includes", f.name)
 	}
 
@@ -183,3 +1164,106 @@ func newFormatter(
 
 	return &f, nil
 }
+
+// useRunner resolves the executable and args for a formatter which is invoked with a package runner, e.g. npx.
+// If the runner only invokes tools which are installed within the project, the formatter's command must be installed,
+// otherwise ErrNotInstalled is returned.
+func (f *Formatter) useRunner(treeRoot string, env expand.Environ) error {
+	runner, ok := config.Runners[f.config.Runner]
+	if !ok {
+		return fmt.Errorf("formatter '%v' has an invalid runner '%s', must be one of <%s>",
+			f.name, f.config.Runner, strings.Join(config.RunnerNames(), "|"))
+	}
+
+	executable, err := lookPath(treeRoot, env, runner.Args[0])
+	if err != nil {
+		return fmt.Errorf("%w: runner %s", ErrCommandNotFound, runner.Args[0])
+	}
+
+	if runner.Installed {
+		// the installed command has an extension on Windows, e.g. prettier.cmd
+		if _, err = lookPath(treeRoot, env, filepath.Join("node_modules", ".bin", f.config.Command)); err != nil {
+			return fmt.Errorf("%w: %s is missing from node_modules/.bin, run your package manager's install "+
+				"command", ErrNotInstalled, f.config.Command)
+		}
+	}
+
+	f.executable = executable
+	f.args = slices.Concat(runner.Args[1:], []string{f.config.Command}, f.config.Options)
+
+	return nil
+}
+
+// useWasm resolves the path of a formatter whose Command is a WebAssembly module, relative to the tree root.
+// Such modules are run in-process rather than being found on the PATH, so they cannot be invoked with a runner.
+func (f *Formatter) useWasm(treeRoot string) error {
+	if f.config.Runner != "" {
+		return fmt.Errorf("formatter '%v' is a WASM module, so cannot have a runner", f.name)
+	} else if f.config.Container != "" {
+		return fmt.Errorf("formatter '%v' is a WASM module, so cannot have a container", f.name)
+	}
+
+	path := f.config.Command
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(treeRoot, path)
+	}
+
+	if _, err := os.Stat(path); err != nil {
+		return fmt.Errorf("%w: %s", ErrCommandNotFound, f.config.Command)
+	}
+
+	f.executable = path
+	f.args = f.config.Options
+
+	return nil
+}
+
+// useBuiltin looks up the builtin formatter referred to by Command, e.g. builtin:json.
+// Builtin formatters are part of treefmt, so the executable recorded for them is treefmt's own.
+func (f *Formatter) useBuiltin() error {
+	name := strings.TrimPrefix(f.config.Command, builtinPrefix)
+
+	var ok bool
+	if f.builtin, ok = builtins[name]; !ok {
+		return fmt.Errorf("formatter '%v' has an invalid builtin '%s', must be one of <%s>",
+			f.name, name, strings.Join(BuiltinNames(), "|"))
+	} else if f.config.Runner != "" {
+		return fmt.Errorf("formatter '%v' is a builtin formatter, so cannot have a runner", f.name)
+	} else if f.config.Container != "" {
+		return fmt.Errorf("formatter '%v' is a builtin formatter, so cannot have a container", f.name)
+	} else if len(f.config.Options) > 0 {
+		return fmt.Errorf("formatter '%v' is a builtin formatter, so cannot have options", f.name)
+	}
+
+	executable, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to determine the path of the treefmt executable: %w", err)
+	}
+
+	f.executable = executable
+
+	return nil
+}
+
+// useSandbox restricts the formatter's processes to writing the files they are applied to, if the platform allows.
+// Formatters which are not run as a process for each batch of files cannot be sandboxed.
+func (f *Formatter) useSandbox() error {
+	switch {
+	case f.config.Plugin:
+		return fmt.Errorf("formatter '%v' cannot be a plugin and also be sandboxed", f.name)
+	case f.builtin != nil || isWasm(f.config.Command):
+		return fmt.Errorf("formatter '%v' is run in-process, so cannot be sandboxed", f.name)
+	case f.container != nil:
+		return fmt.Errorf("formatter '%v' is run within a container, so cannot be sandboxed", f.name)
+	}
+
+	if err := processSandbox.Available(); err != nil {
+		f.log.Warnf("running without a sandbox: %v", err)
+
+		return nil
+	}
+
+	f.sandboxed = true
+
+	return nil
+}
@@ -2,6 +2,7 @@ package format
 
 import (
 	"fmt"
+	"path/filepath"
 
 	"github.com/gobwas/glob"
 )
@@ -22,7 +23,11 @@ func compileGlobs(patterns []string) ([]glob.Glob, error) {
 	return globs, nil
 }
 
+// pathMatches reports whether path matches any of globs.
+// Patterns are always slash separated, so path is converted to match them on Windows.
 func pathMatches(path string, globs []glob.Glob) bool {
+	path = filepath.ToSlash(path)
+
 	for idx := range globs {
 		if globs[idx].Match(path) {
 			return true
@@ -31,3 +36,17 @@ func pathMatches(path string, globs []glob.Glob) bool {
 
 	return false
 }
+
+// matchingPattern returns the first of patterns which matches path, or an empty string if none of them match.
+// globs must be the compiled form of patterns.
+func matchingPattern(path string, globs []glob.Glob, patterns []string) string {
+	path = filepath.ToSlash(path)
+
+	for idx := range globs {
+		if globs[idx].Match(path) {
+			return patterns[idx]
+		}
+	}
+
+	return ""
+}
@@ -2,6 +2,7 @@
 package format
 
 import (
+	"path/filepath"
 	"testing"
 
 	"github.com/gobwas/glob"
@@ -37,4 +38,11 @@ func TestGlobs(t *testing.T) {
 	r.True(pathMatches("LICENSE", globs))
 	r.False(pathMatches("test/LICENSE", globs))
 	r.False(pathMatches("LICENSE.txt", globs))
+
+	// Paths use the platform's separator, e.g. a backslash on windows, whilst patterns are always slash separated
+	globs, err = compileGlobs([]string{"test/foo/*.txt"})
+	r.NoError(err)
+	r.True(pathMatches(filepath.Join("test", "foo", "bar.txt"), globs))
+	r.Equal("test/foo/*.txt", matchingPattern(filepath.Join("test", "foo", "bar.txt"), globs, []string{"test/foo/*.txt"}))
+	r.False(pathMatches(filepath.Join("test", "bar.txt"), globs))
 }
@@ -0,0 +1,85 @@
+package format
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/charmbracelet/log"
+	"github.com/numtide/treefmt/v2/config"
+	"mvdan.cc/sh/v3/expand"
+	"mvdan.cc/sh/v3/interp"
+	"mvdan.cc/sh/v3/syntax"
+)
+
+// RunHook runs command, one of the hooks of cfg, with the tree root as the working directory and vars added to the
+// environment, along with TREEFMT_HOOK and TREEFMT_ROOT.
+// Hooks are not run in check or dry-run mode, as they would otherwise be able to modify the tree.
+func RunHook(ctx context.Context, cfg *config.Config, name string, command string, vars map[string]string) error {
+	if cfg.Check || cfg.DryRun {
+		log.Debugf("skipping %s hook, as the tree is not modified in check or dry-run mode", name)
+
+		return nil
+	}
+
+	return runHook(ctx, lookupEnv(cfg), cfg.TreeRoot, name, command, vars)
+}
+
+// runHook interprets command with the shell built into treefmt, so hooks behave the same on every platform.
+// Anything the hook writes to stdout or stderr is written to stderr, keeping stdout free for formatted output.
+func runHook(
+	ctx context.Context,
+	env expand.Environ,
+	treeRoot string,
+	name string,
+	command string,
+	vars map[string]string,
+) error {
+	script, err := syntax.NewParser().Parse(strings.NewReader(command), name)
+	if err != nil {
+		return fmt.Errorf("failed to parse %s hook: %w", name, err)
+	}
+
+	var environ []string
+
+	env.Each(func(name string, vr expand.Variable) bool {
+		if vr.Exported {
+			environ = append(environ, name+"="+vr.String())
+		}
+
+		return true
+	})
+
+	// later entries take precedence over earlier ones with the same name
+	environ = append(environ, "TREEFMT_HOOK="+name, "TREEFMT_ROOT="+treeRoot)
+
+	keys := make([]string, 0, len(vars))
+	for key := range vars {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		environ = append(environ, key+"="+vars[key])
+	}
+
+	runner, err := interp.New(
+		interp.Dir(treeRoot),
+		interp.Env(expand.ListEnviron(environ...)),
+		interp.StdIO(nil, os.Stderr, os.Stderr),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create %s hook runner: %w", name, err)
+	}
+
+	log.Debugf("running %s hook: %s", name, command)
+
+	if err = runner.Run(ctx, script); err != nil {
+		return fmt.Errorf("%s hook failed: %w", name, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,71 @@
+package format
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/numtide/treefmt/v2/config"
+	processSandbox "github.com/numtide/treefmt/v2/sandbox"
+)
+
+const (
+	// niceMin and niceMax are the bounds of the niceness of a process, from the highest priority to the lowest.
+	niceMin = -20
+	niceMax = 19
+)
+
+// parseLimits parses and validates the Nice, CPULimit and MemoryLimit of a formatter.
+func parseLimits(cfg *config.Formatter) (processSandbox.Limits, error) {
+	var (
+		limits processSandbox.Limits
+		err    error
+	)
+
+	if cfg.Nice < niceMin || cfg.Nice > niceMax {
+		return limits, fmt.Errorf("invalid nice value %d, must be between %d and %d", cfg.Nice, niceMin, niceMax)
+	}
+
+	limits.Nice = cfg.Nice
+
+	if cfg.CPULimit != "" {
+		if limits.CPU, err = time.ParseDuration(cfg.CPULimit); err != nil {
+			return limits, fmt.Errorf("invalid cpu-limit: %w", err)
+		} else if limits.CPU <= 0 {
+			return limits, errors.New("invalid cpu-limit: must be positive")
+		}
+	}
+
+	if limits.Memory, err = ParseSize(cfg.MemoryLimit); err != nil {
+		return limits, fmt.Errorf("invalid memory-limit: %w", err)
+	}
+
+	return limits, nil
+}
+
+// useLimits parses the formatter's resource limits, which are applied to each of its processes if the platform allows.
+// Formatters which are run in-process, or within a container, do not have processes of their own to limit.
+func (f *Formatter) useLimits() error {
+	limits, err := parseLimits(f.config)
+
+	switch {
+	case err != nil:
+		return fmt.Errorf("formatter '%v' has an %w", f.name, err)
+	case limits == processSandbox.Limits{}:
+		return nil
+	case f.builtin != nil || isWasm(f.config.Command):
+		return fmt.Errorf("formatter '%v' is run in-process, so cannot have resource limits", f.name)
+	case f.container != nil:
+		return fmt.Errorf("formatter '%v' is run within a container, so cannot have resource limits", f.name)
+	}
+
+	if err = processSandbox.LimitsAvailable(); err != nil {
+		f.log.Warnf("running without resource limits: %v", err)
+
+		return nil
+	}
+
+	f.limits = &limits
+
+	return nil
+}
@@ -0,0 +1,35 @@
+//nolint:testpackage
+package format
+
+import (
+	"testing"
+	"time"
+
+	"github.com/numtide/treefmt/v2/config"
+	processSandbox "github.com/numtide/treefmt/v2/sandbox"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseLimits(t *testing.T) {
+	r := require.New(t)
+
+	limits, err := parseLimits(&config.Formatter{})
+	r.NoError(err)
+	r.Equal(processSandbox.Limits{}, limits)
+
+	limits, err = parseLimits(&config.Formatter{Nice: 10, CPULimit: "1m30s", MemoryLimit: "512MiB"})
+	r.NoError(err)
+	r.Equal(processSandbox.Limits{Nice: 10, CPU: 90 * time.Second, Memory: 512 * 1024 * 1024}, limits)
+
+	for _, cfg := range []*config.Formatter{
+		{Nice: -21},
+		{Nice: 20},
+		{CPULimit: "10"},
+		{CPULimit: "0s"},
+		{CPULimit: "-1s"},
+		{MemoryLimit: "lots"},
+	} {
+		_, err = parseLimits(cfg)
+		r.Error(err, cfg)
+	}
+}
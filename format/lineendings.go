@@ -0,0 +1,146 @@
+package format
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/numtide/treefmt/v2/walk"
+)
+
+// The values of line-endings, which determine the line endings of each file once it has been formatted.
+const (
+	// LineEndingsFormatter leaves line endings to the formatters.
+	LineEndingsFormatter = "formatter"
+	// LineEndingsPreserve restores the line endings each file had before it was formatted.
+	LineEndingsPreserve = "preserve"
+	// LineEndingsLF converts the line endings of each formatted file to \n.
+	LineEndingsLF = "lf"
+	// LineEndingsCRLF converts the line endings of each formatted file to \r\n.
+	LineEndingsCRLF = "crlf"
+)
+
+//nolint:gochecknoglobals
+var (
+	lf   = []byte("\n")
+	crlf = []byte("\r\n")
+)
+
+// lineEndings restores or enforces the line endings of a batch of files once the formatters have been applied.
+type lineEndings struct {
+	files []*walk.File
+	// endings contains the line ending to be used for each file, or nil if it should be left alone
+	endings [][]byte
+	// hashes and modTimes record the content and modification time of each file before it was formatted
+	hashes   [][sha256.Size]byte
+	modTimes []time.Time
+}
+
+// apply converts the line endings of each file, rewriting only those whose content is changed.
+// If a file ends up with the same content it had before it was formatted, e.g. because a formatter only changed its
+// line endings, its modification time is restored so it is not reported as changed.
+func (l *lineEndings) apply() error {
+	for i, file := range l.files {
+		content, err := os.ReadFile(file.Path)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", file.Path, err)
+		}
+
+		// the formatter may have produced binary content, which has no lines
+		if l.endings[i] != nil && bytes.IndexByte(content, 0) == -1 {
+			if converted := convertLineEndings(content, l.endings[i]); !bytes.Equal(converted, content) {
+				// the permissions of an existing file are not changed by WriteFile
+				if err = os.WriteFile(file.Path, converted, 0o600); err != nil {
+					return fmt.Errorf("failed to write line endings of %s: %w", file.Path, err)
+				}
+
+				content = converted
+			}
+		}
+
+		if sha256.Sum256(content) != l.hashes[i] {
+			continue
+		}
+
+		if err = os.Chtimes(file.Path, time.Time{}, l.modTimes[i]); err != nil {
+			return fmt.Errorf("failed to restore modification time of %s: %w", file.Path, err)
+		}
+	}
+
+	return nil
+}
+
+// newLineEndings determines the line endings to be used for each of files once they have been formatted, according
+// to mode, recording the state of each file beforehand. It returns nil if line endings are left to the formatters.
+func newLineEndings(mode string, files []*walk.File) (*lineEndings, error) {
+	if mode == LineEndingsFormatter {
+		return nil, nil
+	}
+
+	l := &lineEndings{
+		files:    files,
+		endings:  make([][]byte, len(files)),
+		hashes:   make([][sha256.Size]byte, len(files)),
+		modTimes: make([]time.Time, len(files)),
+	}
+
+	for i, file := range files {
+		content, err := os.ReadFile(file.Path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", file.Path, err)
+		}
+
+		info, err := os.Stat(file.Path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to stat %s: %w", file.Path, err)
+		}
+
+		l.hashes[i] = sha256.Sum256(content)
+		l.modTimes[i] = info.ModTime()
+
+		switch mode {
+		case LineEndingsLF:
+			l.endings[i] = lf
+		case LineEndingsCRLF:
+			l.endings[i] = crlf
+		case LineEndingsPreserve:
+			l.endings[i] = detectLineEnding(content)
+		}
+	}
+
+	return l, nil
+}
+
+// detectLineEnding returns the line ending used throughout content, or nil if it has no line endings, has a mix of
+// them, or appears to be binary.
+func detectLineEnding(content []byte) []byte {
+	if bytes.IndexByte(content, 0) != -1 {
+		return nil
+	}
+
+	lines := bytes.Count(content, lf)
+	crlfLines := bytes.Count(content, crlf)
+
+	switch {
+	case lines == 0:
+		return nil
+	case crlfLines == 0:
+		return lf
+	case crlfLines == lines:
+		return crlf
+	default:
+		return nil
+	}
+}
+
+// convertLineEndings replaces every line ending within content with ending.
+func convertLineEndings(content []byte, ending []byte) []byte {
+	content = bytes.ReplaceAll(content, crlf, lf)
+	if bytes.Equal(ending, crlf) {
+		content = bytes.ReplaceAll(content, lf, crlf)
+	}
+
+	return content
+}
@@ -0,0 +1,21 @@
+//nolint:testpackage
+package format
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLineEndings(t *testing.T) {
+	r := require.New(t)
+
+	r.Equal(lf, detectLineEnding([]byte("a\nb\n")))
+	r.Equal(crlf, detectLineEnding([]byte("a\r\nb\r\n")))
+	r.Nil(detectLineEnding([]byte("a\r\nb\n")), "mixed line endings are left alone")
+	r.Nil(detectLineEnding([]byte("a")), "there are no line endings to preserve")
+	r.Nil(detectLineEnding([]byte("a\x00\n")), "binary content has no lines")
+
+	r.Equal("a\nb\nc", string(convertLineEndings([]byte("a\r\nb\nc"), lf)))
+	r.Equal("a\r\nb\r\nc", string(convertLineEndings([]byte("a\r\nb\nc"), crlf)))
+}
@@ -0,0 +1,86 @@
+package format
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+
+	"github.com/numtide/treefmt/v2/walk"
+)
+
+// pathLocks prevents formatters which are applied at the same time, such as those which share a stage, from writing
+// to the same file at once. It is shared by all formatters, in the same way as their job slots.
+type pathLocks struct {
+	lock sync.Mutex
+	held map[string]bool
+	// released is closed, and replaced, whenever files are unlocked
+	released chan struct{}
+}
+
+func newPathLocks() *pathLocks {
+	return &pathLocks{
+		held:     make(map[string]bool),
+		released: make(chan struct{}),
+	}
+}
+
+// acquire waits until any of the chunks which have not yet been claimed has none of its files locked, then claims it
+// and locks its files, returning its index and a function which unlocks them. Rather than each of a formatter's
+// processes waiting for the files of a particular chunk, they can then apply chunks whilst another formatter applies
+// the rest. If ctx is cancelled, an unclaimed chunk is still claimed and returned alongside the error, so that its
+// files can be reported as failed.
+func (l *pathLocks) acquire(ctx context.Context, chunks [][]*walk.File, claimed []bool) (int, func(), error) {
+	for {
+		l.lock.Lock()
+
+		for i, chunk := range chunks {
+			if claimed[i] || slices.ContainsFunc(chunk, func(file *walk.File) bool { return l.held[file.Path] }) {
+				continue
+			}
+
+			claimed[i] = true
+
+			for _, file := range chunk {
+				l.held[file.Path] = true
+			}
+
+			l.lock.Unlock()
+
+			return i, func() { l.release(chunk) }, nil
+		}
+
+		released := l.released
+
+		l.lock.Unlock()
+
+		select {
+		case <-released:
+		case <-ctx.Done():
+			return l.abandon(claimed), nil, fmt.Errorf("failed to lock files: %w", ctx.Err())
+		}
+	}
+}
+
+func (l *pathLocks) release(files []*walk.File) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	for _, file := range files {
+		delete(l.held, file.Path)
+	}
+
+	close(l.released)
+	l.released = make(chan struct{})
+}
+
+// abandon claims the first chunk which has not yet been claimed, without locking its files.
+func (l *pathLocks) abandon(claimed []bool) int {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	i := slices.Index(claimed, false)
+	claimed[i] = true
+
+	return i
+}
@@ -0,0 +1,59 @@
+//nolint:testpackage
+package format
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/numtide/treefmt/v2/walk"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPathLocks(t *testing.T) {
+	r := require.New(t)
+
+	a, b := &walk.File{Path: "/a"}, &walk.File{Path: "/b"}
+	chunks := [][]*walk.File{{a}, {b}}
+
+	locks := newPathLocks()
+
+	// another formatter is applying the first chunk, so the second is claimed instead
+	_, unlockA, err := locks.acquire(context.Background(), [][]*walk.File{{a}}, []bool{false})
+	r.NoError(err)
+
+	claimed := make([]bool, len(chunks))
+
+	i, unlockB, err := locks.acquire(context.Background(), chunks, claimed)
+	r.NoError(err)
+	r.Equal(1, i)
+
+	// the first chunk is claimed once it has been unlocked
+	acquired := make(chan int)
+
+	go func() {
+		i, unlock, _ := locks.acquire(context.Background(), chunks, claimed)
+		unlock()
+
+		acquired <- i
+	}()
+
+	select {
+	case <-acquired:
+		r.Fail("acquired a locked chunk")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	unlockA()
+	r.Equal(0, <-acquired)
+
+	// if the context is cancelled whilst waiting, an unclaimed chunk is returned so its files can be reported
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	i, _, err = locks.acquire(ctx, [][]*walk.File{{b}}, []bool{false})
+	r.ErrorIs(err, context.Canceled)
+	r.Equal(0, i)
+
+	unlockB()
+}
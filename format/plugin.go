@@ -0,0 +1,359 @@
+package format
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+
+	"github.com/charmbracelet/log"
+	processSandbox "github.com/numtide/treefmt/v2/sandbox"
+	"github.com/numtide/treefmt/v2/walk"
+)
+
+// pluginProtocolVersion is the version of the plugin protocol which treefmt speaks.
+const pluginProtocolVersion = 1
+
+// pluginShutdownTimeout is how long a plugin is given to exit after being asked to shut down, before it is killed.
+const pluginShutdownTimeout = 5 * time.Second
+
+// The plugin protocol is newline-delimited JSON over the plugin's stdin and stdout. treefmt sends requests, each with a
+// unique id, and the plugin replies with a response carrying the same id. Requests may be sent concurrently, so the
+// plugin may reply to them in any order.
+type (
+	pluginRequest struct {
+		ID     int64  `json:"id"`
+		Method string `json:"method"`
+		Params any    `json:"params,omitempty"`
+	}
+
+	pluginResponse struct {
+		ID     int64           `json:"id"`
+		Result json.RawMessage `json:"result,omitempty"`
+		Error  *pluginError    `json:"error,omitempty"`
+	}
+
+	pluginError struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	}
+
+	// pluginInitializeParams are sent once the plugin has started, before any other request.
+	pluginInitializeParams struct {
+		Version int    `json:"version"`
+		Root    string `json:"root"`
+	}
+
+	pluginInitializeResult struct {
+		Version int `json:"version"`
+		// Contents indicates the plugin wants the content of each file sent with format requests, and replies with
+		// the formatted content rather than modifying the files in place.
+		Contents bool `json:"contents"`
+	}
+
+	pluginFormatParams struct {
+		Files []pluginFile `json:"files"`
+	}
+
+	pluginFormatResult struct {
+		Files []pluginFile `json:"files"`
+	}
+
+	pluginFile struct {
+		// Path is relative to the root sent with the initialize request.
+		Path string `json:"path"`
+		// Content is only set if the plugin asked for contents. In a reply, it is omitted if the file is unchanged.
+		Content     *string            `json:"content,omitempty"`
+		Diagnostics []pluginDiagnostic `json:"diagnostics,omitempty"`
+	}
+
+	pluginDiagnostic struct {
+		Line     int    `json:"line,omitempty"`
+		Column   int    `json:"column,omitempty"`
+		Severity string `json:"severity,omitempty"` // error, warning or info
+		Message  string `json:"message"`
+	}
+)
+
+func (e *pluginError) Error() string {
+	return fmt.Sprintf("%s (code %d)", e.Message, e.Code)
+}
+
+func (d pluginDiagnostic) format(path string) string {
+	switch {
+	case d.Line > 0 && d.Column > 0:
+		return fmt.Sprintf("%s:%d:%d: %s", path, d.Line, d.Column, d.Message)
+	case d.Line > 0:
+		return fmt.Sprintf("%s:%d: %s", path, d.Line, d.Message)
+	default:
+		return fmt.Sprintf("%s: %s", path, d.Message)
+	}
+}
+
+// plugin is a long-lived formatter process which speaks the plugin protocol.
+type plugin struct {
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stderr *lineLogger
+
+	// contents is set if the plugin asked for the content of files, rather than their paths alone
+	contents bool
+
+	nextID    atomic.Int64
+	writeLock sync.Mutex
+
+	pendingLock sync.Mutex
+	pending     map[int64]chan *pluginResponse
+
+	// done is closed once the plugin's stdout has been closed, with err describing why
+	done chan struct{}
+	err  error
+}
+
+// startPlugin starts the formatter's plugin process and initializes it.
+func startPlugin(ctx context.Context, f *Formatter) (*plugin, error) {
+	executable, args := f.command(f.workingDir, f.args)
+
+	cmd := exec.Command(executable, args...) //nolint:gosec
+	cmd.Dir = f.workingDir
+
+	p := &plugin{
+		cmd:     cmd,
+		pending: make(map[int64]chan *pluginResponse),
+		done:    make(chan struct{}),
+	}
+
+	// the plugin's stderr is logged in the same way as the output of any other formatter
+	if p.stderr = f.outputStream(); p.stderr != nil {
+		cmd.Stderr = p.stderr
+	}
+
+	var err error
+
+	if p.stdin, err = cmd.StdinPipe(); err != nil {
+		return nil, fmt.Errorf("failed to create stdin pipe: %w", err)
+	}
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create stdout pipe: %w", err)
+	}
+
+	if f.limits != nil {
+		if err = processSandbox.Limit(cmd, *f.limits); err != nil {
+			return nil, fmt.Errorf("failed to limit plugin: %w", err)
+		}
+	}
+
+	f.log.Debugf("starting plugin: %s", cmd.String())
+
+	if err = cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start plugin: %w", err)
+	}
+
+	go p.read(stdout, f.log)
+
+	if f.timeout > 0 {
+		var cancel context.CancelFunc
+
+		ctx, cancel = context.WithTimeout(ctx, f.timeout)
+		defer cancel()
+	}
+
+	var result pluginInitializeResult
+
+	params := pluginInitializeParams{Version: pluginProtocolVersion, Root: f.workingDir}
+	if f.container != nil {
+		params.Root = containerPath(f.workingDir)
+	}
+
+	if err = p.call(ctx, "initialize", params, &result); err != nil {
+		_ = p.kill()
+
+		return nil, fmt.Errorf("failed to initialize plugin: %w", err)
+	} else if result.Version != pluginProtocolVersion {
+		_ = p.kill()
+
+		return nil, fmt.Errorf(
+			"plugin speaks version %d of the protocol, but version %d is required", result.Version, pluginProtocolVersion,
+		)
+	}
+
+	p.contents = result.Contents
+
+	return p, nil
+}
+
+// read dispatches each response written to the plugin's stdout to the request awaiting it.
+func (p *plugin) read(stdout io.Reader, logger *log.Logger) {
+	reader := bufio.NewReader(stdout)
+
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(bytes.TrimSpace(line)) > 0 {
+			p.dispatch(line, logger)
+		}
+
+		if errors.Is(err, io.EOF) {
+			p.err = errors.New("plugin exited unexpectedly")
+		} else if err != nil {
+			p.err = fmt.Errorf("failed to read from plugin: %w", err)
+		}
+
+		if err != nil {
+			close(p.done)
+
+			return
+		}
+	}
+}
+
+func (p *plugin) dispatch(line []byte, logger *log.Logger) {
+	var resp pluginResponse
+	if err := json.Unmarshal(line, &resp); err != nil {
+		logger.Warnf("ignoring invalid response from plugin: %v", err)
+
+		return
+	}
+
+	p.pendingLock.Lock()
+	ch, ok := p.pending[resp.ID]
+	p.pendingLock.Unlock()
+
+	if !ok {
+		logger.Warnf("ignoring response from plugin to unknown request %d", resp.ID)
+
+		return
+	}
+
+	ch <- &resp
+}
+
+// call sends a request to the plugin and waits for its response, decoding the result into result if it is not nil.
+func (p *plugin) call(ctx context.Context, method string, params any, result any) error {
+	id := p.nextID.Add(1)
+	ch := make(chan *pluginResponse, 1)
+
+	p.pendingLock.Lock()
+	p.pending[id] = ch
+	p.pendingLock.Unlock()
+
+	defer func() {
+		p.pendingLock.Lock()
+		delete(p.pending, id)
+		p.pendingLock.Unlock()
+	}()
+
+	data, err := json.Marshal(pluginRequest{ID: id, Method: method, Params: params})
+	if err != nil {
+		return fmt.Errorf("failed to encode %s request: %w", method, err)
+	}
+
+	p.writeLock.Lock()
+	_, err = p.stdin.Write(append(data, '\n'))
+	p.writeLock.Unlock()
+
+	if err != nil {
+		return fmt.Errorf("failed to send %s request: %w", method, err)
+	}
+
+	select {
+	case resp := <-ch:
+		if resp.Error != nil {
+			return resp.Error
+		} else if result == nil {
+			return nil
+		} else if err = json.Unmarshal(resp.Result, result); err != nil {
+			return fmt.Errorf("failed to decode %s response: %w", method, err)
+		}
+
+		return nil
+	case <-p.done:
+		return p.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// format sends the given files to the plugin, returning its response.
+func (p *plugin) format(ctx context.Context, files []*walk.File) (*pluginFormatResult, error) {
+	params := pluginFormatParams{Files: make([]pluginFile, len(files))}
+
+	for i, file := range files {
+		params.Files[i].Path = file.RelPath
+
+		if !p.contents {
+			continue
+		}
+
+		content, err := os.ReadFile(file.Path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", file.Path, err)
+		} else if !utf8.Valid(content) {
+			return nil, fmt.Errorf("cannot send %s to plugin: content is not valid UTF-8", file.RelPath)
+		}
+
+		str := string(content)
+		params.Files[i].Content = &str
+	}
+
+	var result pluginFormatResult
+	if err := p.call(ctx, "format", params, &result); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+// close asks the plugin to shut down, killing it if it does not exit in time.
+func (p *plugin) close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), pluginShutdownTimeout)
+	defer cancel()
+
+	if p.stderr != nil {
+		defer p.stderr.Flush()
+	}
+
+	shutdownErr := p.call(ctx, "shutdown", nil, nil)
+
+	// closing stdin signals the plugin to exit
+	_ = p.stdin.Close()
+
+	select {
+	case <-p.done:
+	case <-ctx.Done():
+		return errors.Join(shutdownErr, p.kill(), errors.New("plugin did not exit after shutdown"))
+	}
+
+	if err := p.cmd.Wait(); err != nil {
+		return errors.Join(shutdownErr, fmt.Errorf("plugin exited with an error: %w", err))
+	}
+
+	if shutdownErr != nil {
+		return fmt.Errorf("failed to shut down plugin: %w", shutdownErr)
+	}
+
+	return nil
+}
+
+// kill terminates the plugin, waiting for it to exit.
+func (p *plugin) kill() error {
+	if err := p.cmd.Process.Kill(); err != nil {
+		return fmt.Errorf("failed to kill plugin: %w", err)
+	}
+
+	<-p.done
+
+	_ = p.cmd.Wait()
+
+	return nil
+}
@@ -0,0 +1,24 @@
+//go:build !unix
+
+package format
+
+import (
+	"os/exec"
+)
+
+// setProcessGroup is a no-op on platforms without process groups.
+func setProcessGroup(_ *exec.Cmd) {}
+
+// killProcessGroup kills the process for cmd, as we are unable to kill any processes it has started.
+func killProcessGroup(cmd *exec.Cmd) error {
+	return cmd.Process.Kill()
+}
+
+// argMax is the limit on the length of a command line on Windows, which we also apply to any other platforms.
+const argMax = 32 * 1024
+
+// baseArgsSize estimates how much of argMax is consumed by args. Unlike on unix, the environment does not count
+// towards the limit.
+func baseArgsSize(args []string) int {
+	return argsSize(args)
+}
@@ -0,0 +1,29 @@
+//go:build unix
+
+package format
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// setProcessGroup places the command in its own process group, so that it can be killed along with any processes it
+// has started.
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+// killProcessGroup kills the process group created for cmd by setProcessGroup.
+func killProcessGroup(cmd *exec.Cmd) error {
+	return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+}
+
+// argMax is a conservative limit on the combined size of the arguments and environment passed to a new process.
+// The actual limit varies between platforms and is typically much larger, e.g. 2MiB on Linux and 1MiB on macOS.
+const argMax = 256 * 1024
+
+// baseArgsSize estimates how much of argMax is consumed by args, along with the environment inherited by a new process.
+func baseArgsSize(args []string) int {
+	return argsSize(args) + argsSize(os.Environ())
+}
@@ -0,0 +1,159 @@
+package format
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/numtide/treefmt/v2/walk"
+)
+
+const (
+	// ModeFiles passes the formatter the paths of the files it is applied to.
+	ModeFiles = "files"
+	// ModeProject invokes the formatter once within the root of each project containing the files it is applied to,
+	// without passing it their paths.
+	ModeProject = "project"
+)
+
+// useProject validates a formatter which is run once within each project, rather than being passed files. Such
+// formatters can only be run as a process, which finds the files to format for itself.
+func (f *Formatter) useProject() error {
+	switch {
+	case len(f.config.RootMarkers) == 0:
+		return fmt.Errorf("formatter '%v' is run once per project, so must have root-markers", f.name)
+	case f.config.Stdout:
+		return fmt.Errorf("formatter '%v' is run once per project, so cannot write its output to stdout", f.name)
+	case f.config.Plugin:
+		return fmt.Errorf("formatter '%v' is run once per project, so cannot be a plugin", f.name)
+	case f.builtin != nil || isWasm(f.config.Command):
+		return fmt.Errorf("formatter '%v' is run in-process, so cannot be run once per project", f.name)
+	case f.config.BatchSize > 0:
+		return fmt.Errorf("formatter '%v' is run once per project, so cannot have a batch-size", f.name)
+	}
+
+	for _, marker := range f.config.RootMarkers {
+		if marker == "" || strings.ContainsAny(marker, `/\`) {
+			return fmt.Errorf("formatter '%v' has an invalid root marker '%s', must be the name of a file",
+				f.name, marker)
+		}
+	}
+
+	f.projectRoots = make(map[string]string)
+
+	return nil
+}
+
+// wantsProject reports whether file is within a project, if the formatter is run once per project.
+func (f *Formatter) wantsProject(file *walk.File) bool {
+	if f.config.Mode != ModeProject {
+		return true
+	}
+
+	if f.projectRoot(file) == "" {
+		f.log.Debugf("no project root found for %s", file.RelPath)
+
+		return false
+	}
+
+	return true
+}
+
+// projects divides files between the projects containing them, returning the root of each project, relative to the
+// tree root, alongside its files. Files which are not within a project are not wanted by the formatter, but are left
+// out regardless, in case a root marker has since been removed.
+func (f *Formatter) projects(files []*walk.File) ([]string, [][]*walk.File) {
+	var (
+		roots  []string
+		chunks [][]*walk.File
+	)
+
+	index := make(map[string]int)
+
+	for _, file := range files {
+		root := f.projectRoot(file)
+		if root == "" {
+			continue
+		}
+
+		i, ok := index[root]
+		if !ok {
+			i = len(roots)
+			index[root] = i
+
+			roots = append(roots, root)
+			chunks = append(chunks, nil)
+		}
+
+		chunks[i] = append(chunks[i], file)
+	}
+
+	return roots, chunks
+}
+
+// projectRoot returns the root of the project containing file, relative to the tree root, or an empty string if it is
+// not within a project.
+func (f *Formatter) projectRoot(file *walk.File) string {
+	f.projectRootsLock.Lock()
+	defer f.projectRootsLock.Unlock()
+
+	return f.findProjectRoot(filepath.Dir(file.RelPath))
+}
+
+// findProjectRoot returns the nearest directory, from dir up to the tree root, which contains any of the formatter's
+// root markers, caching the result for each directory it searches.
+func (f *Formatter) findProjectRoot(dir string) string {
+	if root, ok := f.projectRoots[dir]; ok {
+		return root
+	}
+
+	var root string
+
+	for _, marker := range f.config.RootMarkers {
+		if _, err := os.Stat(filepath.Join(f.workingDir, dir, marker)); err == nil {
+			root = dir
+
+			break
+		}
+	}
+
+	if root == "" && dir != "." {
+		root = f.findProjectRoot(filepath.Dir(dir))
+	}
+
+	f.projectRoots[dir] = root
+
+	return root
+}
+
+// applyProject invokes the formatter within the root of a project, relative to the tree root, leaving it to find the
+// files to format for itself. If the formatter fails, its combined output is returned alongside the error.
+func (f *Formatter) applyProject(ctx context.Context, root string) ([]byte, error) {
+	var out bytes.Buffer
+
+	// the output is still captured in full, so it can be reported if the formatter fails
+	output := io.Writer(&out)
+
+	if stream := f.outputStream(); stream != nil {
+		defer stream.Flush()
+
+		output = io.MultiWriter(&out, stream)
+	}
+
+	dir := filepath.Join(f.workingDir, root)
+
+	// a sandboxed formatter can write to any file within the project
+	if err := f.run(ctx, dir, f.args, []string{dir}, output, output); err != nil {
+		f.log.Errorf("failed to apply with options '%v' in %s: %s", f.config.Options, root, err)
+
+		return out.Bytes(), fmt.Errorf(
+			"formatter '%s' with options '%v' failed to apply in %s: %w", f.config.Command, f.config.Options, root, err,
+		)
+	}
+
+	return nil, nil
+}
@@ -0,0 +1,171 @@
+package format
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/numtide/treefmt/v2/stats"
+	"github.com/numtide/treefmt/v2/walk"
+	"github.com/numtide/treefmt/v2/walk/cache"
+	"golang.org/x/sync/errgroup"
+)
+
+const (
+	// remoteConcurrency is the maximum number of requests made to the remote cache at once for each batch.
+	remoteConcurrency = 16
+	// remoteTimeout is how long we wait for the requests made to the remote cache for each batch.
+	remoteTimeout = 30 * time.Second
+)
+
+// hashContent returns the hash of the content of the file at path.
+func hashContent(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err = io.Copy(h, f); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	return h.Sum(nil), nil
+}
+
+// remoteUnavailable is called when a request to the remote cache fails, disabling it for the rest of the run.
+// Formatting can always proceed without the remote cache, so we only warn about it once.
+func (s *scheduler) remoteUnavailable(err error) {
+	if s.remoteFailed.CompareAndSwap(false, true) {
+		log.Warnf("remote cache is unavailable, continuing without it: %v", err)
+	}
+}
+
+// remoteEach calls fn for each of n entries concurrently, with at most remoteConcurrency requests in flight at once.
+// If any request fails or they take longer than remoteTimeout altogether, the rest are cancelled and the remote cache
+// is disabled for the rest of the run, so a slow or unreachable remote cannot hold up formatting for long.
+func (s *scheduler) remoteEach(ctx context.Context, n int, fn func(ctx context.Context, i int) error) bool {
+	ctx, cancel := context.WithTimeout(ctx, remoteTimeout)
+	defer cancel()
+
+	eg, ctx := errgroup.WithContext(ctx)
+	eg.SetLimit(remoteConcurrency)
+
+	for i := range n {
+		eg.Go(func() error {
+			return fn(ctx, i)
+		})
+	}
+
+	if err := eg.Wait(); err != nil {
+		s.remoteUnavailable(err)
+
+		return false
+	}
+
+	return true
+}
+
+// fetchRemote looks up each file in batch in the remote cache, using the portable signature of the formatters which
+// are to be applied to it.
+// Files which the remote cache shows are already formatted are released, updating the local cache, and the remaining
+// files which still need formatting are returned.
+func (s *scheduler) fetchRemote(ctx context.Context, sig signature, batch []*walk.File) ([]*walk.File, error) {
+	if s.remoteFailed.Load() {
+		return batch, nil
+	}
+
+	keys := make([]string, len(batch))
+
+	for i, file := range batch {
+		hash, err := hashContent(file.Path)
+		if err != nil {
+			return nil, err
+		}
+
+		keys[i] = cache.Key(sig, hash)
+	}
+
+	found := make([]bool, len(batch))
+
+	if !s.remoteEach(ctx, len(batch), func(ctx context.Context, i int) (err error) {
+		found[i], err = s.remote.Has(ctx, keys[i])
+
+		return err
+	}) {
+		return batch, nil
+	}
+
+	// releasing a file we have found in the remote cache adds it to the local cache
+	releaseCtx := walk.SetNoCache(ctx, false)
+
+	remaining := make([]*walk.File, 0, len(batch))
+
+	for i, file := range batch {
+		if !found[i] {
+			remaining = append(remaining, file)
+
+			continue
+		}
+
+		log.Debug("found file in remote cache", "path", file.RelPath)
+		s.stats.Add(stats.Cached, 1)
+
+		if err := file.Release(releaseCtx); err != nil {
+			return nil, fmt.Errorf("failed to release file: %w", err)
+		}
+	}
+
+	return remaining, nil
+}
+
+// storeRemote records in the remote cache that the content of each file in batch is formatted, once the formatters
+// with the portable signature sig have been applied to targets.
+func (s *scheduler) storeRemote(ctx context.Context, sig signature, targets []*walk.File) error {
+	if s.remoteFailed.Load() {
+		return nil
+	}
+
+	keys := make([]string, len(targets))
+
+	for i, file := range targets {
+		// in check mode, the formatted content is in the sandbox
+		hash, err := hashContent(file.Path)
+		if err != nil {
+			return err
+		}
+
+		keys[i] = cache.Key(sig, hash)
+	}
+
+	s.remoteEach(ctx, len(targets), func(ctx context.Context, i int) error {
+		return s.remote.Put(ctx, keys[i])
+	})
+
+	return nil
+}
+
+// remoteSignature returns the portable signature of the sequence of formatters for key, with which files are keyed
+// in the remote cache.
+func (s *scheduler) remoteSignature(key batchKey) signature {
+	if sig, ok := s.remoteSignatures[key]; ok {
+		return sig
+	}
+
+	names := key.sequence()
+	formatters := make([]*Formatter, len(names))
+
+	for i, name := range names {
+		formatters[i] = s.formatter(name)
+	}
+
+	sig := portableSignature(formatters, s.lineEndings)
+	s.remoteSignatures[key] = sig
+
+	return sig
+}
@@ -0,0 +1,177 @@
+package format
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/numtide/treefmt/v2/walk"
+)
+
+// sandbox mirrors files from the tree root into a temporary directory, allowing formatters to be applied to them
+// without modifying the tree.
+//
+// The directories leading to the files being formatted are mirrored, so formatters are still able to discover any
+// config files they rely upon. The files within them are copied, whilst their subdirectories are symlinked back to the
+// tree rather than being copied in their entirety, so only writes beneath those subdirectories reach the tree.
+type sandbox struct {
+	root string
+	dir  string
+
+	// lock guards populated
+	lock sync.Mutex
+	// populated records the directories, relative to the root, which have been mirrored into the sandbox
+	populated map[string]bool
+}
+
+// add copies files into the sandbox, returning a copy of each file whose Path refers to the copy.
+func (s *sandbox) add(files []*walk.File) ([]*walk.File, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	copies := make([]*walk.File, len(files))
+
+	for i, file := range files {
+		if err := s.populate(filepath.Dir(file.RelPath)); err != nil {
+			return nil, err
+		}
+
+		path := filepath.Join(s.dir, file.RelPath)
+
+		// replace the entry created when populating the parent directory with a copy of the file's content, as it may
+		// have been a symlink
+		content, err := os.ReadFile(file.Path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", file.Path, err)
+		} else if err = os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, fmt.Errorf("failed to remove %s: %w", path, err)
+		} else if err = os.WriteFile(path, content, file.Info.Mode().Perm()); err != nil {
+			return nil, fmt.Errorf("failed to write %s: %w", path, err)
+		}
+
+		copies[i] = &walk.File{
+			Path:    path,
+			RelPath: file.RelPath,
+			Info:    file.Info,
+		}
+	}
+
+	return copies, nil
+}
+
+// populate mirrors the directory at relPath into the sandbox, along with each of its parents, copying each of its files
+// and creating a symlink for each of its other entries.
+func (s *sandbox) populate(relPath string) error {
+	if s.populated[relPath] {
+		return nil
+	}
+
+	path := filepath.Join(s.dir, relPath)
+
+	if relPath != "." {
+		if err := s.populate(filepath.Dir(relPath)); err != nil {
+			return err
+		}
+
+		// replace the symlink created when populating the parent directory with a real directory
+		if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("failed to remove %s: %w", path, err)
+		} else if err = os.Mkdir(path, 0o755); err != nil {
+			return fmt.Errorf("failed to create %s: %w", path, err)
+		}
+	}
+
+	entries, err := os.ReadDir(filepath.Join(s.root, relPath))
+	if err != nil {
+		return fmt.Errorf("failed to read directory %s: %w", relPath, err)
+	}
+
+	for _, entry := range entries {
+		target := filepath.Join(s.root, relPath, entry.Name())
+
+		// files which cannot be read are linked instead, as a formatter would not be able to read them either
+		if entry.Type().IsRegular() {
+			if err = copyFile(target, filepath.Join(path, entry.Name())); err == nil {
+				continue
+			} else if !errors.Is(err, fs.ErrPermission) {
+				return err
+			}
+		}
+
+		if err = os.Symlink(target, filepath.Join(path, entry.Name())); err != nil {
+			return fmt.Errorf("failed to link %s: %w", target, err)
+		}
+	}
+
+	s.populated[relPath] = true
+
+	return nil
+}
+
+// copyFile copies the file at src to dst, preserving its permissions.
+func copyFile(src string, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", src, err)
+	}
+
+	defer in.Close()
+
+	info, err := in.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat %s: %w", src, err)
+	}
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, info.Mode().Perm())
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", dst, err)
+	}
+
+	if _, err = io.Copy(out, in); err != nil {
+		return errors.Join(fmt.Errorf("failed to copy %s: %w", src, err), out.Close())
+	} else if err = out.Close(); err != nil {
+		return fmt.Errorf("failed to close %s: %w", dst, err)
+	}
+
+	return nil
+}
+
+// compare reads the content of the original file and its formatted copy, reporting whether they differ.
+func (s *sandbox) compare(file *walk.File, formatted *walk.File) (original []byte, content []byte, changed bool, err error) {
+	if original, err = os.ReadFile(file.Path); err != nil {
+		return nil, nil, false, fmt.Errorf("failed to read %s: %w", file.Path, err)
+	}
+
+	if content, err = os.ReadFile(formatted.Path); err != nil {
+		return nil, nil, false, fmt.Errorf("failed to read %s: %w", formatted.Path, err)
+	}
+
+	return original, content, !bytes.Equal(original, content), nil
+}
+
+// remove deletes the sandbox and everything within it, leaving the tree untouched.
+func (s *sandbox) remove() error {
+	if err := os.RemoveAll(s.dir); err != nil {
+		return fmt.Errorf("failed to remove sandbox %s: %w", s.dir, err)
+	}
+
+	return nil
+}
+
+func newSandbox(root string) (*sandbox, error) {
+	dir, err := os.MkdirTemp("", "treefmt-check-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create sandbox: %w", err)
+	}
+
+	return &sandbox{
+		root:      root,
+		dir:       dir,
+		populated: make(map[string]bool),
+	}, nil
+}
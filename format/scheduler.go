@@ -4,17 +4,20 @@ import (
 	"bytes"
 	"cmp"
 	"context"
-	"crypto/md5" //nolint:gosec
+	"errors"
 	"fmt"
-	"runtime"
+	"os"
 	"slices"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/charmbracelet/log"
 	"github.com/numtide/treefmt/v2/stats"
+	"github.com/numtide/treefmt/v2/tracing"
 	"github.com/numtide/treefmt/v2/walk"
+	"github.com/numtide/treefmt/v2/walk/cache"
 	"golang.org/x/sync/errgroup"
 )
 
@@ -31,6 +34,12 @@ type (
 
 	// signature is a sha256 hash of a sequence of formatters.
 	signature []byte
+
+	// heldBatch is a full batch which has yet to be scheduled, see scheduler.maxChanges and scheduler.confirm.
+	heldBatch struct {
+		key   batchKey
+		files batch
+	}
 )
 
 // sequence returns the list of formatters, by name, to be applied to a batch of files.
@@ -50,14 +59,43 @@ func newBatchKey(formatters []*Formatter) batchKey {
 
 type scheduler struct {
 	batchSize   int
+	jobs        int
+	keepGoing   bool
+	dryRun      bool
+	diff        bool
 	changeLevel log.Level
-	formatters  map[string]*Formatter
+	// detectConflicts determines whether files are hashed between each stage, to detect formatters which undo each
+	// other's changes
+	detectConflicts bool
+	// lineEndings determines the line endings of each file once it has been formatted, see LineEndingsFormatter
+	lineEndings string
+	// maxChanges is the number of files which may be formatted, above which none are, or 0 if there is no limit.
+	// Whilst it is enforced, full batches are held until every file has been submitted.
+	maxChanges int
+	// confirm is called once every file has been submitted, before any are formatted, if it is set
+	confirm ConfirmFunc
+	held    []heldBatch
+	// submitted is the number of files which have been accepted for formatting
+	submitted int
+
+	// sandbox is only set in check mode, in which case formatters are applied to copies of the files within it
+	sandbox *sandbox
+	// remote is only set when a remote cache has been configured
+	remote cache.Backend
+	// remoteFailed indicates a request to the remote cache failed, in which case we stop using it
+	remoteFailed atomic.Bool
+
+	// formatters are keyed by name, with those of nested config files being registered as they are discovered
+	formatters     map[string]*Formatter
+	formattersLock sync.RWMutex
 
 	eg    *errgroup.Group
 	stats *stats.Stats
 
 	batches    map[batchKey]batch
 	signatures map[batchKey]signature
+	// remoteSignatures are the portable signatures of each sequence of formatters, used with the remote cache
+	remoteSignatures map[batchKey]signature
 
 	// formatError indicates if at least one formatting error occurred
 	formatError *atomic.Bool
@@ -71,15 +109,11 @@ func (s *scheduler) formattersSignature(key batchKey, formatters []*Formatter) (
 	}
 
 	// generate a signature by hashing each formatter in order
-	h := md5.New() //nolint:gosec
-	for _, f := range formatters {
-		if err := f.Hash(h); err != nil {
-			return nil, fmt.Errorf("failed to hash formatter %s: %w", f.Name(), err)
-		}
+	sig, err := sequenceSignature(formatters, s.lineEndings)
+	if err != nil {
+		return nil, err
 	}
 
-	sig = h.Sum(nil)
-
 	// store the signature so we don't have to re-compute for each file
 	s.signatures[key] = sig
 
@@ -93,7 +127,9 @@ func (s *scheduler) submit(
 	file *walk.File,
 	matches []*Formatter,
 ) (accepted bool, err error) {
-	slices.SortFunc(matches, formatterSortFunc)
+	sortFormatters(matches)
+
+	s.register(matches)
 
 	// construct a batch key based on the sequence of formatters
 	key := newBatchKey(matches)
@@ -116,49 +152,271 @@ func (s *scheduler) submit(
 		// We know from the hash signature that we have already applied this sequence of formatters (and their config) to
 		// this file.
 		// When we applied the formatters, the file had the same mod time and file size.
+		s.stats.Add(stats.Cached, 1)
+
 		return false, nil
 	}
 
+	// in dry run mode, we record what would be done instead of doing it, for it to be reported in the summary
+	// the file is not accepted, ensuring it is released without updating the cache
+	if s.dryRun {
+		s.stats.RecordPlan(file.RelPath, key.sequence())
+
+		return false, nil
+	}
+
+	s.submitted++
+	s.add(ctx, key, formattersSig, file)
+
+	return true, nil
+}
+
+// add appends file to the batch for key, the sequence of formatters with the signature formattersSig, scheduling the
+// batch for processing if it's full.
+func (s *scheduler) add(ctx context.Context, key batchKey, formattersSig signature, file *walk.File) {
 	// append the formatters sig to the file
 	// it will be necessary later to calculate a new format signature
 	file.FormattersSignature = formattersSig
+	file.Formatters = key.sequence()
 
 	// append to the batch
 	s.batches[key] = append(s.batches[key], file)
 
-	// schedule the batch for processing if it's full
+	// schedule the batch for processing if it's full, unless every file must be submitted before any are formatted
 	if len(s.batches[key]) == s.batchSize {
-		s.schedule(ctx, key, s.batches[key])
+		if s.maxChanges > 0 || s.confirm != nil {
+			s.held = append(s.held, heldBatch{key: key, files: s.batches[key]})
+		} else {
+			s.schedule(ctx, key, s.batches[key])
+		}
 		// reset the batch
 		s.batches[key] = make([]*walk.File, 0, s.batchSize)
 	}
+}
 
-	return true, nil
+// confirmBatches passes the number of files each formatter would be applied to, across the held and partial batches,
+// to confirm. Any formatters it deselects are removed from the batches, with files which are left without any
+// formatters being released.
+func (s *scheduler) confirmBatches(ctx context.Context) error {
+	batches := s.held
+	for key, files := range s.batches {
+		if len(files) > 0 {
+			batches = append(batches, heldBatch{key: key, files: files})
+		}
+	}
+
+	counts := make(map[string]int)
+
+	for _, b := range batches {
+		for _, name := range b.key.sequence() {
+			counts[name] += len(b.files)
+		}
+	}
+
+	if len(counts) == 0 {
+		return nil
+	}
+
+	deselected, err := s.confirm(counts)
+	if err != nil {
+		return err
+	} else if len(deselected) == 0 {
+		return nil
+	}
+
+	s.held = nil
+	s.batches = make(map[batchKey]batch)
+
+	// files which were to be formatted by a deselected formatter are not cached
+	releaseCtx := walk.SetNoCache(ctx, true)
+
+	for _, b := range batches {
+		var formatters []*Formatter
+
+		s.formattersLock.RLock()
+		for _, name := range b.key.sequence() {
+			if !slices.Contains(deselected, name) {
+				formatters = append(formatters, s.formatters[name])
+			}
+		}
+		s.formattersLock.RUnlock()
+
+		if len(formatters) == 0 {
+			for _, file := range b.files {
+				if err = file.Release(releaseCtx); err != nil {
+					return fmt.Errorf("failed to release file: %w", err)
+				}
+			}
+
+			continue
+		}
+
+		key := newBatchKey(formatters)
+
+		formattersSig, err := s.formattersSignature(key, formatters)
+		if err != nil {
+			return fmt.Errorf("failed to get formatter's signature: %w", err)
+		}
+
+		for _, file := range b.files {
+			s.add(ctx, key, formattersSig, file)
+		}
+	}
+
+	return nil
+}
+
+// register records any formatters which the scheduler has not seen before, so batches can be applied by name.
+func (s *scheduler) register(formatters []*Formatter) {
+	s.formattersLock.RLock()
+
+	var missing []*Formatter
+
+	for _, f := range formatters {
+		if _, ok := s.formatters[f.Name()]; !ok {
+			missing = append(missing, f)
+		}
+	}
+
+	s.formattersLock.RUnlock()
+
+	if len(missing) == 0 {
+		return
+	}
+
+	s.formattersLock.Lock()
+	defer s.formattersLock.Unlock()
+
+	for _, f := range missing {
+		s.formatters[f.Name()] = f
+	}
+}
+
+// formatter returns the formatter with the given name.
+func (s *scheduler) formatter(name string) *Formatter {
+	s.formattersLock.RLock()
+	defer s.formattersLock.RUnlock()
+
+	return s.formatters[name]
 }
 
 // schedule begins processing a batch in the background.
 func (s *scheduler) schedule(ctx context.Context, key batchKey, batch []*walk.File) {
+	var remoteSig signature
+	if s.remote != nil {
+		remoteSig = s.remoteSignature(key)
+	}
+
 	s.eg.Go(func() error {
-		var formatErrors []error
+		// unless we have been asked to keep going, we don't start any more formatting after the first failure
+		if !s.keepGoing && s.formatError.Load() {
+			log.Debugf("skipping batch %s due to an earlier formatting failure", key)
+			s.stats.Add(stats.Skipped, len(batch))
+
+			return releaseBatch(walk.SetNoCache(ctx, true), batch)
+		}
+
+		var (
+			err          error
+			formatErrors []error
+		)
+
+		// skip any files which the remote cache shows are already formatted
+		if s.remote != nil {
+			if batch, err = s.fetchRemote(ctx, remoteSig, batch); err != nil {
+				return err
+			} else if len(batch) == 0 {
+				return nil
+			}
+		}
+
+		sequence := key.sequence()
+
+		// when diffs have been requested, capture the content of each file before it is formatted
+		var originals [][]byte
+		if s.diff && s.sandbox == nil {
+			if originals, err = readContents(batch); err != nil {
+				return err
+			}
+		}
+
+		// in check mode, the formatters are applied to copies of the files within the sandbox
+		targets := batch
+		if s.sandbox != nil {
+			if targets, err = s.sandbox.add(batch); err != nil {
+				return fmt.Errorf("failed to copy files into sandbox: %w", err)
+			}
+		}
 
-		// apply the formatters in sequence
-		for _, name := range key.sequence() {
-			formatter := s.formatters[name]
+		// record the line endings of each file before it is formatted, if they are to be restored
+		eol, err := newLineEndings(s.lineEndings, targets)
+		if err != nil {
+			return err
+		}
 
-			if err := formatter.Apply(ctx, batch); err != nil {
+		// when more than one formatter is applied, and we have been asked to, track the content of each file between
+		// them so we can detect formatters which conflict with one another
+		var tracker *stageTracker
+		if s.detectConflicts && len(sequence) > 1 {
+			if tracker, err = newStageTracker(targets); err != nil {
+				return err
+			}
+		}
+
+		// apply the formatters in sequence, one stage at a time
+		for _, stage := range s.stages(sequence) {
+			err = s.applyStage(ctx, stage, targets)
+
+			if tracker != nil {
+				if trackErr := tracker.record(stage...); trackErr != nil {
+					return trackErr
+				}
+			}
+
+			if err != nil {
 				formatErrors = append(formatErrors, err)
+				s.recordFailures(err)
+
+				// there is no point applying the remaining formatters unless we have been asked to keep going
+				if !s.keepGoing {
+					break
+				}
 			}
 		}
 
+		if tracker != nil {
+			s.recordConflicts(tracker.conflicts())
+		}
+
 		// record if a format error occurred
 		hasErrors := len(formatErrors) > 0
 
 		// update overall error tracking
-		s.formatError.Store(hasErrors)
+		if hasErrors {
+			s.formatError.Store(true)
+		} else if eol != nil {
+			if err = eol.apply(); err != nil {
+				return err
+			}
+		}
 
-		if !hasErrors {
+		if hasErrors {
+			// record that the files could not be formatted
+			s.stats.Add(stats.Errored, len(batch))
+		} else {
 			// record that the file was formatted
 			s.stats.Add(stats.Formatted, len(batch))
+
+			// share the outcome with other machines
+			if s.remote != nil {
+				if err = s.storeRemote(ctx, remoteSig, targets); err != nil {
+					return err
+				}
+			}
+		}
+
+		if s.sandbox != nil {
+			return s.check(ctx, batch, targets, sequence)
 		}
 
 		// Create a release context.
@@ -168,7 +426,7 @@ func (s *scheduler) schedule(ctx context.Context, key batchKey, batch []*walk.Fi
 		releaseCtx := walk.SetNoCache(ctx, hasErrors)
 
 		// post-processing
-		for _, file := range batch {
+		for i, file := range batch {
 			// check if the file has changed
 			changed, newInfo, err := file.Stat()
 			if err != nil {
@@ -177,7 +435,15 @@ func (s *scheduler) schedule(ctx context.Context, key batchKey, batch []*walk.Fi
 
 			if changed {
 				// record the change
+				var diff string
+				if originals != nil {
+					if diff, err = diffFile(file, originals[i]); err != nil {
+						return err
+					}
+				}
+
 				s.stats.Add(stats.Changed, 1)
+				s.stats.RecordChange(file.RelPath, sequence, diff)
 
 				// log the change (useful for diagnosing issues)
 				log.Log(
@@ -203,7 +469,174 @@ func (s *scheduler) schedule(ctx context.Context, key batchKey, batch []*walk.Fi
 	})
 }
 
+// stages divides a sequence of formatters into the stages in which they are applied.
+// Adjacent formatters which share a stage are applied together, whilst every other formatter is a stage of its own.
+func (s *scheduler) stages(sequence []string) [][]string {
+	var (
+		result [][]string
+		prev   string
+	)
+
+	for _, name := range sequence {
+		stage := s.formatter(name).Stage()
+
+		if stage != "" && stage == prev {
+			result[len(result)-1] = append(result[len(result)-1], name)
+		} else {
+			result = append(result, []string{name})
+		}
+
+		prev = stage
+	}
+
+	return result
+}
+
+// applyStage applies the named formatters to files concurrently, returning the failures of any of them.
+func (s *scheduler) applyStage(ctx context.Context, names []string, files []*walk.File) error {
+	errs := make([]error, len(names))
+
+	var wg sync.WaitGroup
+
+	for i, name := range names {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			errs[i] = s.apply(ctx, name, files)
+		}()
+	}
+
+	wg.Wait()
+
+	return errors.Join(errs...)
+}
+
+// apply applies the named formatter to files, recording how long it took.
+func (s *scheduler) apply(ctx context.Context, name string, files []*walk.File) error {
+	s.stats.StartFormatter(name)
+
+	ctx, span := tracing.Start(ctx, "formatter",
+		tracing.String("formatter", name),
+		tracing.Int("files", len(files)),
+	)
+
+	start := time.Now()
+	err := s.formatter(name).Apply(ctx, files)
+
+	// record how long the formatter took to process this batch
+	s.stats.RecordFormatter(name, len(files), time.Since(start))
+
+	span.SetError(err)
+	span.End()
+
+	return err
+}
+
+// check compares each file in batch with its formatted copy in the sandbox, recording those which are not formatted.
+// The files are then released without updating the cache, as they have not actually been formatted.
+func (s *scheduler) check(ctx context.Context, batch []*walk.File, copies []*walk.File, sequence []string) error {
+	for i, file := range batch {
+		original, formatted, changed, err := s.sandbox.compare(file, copies[i])
+		if err != nil {
+			return fmt.Errorf("failed to compare file with its formatted copy: %w", err)
+		}
+
+		if changed {
+			var diff string
+			if s.diff {
+				diff = unifiedDiff(file.RelPath, original, formatted)
+			}
+
+			s.stats.Add(stats.Changed, 1)
+			s.stats.RecordChange(file.RelPath, sequence, diff)
+
+			log.Log(s.changeLevel, "file is not formatted", "path", file.RelPath)
+		}
+	}
+
+	return releaseBatch(walk.SetNoCache(ctx, true), batch)
+}
+
+// recordConflicts records each file which was modified by more than one formatter.
+// Formatters with overlapping includes are expected to modify the same files at times, but a file whose content
+// oscillates between formatters will never be stable, so we warn about it.
+func (s *scheduler) recordConflicts(conflicts []stats.Conflict) {
+	for _, conflict := range conflicts {
+		formatters := strings.Join(conflict.Formatters, ", ")
+
+		if conflict.Oscillating {
+			log.Warnf(
+				"formatters %s are undoing each other's changes to %s, check their includes and excludes",
+				formatters, conflict.Path,
+			)
+		} else {
+			log.Debug("file was modified by multiple formatters", "path", conflict.Path, "formatters", formatters)
+		}
+
+		s.stats.RecordConflict(conflict)
+	}
+}
+
+// recordFailures records each of the failures contained within err, as returned by Formatter.Apply.
+// Unless we have been asked to keep going, any output captured from the formatter is printed straight away, otherwise
+// it is saved for the report printed at the end.
+func (s *scheduler) recordFailures(err error) {
+	var errs []error
+
+	if joined, ok := err.(interface{ Unwrap() []error }); ok { //nolint:errorlint
+		errs = joined.Unwrap()
+	} else {
+		errs = []error{err}
+	}
+
+	for _, err := range errs {
+		var formatErr *FormatError
+		if !errors.As(err, &formatErr) {
+			continue
+		}
+
+		if !s.keepGoing && len(formatErr.Output) > 0 {
+			_, _ = fmt.Fprintf(os.Stderr, "\n%s\n", formatErr.Output)
+		}
+
+		s.stats.RecordFailure(stats.Failure{
+			Formatter: formatErr.Formatter,
+			Paths:     formatErr.Paths,
+			Error:     formatErr.Err.Error(),
+			Output:    string(formatErr.Output),
+		})
+	}
+}
+
+// releaseBatch releases each file in batch.
+func releaseBatch(ctx context.Context, batch []*walk.File) error {
+	for _, file := range batch {
+		if err := file.Release(ctx); err != nil {
+			return fmt.Errorf("failed to release file: %w", err)
+		}
+	}
+
+	return nil
+}
+
 func (s *scheduler) close(ctx context.Context) error {
+	// nothing has been formatted yet, so we can refuse to format anything at all
+	if s.maxChanges > 0 && s.submitted > s.maxChanges {
+		return fmt.Errorf("%w: %d files would be formatted, the limit is %d", ErrTooManyChanges, s.submitted, s.maxChanges)
+	}
+
+	if s.confirm != nil {
+		if err := s.confirmBatches(ctx); err != nil {
+			return err
+		}
+	}
+
+	for _, held := range s.held {
+		s.schedule(ctx, held.key, held.files)
+	}
+
 	// schedule any partial batches that remain
 	for key, batch := range s.batches {
 		if len(batch) > 0 {
@@ -211,8 +644,43 @@ func (s *scheduler) close(ctx context.Context) error {
 		}
 	}
 
+	// remove the sandbox once processing is complete
+	if s.sandbox != nil {
+		defer func() {
+			if err := s.sandbox.remove(); err != nil {
+				log.Warnf("%v", err)
+			}
+		}()
+	}
+
 	// wait for processing to complete
-	if err := s.eg.Wait(); err != nil {
+	err := s.eg.Wait()
+
+	s.formattersLock.RLock()
+	formatters := make([]*Formatter, 0, len(s.formatters))
+	for _, f := range s.formatters {
+		formatters = append(formatters, f)
+	}
+	s.formattersLock.RUnlock()
+
+	// shut down any plugins and WASM modules, which are no longer needed
+	for _, f := range formatters {
+		if closeErr := f.close(ctx); closeErr != nil {
+			log.Warnf("%v", closeErr)
+		}
+	}
+
+	// run the post hooks of the formatters which were applied, in the order in which they are applied to a file
+	slices.SortFunc(formatters, formatterSortFunc)
+
+	for _, f := range formatters {
+		if hookErr := f.runPost(ctx); hookErr != nil {
+			f.log.Errorf("%v", hookErr)
+			s.formatError.Store(true)
+		}
+	}
+
+	if err != nil {
 		return fmt.Errorf("failed to wait for formatters: %w", err)
 	} else if s.formatError.Load() {
 		return ErrFormattingFailures
@@ -221,14 +689,39 @@ func (s *scheduler) close(ctx context.Context) error {
 	return nil
 }
 
-// formatterSortFunc sorts formatters by their priority in ascending order; ties are resolved by lexicographic order of
-// names.
+// reset prepares the scheduler to be used again once it has been closed, recording the outcome in statz and applying
+// formatters within sandbox, if set.
+func (s *scheduler) reset(statz *stats.Stats, sandbox *sandbox, formatters map[string]*Formatter) {
+	s.eg = &errgroup.Group{}
+	s.eg.SetLimit(s.jobs)
+	s.stats = statz
+	s.sandbox = sandbox
+	s.formatters = formatters
+
+	s.confirm = nil
+	s.held = nil
+	s.submitted = 0
+	s.remoteFailed.Store(false)
+	s.formatError = &atomic.Bool{}
+
+	// the signatures include the formatters' executables, which may have changed since the last run
+	s.batches = make(map[batchKey]batch)
+	s.signatures = make(map[batchKey]signature)
+	s.remoteSignatures = make(map[batchKey]signature)
+}
+
+// formatterSortFunc sorts formatters by their priority in ascending order; ties are resolved by the lexicographic
+// order of their stage, so formatters in the same stage are adjacent, and then of their names.
 func formatterSortFunc(a, b *Formatter) int {
 	// sort by priority in ascending order
 	priorityA := a.Priority()
 	priorityB := b.Priority()
 
 	result := priorityA - priorityB
+	if result == 0 {
+		result = cmp.Compare(a.Stage(), b.Stage())
+	}
+
 	if result == 0 {
 		// formatters with the same priority are sorted lexicographically to ensure a deterministic outcome
 		result = cmp.Compare(a.Name(), b.Name())
@@ -237,26 +730,115 @@ func formatterSortFunc(a, b *Formatter) int {
 	return result
 }
 
+// sortFormatters sorts formatters using formatterSortFunc, before reordering them so each is applied after any of the
+// others it must be applied after, regardless of their priority.
+// Cycles are rejected when the config is loaded, but should one remain, the formatters involved are left as sorted.
+func sortFormatters(formatters []*Formatter) {
+	slices.SortFunc(formatters, formatterSortFunc)
+
+	if !slices.ContainsFunc(formatters, func(f *Formatter) bool { return len(f.After()) > 0 }) {
+		return
+	}
+
+	remaining := slices.Clone(formatters)
+
+	ready := func(f *Formatter) bool {
+		return !slices.ContainsFunc(remaining, f.isAfter)
+	}
+
+	var stage string
+
+	for i := range formatters {
+		// take the first of the remaining formatters which is not after any of the others, preferring those in the same
+		// stage as the previous formatter, so the stage is not split
+		idx := slices.IndexFunc(remaining, func(f *Formatter) bool {
+			return stage != "" && f.Stage() == stage && ready(f)
+		})
+		if idx < 0 {
+			idx = slices.IndexFunc(remaining, ready)
+		}
+
+		if idx < 0 {
+			idx = 0
+		}
+
+		formatters[i] = remaining[idx]
+		stage = formatters[i].Stage()
+		remaining = slices.Delete(remaining, idx, idx+1)
+	}
+}
+
+// checkStages ensures the formatters of each stage share the same priority, and are not applied after one another, as
+// otherwise other formatters could be applied between them.
+func checkStages(formatters map[string]*Formatter) error {
+	stages := make(map[string][]*Formatter)
+
+	for _, f := range sortedByName(formatters) {
+		if f.Stage() == "" {
+			continue
+		}
+
+		for _, other := range stages[f.Stage()] {
+			if other.Priority() != f.Priority() {
+				return fmt.Errorf(
+					"formatters %v and %v are in stage %v but have different priorities, %d and %d",
+					other.Name(), f.Name(), f.Stage(), other.Priority(), f.Priority(),
+				)
+			} else if f.isAfter(other) || other.isAfter(f) {
+				return fmt.Errorf(
+					"formatters %v and %v are in stage %v but one is after the other", other.Name(), f.Name(), f.Stage(),
+				)
+			}
+		}
+
+		stages[f.Stage()] = append(stages[f.Stage()], f)
+	}
+
+	return nil
+}
+
 func newScheduler(
 	statz *stats.Stats,
 	batchSize int,
+	jobs int,
+	keepGoing bool,
+	dryRun bool,
+	diff bool,
+	detectConflicts bool,
+	sandbox *sandbox,
+	remote cache.Backend,
 	changeLevel log.Level,
+	lineEndings string,
+	maxChanges int,
 	formatters map[string]*Formatter,
 ) *scheduler {
 	eg := &errgroup.Group{}
-	// we use a simple heuristic to avoid too much contention by limiting the concurrency to runtime.NumCPU()
-	eg.SetLimit(runtime.NumCPU())
+	// each batch applies its formatters one stage at a time, usually of a single formatter, so there is no need to
+	// process more batches concurrently than the number of formatter processes we are allowed to run
+	eg.SetLimit(jobs)
 
 	return &scheduler{
 		batchSize:   batchSize,
+		jobs:        jobs,
+		keepGoing:   keepGoing,
+		dryRun:      dryRun,
+		diff:        diff,
+		sandbox:     sandbox,
+		remote:      remote,
 		changeLevel: changeLevel,
+		lineEndings: lineEndings,
+		maxChanges:  maxChanges,
 		formatters:  formatters,
 
+		detectConflicts: detectConflicts,
+
 		eg:    eg,
 		stats: statz,
 
-		batches:     make(map[batchKey]batch),
-		signatures:  make(map[batchKey]signature),
-		formatError: &atomic.Bool{},
+		batches:    make(map[batchKey]batch),
+		signatures: make(map[batchKey]signature),
+
+		remoteSignatures: make(map[batchKey]signature),
+		formatError:      &atomic.Bool{},
 	}
 }
@@ -0,0 +1,113 @@
+package format
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/charmbracelet/log"
+	"github.com/gobwas/glob"
+	"github.com/numtide/treefmt/v2/config"
+)
+
+// scope contains the global excludes and formatters which apply to the files beneath a directory in the tree root.
+type scope struct {
+	config     *config.Scope
+	excludes   []glob.Glob
+	formatters map[string]*Formatter
+}
+
+// scopeFor returns the scope which applies to the file at relPath.
+// Unless nested config files have been enabled, this is always the root scope.
+func (c *CompositeFormatter) scopeFor(relPath string) (*scope, error) {
+	if !c.cfg.NestedConfigs {
+		return c.scopes["."], nil
+	}
+
+	return c.scopeForDir(filepath.Dir(relPath))
+}
+
+// scopeForDir returns the scope which applies to the files in dir, a directory relative to the tree root.
+// Nested config files are discovered as the tree is walked, with the scope for each directory being cached.
+func (c *CompositeFormatter) scopeForDir(dir string) (*scope, error) {
+	if s, ok := c.scopes[dir]; ok {
+		return s, nil
+	}
+
+	// the root scope is always cached, so this terminates
+	parent, err := c.scopeForDir(filepath.Dir(dir))
+	if err != nil {
+		return nil, err
+	}
+
+	nested, err := parent.config.Nest(c.cfg.TreeRoot, dir)
+	if err != nil {
+		return nil, err
+	} else if nested == nil {
+		// no config file, the parent scope applies
+		c.scopes[dir] = parent
+
+		return parent, nil
+	}
+
+	s, err := c.newScope(nested, parent)
+	if err != nil {
+		return nil, fmt.Errorf("failed to apply nested config file %s: %w", nested.ConfigFile, err)
+	}
+
+	c.scopes[dir] = s
+
+	return s, nil
+}
+
+// newScope creates a scope from its config, reusing the formatters of parent which have not been changed.
+func (c *CompositeFormatter) newScope(cfg *config.Scope, parent *scope) (*scope, error) {
+	log.Debugf("using nested config file %s for %s", cfg.ConfigFile, cfg.Dir)
+
+	for _, key := range cfg.Ignored {
+		log.Warnf("%s cannot be set in a nested config file, ignoring it in %s", key, cfg.ConfigFile)
+	}
+
+	for _, name := range cfg.Disabled {
+		log.Debugf("formatter %v is disabled by its enabled-if condition in %s", name, cfg.ConfigFile)
+	}
+
+	excludes, err := compileGlobs(cfg.Excludes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compile global excludes: %w", err)
+	}
+
+	formatters := make(map[string]*Formatter)
+
+	for name, formatterCfg := range cfg.FormatterConfigs {
+		// formatters which are unchanged are shared with the parent, unless their command was missing
+		if parent.config.FormatterConfigs[name] == formatterCfg {
+			if formatter, ok := parent.formatters[name]; ok {
+				formatters[name] = formatter
+			}
+
+			continue
+		}
+
+		formatter, err := c.newFormatter(name, formatterCfg)
+		if err != nil {
+			return nil, err
+		} else if formatter == nil {
+			continue
+		}
+
+		// distinguish the formatter from those of the same name in other scopes
+		formatter.scope(cfg.Dir)
+
+		formatters[name] = formatter
+	}
+
+	if err = checkStages(formatters); err != nil {
+		return nil, fmt.Errorf("failed to apply nested config file %s: %w", cfg.ConfigFile, err)
+	}
+
+	return &scope{
+		config:     cfg,
+		excludes:   excludes,
+		formatters: formatters,
+	}, nil
+}
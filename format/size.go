@@ -0,0 +1,61 @@
+package format
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/numtide/treefmt/v2/walk"
+)
+
+//nolint:gochecknoglobals
+var sizeUnits = map[string]int64{
+	"":    1,
+	"b":   1,
+	"kb":  1000,
+	"mb":  1000 * 1000,
+	"gb":  1000 * 1000 * 1000,
+	"k":   1024,
+	"m":   1024 * 1024,
+	"g":   1024 * 1024 * 1024,
+	"kib": 1024,
+	"mib": 1024 * 1024,
+	"gib": 1024 * 1024 * 1024,
+}
+
+// ParseSize parses a human-readable size such as 512KiB or 2MB into a number of bytes.
+// Decimal units (KB, MB, GB) are powers of 1000, while binary units (K, M, G, KiB, MiB, GiB) are powers of 1024.
+// An empty value returns 0, meaning no limit.
+func ParseSize(value string) (int64, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, nil
+	}
+
+	// split the value into a number and a unit
+	idx := strings.IndexFunc(value, func(r rune) bool {
+		return (r < '0' || r > '9') && r != '.'
+	})
+	if idx < 0 {
+		idx = len(value)
+	}
+
+	number, unit := value[:idx], strings.ToLower(strings.TrimSpace(value[idx:]))
+
+	multiplier, ok := sizeUnits[unit]
+	if !ok {
+		return 0, fmt.Errorf("invalid size '%s': unknown unit '%s'", value, value[idx:])
+	}
+
+	n, err := strconv.ParseFloat(number, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size '%s': must be a positive number followed by an optional unit", value)
+	}
+
+	return int64(n * float64(multiplier)), nil
+}
+
+// exceedsSize determines if file is larger than limit, where a limit of 0 means there is no limit.
+func exceedsSize(file *walk.File, limit int64) bool {
+	return limit > 0 && file.Info != nil && file.Info.Size() > limit
+}
@@ -0,0 +1,37 @@
+//nolint:testpackage
+package format
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSize(t *testing.T) {
+	r := require.New(t)
+
+	for value, expected := range map[string]int64{
+		"":       0,
+		"0":      0,
+		"1024":   1024,
+		"100B":   100,
+		"2KB":    2000,
+		"2kb":    2000,
+		"2MB":    2 * 1000 * 1000,
+		"1GB":    1000 * 1000 * 1000,
+		"2K":     2048,
+		"512KiB": 512 * 1024,
+		"1.5MiB": 1536 * 1024,
+		"2 MiB":  2 * 1024 * 1024,
+		"1GiB":   1024 * 1024 * 1024,
+	} {
+		size, err := ParseSize(value)
+		r.NoError(err, value)
+		r.Equal(expected, size, value)
+	}
+
+	for _, value := range []string{"MB", "2XB", "-1", "1.2.3MB"} {
+		_, err := ParseSize(value)
+		r.Error(err, value)
+	}
+}
@@ -0,0 +1,79 @@
+package format
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+
+	"github.com/charmbracelet/log"
+)
+
+// outputLevels maps the allowed values of a formatter's output setting to the level its output is logged at.
+var outputLevels = map[string]log.Level{
+	"debug": log.DebugLevel,
+	"info":  log.InfoLevel,
+}
+
+// parseOutput returns the level at which a formatter's output should be logged, and false if it should not be logged.
+func parseOutput(value string) (log.Level, bool, error) {
+	switch value {
+	case "":
+		return log.DebugLevel, true, nil
+	case "never":
+		return 0, false, nil
+	}
+
+	level, ok := outputLevels[value]
+	if !ok {
+		return 0, false, fmt.Errorf("invalid output value '%s', must be one of <debug|info|never>", value)
+	}
+
+	return level, true, nil
+}
+
+// lineLogger is an io.Writer which logs each line written to it.
+// A trailing line without a newline is held back until more is written, or Flush is called.
+type lineLogger struct {
+	log   *log.Logger
+	level log.Level
+
+	lock    sync.Mutex
+	partial []byte
+}
+
+func (l *lineLogger) Write(p []byte) (int, error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	l.partial = append(l.partial, p...)
+
+	for {
+		idx := bytes.IndexByte(l.partial, '\n')
+		if idx < 0 {
+			break
+		}
+
+		l.print(l.partial[:idx])
+		l.partial = l.partial[idx+1:]
+	}
+
+	return len(p), nil
+}
+
+// Flush logs any remaining output which was not terminated by a newline.
+func (l *lineLogger) Flush() {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if len(l.partial) > 0 {
+		l.print(l.partial)
+		l.partial = nil
+	}
+}
+
+func (l *lineLogger) print(line []byte) {
+	line = bytes.TrimRight(line, "\r")
+	if len(line) > 0 {
+		l.log.Log(l.level, string(line))
+	}
+}
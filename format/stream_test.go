@@ -0,0 +1,57 @@
+//nolint:testpackage
+package format
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/charmbracelet/log"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseOutput(t *testing.T) {
+	r := require.New(t)
+
+	for value, expected := range map[string]log.Level{
+		"":      log.DebugLevel,
+		"debug": log.DebugLevel,
+		"info":  log.InfoLevel,
+	} {
+		level, ok, err := parseOutput(value)
+		r.NoError(err, value)
+		r.True(ok, value)
+		r.Equal(expected, level, value)
+	}
+
+	_, ok, err := parseOutput("never")
+	r.NoError(err)
+	r.False(ok)
+
+	_, _, err = parseOutput("warn")
+	r.ErrorContains(err, "invalid output value 'warn'")
+}
+
+func TestLineLogger(t *testing.T) {
+	r := require.New(t)
+
+	var out strings.Builder
+
+	logger := log.NewWithOptions(&out, log.Options{Prefix: "formatter | test", Level: log.DebugLevel})
+	stream := &lineLogger{log: logger, level: log.InfoLevel}
+
+	// lines are logged as they are completed, skipping any which are empty
+	for _, chunk := range []string{"first li", "ne\r\nsecond line\n\nthi", "rd"} {
+		n, err := stream.Write([]byte(chunk))
+		r.NoError(err)
+		r.Equal(len(chunk), n)
+	}
+
+	r.Equal("INFO formatter | test: first line\nINFO formatter | test: second line\n", out.String())
+
+	// the trailing line is logged when flushed
+	stream.Flush()
+	r.Equal(
+		"INFO formatter | test: first line\nINFO formatter | test: second line\nINFO formatter | test: third\n",
+		out.String(),
+	)
+}
@@ -0,0 +1,109 @@
+package format
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"os/exec"
+	"slices"
+	"strings"
+	"time"
+
+	processSandbox "github.com/numtide/treefmt/v2/sandbox"
+)
+
+// versionProbeTimeout is how long we wait for a formatter to report its version.
+const versionProbeTimeout = 5 * time.Second
+
+// versionProbe invokes a formatter's command in the background to print its version, allowing the formatters to be
+// probed concurrently whilst the tree is traversed.
+type versionProbe struct {
+	done chan struct{}
+	// output is that of the command, or nil if it failed
+	output []byte
+}
+
+// probeVersion starts probing the formatter for its version, if it has a version-probe. Formatters are never probed by
+// default, as one which treats its args as paths would create a file named after them. Those which run in-process
+// are not probed either, as their version is that of treefmt.
+func (f *Formatter) probeVersion() {
+	probeArgs := f.config.VersionProbe
+	if probeArgs == "" || f.config.Version != "" || f.builtin != nil || isWasm(f.config.Command) {
+		return
+	}
+
+	// a runner precedes the command with args of its own, whilst the formatter's options are not wanted
+	args := slices.Concat(f.args[:len(f.args)-len(f.config.Options)], strings.Fields(probeArgs))
+
+	probe := &versionProbe{done: make(chan struct{})}
+	f.version = probe
+
+	// the working directory is captured as it changes if the formatter is later moved into a sandbox
+	dir, log, sandboxed := f.workingDir, f.log, f.sandboxed
+	executable, args := f.command(dir, args)
+
+	go func() {
+		defer close(probe.done)
+
+		ctx, cancel := context.WithTimeout(context.Background(), versionProbeTimeout)
+		defer cancel()
+
+		cmd := exec.CommandContext(ctx, executable, args...) //nolint:gosec
+		cmd.Dir = dir
+
+		// the formatter is no more trusted when printing its version
+		if sandboxed {
+			cleanup, err := processSandbox.Apply(cmd, nil)
+			if err != nil {
+				log.Debugf("failed to sandbox version probe: %v", err)
+
+				return
+			}
+
+			defer cleanup()
+		}
+
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			log.Debugf("failed to probe version with %s: %v", probeArgs, err)
+
+			return
+		}
+
+		probe.output = out
+	}()
+}
+
+// Version returns the configured version of the formatter, if any. Otherwise, it returns the first line output by the
+// formatter when invoked with its version-probe, probing it if that has not already happened, or an empty string if it
+// has no version-probe or the probe failed.
+func (f *Formatter) Version() string {
+	if f.config.Version != "" {
+		return f.config.Version
+	}
+
+	if f.version == nil {
+		f.probeVersion()
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(f.version.wait()))
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			return line
+		}
+	}
+
+	return ""
+}
+
+// wait returns the output of the probe once it has completed, or nil if the formatter was not probed or the probe
+// failed.
+func (p *versionProbe) wait() []byte {
+	if p == nil {
+		return nil
+	}
+
+	<-p.done
+
+	return p.output
+}
@@ -0,0 +1,93 @@
+//nolint:testpackage
+package format
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/numtide/treefmt/v2/config"
+	"github.com/numtide/treefmt/v2/stats"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVersionProbe(t *testing.T) {
+	as := require.New(t)
+
+	tempDir := t.TempDir()
+	versionFile := filepath.Join(tempDir, "version")
+	invokedFile := filepath.Join(tempDir, "invoked")
+
+	// a wrapper whose executable is unchanged when the formatter it runs is upgraded
+	binPath := filepath.Join(tempDir, "bin")
+	as.NoError(os.Mkdir(binPath, 0o755))
+	as.NoError(os.WriteFile(filepath.Join(binPath, "wrapped-fmt"), []byte(`#!/bin/sh
+if [ "$1" = "--version" ] || [ "$1" = "version" ]; then
+	cat "`+versionFile+`"
+else
+	touch "`+invokedFile+`"
+	exit 1
+fi
+`), 0o755))
+
+	t.Setenv("PATH", binPath+":"+os.Getenv("PATH"))
+
+	setVersion := func(version string) {
+		as.NoError(os.WriteFile(versionFile, []byte(version), 0o600))
+	}
+
+	cfg := &config.Config{
+		OnUnmatched: "info",
+		TreeRoot:    tempDir,
+		FormatterConfigs: map[string]*config.Formatter{
+			"wrapped": {
+				Command:      "wrapped-fmt",
+				Options:      []string{"--write"},
+				Includes:     []string{"*.txt"},
+				VersionProbe: "--version",
+			},
+		},
+	}
+
+	signatureOf := func() signature {
+		statz := stats.New()
+
+		f, err := NewCompositeFormatter(cfg, &statz, 1024)
+		as.NoError(err)
+
+		sig, err := f.signature()
+		as.NoError(err)
+
+		return sig
+	}
+
+	setVersion("wrapped-fmt 1.0.0\n")
+	oldSignature := assertSignatureChangedAndStable(t, as, cfg, nil)
+
+	as.Equal(oldSignature, signatureOf(), "the signature should be stable between runs")
+
+	// upgrading the formatter changes the signature, even though its executable is unchanged
+	setVersion("wrapped-fmt 1.1.0\n")
+	oldSignature = assertSignatureChangedAndStable(t, as, cfg, oldSignature)
+
+	// the probe is configurable
+	cfg.FormatterConfigs["wrapped"].VersionProbe = "version"
+	as.Equal(oldSignature, signatureOf())
+
+	setVersion("wrapped-fmt 1.2.0\n")
+	oldSignature = assertSignatureChangedAndStable(t, as, cfg, oldSignature)
+
+	// without a probe, only the executable is considered
+	cfg.FormatterConfigs["wrapped"].VersionProbe = ""
+	oldSignature = assertSignatureChangedAndStable(t, as, cfg, oldSignature)
+
+	setVersion("wrapped-fmt 1.3.0\n")
+	as.Equal(oldSignature, signatureOf())
+
+	// the formatter is never invoked with args it might treat as paths
+	as.NoFileExists(invokedFile)
+
+	// a probe which fails is ignored
+	cfg.FormatterConfigs["wrapped"].VersionProbe = "--unknown"
+	as.Equal(oldSignature, signatureOf())
+}
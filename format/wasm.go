@@ -0,0 +1,83 @@
+package format
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+	"github.com/tetratelabs/wazero/sys"
+)
+
+// isWasm reports whether command refers to a WebAssembly module, rather than an executable.
+func isWasm(command string) bool {
+	return filepath.Ext(command) == ".wasm"
+}
+
+// wasmModule is a compiled WASI module, which is run in-process for each file it formats.
+type wasmModule struct {
+	runtime  wazero.Runtime
+	compiled wazero.CompiledModule
+}
+
+// compileWasm compiles the WASI module at path.
+func compileWasm(ctx context.Context, path string) (*wasmModule, error) {
+	code, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	// closing the module when the context is done allows a module which exceeds its timeout to be stopped
+	runtime := wazero.NewRuntimeWithConfig(ctx, wazero.NewRuntimeConfig().WithCloseOnContextDone(true))
+
+	if _, err = wasi_snapshot_preview1.Instantiate(ctx, runtime); err != nil {
+		_ = runtime.Close(ctx)
+
+		return nil, fmt.Errorf("failed to instantiate WASI: %w", err)
+	}
+
+	compiled, err := runtime.CompileModule(ctx, code)
+	if err != nil {
+		_ = runtime.Close(ctx)
+
+		return nil, fmt.Errorf("failed to compile %s: %w", path, err)
+	}
+
+	return &wasmModule{runtime: runtime, compiled: compiled}, nil
+}
+
+// run instantiates the module with the given args, which follow the module's name, and stdio.
+// The module has no access to the filesystem, network or environment.
+func (m *wasmModule) run(ctx context.Context, args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	cfg := wazero.NewModuleConfig().
+		WithName(""). // allows the module to be instantiated more than once at the same time
+		WithArgs(append([]string{m.compiled.Name()}, args...)...).
+		WithStdin(stdin).
+		WithStdout(stdout).
+		WithStderr(stderr)
+
+	module, err := m.runtime.InstantiateModule(ctx, m.compiled, cfg)
+
+	var exitErr *sys.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == 0 {
+		err = nil
+	}
+
+	if module != nil {
+		_ = module.Close(ctx)
+	}
+
+	return err
+}
+
+func (m *wasmModule) close(ctx context.Context) error {
+	if err := m.runtime.Close(ctx); err != nil {
+		return fmt.Errorf("failed to close WASM runtime: %w", err)
+	}
+
+	return nil
+}
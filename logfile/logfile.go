@@ -0,0 +1,132 @@
+package logfile
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/charmbracelet/log"
+)
+
+// ansiEscape matches the escape sequences used to style log entries for the terminal.
+var ansiEscape = regexp.MustCompile(`\x1b\[[0-9;]*m`)
+
+// levels maps the abbreviations with which log entries begin to their level.
+var levels = map[string]log.Level{
+	"DEBU": log.DebugLevel,
+	"INFO": log.InfoLevel,
+	"WARN": log.WarnLevel,
+	"ERRO": log.ErrorLevel,
+	"FATA": log.FatalLevel,
+}
+
+// Writer duplicates log entries to a file, whilst only passing those at or above the console's level to the console.
+// This allows the file to record everything, regardless of the verbosity requested for the console.
+// It relies on each log entry being written with a single call to Write.
+type Writer struct {
+	console      io.Writer
+	consoleLevel log.Level
+
+	path    string
+	maxSize int64
+
+	lock sync.Mutex
+	file *os.File
+	size int64
+}
+
+// Open opens the file at path for appending log entries, creating it if necessary.
+// Entries at or above consoleLevel are also written to console.
+// If maxSize is greater than zero, the file is rotated when writing an entry would cause it to exceed maxSize, with
+// the previous entries kept in a single backup with a .1 suffix.
+func Open(path string, maxSize int64, console io.Writer, consoleLevel log.Level) (*Writer, error) {
+	w := &Writer{
+		console:      console,
+		consoleLevel: consoleLevel,
+		path:         path,
+		maxSize:      maxSize,
+	}
+
+	if err := w.open(); err != nil {
+		return nil, err
+	}
+
+	return w, nil
+}
+
+func (w *Writer) open() error {
+	file, err := os.OpenFile(w.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open log file %s: %w", w.path, err)
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+
+		return fmt.Errorf("failed to stat log file %s: %w", w.path, err)
+	}
+
+	w.file = file
+	w.size = info.Size()
+
+	return nil
+}
+
+// rotate moves the current file aside, replacing any previous backup, and starts a new one.
+func (w *Writer) rotate() error {
+	if err := w.file.Close(); err != nil {
+		return fmt.Errorf("failed to close log file %s: %w", w.path, err)
+	}
+
+	if err := os.Rename(w.path, w.path+".1"); err != nil {
+		return fmt.Errorf("failed to rotate log file %s: %w", w.path, err)
+	}
+
+	return w.open()
+}
+
+// Write writes a log entry to the file, prefixed with the current time, and to the console if its level is high
+// enough.
+func (w *Writer) Write(p []byte) (int, error) {
+	plain := ansiEscape.ReplaceAll(p, nil)
+
+	if level, ok := levels[string(plain[:min(len(plain), 4)])]; !ok || level >= w.consoleLevel {
+		if _, err := w.console.Write(p); err != nil {
+			return 0, err
+		}
+	}
+
+	entry := append([]byte(time.Now().Format(time.RFC3339)+" "), bytes.TrimRight(plain, "\n")...)
+	entry = append(entry, '\n')
+
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if w.maxSize > 0 && w.size > 0 && w.size+int64(len(entry)) > w.maxSize {
+		if err := w.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := w.file.Write(entry)
+	w.size += int64(n)
+
+	if err != nil {
+		return 0, fmt.Errorf("failed to write to log file %s: %w", w.path, err)
+	}
+
+	return len(p), nil
+}
+
+// Close closes the file.
+func (w *Writer) Close() error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	return w.file.Close()
+}
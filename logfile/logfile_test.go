@@ -0,0 +1,93 @@
+package logfile_test
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/numtide/treefmt/v2/logfile"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriter(t *testing.T) {
+	as := require.New(t)
+
+	path := filepath.Join(t.TempDir(), "treefmt.log")
+
+	var console strings.Builder
+
+	writer, err := logfile.Open(path, 0, &console, log.InfoLevel)
+	as.NoError(err)
+
+	logger := log.NewWithOptions(writer, log.Options{Level: log.DebugLevel})
+	logger.Debug("walking")
+	logger.Info("formatting")
+	logger.Warn("no formatter for path: foo.txt")
+	logger.Error("formatter failed:\nexit status 1")
+
+	as.NoError(writer.Close())
+
+	// the console only receives entries at or above its level
+	as.Equal(
+		"INFO formatting\nWARN no formatter for path: foo.txt\nERRO formatter failed:\nexit status 1\n",
+		console.String(),
+	)
+
+	// whilst the file receives everything, with a timestamp
+	content, err := os.ReadFile(path)
+	as.NoError(err)
+
+	as.Regexp(regexp.MustCompile(
+		`^\S+ DEBU walking\n\S+ INFO formatting\n\S+ WARN no formatter for path: foo.txt\n`+
+			`\S+ ERRO formatter failed:\nexit status 1\n$`,
+	), string(content))
+
+	// entries are appended to an existing file
+	writer, err = logfile.Open(path, 0, &console, log.InfoLevel)
+	as.NoError(err)
+
+	_, err = writer.Write([]byte("INFO again\n"))
+	as.NoError(err)
+	as.NoError(writer.Close())
+
+	content, err = os.ReadFile(path)
+	as.NoError(err)
+	as.Contains(string(content), " DEBU walking\n")
+	as.True(strings.HasSuffix(string(content), " INFO again\n"))
+}
+
+func TestRotation(t *testing.T) {
+	as := require.New(t)
+
+	path := filepath.Join(t.TempDir(), "treefmt.log")
+
+	var console strings.Builder
+
+	// allow for the first two entries, along with their timestamps
+	stamp := len(time.Now().Format(time.RFC3339)) + 1
+
+	writer, err := logfile.Open(path, int64(2*stamp+len("INFO one\nINFO two\n")), &console, log.WarnLevel)
+	as.NoError(err)
+
+	for _, entry := range []string{"INFO one\n", "INFO two\n", "INFO three\n"} {
+		_, err = writer.Write([]byte(entry))
+		as.NoError(err)
+	}
+
+	as.NoError(writer.Close())
+	as.Empty(console.String())
+
+	content, err := os.ReadFile(path + ".1")
+	as.NoError(err)
+	as.Contains(string(content), "INFO one\n")
+	as.Contains(string(content), "INFO two\n")
+
+	content, err = os.ReadFile(path)
+	as.NoError(err)
+	as.Contains(string(content), "INFO three\n")
+	as.NotContains(string(content), "INFO two\n")
+}
@@ -4,12 +4,14 @@ import (
 	"os"
 
 	"github.com/numtide/treefmt/v2/cmd"
+	"github.com/numtide/treefmt/v2/sandbox"
 )
 
 func main() {
-	// todo how are exit codes thrown by commands?
+	// formatters which are sandboxed with landlock, or have resource limits, are started by treefmt itself, which
+	// restricts itself first
+	sandbox.Exec()
+
 	root, _ := cmd.NewRoot()
-	if err := root.Execute(); err != nil {
-		os.Exit(1)
-	}
+	os.Exit(cmd.Execute(root))
 }
@@ -0,0 +1,207 @@
+package metrics
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/numtide/treefmt/v2/stats"
+)
+
+// formatter accumulates the work performed by a formatter across runs.
+type formatter struct {
+	files    int
+	batches  int
+	duration time.Duration
+	failures int
+}
+
+// Registry accumulates the stats of each formatting run, for as long as treefmt is running, and exposes them in the
+// Prometheus text format.
+type Registry struct {
+	lock       sync.Mutex
+	runs       int
+	failedRuns int
+	duration   time.Duration
+	lastRun    time.Time
+	counters   map[stats.Type]int
+	formatters map[string]*formatter
+}
+
+// New creates an empty Registry.
+func New() *Registry {
+	return &Registry{
+		counters:   make(map[stats.Type]int),
+		formatters: make(map[string]*formatter),
+	}
+}
+
+// Record adds the stats of a formatting run to the registry.
+// err is the outcome of the run, which is counted as a failure if non-nil.
+func (r *Registry) Record(summary stats.Summary, err error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.runs++
+	r.duration += summary.Elapsed
+	r.lastRun = time.Now()
+
+	if err != nil {
+		r.failedRuns++
+	}
+
+	for t, value := range summary.Counters {
+		r.counters[t] += value
+	}
+
+	for name, f := range summary.Formatters {
+		m := r.formatter(name)
+		m.files += f.Files
+		m.batches += f.Batches
+		m.duration += f.Duration
+	}
+
+	for _, failure := range summary.Failures {
+		r.formatter(failure.Formatter).failures++
+	}
+}
+
+// formatter returns the metrics for the named formatter, creating them if necessary.
+// The caller must hold the lock.
+func (r *Registry) formatter(name string) *formatter {
+	f, ok := r.formatters[name]
+	if !ok {
+		f = &formatter{}
+		r.formatters[name] = f
+	}
+
+	return f
+}
+
+// Write writes the metrics to w in the Prometheus text exposition format.
+// See https://prometheus.io/docs/instrumenting/exposition_formats/
+func (r *Registry) Write(w io.Writer) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	out := bufio.NewWriter(w)
+
+	metric := func(name string, kind string, help string) {
+		fmt.Fprintf(out, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+	}
+
+	metric("treefmt_runs_total", "counter", "The number of formatting runs.")
+	fmt.Fprintf(out, "treefmt_runs_total %d\n", r.runs)
+
+	metric("treefmt_run_failures_total", "counter", "The number of formatting runs which failed.")
+	fmt.Fprintf(out, "treefmt_run_failures_total %d\n", r.failedRuns)
+
+	metric("treefmt_run_duration_seconds_total", "counter", "The time spent formatting, across all runs.")
+	fmt.Fprintf(out, "treefmt_run_duration_seconds_total %g\n", r.duration.Seconds())
+
+	if !r.lastRun.IsZero() {
+		metric("treefmt_last_run_timestamp_seconds", "gauge", "The time at which the last formatting run completed.")
+		fmt.Fprintf(out, "treefmt_last_run_timestamp_seconds %d\n", r.lastRun.Unix())
+	}
+
+	metric(
+		"treefmt_files_total", "counter",
+		"The number of files processed, by type. Cached files were matched but already formatted.",
+	)
+
+	for _, t := range stats.TypeValues() {
+		fmt.Fprintf(out, "treefmt_files_total{type=%q} %d\n", t.String(), r.counters[t])
+	}
+
+	names := make([]string, 0, len(r.formatters))
+	for name := range r.formatters {
+		names = append(names, name)
+	}
+
+	slices.Sort(names)
+
+	formatterMetrics := []struct {
+		name  string
+		help  string
+		value func(f *formatter) string
+	}{
+		{
+			"treefmt_formatter_files_total", "The number of files processed by each formatter.",
+			func(f *formatter) string { return fmt.Sprint(f.files) },
+		},
+		{
+			"treefmt_formatter_batches_total", "The number of batches processed by each formatter.",
+			func(f *formatter) string { return fmt.Sprint(f.batches) },
+		},
+		{
+			"treefmt_formatter_duration_seconds_total", "The time spent by each formatter.",
+			func(f *formatter) string { return fmt.Sprintf("%g", f.duration.Seconds()) },
+		},
+		{
+			"treefmt_formatter_failures_total", "The number of times each formatter failed.",
+			func(f *formatter) string { return fmt.Sprint(f.failures) },
+		},
+	}
+
+	for _, m := range formatterMetrics {
+		metric(m.name, "counter", m.help)
+
+		for _, name := range names {
+			fmt.Fprintf(out, "%s{formatter=%q} %s\n", m.name, name, m.value(r.formatters[name]))
+		}
+	}
+
+	return out.Flush()
+}
+
+// ServeHTTP writes the metrics in response to a scrape.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+	if err := r.Write(w); err != nil {
+		log.Errorf("failed to write metrics: %v", err)
+	}
+}
+
+// Serve exposes the metrics over HTTP at /metrics on addr e.g. :9090, until ctx is cancelled.
+// It returns the address which is being listened on.
+func (r *Registry) Serve(ctx context.Context, addr string) (net.Addr, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen for metrics requests on %s: %w", addr, err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", r)
+
+	server := &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		<-ctx.Done()
+
+		if err := server.Close(); err != nil {
+			log.Errorf("failed to close metrics server: %v", err)
+		}
+	}()
+
+	go func() {
+		if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Errorf("metrics server failed: %v", err)
+		}
+	}()
+
+	log.Infof("serving metrics on http://%s/metrics", listener.Addr())
+
+	return listener.Addr(), nil
+}
@@ -0,0 +1,99 @@
+package metrics_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/numtide/treefmt/v2/metrics"
+	"github.com/numtide/treefmt/v2/stats"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegistry(t *testing.T) {
+	as := require.New(t)
+
+	registry := metrics.New()
+
+	statz := stats.New()
+	statz.Add(stats.Traversed, 10)
+	statz.Add(stats.Matched, 4)
+	statz.Add(stats.Cached, 3)
+	statz.Add(stats.Formatted, 1)
+	statz.RecordFormatter("gofmt", 1, 1500*time.Millisecond)
+
+	registry.Record(statz.Summary(), nil)
+
+	statz = stats.New()
+	statz.Add(stats.Traversed, 2)
+	statz.Add(stats.Matched, 2)
+	statz.Add(stats.Errored, 2)
+	statz.RecordFormatter("gofmt", 1, 500*time.Millisecond)
+	statz.RecordFormatter("nixfmt", 1, 250*time.Millisecond)
+	statz.RecordFailure(stats.Failure{Formatter: "nixfmt", Paths: []string{"a.nix"}, Error: "exit status 1"})
+
+	registry.Record(statz.Summary(), errors.New("formatting failures detected"))
+
+	var out strings.Builder
+	as.NoError(registry.Write(&out))
+
+	for _, line := range []string{
+		"# TYPE treefmt_runs_total counter",
+		"treefmt_runs_total 2",
+		"treefmt_run_failures_total 1",
+		`treefmt_files_total{type="traversed"} 12`,
+		`treefmt_files_total{type="matched"} 6`,
+		`treefmt_files_total{type="cached"} 3`,
+		`treefmt_files_total{type="errored"} 2`,
+		`treefmt_formatter_files_total{formatter="gofmt"} 2`,
+		`treefmt_formatter_files_total{formatter="nixfmt"} 1`,
+		`treefmt_formatter_batches_total{formatter="gofmt"} 2`,
+		`treefmt_formatter_duration_seconds_total{formatter="gofmt"} 2`,
+		`treefmt_formatter_duration_seconds_total{formatter="nixfmt"} 0.25`,
+		`treefmt_formatter_failures_total{formatter="gofmt"} 0`,
+		`treefmt_formatter_failures_total{formatter="nixfmt"} 1`,
+	} {
+		as.Contains(out.String(), line+"\n")
+	}
+}
+
+func TestServe(t *testing.T) {
+	as := require.New(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	registry := metrics.New()
+
+	addr, err := registry.Serve(ctx, "127.0.0.1:0")
+	as.NoError(err)
+
+	get := func(path string) (*http.Response, string) {
+		res, err := http.Get(fmt.Sprintf("http://%s%s", addr, path)) //nolint:noctx
+		as.NoError(err)
+
+		defer res.Body.Close()
+
+		body, err := io.ReadAll(res.Body)
+		as.NoError(err)
+
+		return res, string(body)
+	}
+
+	res, body := get("/metrics")
+	as.Equal(http.StatusOK, res.StatusCode)
+	as.Contains(res.Header.Get("Content-Type"), "text/plain")
+	as.Contains(body, "treefmt_runs_total 0\n")
+
+	res, _ = get("/other")
+	as.Equal(http.StatusNotFound, res.StatusCode)
+
+	// the address cannot be listened on twice
+	_, err = registry.Serve(ctx, addr.String())
+	as.ErrorContains(err, "failed to listen for metrics requests")
+}
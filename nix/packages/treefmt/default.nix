@@ -47,7 +47,7 @@ in
     ];
 
     nativeBuildInputs =
-      [pkgs.git]
+      [pkgs.git pkgs.jujutsu]
       ++
       # we need some formatters available for the tests
       import ./formatters.nix pkgs;
@@ -0,0 +1,172 @@
+// Package treefmt allows other Go programs to embed treefmt, formatting a tree and inspecting the outcome without
+// shelling out to the treefmt executable and parsing its logs.
+//
+//	cfg, err := treefmt.LoadConfig(treefmt.Options{WorkingDir: "path/to/repo"})
+//	if err != nil {
+//		return err
+//	}
+//
+//	runner, err := treefmt.New(cfg)
+//	if err != nil {
+//		return err
+//	}
+//	defer runner.Close()
+//
+//	statz, err := runner.Format(ctx)
+package treefmt
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/numtide/treefmt/v2/config"
+	"github.com/numtide/treefmt/v2/format"
+	"github.com/numtide/treefmt/v2/runner"
+	"github.com/numtide/treefmt/v2/stats"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+var (
+	// ErrFormattingFailures is returned when one or more formatters failed, see stats.Stats.Failures.
+	ErrFormattingFailures = format.ErrFormattingFailures
+	// ErrFailOnChange is returned when files were changed and the fail-on-change setting is enabled.
+	ErrFailOnChange = runner.ErrFailOnChange
+	// ErrUnmatched is returned when paths had no formatter and the on-unmatched setting is fail-with-list.
+	ErrUnmatched = runner.ErrUnmatched
+)
+
+// Options determine how LoadConfig finds and reads the config file.
+type Options struct {
+	// WorkingDir is the directory from which the config file is searched for, defaulting to the current directory.
+	WorkingDir string
+	// ConfigFile is the path of the config file, relative to WorkingDir. If empty, the config file is searched for
+	// in the same way as the treefmt executable does.
+	ConfigFile string
+	// Settings override the entries of the config file, keyed by the name of the corresponding flag, e.g.
+	// "no-cache": true or "formatters": []string{"gofmt"}.
+	Settings map[string]any
+}
+
+// LoadConfig reads the config file described by opts.
+// Unlike the treefmt executable, the environment is not consulted, so TREEFMT_* variables have no effect.
+func LoadConfig(opts Options) (*config.Config, error) {
+	workingDir, err := filepath.Abs(opts.WorkingDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get absolute path for working directory: %w", err)
+	}
+
+	// the defaults of each entry are those of the corresponding flag
+	fs := pflag.NewFlagSet("treefmt", pflag.ContinueOnError)
+	config.SetFlags(fs)
+
+	v := viper.New()
+	if err = config.BindFlags(v, fs); err != nil {
+		return nil, fmt.Errorf("failed to bind flags: %w", err)
+	}
+
+	for key, value := range opts.Settings {
+		if fs.Lookup(key) == nil {
+			return nil, fmt.Errorf("unknown setting: %s", key)
+		}
+
+		v.Set(key, value)
+	}
+
+	v.Set("working-dir", workingDir)
+
+	configFile := opts.ConfigFile
+	if configFile != "" && !filepath.IsAbs(configFile) {
+		configFile = filepath.Join(workingDir, configFile)
+	}
+
+	if configFile, err = config.Locate(configFile, workingDir); err != nil {
+		return nil, fmt.Errorf("failed to find treefmt config file: %w", err)
+	}
+
+	v.SetConfigFile(configFile)
+	v.SetConfigType(config.FileType(configFile))
+
+	if err = v.ReadInConfig(); err != nil {
+		return nil, fmt.Errorf("failed to read config file '%s': %w", configFile, err)
+	} else if err = config.ResolveImports(v); err != nil {
+		return nil, fmt.Errorf("failed to resolve config imports: %w", err)
+	}
+
+	cfg, err := config.FromViper(v)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load config: %w", err)
+	}
+
+	return cfg, nil
+}
+
+// Runner formats paths within the tree root of a config. It can be used to format paths any number of times, but only
+// one at a time, and must be closed once it is no longer needed.
+type Runner struct {
+	cfg    *config.Config
+	runner *runner.Runner
+}
+
+// New creates a Runner for the given config. The cache is only opened whilst formatting, unless the no-cache setting
+// is enabled.
+func New(cfg *config.Config) (*Runner, error) {
+	r, err := runner.NewShared(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Runner{cfg: cfg, runner: r}, nil
+}
+
+// Format applies the configured formatters to the given paths, which are either absolute or relative to the tree
+// root, returning the stats of the run. If no paths are given, the entire tree root is formatted.
+// Whilst formatting, the tree root is locked, so other treefmt processes do not format it at the same time.
+// The stats are returned even if formatting fails, e.g. with ErrFormattingFailures.
+func (r *Runner) Format(ctx context.Context, paths ...string) (*stats.Stats, error) {
+	relPaths := make([]string, len(paths))
+
+	for i, path := range paths {
+		relPath, err := runner.RelativePath(r.cfg.TreeRoot, path)
+		if err != nil {
+			return nil, err
+		}
+
+		if _, err = os.Stat(filepath.Join(r.cfg.TreeRoot, relPath)); err != nil {
+			return nil, fmt.Errorf("path %s not found", path)
+		}
+
+		relPaths[i] = relPath
+	}
+
+	statz := stats.New()
+
+	return &statz, r.runner.Format(ctx, &statz, relPaths)
+}
+
+// FormatBuffer formats the content read from input as if it were a file at path, writing the result to output.
+// The path, which need not exist, is used for matching the content against the configured formatters.
+func (r *Runner) FormatBuffer(
+	ctx context.Context,
+	path string,
+	input io.Reader,
+	output io.Writer,
+) (*stats.Stats, error) {
+	relPath, err := runner.RelativePath(r.cfg.TreeRoot, path)
+	if err != nil {
+		return nil, err
+	}
+
+	statz := stats.New()
+
+	return &statz, r.runner.FormatBuffer(ctx, &statz, relPath, input, output)
+}
+
+// Close releases any resources held by the Runner. The cache is closed after each call to Format, so there is currently
+// nothing to release.
+func (r *Runner) Close() error {
+	return nil
+}
@@ -0,0 +1,121 @@
+package treefmt_test
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/numtide/treefmt/v2/pkg/treefmt"
+	"github.com/numtide/treefmt/v2/stats"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunner(t *testing.T) {
+	as := require.New(t)
+
+	tempDir := t.TempDir()
+
+	for name, content := range map[string]string{
+		"treefmt.toml": "[formatter.json]\ncommand = \"builtin:json\"\nincludes = [\"*.json\"]\n\n" +
+			"[formatter.text]\ncommand = \"builtin:trim-whitespace\"\nincludes = [\"*.txt\"]\n",
+		"a.json":       `{"a": 1}`,
+		"b.json":       "{\n  \"b\": 2\n}\n",
+		"nested/c.txt": "trailing  \n",
+	} {
+		as.NoError(os.MkdirAll(filepath.Dir(filepath.Join(tempDir, name)), 0o755))
+		as.NoError(os.WriteFile(filepath.Join(tempDir, name), []byte(content), 0o600))
+	}
+
+	cfg, err := treefmt.LoadConfig(treefmt.Options{
+		WorkingDir: tempDir,
+		Settings:   map[string]any{"cache-dir": t.TempDir()},
+	})
+	as.NoError(err)
+	as.Equal(tempDir, cfg.TreeRoot)
+
+	runner, err := treefmt.New(cfg)
+	as.NoError(err)
+
+	defer func() {
+		as.NoError(runner.Close())
+	}()
+
+	ctx := context.Background()
+
+	// format the entire tree root
+	statz, err := runner.Format(ctx)
+	as.NoError(err)
+	as.Equal(4, statz.Value(stats.Traversed))
+	as.Equal(3, statz.Value(stats.Matched))
+	as.Equal(2, statz.Value(stats.Changed))
+
+	content, err := os.ReadFile(filepath.Join(tempDir, "nested/c.txt"))
+	as.NoError(err)
+	as.Equal("trailing\n", string(content))
+
+	// the runner can be used again, with paths relative to the tree root or absolute
+	as.NoError(os.WriteFile(filepath.Join(tempDir, "a.json"), []byte(`{"a": 2}`), 0o600))
+
+	statz, err = runner.Format(ctx, "a.json", filepath.Join(tempDir, "nested"))
+	as.NoError(err)
+	as.Equal(2, statz.Value(stats.Traversed))
+	as.Equal(1, statz.Value(stats.Changed))
+
+	_, err = runner.Format(ctx, "../outside.json")
+	as.ErrorContains(err, "not inside the tree root")
+
+	// but a file whose name merely starts with .. is inside it
+	as.NoError(os.WriteFile(filepath.Join(tempDir, "..inside.json"), []byte(`{"d": 4}`), 0o600))
+
+	statz, err = runner.Format(ctx, "..inside.json")
+	as.NoError(err)
+	as.Equal(1, statz.Value(stats.Changed))
+
+	// content can be formatted without writing it to a file
+	var output bytes.Buffer
+
+	_, err = runner.FormatBuffer(ctx, "buffer.json", strings.NewReader(`{"c": 3}`), &output)
+	as.NoError(err)
+	as.Equal("{\n  \"c\": 3\n}\n", output.String())
+
+	// formatting failures are reported with the stats of the run
+	as.NoError(os.WriteFile(filepath.Join(tempDir, "b.json"), []byte("{"), 0o600))
+
+	statz, err = runner.Format(ctx, "b.json")
+	as.ErrorIs(err, treefmt.ErrFormattingFailures)
+	as.Len(statz.Failures(), 1)
+}
+
+func TestLoadConfig(t *testing.T) {
+	as := require.New(t)
+
+	tempDir := t.TempDir()
+	as.NoError(os.WriteFile(filepath.Join(tempDir, "fmt.toml"), []byte("[formatter.json]\n"+
+		"command = \"builtin:json\"\nincludes = [\"*.json\"]\n"), 0o600))
+
+	// the config file can be given explicitly, relative to the working directory
+	cfg, err := treefmt.LoadConfig(treefmt.Options{
+		WorkingDir: tempDir,
+		ConfigFile: "fmt.toml",
+		Settings:   map[string]any{"no-cache": true, "fail-on-change": true},
+	})
+	as.NoError(err)
+	as.True(cfg.NoCache)
+	as.True(cfg.FailOnChange)
+	as.Contains(cfg.FormatterConfigs, "json")
+
+	// settings must correspond to a flag
+	_, err = treefmt.LoadConfig(treefmt.Options{
+		WorkingDir: tempDir,
+		ConfigFile: "fmt.toml",
+		Settings:   map[string]any{"no-such-setting": true},
+	})
+	as.ErrorContains(err, "unknown setting: no-such-setting")
+
+	// without a config file, there is nothing to format
+	_, err = treefmt.LoadConfig(treefmt.Options{WorkingDir: tempDir})
+	as.ErrorContains(err, "failed to find treefmt config file")
+}
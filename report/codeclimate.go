@@ -0,0 +1,69 @@
+package report
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/numtide/treefmt/v2/build"
+	"github.com/numtide/treefmt/v2/stats"
+)
+
+// The following types model the subset of the Code Climate issue format consumed by GitLab's Code Quality widget.
+// See https://docs.gitlab.com/ee/ci/testing/code_quality.html#code-quality-report-format.
+type (
+	codeClimateIssue struct {
+		Type        string              `json:"type"`
+		CheckName   string              `json:"check_name"` //nolint:tagliatelle
+		Description string              `json:"description"`
+		Categories  []string            `json:"categories"`
+		Severity    string              `json:"severity"`
+		Fingerprint string              `json:"fingerprint"`
+		Location    codeClimateLocation `json:"location"`
+	}
+
+	codeClimateLocation struct {
+		Path  string           `json:"path"`
+		Lines codeClimateLines `json:"lines"`
+	}
+
+	codeClimateLines struct {
+		Begin int `json:"begin"`
+	}
+)
+
+// writeCodeClimate writes a Code Climate report containing an issue for each file which was changed.
+func writeCodeClimate(w io.Writer, statz *stats.Stats) error {
+	issues := []codeClimateIssue{}
+
+	for _, change := range statz.Changes() {
+		path := filepath.ToSlash(change.Path)
+		formatters := strings.Join(change.Formatters, ":")
+
+		// the fingerprint must uniquely identify an issue across runs, so it is derived from the path and the
+		// sequence of formatters that were applied
+		digest := sha256.Sum256([]byte(fmt.Sprintf("%s:%s:%s", build.Name, path, formatters)))
+
+		issues = append(issues, codeClimateIssue{
+			Type:        "issue",
+			CheckName:   fmt.Sprintf("%s/%s", build.Name, formatters),
+			Description: fmt.Sprintf("File is not formatted according to %s", strings.Join(change.Formatters, ", ")),
+			Categories:  []string{"Style"},
+			Severity:    "minor",
+			Fingerprint: hex.EncodeToString(digest[:]),
+			Location: codeClimateLocation{
+				Path:  path,
+				Lines: codeClimateLines{Begin: 1},
+			},
+		})
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+
+	return encoder.Encode(issues)
+}
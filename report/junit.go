@@ -0,0 +1,108 @@
+package report
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"github.com/numtide/treefmt/v2/build"
+	"github.com/numtide/treefmt/v2/stats"
+)
+
+// The following types model the de facto JUnit XML format as consumed by Jenkins, GitLab and others.
+type (
+	junitTestSuites struct {
+		XMLName  xml.Name         `xml:"testsuites"`
+		Name     string           `xml:"name,attr"`
+		Tests    int              `xml:"tests,attr"`
+		Failures int              `xml:"failures,attr"`
+		Time     float64          `xml:"time,attr"`
+		Suites   []junitTestSuite `xml:"testsuite"`
+	}
+
+	junitTestSuite struct {
+		Name      string          `xml:"name,attr"`
+		Tests     int             `xml:"tests,attr"`
+		Failures  int             `xml:"failures,attr"`
+		Time      float64         `xml:"time,attr"`
+		TestCases []junitTestCase `xml:"testcase"`
+	}
+
+	junitTestCase struct {
+		Name      string        `xml:"name,attr"`
+		ClassName string        `xml:"classname,attr"`
+		Time      float64       `xml:"time,attr"`
+		Failure   *junitFailure `xml:"failure,omitempty"`
+	}
+
+	junitFailure struct {
+		Message  string `xml:"message,attr"`
+		Contents string `xml:",chardata"`
+	}
+)
+
+// writeJunit writes a JUnit XML report containing a test case for each formatter which was executed.
+// A test case fails if the formatter changed any files, with the failure listing the files in question.
+func writeJunit(w io.Writer, statz *stats.Stats) error {
+	summary := statz.Summary()
+
+	// collect the files changed by each formatter
+	changes := make(map[string][]string)
+
+	for _, change := range summary.Changes {
+		for _, name := range change.Formatters {
+			changes[name] = append(changes[name], change.Path)
+		}
+	}
+
+	// sort formatters by name for a deterministic report
+	names := make([]string, 0, len(summary.Formatters))
+	for name := range summary.Formatters {
+		names = append(names, name)
+	}
+
+	slices.Sort(names)
+
+	suite := junitTestSuite{
+		Name:      build.Name,
+		Time:      summary.Elapsed.Seconds(),
+		TestCases: make([]junitTestCase, 0, len(names)),
+	}
+
+	for _, name := range names {
+		testCase := junitTestCase{
+			Name:      name,
+			ClassName: build.Name,
+			Time:      summary.Formatters[name].Duration.Seconds(),
+		}
+
+		if paths, ok := changes[name]; ok {
+			testCase.Failure = &junitFailure{
+				Message:  fmt.Sprintf("%d file(s) changed", len(paths)),
+				Contents: strings.Join(paths, "\n"),
+			}
+
+			suite.Failures++
+		}
+
+		suite.Tests++
+		suite.TestCases = append(suite.TestCases, testCase)
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return fmt.Errorf("failed to write xml header: %w", err)
+	}
+
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+
+	return encoder.Encode(junitTestSuites{
+		Name:     build.Name,
+		Tests:    suite.Tests,
+		Failures: suite.Failures,
+		Time:     suite.Time,
+		Suites:   []junitTestSuite{suite},
+	})
+}
@@ -0,0 +1,73 @@
+package report
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/numtide/treefmt/v2/stats"
+)
+
+//go:generate enumer -type=Format -text -transform=snake -output=./format_enum.go
+type Format int
+
+const (
+	Sarif Format = iota
+	Junit
+	Codeclimate
+)
+
+// Report describes a file which should be written in a given Format once formatting has completed.
+type Report struct {
+	Format Format
+	Path   string
+}
+
+// Parse parses a report specification of the form `<format>=<path>`, e.g. `sarif=treefmt.sarif`.
+func Parse(value string) (*Report, error) {
+	name, path, ok := strings.Cut(value, "=")
+	if !ok || path == "" {
+		return nil, fmt.Errorf("report '%s' must be of the form <format>=<path>", value)
+	}
+
+	format, err := FormatString(name)
+	if err != nil {
+		return nil, fmt.Errorf("invalid report format: %w", err)
+	}
+
+	return &Report{
+		Format: format,
+		Path:   path,
+	}, nil
+}
+
+// Write generates the report from the provided stats and writes it to the report's Path.
+func (r *Report) Write(statz *stats.Stats) error {
+	f, err := os.Create(r.Path)
+	if err != nil {
+		return fmt.Errorf("failed to create %s report file: %w", r.Format, err)
+	}
+
+	switch r.Format {
+	case Sarif:
+		err = writeSarif(f, statz)
+	case Junit:
+		err = writeJunit(f, statz)
+	case Codeclimate:
+		err = writeCodeClimate(f, statz)
+	default:
+		err = fmt.Errorf("unknown report format: %v", r.Format)
+	}
+
+	if err != nil {
+		_ = f.Close()
+
+		return fmt.Errorf("failed to write %s report: %w", r.Format, err)
+	}
+
+	if err = f.Close(); err != nil {
+		return fmt.Errorf("failed to close %s report file: %w", r.Format, err)
+	}
+
+	return nil
+}
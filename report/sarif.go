@@ -0,0 +1,133 @@
+package report
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/numtide/treefmt/v2/build"
+	"github.com/numtide/treefmt/v2/stats"
+)
+
+const (
+	sarifVersion = "2.1.0"
+	sarifSchema  = "https://json.schemastore.org/sarif-2.1.0.json"
+)
+
+// The following types model the subset of the SARIF 2.1.0 specification which we need to report formatting violations.
+// See https://docs.oasis-open.org/sarif/sarif/v2.1.0/sarif-v2.1.0.html.
+type (
+	sarifLog struct {
+		Version string     `json:"version"`
+		Schema  string     `json:"$schema"` //nolint:tagliatelle
+		Runs    []sarifRun `json:"runs"`
+	}
+
+	sarifRun struct {
+		Tool    sarifTool     `json:"tool"`
+		Results []sarifResult `json:"results"`
+	}
+
+	sarifTool struct {
+		Driver sarifDriver `json:"driver"`
+	}
+
+	sarifDriver struct {
+		Name           string      `json:"name"`
+		Version        string      `json:"version"`
+		InformationURI string      `json:"informationUri"`
+		Rules          []sarifRule `json:"rules"`
+	}
+
+	sarifRule struct {
+		ID               string       `json:"id"`
+		ShortDescription sarifMessage `json:"shortDescription"`
+	}
+
+	sarifResult struct {
+		RuleID    string          `json:"ruleId"`
+		Level     string          `json:"level"`
+		Message   sarifMessage    `json:"message"`
+		Locations []sarifLocation `json:"locations"`
+	}
+
+	sarifMessage struct {
+		Text string `json:"text"`
+	}
+
+	sarifLocation struct {
+		PhysicalLocation sarifPhysicalLocation `json:"physicalLocation"`
+	}
+
+	sarifPhysicalLocation struct {
+		ArtifactLocation sarifArtifactLocation `json:"artifactLocation"`
+	}
+
+	sarifArtifactLocation struct {
+		URI       string `json:"uri"`
+		URIBaseID string `json:"uriBaseId"`
+	}
+)
+
+// writeSarif writes a SARIF log containing a result for each file which was changed, with the rule identifying the
+// sequence of formatters responsible for the change.
+func writeSarif(w io.Writer, statz *stats.Stats) error {
+	rules := []sarifRule{}
+	results := []sarifResult{}
+	ruleIDs := make(map[string]bool)
+
+	for _, change := range statz.Changes() {
+		// the rule is the sequence of formatters that were applied to the file e.g. `deadnix:nixpkgs-fmt`
+		ruleID := strings.Join(change.Formatters, ":")
+
+		if !ruleIDs[ruleID] {
+			ruleIDs[ruleID] = true
+
+			rules = append(rules, sarifRule{
+				ID: ruleID,
+				ShortDescription: sarifMessage{
+					Text: fmt.Sprintf("File is not formatted according to %s", strings.Join(change.Formatters, ", ")),
+				},
+			})
+		}
+
+		results = append(results, sarifResult{
+			RuleID: ruleID,
+			Level:  "error",
+			Message: sarifMessage{
+				Text: fmt.Sprintf("%s was changed by %s", change.Path, strings.Join(change.Formatters, ", ")),
+			},
+			Locations: []sarifLocation{{
+				PhysicalLocation: sarifPhysicalLocation{
+					ArtifactLocation: sarifArtifactLocation{
+						URI:       filepath.ToSlash(change.Path),
+						URIBaseID: "%SRCROOT%",
+					},
+				},
+			}},
+		})
+	}
+
+	log := sarifLog{
+		Version: sarifVersion,
+		Schema:  sarifSchema,
+		Runs: []sarifRun{{
+			Tool: sarifTool{
+				Driver: sarifDriver{
+					Name:           build.Name,
+					Version:        build.Version,
+					InformationURI: "https://github.com/numtide/treefmt",
+					Rules:          rules,
+				},
+			},
+			Results: results,
+		}},
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+
+	return encoder.Encode(log)
+}
@@ -0,0 +1,46 @@
+package runner
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/numtide/treefmt/v2/config"
+	"github.com/numtide/treefmt/v2/walk/cache"
+)
+
+// Lock acquires the lock for the configured tree root, waiting for any other treefmt process which holds it for up
+// to the configured lock-wait duration.
+// If the lock file cannot be created, e.g. because the cache directory is not writable, a warning is logged and we
+// carry on without the lock rather than refusing to format anything.
+// The returned function releases the lock.
+func Lock(cfg *config.Config) (func(), error) {
+	var (
+		err  error
+		wait time.Duration
+	)
+
+	if cfg.LockWait != "" {
+		if wait, err = time.ParseDuration(cfg.LockWait); err != nil {
+			return nil, fmt.Errorf("invalid lock-wait value: %w", err)
+		} else if wait < 0 {
+			return nil, fmt.Errorf("invalid lock-wait value: must not be negative")
+		}
+	}
+
+	unlock, err := cache.Lock(cfg.TreeRoot, wait)
+	if errors.Is(err, cache.ErrLocked) {
+		return nil, err
+	} else if err != nil {
+		log.Warnf("continuing without locking the tree root: %v", err)
+
+		return func() {}, nil
+	}
+
+	return func() {
+		if err := unlock(); err != nil {
+			log.Errorf("%v", err)
+		}
+	}, nil
+}
@@ -0,0 +1,48 @@
+package runner_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/adrg/xdg"
+	"github.com/numtide/treefmt/v2/config"
+	"github.com/numtide/treefmt/v2/runner"
+	"github.com/numtide/treefmt/v2/walk/cache"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLock(t *testing.T) {
+	as := require.New(t)
+
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	xdg.Reload()
+
+	t.Cleanup(xdg.Reload)
+
+	cfg := &config.Config{TreeRoot: t.TempDir()}
+
+	unlock, err := runner.Lock(cfg)
+	as.NoError(err)
+
+	// another process cannot take the lock whilst it is held
+	_, err = runner.Lock(cfg)
+	as.ErrorIs(err, cache.ErrLocked)
+
+	unlock()
+
+	unlock, err = runner.Lock(cfg)
+	as.NoError(err)
+	unlock()
+
+	// if the lock file cannot be created, we carry on without it
+	blocker := filepath.Join(t.TempDir(), "file")
+	as.NoError(os.WriteFile(blocker, nil, 0o600))
+
+	t.Setenv("XDG_CACHE_HOME", blocker)
+	xdg.Reload()
+
+	unlock, err = runner.Lock(cfg)
+	as.NoError(err)
+	unlock()
+}
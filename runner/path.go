@@ -0,0 +1,25 @@
+package runner
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// RelativePath returns path relative to root, ensuring it is inside it. A relative path is taken to be relative to
+// root already, rather than the working directory.
+func RelativePath(root string, path string) (string, error) {
+	absPath := path
+	if !filepath.IsAbs(absPath) {
+		absPath = filepath.Join(root, absPath)
+	}
+
+	relPath, err := filepath.Rel(root, filepath.Clean(absPath))
+	if err != nil {
+		return "", fmt.Errorf("error computing relative path from %s to %s: %w", root, absPath, err)
+	} else if relPath == ".." || strings.HasPrefix(relPath, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("path %s not inside the tree root %s", path, root)
+	}
+
+	return relPath, nil
+}
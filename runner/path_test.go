@@ -0,0 +1,32 @@
+package runner_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/numtide/treefmt/v2/runner"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRelativePath(t *testing.T) {
+	as := require.New(t)
+
+	root := t.TempDir()
+
+	for path, expected := range map[string]string{
+		"foo.go":                             "foo.go",
+		"nested/../foo.go":                   "foo.go",
+		".":                                  ".",
+		filepath.Join(root, "nested/bar.go"): filepath.Join("nested", "bar.go"),
+		"..foo":                              "..foo",
+	} {
+		relPath, err := runner.RelativePath(root, path)
+		as.NoError(err, path)
+		as.Equal(expected, relPath, path)
+	}
+
+	for _, path := range []string{"..", "../foo.go", filepath.Dir(root)} {
+		_, err := runner.RelativePath(root, path)
+		as.ErrorContains(err, "not inside the tree root", path)
+	}
+}
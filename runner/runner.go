@@ -0,0 +1,431 @@
+// Package runner applies the configured formatters to paths within a tree root, independently of how treefmt is
+// invoked. It is shared by the treefmt command, its daemon and language server, and the public pkg/treefmt API.
+package runner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/numtide/treefmt/v2/config"
+	"github.com/numtide/treefmt/v2/format"
+	"github.com/numtide/treefmt/v2/metrics"
+	"github.com/numtide/treefmt/v2/stats"
+	"github.com/numtide/treefmt/v2/tracing"
+	"github.com/numtide/treefmt/v2/walk"
+	"github.com/numtide/treefmt/v2/walk/cache"
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	ErrFailOnChange = errors.New("unexpected changes detected, --fail-on-change is enabled")
+	ErrUnmatched    = errors.New("paths without a formatter detected, --on-unmatched is fail-with-list")
+	// ErrInvalidConfig is matched by errors caused by a config from which the formatters could not be created.
+	ErrInvalidConfig = errors.New("invalid config")
+)
+
+// Runner applies the configured formatters to paths within the tree root.
+// It can be used to format paths multiple times, e.g. when watching for changes or serving requests from a daemon.
+type Runner struct {
+	cfg      *config.Config
+	db       *bolt.DB
+	walkType walk.Type
+	symlinks walk.Symlinks
+	// tracer exports the spans of each run, and is only set if requested with --otel-endpoint
+	tracer *tracing.Tracer
+	// metrics accumulates the stats of every run, and is only set if they are to be exposed with --metrics-listen
+	metrics *metrics.Registry
+	// confirm is passed to the formatter of each run, and is only set if the formatters should be confirmed first
+	confirm format.ConfirmFunc
+	// shared indicates the runner is long-lived, so it only holds the lock and opens the cache whilst formatting,
+	// allowing other treefmt processes to run in between
+	shared bool
+	// clearCache indicates the cache should be cleared the next time a shared runner opens it
+	clearCache bool
+	// formatter is created by the first run, or LoadFormatters, and reset for each subsequent run, so the formatters
+	// are only created once for each time the config is loaded
+	formatter *format.CompositeFormatter
+}
+
+// New creates a Runner for the given config.
+// db is used for caching and may be nil, in which case the cache is disabled.
+func New(cfg *config.Config, db *bolt.DB) (*Runner, error) {
+	// parse the walk type
+	walkType, err := walk.ParseType(cfg.Walk)
+	if err != nil {
+		return nil, fmt.Errorf("invalid walk type: %w", err)
+	}
+
+	// parse how symlinks are handled
+	symlinks, err := walk.SymlinksString(cfg.Symlinks)
+	if err != nil {
+		return nil, fmt.Errorf("invalid symlinks: %w", err)
+	}
+
+	r := &Runner{
+		cfg:      cfg,
+		db:       db,
+		walkType: walkType,
+		symlinks: symlinks,
+	}
+
+	if cfg.MetricsListen != "" {
+		r.metrics = metrics.New()
+	}
+
+	if cfg.OtelEndpoint != "" {
+		if r.tracer, err = tracing.New(cfg.OtelEndpoint); err != nil {
+			return nil, fmt.Errorf("invalid otel endpoint: %w", err)
+		}
+	}
+
+	return r, nil
+}
+
+// NewShared creates a Runner for a long-lived process, such as the daemon or --watch, which takes the lock for
+// the tree root and opens the cache for each run, rather than holding them for as long as it is running.
+func NewShared(cfg *config.Config) (*Runner, error) {
+	r, err := New(cfg, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	r.shared = true
+	r.clearCache = cfg.ClearCache
+
+	return r, nil
+}
+
+// WalkType returns the type of walker with which the tree root is traversed.
+func (r *Runner) WalkType() walk.Type {
+	return r.walkType
+}
+
+// Confirm sets a function with which the formatters to be applied are confirmed at the start of each run, before any
+// files are formatted.
+func (r *Runner) Confirm(fn format.ConfirmFunc) {
+	r.confirm = fn
+}
+
+// acquire takes the lock for the tree root and opens the cache for a single run of a shared runner.
+// The returned function closes the cache and releases the lock.
+func (r *Runner) acquire() (func(), error) {
+	unlock, err := Lock(r.cfg)
+	if err != nil {
+		return nil, err
+	} else if r.cfg.NoCache {
+		return unlock, nil
+	}
+
+	if r.db, err = cache.Open(r.cfg.CacheDir, r.cfg.TreeRoot); err != nil {
+		unlock()
+
+		return nil, fmt.Errorf("failed to open cache: %w", err)
+	}
+
+	release := func() {
+		if err := r.db.Close(); err != nil {
+			log.Errorf("failed to close cache: %v", err)
+		}
+
+		r.db = nil
+
+		unlock()
+	}
+
+	if r.clearCache {
+		if err = cache.Clear(r.db); err != nil {
+			release()
+
+			return nil, fmt.Errorf("failed to clear cache: %w", err)
+		}
+
+		r.clearCache = false
+	}
+
+	return release, nil
+}
+
+// LoadFormatters creates the formatters up front, rather than on the first run, so any problem with their config is
+// reported straight away.
+func (r *Runner) LoadFormatters() error {
+	if r.formatter != nil {
+		return nil
+	}
+
+	statz := stats.New()
+
+	formatter, err := format.NewCompositeFormatter(r.cfg, &statz, walk.BatchSize)
+	if err != nil {
+		return &configError{fmt.Errorf("failed to create composite formatter: %w", err)}
+	}
+
+	r.formatter = formatter
+
+	return nil
+}
+
+// ServeMetrics exposes the stats of every run over HTTP, if requested with --metrics-listen, until ctx is cancelled.
+func (r *Runner) ServeMetrics(ctx context.Context) error {
+	if r.metrics == nil {
+		return nil
+	}
+
+	_, err := r.metrics.Serve(ctx, r.cfg.MetricsListen)
+
+	return err
+}
+
+// Format traverses the given paths, which must be relative to the tree root, applying the configured formatters and
+// recording the outcome in statz.
+// If no paths are provided, the entire tree root is traversed. With --staged, only the files staged within the paths
+// are traversed.
+func (r *Runner) Format(ctx context.Context, statz *stats.Stats, paths []string) error {
+	return r.applyLocked(ctx, statz, func() (walk.Reader, error) {
+		if r.cfg.Staged {
+			// the cache is bypassed, as partially staged files are swapped for their staged content whilst formatted
+			walker, err := walk.NewStagedReader(r.cfg.TreeRoot, paths, statz)
+			if err != nil {
+				return nil, fmt.Errorf("failed to create walker: %w", err)
+			}
+
+			return walker, nil
+		}
+
+		if r.cfg.From != "" {
+			return r.rangeWalker(paths, statz)
+		}
+
+		// create a new walker for traversing the paths
+		walker, err := walk.NewCompositeReader(
+			r.walkType, r.cfg.TreeRoot, paths, r.cfg.Since, r.symlinks, r.db, statz,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create walker: %w", err)
+		}
+
+		return walker, nil
+	})
+}
+
+// FormatFile applies the configured formatters to a single file, which must be relative to the tree root, recording
+// the outcome in statz. Unlike Format, the file is read directly rather than by traversing the tree root.
+func (r *Runner) FormatFile(ctx context.Context, statz *stats.Stats, path string) error {
+	return r.applyLocked(ctx, statz, func() (walk.Reader, error) {
+		return walk.NewFileReader(r.cfg.TreeRoot, path, r.symlinks, statz), nil
+	})
+}
+
+// FormatBuffer formats the content read from input as if it were a file at path, writing the result to output.
+// The path, which need not exist, is used for matching the content against the configured formatters.
+func (r *Runner) FormatBuffer(
+	ctx context.Context,
+	statz *stats.Stats,
+	path string,
+	input io.Reader,
+	output io.Writer,
+) error {
+	return r.apply(ctx, statz, func() (walk.Reader, error) {
+		return walk.NewBufferReader(r.cfg.TreeRoot, path, statz, input, output), nil
+	})
+}
+
+// rangeWalker creates a walker for the files touched by the commits between --from and --to, within the given paths.
+func (r *Runner) rangeWalker(paths []string, statz *stats.Stats) (walk.Reader, error) {
+	reader, err := walk.NewGitRangeReader(r.cfg.TreeRoot, paths, r.cfg.From, r.cfg.To, r.symlinks, statz)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create walker: %w", err)
+	} else if r.db == nil {
+		return reader, nil
+	}
+
+	walker, err := walk.NewCachedReader(r.db, walk.BatchSize, reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create walker: %w", err)
+	}
+
+	return walker, nil
+}
+
+// applyLocked calls apply, first taking the lock for the tree root and opening the cache if the runner is shared.
+// Otherwise, whoever created the runner is responsible for them.
+func (r *Runner) applyLocked(ctx context.Context, statz *stats.Stats, newWalker func() (walk.Reader, error)) error {
+	if r.shared {
+		release, err := r.acquire()
+		if err != nil {
+			return err
+		}
+
+		defer release()
+	}
+
+	return r.apply(ctx, statz, newWalker)
+}
+
+// apply reads files from the walker created by newWalker until it is exhausted, applying the configured formatters
+// to each. The walker is created after running the pre hook, so it sees any files the hook generates.
+func (r *Runner) apply(
+	ctx context.Context,
+	statz *stats.Stats,
+	newWalker func() (walk.Reader, error),
+) (err error) {
+	cfg := r.cfg
+
+	if r.metrics != nil {
+		defer func() {
+			r.metrics.Record(statz.Summary(), err)
+		}()
+	}
+
+	if r.tracer != nil {
+		var span *tracing.Span
+
+		ctx, span = tracing.Start(tracing.WithTracer(ctx, r.tracer), "format")
+
+		defer func() {
+			span.SetAttributes(
+				tracing.Int("files.traversed", statz.Value(stats.Traversed)),
+				tracing.Int("files.matched", statz.Value(stats.Matched)),
+				tracing.Int("files.cached", statz.Value(stats.Cached)),
+				tracing.Int("files.formatted", statz.Value(stats.Formatted)),
+				tracing.Int("files.changed", statz.Value(stats.Changed)),
+			)
+			span.SetError(err)
+			span.End()
+
+			// a problem with the collector should not cause formatting to fail
+			if exportErr := r.tracer.Export(context.WithoutCancel(ctx)); exportErr != nil {
+				log.Warnf("failed to export traces: %v", exportErr)
+			}
+		}()
+	}
+
+	if cfg.Hooks.Pre != "" {
+		if err = format.RunHook(ctx, cfg, "pre", cfg.Hooks.Pre, nil); err != nil {
+			return err
+		}
+	}
+
+	if cfg.Hooks.Post != "" {
+		// the post hook is run however formatting ends, and is told how it went
+		defer func() {
+			if hookErr := r.runPostHook(ctx, statz, err); hookErr != nil {
+				err = errors.Join(err, hookErr)
+			}
+		}()
+	}
+
+	walker, err := newWalker()
+	if err != nil {
+		return err
+	}
+
+	// create a composite formatter which will handle applying the correct formatters to each file we traverse, or
+	// reuse the one created by a previous run
+	if r.formatter == nil {
+		if r.formatter, err = format.NewCompositeFormatter(cfg, statz, walk.BatchSize); err != nil {
+			return &configError{fmt.Errorf("failed to create composite formatter: %w", err)}
+		}
+	} else if err = r.formatter.Reset(statz); err != nil {
+		return err
+	}
+
+	formatter := r.formatter
+
+	if r.confirm != nil {
+		formatter.Confirm(r.confirm)
+	}
+
+	// start traversing
+	files := make([]*walk.File, walk.BatchSize)
+
+	for {
+		// read the next batch
+		readCtx, cancel := context.WithTimeout(ctx, 1*time.Second)
+		readCtx, span := tracing.Start(readCtx, "walk")
+		start := time.Now()
+		n, err := walker.Read(readCtx, files)
+
+		statz.RecordWalk(time.Since(start))
+
+		span.SetAttributes(tracing.Int("files", n))
+		span.End()
+
+		// ensure context is cancelled to release resources
+		cancel()
+
+		// format
+		if err := formatter.Apply(ctx, files[:n]); err != nil {
+			return fmt.Errorf("formatting failure: %w", err)
+		}
+
+		if errors.Is(err, io.EOF) {
+			// we have finished traversing
+			break
+		} else if err != nil {
+			// something went wrong
+			return fmt.Errorf("failed to read files: %w", err)
+		}
+	}
+
+	// finalize formatting
+	formatErr := formatter.Close(ctx)
+
+	// close the walker, ensuring any pending file release hooks finish
+	if err = walker.Close(); err != nil {
+		return fmt.Errorf("failed to close walker: %w", err)
+	}
+
+	if formatErr != nil {
+		// return an error if any formatting failures were detected
+		return formatErr
+	} else if changed := statz.Value(stats.Changed); cfg.FailOnChange && changed != 0 {
+		// if fail on change has been enabled, check that no files were actually changed, throwing an error if so
+		return fmt.Errorf("%w: %d file(s) changed", ErrFailOnChange, changed)
+	} else if unmatched := len(statz.Unmatched()); unmatched != 0 {
+		// unmatched paths are only collected when they should cause an error
+		return fmt.Errorf("%w: %d path(s) unmatched", ErrUnmatched, unmatched)
+	}
+
+	return nil
+}
+
+// runPostHook runs the post hook, passing the stats of the run and any error with which it failed in the environment.
+func (r *Runner) runPostHook(ctx context.Context, statz *stats.Stats, formatErr error) error {
+	vars := map[string]string{
+		"TREEFMT_TRAVERSED": strconv.Itoa(statz.Value(stats.Traversed)),
+		"TREEFMT_MATCHED":   strconv.Itoa(statz.Value(stats.Matched)),
+		"TREEFMT_FORMATTED": strconv.Itoa(statz.Value(stats.Formatted)),
+		"TREEFMT_CHANGED":   strconv.Itoa(statz.Value(stats.Changed)),
+		"TREEFMT_ERROR":     "",
+	}
+
+	if formatErr != nil {
+		vars["TREEFMT_ERROR"] = formatErr.Error()
+	}
+
+	// formatting may have been cancelled, but the hook should still be told how it ended
+	return format.RunHook(context.WithoutCancel(ctx), r.cfg, "post", r.cfg.Hooks.Post, vars)
+}
+
+// configError marks an error as being caused by the config, so that it matches ErrInvalidConfig, without changing its
+// message.
+type configError struct {
+	err error
+}
+
+func (e *configError) Error() string {
+	return e.err.Error()
+}
+
+func (e *configError) Unwrap() error {
+	return e.err
+}
+
+func (e *configError) Is(target error) bool {
+	return target == ErrInvalidConfig
+}
@@ -0,0 +1,54 @@
+package sandbox
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"os/exec"
+	"slices"
+	"time"
+)
+
+// envLimits is set when treefmt is started to run a process with resource limits, holding the limits to apply.
+const envLimits = "TREEFMT_LIMITS"
+
+// ErrLimitsUnavailable is returned when the resources available to a process cannot be limited.
+var ErrLimitsUnavailable = errors.New("resource limits are only supported on linux")
+
+// Limits restrict the resources available to a process, and to any processes it starts.
+type Limits struct {
+	// Nice is the niceness with which the process is run, 0 if it is left unchanged.
+	Nice int
+	// CPU is the cpu time the process may consume before it is killed, 0 if there is no limit.
+	CPU time.Duration
+	// Memory is the number of bytes to which the data of the process may grow, 0 if there is no limit.
+	Memory int64
+}
+
+// Limit modifies cmd so that it runs with the given limits. Rather than being applied once cmd has started, by which
+// time it may have started other processes, they are applied by a copy of treefmt which then replaces itself with the
+// process.
+func Limit(cmd *exec.Cmd, limits Limits) error {
+	if err := LimitsAvailable(); err != nil {
+		return err
+	}
+
+	executable, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to determine the path of the treefmt executable: %w", err)
+	}
+
+	if cmd.Env == nil {
+		cmd.Env = os.Environ()
+	}
+
+	// cpu time is limited in whole seconds, so any fraction is rounded up
+	seconds := int64(math.Ceil(limits.CPU.Seconds()))
+
+	cmd.Args = slices.Concat([]string{executable, cmd.Path}, cmd.Args[1:])
+	cmd.Path = executable
+	cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%d,%d,%d", envLimits, limits.Nice, seconds, limits.Memory))
+
+	return nil
+}
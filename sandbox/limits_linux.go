@@ -0,0 +1,81 @@
+//go:build linux
+
+package sandbox
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"slices"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// LimitsAvailable returns nil, as resource limits are supported on linux.
+func LimitsAvailable() error {
+	return nil
+}
+
+// ExceededCPU reports whether err is the result of a process being killed for exceeding its cpu time limit.
+func ExceededCPU(err error) bool {
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		return false
+	}
+
+	status, ok := exitErr.Sys().(syscall.WaitStatus)
+
+	return ok && status.Signaled() && status.Signal() == syscall.SIGXCPU
+}
+
+func execLimits(value string) error {
+	if len(os.Args) < 2 {
+		return errors.New("no process to run")
+	}
+
+	// the niceness of a thread is not shared with the others in the process, so the thread which sets it must be the
+	// one which replaces the process
+	runtime.LockOSThread()
+
+	var (
+		nice    int
+		seconds uint64
+		memory  uint64
+	)
+
+	if _, err := fmt.Sscanf(value, "%d,%d,%d", &nice, &seconds, &memory); err != nil {
+		return fmt.Errorf("invalid %s: %w", envLimits, err)
+	}
+
+	if nice != 0 {
+		if err := unix.Setpriority(unix.PRIO_PROCESS, 0, nice); err != nil {
+			return fmt.Errorf("failed to set nice value: %w", err)
+		}
+	}
+
+	if seconds > 0 {
+		// the process is sent SIGXCPU when it reaches the soft limit, and killed a second later if it ignores it
+		if err := unix.Setrlimit(unix.RLIMIT_CPU, &unix.Rlimit{Cur: seconds, Max: seconds + 1}); err != nil {
+			return fmt.Errorf("failed to limit cpu time: %w", err)
+		}
+	}
+
+	if memory > 0 {
+		// unlike the size of the address space, the data limit excludes memory which has been reserved but not
+		// committed, which runtimes such as the JVM and V8 reserve in large amounts
+		if err := unix.Setrlimit(unix.RLIMIT_DATA, &unix.Rlimit{Cur: memory, Max: memory}); err != nil {
+			return fmt.Errorf("failed to limit memory: %w", err)
+		}
+	}
+
+	env := slices.DeleteFunc(os.Environ(), func(entry string) bool {
+		return strings.HasPrefix(entry, envLimits+"=")
+	})
+
+	// if the process is also to be sandboxed, it is a further copy of treefmt which does so
+	return syscall.Exec(os.Args[1], os.Args[1:], env) //nolint:gosec
+}
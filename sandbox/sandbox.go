@@ -0,0 +1,98 @@
+// Package sandbox runs the processes of formatters with restricted access to the filesystem, limiting the damage a
+// misbehaving or malicious formatter can do. Within a sandbox, a process can read the whole filesystem, but only write
+// to the files it is applied to and a private temporary directory.
+//
+// Processes are run with bubblewrap if it is installed, or otherwise with landlock, where the kernel supports it.
+//
+// The package also limits the niceness, cpu time and memory of processes, independently of whether they are sandboxed.
+package sandbox
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+// ErrUnavailable is returned when neither bubblewrap nor landlock are available.
+var ErrUnavailable = errors.New(
+	"sandboxing requires bubblewrap to be installed, or a linux kernel with landlock enabled",
+)
+
+// Apply modifies cmd so that it runs within a sandbox, in which it can only write to the files in writable and to a
+// private temporary directory, referred to by $TMPDIR. The returned function must be called once cmd has exited, to
+// remove the temporary directory.
+func Apply(cmd *exec.Cmd, writable []string) (func(), error) {
+	if err := Available(); err != nil {
+		return nil, err
+	}
+
+	tempDir, err := os.MkdirTemp("", "treefmt-sandbox-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temporary directory: %w", err)
+	}
+
+	paths := make([]string, len(writable), len(writable)+1)
+	for i, path := range writable {
+		if paths[i], err = filepath.Abs(path); err != nil {
+			_ = os.RemoveAll(tempDir)
+
+			return nil, fmt.Errorf("failed to resolve %s: %w", path, err)
+		}
+	}
+
+	paths = append(paths, tempDir)
+
+	// the paths are sent on a pipe rather than the command line, as they could otherwise exceed the platform's limit
+	// on its size
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		_ = os.RemoveAll(tempDir)
+
+		return nil, fmt.Errorf("failed to create pipe: %w", err)
+	}
+
+	// the child's descriptors for ExtraFiles begin after those for stdin, stdout and stderr
+	fd := 3 + len(cmd.ExtraFiles)
+	cmd.ExtraFiles = append(cmd.ExtraFiles, reader)
+
+	if cmd.Env == nil {
+		cmd.Env = os.Environ()
+	}
+
+	cmd.Env = append(cmd.Env, "TMPDIR="+tempDir)
+
+	payload, err := wrap(cmd, fd, paths)
+	if err != nil {
+		_ = reader.Close()
+		_ = writer.Close()
+		_ = os.RemoveAll(tempDir)
+
+		return nil, err
+	}
+
+	// if the process exits without reading the paths, closing the reader below causes the write to fail
+	go func() {
+		_, _ = writer.Write(payload)
+		_ = writer.Close()
+	}()
+
+	return func() {
+		_ = reader.Close()
+		_ = os.RemoveAll(tempDir)
+	}, nil
+}
+
+// nulSeparated joins values, terminating each with a NUL byte, as they may contain any other character.
+func nulSeparated(values []string) []byte {
+	var b bytes.Buffer
+
+	for _, value := range values {
+		b.WriteString(value)
+		b.WriteByte(0)
+	}
+
+	return b.Bytes()
+}
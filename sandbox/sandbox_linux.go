@@ -0,0 +1,239 @@
+//go:build linux
+
+package sandbox
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// envFD is set when treefmt is started to run a process within a landlock sandbox, referring to the descriptor on
+// which the writable paths are received.
+const envFD = "TREEFMT_SANDBOX_FD"
+
+const (
+	// accessRead is granted beneath the root of the filesystem.
+	accessRead = unix.LANDLOCK_ACCESS_FS_EXECUTE | unix.LANDLOCK_ACCESS_FS_READ_FILE | unix.LANDLOCK_ACCESS_FS_READ_DIR
+	// accessFile is granted for each writable file. Files cannot be created or removed, which requires access to the
+	// directory containing them.
+	accessFile = unix.LANDLOCK_ACCESS_FS_READ_FILE | unix.LANDLOCK_ACCESS_FS_WRITE_FILE | unix.LANDLOCK_ACCESS_FS_TRUNCATE
+)
+
+// accessRights are the filesystem rights introduced with each version of the landlock ABI. Every right the kernel
+// supports is handled, so that it is denied unless granted.
+var accessRights = []uint64{
+	unix.LANDLOCK_ACCESS_FS_EXECUTE | unix.LANDLOCK_ACCESS_FS_WRITE_FILE | unix.LANDLOCK_ACCESS_FS_READ_FILE |
+		unix.LANDLOCK_ACCESS_FS_READ_DIR | unix.LANDLOCK_ACCESS_FS_REMOVE_DIR | unix.LANDLOCK_ACCESS_FS_REMOVE_FILE |
+		unix.LANDLOCK_ACCESS_FS_MAKE_CHAR | unix.LANDLOCK_ACCESS_FS_MAKE_DIR | unix.LANDLOCK_ACCESS_FS_MAKE_REG |
+		unix.LANDLOCK_ACCESS_FS_MAKE_SOCK | unix.LANDLOCK_ACCESS_FS_MAKE_FIFO | unix.LANDLOCK_ACCESS_FS_MAKE_BLOCK |
+		unix.LANDLOCK_ACCESS_FS_MAKE_SYM,
+	unix.LANDLOCK_ACCESS_FS_REFER,
+	unix.LANDLOCK_ACCESS_FS_TRUNCATE,
+}
+
+var (
+	// bwrapPath and landlockABI are populated by detect
+	bwrapPath   string
+	landlockABI int
+	detectOnce  sync.Once
+)
+
+// detect looks for bubblewrap, and the version of the landlock ABI supported by the kernel, 0 if it is unsupported.
+func detect() {
+	detectOnce.Do(func() {
+		bwrapPath, _ = exec.LookPath("bwrap")
+
+		abi, _, errno := unix.Syscall(
+			unix.SYS_LANDLOCK_CREATE_RULESET, 0, 0, unix.LANDLOCK_CREATE_RULESET_VERSION,
+		)
+		if errno == 0 {
+			landlockABI = int(abi)
+		}
+	})
+}
+
+// Available returns ErrUnavailable if processes cannot be sandboxed.
+func Available() error {
+	detect()
+
+	if bwrapPath == "" && landlockABI == 0 {
+		return ErrUnavailable
+	}
+
+	return nil
+}
+
+// wrap modifies cmd to run within bubblewrap, or otherwise within a copy of treefmt which restricts itself with
+// landlock before replacing itself with the process, returning the payload to be sent on fd.
+func wrap(cmd *exec.Cmd, fd int, writable []string) ([]byte, error) {
+	if bwrapPath != "" {
+		args := []string{
+			"bwrap", "--die-with-parent", "--new-session", "--unshare-all",
+			"--ro-bind", "/", "/", "--dev", "/dev", "--proc", "/proc",
+			// the writable paths are bound on top of the read-only root
+			"--args", strconv.Itoa(fd),
+			"--", cmd.Path,
+		}
+
+		binds := make([]string, 0, 3*len(writable))
+		for _, path := range writable {
+			binds = append(binds, "--bind", path, path)
+		}
+
+		cmd.Args = slices.Concat(args, cmd.Args[1:])
+		cmd.Path = bwrapPath
+
+		return nulSeparated(binds), nil
+	}
+
+	executable, err := os.Executable()
+	if err != nil {
+		return nil, fmt.Errorf("failed to determine the path of the treefmt executable: %w", err)
+	}
+
+	cmd.Args = slices.Concat([]string{executable, cmd.Path}, cmd.Args[1:])
+	cmd.Path = executable
+	cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%d", envFD, fd))
+
+	return nulSeparated(writable), nil
+}
+
+// Exec must be called at the start of main. If treefmt was started to run a process with resource limits, or within
+// a landlock sandbox, it restricts itself before replacing itself with the process, and never returns.
+func Exec() {
+	if value, ok := os.LookupEnv(envLimits); ok {
+		if err := execLimits(value); err != nil {
+			fmt.Fprintf(os.Stderr, "treefmt: failed to limit %s: %v\n", strings.Join(os.Args[1:], " "), err)
+			os.Exit(126)
+		}
+	}
+
+	value, ok := os.LookupEnv(envFD)
+	if !ok {
+		return
+	}
+
+	if err := execLandlock(value); err != nil {
+		fmt.Fprintf(os.Stderr, "treefmt: failed to sandbox %s: %v\n", strings.Join(os.Args[1:], " "), err)
+		os.Exit(126)
+	}
+}
+
+func execLandlock(fdValue string) error {
+	if len(os.Args) < 2 {
+		return errors.New("no process to run")
+	}
+
+	// landlock restricts the calling thread, which must therefore be the one which replaces the process
+	runtime.LockOSThread()
+
+	fd, err := strconv.Atoi(fdValue)
+	if err != nil {
+		return fmt.Errorf("invalid %s: %w", envFD, err)
+	}
+
+	file := os.NewFile(uintptr(fd), "sandbox")
+
+	payload, err := io.ReadAll(file)
+	if err != nil {
+		return fmt.Errorf("failed to read writable paths: %w", err)
+	}
+
+	_ = file.Close()
+
+	detect()
+
+	if landlockABI == 0 {
+		return errors.New("landlock is not supported by the kernel")
+	}
+
+	var handled uint64
+	for _, rights := range accessRights[:min(landlockABI, len(accessRights))] {
+		handled |= rights
+	}
+
+	attr := unix.LandlockRulesetAttr{Access_fs: handled}
+
+	ruleset, _, errno := unix.Syscall(
+		unix.SYS_LANDLOCK_CREATE_RULESET, uintptr(unsafe.Pointer(&attr)), unsafe.Sizeof(attr), 0,
+	)
+	if errno != 0 {
+		return fmt.Errorf("failed to create landlock ruleset: %w", errno)
+	}
+
+	if err = allow(int(ruleset), "/", accessRead); err != nil {
+		return err
+	}
+
+	// output which is discarded is commonly written to /dev/null
+	paths := []string{"/dev/null"}
+	if len(payload) > 0 {
+		paths = append(paths, strings.Split(strings.TrimSuffix(string(payload), "\x00"), "\x00")...)
+	}
+
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return fmt.Errorf("failed to stat %s: %w", path, err)
+		}
+
+		access := uint64(accessFile)
+		if info.IsDir() {
+			access = handled
+		}
+
+		if err = allow(int(ruleset), path, access&handled); err != nil {
+			return err
+		}
+	}
+
+	if err = unix.Prctl(unix.PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0); err != nil {
+		return fmt.Errorf("failed to set no_new_privs: %w", err)
+	}
+
+	if _, _, errno = unix.Syscall(unix.SYS_LANDLOCK_RESTRICT_SELF, ruleset, 0, 0); errno != 0 {
+		return fmt.Errorf("failed to enforce landlock ruleset: %w", errno)
+	}
+
+	_ = unix.Close(int(ruleset))
+
+	env := slices.DeleteFunc(os.Environ(), func(entry string) bool {
+		return strings.HasPrefix(entry, envFD+"=")
+	})
+
+	return syscall.Exec(os.Args[1], os.Args[1:], env) //nolint:gosec
+}
+
+// allow grants access beneath path within the ruleset.
+func allow(ruleset int, path string, access uint64) error {
+	fd, err := unix.Open(path, unix.O_PATH|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", path, err)
+	}
+
+	defer unix.Close(fd)
+
+	attr := unix.LandlockPathBeneathAttr{Allowed_access: access, Parent_fd: int32(fd)} //nolint:gosec
+
+	_, _, errno := unix.Syscall6(
+		unix.SYS_LANDLOCK_ADD_RULE, uintptr(ruleset), unix.LANDLOCK_RULE_PATH_BENEATH,
+		uintptr(unsafe.Pointer(&attr)), 0, 0, 0,
+	)
+	if errno != 0 {
+		return fmt.Errorf("failed to allow access to %s: %w", path, errno)
+	}
+
+	return nil
+}
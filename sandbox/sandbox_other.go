@@ -0,0 +1,27 @@
+//go:build !linux
+
+package sandbox
+
+import "os/exec"
+
+// Available returns ErrUnavailable, as processes can only be sandboxed on linux.
+func Available() error {
+	return ErrUnavailable
+}
+
+func wrap(_ *exec.Cmd, _ int, _ []string) ([]byte, error) {
+	return nil, ErrUnavailable
+}
+
+// LimitsAvailable returns ErrLimitsUnavailable, as resource limits are only supported on linux.
+func LimitsAvailable() error {
+	return ErrLimitsUnavailable
+}
+
+// ExceededCPU always returns false, as cpu time cannot be limited on this platform.
+func ExceededCPU(_ error) bool {
+	return false
+}
+
+// Exec is a no-op on platforms without landlock or resource limits.
+func Exec() {}
@@ -1,9 +1,14 @@
 package stats
 
 import (
+	"cmp"
 	"fmt"
+	"os"
+	"slices"
 	"strings"
+	"sync"
 	"sync/atomic"
+	"text/tabwriter"
 	"time"
 )
 
@@ -15,11 +20,76 @@ const (
 	Matched
 	Formatted
 	Changed
+	Skipped
+	Errored
+	Cached
 )
 
+// Change records a file which was modified during formatting, along with the sequence of formatters that were applied
+// to it.
+type Change struct {
+	Path       string   `json:"path"`
+	Formatters []string `json:"formatters"`
+	// Diff is a unified diff of the change, only recorded when requested.
+	Diff string `json:"diff,omitempty"`
+}
+
+// Plan records a file which would have been formatted during a dry run, along with the sequence of formatters that
+// would have been applied to it.
+type Plan struct {
+	Path       string   `json:"path"`
+	Formatters []string `json:"formatters"`
+}
+
+// Failure records a formatter which failed to process a set of files, along with any output it produced.
+type Failure struct {
+	Formatter string   `json:"formatter"`
+	Paths     []string `json:"paths"`
+	Error     string   `json:"error"`
+	Output    string   `json:"output,omitempty"`
+}
+
+// Conflict records a file which was modified by more than one of the formatters applied to it.
+// Oscillating indicates the formatters went on to undo each other's changes.
+type Conflict struct {
+	Path        string   `json:"path"`
+	Formatters  []string `json:"formatters"`
+	Oscillating bool     `json:"oscillating"`
+}
+
+// Formatter records how much work a given formatter performed.
+type Formatter struct {
+	Files    int           `json:"files"`
+	Batches  int           `json:"batches"`
+	Duration time.Duration `json:"duration"`
+}
+
+// Summary is a point-in-time snapshot of Stats, suitable for machine-readable output.
+type Summary struct {
+	Counters   map[Type]int         `json:"counters"`
+	Elapsed    time.Duration        `json:"elapsed"`
+	Formatters map[string]Formatter `json:"formatters"`
+	Changes    []Change             `json:"changes"`
+	Planned    []Plan               `json:"planned"`
+	Failures   []Failure            `json:"failures"`
+	Conflicts  []Conflict           `json:"conflicts"`
+	Unmatched  []string             `json:"unmatched"`
+}
+
 type Stats struct {
 	start    time.Time
 	counters map[Type]*atomic.Int64
+
+	// lock guards changes, planned, failures, conflicts, unmatched, formatters, running and walk
+	lock       *sync.Mutex
+	changes    []Change
+	planned    []Plan
+	failures   []Failure
+	conflicts  []Conflict
+	unmatched  []string
+	formatters map[string]*Formatter
+	running    map[string]int
+	walk       time.Duration
 }
 
 func (s *Stats) Add(t Type, delta int) int {
@@ -34,6 +104,223 @@ func (s *Stats) Elapsed() time.Duration {
 	return time.Since(s.start)
 }
 
+// RecordChange records that the file at path was changed by the given sequence of formatters, along with an optional
+// unified diff of the change.
+func (s *Stats) RecordChange(path string, formatters []string, diff string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.changes = append(s.changes, Change{
+		Path:       path,
+		Formatters: formatters,
+		Diff:       diff,
+	})
+}
+
+// Changes returns the files which were changed during formatting, sorted by path.
+func (s *Stats) Changes() []Change {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	changes := slices.Clone(s.changes)
+	slices.SortFunc(changes, func(a, b Change) int {
+		return strings.Compare(a.Path, b.Path)
+	})
+
+	return changes
+}
+
+// RecordPlan records that the file at path would have been formatted by the given sequence of formatters, had this
+// not been a dry run.
+func (s *Stats) RecordPlan(path string, formatters []string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.planned = append(s.planned, Plan{
+		Path:       path,
+		Formatters: formatters,
+	})
+}
+
+// Planned returns the files which would have been formatted during a dry run, sorted by path.
+func (s *Stats) Planned() []Plan {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	planned := slices.Clone(s.planned)
+	slices.SortFunc(planned, func(a, b Plan) int {
+		return strings.Compare(a.Path, b.Path)
+	})
+
+	return planned
+}
+
+// RecordFailure records that a formatter failed to process some files.
+func (s *Stats) RecordFailure(failure Failure) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.failures = append(s.failures, failure)
+}
+
+// Failures returns the failures which occurred during formatting, sorted by formatter and then by path.
+func (s *Stats) Failures() []Failure {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	failures := slices.Clone(s.failures)
+	slices.SortFunc(failures, func(a, b Failure) int {
+		if result := strings.Compare(a.Formatter, b.Formatter); result != 0 {
+			return result
+		}
+
+		return slices.Compare(a.Paths, b.Paths)
+	})
+
+	return failures
+}
+
+// RecordConflict records that a file was modified by more than one formatter.
+func (s *Stats) RecordConflict(conflict Conflict) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.conflicts = append(s.conflicts, conflict)
+}
+
+// Conflicts returns the conflicts detected during formatting, sorted by path.
+func (s *Stats) Conflicts() []Conflict {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	conflicts := slices.Clone(s.conflicts)
+	slices.SortFunc(conflicts, func(a, b Conflict) int {
+		return strings.Compare(a.Path, b.Path)
+	})
+
+	return conflicts
+}
+
+// RecordUnmatched records that no formatter wanted the file at path.
+func (s *Stats) RecordUnmatched(path string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.unmatched = append(s.unmatched, path)
+}
+
+// Unmatched returns the paths which no formatter wanted, sorted.
+func (s *Stats) Unmatched() []string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	unmatched := slices.Clone(s.unmatched)
+	slices.Sort(unmatched)
+
+	return unmatched
+}
+
+// StartFormatter records that the named formatter has started processing a batch of files.
+// It is considered running until the batch is recorded with RecordFormatter.
+func (s *Stats) StartFormatter(name string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.running[name]++
+}
+
+// Running returns the names of the formatters which are currently processing files, sorted by name.
+func (s *Stats) Running() []string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	names := make([]string, 0, len(s.running))
+	for name := range s.running {
+		names = append(names, name)
+	}
+
+	slices.Sort(names)
+
+	return names
+}
+
+// RecordFormatter records that the named formatter processed a batch containing a number of files in the given
+// duration.
+func (s *Stats) RecordFormatter(name string, files int, duration time.Duration) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.running[name] > 1 {
+		s.running[name]--
+	} else {
+		delete(s.running, name)
+	}
+
+	f, ok := s.formatters[name]
+	if !ok {
+		f = &Formatter{}
+		s.formatters[name] = f
+	}
+
+	f.Files += files
+	f.Batches++
+	f.Duration += duration
+}
+
+// Formatters returns a snapshot of the work performed by each formatter so far, keyed by name.
+func (s *Stats) Formatters() map[string]Formatter {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	formatters := make(map[string]Formatter, len(s.formatters))
+	for name, f := range s.formatters {
+		formatters[name] = *f
+	}
+
+	return formatters
+}
+
+// RecordWalk records time spent waiting for the tree to be traversed.
+func (s *Stats) RecordWalk(duration time.Duration) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.walk += duration
+}
+
+// WalkDuration returns the total time spent waiting for the tree to be traversed, excluding any time spent formatting.
+func (s *Stats) WalkDuration() time.Duration {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.walk
+}
+
+// Summary returns a snapshot of the current counters, per-formatter statistics and changed files.
+func (s *Stats) Summary() Summary {
+	counters := make(map[Type]int, len(s.counters))
+	for t := range s.counters {
+		counters[t] = s.Value(t)
+	}
+
+	changes := s.Changes()
+	planned := s.Planned()
+	failures := s.Failures()
+	conflicts := s.Conflicts()
+	unmatched := s.Unmatched()
+
+	return Summary{
+		Counters:   counters,
+		Elapsed:    s.Elapsed(),
+		Formatters: s.Formatters(),
+		Changes:    changes,
+		Planned:    planned,
+		Failures:   failures,
+		Conflicts:  conflicts,
+		Unmatched:  unmatched,
+	}
+}
+
 func (s *Stats) Print() {
 	components := []string{
 		"traversed %d files",
@@ -50,6 +337,52 @@ func (s *Stats) Print() {
 		s.Value(Changed),
 		s.Elapsed().Round(time.Millisecond),
 	)
+
+	if skipped := s.Value(Skipped); skipped > 0 {
+		fmt.Printf("skipped %d files\n", skipped)
+	}
+
+	if errored := s.Value(Errored); errored > 0 {
+		fmt.Printf("failed to format %d files\n", errored)
+	}
+}
+
+// PrintFormatters writes a table of the work performed by each formatter, slowest first.
+func (s *Stats) PrintFormatters() {
+	s.lock.Lock()
+
+	names := make([]string, 0, len(s.formatters))
+	formatters := make(map[string]Formatter, len(s.formatters))
+
+	for name, f := range s.formatters {
+		names = append(names, name)
+		formatters[name] = *f
+	}
+
+	s.lock.Unlock()
+
+	if len(names) == 0 {
+		return
+	}
+
+	slices.SortFunc(names, func(a, b string) int {
+		if result := cmp.Compare(formatters[b].Duration, formatters[a].Duration); result != 0 {
+			return result
+		}
+
+		return strings.Compare(a, b)
+	})
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintln(w, "formatter\tfiles\tbatches\tduration")
+
+	for _, name := range names {
+		f := formatters[name]
+		fmt.Fprintf(w, "%s\t%d\t%d\t%v\n", name, f.Files, f.Batches, f.Duration.Round(time.Millisecond))
+	}
+
+	_ = w.Flush()
 }
 
 func New() Stats {
@@ -58,9 +391,21 @@ func New() Stats {
 	counters[Matched] = &atomic.Int64{}
 	counters[Formatted] = &atomic.Int64{}
 	counters[Changed] = &atomic.Int64{}
+	counters[Skipped] = &atomic.Int64{}
+	counters[Errored] = &atomic.Int64{}
+	counters[Cached] = &atomic.Int64{}
 
 	return Stats{
 		start:    time.Now(),
 		counters: counters,
+
+		lock:       &sync.Mutex{},
+		changes:    []Change{},
+		planned:    []Plan{},
+		failures:   []Failure{},
+		conflicts:  []Conflict{},
+		unmatched:  []string{},
+		formatters: make(map[string]*Formatter),
+		running:    make(map[string]int),
 	}
 }
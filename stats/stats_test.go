@@ -0,0 +1,36 @@
+package stats_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/numtide/treefmt/v2/stats"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunning(t *testing.T) {
+	as := require.New(t)
+
+	statz := stats.New()
+	as.Empty(statz.Running())
+
+	statz.StartFormatter("shfmt")
+	statz.StartFormatter("alejandra")
+	statz.StartFormatter("shfmt")
+	as.Equal([]string{"alejandra", "shfmt"}, statz.Running())
+
+	// a formatter is running for as long as any of its batches are
+	statz.RecordFormatter("shfmt", 2, time.Second)
+	as.Equal([]string{"alejandra", "shfmt"}, statz.Running())
+
+	statz.RecordFormatter("shfmt", 3, time.Second)
+	as.Equal([]string{"alejandra"}, statz.Running())
+
+	statz.RecordFormatter("alejandra", 1, time.Second)
+	as.Empty(statz.Running())
+
+	as.Equal(map[string]stats.Formatter{
+		"alejandra": {Files: 1, Batches: 1, Duration: time.Second},
+		"shfmt":     {Files: 5, Batches: 2, Duration: 2 * time.Second},
+	}, statz.Formatters())
+}
@@ -7,11 +7,11 @@ import (
 	"strings"
 )
 
-const _TypeName = "traversedmatchedformattedchanged"
+const _TypeName = "traversedmatchedformattedchangedskippederroredcached"
 
-var _TypeIndex = [...]uint8{0, 9, 16, 25, 32}
+var _TypeIndex = [...]uint8{0, 9, 16, 25, 32, 39, 46, 52}
 
-const _TypeLowerName = "traversedmatchedformattedchanged"
+const _TypeLowerName = "traversedmatchedformattedchangedskippederroredcached"
 
 func (i Type) String() string {
 	if i < 0 || i >= Type(len(_TypeIndex)-1) {
@@ -28,9 +28,12 @@ func _TypeNoOp() {
 	_ = x[Matched-(1)]
 	_ = x[Formatted-(2)]
 	_ = x[Changed-(3)]
+	_ = x[Skipped-(4)]
+	_ = x[Errored-(5)]
+	_ = x[Cached-(6)]
 }
 
-var _TypeValues = []Type{Traversed, Matched, Formatted, Changed}
+var _TypeValues = []Type{Traversed, Matched, Formatted, Changed, Skipped, Errored, Cached}
 
 var _TypeNameToValueMap = map[string]Type{
 	_TypeName[0:9]:        Traversed,
@@ -41,6 +44,12 @@ var _TypeNameToValueMap = map[string]Type{
 	_TypeLowerName[16:25]: Formatted,
 	_TypeName[25:32]:      Changed,
 	_TypeLowerName[25:32]: Changed,
+	_TypeName[32:39]:      Skipped,
+	_TypeLowerName[32:39]: Skipped,
+	_TypeName[39:46]:      Errored,
+	_TypeLowerName[39:46]: Errored,
+	_TypeName[46:52]:      Cached,
+	_TypeLowerName[46:52]: Cached,
 }
 
 var _TypeNames = []string{
@@ -48,6 +57,9 @@ var _TypeNames = []string{
 	_TypeName[9:16],
 	_TypeName[16:25],
 	_TypeName[25:32],
+	_TypeName[32:39],
+	_TypeName[39:46],
+	_TypeName[46:52],
 }
 
 // TypeString retrieves an enum value from the enum constants string name.
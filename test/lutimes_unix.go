@@ -0,0 +1,28 @@
+//go:build unix
+
+package test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// Lutimes is a convenience wrapper for using unix.Lutimes.
+func Lutimes(t *testing.T, path string, atime time.Time, mtime time.Time) error {
+	t.Helper()
+
+	var utimes [2]unix.Timeval
+	utimes[0] = unix.NsecToTimeval(atime.UnixNano())
+	utimes[1] = unix.NsecToTimeval(mtime.UnixNano())
+
+	// Change the timestamps of the path. If it's a symlink, it updates the symlink's timestamps, not the target's.
+	err := unix.Lutimes(path, utimes[0:])
+	if err != nil {
+		return fmt.Errorf("failed to change times: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,22 @@
+//go:build windows
+
+package test
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+)
+
+// Lutimes changes the timestamps of path. Windows has no equivalent of unix.Lutimes, so if path is a symlink, the
+// timestamps of its target are changed instead.
+func Lutimes(t *testing.T, path string, atime time.Time, mtime time.Time) error {
+	t.Helper()
+
+	if err := os.Chtimes(path, atime, mtime); err != nil {
+		return fmt.Errorf("failed to change times: %w", err)
+	}
+
+	return nil
+}
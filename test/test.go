@@ -11,7 +11,6 @@ import (
 	"github.com/numtide/treefmt/v2/config"
 	cp "github.com/otiai10/copy"
 	"github.com/stretchr/testify/require"
-	"golang.org/x/sys/unix"
 )
 
 func WriteConfig(t *testing.T, path string, cfg *config.Config) {
@@ -63,24 +62,6 @@ func TempFile(t *testing.T, dir string, pattern string, contents *string) *os.Fi
 	return file
 }
 
-// Lutimes is a convenience wrapper for using unix.Lutimes
-// TODO: this will need to be adapted if we support Windows.
-func Lutimes(t *testing.T, path string, atime time.Time, mtime time.Time) error {
-	t.Helper()
-
-	var utimes [2]unix.Timeval
-	utimes[0] = unix.NsecToTimeval(atime.UnixNano())
-	utimes[1] = unix.NsecToTimeval(mtime.UnixNano())
-
-	// Change the timestamps of the path. If it's a symlink, it updates the symlink's timestamps, not the target's.
-	err := unix.Lutimes(path, utimes[0:])
-	if err != nil {
-		return fmt.Errorf("failed to change times: %w", err)
-	}
-
-	return nil
-}
-
 func LutimesBump(t *testing.T, path string, atime time.Duration, mtime time.Duration) {
 	t.Helper()
 
@@ -0,0 +1,260 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/numtide/treefmt/v2/build"
+)
+
+// exportTimeout bounds how long we wait for the collector to accept the spans of a run.
+const exportTimeout = 10 * time.Second
+
+type contextKey struct{}
+
+// Attribute is a key-value pair which describes a span.
+type Attribute struct {
+	Key   string
+	Value any
+}
+
+// String creates an attribute with a string value.
+func String(key string, value string) Attribute {
+	return Attribute{Key: key, Value: value}
+}
+
+// Int creates an attribute with an integer value.
+func Int(key string, value int) Attribute {
+	return Attribute{Key: key, Value: value}
+}
+
+// Tracer records spans, which are sent to an OpenTelemetry collector with Export.
+type Tracer struct {
+	endpoint string
+
+	lock  sync.Mutex
+	spans []*Span
+}
+
+// New creates a Tracer which exports spans to the collector at endpoint e.g. http://localhost:4318, using OTLP over
+// HTTP with JSON encoding. If endpoint has no path, the default path of /v1/traces is used.
+func New(endpoint string) (*Tracer, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse otel endpoint: %w", err)
+	} else if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("otel endpoint %s must be an http or https url", endpoint)
+	}
+
+	if u.Path == "" || u.Path == "/" {
+		u.Path = "/v1/traces"
+	}
+
+	return &Tracer{
+		endpoint: u.String(),
+	}, nil
+}
+
+// WithTracer returns a copy of ctx which carries t, causing Start to record spans with it.
+// The first span started with the returned context begins a new trace.
+func WithTracer(ctx context.Context, t *Tracer) context.Context {
+	return context.WithValue(ctx, contextKey{}, &Span{tracer: t})
+}
+
+// Span is an operation within a run.
+// A nil Span is valid, and is returned by Start when tracing is not enabled.
+type Span struct {
+	tracer *Tracer
+
+	name       string
+	traceID    string
+	id         string
+	parentID   string
+	start      time.Time
+	end        time.Time
+	attributes []Attribute
+	err        error
+}
+
+// Start starts a span with the given name, as a child of the span carried by ctx.
+// It returns a context carrying the new span, which should be ended with End.
+// If ctx carries no tracer, the span is not recorded and nil is returned.
+func Start(ctx context.Context, name string, attributes ...Attribute) (context.Context, *Span) {
+	parent, ok := ctx.Value(contextKey{}).(*Span)
+	if !ok {
+		return ctx, nil
+	}
+
+	traceID := parent.traceID
+	if traceID == "" {
+		traceID = randomID(16)
+	}
+
+	span := &Span{
+		tracer:     parent.tracer,
+		name:       name,
+		traceID:    traceID,
+		id:         randomID(8),
+		parentID:   parent.id,
+		start:      time.Now(),
+		attributes: attributes,
+	}
+
+	return context.WithValue(ctx, contextKey{}, span), span
+}
+
+// SetAttributes adds attributes to the span.
+func (s *Span) SetAttributes(attributes ...Attribute) {
+	if s == nil {
+		return
+	}
+
+	s.attributes = append(s.attributes, attributes...)
+}
+
+// SetError marks the span as having failed with err, if it is not nil.
+func (s *Span) SetError(err error) {
+	if s == nil {
+		return
+	}
+
+	s.err = err
+}
+
+// End completes the span, recording it with its tracer.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+
+	s.end = time.Now()
+
+	s.tracer.lock.Lock()
+	defer s.tracer.lock.Unlock()
+
+	s.tracer.spans = append(s.tracer.spans, s)
+}
+
+// Export sends the spans which have ended to the collector.
+func (t *Tracer) Export(ctx context.Context) error {
+	t.lock.Lock()
+	spans := t.spans
+	t.spans = nil
+	t.lock.Unlock()
+
+	if len(spans) == 0 {
+		return nil
+	}
+
+	body, err := json.Marshal(request(spans))
+	if err != nil {
+		return fmt.Errorf("failed to encode spans: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, exportTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create export request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to export spans to %s: %w", t.endpoint, err)
+	}
+
+	_ = res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return fmt.Errorf("failed to export spans to %s: %s", t.endpoint, res.Status)
+	}
+
+	return nil
+}
+
+// request builds an OTLP export request for spans.
+// See https://opentelemetry.io/docs/specs/otlp/#json-protobuf-encoding
+func request(spans []*Span) map[string]any {
+	encoded := make([]map[string]any, len(spans))
+
+	for i, span := range spans {
+		entry := map[string]any{
+			"traceId":           span.traceID,
+			"spanId":            span.id,
+			"name":              span.name,
+			"kind":              1, // internal
+			"startTimeUnixNano": strconv.FormatInt(span.start.UnixNano(), 10),
+			"endTimeUnixNano":   strconv.FormatInt(span.end.UnixNano(), 10),
+			"attributes":        encodeAttributes(span.attributes),
+		}
+
+		if span.parentID != "" {
+			entry["parentSpanId"] = span.parentID
+		}
+
+		if span.err != nil {
+			entry["status"] = map[string]any{"code": 2, "message": span.err.Error()} // error
+		}
+
+		encoded[i] = entry
+	}
+
+	return map[string]any{
+		"resourceSpans": []any{
+			map[string]any{
+				"resource": map[string]any{
+					"attributes": encodeAttributes([]Attribute{
+						String("service.name", build.Name),
+						String("service.version", build.Version),
+					}),
+				},
+				"scopeSpans": []any{
+					map[string]any{
+						"scope": map[string]any{"name": build.Name, "version": build.Version},
+						"spans": encoded,
+					},
+				},
+			},
+		},
+	}
+}
+
+// encodeAttributes encodes attributes as OTLP key-values.
+func encodeAttributes(attributes []Attribute) []any {
+	result := make([]any, len(attributes))
+
+	for i, attr := range attributes {
+		var value map[string]any
+
+		switch v := attr.Value.(type) {
+		case int:
+			value = map[string]any{"intValue": strconv.Itoa(v)}
+		default:
+			value = map[string]any{"stringValue": fmt.Sprint(v)}
+		}
+
+		result[i] = map[string]any{"key": attr.Key, "value": value}
+	}
+
+	return result
+}
+
+// randomID returns a random, hex encoded identifier of size bytes.
+func randomID(size int) string {
+	id := make([]byte, size)
+	_, _ = rand.Read(id)
+
+	return hex.EncodeToString(id)
+}
@@ -0,0 +1,140 @@
+package tracing_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/numtide/treefmt/v2/tracing"
+	"github.com/stretchr/testify/require"
+)
+
+type exportedSpan struct {
+	TraceID      string `json:"traceId"`
+	SpanID       string `json:"spanId"`
+	ParentSpanID string `json:"parentSpanId"`
+	Name         string `json:"name"`
+	Attributes   []struct {
+		Key   string            `json:"key"`
+		Value map[string]string `json:"value"`
+	} `json:"attributes"`
+	Status *struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"status"`
+}
+
+type exportRequest struct {
+	ResourceSpans []struct {
+		ScopeSpans []struct {
+			Spans []exportedSpan `json:"spans"`
+		} `json:"scopeSpans"`
+	} `json:"resourceSpans"`
+}
+
+func TestTracer(t *testing.T) {
+	as := require.New(t)
+
+	var requests []exportRequest
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		as.Equal("/v1/traces", r.URL.Path)
+		as.Equal("application/json", r.Header.Get("Content-Type"))
+
+		body, err := io.ReadAll(r.Body)
+		as.NoError(err)
+
+		var req exportRequest
+		as.NoError(json.Unmarshal(body, &req))
+
+		requests = append(requests, req)
+	}))
+	defer server.Close()
+
+	tracer, err := tracing.New(server.URL)
+	as.NoError(err)
+
+	// spans are only recorded when the context carries a tracer
+	_, span := tracing.Start(context.Background(), "untraced")
+	as.Nil(span)
+	span.SetAttributes(tracing.Int("files", 1))
+	span.SetError(errors.New("ignored"))
+	span.End()
+
+	ctx, root := tracing.Start(tracing.WithTracer(context.Background(), tracer), "format")
+	_, child := tracing.Start(ctx, "formatter", tracing.String("formatter", "gofmt"), tracing.Int("files", 3))
+	child.SetError(errors.New("exit status 1"))
+	child.End()
+	root.End()
+
+	as.NoError(tracer.Export(context.Background()))
+	as.Len(requests, 1)
+
+	spans := requests[0].ResourceSpans[0].ScopeSpans[0].Spans
+	as.Len(spans, 2)
+
+	formatter, format := spans[0], spans[1]
+
+	as.Equal("format", format.Name)
+	as.Len(format.TraceID, 32)
+	as.Len(format.SpanID, 16)
+	as.Empty(format.ParentSpanID)
+	as.Nil(format.Status)
+
+	as.Equal("formatter", formatter.Name)
+	as.Equal(format.TraceID, formatter.TraceID)
+	as.Equal(format.SpanID, formatter.ParentSpanID)
+	as.Len(formatter.Attributes, 2)
+	as.Equal("formatter", formatter.Attributes[0].Key)
+	as.Equal(map[string]string{"stringValue": "gofmt"}, formatter.Attributes[0].Value)
+	as.Equal("files", formatter.Attributes[1].Key)
+	as.Equal(map[string]string{"intValue": "3"}, formatter.Attributes[1].Value)
+	as.Equal(2, formatter.Status.Code)
+	as.Equal("exit status 1", formatter.Status.Message)
+
+	// exported spans are not sent again, and each run has its own trace
+	_, next := tracing.Start(tracing.WithTracer(context.Background(), tracer), "format")
+	next.End()
+
+	as.NoError(tracer.Export(context.Background()))
+	as.Len(requests, 2)
+
+	spans = requests[1].ResourceSpans[0].ScopeSpans[0].Spans
+	as.Len(spans, 1)
+	as.NotEqual(format.TraceID, spans[0].TraceID)
+
+	// there is nothing to send if no spans have ended
+	as.NoError(tracer.Export(context.Background()))
+	as.Len(requests, 2)
+}
+
+func TestNew(t *testing.T) {
+	as := require.New(t)
+
+	_, err := tracing.New("localhost:4318")
+	as.ErrorContains(err, "must be an http or https url")
+
+	_, err = tracing.New("http://localhost:4318/custom/traces")
+	as.NoError(err)
+}
+
+func TestExportFailure(t *testing.T) {
+	as := require.New(t)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	tracer, err := tracing.New(server.URL + "/")
+	as.NoError(err)
+
+	_, span := tracing.Start(tracing.WithTracer(context.Background(), tracer), "format")
+	span.End()
+
+	as.ErrorContains(tracer.Export(context.Background()), "503 Service Unavailable")
+}
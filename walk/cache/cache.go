@@ -3,7 +3,12 @@ package cache
 import (
 	"crypto/sha256"
 	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
 	"time"
 
 	"github.com/adrg/xdg"
@@ -11,23 +16,57 @@ import (
 )
 
 const (
-	bucketPaths = "paths"
+	bucketPaths   = "paths"
+	bucketEntries = "entries"
 )
 
-func Open(root string) (*bolt.DB, error) {
-	var (
-		err  error
-		path string
-	)
+// Entry records how a path was formatted, in addition to the format signature stored in the paths bucket.
+type Entry struct {
+	// Formatters contains the names of the formatters which were applied, in order.
+	Formatters []string `json:"formatters"`
+	// Signature is the signature of the formatters which were applied, and their config.
+	Signature []byte `json:"signature"`
+}
+
+// Info describes the cache associated with a tree root.
+type Info struct {
+	Path    string
+	Entries int
+	Size    int64
+}
 
-	// Otherwise, the database will be located in `XDG_CACHE_DIR/treefmt/eval-cache/<name>.db`, where <name> is
-	// determined by hashing the treeRoot path.
-	// This associates a given treeRoot with a given instance of the cache.
+// Path returns the location of the cache associated with root.
+// If dir is empty, the cache is located in the user's cache directory.
+func Path(dir string, root string) (string, error) {
+	// The database will be located in `<dir>/<name>.db`, where <name> is determined by hashing the treeRoot path.
+	// This associates a given treeRoot with a given instance of the cache, ensuring multiple checkouts sharing the
+	// same directory don't collide.
 	digest := sha256.Sum256([]byte(root))
 
 	name := hex.EncodeToString(digest[:])
-	if path, err = xdg.CacheFile(fmt.Sprintf("treefmt/eval-cache/%v.db", name)); err != nil {
-		return nil, fmt.Errorf("could not resolve local path for the cache: %w", err)
+
+	// by default, the database is located in `XDG_CACHE_DIR/treefmt/eval-cache`
+	if dir == "" {
+		path, err := xdg.CacheFile(fmt.Sprintf("treefmt/eval-cache/%v.db", name))
+		if err != nil {
+			return "", fmt.Errorf("could not resolve local path for the cache: %w", err)
+		}
+
+		return path, nil
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", fmt.Errorf("failed to create cache directory %s: %w", dir, err)
+	}
+
+	return filepath.Join(dir, name+".db"), nil
+}
+
+// Open opens the cache associated with root, located within dir, or the user's cache directory if dir is empty.
+func Open(dir string, root string) (*bolt.DB, error) {
+	path, err := Path(dir, root)
+	if err != nil {
+		return nil, err
 	}
 
 	// open db
@@ -36,11 +75,15 @@ func Open(root string) (*bolt.DB, error) {
 		return nil, err
 	}
 
-	// ensure bucket exist
+	// ensure buckets exist
 	err = db.Update(func(tx *bolt.Tx) error {
-		_, err := tx.CreateBucketIfNotExists([]byte(bucketPaths))
+		for _, name := range []string{bucketPaths, bucketEntries} {
+			if _, err := tx.CreateBucketIfNotExists([]byte(name)); err != nil {
+				return err
+			}
+		}
 
-		return err
+		return nil
 	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to create bucket: %w", err)
@@ -49,8 +92,48 @@ func Open(root string) (*bolt.DB, error) {
 	return db, nil
 }
 
+// PathKey returns the key under which the format signature and Entry of relPath are recorded.
+// Keys are slash separated on every platform, so snapshots of the cache can be shared between them.
+func PathKey(relPath string) string {
+	return filepath.ToSlash(relPath)
+}
+
 func PathsBucket(tx *bolt.Tx) *bolt.Bucket {
-	return tx.Bucket([]byte("paths"))
+	return tx.Bucket([]byte(bucketPaths))
+}
+
+// EntriesBucket returns the bucket recording an Entry for each path.
+func EntriesBucket(tx *bolt.Tx) *bolt.Bucket {
+	return tx.Bucket([]byte(bucketEntries))
+}
+
+// PutEntry records how path was formatted.
+func PutEntry(bucket *bolt.Bucket, path string, entry *Entry) error {
+	value, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to marshal entry for path %s: %w", path, err)
+	}
+
+	if err = bucket.Put([]byte(path), value); err != nil {
+		return fmt.Errorf("failed to put entry for path %s: %w", path, err)
+	}
+
+	return nil
+}
+
+// GetEntry returns how path was formatted, or nil if it was not recorded.
+func GetEntry(bucket *bolt.Bucket, path string) (*Entry, error) {
+	value := bucket.Get([]byte(path))
+	if value == nil {
+		return nil, nil
+	}
+
+	var entry Entry
+	if err := json.Unmarshal(value, &entry); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal entry for path %s: %w", path, err)
+	}
+
+	return &entry, nil
 }
 
 func deleteAll(bucket *bolt.Bucket) error {
@@ -66,6 +149,120 @@ func deleteAll(bucket *bolt.Bucket) error {
 
 func Clear(db *bolt.DB) error {
 	return db.Update(func(tx *bolt.Tx) error {
-		return deleteAll(PathsBucket(tx))
+		if err := deleteAll(PathsBucket(tx)); err != nil {
+			return err
+		}
+
+		return deleteAll(EntriesBucket(tx))
+	})
+}
+
+// Stat returns information about the cache in db.
+func Stat(db *bolt.DB) (*Info, error) {
+	info := &Info{Path: db.Path()}
+
+	err := db.View(func(tx *bolt.Tx) error {
+		info.Entries = PathsBucket(tx).Stats().KeyN
+		info.Size = tx.Size()
+
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cache: %w", err)
+	}
+
+	return info, nil
+}
+
+// Check verifies the integrity of the cache in db, returning any inconsistencies which were found.
+func Check(db *bolt.DB) error {
+	var errs []error
+
+	err := db.View(func(tx *bolt.Tx) error {
+		for err := range tx.Check() {
+			errs = append(errs, err)
+		}
+
+		return nil
 	})
+	if err != nil {
+		return fmt.Errorf("failed to read cache: %w", err)
+	}
+
+	return errors.Join(errs...)
+}
+
+// Prune removes entries for paths which no longer exist within root, as well as entries for paths which were formatted
+// by a formatter that is not in the list of configured formatters.
+// It returns the number of entries which were removed.
+func Prune(db *bolt.DB, root string, formatters []string) (int, error) {
+	configured := make(map[string]bool, len(formatters))
+	for _, name := range formatters {
+		configured[name] = true
+	}
+
+	var pruned int
+
+	err := db.Update(func(tx *bolt.Tx) error {
+		paths := PathsBucket(tx)
+		entries := EntriesBucket(tx)
+
+		// collect the stale entries first, as modifying a bucket whilst iterating over it is not safe
+		var stale []string
+
+		err := paths.ForEach(func(k, _ []byte) error {
+			path := string(k)
+
+			entry, err := GetEntry(entries, path)
+			if err != nil {
+				return err
+			}
+
+			ok, err := isStale(root, path, entry, configured)
+			if ok {
+				stale = append(stale, path)
+			}
+
+			return err
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, path := range stale {
+			if err = paths.Delete([]byte(path)); err != nil {
+				return fmt.Errorf("failed to remove cache entry for key %s: %w", path, err)
+			} else if err = entries.Delete([]byte(path)); err != nil {
+				return fmt.Errorf("failed to remove entry for key %s: %w", path, err)
+			}
+		}
+
+		pruned = len(stale)
+
+		return nil
+	})
+
+	return pruned, err
+}
+
+// isStale determines whether the cache entry for path should be pruned.
+func isStale(root string, path string, entry *Entry, configured map[string]bool) (bool, error) {
+	if _, err := os.Lstat(filepath.Join(root, path)); errors.Is(err, fs.ErrNotExist) {
+		return true, nil
+	} else if err != nil {
+		return false, fmt.Errorf("failed to stat %s: %w", path, err)
+	}
+
+	// older versions don't record which formatters were applied
+	if entry == nil {
+		return false, nil
+	}
+
+	for _, name := range entry.Formatters {
+		if !configured[name] {
+			return true, nil
+		}
+	}
+
+	return false, nil
 }
@@ -0,0 +1,84 @@
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/adrg/xdg"
+)
+
+// ErrLocked is returned by Lock when another process holds the lock for a tree root.
+var ErrLocked = errors.New("another treefmt process holds the lock for this tree root")
+
+// lockPollInterval is how often we try to acquire a lock which is held by another process.
+const lockPollInterval = 100 * time.Millisecond
+
+// LockPath returns the path of the lock file for root, within the user's cache directory, e.g. ~/.cache.
+// It depends on root alone, so runs using a different cache directory, or no cache at all, share the same lock.
+func LockPath(root string) (string, error) {
+	digest := sha256.Sum256([]byte(root))
+	name := hex.EncodeToString(digest[:])
+
+	path, err := xdg.CacheFile(fmt.Sprintf("treefmt/lock/%s.lock", name))
+	if err != nil {
+		return "", fmt.Errorf("could not resolve local path for the lock: %w", err)
+	}
+
+	return path, nil
+}
+
+// Lock acquires an advisory lock for root, preventing multiple treefmt processes from formatting the same tree at
+// once. The lock file is located within the user's cache directory rather than the tree, so the tree itself is left
+// untouched.
+//
+// If the lock is held by another process, Lock waits up to wait for it to be released before returning ErrLocked.
+// The returned function releases the lock.
+func Lock(root string, wait time.Duration) (func() error, error) {
+	path, err := LockPath(root)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open lock file %s: %w", path, err)
+	}
+
+	deadline := time.Now().Add(wait)
+
+	for {
+		locked, err := tryLock(f)
+		if err != nil {
+			_ = f.Close()
+
+			return nil, fmt.Errorf("failed to lock %s: %w", path, err)
+		} else if locked {
+			break
+		}
+
+		if time.Now().After(deadline) {
+			_ = f.Close()
+
+			if wait > 0 {
+				return nil, fmt.Errorf("%w, gave up waiting after %v", ErrLocked, wait)
+			}
+
+			return nil, fmt.Errorf("%w, use --lock-wait to wait for it to finish", ErrLocked)
+		}
+
+		time.Sleep(lockPollInterval)
+	}
+
+	return func() error {
+		// closing the file releases the lock
+		if err := f.Close(); err != nil {
+			return fmt.Errorf("failed to release lock %s: %w", path, err)
+		}
+
+		return nil
+	}, nil
+}
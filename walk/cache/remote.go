@@ -0,0 +1,209 @@
+package cache
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// Backend is a cache which can be shared between machines.
+//
+// Unlike the local cache, which records the size and mod time of each path when it was formatted, entries in a Backend
+// are keyed by the hash of a file's content combined with the signature of the formatters applied to it. An entry
+// records that the content is already formatted, so there is nothing to be done for any file with the same content.
+type Backend interface {
+	// Has reports whether an entry exists for key.
+	Has(ctx context.Context, key string) (bool, error)
+	// Put records an entry for key.
+	Put(ctx context.Context, key string) error
+}
+
+// Key generates a Backend key for content with the given hash, formatted by formatters with the given signature.
+func Key(formattersSignature []byte, contentHash []byte) string {
+	h := sha256.New()
+	h.Write(formattersSignature)
+	h.Write(contentHash)
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// NewBackend creates a Backend for the given remote, which is either an http(s) URL or an s3://<bucket>/<prefix> URL.
+func NewBackend(remote string) (Backend, error) {
+	u, err := url.Parse(remote)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse remote cache url: %w", err)
+	}
+
+	client := &http.Client{Timeout: 10 * time.Second}
+
+	switch u.Scheme {
+	case "http", "https":
+		return &httpBackend{
+			client: client,
+			url:    strings.TrimSuffix(u.String(), "/"),
+			token:  os.Getenv("TREEFMT_CACHE_TOKEN"),
+		}, nil
+	case "s3":
+		return newS3Backend(client, u)
+	default:
+		return nil, fmt.Errorf("unsupported remote cache scheme '%s', expected http, https or s3", u.Scheme)
+	}
+}
+
+// httpBackend stores each entry as an empty object at <url>/<key>, using HEAD to check for its existence and PUT to
+// create it.
+type httpBackend struct {
+	client *http.Client
+	url    string
+	// token is sent as a bearer token, if set
+	token string
+	// sign is called to authorise each request, if set
+	sign func(req *http.Request)
+}
+
+func (h *httpBackend) Has(ctx context.Context, key string) (bool, error) {
+	resp, err := h.do(ctx, http.MethodHead, key)
+	if err != nil {
+		return false, err
+	}
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	default:
+		return false, fmt.Errorf("unexpected response from remote cache: %s", resp.Status)
+	}
+}
+
+func (h *httpBackend) Put(ctx context.Context, key string) error {
+	resp, err := h.do(ctx, http.MethodPut, key)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected response from remote cache: %s", resp.Status)
+	}
+
+	return nil
+}
+
+func (h *httpBackend) do(ctx context.Context, method string, key string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, h.url+"/"+key, http.NoBody)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create remote cache request: %w", err)
+	}
+
+	if h.token != "" {
+		req.Header.Set("Authorization", "Bearer "+h.token)
+	}
+
+	if h.sign != nil {
+		h.sign(req)
+	}
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("remote cache request failed: %w", err)
+	}
+
+	// entries have no content, so there is nothing to read
+	_ = resp.Body.Close()
+
+	return resp, nil
+}
+
+// emptyPayloadHash is the hash of an empty request body, as required when signing requests for S3.
+const emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// newS3Backend creates a Backend which stores entries in an S3-compatible bucket, using path-style requests signed
+// with AWS Signature Version 4.
+// The endpoint, region and credentials are read from the standard AWS environment variables.
+func newS3Backend(client *http.Client, u *url.URL) (Backend, error) {
+	accessKey := os.Getenv("AWS_ACCESS_KEY_ID")
+	secretKey := os.Getenv("AWS_SECRET_ACCESS_KEY")
+
+	if accessKey == "" || secretKey == "" {
+		return nil, fmt.Errorf("AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY must be set to use an s3 remote cache")
+	}
+
+	region := os.Getenv("AWS_REGION")
+	if region == "" {
+		region = "us-east-1"
+	}
+
+	endpoint := os.Getenv("AWS_ENDPOINT_URL")
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://s3.%s.amazonaws.com", region)
+	}
+
+	sessionToken := os.Getenv("AWS_SESSION_TOKEN")
+
+	sign := func(req *http.Request) {
+		now := time.Now().UTC()
+		date := now.Format("20060102")
+		timestamp := now.Format("20060102T150405Z")
+
+		req.Header.Set("X-Amz-Content-Sha256", emptyPayloadHash)
+		req.Header.Set("X-Amz-Date", timestamp)
+
+		signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+		canonicalHeaders := fmt.Sprintf(
+			"host:%s\nx-amz-content-sha256:%s\nx-amz-date:%s\n", req.URL.Host, emptyPayloadHash, timestamp,
+		)
+
+		if sessionToken != "" {
+			req.Header.Set("X-Amz-Security-Token", sessionToken)
+
+			signedHeaders += ";x-amz-security-token"
+			canonicalHeaders += fmt.Sprintf("x-amz-security-token:%s\n", sessionToken)
+		}
+
+		canonicalRequest := strings.Join([]string{
+			req.Method,
+			req.URL.EscapedPath(),
+			req.URL.RawQuery,
+			canonicalHeaders,
+			signedHeaders,
+			emptyPayloadHash,
+		}, "\n")
+
+		scope := fmt.Sprintf("%s/%s/s3/aws4_request", date, region)
+		requestHash := sha256.Sum256([]byte(canonicalRequest))
+		stringToSign := strings.Join([]string{
+			"AWS4-HMAC-SHA256", timestamp, scope, hex.EncodeToString(requestHash[:]),
+		}, "\n")
+
+		key := hmacSHA256([]byte("AWS4"+secretKey), date)
+		for _, part := range []string{region, "s3", "aws4_request"} {
+			key = hmacSHA256(key, part)
+		}
+
+		req.Header.Set("Authorization", fmt.Sprintf(
+			"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+			accessKey, scope, signedHeaders, hex.EncodeToString(hmacSHA256(key, stringToSign)),
+		))
+	}
+
+	return &httpBackend{
+		client: client,
+		url:    strings.TrimSuffix(endpoint, "/") + "/" + u.Host + strings.TrimSuffix(u.Path, "/"),
+		sign:   sign,
+	}, nil
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+
+	return h.Sum(nil)
+}
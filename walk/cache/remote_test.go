@@ -0,0 +1,65 @@
+package cache_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+
+	"github.com/numtide/treefmt/v2/walk/cache"
+	"github.com/stretchr/testify/require"
+)
+
+func TestS3Backend(t *testing.T) {
+	as := require.New(t)
+
+	var requests []*http.Request
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r)
+
+		if r.Method == http.MethodHead {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	t.Setenv("AWS_ENDPOINT_URL", server.URL)
+	t.Setenv("AWS_REGION", "eu-west-1")
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIDEXAMPLE")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	t.Setenv("AWS_SESSION_TOKEN", "")
+
+	backend, err := cache.NewBackend("s3://bucket/treefmt/")
+	as.NoError(err)
+
+	found, err := backend.Has(context.Background(), "abc")
+	as.NoError(err)
+	as.False(found)
+
+	as.NoError(backend.Put(context.Background(), "abc"))
+
+	as.Len(requests, 2)
+
+	for _, req := range requests {
+		// requests are path-style, and signed with AWS Signature Version 4
+		as.Equal("/bucket/treefmt/abc", req.URL.Path)
+		as.Regexp(
+			regexp.MustCompile(`^AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/\d{8}/eu-west-1/s3/aws4_request, `+
+				`SignedHeaders=host;x-amz-content-sha256;x-amz-date, Signature=[0-9a-f]{64}$`),
+			req.Header.Get("Authorization"),
+		)
+		as.NotEmpty(req.Header.Get("X-Amz-Date"))
+	}
+
+	// credentials are required
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "")
+
+	_, err = cache.NewBackend("s3://bucket/treefmt")
+	as.ErrorContains(err, "AWS_SECRET_ACCESS_KEY must be set")
+
+	// only known schemes are supported
+	_, err = cache.NewBackend("ftp://example.com")
+	as.ErrorContains(err, "unsupported remote cache scheme")
+}
@@ -8,6 +8,7 @@ import (
 	"runtime"
 
 	"github.com/charmbracelet/log"
+	"github.com/numtide/treefmt/v2/tracing"
 	"github.com/numtide/treefmt/v2/walk/cache"
 	bolt "go.etcd.io/bbolt"
 	"golang.org/x/sync/errgroup"
@@ -38,21 +39,38 @@ type CachedReader struct {
 	eg *errgroup.Group
 
 	// updateCh contains files which have been released after processing and should be updated in the cache.
-	updateCh chan *File
+	updateCh chan update
+}
+
+// update is a file which should be updated in the cache, along with the context in which it was released.
+type update struct {
+	ctx  context.Context //nolint:containedctx
+	file *File
 }
 
 // process updates cached file entries by batching file updates and flushing them to the database periodically.
 func (c *CachedReader) process() error {
 	batch := make([]*File, 0, c.batchSize)
 
-	flush := func() error {
+	// ctx is that in which the first file of the batch was released, which is used for tracing the update
+	var ctx context.Context
+
+	flush := func() (err error) {
 		// check for an empty batch
 		if len(batch) == 0 {
 			return nil
 		}
 
+		_, span := tracing.Start(ctx, "cache.update", tracing.Int("files", len(batch)))
+
+		defer func() {
+			span.SetError(err)
+			span.End()
+		}()
+
 		return c.db.Update(func(tx *bolt.Tx) error {
 			bucket := cache.PathsBucket(tx)
+			entries := cache.EntriesBucket(tx)
 
 			// for each file in the batch, calculate its new format signature and update the bucket entry
 			for _, file := range batch {
@@ -61,17 +79,34 @@ func (c *CachedReader) process() error {
 					return fmt.Errorf("failed to calculate signature for path %s: %w", file.RelPath, err)
 				}
 
-				if err := bucket.Put([]byte(file.RelPath), signature); err != nil {
+				key := cache.PathKey(file.RelPath)
+
+				if err := bucket.Put([]byte(key), signature); err != nil {
 					return fmt.Errorf("failed to put format signature for path %s: %w", file.RelPath, err)
 				}
+
+				// record which formatters were applied, allowing entries for formatters which have since been removed
+				// to be pruned, and the cache to be exported
+				entry := &cache.Entry{
+					Formatters: file.Formatters,
+					Signature:  file.FormattersSignature,
+				}
+
+				if err := cache.PutEntry(entries, key, entry); err != nil {
+					return err
+				}
 			}
 
 			return nil
 		})
 	}
 
-	for file := range c.updateCh {
-		batch = append(batch, file)
+	for u := range c.updateCh {
+		if len(batch) == 0 {
+			ctx = u.ctx
+		}
+
+		batch = append(batch, u.file)
 		if len(batch) == c.batchSize {
 			if err := flush(); err != nil {
 				return err
@@ -99,15 +134,18 @@ func (c *CachedReader) Read(ctx context.Context, files []*File) (n int, err erro
 		n, err = c.delegate.Read(ctx, files)
 		c.log.Debugf("read %d files from delegate", n)
 
+		_, span := tracing.Start(ctx, "cache.lookup", tracing.Int("files", n))
+		defer span.End()
+
 		for i := 0; i < n; i++ {
 			file := files[i]
 
-			file.CachedFormatSignature = bucket.Get([]byte(file.RelPath))
+			file.CachedFormatSignature = bucket.Get([]byte(cache.PathKey(file.RelPath)))
 
 			// set a release function which inserts this file into the update channel
 			file.AddReleaseFunc(func(ctx context.Context) error {
 				if !GetNoCache(ctx) {
-					c.updateCh <- file
+					c.updateCh <- update{ctx: ctx, file: file}
 				}
 
 				return nil
@@ -145,7 +183,7 @@ func NewCachedReader(db *bolt.DB, batchSize int, delegate Reader) (*CachedReader
 		delegate:  delegate,
 		log:       log.WithPrefix("walk | cache"),
 		eg:        eg,
-		updateCh:  make(chan *File, batchSize*runtime.NumCPU()),
+		updateCh:  make(chan update, batchSize*runtime.NumCPU()),
 	}
 
 	// start the processing loop
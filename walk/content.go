@@ -0,0 +1,147 @@
+package walk
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+//go:generate enumer -type=ContentType -text -transform=snake -output=./content_type_enum.go
+type ContentType int
+
+const (
+	Unknown ContentType = iota
+	Binary
+	Text
+	JSON
+	XML
+	HTML
+	Shell
+	Python
+	Ruby
+	Perl
+	Javascript
+)
+
+var (
+	//nolint:gochecknoglobals
+	htmlRegex = regexp.MustCompile(`(?i)^<(!doctype\s+html|html)[\s>]`)
+	//nolint:gochecknoglobals
+	xmlRegex = regexp.MustCompile(`^<(\?xml|[a-zA-Z_][\w.:-]*[\s/>])`)
+	//nolint:gochecknoglobals
+	interpreterTypes = map[string]ContentType{
+		"sh":      Shell,
+		"bash":    Shell,
+		"dash":    Shell,
+		"ksh":     Shell,
+		"zsh":     Shell,
+		"python":  Python,
+		"python2": Python,
+		"python3": Python,
+		"ruby":    Ruby,
+		"perl":    Perl,
+		"node":    Javascript,
+		"deno":    Javascript,
+		"bun":     Javascript,
+	}
+)
+
+// ContentType returns the type of the file, as detected from the first HeadSize bytes of its content.
+// The result is cached, so detection is performed at most once per file regardless of how many formatters inspect it.
+func (f *File) ContentType() (ContentType, error) {
+	if f.contentType != nil {
+		return *f.contentType, nil
+	}
+
+	head, err := f.Head()
+	if err != nil {
+		return Unknown, err
+	}
+
+	// determine if we have read the entire file
+	complete := len(head) < HeadSize || (f.Info != nil && f.Info.Size() <= int64(len(head)))
+
+	contentType := detectContentType(head, complete)
+	f.contentType = &contentType
+
+	return contentType, nil
+}
+
+// detectContentType determines the type of content based on head, which is the start of the content.
+// If complete is true, head contains the entire content.
+func detectContentType(head []byte, complete bool) ContentType {
+	if len(head) == 0 {
+		return Unknown
+	}
+
+	if isBinary(head, complete) {
+		return Binary
+	}
+
+	// shebangs take precedence over everything else
+	if interpreter := shebangInterpreter(head); interpreter != "" {
+		if contentType, ok := interpreterTypes[interpreter]; ok {
+			return contentType
+		}
+
+		// handle versioned interpreters such as python3.12
+		if contentType, ok := interpreterTypes[strings.TrimRight(interpreter, "0123456789.")]; ok {
+			return contentType
+		}
+
+		return Text
+	}
+
+	// skip any byte order mark and leading whitespace
+	trimmed := bytes.TrimLeft(bytes.TrimPrefix(head, []byte("\xef\xbb\xbf")), " \t\r\n")
+
+	switch {
+	case htmlRegex.Match(trimmed):
+		return HTML
+	case xmlRegex.Match(trimmed):
+		return XML
+	case (bytes.HasPrefix(trimmed, []byte("{")) || bytes.HasPrefix(trimmed, []byte("["))) && isJSON(trimmed, complete):
+		return JSON
+	default:
+		return Text
+	}
+}
+
+// isBinary determines if head contains binary content, based on the presence of NUL bytes or invalid UTF-8.
+func isBinary(head []byte, complete bool) bool {
+	if bytes.IndexByte(head, 0) >= 0 {
+		return true
+	}
+
+	if !complete {
+		// the last rune might have been truncated when reading head
+		for i := 0; i < utf8.UTFMax-1 && len(head) > 0 && !utf8.Valid(head); i++ {
+			head = head[:len(head)-1]
+		}
+	}
+
+	return !utf8.Valid(head)
+}
+
+// isJSON determines if head is valid JSON.
+// If head does not contain the entire content, it is considered JSON if it contains no syntax errors.
+func isJSON(head []byte, complete bool) bool {
+	if complete {
+		return json.Valid(head)
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(head))
+
+	for {
+		_, err := decoder.Token()
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return true
+		} else if err != nil {
+			return false
+		}
+	}
+}
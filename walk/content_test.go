@@ -0,0 +1,106 @@
+package walk_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/numtide/treefmt/v2/walk"
+	"github.com/stretchr/testify/require"
+)
+
+func TestContentType(t *testing.T) {
+	as := require.New(t)
+
+	tempDir := t.TempDir()
+
+	// a large JSON document which will be truncated when read
+	largeJSON := `{"items": [` + strings.Repeat(`{"name": "item", "value": 1}, `, 1024) + `{}]}`
+
+	for _, tc := range []struct {
+		name        string
+		content     string
+		contentType walk.ContentType
+	}{
+		{"empty", "", walk.Unknown},
+		{"binary", "\x00\x01\x02", walk.Binary},
+		{"invalid-utf8", "\xff\xfe\xfd", walk.Binary},
+		{"text", "hello world\n", walk.Text},
+		{"json-object.txt", `{"hello": "world"}`, walk.JSON},
+		{"json-array", "\n  [1, 2, 3]\n", walk.JSON},
+		{"json-bom", "\xef\xbb\xbf{}", walk.JSON},
+		{"json-large", largeJSON, walk.JSON},
+		{"not-json.json", `{hello: world}`, walk.Text},
+		{"xml", `<?xml version="1.0"?><root/>`, walk.XML},
+		{"xml-no-declaration", "<project>\n</project>\n", walk.XML},
+		{"html", "<!DOCTYPE html>\n<html></html>\n", walk.HTML},
+		{"html-tag", "<html lang=\"en\"></html>\n", walk.HTML},
+		{"shell", "#!/bin/sh\necho hello\n", walk.Shell},
+		{"shell-env.py", "#!/usr/bin/env bash\necho hello\n", walk.Shell},
+		{"python", "#!/usr/bin/python3.12\nprint('hello')\n", walk.Python},
+		{"ruby", "#!/usr/bin/env ruby\nputs 'hello'\n", walk.Ruby},
+		{"perl", "#!/usr/bin/perl -w\nprint 'hello';\n", walk.Perl},
+		{"javascript", "#!/usr/bin/env node\nconsole.log('hello')\n", walk.Javascript},
+		{"other-interpreter", "#!/usr/bin/env awk -f\n", walk.Text},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(tempDir, tc.name)
+			as.NoError(os.WriteFile(path, []byte(tc.content), 0o600))
+
+			info, err := os.Stat(path)
+			as.NoError(err)
+
+			file := &walk.File{Path: path, RelPath: tc.name, Info: info}
+
+			contentType, err := file.ContentType()
+			as.NoError(err)
+			as.Equal(tc.contentType, contentType)
+
+			// detection is cached
+			as.NoError(os.Remove(path))
+
+			contentType, err = file.ContentType()
+			as.NoError(err)
+			as.Equal(tc.contentType, contentType)
+		})
+	}
+}
+
+func TestEncoding(t *testing.T) {
+	as := require.New(t)
+
+	tempDir := t.TempDir()
+
+	for _, tc := range []struct {
+		name     string
+		content  string
+		encoding walk.Encoding
+	}{
+		{"empty", "", walk.EncodingUTF8},
+		{"ascii", "hello world\n", walk.EncodingUTF8},
+		{"utf8", "héllo wörld\n", walk.EncodingUTF8},
+		{"utf8-bom", "\xef\xbb\xbfhello\n", walk.EncodingUTF8},
+		{"utf16le-bom", "\xff\xfeh\x00i\x00\n\x00", walk.EncodingUTF16LE},
+		{"utf16be-bom", "\xfe\xff\x00h\x00i\x00\n", walk.EncodingUTF16BE},
+		{"utf16le", "h\x00\xe9\x00l\x00l\x00o\x00\n\x00", walk.EncodingUTF16LE},
+		{"utf16be", "\x00h\x00\xe9\x00l\x00l\x00o\x00\n", walk.EncodingUTF16BE},
+		{"latin1", "h\xe9llo w\xf6rld\n", walk.EncodingLatin1},
+		{"binary", "\x00\x01\x02\x03\x04", walk.EncodingUnknown},
+		{"binary-no-nul", "\xff\x01\x02\x03", walk.EncodingUnknown},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(tempDir, tc.name)
+			as.NoError(os.WriteFile(path, []byte(tc.content), 0o600))
+
+			info, err := os.Stat(path)
+			as.NoError(err)
+
+			file := &walk.File{Path: path, RelPath: tc.name, Info: info}
+
+			encoding, err := file.Encoding()
+			as.NoError(err)
+			as.Equal(tc.encoding, encoding)
+		})
+	}
+}
@@ -0,0 +1,136 @@
+package walk
+
+import (
+	"bytes"
+	"strings"
+)
+
+//go:generate enumer -type=Encoding -text -trimprefix=Encoding -transform=snake -output=./encoding_enum.go
+type Encoding int
+
+const (
+	// EncodingUnknown is binary content, or text in an encoding which is not recognised.
+	EncodingUnknown Encoding = iota
+	// EncodingUTF8 is UTF-8, with or without a byte order mark, including plain ASCII.
+	EncodingUTF8
+	// EncodingUTF16LE is little-endian UTF-16, with or without a byte order mark.
+	EncodingUTF16LE
+	// EncodingUTF16BE is big-endian UTF-16, with or without a byte order mark.
+	EncodingUTF16BE
+	// EncodingLatin1 is ISO-8859-1, assumed for text which is not valid UTF-8.
+	EncodingLatin1
+)
+
+//nolint:gochecknoglobals
+var (
+	bomUTF8    = []byte("\xef\xbb\xbf")
+	bomUTF16LE = []byte("\xff\xfe")
+	bomUTF16BE = []byte("\xfe\xff")
+)
+
+// Encoding returns the character encoding of the file, as detected from the first HeadSize bytes of its content.
+// The result is cached, so detection is performed at most once per file regardless of how many formatters inspect it.
+func (f *File) Encoding() (Encoding, error) {
+	if f.encoding != nil {
+		return *f.encoding, nil
+	}
+
+	head, err := f.Head()
+	if err != nil {
+		return EncodingUnknown, err
+	}
+
+	// determine if we have read the entire file
+	complete := len(head) < HeadSize || (f.Info != nil && f.Info.Size() <= int64(len(head)))
+
+	encoding := detectEncoding(head, complete)
+	f.encoding = &encoding
+
+	return encoding, nil
+}
+
+// BOM returns the byte order mark which identifies encoding, or nil if it has none.
+func (e Encoding) BOM() []byte {
+	switch e {
+	case EncodingUTF8:
+		return bomUTF8
+	case EncodingUTF16LE:
+		return bomUTF16LE
+	case EncodingUTF16BE:
+		return bomUTF16BE
+	default:
+		return nil
+	}
+}
+
+// detectEncoding determines the character encoding of head, which is the start of the content, using its byte order
+// mark if it has one. Otherwise, UTF-16 is recognised by NUL bytes alternating with ASCII characters, as found in most
+// source files, and latin-1 is assumed for text which is not valid UTF-8.
+// If complete is true, head contains the entire content.
+func detectEncoding(head []byte, complete bool) Encoding {
+	switch {
+	case bytes.HasPrefix(head, bomUTF8):
+		return EncodingUTF8
+	case bytes.HasPrefix(head, bomUTF16LE):
+		return EncodingUTF16LE
+	case bytes.HasPrefix(head, bomUTF16BE):
+		return EncodingUTF16BE
+	}
+
+	if bytes.IndexByte(head, 0) >= 0 {
+		return detectUTF16(head)
+	}
+
+	if !isBinary(head, complete) {
+		return EncodingUTF8
+	}
+
+	// latin-1 assigns a character to every byte, but text rarely contains control characters besides whitespace
+	for _, b := range head {
+		if b < 0x20 && !isText(b) && b != 0x1b {
+			return EncodingUnknown
+		}
+	}
+
+	return EncodingLatin1
+}
+
+// detectUTF16 determines if head, which contains NUL bytes, is UTF-16 without a byte order mark. The high byte of
+// ASCII characters is NUL, so at least half of the code units must be ASCII text, whilst none may have a NUL low byte.
+func detectUTF16(head []byte) Encoding {
+	// a trailing odd byte may have been truncated when reading head
+	units := len(head) / 2
+	if units == 0 {
+		return EncodingUnknown
+	}
+
+	for _, encoding := range []Encoding{EncodingUTF16LE, EncodingUTF16BE} {
+		var ascii int
+
+		for i := 0; i < units*2; i += 2 {
+			low, high := head[i], head[i+1]
+			if encoding == EncodingUTF16BE {
+				low, high = high, low
+			}
+
+			if low == 0 {
+				ascii = 0
+
+				break
+			} else if high == 0 && isText(low) {
+				ascii++
+			}
+		}
+
+		if ascii*2 >= units {
+			return encoding
+		}
+	}
+
+	return EncodingUnknown
+}
+
+// isText reports whether b is a printable ASCII character or whitespace.
+func isText(b byte) bool {
+	return (b >= 0x20 && b < 0x7f) || strings.IndexByte("\t\n\v\f\r", b) >= 0
+}
@@ -0,0 +1,75 @@
+package walk
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/charmbracelet/log"
+	"github.com/numtide/treefmt/v2/stats"
+)
+
+// FileReader reads a single file, avoiding the overhead of traversing the filesystem when only one file is to be
+// formatted.
+type FileReader struct {
+	root     string
+	path     string
+	symlinks *symlinkResolver
+	stats    *stats.Stats
+
+	complete bool
+}
+
+// Read populates files with the file being read, if it has not already been read.
+func (f *FileReader) Read(_ context.Context, files []*File) (n int, err error) {
+	if f.complete || len(files) == 0 {
+		return 0, io.EOF
+	}
+
+	f.complete = true
+
+	path := filepath.Join(f.root, f.path)
+
+	info, err := os.Lstat(path)
+	if err != nil {
+		return 0, fmt.Errorf("failed to stat %s: %w", path, err)
+	}
+
+	// symlinks are handled as they are when traversing the filesystem, except that a directory cannot be read as a
+	// single file
+	if info.Mode()&os.ModeSymlink == os.ModeSymlink {
+		if _, info, err = f.symlinks.resolve(path, f.path); err != nil {
+			return 0, err
+		} else if info == nil || info.IsDir() {
+			return 0, io.EOF
+		}
+	}
+
+	files[0] = &File{
+		Path:    path,
+		RelPath: f.path,
+		Info:    info,
+	}
+
+	f.stats.Add(stats.Traversed, 1)
+
+	return 1, io.EOF
+}
+
+func (f *FileReader) Close() error {
+	return nil
+}
+
+// NewFileReader creates a reader for the file at path, relative to root.
+func NewFileReader(root string, path string, symlinks Symlinks, statz *stats.Stats) *FileReader {
+	path = filepath.Clean(path)
+
+	return &FileReader{
+		root:     root,
+		path:     path,
+		symlinks: newSymlinkResolver(symlinks, log.WithPrefix("walk | file"), root, path),
+		stats:    statz,
+	}
+}
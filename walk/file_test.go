@@ -0,0 +1,50 @@
+package walk_test
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/numtide/treefmt/v2/stats"
+	"github.com/numtide/treefmt/v2/test"
+	"github.com/numtide/treefmt/v2/walk"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileReader(t *testing.T) {
+	as := require.New(t)
+
+	tempDir := test.TempExamples(t)
+	statz := stats.New()
+
+	r := walk.NewFileReader(tempDir, "elm/./src/Main.elm", walk.SymlinksSkip, &statz)
+
+	files := make([]*walk.File, 8)
+
+	// the file is read once
+	n, err := r.Read(context.Background(), files)
+	as.ErrorIs(err, io.EOF)
+	as.Equal(1, n)
+	as.Equal("elm/src/Main.elm", files[0].RelPath)
+	as.Equal(filepath.Join(tempDir, "elm/src/Main.elm"), files[0].Path)
+	as.Equal(1, statz.Value(stats.Traversed))
+
+	n, err = r.Read(context.Background(), files)
+	as.ErrorIs(err, io.EOF)
+	as.Equal(0, n)
+	as.NoError(r.Close())
+
+	// symlinks are ignored
+	as.NoError(os.Symlink("Main.elm", filepath.Join(tempDir, "elm/src/Link.elm")))
+
+	n, err = walk.NewFileReader(tempDir, "elm/src/Link.elm", walk.SymlinksSkip, &statz).Read(context.Background(), files)
+	as.ErrorIs(err, io.EOF)
+	as.Equal(0, n)
+
+	// missing files are reported
+	r = walk.NewFileReader(tempDir, "elm/src/Missing.elm", walk.SymlinksSkip, &statz)
+	_, err = r.Read(context.Background(), files)
+	as.ErrorContains(err, "failed to stat")
+}
@@ -24,6 +24,11 @@ type FilesystemReader struct {
 
 	eg *errgroup.Group
 
+	// ignore is used to skip paths ignored by git, it is nil if ignored paths should be traversed
+	ignore *gitignore
+	// symlinks determines whether the symlinks encountered are skipped, followed or reported as an error
+	symlinks *symlinkResolver
+
 	stats   *stats.Stats
 	filesCh chan *File
 }
@@ -44,22 +49,42 @@ func (f *FilesystemReader) process() error {
 		return fmt.Errorf("path '%s' is outside of the root '%s'", path, f.root)
 	}
 
-	// walk the path
-	return filepath.Walk(path, func(path string, info fs.FileInfo, err error) error {
-		// return errors immediately
-		if err != nil {
+	if f.ignore != nil {
+		// load the ignore files in any directories between the root and the path
+		if err := f.ignore.enterParents(filepath.Clean(f.path)); err != nil {
 			return err
 		}
+	}
 
-		// ignore directories and symlinks
-		if info.IsDir() || info.Mode()&os.ModeSymlink == os.ModeSymlink {
-			return nil
+	// determine the path relative to the root, which is "." for the root itself
+	relPath, err := filepath.Rel(f.root, path)
+	if err != nil {
+		return fmt.Errorf("failed to determine a relative path for %s: %w", path, err)
+	}
+
+	// walk the path
+	return f.symlinks.walk(f.root, path, relPath, func(path string, relPath string, info fs.FileInfo) error {
+		if f.ignore != nil {
+			if f.ignore.ignored(relPath, info.IsDir()) {
+				f.log.Debugf("skipping ignored path %s", relPath)
+
+				// ignored symlinks are not resolved
+				if info.IsDir() || info.Mode()&os.ModeSymlink == os.ModeSymlink {
+					return filepath.SkipDir
+				}
+
+				return nil
+			} else if info.IsDir() {
+				// load the directory's ignore file before traversing it
+				if err := f.ignore.enter(relPath); err != nil {
+					return err
+				}
+			}
 		}
 
-		// determine a path relative to the root
-		relPath, err := filepath.Rel(f.root, path)
-		if err != nil {
-			return fmt.Errorf("failed to determine a relative path for %s: %w", path, err)
+		// ignore directories and symlinks, the latter are resolved after returning
+		if info.IsDir() || info.Mode()&os.ModeSymlink == os.ModeSymlink {
+			return nil
 		}
 
 		// create a new file and pass to the files channel
@@ -121,20 +146,54 @@ func (f *FilesystemReader) Close() error {
 func NewFilesystemReader(
 	root string,
 	path string,
+	symlinks Symlinks,
 	statz *stats.Stats,
 	batchSize int,
+) *FilesystemReader {
+	return newFilesystemReader(root, path, symlinks, statz, batchSize, nil)
+}
+
+// NewGitignoreReader creates a new instance of FilesystemReader which skips any paths ignored by the .gitignore files
+// within root, as well as root/.git/info/exclude, without requiring a git repository or index.
+func NewGitignoreReader(
+	root string,
+	path string,
+	symlinks Symlinks,
+	statz *stats.Stats,
+	batchSize int,
+) *FilesystemReader {
+	return newFilesystemReader(root, path, symlinks, statz, batchSize, newGitignore(root))
+}
+
+func newFilesystemReader(
+	root string,
+	path string,
+	symlinks Symlinks,
+	statz *stats.Stats,
+	batchSize int,
+	ignore *gitignore,
 ) *FilesystemReader {
 	// create an error group for managing the processing loop
 	eg := errgroup.Group{}
 
+	prefix := "walk | filesystem"
+	if ignore != nil {
+		prefix = "walk | gitignore"
+	}
+
+	logger := log.WithPrefix(prefix)
+
 	r := FilesystemReader{
-		log:       log.WithPrefix("walk | filesystem"),
+		log:       logger,
 		root:      root,
 		path:      path,
 		batchSize: batchSize,
 
 		eg: &eg,
 
+		ignore:   ignore,
+		symlinks: newSymlinkResolver(symlinks, logger, root, path),
+
 		stats:   statz,
 		filesCh: make(chan *File, batchSize*runtime.NumCPU()),
 	}
@@ -4,6 +4,8 @@ import (
 	"context"
 	"errors"
 	"io"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
@@ -55,7 +57,7 @@ func TestFilesystemReader(t *testing.T) {
 	tempDir := test.TempExamples(t)
 	statz := stats.New()
 
-	r := walk.NewFilesystemReader(tempDir, "", &statz, 1024)
+	r := walk.NewFilesystemReader(tempDir, "", walk.SymlinksSkip, &statz, 1024)
 
 	count := 0
 
@@ -66,7 +68,7 @@ func TestFilesystemReader(t *testing.T) {
 		n, err := r.Read(ctx, files)
 
 		for i := count; i < count+n; i++ {
-			as.Equal(examplesPaths[i], files[i-count].RelPath)
+			as.Equal(filepath.FromSlash(examplesPaths[i]), files[i-count].RelPath)
 		}
 
 		count += n
@@ -84,3 +86,60 @@ func TestFilesystemReader(t *testing.T) {
 	as.Equal(0, statz.Value(stats.Formatted))
 	as.Equal(0, statz.Value(stats.Changed))
 }
+
+func TestFilesystemReaderSymlinks(t *testing.T) {
+	as := require.New(t)
+
+	tempDir := t.TempDir()
+	outside := t.TempDir()
+
+	as.NoError(os.MkdirAll(filepath.Join(tempDir, "src"), 0o755))
+	as.NoError(os.WriteFile(filepath.Join(tempDir, "src", "main.go"), []byte("package main\n"), 0o600))
+	as.NoError(os.MkdirAll(filepath.Join(outside, "lib", "nested"), 0o755))
+	as.NoError(os.WriteFile(filepath.Join(outside, "lib", "lib.go"), []byte("package lib\n"), 0o600))
+	as.NoError(os.WriteFile(filepath.Join(outside, "lib", "nested", "nested.go"), []byte("package nested\n"), 0o600))
+
+	// a directory outside the tree, a file within it, a cycle, a second link to the same directory and a dangling link
+	as.NoError(os.Symlink(filepath.Join(outside, "lib"), filepath.Join(tempDir, "lib")))
+	as.NoError(os.Symlink("main.go", filepath.Join(tempDir, "src", "link.go")))
+	as.NoError(os.Symlink("..", filepath.Join(outside, "lib", "nested", "parent")))
+	as.NoError(os.Symlink(filepath.Join(outside, "lib"), filepath.Join(tempDir, "again")))
+	as.NoError(os.Symlink("missing", filepath.Join(tempDir, "dangling")))
+
+	read := func(symlinks walk.Symlinks) ([]string, error) {
+		statz := stats.New()
+		reader := walk.NewFilesystemReader(tempDir, "", symlinks, &statz, 1024)
+
+		var paths []string
+
+		for {
+			files := make([]*walk.File, 8)
+			n, err := reader.Read(context.Background(), files)
+
+			for _, file := range files[:n] {
+				paths = append(paths, file.RelPath)
+				as.True(file.Info.Mode().IsRegular(), "%s is not a regular file", file.RelPath)
+			}
+
+			if errors.Is(err, io.EOF) {
+				break
+			}
+
+			as.NoError(err)
+		}
+
+		return paths, reader.Close()
+	}
+
+	paths, err := read(walk.SymlinksSkip)
+	as.NoError(err)
+	as.Equal([]string{"src/main.go"}, paths)
+
+	// the target within the tree is already traversed, and the directory outside it is only traversed once
+	paths, err = read(walk.SymlinksFollow)
+	as.NoError(err)
+	as.Equal([]string{"again/lib.go", "again/nested/nested.go", "src/main.go"}, paths)
+
+	_, err = read(walk.SymlinksError)
+	as.ErrorContains(err, "again is a symlink")
+}
@@ -19,6 +19,21 @@ type GitReader struct {
 	root string
 	path string
 
+	// base is the commit to compare against when only traversing changed files, empty otherwise
+	base string
+
+	// from and to are the commits between which the files touched by each commit are traversed, empty otherwise
+	from, to string
+	// pathspecs restrict the files touched between from and to which are traversed
+	pathspecs []string
+	// seen contains the files which have already been traversed, as a file may be touched by many commits
+	seen map[string]bool
+
+	// symlinks determines whether the symlinks listed are skipped, followed or reported as an error
+	symlinks *symlinkResolver
+	// pending contains the files found by following a symlink to a directory, which have yet to be read
+	pending []*File
+
 	log   *log.Logger
 	stats *stats.Stats
 
@@ -36,14 +51,9 @@ func (g *GitReader) Read(ctx context.Context, files []*File) (n int, err error)
 		// create a pipe to capture the command output
 		r, w := io.Pipe()
 
-		// create a command which will execute from the specified sub path within root
-		cmd := exec.Command("git", "ls-files")
-		cmd.Dir = filepath.Join(g.root, g.path)
-		cmd.Stdout = w
-
-		// execute the command in the background
+		// execute the listing in the background
 		g.eg.Go(func() error {
-			return w.CloseWithError(cmd.Run())
+			return w.CloseWithError(g.list(w))
 		})
 
 		// create a new scanner for reading the output
@@ -59,14 +69,37 @@ LOOP:
 			return n, ctx.Err()
 
 		default:
+			if len(g.pending) > 0 {
+				files[n] = g.pending[0]
+				g.pending = g.pending[1:]
+				n++
+
+				continue
+			}
+
 			// read the next file
 			if g.scanner.Scan() {
+				// the files touched by each commit in a range are separated by blank lines, and may repeat
+				if g.seen != nil {
+					if g.scanner.Text() == "" || g.seen[g.scanner.Text()] {
+						continue
+					}
+
+					g.seen[g.scanner.Text()] = true
+				}
+
 				path := filepath.Join(g.root, g.path, g.scanner.Text())
+				relPath := filepath.Join(g.path, g.scanner.Text())
 
 				g.log.Debugf("processing file: %s", path)
 
-				info, err := os.Stat(path)
-				if os.IsNotExist(err) {
+				info, err := os.Lstat(path)
+				if os.IsNotExist(err) && g.seen != nil {
+					// the file was touched by a commit in the range, but has since been removed
+					g.log.Debugf("skipping %s as it no longer exists", path)
+
+					continue
+				} else if os.IsNotExist(err) {
 					// the underlying file might have been removed
 					g.log.Warnf(
 						"Path %s is in the worktree but appears to have been removed from the filesystem", path,
@@ -75,11 +108,20 @@ LOOP:
 					continue
 				} else if err != nil {
 					return n, fmt.Errorf("failed to stat %s: %w", path, err)
+				} else if info.Mode()&os.ModeSymlink == os.ModeSymlink {
+					followed, err := g.symlinks.follow(g.root, path, relPath)
+					if err != nil {
+						return n, err
+					}
+
+					g.pending = append(g.pending, followed...)
+
+					continue
 				}
 
 				files[n] = &File{
 					Path:    path,
-					RelPath: filepath.Join(g.path, g.scanner.Text()),
+					RelPath: relPath,
 					Info:    info,
 				}
 				n++
@@ -95,13 +137,53 @@ LOOP:
 	return n, err
 }
 
+// list writes the paths to be traversed into w, one per line.
+// If a base commit was provided, only files which have changed since the base commit, including uncommitted and
+// untracked files, are listed. If a range of commits was provided, the files touched by each commit are listed.
+// Otherwise, all files in the index are listed.
+func (g *GitReader) list(w io.Writer) error {
+	commands := [][]string{{"ls-files"}}
+
+	if g.from != "" {
+		commands = [][]string{
+			append([]string{
+				"log", "--name-only", "--format=", "--relative", "--diff-filter=d", g.from + ".." + g.to, "--",
+			}, g.pathspecs...),
+		}
+	} else if g.base != "" {
+		commands = [][]string{
+			// --relative restricts the output to the current directory and makes the paths relative to it
+			{"diff", "--name-only", "--relative", "--diff-filter=d", g.base},
+			{"ls-files", "--others", "--exclude-standard"},
+		}
+	}
+
+	for _, args := range commands {
+		// create a command which will execute from the specified sub path within root
+		cmd := exec.Command("git", args...)
+		cmd.Dir = filepath.Join(g.root, g.path)
+		cmd.Stdout = w
+
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("failed to run git %s: %w", strings.Join(args, " "), err)
+		}
+	}
+
+	return nil
+}
+
 func (g *GitReader) Close() error {
 	return g.eg.Wait()
 }
 
+// NewGitReader creates a reader which traverses the files tracked by git.
+// If since is not empty, only files which have changed since since and HEAD diverged are traversed, along with any
+// uncommitted or untracked files.
 func NewGitReader(
 	root string,
 	path string,
+	since string,
+	symlinks Symlinks,
 	statz *stats.Stats,
 ) (*GitReader, error) {
 	// check if the root is a git repository
@@ -114,11 +196,72 @@ func NewGitReader(
 		return nil, fmt.Errorf("%s is not a git repository", root)
 	}
 
+	var base string
+
+	if since != "" {
+		// find the point at which HEAD diverged from since
+		cmd = exec.Command("git", "merge-base", since, "HEAD")
+		cmd.Dir = root
+
+		out, err := cmd.Output()
+		if err != nil {
+			return nil, fmt.Errorf("failed to find merge base of %s and HEAD: %w", since, err)
+		}
+
+		base = strings.TrimSpace(string(out))
+	}
+
+	logger := log.WithPrefix("walk | git")
+
 	return &GitReader{
-		root:  root,
-		path:  path,
-		stats: statz,
-		eg:    &errgroup.Group{},
-		log:   log.WithPrefix("walk | git"),
+		root:     root,
+		path:     path,
+		base:     base,
+		symlinks: newSymlinkResolver(symlinks, logger, root, path),
+		stats:    statz,
+		eg:       &errgroup.Group{},
+		log:      logger,
 	}, nil
 }
+
+// NewGitRangeReader creates a reader which traverses the files touched by any of the commits reachable from to but not
+// from, e.g. those introduced by a series of commits in a merge queue, restricted to the given paths, relative to root,
+// if any are provided.
+// Files touched by the range which no longer exist in the worktree are skipped. If to is empty, it defaults to HEAD.
+func NewGitRangeReader(
+	root string,
+	paths []string,
+	from string,
+	to string,
+	symlinks Symlinks,
+	statz *stats.Stats,
+) (*GitReader, error) {
+	reader, err := NewGitReader(root, "", "", symlinks, statz)
+	if err != nil {
+		return nil, err
+	}
+
+	if to == "" {
+		to = "HEAD"
+	}
+
+	// resolve the refs, ensuring they exist
+	for _, ref := range []*string{&from, &to} {
+		cmd := exec.Command("git", "rev-parse", "--verify", "--quiet", *ref+"^{commit}")
+		cmd.Dir = root
+
+		out, err := cmd.Output()
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve commit %s: %w", *ref, err)
+		}
+
+		*ref = strings.TrimSpace(string(out))
+	}
+
+	reader.from = from
+	reader.to = to
+	reader.pathspecs = paths
+	reader.seen = make(map[string]bool)
+
+	return reader, nil
+}
@@ -4,7 +4,9 @@ import (
 	"context"
 	"errors"
 	"io"
+	"os"
 	"os/exec"
+	"path/filepath"
 	"testing"
 	"time"
 
@@ -26,7 +28,7 @@ func TestGitReader(t *testing.T) {
 
 	// read empty worktree
 	statz := stats.New()
-	reader, err := walk.NewGitReader(tempDir, "", &statz)
+	reader, err := walk.NewGitReader(tempDir, "", "", walk.SymlinksSkip, &statz)
 	as.NoError(err)
 
 	files := make([]*walk.File, 8)
@@ -42,7 +44,7 @@ func TestGitReader(t *testing.T) {
 	cmd.Dir = tempDir
 	as.NoError(cmd.Run(), "failed to add everything to the index")
 
-	reader, err = walk.NewGitReader(tempDir, "", &statz)
+	reader, err = walk.NewGitReader(tempDir, "", "", walk.SymlinksSkip, &statz)
 	as.NoError(err)
 
 	count := 0
@@ -68,3 +70,217 @@ func TestGitReader(t *testing.T) {
 	as.Equal(0, statz.Value(stats.Formatted))
 	as.Equal(0, statz.Value(stats.Changed))
 }
+
+func TestGitReaderSince(t *testing.T) {
+	as := require.New(t)
+
+	tempDir := test.TempExamples(t)
+
+	t.Setenv("GIT_AUTHOR_NAME", "Treefmt Test")
+	t.Setenv("GIT_AUTHOR_EMAIL", "test@treefmt.com")
+	t.Setenv("GIT_COMMITTER_NAME", "Treefmt Test")
+	t.Setenv("GIT_COMMITTER_EMAIL", "test@treefmt.com")
+
+	git := func(args ...string) {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = tempDir
+		out, err := cmd.CombinedOutput()
+		as.NoError(err, "failed to run git %v: %s", args, out)
+	}
+
+	read := func(path string, since string) []string {
+		statz := stats.New()
+		reader, err := walk.NewGitReader(tempDir, path, since, walk.SymlinksSkip, &statz)
+		as.NoError(err)
+
+		var paths []string
+
+		for {
+			ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+
+			files := make([]*walk.File, 8)
+			n, err := reader.Read(ctx, files)
+
+			for _, file := range files[:n] {
+				paths = append(paths, file.RelPath)
+			}
+
+			cancel()
+
+			if errors.Is(err, io.EOF) {
+				break
+			}
+		}
+
+		as.NoError(reader.Close())
+		as.Len(paths, statz.Value(stats.Traversed))
+
+		return paths
+	}
+
+	// commit everything and mark it as our base
+	git("init")
+	git("add", ".")
+	git("commit", "-m", "initial")
+	git("tag", "base")
+
+	// nothing has changed
+	as.Empty(read("", "base"))
+
+	// commit a change, delete a file, make an uncommitted change and add an untracked file
+	as.NoError(os.WriteFile(filepath.Join(tempDir, "go/main.go"), []byte("package main\n"), 0o644))
+	git("commit", "-am", "change")
+	as.NoError(os.Remove(filepath.Join(tempDir, "rust/src/main.rs")))
+	as.NoError(os.WriteFile(filepath.Join(tempDir, "elm/elm.json"), []byte("{}\n"), 0o644))
+	as.NoError(os.WriteFile(filepath.Join(tempDir, "elm/new.elm"), []byte("module New\n"), 0o644))
+
+	as.ElementsMatch([]string{"go/main.go", "elm/elm.json", "elm/new.elm"}, read("", "base"))
+
+	// restrict to a sub directory
+	as.ElementsMatch([]string{"elm/elm.json", "elm/new.elm"}, read("elm", "base"))
+
+	// without since, everything in the index is traversed, excluding the removed file and the untracked file
+	as.Len(read("", ""), 31)
+
+	// invalid ref
+	statz := stats.New()
+	_, err := walk.NewGitReader(tempDir, "", "does-not-exist", walk.SymlinksSkip, &statz)
+	as.ErrorContains(err, "failed to find merge base of does-not-exist and HEAD")
+}
+
+func TestGitRangeReader(t *testing.T) {
+	as := require.New(t)
+
+	tempDir := test.TempExamples(t)
+
+	t.Setenv("GIT_AUTHOR_NAME", "Treefmt Test")
+	t.Setenv("GIT_AUTHOR_EMAIL", "test@treefmt.com")
+	t.Setenv("GIT_COMMITTER_NAME", "Treefmt Test")
+	t.Setenv("GIT_COMMITTER_EMAIL", "test@treefmt.com")
+
+	git := func(args ...string) {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = tempDir
+		out, err := cmd.CombinedOutput()
+		as.NoError(err, "failed to run git %v: %s", args, out)
+	}
+
+	read := func(paths []string, from string, to string) []string {
+		statz := stats.New()
+		reader, err := walk.NewGitRangeReader(tempDir, paths, from, to, walk.SymlinksSkip, &statz)
+		as.NoError(err)
+
+		var result []string
+
+		for {
+			files := make([]*walk.File, 8)
+			n, err := reader.Read(context.Background(), files)
+
+			for _, file := range files[:n] {
+				result = append(result, file.RelPath)
+			}
+
+			if errors.Is(err, io.EOF) {
+				break
+			}
+		}
+
+		as.NoError(reader.Close())
+		as.Len(result, statz.Value(stats.Traversed))
+
+		return result
+	}
+
+	write := func(path string, content string) {
+		as.NoError(os.WriteFile(filepath.Join(tempDir, path), []byte(content), 0o644))
+	}
+
+	git("init")
+	git("add", ".")
+	git("commit", "-m", "initial")
+	git("tag", "base")
+
+	// a series of commits, touching one file twice and removing another
+	write("go/main.go", "package main\n")
+	git("commit", "-am", "first")
+	write("go/main.go", "package main\n\nfunc main() {}\n")
+	write("elm/elm.json", "{}\n")
+	git("commit", "-am", "second")
+	git("tag", "second")
+	git("rm", "-q", "rust/src/main.rs")
+	write("haskell/Foo.hs", "module Foo where\n")
+	git("commit", "-am", "third")
+
+	// uncommitted changes are not included
+	write("nixpkgs.toml", "")
+
+	as.ElementsMatch([]string{"go/main.go", "elm/elm.json", "haskell/Foo.hs"}, read(nil, "base", ""))
+	as.ElementsMatch([]string{"go/main.go", "elm/elm.json"}, read(nil, "base", "second"))
+	as.ElementsMatch([]string{"haskell/Foo.hs"}, read(nil, "second", "HEAD"))
+
+	// restrict to some paths
+	as.ElementsMatch([]string{"go/main.go", "haskell/Foo.hs"}, read([]string{"go", "haskell"}, "base", ""))
+
+	// invalid ref
+	statz := stats.New()
+	_, err := walk.NewGitRangeReader(tempDir, nil, "does-not-exist", "", walk.SymlinksSkip, &statz)
+	as.ErrorContains(err, "failed to resolve commit does-not-exist")
+}
+
+func TestGitReaderSymlinks(t *testing.T) {
+	as := require.New(t)
+
+	tempDir := t.TempDir()
+	outside := t.TempDir()
+
+	as.NoError(os.WriteFile(filepath.Join(tempDir, "main.go"), []byte("package main\n"), 0o600))
+	as.NoError(os.WriteFile(filepath.Join(outside, "lib.go"), []byte("package lib\n"), 0o600))
+	as.NoError(os.WriteFile(filepath.Join(outside, "util.go"), []byte("package lib\n"), 0o600))
+	as.NoError(os.Symlink(outside, filepath.Join(tempDir, "lib")))
+	as.NoError(os.Symlink(filepath.Join(outside, "lib.go"), filepath.Join(tempDir, "lib.go")))
+
+	for _, args := range [][]string{{"init"}, {"add", "."}} {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = tempDir
+		as.NoError(cmd.Run(), "failed to run git %v", args)
+	}
+
+	read := func(symlinks walk.Symlinks) ([]string, error) {
+		statz := stats.New()
+
+		reader, err := walk.NewGitReader(tempDir, "", "", symlinks, &statz)
+		as.NoError(err)
+
+		var paths []string
+
+		for {
+			// a small batch ensures the files within a followed directory are read across several calls
+			files := make([]*walk.File, 1)
+			n, err := reader.Read(context.Background(), files)
+
+			for _, file := range files[:n] {
+				paths = append(paths, file.RelPath)
+			}
+
+			if errors.Is(err, io.EOF) {
+				break
+			} else if err != nil {
+				return paths, errors.Join(err, reader.Close())
+			}
+		}
+
+		return paths, reader.Close()
+	}
+
+	paths, err := read(walk.SymlinksSkip)
+	as.NoError(err)
+	as.Equal([]string{"main.go"}, paths)
+
+	// the symlink to the file is skipped, as its target was already traversed when following the directory
+	paths, err = read(walk.SymlinksFollow)
+	as.NoError(err)
+	as.Equal([]string{"lib/lib.go", "lib/util.go", "main.go"}, paths)
+
+	_, err = read(walk.SymlinksError)
+	as.ErrorContains(err, "lib is a symlink")
+}
@@ -0,0 +1,272 @@
+package walk
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// ignorePattern is a single pattern read from a .gitignore file.
+type ignorePattern struct {
+	// base is the slash separated directory containing the file the pattern was read from, relative to the root.
+	// It is empty for the root directory.
+	base    string
+	regex   *regexp.Regexp
+	negate  bool
+	dirOnly bool
+}
+
+// match reports whether relPath, which is slash separated and relative to the root, is matched by the pattern.
+func (p ignorePattern) match(relPath string, isDir bool) bool {
+	if p.dirOnly && !isDir {
+		return false
+	}
+
+	if p.base != "" {
+		if !strings.HasPrefix(relPath, p.base+"/") {
+			return false
+		}
+
+		relPath = relPath[len(p.base)+1:]
+	}
+
+	return p.regex.MatchString(relPath)
+}
+
+// gitignore determines which paths are ignored based on the .gitignore files found in each directory, along with
+// .git/info/exclude in the root, following the same rules as git.
+type gitignore struct {
+	root string
+
+	// patterns contains the patterns which apply to the contents of each directory that has been entered, keyed by
+	// the directory's path relative to the root.
+	patterns map[string][]ignorePattern
+}
+
+// enterParents loads the ignore files for each of the parent directories of relPath.
+func (g *gitignore) enterParents(relPath string) error {
+	if relPath == "." {
+		return nil
+	}
+
+	dirs := []string{"."}
+
+	parts := strings.Split(filepath.Dir(relPath), string(filepath.Separator))
+	for i := range parts {
+		if parts[i] != "." {
+			dirs = append(dirs, filepath.Join(parts[:i+1]...))
+		}
+	}
+
+	for _, dir := range dirs {
+		if err := g.enter(dir); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// enter loads the ignore file for the directory at relPath, which must be relative to the root.
+// The patterns from its parent directory must already have been loaded.
+func (g *gitignore) enter(relPath string) error {
+	if _, ok := g.patterns[relPath]; ok {
+		return nil
+	}
+
+	var (
+		err      error
+		patterns []ignorePattern
+	)
+
+	base := filepath.ToSlash(relPath)
+
+	if relPath == "." {
+		base = ""
+
+		// patterns in .git/info/exclude have a lower precedence than those in .gitignore files
+		patterns, err = readIgnoreFile(filepath.Join(g.root, ".git", "info", "exclude"), base)
+		if err != nil {
+			return err
+		}
+	} else {
+		patterns = slices.Clip(g.patterns[filepath.Dir(relPath)])
+	}
+
+	own, err := readIgnoreFile(filepath.Join(g.root, relPath, ".gitignore"), base)
+	if err != nil {
+		return err
+	}
+
+	g.patterns[relPath] = append(patterns, own...)
+
+	return nil
+}
+
+// ignored reports whether the file or directory at relPath, which must be relative to the root, is ignored.
+// The patterns for its parent directory must already have been loaded.
+func (g *gitignore) ignored(relPath string, isDir bool) bool {
+	// we never want to traverse the git directory, or the file which replaces it in worktrees and submodules
+	if filepath.Base(relPath) == ".git" {
+		return true
+	}
+
+	patterns := g.patterns[filepath.Dir(relPath)]
+	slashPath := filepath.ToSlash(relPath)
+
+	// the last matching pattern takes precedence
+	for i := len(patterns) - 1; i >= 0; i-- {
+		if patterns[i].match(slashPath, isDir) {
+			return !patterns[i].negate
+		}
+	}
+
+	return false
+}
+
+func newGitignore(root string) *gitignore {
+	return &gitignore{
+		root:     root,
+		patterns: make(map[string][]ignorePattern),
+	}
+}
+
+// readIgnoreFile parses the patterns in the ignore file at path, returning nothing if the file does not exist.
+func readIgnoreFile(path string, base string) ([]ignorePattern, error) {
+	file, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to open ignore file %s: %w", path, err)
+	}
+	defer file.Close()
+
+	var patterns []ignorePattern
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		pattern, ok, err := parseIgnorePattern(scanner.Text(), base)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse pattern in %s: %w", path, err)
+		} else if ok {
+			patterns = append(patterns, pattern)
+		}
+	}
+
+	if err = scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read ignore file %s: %w", path, err)
+	}
+
+	return patterns, nil
+}
+
+// parseIgnorePattern parses a single line from an ignore file, returning false if the line does not contain a pattern.
+// See https://git-scm.com/docs/gitignore#_pattern_format.
+func parseIgnorePattern(line string, base string) (ignorePattern, bool, error) {
+	pattern := ignorePattern{base: base}
+
+	// trailing spaces are ignored unless they are escaped
+	for strings.HasSuffix(line, " ") && !strings.HasSuffix(line, "\\ ") {
+		line = line[:len(line)-1]
+	}
+
+	// blank lines and comments
+	if line == "" || strings.HasPrefix(line, "#") {
+		return pattern, false, nil
+	}
+
+	if strings.HasPrefix(line, "!") {
+		pattern.negate = true
+		line = line[1:]
+	}
+
+	if strings.HasSuffix(line, "/") {
+		pattern.dirOnly = true
+		line = strings.TrimSuffix(line, "/")
+	}
+
+	if line == "" {
+		return pattern, false, nil
+	}
+
+	// a pattern containing a separator is relative to the directory containing the ignore file, otherwise it
+	// matches at any level below it
+	anchored := strings.Contains(line, "/")
+	line = strings.TrimPrefix(line, "/")
+
+	expr := globToRegex(line)
+	if !anchored {
+		expr = "(?:.*/)?" + expr
+	}
+
+	regex, err := regexp.Compile("^" + expr + "$")
+	if err != nil {
+		return pattern, false, fmt.Errorf("invalid pattern %q: %w", line, err)
+	}
+
+	pattern.regex = regex
+
+	return pattern, true, nil
+}
+
+// globToRegex converts a gitignore glob into an equivalent regular expression.
+func globToRegex(glob string) string {
+	var sb strings.Builder
+
+	for i := 0; i < len(glob); i++ {
+		c := glob[i]
+
+		switch {
+		case strings.HasPrefix(glob[i:], "**/") && (i == 0 || glob[i-1] == '/'):
+			// leading or middle **/ matches zero or more directories
+			sb.WriteString("(?:.*/)?")
+
+			i += 2
+
+		case glob[i:] == "**" && i > 0 && glob[i-1] == '/':
+			// trailing /** matches everything inside
+			sb.WriteString(".*")
+
+			i++
+
+		case c == '*':
+			sb.WriteString("[^/]*")
+
+		case c == '?':
+			sb.WriteString("[^/]")
+
+		case c == '[':
+			end := strings.IndexByte(glob[i+1:], ']')
+			if end < 0 {
+				sb.WriteString(regexp.QuoteMeta("["))
+
+				continue
+			}
+
+			class := glob[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+
+			sb.WriteString("[" + strings.ReplaceAll(class, "\\", "\\\\") + "]")
+
+			i += end + 1
+
+		case c == '\\' && i+1 < len(glob):
+			i++
+
+			sb.WriteString(regexp.QuoteMeta(string(glob[i])))
+
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+
+	return sb.String()
+}
@@ -0,0 +1,98 @@
+package walk_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/numtide/treefmt/v2/stats"
+	"github.com/numtide/treefmt/v2/test"
+	"github.com/numtide/treefmt/v2/walk"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGitignoreReader(t *testing.T) {
+	as := require.New(t)
+
+	tempDir := test.TempExamples(t)
+
+	writeFile := func(path string, content string) {
+		path = filepath.Join(tempDir, path)
+		as.NoError(os.MkdirAll(filepath.Dir(path), 0o755))
+		as.NoError(os.WriteFile(path, []byte(content), 0o644))
+	}
+
+	writeFile(".gitignore", "# comment\n*.hs\n!haskell/Main.hs\nrust/\n/nixpkgs.toml\n**/src/*.elm\njavascript/**\n")
+	writeFile("haskell-frontend/.gitignore", "*.cabal\n")
+	writeFile(".git/info/exclude", "yaml\n")
+	writeFile(".git/config", "")
+
+	read := func(path string) []string {
+		statz := stats.New()
+		reader := walk.NewGitignoreReader(tempDir, path, walk.SymlinksSkip, &statz, 1024)
+
+		var paths []string
+
+		for {
+			ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+
+			files := make([]*walk.File, 8)
+			n, err := reader.Read(ctx, files)
+
+			for _, file := range files[:n] {
+				paths = append(paths, file.RelPath)
+			}
+
+			cancel()
+
+			if errors.Is(err, io.EOF) {
+				break
+			}
+		}
+
+		as.NoError(reader.Close())
+		as.Len(paths, statz.Value(stats.Traversed))
+
+		return paths
+	}
+
+	as.ElementsMatch([]string{
+		".gitignore",
+		"elm/elm.json",
+		"go/go.mod",
+		"go/main.go",
+		"haskell/CHANGELOG.md",
+		"haskell/Main.hs",
+		"haskell/haskell.cabal",
+		"haskell/treefmt.toml",
+		"haskell-frontend/.gitignore",
+		"haskell-frontend/CHANGELOG.md",
+		"html/index.html",
+		"html/scripts/.gitkeep",
+		"nix/sources.nix",
+		"python/main.py",
+		"python/requirements.txt",
+		"python/virtualenv_proxy.py",
+		"ruby/bundler.rb",
+		"shell/foo.sh",
+		"terraform/main.tf",
+		"terraform/two.tf",
+		"touch.toml",
+		"treefmt.toml",
+	}, read(""))
+
+	// ignore files in parent directories are honoured when traversing a sub directory
+	as.ElementsMatch([]string{
+		"haskell/CHANGELOG.md",
+		"haskell/Main.hs",
+		"haskell/haskell.cabal",
+		"haskell/treefmt.toml",
+	}, read("haskell"))
+
+	// an ignored directory is not traversed
+	as.Empty(read("rust"))
+}
@@ -0,0 +1,149 @@
+package walk
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/charmbracelet/log"
+	"github.com/numtide/treefmt/v2/stats"
+	"golang.org/x/sync/errgroup"
+)
+
+type JujutsuReader struct {
+	root string
+	path string
+
+	// symlinks determines whether the symlinks listed are skipped, followed or reported as an error
+	symlinks *symlinkResolver
+	// pending contains the files found by following a symlink to a directory, which have yet to be read
+	pending []*File
+
+	log   *log.Logger
+	stats *stats.Stats
+
+	eg      *errgroup.Group
+	scanner *bufio.Scanner
+}
+
+func (j *JujutsuReader) Read(ctx context.Context, files []*File) (n int, err error) {
+	// ensure we record how many files we traversed
+	defer func() {
+		j.stats.Add(stats.Traversed, n)
+	}()
+
+	if j.scanner == nil {
+		// create a pipe to capture the command output
+		r, w := io.Pipe()
+
+		// create a command which will execute from the specified sub path within root
+		// we restrict the listing to the current directory, otherwise jj lists every file in the repository
+		cmd := exec.Command("jj", "file", "list", ".")
+		cmd.Dir = filepath.Join(j.root, j.path)
+		cmd.Stdout = w
+
+		// execute the command in the background
+		j.eg.Go(func() error {
+			return w.CloseWithError(cmd.Run())
+		})
+
+		// create a new scanner for reading the output
+		j.scanner = bufio.NewScanner(r)
+	}
+
+LOOP:
+
+	for n < len(files) {
+		select {
+		// exit early if the context was cancelled
+		case <-ctx.Done():
+			return n, ctx.Err()
+
+		default:
+			if len(j.pending) > 0 {
+				files[n] = j.pending[0]
+				j.pending = j.pending[1:]
+				n++
+
+				continue
+			}
+
+			// read the next file
+			if j.scanner.Scan() {
+				path := filepath.Join(j.root, j.path, j.scanner.Text())
+				relPath := filepath.Join(j.path, j.scanner.Text())
+
+				j.log.Debugf("processing file: %s", path)
+
+				info, err := os.Lstat(path)
+				if os.IsNotExist(err) {
+					// the underlying file might have been removed
+					j.log.Warnf(
+						"Path %s is in the working copy but appears to have been removed from the filesystem", path,
+					)
+
+					continue
+				} else if err != nil {
+					return n, fmt.Errorf("failed to stat %s: %w", path, err)
+				} else if info.Mode()&os.ModeSymlink == os.ModeSymlink {
+					followed, err := j.symlinks.follow(j.root, path, relPath)
+					if err != nil {
+						return n, err
+					}
+
+					j.pending = append(j.pending, followed...)
+
+					continue
+				}
+
+				files[n] = &File{
+					Path:    path,
+					RelPath: relPath,
+					Info:    info,
+				}
+				n++
+			} else {
+				// nothing more to read
+				err = io.EOF
+
+				break LOOP
+			}
+		}
+	}
+
+	return n, err
+}
+
+func (j *JujutsuReader) Close() error {
+	return j.eg.Wait()
+}
+
+func NewJujutsuReader(
+	root string,
+	path string,
+	symlinks Symlinks,
+	statz *stats.Stats,
+) (*JujutsuReader, error) {
+	// check if the root is within a jujutsu repository
+	cmd := exec.Command("jj", "root")
+	cmd.Dir = root
+
+	if _, err := cmd.Output(); err != nil {
+		return nil, fmt.Errorf("failed to check if %s is a jujutsu repository: %w", root, err)
+	}
+
+	logger := log.WithPrefix("walk | jujutsu")
+
+	return &JujutsuReader{
+		root:     root,
+		path:     path,
+		symlinks: newSymlinkResolver(symlinks, logger, root, path),
+		stats:    statz,
+		eg:       &errgroup.Group{},
+		log:      logger,
+	}, nil
+}
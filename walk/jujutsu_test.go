@@ -0,0 +1,114 @@
+package walk_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/numtide/treefmt/v2/stats"
+	"github.com/numtide/treefmt/v2/test"
+	"github.com/numtide/treefmt/v2/walk"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJujutsuReader(t *testing.T) {
+	as := require.New(t)
+
+	if _, err := exec.LookPath("jj"); err != nil {
+		t.Skip("jj is not available")
+	}
+
+	tempDir := test.TempExamples(t)
+
+	// not a jujutsu repository
+	statz := stats.New()
+	_, err := walk.NewJujutsuReader(tempDir, "", walk.SymlinksSkip, &statz)
+	as.Error(err)
+
+	// init a jujutsu repo
+	t.Setenv("JJ_USER", "Treefmt Test")
+	t.Setenv("JJ_EMAIL", "test@treefmt.com")
+
+	cmd := exec.Command("jj", "git", "init")
+	cmd.Dir = tempDir
+	as.NoError(cmd.Run(), "failed to init jujutsu repository")
+
+	read := func(path string) int {
+		reader, err := walk.NewJujutsuReader(tempDir, path, walk.SymlinksSkip, &statz)
+		as.NoError(err)
+
+		count := 0
+
+		for {
+			ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+
+			files := make([]*walk.File, 8)
+			n, err := reader.Read(ctx, files)
+
+			count += n
+
+			cancel()
+
+			if errors.Is(err, io.EOF) {
+				break
+			}
+		}
+
+		as.NoError(reader.Close())
+
+		return count
+	}
+
+	// jujutsu automatically tracks new files in the working copy
+	as.Equal(32, read(""))
+	as.Equal(32, statz.Value(stats.Traversed))
+	as.Equal(0, statz.Value(stats.Matched))
+	as.Equal(0, statz.Value(stats.Formatted))
+	as.Equal(0, statz.Value(stats.Changed))
+
+	// read a sub directory
+	as.Equal(2, read("elm"))
+	as.Equal(34, statz.Value(stats.Traversed))
+}
+
+func TestAutoReaderJujutsu(t *testing.T) {
+	as := require.New(t)
+
+	tempDir := test.TempExamples(t)
+
+	cmd := exec.Command("git", "init")
+	cmd.Dir = tempDir
+	as.NoError(cmd.Run(), "failed to init git repository")
+
+	// replace jj with a script which records that it was run, failing as if the tree root is not a jujutsu repository
+	binDir := t.TempDir()
+	marker := filepath.Join(t.TempDir(), "jj-called")
+
+	script := "#!/bin/sh\ntouch " + marker + "\nexit 1\n"
+	as.NoError(os.WriteFile(filepath.Join(binDir, "jj"), []byte(script), 0o755)) //nolint:gosec
+
+	t.Setenv("PATH", binDir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	statz := stats.New()
+
+	// jujutsu is not tried without a .jj directory
+	reader, err := walk.NewReader(walk.Auto, tempDir, "", "", walk.SymlinksSkip, nil, &statz)
+	as.NoError(err)
+	as.IsType(&walk.GitReader{}, reader)
+	as.NoFileExists(marker)
+	as.NoError(reader.Close())
+
+	// with one, it is tried first, falling back to git
+	as.NoError(os.Mkdir(filepath.Join(tempDir, ".jj"), 0o755))
+
+	reader, err = walk.NewReader(walk.Auto, tempDir, "", "", walk.SymlinksSkip, nil, &statz)
+	as.NoError(err)
+	as.IsType(&walk.GitReader{}, reader)
+	as.FileExists(marker)
+	as.NoError(reader.Close())
+}
@@ -0,0 +1,112 @@
+package walk
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/numtide/treefmt/v2/stats"
+)
+
+// Factory creates a Reader which traverses path, relative to root, or the whole of root if path is empty.
+// It should return an error if root cannot be traversed, e.g. because it is not within the kind of repository the
+// Reader supports.
+type Factory func(root string, path string, statz *stats.Stats) (Reader, error)
+
+// registry contains the walkers added with Register, which are assigned a Type following those built into treefmt.
+var registry = struct {
+	lock      sync.RWMutex
+	types     map[string]Type
+	names     map[Type]string
+	factories map[Type]Factory
+}{
+	types:     make(map[string]Type),
+	names:     make(map[Type]string),
+	factories: make(map[Type]Factory),
+}
+
+// Register adds a walker, e.g. for another version control system, which can then be selected by name with --walk.
+// It returns the Type assigned to the walker, which can be passed to NewReader.
+// Registered walkers do not support --since, and are not tried when the walk type is auto.
+func Register(name string, factory Factory) (Type, error) {
+	if name == "" {
+		return 0, errors.New("walk type must have a name")
+	} else if factory == nil {
+		return 0, fmt.Errorf("walk type %s must have a factory", name)
+	}
+
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+
+	if _, err := TypeString(name); err == nil {
+		return 0, fmt.Errorf("walk type %s is already defined", name)
+	} else if _, ok := registry.types[name]; ok {
+		return 0, fmt.Errorf("walk type %s is already defined", name)
+	}
+
+	t := Type(len(TypeValues()) + len(registry.types))
+
+	registry.types[name] = t
+	registry.names[t] = name
+	registry.factories[t] = factory
+
+	return t, nil
+}
+
+// ParseType returns the Type of the walker with the given name, whether it is built into treefmt or was added with
+// Register.
+func ParseType(name string) (Type, error) {
+	if t, err := TypeString(name); err == nil {
+		return t, nil
+	}
+
+	registry.lock.RLock()
+	t, ok := registry.types[name]
+	registry.lock.RUnlock()
+
+	if !ok {
+		return 0, fmt.Errorf("unknown walk type %s, must be one of <%s>", name, strings.Join(TypeNames(), "|"))
+	}
+
+	return t, nil
+}
+
+// TypeNames returns the names of every walker, those built into treefmt followed by any added with Register in
+// lexicographic order.
+func TypeNames() []string {
+	registry.lock.RLock()
+	defer registry.lock.RUnlock()
+
+	names := make([]string, 0, len(registry.types))
+	for name := range registry.types {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return append(TypeStrings(), names...)
+}
+
+// Name returns the name of the walker, which unlike String includes those added with Register.
+func (i Type) Name() string {
+	registry.lock.RLock()
+	defer registry.lock.RUnlock()
+
+	if name, ok := registry.names[i]; ok {
+		return name
+	}
+
+	return i.String()
+}
+
+// registered returns the factory of a walker added with Register, if any.
+func registered(t Type) (Factory, bool) {
+	registry.lock.RLock()
+	defer registry.lock.RUnlock()
+
+	factory, ok := registry.factories[t]
+
+	return factory, ok
+}
@@ -0,0 +1,65 @@
+package walk_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/numtide/treefmt/v2/stats"
+	"github.com/numtide/treefmt/v2/test"
+	"github.com/numtide/treefmt/v2/walk"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegister(t *testing.T) {
+	as := require.New(t)
+
+	tempDir := test.TempExamples(t)
+	statz := stats.New()
+
+	// a walker which only traverses the go directory
+	walkType, err := walk.Register("go-only", func(root string, _ string, statz *stats.Stats) (walk.Reader, error) {
+		return walk.NewFilesystemReader(root, "go", walk.SymlinksSkip, statz, walk.BatchSize), nil
+	})
+	as.NoError(err)
+	as.Equal("go-only", walkType.Name())
+	as.Contains(walk.TypeNames(), "go-only")
+
+	parsed, err := walk.ParseType("go-only")
+	as.NoError(err)
+	as.Equal(walkType, parsed)
+
+	reader, err := walk.NewReader(walkType, tempDir, "", "", walk.SymlinksSkip, nil, &statz)
+	as.NoError(err)
+
+	files := make([]*walk.File, 8)
+	n, err := reader.Read(context.Background(), files)
+
+	if !errors.Is(err, io.EOF) {
+		as.NoError(err)
+	}
+
+	as.Equal(2, n)
+	as.Equal("go/go.mod", files[0].RelPath)
+	as.Equal("go/main.go", files[1].RelPath)
+
+	// only the git walker supports --since
+	_, err = walk.NewReader(walkType, tempDir, "", "HEAD~1", walk.SymlinksSkip, nil, &statz)
+	as.ErrorContains(err, "the go-only walk type does not support the --since flag")
+
+	// names must be unique
+	_, err = walk.Register("go-only", func(string, string, *stats.Stats) (walk.Reader, error) {
+		return nil, nil
+	})
+	as.ErrorContains(err, "walk type go-only is already defined")
+
+	_, err = walk.Register("git", func(string, string, *stats.Stats) (walk.Reader, error) {
+		return nil, nil
+	})
+	as.ErrorContains(err, "walk type git is already defined")
+
+	_, err = walk.ParseType("perforce")
+	as.ErrorContains(err, "unknown walk type perforce, must be one of "+
+		"<auto|stdin|filesystem|git|jujutsu|gitignore|go-only>")
+}
@@ -0,0 +1,155 @@
+package walk
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// HeadSize is the maximum number of bytes read from the start of a file when inspecting its content.
+const HeadSize = 4096
+
+// Head returns up to the first HeadSize bytes of the file's content.
+// The content is read on first use and cached, allowing it to be inspected by multiple formatters.
+func (f *File) Head() ([]byte, error) {
+	if f.headRead {
+		return f.head, nil
+	}
+
+	file, err := os.Open(f.Path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", f.Path, err)
+	}
+	defer file.Close()
+
+	head := make([]byte, HeadSize)
+
+	n, err := io.ReadFull(file, head)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return nil, fmt.Errorf("failed to read %s: %w", f.Path, err)
+	}
+
+	f.head = head[:n]
+	f.headRead = true
+
+	return f.head, nil
+}
+
+// FirstLine returns the first line of the file's content, without any line ending.
+// Lines longer than HeadSize are truncated.
+func (f *File) FirstLine() (string, error) {
+	head, err := f.Head()
+	if err != nil {
+		return "", err
+	}
+
+	if idx := bytes.IndexByte(head, '\n'); idx >= 0 {
+		head = head[:idx]
+	}
+
+	return string(bytes.TrimSuffix(head, []byte("\r"))), nil
+}
+
+// Interpreter returns the name of the interpreter specified by the file's shebang line, or an empty string if the
+// file has an extension or no shebang.
+// Both direct shebangs such as `#!/bin/bash` and those using env such as `#!/usr/bin/env -S python3 -u` are supported.
+func (f *File) Interpreter() (string, error) {
+	// only extensionless files are inspected, others are expected to be matched by their extension
+	if filepath.Ext(f.RelPath) != "" {
+		return "", nil
+	}
+
+	head, err := f.Head()
+	if err != nil {
+		return "", err
+	}
+
+	return shebangInterpreter(head), nil
+}
+
+// shebangInterpreter returns the name of the interpreter specified by a shebang at the start of head, if any.
+func shebangInterpreter(head []byte) string {
+	line, ok := bytes.CutPrefix(head, []byte("#!"))
+	if !ok {
+		return ""
+	}
+
+	if idx := bytes.IndexByte(line, '\n'); idx >= 0 {
+		line = line[:idx]
+	}
+
+	fields := strings.Fields(string(line))
+	if len(fields) == 0 {
+		return ""
+	}
+
+	interpreter := filepath.Base(fields[0])
+
+	if interpreter == "env" {
+		// skip any options passed to env, as well as environment variable assignments
+		interpreter = ""
+
+		for _, field := range fields[1:] {
+			if !strings.HasPrefix(field, "-") && !strings.Contains(field, "=") {
+				interpreter = filepath.Base(field)
+
+				break
+			}
+		}
+	}
+
+	return interpreter
+}
+
+// HasConflictMarkers reports whether the file contains the markers git leaves around an unresolved merge conflict,
+// i.e. a line beginning with <<<<<<< which is followed by a line beginning with >>>>>>>.
+// Both markers must be followed by a space or the end of the line, so lines which merely start with them, such as a
+// row of arrows in a comment, are ignored.
+func (f *File) HasConflictMarkers() (bool, error) {
+	file, err := os.Open(f.Path)
+	if err != nil {
+		return false, fmt.Errorf("failed to open %s: %w", f.Path, err)
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+
+	// whether the start of a conflict has been found, after which we look for its end
+	inConflict := false
+	// whether the next read is at the start of a line, as long lines are read in several parts
+	lineStart := true
+
+	for {
+		line, isPrefix, err := reader.ReadLine()
+		if errors.Is(err, io.EOF) {
+			return false, nil
+		} else if err != nil {
+			return false, fmt.Errorf("failed to read %s: %w", f.Path, err)
+		}
+
+		if lineStart && inConflict && isConflictMarker(line, conflictEnd) {
+			return true, nil
+		} else if lineStart && isConflictMarker(line, conflictStart) {
+			inConflict = true
+		}
+
+		lineStart = !isPrefix
+	}
+}
+
+var (
+	conflictStart = []byte("<<<<<<<")
+	conflictEnd   = []byte(">>>>>>>")
+)
+
+// isConflictMarker reports whether line begins with marker, followed by a space or nothing at all.
+func isConflictMarker(line []byte, marker []byte) bool {
+	rest, ok := bytes.CutPrefix(line, marker)
+
+	return ok && (len(rest) == 0 || rest[0] == ' ')
+}
@@ -0,0 +1,108 @@
+package walk_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/numtide/treefmt/v2/walk"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInterpreter(t *testing.T) {
+	as := require.New(t)
+
+	tempDir := t.TempDir()
+
+	for _, tc := range []struct {
+		name        string
+		content     string
+		interpreter string
+	}{
+		{"bash", "#!/bin/bash\necho hello\n", "bash"},
+		{"bash-options", "#!/bin/bash -eu\necho hello\n", "bash"},
+		{"env", "#!/usr/bin/env python3\nprint('hello')\n", "python3"},
+		{"env-split", "#!/usr/bin/env -S FOO=bar python3 -u\nprint('hello')\n", "python3"},
+		{"spaces", "#! /usr/bin/env  sh\n", "sh"},
+		{"no-newline", "#!/bin/zsh", "zsh"},
+		{"empty-shebang", "#!\n", ""},
+		{"no-shebang", "echo hello\n", ""},
+		{"empty", "", ""},
+		{"extension.sh", "#!/bin/bash\n", ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(tempDir, tc.name)
+			as.NoError(os.WriteFile(path, []byte(tc.content), 0o600))
+
+			file := &walk.File{Path: path, RelPath: tc.name}
+
+			interpreter, err := file.Interpreter()
+			as.NoError(err)
+			as.Equal(tc.interpreter, interpreter)
+		})
+	}
+}
+
+func TestFirstLine(t *testing.T) {
+	as := require.New(t)
+
+	tempDir := t.TempDir()
+
+	for _, tc := range []struct {
+		name    string
+		content string
+		line    string
+	}{
+		{"lf", "first\nsecond\n", "first"},
+		{"crlf", "first\r\nsecond\r\n", "first"},
+		{"no-newline", "only", "only"},
+		{"empty", "", ""},
+		{"blank", "\nsecond\n", ""},
+		{"long", strings.Repeat("a", walk.HeadSize+10), strings.Repeat("a", walk.HeadSize)},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(tempDir, tc.name)
+			as.NoError(os.WriteFile(path, []byte(tc.content), 0o600))
+
+			file := &walk.File{Path: path, RelPath: tc.name}
+
+			line, err := file.FirstLine()
+			as.NoError(err)
+			as.Equal(tc.line, line)
+		})
+	}
+}
+
+func TestHasConflictMarkers(t *testing.T) {
+	as := require.New(t)
+
+	tempDir := t.TempDir()
+
+	for _, tc := range []struct {
+		name       string
+		content    string
+		conflicted bool
+	}{
+		{"conflict", "a\n<<<<<<< HEAD\nb\n=======\nc\n>>>>>>> feature\n", true},
+		{"diff3", "<<<<<<< ours\nb\n||||||| base\na\n=======\nc\n>>>>>>> theirs\n", true},
+		{"bare-markers", "<<<<<<<\nb\n>>>>>>>", true},
+		{"crlf", "<<<<<<< HEAD\r\nb\r\n=======\r\nc\r\n>>>>>>> feature\r\n", true},
+		{"long-line", strings.Repeat("x", 8192) + "\n<<<<<<< HEAD\n>>>>>>> feature\n", true},
+		{"start-only", "<<<<<<< HEAD\nb\n", false},
+		{"end-before-start", ">>>>>>> feature\n<<<<<<< HEAD\n", false},
+		{"arrows", "<<<<<<<<<<\n>>>>>>>>>>\n", false},
+		{"indented", "  <<<<<<< HEAD\n  >>>>>>> feature\n", false},
+		{"mid-line", strings.Repeat("x", 8192) + "<<<<<<< HEAD\n" + strings.Repeat("x", 8192) + ">>>>>>> f\n", false},
+		{"empty", "", false},
+	} {
+		path := filepath.Join(tempDir, tc.name)
+		as.NoError(os.WriteFile(path, []byte(tc.content), 0o600))
+
+		file := &walk.File{Path: path, RelPath: tc.name}
+
+		conflicted, err := file.HasConflictMarkers()
+		as.NoError(err, tc.name)
+		as.Equal(tc.conflicted, conflicted, tc.name)
+	}
+}
@@ -0,0 +1,363 @@
+package walk
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/charmbracelet/log"
+	"github.com/numtide/treefmt/v2/stats"
+)
+
+// StagedReader reads the files which are staged in the git index, for use in a pre-commit hook.
+//
+// Files which are only partially staged have their staged content swapped into the worktree whilst they are formatted,
+// so the unstaged changes are neither formatted nor committed. Once a file has been formatted, it is added to the index
+// again, and its unstaged changes are restored with the formatting merged into them. Files which fail to be formatted
+// are not added to the index.
+type StagedReader struct {
+	root  string
+	log   *log.Logger
+	stats *stats.Stats
+
+	// paths are the staged files, relative to root, which have yet to be read
+	paths []string
+	// partial contains the paths which have unstaged changes as well as staged ones
+	partial map[string]bool
+
+	// backupDir holds the worktree content of partially staged files whilst their staged content is formatted
+	backupDir string
+
+	// lock guards the index, which git does not allow to be updated concurrently, and the fields below
+	lock sync.Mutex
+	// backups are the paths at which the worktree content of partially staged files is backed up, keyed by the path of
+	// the file, until it is restored
+	backups map[string]string
+	// bases are the paths at which the staged content of partially staged files is kept from before they were
+	// formatted, keyed by the path of the file, so the formatting can be merged into the unstaged changes
+	bases map[string]string
+	// staged are the fully staged files which have been formatted, and should be added to the index once more
+	staged []string
+}
+
+func (s *StagedReader) Read(ctx context.Context, files []*File) (n int, err error) {
+	// ensure we record how many files we traversed
+	defer func() {
+		s.stats.Add(stats.Traversed, n)
+	}()
+
+	for n < len(files) && len(s.paths) > 0 {
+		if err = ctx.Err(); err != nil {
+			return n, err
+		}
+
+		relPath := s.paths[0]
+		s.paths = s.paths[1:]
+
+		path := filepath.Join(s.root, relPath)
+
+		info, err := os.Lstat(path)
+		if os.IsNotExist(err) {
+			s.log.Warnf("Path %s is staged but appears to have been removed from the filesystem", path)
+
+			continue
+		} else if err != nil {
+			return n, fmt.Errorf("failed to stat %s: %w", path, err)
+		} else if !info.Mode().IsRegular() {
+			// symlinks and submodules are left alone
+			continue
+		}
+
+		file := &File{
+			Path:    path,
+			RelPath: relPath,
+			Info:    info,
+		}
+
+		if s.partial[relPath] {
+			if file.Info, err = s.checkoutStaged(relPath); err != nil {
+				return n, err
+			}
+
+			file.AddReleaseFunc(func(ctx context.Context) error {
+				// files which were not formatted, or failed to be, are left as they were
+				if GetNoCache(ctx) {
+					s.lock.Lock()
+					defer s.lock.Unlock()
+
+					return s.restore(path)
+				}
+
+				return s.restage(relPath)
+			})
+		} else {
+			file.AddReleaseFunc(func(ctx context.Context) error {
+				// a formatter which failed may have left the file half formatted, which should not be committed
+				if GetNoCache(ctx) {
+					return nil
+				}
+
+				s.lock.Lock()
+				defer s.lock.Unlock()
+
+				s.staged = append(s.staged, relPath)
+
+				return nil
+			})
+		}
+
+		s.log.Debugf("processing file: %s", path)
+
+		files[n] = file
+		n++
+	}
+
+	if len(s.paths) == 0 {
+		err = io.EOF
+	}
+
+	return n, err
+}
+
+// checkoutStaged backs up the worktree content of a partially staged file, before replacing it with the staged
+// content.
+func (s *StagedReader) checkoutStaged(relPath string) (os.FileInfo, error) {
+	path := filepath.Join(s.root, relPath)
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	// filters are applied in the same way as they would be when checking out the file
+	staged, err := git(s.root, nil, "cat-file", "--filters", ":./"+filepath.ToSlash(relPath))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the staged content of %s: %w", relPath, err)
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	backup, err := s.backup(content)
+	if err != nil {
+		return nil, fmt.Errorf("failed to back up %s: %w", path, err)
+	}
+
+	s.backups[path] = backup
+
+	base, err := s.backup(staged)
+	if err != nil {
+		return nil, fmt.Errorf("failed to back up the staged content of %s: %w", path, err)
+	}
+
+	s.bases[path] = base
+
+	if err = os.WriteFile(path, staged, 0o600); err != nil {
+		return nil, fmt.Errorf("failed to write the staged content of %s: %w", path, err)
+	}
+
+	return os.Lstat(path)
+}
+
+// backup writes content to a new file within the backup directory, returning its path.
+// The caller must hold the lock.
+func (s *StagedReader) backup(content []byte) (string, error) {
+	f, err := os.CreateTemp(s.backupDir, "")
+	if err != nil {
+		return "", err
+	}
+
+	_, err = f.Write(content)
+
+	return f.Name(), errors.Join(err, f.Close())
+}
+
+// restage adds the formatted staged content of a partially staged file to the index, before restoring its unstaged
+// changes with the formatting merged into them.
+func (s *StagedReader) restage(relPath string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if _, err := git(s.root, nil, "add", "--", relPath); err != nil {
+		return fmt.Errorf("failed to stage %s: %w", relPath, err)
+	}
+
+	path := filepath.Join(s.root, relPath)
+
+	if err := s.merge(path); err != nil {
+		return err
+	}
+
+	return s.restore(path)
+}
+
+// merge applies the changes made by formatting the staged content of the file at path to the backup of its unstaged
+// changes. If they conflict, the backup is left as it is, so the unstaged changes are restored unformatted.
+// The caller must hold the lock.
+func (s *StagedReader) merge(path string) error {
+	backup, base := s.backups[path], s.bases[path]
+
+	merged, err := git(s.root, nil, "merge-file", "--stdout", "--quiet", backup, base, path)
+
+	// the exit code is the number of conflicts, whilst errors are reported with a negative exit code
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() > 0 && exitErr.ExitCode() < 128 {
+		s.log.Warnf("the unstaged changes of %s conflict with how it was formatted, leaving them unformatted", path)
+
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to merge the formatting of %s into its unstaged changes: %w", path, err)
+	}
+
+	if err = os.WriteFile(backup, merged, 0o600); err != nil {
+		return fmt.Errorf("failed to merge the formatting of %s into its unstaged changes: %w", path, err)
+	}
+
+	return nil
+}
+
+// restore copies the backup of the file at path over it, then removes the backup.
+// The caller must hold the lock.
+func (s *StagedReader) restore(path string) error {
+	backup, ok := s.backups[path]
+	if !ok {
+		return nil
+	}
+
+	if base, ok := s.bases[path]; ok {
+		if err := os.Remove(base); err != nil {
+			return fmt.Errorf("failed to remove the staged content of %s: %w", path, err)
+		}
+
+		delete(s.bases, path)
+	}
+
+	content, err := os.ReadFile(backup)
+	if err != nil {
+		return fmt.Errorf("failed to read the backup of %s: %w", path, err)
+	} else if err = os.WriteFile(path, content, 0o600); err != nil {
+		return fmt.Errorf("failed to restore the unstaged changes of %s, which are backed up in %s: %w", path, backup, err)
+	}
+
+	delete(s.backups, path)
+
+	return os.Remove(backup)
+}
+
+// Close adds the formatted files which were fully staged to the index, and restores the unstaged changes of any
+// partially staged files which were not released, e.g. because formatting was interrupted.
+func (s *StagedReader) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	var errs []error
+
+	for path := range s.backups {
+		errs = append(errs, s.restore(path))
+	}
+
+	if len(s.backups) == 0 {
+		errs = append(errs, os.RemoveAll(s.backupDir))
+	}
+
+	if len(s.staged) > 0 {
+		pathspecs := strings.Join(s.staged, "\x00")
+
+		if _, err := git(
+			s.root, strings.NewReader(pathspecs), "add", "--pathspec-from-file=-", "--pathspec-file-nul",
+		); err != nil {
+			errs = append(errs, fmt.Errorf("failed to stage formatted files: %w", err))
+		}
+
+		s.staged = nil
+	}
+
+	return errors.Join(errs...)
+}
+
+// NewStagedReader creates a reader which traverses the files staged in the git index, restricted to the given paths,
+// relative to root, if any are provided.
+func NewStagedReader(root string, paths []string, statz *stats.Stats) (*StagedReader, error) {
+	if out, err := git(root, nil, "rev-parse", "--is-inside-work-tree"); err != nil {
+		return nil, fmt.Errorf("failed to check if %s is a git repository: %w", root, err)
+	} else if strings.TrimSpace(string(out)) != "true" {
+		return nil, fmt.Errorf("%s is not a git repository", root)
+	}
+
+	// deleted files have nothing to format
+	staged, err := git(root, nil, append([]string{
+		"diff", "--cached", "--name-only", "--relative", "--diff-filter=d", "-z", "--",
+	}, paths...)...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list staged files: %w", err)
+	}
+
+	// files with differences between the index and the worktree are partially staged
+	unstaged, err := git(root, nil, append([]string{"diff", "--name-only", "--relative", "-z", "--"}, paths...)...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list unstaged files: %w", err)
+	}
+
+	partial := make(map[string]bool)
+	for _, path := range splitNul(unstaged) {
+		partial[filepath.FromSlash(path)] = true
+	}
+
+	backupDir, err := os.MkdirTemp("", "treefmt-staged-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create a directory for backing up unstaged changes: %w", err)
+	}
+
+	s := &StagedReader{
+		root:      root,
+		log:       log.WithPrefix("walk | staged"),
+		stats:     statz,
+		partial:   partial,
+		backupDir: backupDir,
+		backups:   make(map[string]string),
+		bases:     make(map[string]string),
+	}
+
+	for _, path := range splitNul(staged) {
+		s.paths = append(s.paths, filepath.FromSlash(path))
+	}
+
+	return s, nil
+}
+
+// git runs git in dir with the given stdin, returning its stdout, or its stderr within the error if it fails.
+func git(dir string, stdin io.Reader, args ...string) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	cmd.Stdin = stdin
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("failed to run git %s: %w: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+
+	return stdout.Bytes(), nil
+}
+
+// splitNul splits the NUL separated output of git into its entries.
+func splitNul(out []byte) []string {
+	var entries []string
+
+	for _, entry := range bytes.Split(out, []byte{0}) {
+		if len(entry) > 0 {
+			entries = append(entries, string(entry))
+		}
+	}
+
+	return entries
+}
@@ -0,0 +1,164 @@
+package walk_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/numtide/treefmt/v2/stats"
+	"github.com/numtide/treefmt/v2/walk"
+	"github.com/stretchr/testify/require"
+)
+
+// stagedRepo creates a git repository in a temp dir, returning its path along with functions for running git within
+// it and writing files to it.
+func stagedRepo(t *testing.T) (string, func(args ...string) string, func(path string, content string)) {
+	t.Helper()
+
+	as := require.New(t)
+
+	tempDir := t.TempDir()
+
+	git := func(args ...string) string {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = tempDir
+		out, err := cmd.Output()
+		as.NoError(err, "failed to run git %v", args)
+
+		return string(out)
+	}
+
+	write := func(path string, content string) {
+		as.NoError(os.WriteFile(filepath.Join(tempDir, path), []byte(content), 0o600))
+	}
+
+	git("init")
+
+	return tempDir, git, write
+}
+
+// readStaged reads every file from reader, passing each to format before releasing it, and returns their paths once
+// the reader has been closed. Files are released as if they failed to be formatted if failed is set.
+func readStaged(t *testing.T, reader *walk.StagedReader, failed bool, format func(content []byte) []byte) []string {
+	t.Helper()
+
+	as := require.New(t)
+
+	// files which fail to be formatted are released without updating the cache
+	releaseCtx := walk.SetNoCache(context.Background(), failed)
+
+	var paths []string
+
+	for {
+		files := make([]*walk.File, 8)
+		n, err := reader.Read(context.Background(), files)
+
+		for _, file := range files[:n] {
+			paths = append(paths, file.RelPath)
+
+			// only the staged content is formatted
+			content, readErr := os.ReadFile(file.Path)
+			as.NoError(readErr)
+			as.NotContains(string(content), "unstaged")
+
+			as.NoError(os.WriteFile(file.Path, format(content), 0o600))
+			as.NoError(file.Release(releaseCtx))
+		}
+
+		if errors.Is(err, io.EOF) {
+			break
+		}
+
+		as.NoError(err)
+	}
+
+	as.NoError(reader.Close())
+
+	return paths
+}
+
+// upperFirstLine formats content by upper casing its first line.
+func upperFirstLine(content []byte) []byte {
+	first, rest, _ := bytes.Cut(content, []byte("\n"))
+
+	return append(append(bytes.ToUpper(first), '\n'), rest...)
+}
+
+func TestStagedReader(t *testing.T) {
+	as := require.New(t)
+
+	tempDir, git, write := stagedRepo(t)
+
+	// a is fully staged, b and d are partially staged and c is not staged at all
+	write("a.txt", "a\n")
+	write("b.txt", "b\none\ntwo\n")
+	write("d.txt", "d\n")
+	git("add", "a.txt", "b.txt", "d.txt")
+	write("b.txt", "b\none\ntwo\nunstaged\n")
+	write("c.txt", "c\n")
+	// the unstaged changes of d overlap with how it will be formatted
+	write("d.txt", "d unstaged\n")
+
+	statz := stats.New()
+	reader, err := walk.NewStagedReader(tempDir, nil, &statz)
+	as.NoError(err)
+
+	paths := readStaged(t, reader, false, upperFirstLine)
+
+	as.ElementsMatch([]string{"a.txt", "b.txt", "d.txt"}, paths)
+	as.Equal(3, statz.Value(stats.Traversed))
+
+	// the formatted content was staged
+	as.Equal("A\n", git("show", ":a.txt"))
+	as.Equal("B\none\ntwo\n", git("show", ":b.txt"))
+	as.Equal("D\n", git("show", ":d.txt"))
+
+	// whilst the unstaged changes were restored, with the formatting merged into them where they don't conflict
+	content, err := os.ReadFile(filepath.Join(tempDir, "b.txt"))
+	as.NoError(err)
+	as.Equal("B\none\ntwo\nunstaged\n", string(content))
+
+	content, err = os.ReadFile(filepath.Join(tempDir, "d.txt"))
+	as.NoError(err)
+	as.Equal("d unstaged\n", string(content))
+
+	as.Equal("", git("diff", "--name-only", "--", "a.txt"))
+	as.Equal("?? c.txt\n", git("status", "--porcelain", "--", "c.txt"))
+}
+
+func TestStagedReaderFailure(t *testing.T) {
+	as := require.New(t)
+
+	tempDir, git, write := stagedRepo(t)
+
+	// a is fully staged, whilst b is partially staged
+	write("a.txt", "a\n")
+	write("b.txt", "b\n")
+	git("add", "a.txt", "b.txt")
+	write("b.txt", "b\nunstaged\n")
+
+	statz := stats.New()
+	reader, err := walk.NewStagedReader(tempDir, nil, &statz)
+	as.NoError(err)
+
+	paths := readStaged(t, reader, true, func(content []byte) []byte {
+		// a formatter which fails part way through may leave a file half formatted
+		return append(bytes.ToUpper(content), "half"...)
+	})
+
+	as.ElementsMatch([]string{"a.txt", "b.txt"}, paths)
+
+	// nothing was staged
+	as.Equal("a\n", git("show", ":a.txt"))
+	as.Equal("b\n", git("show", ":b.txt"))
+
+	// the unstaged changes were restored as they were
+	content, err := os.ReadFile(filepath.Join(tempDir, "b.txt"))
+	as.NoError(err)
+	as.Equal("b\nunstaged\n", string(content))
+}
@@ -11,10 +11,11 @@ import (
 )
 
 type StdinReader struct {
-	root  string
-	path  string
-	stats stats.Stats
-	input *os.File
+	root   string
+	path   string
+	stats  stats.Stats
+	input  io.Reader
+	output io.Writer
 
 	complete bool
 }
@@ -53,7 +54,7 @@ func (s StdinReader) Read(_ context.Context, files []*File) (n int, err error) {
 		Info:    info,
 	}
 
-	// dump the temp file to stdout and remove it once the file is finished being processed
+	// dump the temp file to the output and remove it once the file is finished being processed
 	files[0].AddReleaseFunc(func(_ context.Context) error {
 		// open the temp file
 		file, err := os.Open(file.Name())
@@ -61,9 +62,9 @@ func (s StdinReader) Read(_ context.Context, files []*File) (n int, err error) {
 			return fmt.Errorf("failed to open temp file %s: %w", file.Name(), err)
 		}
 
-		// dump file into stdout
-		if _, err = io.Copy(os.Stdout, file); err != nil {
-			return fmt.Errorf("failed to copy %s to stdout: %w", file.Name(), err)
+		// dump file into the output
+		if _, err = io.Copy(s.output, file); err != nil {
+			return fmt.Errorf("failed to copy %s to output: %w", file.Name(), err)
 		}
 
 		if err = file.Close(); err != nil {
@@ -89,10 +90,18 @@ func (s StdinReader) Close() error {
 }
 
 func NewStdinReader(root string, path string, statz *stats.Stats) StdinReader {
+	return NewBufferReader(root, path, statz, os.Stdin, os.Stdout)
+}
+
+// NewBufferReader creates a reader which behaves like a StdinReader, but reads the content to be formatted from input
+// and writes the formatted result to output.
+// This allows content which is not backed by a file in the tree, such as an editor buffer, to be formatted.
+func NewBufferReader(root string, path string, statz *stats.Stats, input io.Reader, output io.Writer) StdinReader {
 	return StdinReader{
-		root:  root,
-		path:  path,
-		stats: *statz,
-		input: os.Stdin,
+		root:   root,
+		path:   path,
+		stats:  *statz,
+		input:  input,
+		output: output,
 	}
 }
@@ -0,0 +1,186 @@
+package walk
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/charmbracelet/log"
+)
+
+//go:generate enumer -type=Symlinks -text -trimprefix=Symlinks -transform=snake -output=./symlinks_enum.go
+type Symlinks int
+
+const (
+	// SymlinksSkip leaves symlinks, and whatever they point to, alone.
+	SymlinksSkip Symlinks = iota
+	// SymlinksFollow traverses the target of each symlink as if it were located at the symlink.
+	SymlinksFollow
+	// SymlinksError fails the traversal upon encountering a symlink.
+	SymlinksError
+)
+
+// symlinkResolver decides what a reader should do with each of the symlinks it encounters.
+type symlinkResolver struct {
+	mode Symlinks
+	log  *log.Logger
+
+	// base is the real path of the directory being traversed, the targets within which are traversed directly.
+	// It is empty if the path being traversed is itself a symlink.
+	base string
+	// followed contains the real paths of the targets which have been followed, so they are only traversed once
+	followed map[string]bool
+}
+
+// resolve returns the real path and info of the target of the symlink at path, relative to the tree root as relPath,
+// if it should be followed. Otherwise, the returned info is nil.
+func (s *symlinkResolver) resolve(path string, relPath string) (string, fs.FileInfo, error) {
+	switch s.mode {
+	case SymlinksFollow:
+	case SymlinksError:
+		return "", nil, fmt.Errorf("%s is a symlink, use --symlinks to skip or follow symlinks", relPath)
+	default:
+		s.log.Debugf("skipping symlink %s", relPath)
+
+		return "", nil, nil
+	}
+
+	target, err := filepath.EvalSymlinks(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		s.log.Warnf("skipping symlink %s as its target does not exist", relPath)
+
+		return "", nil, nil
+	} else if err != nil {
+		return "", nil, fmt.Errorf("failed to resolve symlink %s: %w", relPath, err)
+	}
+
+	info, err := os.Stat(target)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to stat target of symlink %s: %w", relPath, err)
+	}
+
+	parent, err := filepath.EvalSymlinks(filepath.Dir(path))
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to resolve parent of symlink %s: %w", relPath, err)
+	}
+
+	switch {
+	case info.IsDir() && within(parent, target):
+		s.log.Warnf("skipping symlink %s as it points to one of its parents, forming a cycle", relPath)
+
+		return "", nil, nil
+	case s.base != "" && within(target, s.base):
+		s.log.Debugf("skipping symlink %s as its target is traversed directly", relPath)
+
+		return "", nil, nil
+	}
+
+	for dir := target; ; dir = filepath.Dir(dir) {
+		if s.followed[dir] {
+			s.log.Debugf("skipping symlink %s as its target has already been traversed", relPath)
+
+			return "", nil, nil
+		} else if dir == filepath.Dir(dir) {
+			break
+		}
+	}
+
+	s.followed[target] = true
+
+	return target, info, nil
+}
+
+// walk traverses dir, the real path of the directory located at relDir relative to root, calling fn for each entry as
+// if dir were located at relDir. Any symlinks are passed to fn before being resolved, and are skipped if it returns
+// filepath.SkipDir. Otherwise, those which are followed are passed to fn again with the info of their target, with
+// directories being traversed in turn.
+func (s *symlinkResolver) walk(
+	root string,
+	dir string,
+	relDir string,
+	fn func(path string, relPath string, info fs.FileInfo) error,
+) error {
+	return filepath.Walk(dir, func(realPath string, info fs.FileInfo, err error) error {
+		// return errors immediately
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(dir, realPath)
+		if err != nil {
+			return fmt.Errorf("failed to determine a relative path for %s: %w", realPath, err)
+		}
+
+		relPath := filepath.Join(relDir, rel)
+		path := filepath.Join(root, relPath)
+
+		err = fn(path, relPath, info)
+		if info.Mode()&os.ModeSymlink == 0 {
+			return err
+		} else if errors.Is(err, filepath.SkipDir) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		target, targetInfo, err := s.resolve(realPath, relPath)
+		if err != nil || targetInfo == nil {
+			return err
+		} else if targetInfo.IsDir() {
+			return s.walk(root, target, relPath, fn)
+		}
+
+		return fn(path, relPath, targetInfo)
+	})
+}
+
+func newSymlinkResolver(mode Symlinks, log *log.Logger, root string, path string) *symlinkResolver {
+	base := filepath.Join(root, path)
+
+	// the real path is only needed when following symlinks
+	if mode == SymlinksFollow {
+		if info, err := os.Lstat(base); err == nil && info.Mode()&os.ModeSymlink != 0 {
+			base = ""
+		} else if real, err := filepath.EvalSymlinks(base); err == nil {
+			base = real
+		}
+	}
+
+	return &symlinkResolver{
+		mode:     mode,
+		log:      log,
+		base:     base,
+		followed: make(map[string]bool),
+	}
+}
+
+// within reports whether path is dir or one of its descendants.
+func within(path string, dir string) bool {
+	return path == dir || strings.HasPrefix(path, dir+string(filepath.Separator))
+}
+
+// follow resolves the symlink at path, relative to root as relPath, returning the files it leads to: its target if it
+// is a file, or the files within its target if it is a directory.
+func (s *symlinkResolver) follow(root string, path string, relPath string) ([]*File, error) {
+	target, info, err := s.resolve(path, relPath)
+	if err != nil || info == nil {
+		return nil, err
+	} else if !info.IsDir() {
+		return []*File{{Path: path, RelPath: relPath, Info: info}}, nil
+	}
+
+	var files []*File
+
+	err = s.walk(root, target, relPath, func(path string, relPath string, info fs.FileInfo) error {
+		if !info.IsDir() && info.Mode()&os.ModeSymlink == 0 {
+			files = append(files, &File{Path: path, RelPath: relPath, Info: info})
+		}
+
+		return nil
+	})
+
+	return files, err
+}
@@ -22,6 +22,8 @@ const (
 	Stdin
 	Filesystem
 	Git
+	Jujutsu
+	Gitignore
 
 	BatchSize = 1024
 )
@@ -40,10 +42,23 @@ type File struct {
 	// FormattersSignature represents the sequence of formatters and their config that was applied to this file.
 	FormattersSignature []byte
 
+	// Formatters contains the names of the formatters that were applied to this file, in order.
+	Formatters []string
+
 	// CachedFormatSignature is the last FormatSignature generated for this file, retrieved from the cache.
 	CachedFormatSignature []byte
 
 	releaseFuncs []ReleaseFunc
+
+	// head caches the first bytes of the file's content, see Head.
+	head     []byte
+	headRead bool
+
+	// contentType caches the type detected from the file's content, see ContentType.
+	contentType *ContentType
+
+	// encoding caches the character encoding detected from the file's content, see Encoding.
+	encoding *Encoding
 }
 
 func formatSignature(formattersSig []byte, info fs.FileInfo) []byte {
@@ -135,6 +150,8 @@ func (f *File) String() string {
 }
 
 // Reader is an interface for reading files.
+// Reader traverses files, e.g. those tracked by a version control system.
+// Read fills files with the next of them, returning the number which were read, or io.EOF once they are exhausted.
 type Reader interface {
 	Read(ctx context.Context, files []*File) (n int, err error)
 	Close() error
@@ -192,6 +209,8 @@ func NewReader(
 	walkType Type,
 	root string,
 	path string,
+	since string,
+	symlinks Symlinks,
 	db *bolt.DB,
 	statz *stats.Stats,
 ) (Reader, error) {
@@ -200,24 +219,50 @@ func NewReader(
 		reader Reader
 	)
 
+	// only the git walker is able to determine which files have changed
+	if since != "" && walkType != Auto && walkType != Git {
+		return nil, fmt.Errorf("the %s walk type does not support the --since flag, use git instead", walkType.Name())
+	}
+
 	switch walkType {
 	case Auto:
+		if since != "" {
+			return NewReader(Git, root, path, since, symlinks, db, statz)
+		}
+
 		// for now, we keep it simple and try git first, filesystem second
-		reader, err = NewReader(Git, root, path, db, statz)
+		// if the tree root is a jujutsu repository, it is tried before git as it may be colocated with a git repository,
+		// in which case files which have yet to be added to the git index are still tracked by jujutsu
+		if info, statErr := os.Stat(filepath.Join(root, ".jj")); statErr == nil && info.IsDir() {
+			if reader, err = NewReader(Jujutsu, root, path, since, symlinks, db, statz); err == nil {
+				return reader, nil
+			}
+		}
+
+		reader, err = NewReader(Git, root, path, since, symlinks, db, statz)
 		if err != nil {
-			reader, err = NewReader(Filesystem, root, path, db, statz)
+			reader, err = NewReader(Filesystem, root, path, since, symlinks, db, statz)
 		}
 
 		return reader, err
 	case Stdin:
 		return nil, fmt.Errorf("stdin walk type is not supported")
 	case Filesystem:
-		reader = NewFilesystemReader(root, path, statz, BatchSize)
+		reader = NewFilesystemReader(root, path, symlinks, statz, BatchSize)
 	case Git:
-		reader, err = NewGitReader(root, path, statz)
+		reader, err = NewGitReader(root, path, since, symlinks, statz)
+	case Jujutsu:
+		reader, err = NewJujutsuReader(root, path, symlinks, statz)
+	case Gitignore:
+		reader = NewGitignoreReader(root, path, symlinks, statz, BatchSize)
 
 	default:
-		return nil, fmt.Errorf("unknown walk type: %v", walkType)
+		factory, ok := registered(walkType)
+		if !ok {
+			return nil, fmt.Errorf("unknown walk type: %v", walkType)
+		}
+
+		reader, err = factory(root, path, statz)
 	}
 
 	if err != nil {
@@ -238,12 +283,14 @@ func NewCompositeReader(
 	walkType Type,
 	root string,
 	paths []string,
+	since string,
+	symlinks Symlinks,
 	db *bolt.DB,
 	statz *stats.Stats,
 ) (Reader, error) {
 	// if not paths are provided we default to processing the tree root
 	if len(paths) == 0 {
-		return NewReader(walkType, root, "", db, statz)
+		return NewReader(walkType, root, "", since, symlinks, db, statz)
 	}
 
 	readers := make([]Reader, len(paths))
@@ -275,10 +322,10 @@ func NewCompositeReader(
 
 		if info.IsDir() {
 			// for directories, we honour the walk type as we traverse them
-			readers[idx], err = NewReader(walkType, root, relPath, db, statz)
+			readers[idx], err = NewReader(walkType, root, relPath, since, symlinks, db, statz)
 		} else {
 			// for files, we enforce a simple filesystem read
-			readers[idx], err = NewReader(Filesystem, root, relPath, db, statz)
+			readers[idx], err = NewReader(Filesystem, root, relPath, "", symlinks, db, statz)
 		}
 
 		if err != nil {